@@ -0,0 +1,135 @@
+// hd-idle - spin down idle hard disks
+// Copyright (C) 2018  Andoni del Olmo
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const controlTimeout = 10 * time.Second
+
+// PauseSpindowns pauses the spindowns of the hd-idle instance at the given
+// base URL, e.g. http://127.0.0.1:7000, for the given time. The instance must
+// run with --control. A base URL of unix:path, e.g. unix:/run/hd-idle.sock,
+// reaches an instance listening on a unix socket.
+func PauseSpindowns(host string, d time.Duration) (Pause, error) {
+	var pause Pause
+	err := send(host, http.MethodPost, "/pause", PauseRequest{For: d.String()}, &pause)
+	return pause, err
+}
+
+// ResumeSpindowns ends the pause of the hd-idle instance at the given base
+// URL.
+func ResumeSpindowns(host string) (Pause, error) {
+	var pause Pause
+	err := send(host, http.MethodDelete, "/pause", nil, &pause)
+	return pause, err
+}
+
+// SwitchProfile makes the named profile the active one of the hd-idle
+// instance at the given base URL, default for the configured idle times. The
+// instance must run with --control.
+func SwitchProfile(host, name string) (Profile, error) {
+	var profile Profile
+	err := send(host, http.MethodPut, "/profile", ProfileRequest{Name: name}, &profile)
+	return profile, err
+}
+
+// FetchProfile returns the active profile of the hd-idle instance at the
+// given base URL.
+func FetchProfile(host string) (Profile, error) {
+	var profile Profile
+	err := send(host, http.MethodGet, "/profile", nil, &profile)
+	return profile, err
+}
+
+// SpinUp spins a disk of the hd-idle instance at the given base URL up by
+// hand, holding it for its --manual-hold. The instance must run with
+// --control.
+func SpinUp(host, disk string) (Status, error) {
+	var status Status
+	err := send(host, http.MethodPost, "/spinup", SpinupRequest{Disk: disk}, &status)
+	return status, err
+}
+
+// PowerOnUsbPort powers a usb port on through the hd-idle instance at the
+// given base URL. The instance must run with --control.
+func PowerOnUsbPort(host, port string) (UsbPower, error) {
+	var power UsbPower
+	err := send(host, http.MethodPost, "/usb-power", UsbPowerRequest{Port: port}, &power)
+	return power, err
+}
+
+// Annotate starts an epoch on the hd-idle instance at the given base URL.
+// The instance must run with --control.
+func Annotate(host string, request AnnotationRequest) (Epochs, error) {
+	var epochs Epochs
+	err := send(host, http.MethodPost, "/epochs", request, &epochs)
+	return epochs, err
+}
+
+// FetchEpochs returns the epochs of the hd-idle instance at the given base
+// URL.
+func FetchEpochs(host string) (Epochs, error) {
+	var epochs Epochs
+	err := send(host, http.MethodGet, "/epochs", nil, &epochs)
+	return epochs, err
+}
+
+func send(host, method, path string, request, response interface{}) error {
+	var body []byte
+	if request != nil {
+		var err error
+		if body, err = json.Marshal(request); err != nil {
+			return err
+		}
+	}
+	client := &http.Client{Timeout: controlTimeout}
+	base := strings.TrimSuffix(host, "/")
+	if strings.HasPrefix(host, "unix:") {
+		socket := strings.TrimPrefix(host, "unix:")
+		client.Transport = &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				return (&net.Dialer{}).DialContext(ctx, "unix", socket)
+			},
+		}
+		base = "http://hd-idle"
+	}
+	r, err := http.NewRequest(method, base+path, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	r.Header.Set("Content-Type", "application/json")
+	resp, err := client.Do(r)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusCreated {
+		message, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("%s answered %s: %s", host, resp.Status, strings.TrimSpace(string(message)))
+	}
+	return json.NewDecoder(resp.Body).Decode(response)
+}
@@ -0,0 +1,115 @@
+// hd-idle - spin down idle hard disks
+// Copyright (C) 2018  Andoni del Olmo
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package api
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"time"
+)
+
+const day = 24 * time.Hour
+
+// Epoch returns the epoch with the given name.
+func (e Epochs) Epoch(name string) (Epoch, bool) {
+	for _, epoch := range e.Epochs {
+		if epoch.Name == name {
+			return epoch, true
+		}
+	}
+	return Epoch{}, false
+}
+
+// EpochRates are the activity of a disk during an epoch, normalized by its
+// length so epochs of different lengths compare.
+type EpochRates struct {
+	SpindownsPerDay float64
+	SpinupsPerDay   float64
+	SpunDownShare   float64 // of the epoch, from 0 to 1
+}
+
+// Rates returns the rates of the disk during the epoch, false if the disk
+// wasn't counted then.
+func (e Epoch) Rates(disk string) (EpochRates, bool) {
+	length := e.End.Sub(e.Start)
+	for _, d := range e.Disks {
+		if d.Name != disk || length <= 0 {
+			continue
+		}
+		days := float64(length) / float64(day)
+		return EpochRates{
+			SpindownsPerDay: float64(d.Spindowns) / days,
+			SpinupsPerDay:   float64(d.Spinups) / days,
+			SpunDownShare:   d.SpunDownSeconds / length.Seconds(),
+		}, true
+	}
+	return EpochRates{}, false
+}
+
+// CompareEpochs writes the rates of every disk in epoch a next to those in
+// epoch b.
+func CompareEpochs(out io.Writer, a, b Epoch) {
+	for _, e := range []Epoch{a, b} {
+		fmt.Fprintf(out, "epoch %s: %s to %s (%.1f days)", e.Name,
+			e.Start.Local().Format("2006-01-02 15:04"), e.End.Local().Format("2006-01-02 15:04"),
+			float64(e.End.Sub(e.Start))/float64(day))
+		switch {
+		case len(e.Disk) > 0:
+			fmt.Fprintf(out, " %s: %s\n", e.Disk, e.Note)
+		case len(e.Note) > 0:
+			fmt.Fprintf(out, " %s\n", e.Note)
+		default:
+			fmt.Fprintln(out)
+		}
+	}
+
+	fmt.Fprintf(out, "%-12s %-22s %-22s %s\n", "disk", "spindowns/day", "spinups/day", "spun down")
+	for _, disk := range epochDiskNames(a, b) {
+		ra, inA := a.Rates(disk)
+		rb, inB := b.Rates(disk)
+		fmt.Fprintf(out, "%-12s %-22s %-22s %s\n", disk,
+			compared(ra.SpindownsPerDay, inA, rb.SpindownsPerDay, inB, "%.1f"),
+			compared(ra.SpinupsPerDay, inA, rb.SpinupsPerDay, inB, "%.1f"),
+			compared(ra.SpunDownShare*100, inA, rb.SpunDownShare*100, inB, "%.0f%%"))
+	}
+}
+
+func compared(a float64, inA bool, b float64, inB bool, format string) string {
+	value := func(v float64, found bool) string {
+		if !found {
+			return "n/a"
+		}
+		return fmt.Sprintf(format, v)
+	}
+	return value(a, inA) + " -> " + value(b, inB)
+}
+
+func epochDiskNames(epochs ...Epoch) []string {
+	found := map[string]bool{}
+	var names []string
+	for _, e := range epochs {
+		for _, d := range e.Disks {
+			if !found[d.Name] {
+				found[d.Name] = true
+				names = append(names, d.Name)
+			}
+		}
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,51 @@
+// hd-idle - spin down idle hard disks
+// Copyright (C) 2018  Andoni del Olmo
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package api
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCompareEpochs(t *testing.T) {
+	start := time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC)
+	a := Epoch{Name: "0", Start: start, End: start.Add(2 * day), Disks: []EpochDisk{
+		{Name: "sda", Spindowns: 10, Spinups: 8, SpunDownSeconds: 86400},
+	}}
+	b := Epoch{Name: "1", Note: "changed idle from 10m to 20m", Disk: "sda", Start: a.End, End: a.End.Add(day), Disks: []EpochDisk{
+		{Name: "sda", Spindowns: 2, Spinups: 2, SpunDownSeconds: 64800},
+		{Name: "sdb", Spindowns: 1, Spinups: 1},
+	}}
+
+	if rates, _ := a.Rates("sda"); rates.SpindownsPerDay != 5 || rates.SpunDownShare != 0.5 {
+		t.Fatalf("Unexpected rates %+v", rates)
+	}
+
+	var out bytes.Buffer
+	CompareEpochs(&out, a, b)
+	for _, expected := range []string{
+		"sda: changed idle from 10m to 20m",
+		"sda          5.0 -> 2.0             4.0 -> 2.0             50% -> 75%",
+		"sdb          n/a -> 1.0",
+	} {
+		if !strings.Contains(out.String(), expected) {
+			t.Errorf("Expected %q in\n%s", expected, out.String())
+		}
+	}
+}
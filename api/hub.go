@@ -0,0 +1,169 @@
+// hd-idle - spin down idle hard disks
+// Copyright (C) 2018  Andoni del Olmo
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const hubTimeout = 5 * time.Second
+
+// HubStatus is the merged status of several hosts, served by the hub at
+// /status.
+type HubStatus struct {
+	SchemaVersion int          `json:"schema_version"`
+	Hosts         []HostStatus `json:"hosts"`
+}
+
+type HostStatus struct {
+	Host     string       `json:"host"`
+	Error    string       `json:"error,omitempty"`
+	PushedAt *time.Time   `json:"pushed_at,omitempty"` // set for agents pushing to the hub
+	Disks    []DiskStatus `json:"disks"`
+}
+
+// Hub collects the status of several hd-idle instances, either querying
+// them or receiving what agents push.
+type Hub struct {
+	hosts  []string
+	client *http.Client
+
+	mu     sync.Mutex
+	pushed map[string]HostStatus
+	events []Event
+}
+
+// NewHub creates a hub for the given base URLs, e.g. http://nas:7000.
+func NewHub(hosts []string) *Hub {
+	return &Hub{hosts: hosts, client: &http.Client{Timeout: hubTimeout}, pushed: map[string]HostStatus{}}
+}
+
+// Receive keeps the status and events pushed by an agent.
+func (h *Hub) Receive(push Push) error {
+	if push.SchemaVersion != SchemaVersion {
+		return fmt.Errorf("schema version %d, expected %d", push.SchemaVersion, SchemaVersion)
+	}
+	if len(push.Host) == 0 {
+		return fmt.Errorf("missing host")
+	}
+	now := time.Now()
+	if push.Disks == nil {
+		push.Disks = []DiskStatus{}
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.pushed[push.Host] = HostStatus{Host: push.Host, PushedAt: &now, Disks: push.Disks}
+	h.events = appendEvents(h.events, push.Events...)
+	return nil
+}
+
+// Events returns the events pushed by the agents, oldest first.
+func (h *Hub) Events() Events {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return Events{SchemaVersion: SchemaVersion, Events: append([]Event{}, h.events...)}
+}
+
+// Status queries all hosts in parallel and adds the last status pushed by
+// every agent. Unreachable hosts are reported with an error instead of
+// failing the whole status.
+func (h *Hub) Status() HubStatus {
+	status := HubStatus{SchemaVersion: SchemaVersion, Hosts: make([]HostStatus, len(h.hosts))}
+	var wg sync.WaitGroup
+	for i, host := range h.hosts {
+		wg.Add(1)
+		go func(i int, host string) {
+			defer wg.Done()
+			hostStatus := HostStatus{Host: host, Disks: []DiskStatus{}}
+			remote, err := h.fetch(host)
+			if err != nil {
+				hostStatus.Error = err.Error()
+			} else {
+				hostStatus.Disks = remote.Disks
+			}
+			status.Hosts[i] = hostStatus
+		}(i, host)
+	}
+	wg.Wait()
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	var agents []string
+	for host := range h.pushed {
+		agents = append(agents, host)
+	}
+	sort.Strings(agents)
+	for _, host := range agents {
+		status.Hosts = append(status.Hosts, h.pushed[host])
+	}
+	return status
+}
+
+func (h *Hub) fetch(host string) (Status, error) {
+	var status Status
+	resp, err := h.client.Get(strings.TrimSuffix(host, "/") + "/status")
+	if err != nil {
+		return status, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return status, fmt.Errorf("%s answered %s", host, resp.Status)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&status); err != nil {
+		return status, err
+	}
+	if status.SchemaVersion != SchemaVersion {
+		return status, fmt.Errorf("%s uses schema version %d, expected %d", host, status.SchemaVersion, SchemaVersion)
+	}
+	return status, nil
+}
+
+// NewHubHandler serves the merged status at /status, the pushed events at
+// /events and the JSON schemas at /schema. Agents POST to /push.
+func NewHubHandler(hub *Hub) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/status", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, hub.Status())
+	})
+	mux.HandleFunc("/events", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, hub.Events())
+	})
+	mux.HandleFunc("/push", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		var push Push
+		if err := json.NewDecoder(r.Body).Decode(&push); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := hub.Receive(push); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+	handleSchemas(mux)
+	return mux
+}
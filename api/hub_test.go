@@ -0,0 +1,48 @@
+// hd-idle - spin down idle hard disks
+// Copyright (C) 2018  Andoni del Olmo
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package api
+
+import (
+	"github.com/adelolmo/hd-idle/hdidle"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestHubStatus(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, NewStatus([]hdidle.DeviceStatus{{Name: "sda", CommandType: "scsi", IdleTime: time.Minute}}))
+	}))
+	defer server.Close()
+	down := httptest.NewServer(http.NotFoundHandler())
+	defer down.Close()
+
+	status := NewHub([]string{server.URL + "/", down.URL}).Status()
+
+	if len(status.Hosts) != 2 {
+		t.Fatalf("Expected 2 hosts but found %d", len(status.Hosts))
+	}
+	first := status.Hosts[0]
+	if first.Host != server.URL+"/" || len(first.Error) > 0 || len(first.Disks) != 1 || first.Disks[0].Name != "sda" {
+		t.Fatalf("Unexpected status %+v", first)
+	}
+	second := status.Hosts[1]
+	if len(second.Error) == 0 || len(second.Disks) != 0 {
+		t.Fatalf("Expected an error for %s but found %+v", down.URL, second)
+	}
+}
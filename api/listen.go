@@ -0,0 +1,84 @@
+// hd-idle - spin down idle hard disks
+// Copyright (C) 2018  Andoni del Olmo
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package api
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+// Listen opens the listeners of an address: host:port, [ipv6]:port with an
+// optional zone for link-local addresses, e.g. [fe80::1%eth0]:7000, or
+// interface:port to listen on every address of a network interface, e.g.
+// eth0:7000. [::]:port listens on IPv4 and IPv6. unix:path listens on a
+// unix socket whose clients NewPeerHandler can tell apart, e.g.
+// unix:/run/hd-idle.sock.
+func Listen(address string) ([]net.Listener, error) {
+	if strings.HasPrefix(address, "unix:") {
+		listener, err := listenUnix(strings.TrimPrefix(address, "unix:"))
+		if err != nil {
+			return nil, err
+		}
+		return []net.Listener{listener}, nil
+	}
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, err
+	}
+	iface, err := net.InterfaceByName(host)
+	if err != nil {
+		/* not an interface, a host name or an address */
+		listener, err := net.Listen("tcp", address)
+		if err != nil {
+			return nil, err
+		}
+		return []net.Listener{listener}, nil
+	}
+
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return nil, err
+	}
+	var listeners []net.Listener
+	for _, addr := range addrs {
+		ipNet, ok := addr.(*net.IPNet)
+		if !ok {
+			continue
+		}
+		ip := ipNet.IP.String()
+		if ipNet.IP.IsLinkLocalUnicast() && ipNet.IP.To4() == nil {
+			ip += "%" + iface.Name
+		}
+		listener, err := net.Listen("tcp", net.JoinHostPort(ip, port))
+		if err != nil {
+			closeAll(listeners)
+			return nil, err
+		}
+		listeners = append(listeners, listener)
+	}
+	if len(listeners) == 0 {
+		return nil, fmt.Errorf("interface %s has no address", iface.Name)
+	}
+	return listeners, nil
+}
+
+func closeAll(listeners []net.Listener) {
+	for _, listener := range listeners {
+		listener.Close()
+	}
+}
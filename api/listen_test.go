@@ -0,0 +1,55 @@
+// hd-idle - spin down idle hard disks
+// Copyright (C) 2018  Andoni del Olmo
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package api
+
+import (
+	"net"
+	"testing"
+)
+
+func TestListen(t *testing.T) {
+	listeners, err := Listen("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	closeAll(listeners)
+	if len(listeners) != 1 {
+		t.Fatalf("Expected 1 listener but found %d", len(listeners))
+	}
+
+	if _, err := Listen("127.0.0.1"); err == nil {
+		t.Fatal("Expected an error for an address without port")
+	}
+}
+
+func TestListenOnInterface(t *testing.T) {
+	iface, err := net.InterfaceByName("lo")
+	if err != nil {
+		t.Skip("no loopback interface")
+	}
+	listeners, err := Listen(iface.Name + ":0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer closeAll(listeners)
+	for _, listener := range listeners {
+		ip := listener.Addr().(*net.TCPAddr).IP
+		if !ip.IsLoopback() {
+			t.Fatalf("Expected a loopback address but found %s", ip)
+		}
+	}
+}
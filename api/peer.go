@@ -0,0 +1,185 @@
+// hd-idle - spin down idle hard disks
+// Copyright (C) 2018  Andoni del Olmo
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package api
+
+import (
+	"fmt"
+	"github.com/adelolmo/hd-idle/hdidle"
+	"net"
+	"net/http"
+	"os"
+	"os/user"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// PeerPolicy tells which clients of a unix socket may read the status and
+// which may make changes. An entry is a user name or uid, or a group name or
+// gid after @, e.g. root, 1000 or @wheel. An empty Read lets every client
+// read, an empty Control lets only root make changes.
+type PeerPolicy struct {
+	Read    []string
+	Control []string
+}
+
+// NewPeerHandler serves the clients of a unix socket opened by Listen: the
+// clients allowed to read get what NewHandler serves, those allowed to
+// control what NewControlHandler serves. Clients are told apart by the
+// credentials of their process (SO_PEERCRED).
+func NewPeerHandler(monitor *hdidle.Monitor, policy PeerPolicy) http.Handler {
+	mux := newMux(monitor)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		uid, gid, ok := parsePeerAddr(r.RemoteAddr)
+		if !ok {
+			http.Error(w, "unknown peer credentials", http.StatusForbidden)
+			return
+		}
+		allowed := policy.Control
+		if len(allowed) == 0 {
+			allowed = []string{"root"}
+		}
+		if r.Method == http.MethodGet || r.Method == http.MethodHead {
+			if len(policy.Read) == 0 {
+				mux.ServeHTTP(w, r)
+				return
+			}
+			allowed = append(append([]string{}, policy.Read...), allowed...)
+		}
+		if !peerAllowed(allowed, uid, gid) {
+			http.Error(w, fmt.Sprintf("uid %d is not allowed to %s %s", uid, r.Method, r.URL.Path), http.StatusForbidden)
+			return
+		}
+		mux.ServeHTTP(w, r)
+	})
+}
+
+/* unix:/run/hd-idle.sock */
+func listenUnix(path string) (net.Listener, error) {
+	if info, err := os.Lstat(path); err == nil && info.Mode()&os.ModeSocket != 0 {
+		/* left behind by an instance that didn't stop cleanly */
+		os.Remove(path)
+	}
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	/* everyone may connect, the handler decides what they may do */
+	if err := os.Chmod(path, 0666); err != nil {
+		listener.Close()
+		return nil, err
+	}
+	return peerListener{listener}, nil
+}
+
+// the remote address is the only thing of its connection an http.Request
+// carries, it tells the handler who is connected
+type peerListener struct {
+	net.Listener
+}
+
+func (l peerListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	addr := peerAddr{uid: -1, gid: -1}
+	if unixConn, ok := conn.(*net.UnixConn); ok {
+		if cred, err := peerCredentials(unixConn); err == nil {
+			addr = peerAddr{uid: int(cred.Uid), gid: int(cred.Gid)}
+		}
+	}
+	return peerConn{Conn: conn, addr: addr}, nil
+}
+
+type peerConn struct {
+	net.Conn
+	addr peerAddr
+}
+
+func (c peerConn) RemoteAddr() net.Addr {
+	return c.addr
+}
+
+type peerAddr struct {
+	uid, gid int
+}
+
+func (a peerAddr) Network() string {
+	return "unix"
+}
+
+func (a peerAddr) String() string {
+	return fmt.Sprintf("uid=%d,gid=%d", a.uid, a.gid)
+}
+
+func parsePeerAddr(s string) (int, int, bool) {
+	var uid, gid int
+	if _, err := fmt.Sscanf(s, "uid=%d,gid=%d", &uid, &gid); err != nil || uid < 0 || gid < 0 {
+		return 0, 0, false
+	}
+	return uid, gid, true
+}
+
+func peerCredentials(conn *net.UnixConn) (*syscall.Ucred, error) {
+	raw, err := conn.SyscallConn()
+	if err != nil {
+		return nil, err
+	}
+	var cred *syscall.Ucred
+	var credErr error
+	err = raw.Control(func(fd uintptr) {
+		cred, credErr = syscall.GetsockoptUcred(int(fd), syscall.SOL_SOCKET, syscall.SO_PEERCRED)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return cred, credErr
+}
+
+/* entries that don't resolve to a user or group never match */
+func peerAllowed(allowed []string, uid, gid int) bool {
+	account, _ := user.LookupId(strconv.Itoa(uid))
+	var groups []string
+	if account != nil {
+		groups, _ = account.GroupIds()
+	}
+	groups = append(groups, strconv.Itoa(gid))
+
+	for _, entry := range allowed {
+		if strings.HasPrefix(entry, "@") {
+			group := entry[1:]
+			if _, err := strconv.Atoi(group); err != nil {
+				found, err := user.LookupGroup(group)
+				if err != nil {
+					continue
+				}
+				group = found.Gid
+			}
+			for _, id := range groups {
+				if id == group {
+					return true
+				}
+			}
+			continue
+		}
+		if entry == strconv.Itoa(uid) || (account != nil && entry == account.Username) {
+			return true
+		}
+	}
+	return false
+}
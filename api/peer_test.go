@@ -0,0 +1,89 @@
+// hd-idle - spin down idle hard disks
+// Copyright (C) 2018  Andoni del Olmo
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package api
+
+import (
+	"github.com/adelolmo/hd-idle/hdidle"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPeerHandler(t *testing.T) {
+	dir, err := ioutil.TempDir("", "hd-idle-peer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	socket := "unix:" + filepath.Join(dir, "hd-idle.sock")
+	uid := strconv.Itoa(os.Getuid())
+
+	serve := func(policy PeerPolicy) func() {
+		monitor := hdidle.New(hdidle.NewConfig())
+		listeners, err := Listen(socket)
+		if err != nil {
+			t.Fatal(err)
+		}
+		go http.Serve(listeners[0], NewPeerHandler(monitor, policy))
+		return func() {
+			closeAll(listeners)
+			monitor.Stop()
+		}
+	}
+
+	stop := serve(PeerPolicy{Control: []string{uid}})
+	if _, err := FetchEpochs(socket); err != nil {
+		t.Fatalf("Expected everyone to read but found %s", err)
+	}
+	if _, err := PauseSpindowns(socket, time.Hour); err != nil {
+		t.Fatalf("Expected uid %s to pause but found %s", uid, err)
+	}
+	stop()
+
+	/* a socket left behind is replaced */
+	stop = serve(PeerPolicy{Read: []string{"@" + strconv.Itoa(os.Getgid())}, Control: []string{"-1"}})
+	defer stop()
+	if _, err := FetchEpochs(socket); err != nil {
+		t.Fatalf("Expected the group to read but found %s", err)
+	}
+	if _, err := PauseSpindowns(socket, time.Hour); err == nil || !strings.Contains(err.Error(), "403") {
+		t.Fatalf("Expected uid %s refused but found %v", uid, err)
+	}
+}
+
+func TestPeerAllowed(t *testing.T) {
+	if !peerAllowed([]string{"1000"}, 1000, 1000) {
+		t.Fatal("Expected the uid to match")
+	}
+	if peerAllowed([]string{"1000"}, 1001, 1000) {
+		t.Fatal("Expected another uid not to match")
+	}
+	if !peerAllowed([]string{"@1000"}, 1001, 1000) {
+		t.Fatal("Expected the gid to match")
+	}
+	if peerAllowed([]string{"@no-such-group", "no-such-user"}, 1001, 1000) {
+		t.Fatal("Expected unknown names not to match")
+	}
+	if _, _, ok := parsePeerAddr(peerAddr{uid: -1, gid: -1}.String()); ok {
+		t.Fatal("Expected unknown credentials refused")
+	}
+}
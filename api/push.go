@@ -0,0 +1,139 @@
+// hd-idle - spin down idle hard disks
+// Copyright (C) 2018  Andoni del Olmo
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/adelolmo/hd-idle/hdidle"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultPushInterval = time.Minute
+	maxPushBackoff      = 30 * time.Minute
+	maxPushEvents       = 1000
+	pushTimeout         = 10 * time.Second
+)
+
+// Push is what an agent POSTs to the /push endpoint of a hub.
+type Push struct {
+	SchemaVersion int          `json:"schema_version"`
+	Host          string       `json:"host"`
+	Disks         []DiskStatus `json:"disks"`
+	Events        []Event      `json:"events"`
+}
+
+// Pusher periodically POSTs the status of a monitor, and the events since
+// the last successful push, to a hub. It is also the sink collecting those
+// events.
+type Pusher struct {
+	url      string
+	host     string
+	interval time.Duration
+	client   *http.Client
+
+	mu     sync.Mutex
+	events []Event
+}
+
+func NewPusher(url, host string, interval time.Duration) *Pusher {
+	if interval <= 0 {
+		interval = DefaultPushInterval
+	}
+	return &Pusher{url: url, host: host, interval: interval, client: &http.Client{Timeout: pushTimeout}}
+}
+
+// Deliver keeps the event for the next push. The oldest events are dropped
+// when the hub has been unreachable for too long.
+func (p *Pusher) Deliver(event hdidle.Event) error {
+	e := NewEvent(event)
+	e.Host = p.host
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.events = appendEvents(p.events, e)
+	return nil
+}
+
+// Run pushes until stop is closed. Failed pushes are retried with an
+// exponential backoff, up to 30 minutes between attempts.
+func (p *Pusher) Run(monitor *hdidle.Monitor, stop <-chan struct{}) {
+	wait := p.interval
+	for {
+		select {
+		case <-stop:
+			return
+		case <-time.After(wait):
+		}
+		status := NewStatus(monitor.Status())
+		status.Pending = NewPending(monitor.Pending())
+		if err := p.push(status); err != nil {
+			wait *= 2
+			if wait > maxPushBackoff {
+				wait = maxPushBackoff
+			}
+			fmt.Printf("push to %s failed, retrying in %v: %s\n", p.url, wait, err)
+			continue
+		}
+		wait = p.interval
+	}
+}
+
+func (p *Pusher) push(status Status) error {
+	p.mu.Lock()
+	events := p.events
+	p.events = nil
+	p.mu.Unlock()
+
+	err := p.post(Push{SchemaVersion: SchemaVersion, Host: p.host, Disks: status.Disks, Events: events})
+	if err != nil {
+		p.mu.Lock()
+		p.events = appendEvents(events, p.events...)
+		p.mu.Unlock()
+	}
+	return err
+}
+
+func (p *Pusher) post(push Push) error {
+	if push.Events == nil {
+		push.Events = []Event{}
+	}
+	body, err := json.Marshal(push)
+	if err != nil {
+		return err
+	}
+	resp, err := p.client.Post(p.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("%s answered %s", p.url, resp.Status)
+	}
+	return nil
+}
+
+func appendEvents(events []Event, more ...Event) []Event {
+	events = append(events, more...)
+	if len(events) > maxPushEvents {
+		events = events[len(events)-maxPushEvents:]
+	}
+	return events
+}
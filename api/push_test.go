@@ -0,0 +1,81 @@
+// hd-idle - spin down idle hard disks
+// Copyright (C) 2018  Andoni del Olmo
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package api
+
+import (
+	"github.com/adelolmo/hd-idle/hdidle"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestPushToHub(t *testing.T) {
+	hub := NewHub(nil)
+	server := httptest.NewServer(NewHubHandler(hub))
+	defer server.Close()
+
+	pusher := NewPusher(server.URL+"/push", "nas", 0)
+	_ = pusher.Deliver(hdidle.Event{Type: hdidle.EventSpindown, Disk: "sda"})
+	if err := pusher.push(NewStatus([]hdidle.DeviceStatus{{Name: "sda", CommandType: "scsi"}})); err != nil {
+		t.Fatal(err)
+	}
+
+	status := hub.Status()
+	if len(status.Hosts) != 1 || status.Hosts[0].Host != "nas" || status.Hosts[0].PushedAt == nil ||
+		len(status.Hosts[0].Disks) != 1 {
+		t.Fatalf("Unexpected hub status %+v", status)
+	}
+	events := hub.Events().Events
+	if len(events) != 1 || events[0].Host != "nas" || events[0].Disk != "sda" {
+		t.Fatalf("Unexpected hub events %+v", events)
+	}
+	if len(pusher.events) != 0 {
+		t.Fatalf("Expected no pending events but found %d", len(pusher.events))
+	}
+}
+
+func TestPushKeepsEventsOnFailure(t *testing.T) {
+	server := httptest.NewServer(http.NotFoundHandler())
+	defer server.Close()
+
+	pusher := NewPusher(server.URL+"/push", "nas", 0)
+	_ = pusher.Deliver(hdidle.Event{Type: hdidle.EventSpinup, Disk: "sda"})
+	if err := pusher.push(NewStatus(nil)); err == nil {
+		t.Fatal("Expected an error for a 404 answer")
+	}
+	if len(pusher.events) != 1 {
+		t.Fatalf("Expected 1 pending event but found %d", len(pusher.events))
+	}
+}
+
+func TestPushEndsWithMonitor(t *testing.T) {
+	monitor := hdidle.New(hdidle.NewConfig())
+	pusher := NewPusher("http://127.0.0.1:1/push", "nas", time.Hour)
+	ended := make(chan struct{})
+	go func() {
+		pusher.Run(monitor, monitor.Stopping())
+		close(ended)
+	}()
+
+	monitor.Stop()
+	select {
+	case <-ended:
+	case <-time.After(time.Second):
+		t.Fatal("Expected the pusher to end once the monitor is stopped")
+	}
+}
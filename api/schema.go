@@ -0,0 +1,499 @@
+// hd-idle - spin down idle hard disks
+// Copyright (C) 2018  Andoni del Olmo
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package api
+
+/*
+JSON Schema (draft-07) documents of the output. Keep them in sync with the
+types in types.go, TestSchemasMatchTypes checks the property names.
+*/
+
+const statusSchema = `{
+  "$schema": "http://json-schema.org/draft-07/schema#",
+  "$id": "hd-idle/status/1",
+  "title": "hd-idle status",
+  "type": "object",
+  "required": ["schema_version", "disks"],
+  "properties": {
+    "schema_version": {"type": "integer", "const": 1},
+    "disks": {
+      "type": "array",
+      "items": {
+        "type": "object",
+        "required": ["name", "command_type", "idle_time_seconds", "spun_down", "consecutive_failures"],
+        "properties": {
+          "name": {"type": "string", "description": "kernel name of the disk, e.g. sda"},
+          "alias": {"type": "string"},
+          "command_type": {"type": "string", "enum": ["scsi", "ata"]},
+          "idle_time_seconds": {"type": "number"},
+          "spun_down": {"type": "boolean"},
+          "spin_down_at": {"type": "string", "format": "date-time"},
+          "spin_up_at": {"type": "string", "format": "date-time"},
+          "last_io_at": {"type": "string", "format": "date-time"},
+          "temperature_celsius": {"type": "number"},
+          "consecutive_failures": {"type": "integer", "description": "failed commands in a row"},
+          "quarantined_until": {"type": "string", "format": "date-time", "description": "no commands are sent to the disk until then"},
+          "smart_collected_at": {"type": "string", "format": "date-time"},
+          "wake_latency": {
+            "type": "object",
+            "description": "time from spin up to the first completed I/O",
+            "required": ["last_seconds", "last_estimated", "average_seconds", "max_seconds", "wakes"],
+            "properties": {
+              "last_seconds": {"type": "number"},
+              "last_estimated": {"type": "boolean", "description": "the last wake was caused by an I/O, the latency is an upper bound"},
+              "average_seconds": {"type": "number"},
+              "max_seconds": {"type": "number"},
+              "wakes": {"type": "integer"}
+            }
+          },
+          "statistics": {
+            "type": "object",
+            "description": "long-term counters, kept across restarts with --checkpoint-interval",
+            "required": ["since", "spindown_attempts", "spindowns", "spindowns_verified", "spinups", "spun_down_seconds"],
+            "properties": {
+              "since": {"type": "string", "format": "date-time", "description": "start of the counting"},
+              "spindown_attempts": {"type": "integer", "description": "stop commands sent"},
+              "spindowns": {"type": "integer", "description": "stop commands the disk accepted"},
+              "spindowns_verified": {"type": "integer", "description": "spindowns confirmed by the disk reporting standby or by the power meter"},
+              "spinups": {"type": "integer"},
+              "spun_down_seconds": {"type": "number"}
+            }
+          },
+          "links": {"type": "array", "items": {"type": "string"}, "description": "persistent names of the disk and its partitions, e.g. /dev/disk/by-id/ata-WDC_WD40EFRX-68N32N0_WD-WCC7K1234567"},
+          "uuids": {"type": "array", "items": {"type": "string"}, "description": "uuids of the filesystems on the disk"},
+          "spindown_unsupported": {"type": "string", "description": "why hd-idle gave up spinning the disk down"},
+          "inherits": {"type": "string", "description": "persistent name of the replaced disk whose configuration the disk inherited"},
+          "media": {"type": "string", "enum": ["hdd", "ssd", "sshd", "smr", "flash"], "description": "kind of disk, sshd for hybrid drives, smr for shingled drives, flash for removable flash media"},
+          "namespace": {"type": "string", "description": "group of disks API tokens can be limited to, e.g. media"},
+          "tags": {"type": "object", "additionalProperties": {"type": "string"}, "description": "tags of the user on the disk, e.g. location: rack2"}
+        }
+      }
+    },
+    "pending": {
+      "type": "array",
+      "description": "configured disks that are not plugged in",
+      "items": {
+        "type": "object",
+        "required": ["name", "since"],
+        "properties": {
+          "name": {"type": "string", "description": "name given with -a, e.g. /dev/disk/by-label/offsite"},
+          "alias": {"type": "string"},
+          "namespace": {"type": "string"},
+          "tags": {"type": "object", "additionalProperties": {"type": "string"}},
+          "since": {"type": "string", "format": "date-time"}
+        }
+      }
+    },
+    "self": {
+      "type": "object",
+      "description": "resource usage of hd-idle itself after the last cycle, with --self-metrics",
+      "required": ["measured_at", "cycle_seconds", "cycle_cpu_seconds", "cpu_seconds", "goroutines", "heap_bytes", "gc_runs", "gc_pause_seconds"],
+      "properties": {
+        "measured_at": {"type": "string", "format": "date-time"},
+        "cycle_seconds": {"type": "number", "description": "wall time of the last cycle"},
+        "cycle_cpu_seconds": {"type": "number", "description": "user and system time spent by the process during the last cycle"},
+        "cpu_seconds": {"type": "number", "description": "user and system time since the start"},
+        "goroutines": {"type": "integer"},
+        "rss_bytes": {"type": "integer", "description": "resident set size, missing if unknown"},
+        "heap_bytes": {"type": "integer", "description": "allocated heap objects"},
+        "gc_runs": {"type": "integer"},
+        "gc_pause_seconds": {"type": "number", "description": "total stop the world time of the garbage collections"}
+      }
+    }
+  }
+}`
+
+const eventSchema = `{
+  "$schema": "http://json-schema.org/draft-07/schema#",
+  "$id": "hd-idle/event/1",
+  "title": "hd-idle event",
+  "type": "object",
+  "required": ["schema_version", "type", "disk", "time"],
+  "properties": {
+    "schema_version": {"type": "integer", "const": 1},
+    "host": {"type": "string", "description": "set by agents pushing to a hub"},
+    "type": {
+      "type": "string",
+      "enum": ["spindown", "spindown_failed", "spindown_deferred", "spinup", "sleep_reset", "usb_power_off",
+               "device_stuck", "device_recovered", "loop_stuck", "quarantined", "wake_storm", "spindown_unsupported", "disk_replaced",
+               "paused", "resumed", "disk_plugged", "safe_to_remove", "spindown_unverified", "profile_switched",
+               "daily_report"]
+    },
+    "disk": {"type": "string", "description": "empty for events not about a disk, e.g. loop_stuck or paused"},
+    "time": {"type": "string", "format": "date-time"},
+    "code": {"type": "string", "description": "machine-stable reason, e.g. EIO for spindown_failed or USB_HUB_BUSY for spindown_deferred"},
+    "message": {"type": "string", "description": "for humans, may change between versions"},
+    "tags": {"type": "object", "additionalProperties": {"type": "string"}, "description": "tags of the user on the disk"}
+  }
+}`
+
+const metricLabelsSchema = `{
+  "$schema": "http://json-schema.org/draft-07/schema#",
+  "$id": "hd-idle/metric_labels/1",
+  "title": "hd-idle per disk metric labels",
+  "type": "object",
+  "required": ["disk"],
+  "properties": {
+    "disk": {"type": "string"},
+    "alias": {"type": "string"},
+    "command_type": {"type": "string", "enum": ["scsi", "ata"]}
+  },
+  "patternProperties": {
+    "^tag_[a-z][a-z0-9_]*$": {"type": "string", "description": "a tag of the disk, e.g. tag_location for location=rack2"}
+  },
+  "additionalProperties": false
+}`
+
+const smartSchema = `{
+  "$schema": "http://json-schema.org/draft-07/schema#",
+  "$id": "hd-idle/smart/1",
+  "title": "hd-idle SMART data",
+  "type": "object",
+  "required": ["schema_version", "disks"],
+  "properties": {
+    "schema_version": {"type": "integer", "const": 1},
+    "disks": {
+      "type": "array",
+      "items": {
+        "type": "object",
+        "required": ["name", "collected_at", "attributes"],
+        "properties": {
+          "name": {"type": "string"},
+          "collected_at": {"type": "string", "format": "date-time", "description": "time of the last attempt"},
+          "error": {"type": "string", "description": "why the last attempt failed, attributes are from an earlier one"},
+          "attributes": {
+            "type": "array",
+            "items": {
+              "type": "object",
+              "required": ["id", "current", "worst", "raw"],
+              "properties": {
+                "id": {"type": "integer"},
+                "name": {"type": "string", "description": "as named by smartctl"},
+                "current": {"type": "integer"},
+                "worst": {"type": "integer"},
+                "raw": {"type": "integer"}
+              }
+            }
+          }
+        }
+      }
+    }
+  }
+}`
+
+const adviceSchema = `{
+  "$schema": "http://json-schema.org/draft-07/schema#",
+  "$id": "hd-idle/advice/1",
+  "title": "hd-idle wake up advice",
+  "type": "object",
+  "required": ["schema_version", "timers"],
+  "properties": {
+    "schema_version": {"type": "integer", "const": 1},
+    "timers": {
+      "type": "array",
+      "description": "most frequent first",
+      "items": {
+        "type": "object",
+        "required": ["timer", "on_calendar", "disks", "wakes", "last_wake_at", "suggestion"],
+        "properties": {
+          "timer": {"type": "string", "description": "systemd timer unit, e.g. mlocate.timer"},
+          "description": {"type": "string"},
+          "on_calendar": {"type": "array", "items": {"type": "string"}},
+          "disks": {"type": "array", "items": {"type": "string"}},
+          "wakes": {"type": "integer", "description": "disk wake ups shortly after the timer triggered"},
+          "last_wake_at": {"type": "string", "format": "date-time"},
+          "suggestion": {"type": "string"}
+        }
+      }
+    }
+  }
+}`
+
+const sinksSchema = `{
+  "$schema": "http://json-schema.org/draft-07/schema#",
+  "$id": "hd-idle/sinks/1",
+  "title": "hd-idle event sinks",
+  "type": "object",
+  "required": ["schema_version", "sinks"],
+  "properties": {
+    "schema_version": {"type": "integer", "const": 1},
+    "sinks": {
+      "type": "array",
+      "items": {
+        "type": "object",
+        "required": ["name", "queued", "delivered", "failed", "dropped"],
+        "properties": {
+          "name": {"type": "string"},
+          "queued": {"type": "integer", "description": "events waiting for delivery"},
+          "delivered": {"type": "integer"},
+          "failed": {"type": "integer"},
+          "dropped": {"type": "integer", "description": "oldest events discarded because the queue was full"},
+          "last_error": {"type": "string"},
+          "last_error_at": {"type": "string", "format": "date-time"}
+        }
+      }
+    }
+  }
+}`
+
+const hubStatusSchema = `{
+  "$schema": "http://json-schema.org/draft-07/schema#",
+  "$id": "hd-idle/hub_status/1",
+  "title": "hd-idle hub status",
+  "type": "object",
+  "required": ["schema_version", "hosts"],
+  "properties": {
+    "schema_version": {"type": "integer", "const": 1},
+    "hosts": {
+      "type": "array",
+      "items": {
+        "type": "object",
+        "required": ["host", "disks"],
+        "properties": {
+          "host": {"type": "string"},
+          "error": {"type": "string", "description": "why the host could not be queried"},
+          "pushed_at": {"type": "string", "format": "date-time", "description": "set for agents pushing to the hub"},
+          "disks": {"type": "array", "items": {"$ref": "/schema/status#/properties/disks/items"}}
+        }
+      }
+    }
+  }
+}`
+
+const eventsSchema = `{
+  "$schema": "http://json-schema.org/draft-07/schema#",
+  "$id": "hd-idle/events/1",
+  "title": "hd-idle events",
+  "type": "object",
+  "required": ["schema_version", "events"],
+  "properties": {
+    "schema_version": {"type": "integer", "const": 1},
+    "events": {"type": "array", "items": {"$ref": "/schema/event"}}
+  }
+}`
+
+const pushSchema = `{
+  "$schema": "http://json-schema.org/draft-07/schema#",
+  "$id": "hd-idle/push/1",
+  "title": "hd-idle agent push",
+  "type": "object",
+  "required": ["schema_version", "host", "disks", "events"],
+  "properties": {
+    "schema_version": {"type": "integer", "const": 1},
+    "host": {"type": "string"},
+    "disks": {"type": "array", "items": {"$ref": "/schema/status#/properties/disks/items"}},
+    "events": {"type": "array", "items": {"$ref": "/schema/event"}}
+  }
+}`
+
+const logSchema = `{
+  "$schema": "http://json-schema.org/draft-07/schema#",
+  "$id": "hd-idle/log/1",
+  "title": "hd-idle log file",
+  "type": "object",
+  "required": ["file"],
+  "properties": {
+    "schema_version": {"type": "integer", "const": 1},
+    "file": {"type": "string", "description": "empty when not logging to a file"}
+  }
+}`
+
+const sinkRequestSchema = `{
+  "$schema": "http://json-schema.org/draft-07/schema#",
+  "$id": "hd-idle/sink_request/1",
+  "title": "hd-idle sink to add",
+  "type": "object",
+  "required": ["webhook"],
+  "properties": {
+    "webhook": {"type": "string", "format": "uri"}
+  }
+}`
+
+const pauseSchema = `{
+  "$schema": "http://json-schema.org/draft-07/schema#",
+  "$id": "hd-idle/pause/1",
+  "title": "hd-idle pause of the spindowns",
+  "type": "object",
+  "required": ["schema_version"],
+  "properties": {
+    "schema_version": {"type": "integer", "const": 1},
+    "paused_until": {"type": "string", "format": "date-time", "description": "absent when spindowns aren't paused"}
+  }
+}`
+
+const pauseRequestSchema = `{
+  "$schema": "http://json-schema.org/draft-07/schema#",
+  "$id": "hd-idle/pause_request/1",
+  "title": "hd-idle pause to start",
+  "type": "object",
+  "required": ["for"],
+  "properties": {
+    "for": {"type": "string", "description": "duration of the pause, e.g. 2h or 90m"}
+  }
+}`
+
+const profileSchema = `{
+  "$schema": "http://json-schema.org/draft-07/schema#",
+  "$id": "hd-idle/profile/1",
+  "title": "hd-idle active profile of idle times",
+  "type": "object",
+  "required": ["schema_version", "active", "profiles"],
+  "properties": {
+    "schema_version": {"type": "integer", "const": 1},
+    "active": {"type": "string", "description": "default when the configured idle times apply"},
+    "profiles": {"type": "array", "items": {"type": "string"}}
+  }
+}`
+
+const profileRequestSchema = `{
+  "$schema": "http://json-schema.org/draft-07/schema#",
+  "$id": "hd-idle/profile_request/1",
+  "title": "hd-idle profile to switch to",
+  "type": "object",
+  "required": ["name"],
+  "properties": {
+    "name": {"type": "string", "description": "a profile of the configuration, or default"}
+  }
+}`
+
+const spinupRequestSchema = `{
+  "$schema": "http://json-schema.org/draft-07/schema#",
+  "$id": "hd-idle/spinup_request/1",
+  "title": "hd-idle disk to spin up by hand",
+  "type": "object",
+  "required": ["disk"],
+  "properties": {
+    "disk": {"type": "string", "description": "name, alias or /dev/disk link of a monitored disk"}
+  }
+}`
+
+const usbPowerRequestSchema = `{
+  "$schema": "http://json-schema.org/draft-07/schema#",
+  "$id": "hd-idle/usb_power_request/1",
+  "title": "hd-idle usb port to power on",
+  "type": "object",
+  "required": ["port"],
+  "properties": {
+    "port": {"type": "string", "pattern": "^[0-9]+-[0-9]+(\\.[0-9]+)*$", "description": "e.g. 1-1.2, as logged when it was powered off"}
+  }
+}`
+
+const usbPowerSchema = `{
+  "$schema": "http://json-schema.org/draft-07/schema#",
+  "$id": "hd-idle/usb_power/1",
+  "title": "hd-idle usb port powered on",
+  "type": "object",
+  "required": ["schema_version", "port", "on"],
+  "properties": {
+    "schema_version": {"type": "integer", "const": 1},
+    "port": {"type": "string"},
+    "on": {"type": "boolean"}
+  }
+}`
+
+const epochsSchema = `{
+  "$schema": "http://json-schema.org/draft-07/schema#",
+  "$id": "hd-idle/epochs/1",
+  "title": "hd-idle history split by annotations",
+  "type": "object",
+  "required": ["schema_version", "epochs"],
+  "properties": {
+    "schema_version": {"type": "integer", "const": 1},
+    "epochs": {
+      "type": "array",
+      "items": {
+        "type": "object",
+        "required": ["name", "start", "end", "disks"],
+        "properties": {
+          "name": {"type": "string", "description": "0 for the period before the first annotation"},
+          "note": {"type": "string"},
+          "disk": {"type": "string", "description": "the disk the note is about"},
+          "start": {"type": "string", "format": "date-time"},
+          "end": {"type": "string", "format": "date-time", "description": "now for the current epoch"},
+          "disks": {
+            "type": "array",
+            "items": {
+              "type": "object",
+              "required": ["name", "spindown_attempts", "spindowns", "spindowns_verified", "spinups", "spun_down_seconds"],
+              "properties": {
+                "name": {"type": "string"},
+                "spindown_attempts": {"type": "integer"},
+                "spindowns": {"type": "integer"},
+                "spindowns_verified": {"type": "integer"},
+                "spinups": {"type": "integer"},
+                "spun_down_seconds": {"type": "number"}
+              }
+            }
+          }
+        }
+      }
+    }
+  }
+}`
+
+const annotationRequestSchema = `{
+  "$schema": "http://json-schema.org/draft-07/schema#",
+  "$id": "hd-idle/annotation_request/1",
+  "title": "hd-idle annotation starting an epoch",
+  "type": "object",
+  "required": ["note"],
+  "properties": {
+    "name": {"type": "string", "description": "defaults to the number of the epoch"},
+    "note": {"type": "string"},
+    "disk": {"type": "string"}
+  }
+}`
+
+const commandErrorSchema = `{
+  "$schema": "http://json-schema.org/draft-07/schema#",
+  "$id": "hd-idle/command_error/1",
+  "title": "hd-idle failure of a one-shot command",
+  "type": "object",
+  "required": ["schema_version", "command", "failure", "exit_code", "message"],
+  "properties": {
+    "schema_version": {"type": "integer", "const": 1},
+    "command": {"type": "string", "enum": ["spindown", "spinup", "check", "identify", "eject"]},
+    "disk": {"type": "string"},
+    "failure": {"type": "string", "enum": ["usage", "no_disk", "permission", "unsupported", "busy", "command", "still_spinning"]},
+    "exit_code": {"type": "integer"},
+    "message": {"type": "string"}
+  }
+}`
+
+// Schemas maps the name of every output to its JSON Schema.
+var Schemas = map[string]string{
+	"status":             statusSchema,
+	"event":              eventSchema,
+	"metric_labels":      metricLabelsSchema,
+	"sinks":              sinksSchema,
+	"smart":              smartSchema,
+	"advice":             adviceSchema,
+	"hub_status":         hubStatusSchema,
+	"events":             eventsSchema,
+	"push":               pushSchema,
+	"log":                logSchema,
+	"sink_request":       sinkRequestSchema,
+	"pause":              pauseSchema,
+	"pause_request":      pauseRequestSchema,
+	"profile":            profileSchema,
+	"profile_request":    profileRequestSchema,
+	"spinup_request":     spinupRequestSchema,
+	"usb_power_request":  usbPowerRequestSchema,
+	"usb_power":          usbPowerSchema,
+	"epochs":             epochsSchema,
+	"annotation_request": annotationRequestSchema,
+	"command_error":      commandErrorSchema,
+}
@@ -0,0 +1,232 @@
+// hd-idle - spin down idle hard disks
+// Copyright (C) 2018  Andoni del Olmo
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package api
+
+import (
+	"encoding/json"
+	"github.com/adelolmo/hd-idle/hdidle"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+type schemaDoc struct {
+	Properties map[string]struct {
+		Items struct {
+			Properties map[string]interface{} `json:"properties"`
+		} `json:"items"`
+		Properties map[string]interface{} `json:"properties"`
+	} `json:"properties"`
+}
+
+func TestSchemasMatchTypes(t *testing.T) {
+	status := parseSchema(t, "status")
+	assertProperties(t, "status", status.Properties, Status{})
+	assertProperties(t, "status disks", status.Properties["disks"].Items.Properties, DiskStatus{})
+	assertProperties(t, "status pending", status.Properties["pending"].Items.Properties, PendingDisk{})
+	assertProperties(t, "status self", status.Properties["self"].Properties, Self{})
+	assertProperties(t, "status disks statistics",
+		nested(status.Properties["disks"].Items.Properties["statistics"], "properties"), Statistics{})
+
+	event := parseSchema(t, "event")
+	assertProperties(t, "event", event.Properties, Event{})
+
+	sinks := parseSchema(t, "sinks")
+	assertProperties(t, "sinks", sinks.Properties, Sinks{})
+	assertProperties(t, "sinks sinks", sinks.Properties["sinks"].Items.Properties, SinkStats{})
+
+	smart := parseSchema(t, "smart")
+	assertProperties(t, "smart", smart.Properties, Smart{})
+	assertProperties(t, "smart disks", smart.Properties["disks"].Items.Properties, DiskSmart{})
+
+	advice := parseSchema(t, "advice")
+	assertProperties(t, "advice", advice.Properties, Advice{})
+	assertProperties(t, "advice timers", advice.Properties["timers"].Items.Properties, TimerAdvice{})
+
+	hub := parseSchema(t, "hub_status")
+	assertProperties(t, "hub_status", hub.Properties, HubStatus{})
+	assertProperties(t, "hub_status hosts", hub.Properties["hosts"].Items.Properties, HostStatus{})
+
+	events := parseSchema(t, "events")
+	assertProperties(t, "events", events.Properties, Events{})
+
+	push := parseSchema(t, "push")
+	assertProperties(t, "push", push.Properties, Push{})
+
+	log := parseSchema(t, "log")
+	assertProperties(t, "log", log.Properties, Log{})
+
+	sinkRequest := parseSchema(t, "sink_request")
+	assertProperties(t, "sink_request", sinkRequest.Properties, SinkRequest{})
+
+	pause := parseSchema(t, "pause")
+	assertProperties(t, "pause", pause.Properties, Pause{})
+
+	pauseRequest := parseSchema(t, "pause_request")
+	assertProperties(t, "pause_request", pauseRequest.Properties, PauseRequest{})
+
+	profile := parseSchema(t, "profile")
+	assertProperties(t, "profile", profile.Properties, Profile{})
+
+	profileRequest := parseSchema(t, "profile_request")
+	assertProperties(t, "profile_request", profileRequest.Properties, ProfileRequest{})
+
+	spinupRequest := parseSchema(t, "spinup_request")
+	assertProperties(t, "spinup_request", spinupRequest.Properties, SpinupRequest{})
+
+	usbPowerRequest := parseSchema(t, "usb_power_request")
+	assertProperties(t, "usb_power_request", usbPowerRequest.Properties, UsbPowerRequest{})
+
+	usbPower := parseSchema(t, "usb_power")
+	assertProperties(t, "usb_power", usbPower.Properties, UsbPower{})
+
+	epochs := parseSchema(t, "epochs")
+	assertProperties(t, "epochs", epochs.Properties, Epochs{})
+	assertProperties(t, "epochs epochs", epochs.Properties["epochs"].Items.Properties, Epoch{})
+	assertProperties(t, "epochs epochs disks",
+		nested(epochs.Properties["epochs"].Items.Properties["disks"], "items", "properties"), EpochDisk{})
+
+	annotation := parseSchema(t, "annotation_request")
+	assertProperties(t, "annotation_request", annotation.Properties, AnnotationRequest{})
+
+	commandError := parseSchema(t, "command_error")
+	assertProperties(t, "command_error", commandError.Properties, CommandError{})
+
+	labels := parseSchema(t, "metric_labels")
+	for _, label := range MetricLabels {
+		if _, found := labels.Properties[label]; !found {
+			t.Errorf("Expected label %s in metric_labels schema", label)
+		}
+	}
+	if len(labels.Properties) != len(MetricLabels) {
+		t.Errorf("Expected %d labels in metric_labels schema but found %d", len(MetricLabels), len(labels.Properties))
+	}
+	if !strings.Contains(Schemas["metric_labels"], `"^`+LabelTagPrefix) {
+		t.Errorf("Expected the %s labels in metric_labels schema", LabelTagPrefix)
+	}
+	disk := DiskStatus{Name: "sdb", CommandType: "ata", Tags: map[string]string{"location": "rack2"}}
+	if l := disk.Labels(); len(l) != 3 || l[LabelDisk] != "sdb" || l[LabelTagPrefix+"location"] != "rack2" {
+		t.Errorf("Unexpected labels %v", l)
+	}
+}
+
+func TestNewStatus(t *testing.T) {
+	celsius := 35.0
+	spinDownAt := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	status := NewStatus([]hdidle.DeviceStatus{
+		{Name: "sda", CommandType: "ata", IdleTime: 10 * time.Minute, SpunDown: true, SpinDownAt: spinDownAt, Temperature: &celsius},
+	})
+
+	data, err := json.Marshal(status)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `{"schema_version":1,"disks":[{"name":"sda","command_type":"ata","idle_time_seconds":600,` +
+		`"spun_down":true,"spin_down_at":"2020-01-02T03:04:05Z","temperature_celsius":35,"consecutive_failures":0}]}`
+	if string(data) != expected {
+		t.Fatalf("Expected %s but found %s", expected, data)
+	}
+}
+
+func TestFilterDisks(t *testing.T) {
+	disks := NewStatus([]hdidle.DeviceStatus{
+		{Name: "sda", Links: []string{"/dev/disk/by-id/ata-WDC_WD40EFRX", "/dev/disk/by-uuid/0b4e-1f2a"}},
+		{Name: "sdb", Alias: "backup"},
+	}).Disks
+	if uuids := disks[0].Uuids; len(uuids) != 1 || uuids[0] != "0b4e-1f2a" {
+		t.Fatalf("Expected uuid 0b4e-1f2a but found %v", uuids)
+	}
+
+	for id, expected := range map[string]string{
+		"sda":                              "sda",
+		"backup":                           "sdb",
+		"0b4e-1f2a":                        "sda",
+		"ata-WDC_WD40EFRX":                 "sda",
+		"/dev/disk/by-id/ata-WDC_WD40EFRX": "sda",
+	} {
+		matching := filterDisks(disks, id)
+		if len(matching) != 1 || matching[0].Name != expected {
+			t.Errorf("Expected %s to address %s but found %v", id, expected, matching)
+		}
+	}
+	if matching := filterDisks(disks, "sdc"); len(matching) != 0 {
+		t.Errorf("Expected no disk but found %v", matching)
+	}
+}
+
+func TestSchemaEndpoint(t *testing.T) {
+	handler := NewHandler(hdidle.New(hdidle.NewConfig()))
+
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest("GET", "/schema", nil))
+	var index schemaIndex
+	if err := json.Unmarshal(recorder.Body.Bytes(), &index); err != nil {
+		t.Fatal(err)
+	}
+	if index.SchemaVersion != SchemaVersion || index.Schemas["status"] != "/schema/status" {
+		t.Fatalf("Unexpected schema index %s", recorder.Body.String())
+	}
+
+	recorder = httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest("GET", "/schema/event", nil))
+	if recorder.Body.String() != eventSchema {
+		t.Fatalf("Expected the event schema but found %s", recorder.Body.String())
+	}
+
+	recorder = httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest("GET", "/schema/unknown", nil))
+	if recorder.Code != 404 {
+		t.Fatalf("Expected 404 but found %d", recorder.Code)
+	}
+}
+
+func parseSchema(t *testing.T, name string) schemaDoc {
+	var doc schemaDoc
+	if err := json.Unmarshal([]byte(Schemas[name]), &doc); err != nil {
+		t.Fatalf("Cannot parse %s schema: %s", name, err)
+	}
+	return doc
+}
+
+/* the part of a schema below the given keys */
+func nested(schema interface{}, keys ...string) interface{} {
+	for _, key := range keys {
+		schema = schema.(map[string]interface{})[key]
+	}
+	return schema
+}
+
+func assertProperties(t *testing.T, name string, properties interface{}, v interface{}) {
+	keys := reflect.ValueOf(properties).MapKeys()
+	found := map[string]bool{}
+	for _, key := range keys {
+		found[key.String()] = true
+	}
+	typ := reflect.TypeOf(v)
+	for i := 0; i < typ.NumField(); i++ {
+		tag := strings.Split(typ.Field(i).Tag.Get("json"), ",")[0]
+		if !found[tag] {
+			t.Errorf("Expected property %s in %s schema", tag, name)
+		}
+		delete(found, tag)
+	}
+	for key := range found {
+		t.Errorf("Unexpected property %s in %s schema", key, name)
+	}
+}
@@ -0,0 +1,283 @@
+// hd-idle - spin down idle hard disks
+// Copyright (C) 2018  Andoni del Olmo
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package api
+
+import (
+	"encoding/json"
+	"github.com/adelolmo/hd-idle/hdidle"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+type schemaIndex struct {
+	SchemaVersion int               `json:"schema_version"`
+	Schemas       map[string]string `json:"schemas"`
+}
+
+// NewHandler serves the status of the monitor at /status, of a single disk
+// at /status?disk=<name, alias, /dev/disk link or filesystem uuid>, the SMART data at
+// /smart, the timers waking disks up at /advice, the delivery state of its
+// sinks at /sinks, its log file at /log, whether spindowns are paused at
+// /pause, the active profile at /profile, the history split by annotations
+// at /epochs and the JSON schemas at /schema.
+func NewHandler(monitor *hdidle.Monitor) http.Handler {
+	mux := newMux(monitor)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			http.Error(w, "changes need hd-idle to run with --control", http.StatusMethodNotAllowed)
+			return
+		}
+		mux.ServeHTTP(w, r)
+	})
+}
+
+// NewControlHandler serves what NewHandler does and lets clients change the
+// sinks and the log file of the running monitor: POST a SinkRequest to
+// /sinks to add a webhook, DELETE /sinks?name=<name> to remove a sink, PUT
+// a Log to /log to switch the log file, POST a PauseRequest to /pause to pause
+// the spindowns for a while, DELETE /pause to resume them, PUT a
+// ProfileRequest to /profile to switch the profile, POST a SpinupRequest to
+// /spinup to spin a disk up by hand, POST a UsbPowerRequest to /usb-power to
+// power a usb port on and POST an AnnotationRequest to /epochs to start an
+// epoch.
+func NewControlHandler(monitor *hdidle.Monitor) http.Handler {
+	return newMux(monitor)
+}
+
+func newMux(monitor *hdidle.Monitor) *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/status", func(w http.ResponseWriter, r *http.Request) {
+		status := NewStatus(monitor.Status())
+		status.Pending = NewPending(monitor.Pending())
+		status.Self = NewSelf(monitor.SelfMetrics())
+		if id := r.URL.Query().Get("disk"); len(id) > 0 {
+			status.Pending = nil
+			status.Self = nil
+			status.Disks = filterDisks(status.Disks, id)
+			if len(status.Disks) == 0 {
+				http.NotFound(w, r)
+				return
+			}
+		}
+		writeJSON(w, status)
+	})
+	mux.HandleFunc("/smart", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, NewSmart(monitor.Status()))
+	})
+	mux.HandleFunc("/advice", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, NewAdvice(monitor.Advice()))
+	})
+	mux.HandleFunc("/sinks", func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodPost:
+			addSink(monitor, w, r)
+		case r.Method == http.MethodDelete:
+			if !monitor.RemoveSink(r.URL.Query().Get("name")) {
+				http.NotFound(w, r)
+				return
+			}
+			writeJSON(w, NewSinks(monitor.SinkStats()))
+		default:
+			writeJSON(w, NewSinks(monitor.SinkStats()))
+		}
+	})
+	mux.HandleFunc("/log", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPut {
+			var log Log
+			if err := json.NewDecoder(r.Body).Decode(&log); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			if err := monitor.SetLogFile(log.File); err != nil {
+				http.Error(w, err.Error(), http.StatusForbidden)
+				return
+			}
+		}
+		writeJSON(w, Log{SchemaVersion: SchemaVersion, File: monitor.LogFile()})
+	})
+	mux.HandleFunc("/pause", func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodPost:
+			var request PauseRequest
+			if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			d, err := time.ParseDuration(request.For)
+			if err != nil || d <= 0 {
+				http.Error(w, "for must be a positive duration, e.g. 2h", http.StatusBadRequest)
+				return
+			}
+			monitor.Pause(d)
+		case r.Method == http.MethodDelete:
+			monitor.Resume()
+		}
+		writeJSON(w, NewPause(monitor.PausedUntil()))
+	})
+	mux.HandleFunc("/profile", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPut {
+			var request ProfileRequest
+			if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			if err := monitor.SetProfile(request.Name); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+		writeJSON(w, NewProfile(monitor.Profile(), monitor.Profiles()))
+	})
+	mux.HandleFunc("/spinup", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		var request SpinupRequest
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if len(request.Disk) == 0 {
+			http.Error(w, "disk must not be empty", http.StatusBadRequest)
+			return
+		}
+		disks := filterDisks(NewStatus(monitor.Status()).Disks, request.Disk)
+		if len(disks) == 0 {
+			http.NotFound(w, r)
+			return
+		}
+		if err := monitor.SpinUp(disks[0].Name); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		status := NewStatus(monitor.Status())
+		status.Disks = filterDisks(status.Disks, disks[0].Name)
+		writeJSON(w, status)
+	})
+	mux.HandleFunc("/usb-power", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		var request UsbPowerRequest
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := monitor.PowerOnUsbPort(request.Port); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		writeJSON(w, UsbPower{SchemaVersion: SchemaVersion, Port: request.Port, On: true})
+	})
+	mux.HandleFunc("/epochs", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost {
+			var request AnnotationRequest
+			if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			if len(strings.TrimSpace(request.Note)) == 0 {
+				http.Error(w, "note must not be empty", http.StatusBadRequest)
+				return
+			}
+			if _, err := monitor.Annotate(request.Name, request.Note, request.Disk); err != nil {
+				http.Error(w, err.Error(), http.StatusConflict)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusCreated)
+		}
+		writeJSON(w, NewEpochs(monitor.Epochs()))
+	})
+	handleSchemas(mux)
+	return mux
+}
+
+/* webhooks are named like the ones given with --webhook, so they can be removed the same way */
+func addSink(monitor *hdidle.Monitor, w http.ResponseWriter, r *http.Request) {
+	var request SinkRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	u, err := url.Parse(request.Webhook)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		http.Error(w, "webhook must be an http or https url", http.StatusBadRequest)
+		return
+	}
+	name := "webhook " + request.Webhook
+	for _, stats := range monitor.SinkStats() {
+		if stats.Name == name {
+			http.Error(w, name+" already exists", http.StatusConflict)
+			return
+		}
+	}
+	monitor.AddSink(name, NewWebhook(request.Webhook), hdidle.DefaultSinkQueueSize)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	writeJSON(w, NewSinks(monitor.SinkStats()))
+}
+
+func handleSchemas(mux *http.ServeMux) {
+	mux.HandleFunc("/schema", func(w http.ResponseWriter, r *http.Request) {
+		index := schemaIndex{SchemaVersion: SchemaVersion, Schemas: map[string]string{}}
+		for _, name := range schemaNames() {
+			index.Schemas[name] = "/schema/" + name
+		}
+		writeJSON(w, index)
+	})
+	mux.HandleFunc("/schema/", func(w http.ResponseWriter, r *http.Request) {
+		schema, found := Schemas[strings.TrimPrefix(r.URL.Path, "/schema/")]
+		if !found {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "application/schema+json")
+		_, _ = w.Write([]byte(schema))
+	})
+}
+
+func filterDisks(disks []DiskStatus, id string) []DiskStatus {
+	matching := []DiskStatus{}
+	for _, disk := range disks {
+		if disk.matches(id) {
+			matching = append(matching, disk)
+		}
+	}
+	return matching
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+func schemaNames() []string {
+	var names []string
+	for name := range Schemas {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,275 @@
+// hd-idle - spin down idle hard disks
+// Copyright (C) 2018  Andoni del Olmo
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package api
+
+import (
+	"encoding/json"
+	"github.com/adelolmo/hd-idle/hdidle"
+	"github.com/adelolmo/hd-idle/sysfs"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestControlSinks(t *testing.T) {
+	monitor := hdidle.New(hdidle.NewConfig())
+	defer monitor.Stop()
+	handler := NewControlHandler(monitor)
+
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest("POST", "/sinks", strings.NewReader(`{"webhook":"http://127.0.0.1:9/events"}`)))
+	if recorder.Code != 201 {
+		t.Fatalf("Expected 201 but found %d: %s", recorder.Code, recorder.Body.String())
+	}
+	recorder = httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest("POST", "/sinks", strings.NewReader(`{"webhook":"http://127.0.0.1:9/events"}`)))
+	if recorder.Code != 409 {
+		t.Fatalf("Expected 409 but found %d", recorder.Code)
+	}
+	recorder = httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest("POST", "/sinks", strings.NewReader(`{"webhook":"/etc/passwd"}`)))
+	if recorder.Code != 400 {
+		t.Fatalf("Expected 400 but found %d", recorder.Code)
+	}
+
+	recorder = httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest("DELETE", "/sinks?name=webhook+http://127.0.0.1:9/events", nil))
+	if recorder.Code != 200 || len(monitor.SinkStats()) != 0 {
+		t.Fatalf("Expected the sink removed but found %d: %s", recorder.Code, recorder.Body.String())
+	}
+	recorder = httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest("DELETE", "/sinks?name=unknown", nil))
+	if recorder.Code != 404 {
+		t.Fatalf("Expected 404 but found %d", recorder.Code)
+	}
+}
+
+func TestControlLog(t *testing.T) {
+	dir, err := ioutil.TempDir("", "log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	logFile := filepath.Join(dir, "hd-idle.log")
+	fallback := filepath.Join(dir, "fallback.log")
+	config := hdidle.NewConfig()
+	config.Defaults.LogFile = logFile
+	config.Defaults.LogFallback = fallback
+	config.Defaults.ReadOnly = true
+	monitor := hdidle.New(config)
+
+	put := func(handler http.Handler, file string) *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, httptest.NewRequest("PUT", "/log", strings.NewReader(`{"file":"`+file+`"}`)))
+		return recorder
+	}
+	if recorder := put(NewControlHandler(monitor), fallback); recorder.Code != 403 || monitor.LogFile() != logFile {
+		t.Fatalf("Expected read-only mode to refuse a log file but found %d", recorder.Code)
+	}
+
+	config.Defaults.ReadOnly = false
+	if recorder := put(NewControlHandler(monitor), fallback); recorder.Code != 200 || monitor.LogFile() != fallback {
+		t.Fatalf("Expected log file changed but found %d: %s", recorder.Code, recorder.Body.String())
+	}
+	if recorder := put(NewControlHandler(monitor), logFile); recorder.Code != 200 || monitor.LogFile() != logFile {
+		t.Fatalf("Expected log file changed back but found %d: %s", recorder.Code, recorder.Body.String())
+	}
+
+	for _, file := range []string{"/etc/cron.d/x", "hd-idle.log", dir + "/../" + filepath.Base(dir) + "/hd-idle.log"} {
+		if recorder := put(NewControlHandler(monitor), file); recorder.Code != 403 || monitor.LogFile() != logFile {
+			t.Fatalf("Expected %s to be refused but found %d", file, recorder.Code)
+		}
+	}
+	if err := os.Symlink("/etc/passwd", fallback); err != nil {
+		t.Fatal(err)
+	}
+	if recorder := put(NewControlHandler(monitor), fallback); recorder.Code != 403 || monitor.LogFile() != logFile {
+		t.Fatalf("Expected a symlink to be refused but found %d", recorder.Code)
+	}
+
+	if recorder := put(NewHandler(monitor), ""); recorder.Code != 405 || monitor.LogFile() != logFile {
+		t.Fatalf("Expected the read-only handler to refuse changes but found %d", recorder.Code)
+	}
+}
+
+func TestControlPause(t *testing.T) {
+	monitor := hdidle.New(hdidle.NewConfig())
+	handler := NewControlHandler(monitor)
+
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest("POST", "/pause", strings.NewReader(`{"for":"forever"}`)))
+	if recorder.Code != 400 {
+		t.Fatalf("Expected 400 but found %d", recorder.Code)
+	}
+
+	recorder = httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest("POST", "/pause", strings.NewReader(`{"for":"2h"}`)))
+	var pause Pause
+	if err := json.Unmarshal(recorder.Body.Bytes(), &pause); err != nil {
+		t.Fatal(err)
+	}
+	if pause.PausedUntil == nil || time.Until(*pause.PausedUntil) < time.Hour {
+		t.Fatalf("Expected a pause of 2 hours but found %s", recorder.Body.String())
+	}
+
+	recorder = httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest("DELETE", "/pause", nil))
+	if !monitor.PausedUntil().IsZero() || strings.Contains(recorder.Body.String(), "paused_until") {
+		t.Fatalf("Expected the pause to be over but found %s", recorder.Body.String())
+	}
+}
+
+func TestSwitchProfile(t *testing.T) {
+	config := hdidle.NewConfig()
+	config.Defaults.ProfileIdles = map[string]time.Duration{"night": 5 * time.Minute}
+	monitor := hdidle.New(config)
+	server := httptest.NewServer(NewControlHandler(monitor))
+	defer server.Close()
+
+	if _, err := SwitchProfile(server.URL, "weekend"); err == nil {
+		t.Fatal("Expected an error for an unknown profile")
+	}
+	profile, err := SwitchProfile(server.URL, "night")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if profile.Active != "night" || len(profile.Profiles) != 1 {
+		t.Fatalf("Expected profile night to be active but found %+v", profile)
+	}
+	if profile, err = SwitchProfile(server.URL, hdidle.ProfileDefault); err != nil || profile.Active != hdidle.ProfileDefault {
+		t.Fatalf("Expected the default profile to be active but found %+v, %v", profile, err)
+	}
+
+	readOnly := httptest.NewServer(NewHandler(monitor))
+	defer readOnly.Close()
+	if _, err := SwitchProfile(readOnly.URL, "night"); err == nil {
+		t.Fatal("Expected an error without --control")
+	}
+}
+
+func TestPauseSpindowns(t *testing.T) {
+	monitor := hdidle.New(hdidle.NewConfig())
+	server := httptest.NewServer(NewControlHandler(monitor))
+	defer server.Close()
+
+	pause, err := PauseSpindowns(server.URL, 90*time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pause.PausedUntil == nil || !pause.PausedUntil.Equal(monitor.PausedUntil()) {
+		t.Fatalf("Expected the end of the pause but found %v", pause.PausedUntil)
+	}
+	if pause, err = ResumeSpindowns(server.URL); err != nil || pause.PausedUntil != nil {
+		t.Fatalf("Expected the pause to be over but found %v, %v", pause.PausedUntil, err)
+	}
+
+	readOnly := httptest.NewServer(NewHandler(monitor))
+	defer readOnly.Close()
+	if _, err := PauseSpindowns(readOnly.URL, time.Hour); err == nil {
+		t.Fatal("Expected an error without --control")
+	}
+}
+
+func TestAnnotate(t *testing.T) {
+	server := httptest.NewServer(NewControlHandler(hdidle.New(hdidle.NewConfig())))
+	defer server.Close()
+
+	epochs, err := Annotate(server.URL, AnnotationRequest{Name: "new-enclosure", Note: "replaced enclosure"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, found := epochs.Epoch("new-enclosure"); !found || len(epochs.Epochs) != 2 {
+		t.Fatalf("Expected epochs 0 and new-enclosure but found %+v", epochs.Epochs)
+	}
+	if _, err := Annotate(server.URL, AnnotationRequest{Name: "new-enclosure", Note: "again"}); err == nil {
+		t.Fatal("Expected an error for a duplicate epoch")
+	}
+	if _, err := Annotate(server.URL, AnnotationRequest{}); err == nil {
+		t.Fatal("Expected an error without note")
+	}
+	if epochs, err = FetchEpochs(server.URL); err != nil || len(epochs.Epochs) != 2 {
+		t.Fatalf("Expected 2 epochs but found %+v, %v", epochs.Epochs, err)
+	}
+}
+
+func TestSpinUp(t *testing.T) {
+	monitor := hdidle.New(hdidle.NewConfig())
+	server := httptest.NewServer(NewControlHandler(monitor))
+	defer server.Close()
+
+	if _, err := SpinUp(server.URL, "sdz"); err == nil {
+		t.Fatal("Expected an error for a disk not monitored")
+	}
+
+	readOnly := httptest.NewServer(NewHandler(monitor))
+	defer readOnly.Close()
+	if _, err := SpinUp(readOnly.URL, "sdz"); err == nil {
+		t.Fatal("Expected an error without --control")
+	}
+}
+
+func TestPowerOnUsbPort(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sysfs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer func(root string) { sysfs.Root = root }(sysfs.Root)
+	sysfs.Root = dir
+	port := filepath.Join(dir, "bus", "usb", "devices", "1-1", "1-1:1.0", "1-1-port2")
+	if err := os.MkdirAll(port, 0755); err != nil {
+		t.Fatal(err)
+	}
+	disable := filepath.Join(port, "disable")
+	if err := ioutil.WriteFile(disable, []byte("1"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	config := hdidle.NewConfig()
+	monitor := hdidle.New(config)
+	server := httptest.NewServer(NewControlHandler(monitor))
+	defer server.Close()
+
+	power, err := PowerOnUsbPort(server.URL, "1-1.2")
+	if err != nil || power.Port != "1-1.2" || !power.On {
+		t.Fatalf("Expected usb port 1-1.2 powered on but found %+v, %v", power, err)
+	}
+	if value, _ := ioutil.ReadFile(disable); string(value) != "0" {
+		t.Fatalf("Expected 0 in %s but found %s", disable, value)
+	}
+	for _, wrong := range []string{"", "../../../../etc/x-1", "1-1.2/../3"} {
+		if _, err := PowerOnUsbPort(server.URL, wrong); err == nil {
+			t.Fatalf("Expected an error for usb port %q", wrong)
+		}
+	}
+
+	config.Defaults.ReadOnly = true
+	if _, err := PowerOnUsbPort(server.URL, "1-1.2"); err == nil {
+		t.Fatal("Expected read-only mode to refuse powering on")
+	}
+
+	readOnly := httptest.NewServer(NewHandler(monitor))
+	defer readOnly.Close()
+	if _, err := PowerOnUsbPort(readOnly.URL, "1-1.2"); err == nil {
+		t.Fatal("Expected an error without --control")
+	}
+}
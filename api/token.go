@@ -0,0 +1,299 @@
+// hd-idle - spin down idle hard disks
+// Copyright (C) 2018  Andoni del Olmo
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package api
+
+import (
+	"bufio"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"github.com/adelolmo/hd-idle/hdidle"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// AllNamespaces is the namespace of the tokens of clients that may see all
+// disks.
+const AllNamespaces = "*"
+
+const minTokenLength = 16
+
+/*
+A tokens file has a line per API client: the namespace of the disks the
+client may see, read or control, and its token. Comments start with #.
+
+	# namespace  access   token
+	media        control  5c0f6d1e9a8b4c27b3e1f0a9d8c7b6a5
+	backups      read     0a1b2c3d4e5f60718293a4b5c6d7e8f9
+	*            read     f9e8d7c6b5a4938271605f4e3d2c1b0a
+*/
+
+// Token grants a client access to the disks of a namespace, or of all of
+// them with AllNamespaces.
+type Token struct {
+	Namespace string
+	Control   bool
+	Value     string
+}
+
+func LoadTokens(path string) ([]Token, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ReadTokens(f)
+}
+
+func ReadTokens(r io.Reader) ([]Token, error) {
+	var tokens []Token
+	values := map[string]bool{}
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if len(text) == 0 || strings.HasPrefix(text, "#") {
+			continue
+		}
+		fields := strings.Fields(text)
+		if len(fields) != 3 {
+			return nil, fmt.Errorf("line %d: must be <namespace> <read|control> <token>", line)
+		}
+		namespace, access, value := fields[0], fields[1], fields[2]
+		if namespace != AllNamespaces && !hdidle.ValidNamespace(namespace) {
+			return nil, fmt.Errorf("line %d: wrong namespace %s", line, namespace)
+		}
+		if access != "read" && access != "control" {
+			return nil, fmt.Errorf("line %d: wrong access %s. Must be one of: read, control", line, access)
+		}
+		if len(value) < minTokenLength {
+			return nil, fmt.Errorf("line %d: the token must have at least %d characters", line, minTokenLength)
+		}
+		if values[value] {
+			return nil, fmt.Errorf("line %d: the token is already given", line)
+		}
+		values[value] = true
+		tokens = append(tokens, Token{Namespace: namespace, Control: access == "control", Value: value})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(tokens) == 0 {
+		return nil, fmt.Errorf("no tokens")
+	}
+	return tokens, nil
+}
+
+/* compared in constant time, so the time taken tells nothing about the tokens */
+func findToken(tokens []Token, value string) (Token, bool) {
+	var found Token
+	ok := false
+	for _, token := range tokens {
+		if subtle.ConstantTimeCompare([]byte(token.Value), []byte(value)) == 1 {
+			found, ok = token, true
+		}
+	}
+	return found, ok
+}
+
+// NewTokenHandler serves the clients sending a token as bearer in the
+// Authorization header. Clients with a token of all namespaces get what
+// handler serves, read only unless their token allows control. Clients with
+// a token of a namespace only see its disks and, with control, may pause
+// their spindowns and annotate them. Control needs a handler that allows
+// changes. Clients of a unix socket without a token are left to handler,
+// others are refused.
+func NewTokenHandler(monitor *hdidle.Monitor, tokens []Token, control bool, handler http.Handler) http.Handler {
+	scoped := map[string]http.Handler{}
+	for _, token := range tokens {
+		if token.Namespace != AllNamespaces {
+			key := scopeKey(token.Namespace, token.Control && control)
+			if _, found := scoped[key]; !found {
+				scoped[key] = newNamespaceMux(monitor, token.Namespace, token.Control && control)
+			}
+		}
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header := r.Header.Get("Authorization")
+		if len(header) == 0 {
+			if _, _, peer := parsePeerAddr(r.RemoteAddr); peer {
+				handler.ServeHTTP(w, r)
+				return
+			}
+		}
+		token, found := findToken(tokens, strings.TrimPrefix(header, "Bearer "))
+		if !found || !strings.HasPrefix(header, "Bearer ") {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="hd-idle"`)
+			http.Error(w, "missing or unknown token", http.StatusUnauthorized)
+			return
+		}
+		if token.Namespace != AllNamespaces {
+			scoped[scopeKey(token.Namespace, token.Control && control)].ServeHTTP(w, r)
+			return
+		}
+		if !token.Control && r.Method != http.MethodGet && r.Method != http.MethodHead {
+			http.Error(w, "the token only allows reading", http.StatusForbidden)
+			return
+		}
+		handler.ServeHTTP(w, r)
+	})
+}
+
+func scopeKey(namespace string, control bool) string {
+	return fmt.Sprintf("%s/%t", namespace, control)
+}
+
+/*
+ * What the clients of a namespace are served: the status and SMART data of
+ * its disks, the history of its disks and the pause of its spindowns. The
+ * rest concerns the whole host.
+ */
+func newNamespaceMux(monitor *hdidle.Monitor, namespace string, control bool) http.Handler {
+	disks := func() map[string]bool {
+		names := map[string]bool{}
+		for _, device := range monitor.Status() {
+			if device.Namespace == namespace {
+				names[device.Name] = true
+			}
+		}
+		return names
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/status", func(w http.ResponseWriter, r *http.Request) {
+		status := NewStatus(monitor.Status())
+		status.Disks = filterNamespace(status.Disks, namespace)
+		var pending []hdidle.PendingDevice
+		for _, device := range monitor.Pending() {
+			if device.Namespace == namespace {
+				pending = append(pending, device)
+			}
+		}
+		status.Pending = NewPending(pending)
+		if id := r.URL.Query().Get("disk"); len(id) > 0 {
+			status.Pending = nil
+			status.Disks = filterDisks(status.Disks, id)
+			if len(status.Disks) == 0 {
+				http.NotFound(w, r)
+				return
+			}
+		}
+		writeJSON(w, status)
+	})
+	mux.HandleFunc("/smart", func(w http.ResponseWriter, r *http.Request) {
+		smart := NewSmart(monitor.Status())
+		names := disks()
+		filtered := []DiskSmart{}
+		for _, disk := range smart.Disks {
+			if names[disk.Name] {
+				filtered = append(filtered, disk)
+			}
+		}
+		smart.Disks = filtered
+		writeJSON(w, smart)
+	})
+	mux.HandleFunc("/pause", func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet, http.MethodHead:
+		case http.MethodPost, http.MethodDelete:
+			if !control {
+				http.Error(w, "the token only allows reading", http.StatusForbidden)
+				return
+			}
+			if r.Method == http.MethodDelete {
+				monitor.ResumeNamespace(namespace)
+				break
+			}
+			var request PauseRequest
+			if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			d, err := time.ParseDuration(request.For)
+			if err != nil || d <= 0 {
+				http.Error(w, "for must be a positive duration, e.g. 2h", http.StatusBadRequest)
+				return
+			}
+			monitor.PauseNamespace(namespace, d)
+		default:
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		writeJSON(w, NewPause(monitor.NamespacePausedUntil(namespace)))
+	})
+	mux.HandleFunc("/epochs", func(w http.ResponseWriter, r *http.Request) {
+		names := disks()
+		if r.Method == http.MethodPost {
+			if !control {
+				http.Error(w, "the token only allows reading", http.StatusForbidden)
+				return
+			}
+			var request AnnotationRequest
+			if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			if len(strings.TrimSpace(request.Note)) == 0 {
+				http.Error(w, "note must not be empty", http.StatusBadRequest)
+				return
+			}
+			if !names[request.Disk] {
+				http.Error(w, "disk must be a disk of namespace "+namespace, http.StatusForbidden)
+				return
+			}
+			if _, err := monitor.Annotate(request.Name, request.Note, request.Disk); err != nil {
+				http.Error(w, err.Error(), http.StatusConflict)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusCreated)
+		}
+		epochs := NewEpochs(monitor.Epochs())
+		for i := range epochs.Epochs {
+			filtered := []EpochDisk{}
+			for _, disk := range epochs.Epochs[i].Disks {
+				if names[disk.Name] {
+					filtered = append(filtered, disk)
+				}
+			}
+			epochs.Epochs[i].Disks = filtered
+			if len(epochs.Epochs[i].Disk) > 0 && !names[epochs.Epochs[i].Disk] {
+				/* a note about a disk of someone else */
+				epochs.Epochs[i].Note = ""
+				epochs.Epochs[i].Disk = ""
+			}
+		}
+		writeJSON(w, epochs)
+	})
+	handleSchemas(mux)
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "not allowed for the clients of namespace "+namespace, http.StatusForbidden)
+	})
+	return mux
+}
+
+func filterNamespace(disks []DiskStatus, namespace string) []DiskStatus {
+	matching := []DiskStatus{}
+	for _, disk := range disks {
+		if disk.Namespace == namespace {
+			matching = append(matching, disk)
+		}
+	}
+	return matching
+}
@@ -0,0 +1,103 @@
+// hd-idle - spin down idle hard disks
+// Copyright (C) 2018  Andoni del Olmo
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package api
+
+import (
+	"github.com/adelolmo/hd-idle/hdidle"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestReadTokens(t *testing.T) {
+	tokens, err := ReadTokens(strings.NewReader(`
+# namespace  access   token
+media        control  5c0f6d1e9a8b4c27b3e1f0a9d8c7b6a5
+*            read     f9e8d7c6b5a4938271605f4e3d2c1b0a
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tokens) != 2 || tokens[0] != (Token{Namespace: "media", Control: true, Value: "5c0f6d1e9a8b4c27b3e1f0a9d8c7b6a5"}) ||
+		tokens[1].Namespace != AllNamespaces || tokens[1].Control {
+		t.Fatalf("Unexpected tokens %+v", tokens)
+	}
+
+	for _, wrong := range []string{
+		"",
+		"media control",
+		"media write 5c0f6d1e9a8b4c27b3e1f0a9d8c7b6a5",
+		"me/dia read 5c0f6d1e9a8b4c27b3e1f0a9d8c7b6a5",
+		"media read secret",
+		"media read 5c0f6d1e9a8b4c27b3e1f0a9d8c7b6a5\nbackups read 5c0f6d1e9a8b4c27b3e1f0a9d8c7b6a5",
+	} {
+		if _, err := ReadTokens(strings.NewReader(wrong)); err == nil {
+			t.Fatalf("Expected an error for %q", wrong)
+		}
+	}
+}
+
+func TestTokenHandler(t *testing.T) {
+	monitor := hdidle.New(hdidle.NewConfig())
+	tokens := []Token{
+		{Namespace: "media", Control: true, Value: "media-control-0123456789"},
+		{Namespace: "backups", Value: "backups-read-0123456789"},
+		{Namespace: AllNamespaces, Value: "all-read-0123456789"},
+	}
+	handler := NewTokenHandler(monitor, tokens, true, NewControlHandler(monitor))
+
+	request := func(method, path, token, body string) int {
+		r := httptest.NewRequest(method, path, strings.NewReader(body))
+		if len(token) > 0 {
+			r.Header.Set("Authorization", "Bearer "+token)
+		}
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, r)
+		return recorder.Code
+	}
+
+	if code := request("GET", "/status", "", ""); code != 401 {
+		t.Fatalf("Expected 401 without a token but found %d", code)
+	}
+	if code := request("GET", "/status", "unknown-0123456789", ""); code != 401 {
+		t.Fatalf("Expected 401 for an unknown token but found %d", code)
+	}
+	if code := request("GET", "/status", "backups-read-0123456789", ""); code != 200 {
+		t.Fatalf("Expected 200 but found %d", code)
+	}
+	if code := request("GET", "/sinks", "backups-read-0123456789", ""); code != 403 {
+		t.Fatalf("Expected the sinks of the host hidden from a namespace but found %d", code)
+	}
+	if code := request("POST", "/pause", "backups-read-0123456789", `{"for":"1h"}`); code != 403 {
+		t.Fatalf("Expected a read token refused to pause but found %d", code)
+	}
+	if code := request("POST", "/pause", "media-control-0123456789", `{"for":"1h"}`); code != 200 {
+		t.Fatalf("Expected 200 but found %d", code)
+	}
+	if monitor.NamespacePausedUntil("media").IsZero() || !monitor.PausedUntil().IsZero() {
+		t.Fatal("Expected only the media namespace paused")
+	}
+	if code := request("POST", "/epochs", "media-control-0123456789", `{"note":"new cable","disk":"sdz"}`); code != 403 {
+		t.Fatalf("Expected a note about a disk of another namespace refused but found %d", code)
+	}
+	if code := request("POST", "/pause", "all-read-0123456789", `{"for":"1h"}`); code != 403 {
+		t.Fatalf("Expected a read token refused to pause but found %d", code)
+	}
+	if code := request("GET", "/sinks", "all-read-0123456789", ""); code != 200 {
+		t.Fatalf("Expected 200 but found %d", code)
+	}
+}
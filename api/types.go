@@ -0,0 +1,533 @@
+// hd-idle - spin down idle hard disks
+// Copyright (C) 2018  Andoni del Olmo
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+// Package api defines the machine readable output of hd-idle and serves it
+// over HTTP. The JSON shape of the types is versioned with SchemaVersion:
+// within a version fields may be added, but never renamed or removed.
+package api
+
+import (
+	"github.com/adelolmo/hd-idle/hdidle"
+	"path"
+	"time"
+)
+
+// SchemaVersion is bumped on every incompatible change of the JSON output.
+const SchemaVersion = 1
+
+// Labels attached to every per disk metric.
+const (
+	LabelDisk        = "disk"
+	LabelAlias       = "alias"
+	LabelCommandType = "command_type"
+	LabelTagPrefix   = "tag_" // followed by the key of each tag of the disk, e.g. tag_location
+)
+
+// MetricLabels lists the labels of the per disk metrics, in order. The tags
+// of the disk follow them.
+var MetricLabels = []string{LabelDisk, LabelAlias, LabelCommandType}
+
+// Labels returns the labels of the per disk metrics of the disk, its tags
+// included.
+func (d DiskStatus) Labels() map[string]string {
+	labels := map[string]string{LabelDisk: d.Name, LabelCommandType: d.CommandType}
+	if len(d.Alias) > 0 {
+		labels[LabelAlias] = d.Alias
+	}
+	for key, value := range d.Tags {
+		labels[LabelTagPrefix+key] = value
+	}
+	return labels
+}
+
+// Status is the state of all disks, served at /status.
+type Status struct {
+	SchemaVersion int           `json:"schema_version"`
+	Disks         []DiskStatus  `json:"disks"`
+	Pending       []PendingDisk `json:"pending,omitempty"`
+	Self          *Self         `json:"self,omitempty"`
+}
+
+type DiskStatus struct {
+	Name               string            `json:"name"`
+	Alias              string            `json:"alias,omitempty"`
+	CommandType        string            `json:"command_type"`
+	IdleTimeSeconds    float64           `json:"idle_time_seconds"`
+	SpunDown           bool              `json:"spun_down"`
+	SpinDownAt         *time.Time        `json:"spin_down_at,omitempty"`
+	SpinUpAt           *time.Time        `json:"spin_up_at,omitempty"`
+	LastIoAt           *time.Time        `json:"last_io_at,omitempty"`
+	TemperatureCelsius *float64          `json:"temperature_celsius,omitempty"`
+	Failures           int               `json:"consecutive_failures"`
+	QuarantinedUntil   *time.Time        `json:"quarantined_until,omitempty"`
+	SmartCollectedAt   *time.Time        `json:"smart_collected_at,omitempty"`
+	WakeLatency        *WakeLatency      `json:"wake_latency,omitempty"`
+	Statistics         *Statistics       `json:"statistics,omitempty"`
+	Links              []string          `json:"links,omitempty"`
+	Uuids              []string          `json:"uuids,omitempty"`
+	Unsupported        string            `json:"spindown_unsupported,omitempty"`
+	Inherits           string            `json:"inherits,omitempty"`
+	Media              string            `json:"media,omitempty"`
+	Namespace          string            `json:"namespace,omitempty"`
+	Tags               map[string]string `json:"tags,omitempty"`
+}
+
+// PendingDisk is a configured disk that is not plugged in.
+type PendingDisk struct {
+	Name      string            `json:"name"`
+	Alias     string            `json:"alias,omitempty"`
+	Namespace string            `json:"namespace,omitempty"`
+	Tags      map[string]string `json:"tags,omitempty"`
+	Since     time.Time         `json:"since"`
+}
+
+// Self is the resource usage of hd-idle itself, measured after every cycle
+// with --self-metrics.
+type Self struct {
+	MeasuredAt      time.Time `json:"measured_at"`
+	CycleSeconds    float64   `json:"cycle_seconds"`
+	CycleCpuSeconds float64   `json:"cycle_cpu_seconds"`
+	CpuSeconds      float64   `json:"cpu_seconds"`
+	Goroutines      int       `json:"goroutines"`
+	RssBytes        uint64    `json:"rss_bytes,omitempty"`
+	HeapBytes       uint64    `json:"heap_bytes"`
+	GcRuns          uint32    `json:"gc_runs"`
+	GcPauseSeconds  float64   `json:"gc_pause_seconds"`
+}
+
+// WakeLatency is the time from spin up to the first completed I/O.
+type WakeLatency struct {
+	LastSeconds    float64 `json:"last_seconds"`
+	LastEstimated  bool    `json:"last_estimated"`
+	AverageSeconds float64 `json:"average_seconds"`
+	MaxSeconds     float64 `json:"max_seconds"`
+	Wakes          int     `json:"wakes"`
+}
+
+// Statistics are the long-term counters of a disk, kept across restarts
+// with --checkpoint-interval.
+type Statistics struct {
+	Since             time.Time `json:"since"`
+	SpindownAttempts  int       `json:"spindown_attempts"`
+	Spindowns         int       `json:"spindowns"`          // accepted by the disk
+	SpindownsVerified int       `json:"spindowns_verified"` // confirmed standby
+	Spinups           int       `json:"spinups"`
+	SpunDownSeconds   float64   `json:"spun_down_seconds"`
+}
+
+// Event tells about something that happened to a disk.
+type Event struct {
+	SchemaVersion int               `json:"schema_version"`
+	Host          string            `json:"host,omitempty"` // set by agents pushing to a hub
+	Type          string            `json:"type"`
+	Disk          string            `json:"disk"`
+	Time          time.Time         `json:"time"`
+	Code          string            `json:"code,omitempty"`    // machine-stable reason, e.g. EIO
+	Message       string            `json:"message,omitempty"` // for humans, may change between versions
+	Tags          map[string]string `json:"tags,omitempty"`    // of the disk, e.g. location=rack2
+}
+
+// Events is a list of events, served by the hub at /events.
+type Events struct {
+	SchemaVersion int     `json:"schema_version"`
+	Events        []Event `json:"events"`
+}
+
+// Smart is the SMART data collected from the disks, served at /smart.
+type Smart struct {
+	SchemaVersion int         `json:"schema_version"`
+	Disks         []DiskSmart `json:"disks"`
+}
+
+type DiskSmart struct {
+	Name        string           `json:"name"`
+	CollectedAt time.Time        `json:"collected_at"`
+	Error       string           `json:"error,omitempty"`
+	Attributes  []SmartAttribute `json:"attributes"`
+}
+
+type SmartAttribute struct {
+	ID      uint8  `json:"id"`
+	Name    string `json:"name,omitempty"`
+	Current uint8  `json:"current"`
+	Worst   uint8  `json:"worst"`
+	Raw     uint64 `json:"raw"`
+}
+
+// Advice lists the systemd timers blamed for waking disks up, served at
+// /advice.
+type Advice struct {
+	SchemaVersion int           `json:"schema_version"`
+	Timers        []TimerAdvice `json:"timers"`
+}
+
+type TimerAdvice struct {
+	Timer       string    `json:"timer"`
+	Description string    `json:"description,omitempty"`
+	OnCalendar  []string  `json:"on_calendar"`
+	Disks       []string  `json:"disks"`
+	Wakes       int       `json:"wakes"`
+	LastWakeAt  time.Time `json:"last_wake_at"`
+	Suggestion  string    `json:"suggestion"`
+}
+
+// Sinks is the delivery state of the event sinks, served at /sinks.
+type Sinks struct {
+	SchemaVersion int         `json:"schema_version"`
+	Sinks         []SinkStats `json:"sinks"`
+}
+
+type SinkStats struct {
+	Name        string     `json:"name"`
+	Queued      int        `json:"queued"`
+	Delivered   uint64     `json:"delivered"`
+	Failed      uint64     `json:"failed"`
+	Dropped     uint64     `json:"dropped"`
+	LastError   string     `json:"last_error,omitempty"`
+	LastErrorAt *time.Time `json:"last_error_at,omitempty"`
+}
+
+// Log is the log file of the monitor, served at /log.
+type Log struct {
+	SchemaVersion int    `json:"schema_version"`
+	File          string `json:"file"`
+}
+
+// SinkRequest adds a sink through the control API, see NewControlHandler.
+type SinkRequest struct {
+	Webhook string `json:"webhook"`
+}
+
+// Pause tells whether spindowns are paused, served at /pause.
+type Pause struct {
+	SchemaVersion int        `json:"schema_version"`
+	PausedUntil   *time.Time `json:"paused_until,omitempty"`
+}
+
+// PauseRequest pauses spindowns through the control API, see
+// NewControlHandler.
+type PauseRequest struct {
+	For string `json:"for"` // e.g. 2h or 90m
+}
+
+// NewPause converts the end of a pause to its JSON shape.
+func NewPause(until time.Time) Pause {
+	pause := Pause{SchemaVersion: SchemaVersion}
+	if !until.IsZero() {
+		pause.PausedUntil = &until
+	}
+	return pause
+}
+
+// Profile tells which profile of idle times is active, served at /profile.
+type Profile struct {
+	SchemaVersion int      `json:"schema_version"`
+	Active        string   `json:"active"`   // default when the configured idle times apply
+	Profiles      []string `json:"profiles"` // those of the configuration, without default
+}
+
+// ProfileRequest switches the active profile through the control API, see
+// NewControlHandler.
+type ProfileRequest struct {
+	Name string `json:"name"` // default for the configured idle times
+}
+
+// SpinupRequest spins a disk up by hand through the control API, see
+// NewControlHandler.
+type SpinupRequest struct {
+	Disk string `json:"disk"` // name, alias or /dev/disk link
+}
+
+// UsbPowerRequest powers a usb port on through the control API, see
+// NewControlHandler.
+type UsbPowerRequest struct {
+	Port string `json:"port"` // e.g. 1-1.2, as logged when it was powered off
+}
+
+// UsbPower tells which usb port was powered on, answered at /usb-power.
+type UsbPower struct {
+	SchemaVersion int    `json:"schema_version"`
+	Port          string `json:"port"`
+	On            bool   `json:"on"`
+}
+
+// NewProfile converts the active profile of a monitor to its JSON shape.
+func NewProfile(active string, profiles []string) Profile {
+	if profiles == nil {
+		profiles = []string{}
+	}
+	return Profile{SchemaVersion: SchemaVersion, Active: active, Profiles: profiles}
+}
+
+// Epochs is the history split by annotations, served at /epochs.
+type Epochs struct {
+	SchemaVersion int     `json:"schema_version"`
+	Epochs        []Epoch `json:"epochs"`
+}
+
+type Epoch struct {
+	Name  string      `json:"name"`
+	Note  string      `json:"note,omitempty"`
+	Disk  string      `json:"disk,omitempty"` // the disk the note is about
+	Start time.Time   `json:"start"`
+	End   time.Time   `json:"end"`
+	Disks []EpochDisk `json:"disks"`
+}
+
+type EpochDisk struct {
+	Name              string  `json:"name"`
+	SpindownAttempts  int     `json:"spindown_attempts"`
+	Spindowns         int     `json:"spindowns"`
+	SpindownsVerified int     `json:"spindowns_verified"`
+	Spinups           int     `json:"spinups"`
+	SpunDownSeconds   float64 `json:"spun_down_seconds"`
+}
+
+// AnnotationRequest starts an epoch through the control API, see
+// NewControlHandler.
+type AnnotationRequest struct {
+	Name string `json:"name,omitempty"` // defaults to the number of the epoch
+	Note string `json:"note"`
+	Disk string `json:"disk,omitempty"`
+}
+
+// CommandError tells what went wrong in a one-shot command, e.g. hd-idle
+// spindown --json. It is written to stderr.
+type CommandError struct {
+	SchemaVersion int    `json:"schema_version"`
+	Command       string `json:"command"`
+	Disk          string `json:"disk,omitempty"`
+	Failure       string `json:"failure"` // one of the hdidle.Failure classes
+	ExitCode      int    `json:"exit_code"`
+	Message       string `json:"message"`
+}
+
+// NewEpochs converts the epochs of a monitor to their JSON shape.
+func NewEpochs(reports []hdidle.EpochReport) Epochs {
+	epochs := Epochs{SchemaVersion: SchemaVersion, Epochs: []Epoch{}}
+	for _, r := range reports {
+		epoch := Epoch{Name: r.Name, Note: r.Note, Disk: r.Disk, Start: r.Start, End: r.End, Disks: []EpochDisk{}}
+		for _, d := range r.Disks {
+			epoch.Disks = append(epoch.Disks, EpochDisk{
+				Name:              d.Name,
+				SpindownAttempts:  d.SpindownAttempts,
+				Spindowns:         d.Spindowns,
+				SpindownsVerified: d.SpindownsVerified,
+				Spinups:           d.Spinups,
+				SpunDownSeconds:   d.SpunDownTime.Seconds(),
+			})
+		}
+		epochs.Epochs = append(epochs.Epochs, epoch)
+	}
+	return epochs
+}
+
+// NewStatus converts the status of a monitor to its JSON shape.
+// NewPending converts the pending disks of a monitor.
+func NewPending(devices []hdidle.PendingDevice) []PendingDisk {
+	var pending []PendingDisk
+	for _, device := range devices {
+		pending = append(pending, PendingDisk{Name: device.Name, Alias: device.Alias, Namespace: device.Namespace, Tags: device.Tags, Since: device.Since})
+	}
+	return pending
+}
+
+// NewSelf converts the resource usage of a monitor, nil if it wasn't
+// measured.
+func NewSelf(self hdidle.SelfMetrics, measured bool) *Self {
+	if !measured {
+		return nil
+	}
+	return &Self{
+		MeasuredAt:      self.MeasuredAt,
+		CycleSeconds:    self.CycleDuration.Seconds(),
+		CycleCpuSeconds: self.CycleCpu.Seconds(),
+		CpuSeconds:      self.Cpu.Seconds(),
+		Goroutines:      self.Goroutines,
+		RssBytes:        self.RssBytes,
+		HeapBytes:       self.HeapBytes,
+		GcRuns:          self.GcRuns,
+		GcPauseSeconds:  self.GcPause.Seconds(),
+	}
+}
+
+func NewStatus(devices []hdidle.DeviceStatus) Status {
+	status := Status{SchemaVersion: SchemaVersion, Disks: []DiskStatus{}}
+	for _, device := range devices {
+		var smartCollectedAt *time.Time
+		if device.Smart != nil {
+			smartCollectedAt = timeOrNil(device.Smart.CollectedAt)
+		}
+		var wakeLatency *WakeLatency
+		if w := device.WakeLatency; w != nil {
+			wakeLatency = &WakeLatency{
+				LastSeconds:    w.Last.Seconds(),
+				LastEstimated:  w.LastEstimated,
+				AverageSeconds: w.Average().Seconds(),
+				MaxSeconds:     w.Max.Seconds(),
+				Wakes:          w.Wakes,
+			}
+		}
+		var statistics *Statistics
+		if s := device.Statistics; s != nil {
+			statistics = &Statistics{
+				Since:             s.Since,
+				SpindownAttempts:  s.SpindownAttempts,
+				Spindowns:         s.Spindowns,
+				SpindownsVerified: s.SpindownsVerified,
+				Spinups:           s.Spinups,
+				SpunDownSeconds:   s.SpunDownTime.Seconds(),
+			}
+		}
+		status.Disks = append(status.Disks, DiskStatus{
+			Name:               device.Name,
+			Alias:              device.Alias,
+			CommandType:        device.CommandType,
+			IdleTimeSeconds:    device.IdleTime.Seconds(),
+			SpunDown:           device.SpunDown,
+			SpinDownAt:         timeOrNil(device.SpinDownAt),
+			SpinUpAt:           timeOrNil(device.SpinUpAt),
+			LastIoAt:           timeOrNil(device.LastIoAt),
+			TemperatureCelsius: device.Temperature,
+			Failures:           device.Failures,
+			QuarantinedUntil:   timeOrNil(device.QuarantinedUntil),
+			SmartCollectedAt:   smartCollectedAt,
+			WakeLatency:        wakeLatency,
+			Statistics:         statistics,
+			Links:              device.Links,
+			Uuids:              uuids(device.Links),
+			Unsupported:        device.Unsupported,
+			Inherits:           device.Inherits,
+			Media:              device.Media,
+			Namespace:          device.Namespace,
+			Tags:               device.Tags,
+		})
+	}
+	return status
+}
+
+// NewSmart converts the SMART data in the status of a monitor to its JSON
+// shape. Disks without SMART data are left out.
+func NewSmart(devices []hdidle.DeviceStatus) Smart {
+	smart := Smart{SchemaVersion: SchemaVersion, Disks: []DiskSmart{}}
+	for _, device := range devices {
+		if device.Smart == nil {
+			continue
+		}
+		disk := DiskSmart{
+			Name:        device.Name,
+			CollectedAt: device.Smart.CollectedAt,
+			Error:       device.Smart.Error,
+			Attributes:  []SmartAttribute{},
+		}
+		for _, a := range device.Smart.Attributes {
+			disk.Attributes = append(disk.Attributes, SmartAttribute{
+				ID:      a.ID,
+				Name:    a.Name,
+				Current: a.Current,
+				Worst:   a.Worst,
+				Raw:     a.Raw,
+			})
+		}
+		smart.Disks = append(smart.Disks, disk)
+	}
+	return smart
+}
+
+// NewEvent converts an event of a monitor to its JSON shape.
+func NewEvent(event hdidle.Event) Event {
+	return Event{
+		SchemaVersion: SchemaVersion,
+		Type:          string(event.Type),
+		Disk:          event.Disk,
+		Time:          event.Time,
+		Code:          event.Code,
+		Message:       event.Message,
+		Tags:          event.Tags,
+	}
+}
+
+// NewAdvice converts the advice of a monitor to its JSON shape.
+func NewAdvice(advice []hdidle.Advice) Advice {
+	a := Advice{SchemaVersion: SchemaVersion, Timers: []TimerAdvice{}}
+	for _, timer := range advice {
+		onCalendar := timer.OnCalendar
+		if onCalendar == nil {
+			onCalendar = []string{}
+		}
+		a.Timers = append(a.Timers, TimerAdvice{
+			Timer:       timer.Timer,
+			Description: timer.Description,
+			OnCalendar:  onCalendar,
+			Disks:       timer.Disks,
+			Wakes:       timer.Wakes,
+			LastWakeAt:  timer.LastWakeAt,
+			Suggestion:  timer.Suggestion,
+		})
+	}
+	return a
+}
+
+// NewSinks converts the sink statistics of a monitor to their JSON shape.
+func NewSinks(stats []hdidle.SinkStats) Sinks {
+	sinks := Sinks{SchemaVersion: SchemaVersion, Sinks: []SinkStats{}}
+	for _, s := range stats {
+		sinks.Sinks = append(sinks.Sinks, SinkStats{
+			Name:        s.Name,
+			Queued:      s.Queued,
+			Delivered:   s.Delivered,
+			Failed:      s.Failed,
+			Dropped:     s.Dropped,
+			LastError:   s.LastError,
+			LastErrorAt: timeOrNil(s.LastErrorAt),
+		})
+	}
+	return sinks
+}
+
+/* filesystem uuids, as named by the by-uuid links */
+func uuids(links []string) []string {
+	var uuids []string
+	for _, link := range links {
+		if path.Base(path.Dir(link)) == "by-uuid" {
+			uuids = append(uuids, path.Base(link))
+		}
+	}
+	return uuids
+}
+
+/*
+ * A disk is addressed by its kernel name, alias, one of its persistent names
+ * either as path or file name, or the uuid of one of its filesystems.
+ */
+func (d DiskStatus) matches(id string) bool {
+	if id == d.Name || (len(d.Alias) > 0 && id == d.Alias) {
+		return true
+	}
+	for _, link := range d.Links {
+		if id == link || id == path.Base(link) {
+			return true
+		}
+	}
+	return false
+}
+
+func timeOrNil(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
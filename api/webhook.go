@@ -0,0 +1,54 @@
+// hd-idle - spin down idle hard disks
+// Copyright (C) 2018  Andoni del Olmo
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/adelolmo/hd-idle/hdidle"
+	"net/http"
+	"time"
+)
+
+const webhookTimeout = 10 * time.Second
+
+// Webhook is a sink that POSTs every event as JSON to a URL.
+type Webhook struct {
+	url    string
+	client *http.Client
+}
+
+func NewWebhook(url string) *Webhook {
+	return &Webhook{url: url, client: &http.Client{Timeout: webhookTimeout}}
+}
+
+func (w *Webhook) Deliver(event hdidle.Event) error {
+	body, err := json.Marshal(NewEvent(event))
+	if err != nil {
+		return err
+	}
+	resp, err := w.client.Post(w.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook %s answered %s", w.url, resp.Status)
+	}
+	return nil
+}
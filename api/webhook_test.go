@@ -0,0 +1,53 @@
+// hd-idle - spin down idle hard disks
+// Copyright (C) 2018  Andoni del Olmo
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package api
+
+import (
+	"encoding/json"
+	"github.com/adelolmo/hd-idle/hdidle"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestWebhookDeliver(t *testing.T) {
+	var received Event
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := json.NewDecoder(r.Body).Decode(&received); err != nil {
+			t.Error(err)
+		}
+	}))
+	defer server.Close()
+
+	if err := NewWebhook(server.URL).Deliver(hdidle.Event{Type: hdidle.EventSpindown, Disk: "sda"}); err != nil {
+		t.Fatal(err)
+	}
+	if received.Type != "spindown" || received.Disk != "sda" || received.SchemaVersion != SchemaVersion {
+		t.Fatalf("Unexpected event %+v", received)
+	}
+}
+
+func TestWebhookDeliverFailure(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	if err := NewWebhook(server.URL).Deliver(hdidle.Event{Type: hdidle.EventSpinup, Disk: "sda"}); err == nil {
+		t.Fatal("Expected an error for a 503 answer")
+	}
+}
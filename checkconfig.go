@@ -0,0 +1,157 @@
+// hd-idle - spin down idle hard disks
+// Copyright (C) 2018  Andoni del Olmo
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"fmt"
+	"github.com/adelolmo/hd-idle/diskstats"
+	"github.com/adelolmo/hd-idle/hdidle"
+	"github.com/adelolmo/hd-idle/io"
+	"github.com/adelolmo/hd-idle/sysfs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+/*
+hd-idle check-config [<options>]
+reads the configuration files, the environment and the options as hd-idle
+does and checks what they name without monitoring: the disks exist and spin,
+the files can be read or written. It exits with 0 when hd-idle would manage
+every disk, with 1 printing each problem otherwise, e.g. in ExecStartPre.
+Wrong options end it as they end hd-idle.
+*/
+func checkConfig(args []string) {
+	config, _ := parseArgsOrExit(append(envArgsOrExit(), commandLineOrExit(args)...))
+	problems := configProblems(config)
+	for _, problem := range problems {
+		fmt.Println(problem)
+	}
+	if len(problems) > 0 {
+		os.Exit(1)
+	}
+	fmt.Println(config.String())
+	fmt.Println("configuration ok")
+}
+
+func configProblems(config *hdidle.Config) []string {
+	var problems []string
+	var rootDisks, swapDisks []string
+	if !config.Defaults.ManageRoot {
+		rootDisks, _ = hdidle.RootDisks()
+	}
+	if !config.Defaults.ManageSwap {
+		swapDisks, _ = hdidle.SwapDisks()
+	}
+	for _, device := range config.Devices {
+		if hdidle.IsDevicePattern(device.GivenName) {
+			/* patterns may match no disk until one is plugged in */
+			continue
+		}
+		name, err := io.RealPath(device.GivenName)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("-a %s: %s", device.GivenName, err))
+			continue
+		}
+		spinning, err := sysfs.Rotational(name)
+		switch {
+		case err != nil:
+			problems = append(problems, fmt.Sprintf("-a %s: no disk %s", device.GivenName, name))
+		case !spinning && !device.ManageSsd:
+			problems = append(problems, fmt.Sprintf("-a %s: %s is not rotational, add --manage-ssd to manage it", device.GivenName, name))
+		}
+		for _, root := range rootDisks {
+			if root == name {
+				problems = append(problems, fmt.Sprintf("-a %s: %s holds the root filesystem, add --manage-root to manage it", device.GivenName, name))
+			}
+		}
+		for _, swap := range swapDisks {
+			if swap == name {
+				problems = append(problems, fmt.Sprintf("-a %s: %s holds active swap, add --manage-swap to manage it", device.GivenName, name))
+			}
+		}
+	}
+
+	files := [][2]string{{"-l", config.Defaults.LogFile}, {"--trace", config.Defaults.TraceFile}, {"--audit-opens", config.Defaults.AuditOpens}}
+	for _, device := range config.Devices {
+		files = append(files, [2]string{"--disk-log", device.LogFile})
+	}
+	for _, file := range files {
+		if len(file[1]) == 0 {
+			continue
+		}
+		if _, err := os.Stat(filepath.Dir(file[1])); err != nil {
+			problems = append(problems, fmt.Sprintf("%s %s: no directory %s", file[0], file[1], filepath.Dir(file[1])))
+		}
+	}
+	if len(config.Defaults.ApiTokens) > 0 {
+		if err := loadApiTokens(config.Defaults.ApiTokens); err != nil {
+			problems = append(problems, fmt.Sprintf("--api-tokens %s: %s", config.Defaults.ApiTokens, err))
+		}
+	}
+	for _, option := range unavailableOptions(config) {
+		problems = append(problems, fmt.Sprintf("%s: not part of the minimal build", option))
+	}
+	if config.Defaults.ReadOnly {
+		if paths := config.WritablePaths(); len(paths) > 0 {
+			problems = append(problems, fmt.Sprintf("read-only mode does not allow writing to: %s", strings.Join(paths, ", ")))
+		}
+	}
+	return problems
+}
+
+/*
+hd-idle print-config [<options>]
+reads the configuration like check-config and prints what every disk of
+/proc/diskstats, and every disk named with -a that is not plugged in, ends up
+with: a line of key=value pairs per disk, the keys in the same order between
+versions.
+*/
+func printConfig(args []string) {
+	config, _ := parseArgsOrExit(append(envArgsOrExit(), commandLineOrExit(args)...))
+	snapshot, err := diskstats.Snapshot()
+	if err != nil {
+		fmt.Printf("Cannot read disk stats: %s\n", err)
+		os.Exit(1)
+	}
+	var disks []string
+	for _, stats := range snapshot {
+		disks = append(disks, stats.Name)
+	}
+	monitor := hdidle.New(config)
+	for _, disk := range monitor.EffectiveConfig(disks) {
+		fmt.Println(disk)
+	}
+}
+
+/*
+hd-idle schema
+prints the JSON Schema of the configuration file read as TOML, for editors
+and for validating the configuration before deploying it.
+*/
+func printSchema(args []string) {
+	if len(args) > 0 {
+		fmt.Println("usage: hd-idle schema")
+		os.Exit(1)
+	}
+	schema, err := hdidle.ConfigSchema()
+	if err != nil {
+		fmt.Printf("Cannot write the schema: %s\n", err)
+		os.Exit(1)
+	}
+	fmt.Println(string(schema))
+}
@@ -0,0 +1,74 @@
+// hd-idle - spin down idle hard disks
+// Copyright (C) 2018  Andoni del Olmo
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"github.com/adelolmo/hd-idle/sysfs"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestConfigProblems(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sysfs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer func(root string) { sysfs.Root = root }(sysfs.Root)
+	sysfs.Root = dir
+	for disk, rotational := range map[string]string{"sdb": "1", "sdc": "0", "sdd": "0"} {
+		queue := filepath.Join(dir, "block", disk, "queue")
+		if err := os.MkdirAll(queue, 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(queue, "rotational"), []byte(rotational+"\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	config, _, err := parseArgs([]string{"--manage-root", "--manage-swap", "-l", filepath.Join(dir, "hd-idle.log"),
+		"-a", "sdb", "--disk-log", "/nonexistent/sdb.log", "-a", "sdc", "-a", "sdd", "--manage-ssd",
+		"-a", "sdz", "-a", "/dev/disk/by-id/nonexistent", "-a", "re:^sd[e-f]$"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{
+		"-a sdc: sdc is not rotational, add --manage-ssd to manage it",
+		"-a sdz: no disk sdz",
+		"-a /dev/disk/by-id/nonexistent: cannot find device for /dev/disk/by-id/nonexistent",
+		"--disk-log /nonexistent/sdb.log: no directory /nonexistent",
+	}
+	if problems := configProblems(config); !reflect.DeepEqual(problems, expected) {
+		t.Fatalf("Expected %q but found %q", expected, problems)
+	}
+
+	config, _, err = parseArgs([]string{"--manage-root", "--manage-swap", "--read-only", "-a", "sdb"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if problems := configProblems(config); len(problems) != 0 {
+		t.Fatalf("Expected no problem but found %q", problems)
+	}
+	config.Defaults.LogFile = filepath.Join(dir, "hd-idle.log")
+	expected = []string{"read-only mode does not allow writing to: " + config.Defaults.LogFile}
+	if problems := configProblems(config); !reflect.DeepEqual(problems, expected) {
+		t.Fatalf("Expected %q but found %q", expected, problems)
+	}
+}
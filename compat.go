@@ -0,0 +1,160 @@
+// hd-idle - spin down idle hard disks
+// Copyright (C) 2018  Andoni del Olmo
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/adelolmo/hd-idle/hdidle"
+	"os"
+	"strconv"
+	"strings"
+)
+
+const compatUsage = "usage: hd-idle --compat [-t <disk>] [-a <name>] [-i <idle_time>] [-l <logfile>] [-d] [-h]"
+
+var errCompatHelp = errors.New("help")
+
+/* the options of the original hd-idle, written in C, and whether they take a value */
+var compatOptions = map[byte]bool{'t': true, 'a': true, 'i': true, 'l': true, 'd': false, 'h': false}
+
+/*
+ * The options of the original hd-idle rewritten to the ones of this one, for
+ * an /etc/default/hd-idle kept from the Debian package of the C version. They
+ * are read like getopt did: values attached to their option (-i180) and
+ * switches grouped (-di 180). A disk named with -a idles the default 600
+ * seconds unless -i follows it, whatever the idle time of the other disks,
+ * and -a without a name returns to those defaults. Other options are an
+ * error, anything not an option is skipped.
+ */
+func compatArgs(args []string) ([]string, error) {
+	var defaults, disks, spindown []string
+	current := &defaults
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if arg == "--" {
+			break
+		}
+		if !strings.HasPrefix(arg, "-") || len(arg) < 2 {
+			continue
+		}
+		for j := 1; j < len(arg); j++ {
+			option := arg[j]
+			takesValue, known := compatOptions[option]
+			if !known {
+				return nil, fmt.Errorf("Option %s is not one of the original hd-idle. With --compat only -t, -a, -i, -l, -d and -h are known", arg)
+			}
+			if option == 'h' {
+				return nil, errCompatHelp
+			}
+			if !takesValue {
+				defaults = append(defaults, "-"+string(option))
+				continue
+			}
+			value := arg[j+1:]
+			if len(value) == 0 && i+1 < len(args) && (option != 'a' || !strings.HasPrefix(args[i+1], "-")) {
+				i++
+				value = args[i]
+			}
+			switch {
+			case option == 'a' && len(value) == 0:
+				current = &defaults
+			case len(value) == 0:
+				return nil, fmt.Errorf("Missing value of -%c", option)
+			case option == 'a':
+				disks = append(disks, "-a", value, "-i", strconv.Itoa(int(hdidle.DefaultIdleTime.Seconds())))
+				current = &disks
+			case option == 'i':
+				*current = append(*current, "-i", value)
+			case option == 't':
+				spindown = append(spindown, "-t", value)
+			default:
+				defaults = append(defaults, "-"+string(option), value)
+			}
+			break
+		}
+	}
+	return append(append(defaults, disks...), spindown...), nil
+}
+
+/*
+ * The options need the syntax of the original hd-idle when asked to with
+ * --compat, or when they use what only it understood: a value attached to
+ * its option, grouped switches or -a without a name. Options only this
+ * hd-idle knows, the long ones, rule the original syntax out. The second
+ * result tells whether it was asked for.
+ */
+func originalSyntax(args []string) (bool, bool) {
+	for _, arg := range args {
+		if arg == "--compat" {
+			return true, true
+		}
+	}
+	detected := false
+	for i, arg := range args {
+		switch {
+		case arg == "--":
+			return detected, false
+		case strings.HasPrefix(arg, "--"):
+			return false, false
+		case arg == "-a" && (i+1 == len(args) || strings.HasPrefix(args[i+1], "-")):
+			detected = true
+		case len(arg) > 2 && arg[0] == '-' && arg[1] != '-':
+			if _, known := compatOptions[arg[1]]; known {
+				detected = true
+			}
+		}
+	}
+	return detected, false
+}
+
+/*
+ * The options without --compat, rewritten if they need the original syntax.
+ * The second result tells whether they were rewritten without --compat.
+ */
+func commandLine(args []string) ([]string, bool, error) {
+	original, asked := originalSyntax(args)
+	if !original {
+		return args, false, nil
+	}
+	var rest []string
+	for _, arg := range args {
+		if arg != "--compat" {
+			rest = append(rest, arg)
+		}
+	}
+	rewritten, err := compatArgs(rest)
+	return rewritten, !asked, err
+}
+
+/* commandLine, telling on stderr about options rewritten without --compat */
+func commandLineOrExit(args []string) []string {
+	rewritten, detected, err := commandLine(args)
+	if err == errCompatHelp {
+		fmt.Println(compatUsage)
+		os.Exit(0)
+	}
+	if err != nil {
+		fmt.Println(err.Error())
+		os.Exit(1)
+	}
+	if detected {
+		fmt.Fprintf(os.Stderr, "Options read with the syntax of the original hd-idle, as with --compat: %s\n",
+			strings.Join(rewritten, " "))
+	}
+	return rewritten
+}
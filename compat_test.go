@@ -0,0 +1,75 @@
+// hd-idle - spin down idle hard disks
+// Copyright (C) 2018  Andoni del Olmo
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestCommandLine(t *testing.T) {
+	var tests = []struct {
+		args     []string
+		expected []string
+		detected bool
+	}{
+		{[]string{"-di", "180"}, []string{"-d", "-i", "180"}, true},
+		{[]string{"-a", "-i", "60"}, []string{"-i", "60"}, true},
+		{[]string{"-i180", "-a/dev/sdb"}, []string{"-i", "180", "-a", "/dev/sdb", "-i", "600"}, true},
+		{[]string{"-i", "0", "-a", "sdb", "-a", "-i", "300"}, []string{"-i", "0", "-i", "300", "-a", "sdb", "-i", "600"}, true},
+		{[]string{"--compat", "-i", "0", "-a", "sdb", "-l", "/var/log/hd-idle.log"},
+			[]string{"-i", "0", "-l", "/var/log/hd-idle.log", "-a", "sdb", "-i", "600"}, false},
+		{[]string{"-t", "sdb", "--compat", "-d"}, []string{"-d", "-t", "sdb"}, false},
+		{[]string{"-i", "0", "-a", "sdb", "-i", "300"}, []string{"-i", "0", "-a", "sdb", "-i", "300"}, false},
+		{[]string{"-di", "180", "--skew", "5m"}, []string{"-di", "180", "--skew", "5m"}, false},
+		{[]string{"-a", "sdb", "--", "-i180"}, []string{"-a", "sdb", "--", "-i180"}, false},
+	}
+	for _, test := range tests {
+		args, detected, err := commandLine(test.args)
+		if err != nil {
+			t.Fatalf("%v: unexpected error: %s", test.args, err)
+		}
+		if !reflect.DeepEqual(args, test.expected) || detected != test.detected {
+			t.Fatalf("%v: expected %v, detected %t, but found %v, %t", test.args, test.expected, test.detected, args, detected)
+		}
+	}
+}
+
+func TestCommandLineErrors(t *testing.T) {
+	if _, _, err := commandLine([]string{"--compat", "-h"}); err != errCompatHelp {
+		t.Fatalf("Expected the usage for -h but found %v", err)
+	}
+	if _, _, err := commandLine([]string{"-dh"}); err != errCompatHelp {
+		t.Fatalf("Expected the usage for -dh but found %v", err)
+	}
+	var tests = []struct {
+		args    []string
+		message string
+	}{
+		{[]string{"--compat", "-x"}, "Option -x is not one of the original hd-idle"},
+		{[]string{"-i180", "-c", "ata"}, "Option -c is not one of the original hd-idle"},
+		{[]string{"--compat", "-i"}, "Missing value of -i"},
+		{[]string{"-l", "/var/log/hd-idle.log", "-di"}, "Missing value of -i"},
+	}
+	for _, test := range tests {
+		_, _, err := commandLine(test.args)
+		if err == nil || !strings.HasPrefix(err.Error(), test.message) {
+			t.Fatalf("%v: expected %q but found %v", test.args, test.message, err)
+		}
+	}
+}
@@ -19,8 +19,8 @@ package diskstats
 import (
 	"bufio"
 	"errors"
+	"fmt"
 	"io"
-	"log"
 	"os"
 	"regexp"
 	"strconv"
@@ -67,57 +67,152 @@ For more details refer to Documentation/admin-guide/iostats.rst
 */
 
 const (
-	deviceNameCol = 2 // field 3 - device name
-	readsCol      = 5 // field 6 - sectors read
-	writesCol     = 9 // field 10 - sectors written
+	deviceNameCol      = 2  // field 3 - device name
+	readsCompletedCol  = 3  // field 4 - reads completed successfully
+	readsMergedCol     = 4  // field 5 - reads merged
+	readsCol           = 5  // field 6 - sectors read
+	writesCompletedCol = 7  // field 8 - writes completed
+	writesMergedCol    = 8  // field 9 - writes merged
+	writesCol          = 9  // field 10 - sectors written
+	inFlightCol        = 11 // field 12 - I/Os currently in progress
+	ioTicksCol         = 12 // field 13 - time spent doing I/Os (ms)
+	discardsCol        = 16 // field 17 - sectors discarded
 )
 
 type DiskStats struct {
-	Name        string
-	IdleTime    time.Duration
-	CommandType string
-	Reads       int
-	Writes      int
-	SpinDownAt  time.Time
-	SpinUpAt    time.Time
-	LastIoAt    time.Time
-	SpunDown    bool
+	Name            string
+	IdleTime        time.Duration
+	CommandType     string
+	Reads           int // sectors read
+	Writes          int // sectors written
+	ReadsCompleted  int
+	ReadsMerged     int
+	WritesCompleted int
+	WritesMerged    int
+	Discards        int
+	InFlight        int
+	IoTicks         int // ms the disk had I/O in progress
+	SpinDownAt      time.Time
+	SpinUpAt        time.Time
+	LastIoAt        time.Time
+	SpunDown        bool
+}
+
+// IoDelta is the read and write activity between two snapshots of a disk.
+// Requests merged into others, or completed without moving a whole sector,
+// leave the sector counters alone, so all of them are compared.
+type IoDelta struct {
+	Reads           int // sectors read
+	ReadsCompleted  int
+	ReadsMerged     int
+	Writes          int // sectors written
+	WritesCompleted int
+	WritesMerged    int
+}
+
+// IoSince returns the activity of the disk since the previous snapshot.
+func (ds DiskStats) IoSince(previous DiskStats) IoDelta {
+	return IoDelta{
+		Reads:           ds.Reads - previous.Reads,
+		ReadsCompleted:  ds.ReadsCompleted - previous.ReadsCompleted,
+		ReadsMerged:     ds.ReadsMerged - previous.ReadsMerged,
+		Writes:          ds.Writes - previous.Writes,
+		WritesCompleted: ds.WritesCompleted - previous.WritesCompleted,
+		WritesMerged:    ds.WritesMerged - previous.WritesMerged,
+	}
+}
+
+// TakeReads copies the read counters of a newer snapshot.
+func (ds *DiskStats) TakeReads(current DiskStats) {
+	ds.Reads = current.Reads
+	ds.ReadsCompleted = current.ReadsCompleted
+	ds.ReadsMerged = current.ReadsMerged
+}
+
+// TakeIo copies the read and write counters of a newer snapshot.
+func (ds *DiskStats) TakeIo(current DiskStats) {
+	ds.TakeReads(current)
+	ds.Writes = current.Writes
+	ds.WritesCompleted = current.WritesCompleted
+	ds.WritesMerged = current.WritesMerged
+}
+
+// Read tells whether the disk was read from.
+func (d IoDelta) Read() bool {
+	return d.Reads != 0 || d.ReadsCompleted != 0 || d.ReadsMerged != 0
+}
+
+// Written tells whether the disk was written to.
+func (d IoDelta) Written() bool {
+	return d.Writes != 0 || d.WritesCompleted != 0 || d.WritesMerged != 0
+}
+
+// Idle tells whether the disk was neither read from nor written to.
+func (d IoDelta) Idle() bool {
+	return !d.Read() && !d.Written()
+}
+
+func (d IoDelta) String() string {
+	return fmt.Sprintf("sectorsRead=%+d readsCompleted=%+d readsMerged=%+d sectorsWritten=%+d writesCompleted=%+d writesMerged=%+d",
+		d.Reads, d.ReadsCompleted, d.ReadsMerged, d.Writes, d.WritesCompleted, d.WritesMerged)
 }
 
 var scsiDiskRegex *regexp.Regexp
+var stackedDeviceRegex *regexp.Regexp
 
 func init() {
 	scsiDiskRegex = regexp.MustCompile("sd[a-z]$")
+	stackedDeviceRegex = regexp.MustCompile("^(loop|dm-)[0-9]+$")
 }
 
-func Snapshot() []DiskStats {
+func Snapshot() ([]DiskStats, error) {
 	f, err := os.Open("/proc/diskstats")
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
 	}
 	defer f.Close()
 
 	return ReadSnapshot(f)
 }
 
-func ReadSnapshot(r io.Reader) []DiskStats {
+func ReadSnapshot(r io.Reader) ([]DiskStats, error) {
+	return readSnapshot(r, scsiDiskRegex)
+}
+
+// StackedSnapshot returns the statistics of the loop and device mapper
+// devices, whose I/O may reach the disks underneath only later, if at all.
+func StackedSnapshot() ([]DiskStats, error) {
+	f, err := os.Open("/proc/diskstats")
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return ReadStackedSnapshot(f)
+}
+
+func ReadStackedSnapshot(r io.Reader) ([]DiskStats, error) {
+	return readSnapshot(r, stackedDeviceRegex)
+}
+
+func readSnapshot(r io.Reader, names *regexp.Regexp) ([]DiskStats, error) {
 	var snapshot []DiskStats
 	scanner := bufio.NewScanner(r)
 	for scanner.Scan() {
-		diskStats, err := statsForDisk(scanner.Text())
+		diskStats, err := statsForDisk(scanner.Text(), names)
 		if err == nil {
 			snapshot = append(snapshot, *diskStats)
 		}
 	}
 
 	if err := scanner.Err(); err != nil {
-		log.Fatal(err)
+		return nil, err
 	}
 
-	return snapshot
+	return snapshot, nil
 }
 
-func statsForDisk(rawStats string) (*DiskStats, error) {
+func statsForDisk(rawStats string, names *regexp.Regexp) (*DiskStats, error) {
 	reader := strings.NewReader(rawStats)
 	scanner := bufio.NewScanner(reader)
 	for scanner.Scan() {
@@ -125,7 +220,7 @@ func statsForDisk(rawStats string) (*DiskStats, error) {
 		name := cols[deviceNameCol]
 		reads, _ := strconv.Atoi(cols[readsCol])
 		writes, _ := strconv.Atoi(cols[writesCol])
-		if !scsiDiskRegex.MatchString(name) {
+		if !names.MatchString(name) {
 			return nil, errors.New("disk is a partition")
 		}
 		stats := &DiskStats{
@@ -133,6 +228,19 @@ func statsForDisk(rawStats string) (*DiskStats, error) {
 			Reads:  reads,
 			Writes: writes,
 		}
+		stats.ReadsCompleted, _ = strconv.Atoi(cols[readsCompletedCol])
+		stats.ReadsMerged, _ = strconv.Atoi(cols[readsMergedCol])
+		stats.WritesCompleted, _ = strconv.Atoi(cols[writesCompletedCol])
+		stats.WritesMerged, _ = strconv.Atoi(cols[writesMergedCol])
+		if len(cols) > inFlightCol {
+			stats.InFlight, _ = strconv.Atoi(cols[inFlightCol])
+		}
+		if len(cols) > ioTicksCol {
+			stats.IoTicks, _ = strconv.Atoi(cols[ioTicksCol])
+		}
+		if len(cols) > discardsCol {
+			stats.Discards, _ = strconv.Atoi(cols[discardsCol])
+		}
 		return stats, nil
 	}
 
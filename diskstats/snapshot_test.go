@@ -40,12 +40,18 @@ func TestTakeSnapshot(t *testing.T) {
    8      16 sdb 5650742 34516 727476416 92732820 1728864 35618 404215912 705303450 0 22944140 798112260
    8      17 sdb1 5650643 34516 727475192 92673920 1728864 35618 404215912 705303450 0 22893010 798071230`
 
-	stats := ReadSnapshot(strings.NewReader(s))
+	stats, err := ReadSnapshot(strings.NewReader(s))
+	if err != nil {
+		t.Fatal(err)
+	}
 
 	expected := []DiskStats{
-		{Name: "sda", Reads: 37537568, Writes: 10439592},
-		{Name: "sdc", Reads: 6494584, Writes: 6370936},
-		{Name: "sdb", Reads: 727476416, Writes: 404215912},
+		{Name: "sda", Reads: 37537568, Writes: 10439592, ReadsCompleted: 321553, ReadsMerged: 158156,
+			WritesCompleted: 50820, WritesMerged: 94361, IoTicks: 3357150},
+		{Name: "sdc", Reads: 6494584, Writes: 6370936, ReadsCompleted: 52147, ReadsMerged: 2738,
+			WritesCompleted: 28092, WritesMerged: 1251, IoTicks: 506360},
+		{Name: "sdb", Reads: 727476416, Writes: 404215912, ReadsCompleted: 5650742, ReadsMerged: 34516,
+			WritesCompleted: 1728864, WritesMerged: 35618, IoTicks: 22944140},
 	}
 
 	if len(expected) != len(stats) {
@@ -60,3 +66,69 @@ func TestTakeSnapshot(t *testing.T) {
 		}
 	}
 }
+
+func TestTakeSnapshotWithDiscards(t *testing.T) {
+	s := `   8       0 sda 321553 158156 37537568 5961590 50820 94361 10439592 26691430 2 3357150 32650910 120 0 81920 40 0 0
+   8       1 sda1 321454 158156 37536344 5725790 50820 94361 10439592 26691430 2 3121370 32415240 120 0 81920 40 0 0`
+
+	stats, err := ReadSnapshot(strings.NewReader(s))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := DiskStats{Name: "sda", Reads: 37537568, Writes: 10439592, ReadsCompleted: 321553, ReadsMerged: 158156,
+		WritesCompleted: 50820, WritesMerged: 94361, InFlight: 2, IoTicks: 3357150, Discards: 81920}
+	if len(stats) != 1 {
+		t.Fatalf("Expected 1 disk but found %d", len(stats))
+	}
+	if stats[0] != expected {
+		t.Fatalf("Expected %v but found %v", expected, stats[0])
+	}
+}
+
+func TestTakeStackedSnapshot(t *testing.T) {
+	s := `   7       0 loop0 1200 0 9600 30 400 0 3200 60 0 90 90
+   8       0 sda 321553 158156 37537568 5961590 50820 94361 10439592 26691430 0 3357150 32650910
+   8       1 sda1 321454 158156 37536344 5725790 50820 94361 10439592 26691430 0 3121370 32415240
+ 253       0 dm-0 5000 0 40000 100 200 0 1600 20 0 120 120
+   9       0 md0 10 0 80 1 0 0 0 0 0 1 1`
+
+	stats, err := ReadStackedSnapshot(strings.NewReader(s))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []DiskStats{
+		{Name: "loop0", Reads: 9600, Writes: 3200, ReadsCompleted: 1200, WritesCompleted: 400, IoTicks: 90},
+		{Name: "dm-0", Reads: 40000, Writes: 1600, ReadsCompleted: 5000, WritesCompleted: 200, IoTicks: 120},
+	}
+	if len(stats) != len(expected) || stats[0] != expected[0] || stats[1] != expected[1] {
+		t.Fatalf("Expected %v but found %v", expected, stats)
+	}
+}
+
+func TestIoSinceMerges(t *testing.T) {
+	before := DiskStats{Name: "sda", Reads: 800, Writes: 1600, ReadsCompleted: 10, WritesCompleted: 20, WritesMerged: 5}
+	after := before
+	if !after.IoSince(before).Idle() {
+		t.Fatalf("Expected no activity but found %v", after.IoSince(before))
+	}
+
+	/* a request merged into one already queued leaves the sectors alone */
+	after.WritesMerged++
+	delta := after.IoSince(before)
+	if delta.Idle() || !delta.Written() || delta.Read() {
+		t.Fatalf("Expected a write but found %v", delta)
+	}
+	if delta.String() != "sectorsRead=+0 readsCompleted=+0 readsMerged=+0 sectorsWritten=+0 writesCompleted=+0 writesMerged=+1" {
+		t.Fatalf("Unexpected delta %s", delta)
+	}
+
+	before.TakeReads(after)
+	if before.WritesMerged != 5 {
+		t.Fatalf("Expected the write counters to stay but found %d", before.WritesMerged)
+	}
+	before.TakeIo(after)
+	if !after.IoSince(before).Idle() {
+		t.Fatalf("Expected no activity but found %v", after.IoSince(before))
+	}
+}
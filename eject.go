@@ -0,0 +1,76 @@
+// hd-idle - spin down idle hard disks
+// Copyright (C) 2018  Andoni del Olmo
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/adelolmo/hd-idle/hdidle"
+	"github.com/adelolmo/hd-idle/io"
+	"os"
+)
+
+const ejectUsage = "usage: hd-idle eject [-c <command_type>] [--usb-power-off] [--notify] [--locate] [--json] <disk>"
+
+/*
+hd-idle eject [-c <command_type>] [--usb-power-off] [--notify] [--locate] [--json] <disk>
+flushes and spins down a disk so it can be unplugged, and tells when it can.
+Failures exit with the codes of the one-shot commands, see oneShot.
+*/
+func eject(args []string) {
+	var disk string
+	var jsonErrors bool
+	options := hdidle.EjectOptions{CommandType: hdidle.SCSI}
+	for index := 0; index < len(args); index++ {
+		switch arg := args[index]; arg {
+		case "-c":
+			if index+1 == len(args) {
+				fail("eject", "", jsonErrors, hdidle.FailureUsage, errors.New(ejectUsage))
+			}
+			index++
+			options.CommandType = args[index]
+			if options.CommandType != hdidle.SCSI && options.CommandType != hdidle.ATA {
+				fail("eject", "", jsonErrors, hdidle.FailureUsage,
+					fmt.Errorf("Wrong command_type -c %s. Must be one of: scsi, ata", options.CommandType))
+			}
+		case "--usb-power-off":
+			options.UsbPowerOff = true
+		case "--notify":
+			options.Notify = true
+		case "--locate":
+			options.Locate = true
+		case "--json":
+			jsonErrors = true
+		case "-h":
+			fmt.Println(ejectUsage)
+			os.Exit(0)
+		default:
+			name, err := io.RealPath(arg)
+			if err != nil {
+				fail("eject", arg, jsonErrors, hdidle.FailureNoDisk, err)
+			}
+			disk = name
+		}
+	}
+	if len(disk) == 0 {
+		fail("eject", "", jsonErrors, hdidle.FailureUsage, errors.New("Missing disk. "+ejectUsage))
+	}
+
+	if err := hdidle.Eject(disk, options, os.Stdout); err != nil {
+		fail("eject", disk, jsonErrors, hdidle.FailureClass(err), err)
+	}
+}
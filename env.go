@@ -0,0 +1,160 @@
+// hd-idle - spin down idle hard disks
+// Copyright (C) 2018  Andoni del Olmo
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+/* how an option is given in the environment */
+const (
+	envValue  = iota // HD_IDLE_LOG_FILE=/var/log/hd-idle.log
+	envSwitch        // HD_IDLE_DEBUG=true
+	envList          // HD_IDLE_LISTEN="127.0.0.1:7000 unix:/run/hd-idle.sock", given once per item
+)
+
+type envOption struct {
+	flag string
+	name string // empty for HD_IDLE_ and the long name in capitals, e.g. HD_IDLE_LOG_BUFFER
+	kind int
+}
+
+/*
+ * The options of the defaults. The options of a disk only make sense after
+ * -a, which has no environment variable, and the ones doing something once,
+ * like -t or --usb-power-on, neither.
+ */
+var envOptions = []envOption{
+	{"--config", "", envValue},
+	{"--config-dir", "", envValue},
+	{"-i", "HD_IDLE_DEFAULT_IDLE", envValue},
+	{"-c", "HD_IDLE_DEFAULT_COMMAND_TYPE", envValue},
+	{"-s", "HD_IDLE_SYMLINK_POLICY", envValue},
+	{"-x", "HD_IDLE_EXCLUDE", envList},
+	{"--battery-idle", "", envValue},
+	{"--profile-idle", "", envList},
+	{"--profile", "", envValue},
+	{"-l", "HD_IDLE_LOG_FILE", envValue},
+	{"-d", "HD_IDLE_DEBUG", envSwitch},
+	{"--usb-power-off", "", envSwitch},
+	{"--sata-lpm", "", envValue},
+	{"--wait-mount", "", envList},
+	{"--wait-mount-timeout", "", envValue},
+	{"--skew", "", envValue},
+	{"--probe-window", "", envValue},
+	{"--hba-runtime-pm", "", envSwitch},
+	{"--usb-hub-spacing", "", envValue},
+	{"--smart-interval", "", envValue},
+	{"--standby-read-ahead", "", envValue},
+	{"--state-dir", "", envValue},
+	{"--checkpoint-interval", "", envValue},
+	{"--unsupported", "", envValue},
+	{"--replacement", "", envValue},
+	{"--wake-storm", "", envValue},
+	{"--wake-storm-window", "", envValue},
+	{"--advisor", "", envSwitch},
+	{"--watchdog", "", envValue},
+	{"--breaker-threshold", "", envValue},
+	{"--breaker-cooldown", "", envValue},
+	{"--awake", "", envList},
+	{"--quirks", "", envValue},
+	{"--learn-quirks", "", envSwitch},
+	{"--log-format", "", envValue},
+	{"--log-buffer", "", envSwitch},
+	{"--log-fallback", "", envValue},
+	{"--log-fallback-timeout", "", envValue},
+	{"--trace", "", envValue},
+	{"--audit-opens", "", envValue},
+	{"--watch-config", "", envSwitch},
+	{"--self-metrics", "", envSwitch},
+	{"--daily-report", "", envValue},
+	{"--stacked-io", "", envSwitch},
+	{"--opt-in", "", envSwitch},
+	{"--manage-root", "", envSwitch},
+	{"--manage-swap", "", envSwitch},
+	{"--manual-hold", "", envValue},
+	{"--exports", "", envSwitch},
+	{"--sshd-idle", "", envValue},
+	{"--smr-idle", "", envValue},
+	{"--smr-gc-wait", "", envValue},
+	{"--power-drop", "", envValue},
+	{"--inhibit-suspend", "", envSwitch},
+	{"--background-check", "", envSwitch},
+	{"--crash-dir", "", envValue},
+	{"--simulate", "", envValue},
+	{"--listen", "", envList},
+	{"--control", "", envSwitch},
+	{"--listen-control", "", envList},
+	{"--read-allow", "", envList},
+	{"--control-allow", "", envList},
+	{"--api-tokens", "", envValue},
+	{"--webhook", "", envList},
+	{"--push", "", envValue},
+	{"--push-interval", "", envValue},
+	{"--read-only", "", envSwitch},
+}
+
+func (o envOption) variable() string {
+	if len(o.name) > 0 {
+		return o.name
+	}
+	return "HD_IDLE_" + strings.ToUpper(strings.Replace(strings.TrimPrefix(o.flag, "--"), "-", "_", -1))
+}
+
+/*
+ * The options set in the environment, to be given before the ones of the
+ * command line: the command line overrides the environment, which overrides
+ * the configuration files. Wrong switches are an error.
+ */
+func envArgs() ([]string, error) {
+	var args []string
+	for _, option := range envOptions {
+		value, found := os.LookupEnv(option.variable())
+		if !found {
+			continue
+		}
+		switch option.kind {
+		case envValue:
+			args = append(args, option.flag, value)
+		case envSwitch:
+			on, err := strconv.ParseBool(value)
+			if err != nil {
+				return nil, fmt.Errorf("Wrong %s=%s. Must be true or false", option.variable(), value)
+			}
+			if on {
+				args = append(args, option.flag)
+			}
+		case envList:
+			for _, item := range strings.Fields(value) {
+				args = append(args, option.flag, item)
+			}
+		}
+	}
+	return args, nil
+}
+
+func envArgsOrExit() []string {
+	args, err := envArgs()
+	if err != nil {
+		fmt.Println(err.Error())
+		os.Exit(1)
+	}
+	return args
+}
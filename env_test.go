@@ -0,0 +1,116 @@
+// hd-idle - spin down idle hard disks
+// Copyright (C) 2018  Andoni del Olmo
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"github.com/adelolmo/hd-idle/hdidle"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+/* only the given HD_IDLE_ variables set, until the returned function restores the environment */
+func environment(vars map[string]string) func() {
+	saved := map[string]string{}
+	for _, option := range envOptions {
+		if value, found := os.LookupEnv(option.variable()); found {
+			saved[option.variable()] = value
+			os.Unsetenv(option.variable())
+		}
+	}
+	for name, value := range vars {
+		os.Setenv(name, value)
+	}
+	return func() {
+		for name := range vars {
+			os.Unsetenv(name)
+		}
+		for name, value := range saved {
+			os.Setenv(name, value)
+		}
+	}
+}
+
+func TestEnvArgs(t *testing.T) {
+	var tests = []struct {
+		vars     map[string]string
+		expected []string
+	}{
+		{map[string]string{}, nil},
+		{map[string]string{"HD_IDLE_LOG_FILE": "/var/log/hd-idle.log"}, []string{"-l", "/var/log/hd-idle.log"}},
+		{map[string]string{"HD_IDLE_DEFAULT_IDLE": "300", "HD_IDLE_SKEW": "5m"}, []string{"-i", "300", "--skew", "5m"}},
+		{map[string]string{"HD_IDLE_DEBUG": "true", "HD_IDLE_LOG_BUFFER": "1"}, []string{"-d", "--log-buffer"}},
+		{map[string]string{"HD_IDLE_DEBUG": "false", "HD_IDLE_READ_ONLY": "0"}, nil},
+		{map[string]string{"HD_IDLE_EXCLUDE": " sdb  sdc ", "HD_IDLE_LISTEN": "127.0.0.1:7000"},
+			[]string{"-x", "sdb", "-x", "sdc", "--listen", "127.0.0.1:7000"}},
+		{map[string]string{"HD_IDLE_EXCLUDE": ""}, nil},
+	}
+	for _, test := range tests {
+		restore := environment(test.vars)
+		args, err := envArgs()
+		restore()
+		if err != nil {
+			t.Fatalf("%v: unexpected error: %s", test.vars, err)
+		}
+		if !reflect.DeepEqual(args, test.expected) {
+			t.Fatalf("%v: expected %v but found %v", test.vars, test.expected, args)
+		}
+	}
+}
+
+func TestEnvArgsWrongSwitch(t *testing.T) {
+	defer environment(map[string]string{"HD_IDLE_DEBUG": "maybe"})()
+	_, err := envArgs()
+	if err == nil || !strings.HasPrefix(err.Error(), "Wrong HD_IDLE_DEBUG=maybe") {
+		t.Fatalf("Expected an error for HD_IDLE_DEBUG=maybe but found %v", err)
+	}
+}
+
+func TestEnvArgsPrecedence(t *testing.T) {
+	file, remove := configFile(t, "hd-idle.conf", "idle = 100\nlog_file = \"/tmp/config.log\"\ndebug = true\n")
+	defer remove()
+	parse := func(vars map[string]string, flags ...string) *hdidle.Config {
+		defer environment(vars)()
+		args, err := envArgs()
+		if err != nil {
+			t.Fatal(err)
+		}
+		config, _, err := parseArgs(append(args, append([]string{"--config", file}, flags...)...))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return config
+	}
+
+	config := parse(map[string]string{})
+	if config.Defaults.LogFile != "/tmp/config.log" || config.Defaults.Idle.Seconds() != 100 {
+		t.Fatalf("Expected the configuration file but found %s, %s", config.Defaults.LogFile, config.Defaults.Idle)
+	}
+
+	env := map[string]string{"HD_IDLE_LOG_FILE": "/tmp/env.log", "HD_IDLE_DEFAULT_IDLE": "200"}
+	config = parse(env)
+	if config.Defaults.LogFile != "/tmp/env.log" || config.Defaults.Idle.Seconds() != 200 || !config.Defaults.Debug {
+		t.Fatalf("Expected the environment over the configuration file but found %s, %s, debug %t",
+			config.Defaults.LogFile, config.Defaults.Idle, config.Defaults.Debug)
+	}
+
+	config = parse(env, "-l", "/tmp/flag.log")
+	if config.Defaults.LogFile != "/tmp/flag.log" || config.Defaults.Idle.Seconds() != 200 {
+		t.Fatalf("Expected the command line over the environment but found %s, %s", config.Defaults.LogFile, config.Defaults.Idle)
+	}
+}
@@ -0,0 +1,120 @@
+// hd-idle - spin down idle hard disks
+// Copyright (C) 2018  Andoni del Olmo
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+//go:build !minimal
+// +build !minimal
+
+package main
+
+import (
+	"fmt"
+	"github.com/adelolmo/hd-idle/api"
+	"os"
+)
+
+const (
+	annotateUsage = "usage: hd-idle annotate --note <text> [--name <epoch>] [--disk <disk>] [--url <url>]"
+	reportUsage   = "usage: hd-idle report [--compare <epoch> <epoch>] [--url <url>]"
+)
+
+/*
+hd-idle annotate --note <text> [--name <epoch>] [--disk <disk>] [--url <url>]
+starts a new epoch of the history of the running hd-idle, started with
+--listen and --control, e.g. after changing its configuration.
+*/
+func annotate(args []string) {
+	url := defaultControlUrl
+	var request api.AnnotationRequest
+	for index, arg := range args {
+		switch arg {
+		case "--note":
+			request.Note = args[index+1]
+		case "--name":
+			request.Name = args[index+1]
+		case "--disk":
+			request.Disk = args[index+1]
+		case "--url":
+			url = args[index+1]
+		case "-h":
+			fmt.Println(annotateUsage)
+			os.Exit(0)
+		}
+	}
+	if len(request.Note) == 0 {
+		fmt.Println("Missing --note. " + annotateUsage)
+		os.Exit(1)
+	}
+
+	epochs, err := api.Annotate(url, request)
+	if err != nil {
+		fmt.Println(err.Error())
+		os.Exit(1)
+	}
+	latest := epochs.Epochs[len(epochs.Epochs)-1]
+	fmt.Printf("epoch %s started %s\n", latest.Name, latest.Start.Local().Format("2006-01-02T15:04:05"))
+}
+
+/*
+hd-idle report [--compare <epoch> <epoch>] [--url <url>]
+lists the epochs of the running hd-idle, or compares the activity of its
+disks during two of them.
+*/
+func report(args []string) {
+	url := defaultControlUrl
+	var compare []string
+	for index, arg := range args {
+		switch arg {
+		case "--compare":
+			if index+2 >= len(args) {
+				fmt.Println("Missing epochs for --compare. " + reportUsage)
+				os.Exit(1)
+			}
+			compare = args[index+1 : index+3]
+		case "--url":
+			url = args[index+1]
+		case "-h":
+			fmt.Println(reportUsage)
+			os.Exit(0)
+		}
+	}
+
+	epochs, err := api.FetchEpochs(url)
+	if err != nil {
+		fmt.Println(err.Error())
+		os.Exit(1)
+	}
+	if len(compare) == 0 {
+		for _, e := range epochs.Epochs {
+			line := fmt.Sprintf("epoch %s: %s", e.Name, e.Start.Local().Format("2006-01-02T15:04:05"))
+			if len(e.Disk) > 0 {
+				line += " " + e.Disk + ":"
+			}
+			fmt.Println(line + " " + e.Note)
+		}
+		return
+	}
+
+	var compared []api.Epoch
+	for _, name := range compare {
+		e, found := epochs.Epoch(name)
+		if !found {
+			fmt.Printf("Unknown epoch %s\n", name)
+			os.Exit(1)
+		}
+		compared = append(compared, e)
+	}
+	api.CompareEpochs(os.Stdout, compared[0], compared[1])
+}
@@ -0,0 +1,123 @@
+// hd-idle - spin down idle hard disks
+// Copyright (C) 2018  Andoni del Olmo
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package hdidle
+
+import (
+	"github.com/adelolmo/hd-idle/timers"
+	"sort"
+	"strings"
+	"time"
+)
+
+/* how long after a timer triggered a wake up is blamed on it, besides the poll interval */
+const adviceSlack = time.Minute
+
+// Advice names a systemd timer that keeps waking disks up, and what to do
+// about it.
+type Advice struct {
+	Timer       string
+	Description string
+	OnCalendar  []string
+	Disks       []string
+	Wakes       int
+	LastWakeAt  time.Time
+	Suggestion  string
+}
+
+var suggestions = []struct {
+	prefix     string
+	suggestion string
+}{
+	{"mlocate", "add the mount points of the disk to PRUNEPATHS in /etc/updatedb.conf"},
+	{"plocate", "add the mount points of the disk to PRUNEPATHS in /etc/updatedb.conf"},
+	{"updatedb", "add the mount points of the disk to PRUNEPATHS in /etc/updatedb.conf"},
+	{"man-db", "make sure /usr/share/man and /var/cache/man are not on the disk"},
+	{"apt-daily", "make sure /var/cache/apt and /var/lib/apt are not on the disk"},
+	{"logrotate", "move the logs rotated by logrotate off the disk"},
+	{"btrfs-", "schedule the timer inside an --awake window of the disk"},
+	{"e2scrub", "schedule the timer inside an --awake window of the disk"},
+	{"fstrim", "schedule the timer inside an --awake window of the disk"},
+}
+
+func suggestion(timer string) string {
+	for _, s := range suggestions {
+		if strings.HasPrefix(timer, s.prefix) {
+			return s.suggestion
+		}
+	}
+	return "check what the timer unit touches on the disk, or schedule it inside an --awake window"
+}
+
+/*
+ * Blame a wake up on the persistent timers that triggered shortly before it.
+ * systemd touches their stamp files every time they run, so the correlation
+ * needs neither systemd nor parsing calendar specs.
+ */
+func (m *Monitor) adviseOnWake(disk string) {
+	if !m.config.Defaults.Advisor {
+		return
+	}
+	all, err := timers.Read()
+	if err != nil {
+		if m.config.Defaults.Debug {
+			m.printf("cannot read systemd timers: %s\n", err)
+		}
+		return
+	}
+	window := m.interval + adviceSlack
+	for _, timer := range all {
+		if timer.LastTrigger.IsZero() || timer.LastTrigger.After(m.now) || m.now.Sub(timer.LastTrigger) > window {
+			continue
+		}
+		advice, found := m.advice[timer.Name]
+		if !found {
+			advice = &Advice{
+				Timer:       timer.Name,
+				Description: timer.Description,
+				OnCalendar:  timer.OnCalendar,
+				Suggestion:  suggestion(timer.Name),
+			}
+			m.advice[timer.Name] = advice
+		}
+		advice.Disks = appendUnique(advice.Disks, m.displayName(disk))
+		advice.Wakes++
+		advice.LastWakeAt = m.now
+		m.printf("advice: %s woke up %s after %s ran (%d times so far), %s\n", m.displayName(disk),
+			m.now.Sub(timer.LastTrigger).Round(time.Second), timer.Name, advice.Wakes, advice.Suggestion)
+	}
+}
+
+// Advice returns the timers blamed for waking disks up, the most frequent
+// first.
+func (m *Monitor) Advice() []Advice {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var advice []Advice
+	for _, a := range m.advice {
+		c := *a
+		c.Disks = append([]string(nil), a.Disks...)
+		advice = append(advice, c)
+	}
+	sort.Slice(advice, func(i, j int) bool {
+		if advice[i].Wakes != advice[j].Wakes {
+			return advice[i].Wakes > advice[j].Wakes
+		}
+		return advice[i].Timer < advice[j].Timer
+	})
+	return advice
+}
@@ -0,0 +1,76 @@
+// hd-idle - spin down idle hard disks
+// Copyright (C) 2018  Andoni del Olmo
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package hdidle
+
+import (
+	"github.com/adelolmo/hd-idle/timers"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestAdviseOnWake(t *testing.T) {
+	dir, err := ioutil.TempDir("", "timers")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	unitDirs, stampDir := timers.UnitDirs, timers.StampDir
+	defer func() { timers.UnitDirs, timers.StampDir = unitDirs, stampDir }()
+	timers.UnitDirs = []string{dir}
+	timers.StampDir = dir
+
+	now := time.Now()
+	for name, triggeredAt := range map[string]time.Time{
+		"mlocate.timer": now.Add(-30 * time.Second),
+		"fstrim.timer":  now.Add(-time.Hour),
+	} {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte("[Timer]\nOnCalendar=daily\nPersistent=true\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		stamp := filepath.Join(dir, "stamp-"+name)
+		if err := ioutil.WriteFile(stamp, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(stamp, triggeredAt, triggeredAt); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	config := NewConfig()
+	config.Defaults.Advisor = true
+	m := New(config)
+	m.SetOutput(ioutil.Discard)
+	m.now = now
+	m.adviseOnWake("sda")
+	m.adviseOnWake("sdb")
+
+	expected := []Advice{{
+		Timer:      "mlocate.timer",
+		OnCalendar: []string{"daily"},
+		Disks:      []string{"sda", "sdb"},
+		Wakes:      2,
+		LastWakeAt: now,
+		Suggestion: "add the mount points of the disk to PRUNEPATHS in /etc/updatedb.conf",
+	}}
+	if advice := m.Advice(); !reflect.DeepEqual(advice, expected) {
+		t.Fatalf("Expected %+v but found %+v", expected, advice)
+	}
+}
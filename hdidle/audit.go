@@ -0,0 +1,135 @@
+// hd-idle - spin down idle hard disks
+// Copyright (C) 2018  Andoni del Olmo
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package hdidle
+
+import (
+	"fmt"
+	"github.com/adelolmo/hd-idle/sgio"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+/* replaced in tests */
+var procSelfFd = "/proc/self/fd"
+
+/*
+ * The commands that may reach a disk hd-idle spun down: the safe probes,
+ * which answer without waking it up, and those spinning it up on purpose.
+ */
+var standbyCommands = map[string]bool{
+	"ATA CHECK POWER MODE": true,
+	"TEST UNIT READY":      true,
+	"START STOP UNIT":      true,
+	"ATA IDLE IMMEDIATE":   true,
+}
+
+/*
+ * With --audit-opens every device open is written to a file, with whether
+ * the disk was spun down and the command allowed. Opens happen on the
+ * goroutines of the commands, so the audit has a lock of its own and the
+ * disks spun down as of the last cycle.
+ */
+type openAudit struct {
+	mu      sync.Mutex
+	file    string
+	standby map[string]bool
+	out     func(format string, a ...interface{})
+}
+
+/* install the hook of the sgio package, undone by the returned function */
+func (m *Monitor) startOpenAudit() func() {
+	file := m.config.Defaults.AuditOpens
+	if len(file) == 0 {
+		return func() {}
+	}
+	m.audit = &openAudit{file: file, standby: map[string]bool{}, out: m.printf}
+	sgio.OpenHook = m.audit.open
+	return func() { sgio.OpenHook = nil }
+}
+
+func (a *openAudit) open(device, command string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	disk := filepath.Base(device)
+	standby := a.standby[disk]
+	allowed := !standby || standbyCommands[command]
+	if !allowed {
+		a.out("disk=%s opened in standby for %s, which may wake it up\n", disk, command)
+	}
+	now := time.Now()
+	a.write(fmt.Sprintf("date: %s, time: %s, disk: %s, command: %s, standby: %t, allowed: %t",
+		now.Format("2006-01-02"), now.Format("15:04:05"), disk, command, standby, allowed))
+}
+
+func (a *openAudit) write(text string) {
+	if err := appendToFile(a.file, text); err != nil {
+		a.out("%s\n", err)
+	}
+}
+
+/*
+ * Take the disks spun down in this cycle for the opens until the next one,
+ * and check no device is left open between commands. Disks whose command
+ * still hangs are open on purpose, see runWithWatchdog.
+ */
+func (m *Monitor) auditCycle() {
+	if m.audit == nil {
+		return
+	}
+	standby := map[string]bool{}
+	for _, ds := range m.snapshots {
+		standby[ds.Name] = ds.SpunDown
+	}
+	held := m.heldDevices()
+
+	m.audit.mu.Lock()
+	defer m.audit.mu.Unlock()
+	m.audit.standby = standby
+	for _, disk := range held {
+		if m.stuck[disk] {
+			continue
+		}
+		m.printf("disk=%s held open between commands\n", disk)
+		m.audit.write(fmt.Sprintf("date: %s, time: %s, disk: %s, held open: true",
+			m.now.Format("2006-01-02"), m.now.Format("15:04:05"), disk))
+	}
+}
+
+/* the monitored disks hd-idle has a file descriptor of, it only opens whole disks */
+func (m *Monitor) heldDevices() []string {
+	fds, err := ioutil.ReadDir(procSelfFd)
+	if err != nil {
+		return nil
+	}
+	open := map[string]bool{}
+	for _, fd := range fds {
+		if target, err := os.Readlink(filepath.Join(procSelfFd, fd.Name())); err == nil {
+			open[target] = true
+		}
+	}
+	var held []string
+	for _, ds := range m.snapshots {
+		if open["/dev/"+ds.Name] {
+			held = append(held, ds.Name)
+		}
+	}
+	return held
+}
@@ -0,0 +1,79 @@
+// hd-idle - spin down idle hard disks
+// Copyright (C) 2018  Andoni del Olmo
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package hdidle
+
+import (
+	"github.com/adelolmo/hd-idle/diskstats"
+	"github.com/adelolmo/hd-idle/sgio"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestAuditOpens(t *testing.T) {
+	dir, err := ioutil.TempDir("", "audit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	fds := filepath.Join(dir, "fd")
+	mustMkdir(t, fds)
+	if err := os.Symlink("/dev/sdc", filepath.Join(fds, "7")); err != nil {
+		t.Fatal(err)
+	}
+	defer func(fd string) { procSelfFd = fd }(procSelfFd)
+	procSelfFd = fds
+
+	config := NewConfig()
+	config.Defaults.AuditOpens = filepath.Join(dir, "opens.log")
+	m := New(config)
+	var out strings.Builder
+	m.SetOutput(&out)
+	defer m.startOpenAudit()()
+	m.snapshots = []diskstats.DiskStats{{Name: "sdb", SpunDown: true}, {Name: "sdc"}}
+	m.auditCycle()
+
+	/* the device doesn't exist, the open is still audited */
+	sgio.AtaStandby(filepath.Join(dir, "sdb"))
+	sgio.ReadAtaSmart(filepath.Join(dir, "sdb"))
+	sgio.ReadAtaSmart(filepath.Join(dir, "sdc"))
+
+	b, err := ioutil.ReadFile(config.Defaults.AuditOpens)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, expected := range []string{
+		"disk: sdc, held open: true",
+		"disk: sdb, command: ATA CHECK POWER MODE, standby: true, allowed: true",
+		"disk: sdb, command: ATA SMART READ DATA, standby: true, allowed: false",
+		"disk: sdc, command: ATA SMART READ DATA, standby: false, allowed: true",
+	} {
+		if !strings.Contains(string(b), expected) {
+			t.Errorf("Expected %q in the audit but found:\n%s", expected, b)
+		}
+	}
+	for _, expected := range []string{
+		"disk=sdc held open between commands",
+		"disk=sdb opened in standby for ATA SMART READ DATA, which may wake it up",
+	} {
+		if !strings.Contains(out.String(), expected) {
+			t.Errorf("Expected %q in the output but found:\n%s", expected, out.String())
+		}
+	}
+}
@@ -0,0 +1,65 @@
+// hd-idle - spin down idle hard disks
+// Copyright (C) 2018  Andoni del Olmo
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package hdidle
+
+import (
+	"fmt"
+	"github.com/adelolmo/hd-idle/sgio"
+)
+
+/* replaced in tests */
+var backgroundActivity = BackgroundActivity
+
+// BackgroundActivity tells what maintenance the device runs on its own, asking
+// it with the command type: the SMART offline data collection or self-test of
+// ata disks, the background medium scan of scsi ones. Empty if none.
+func BackgroundActivity(device, command string) (string, error) {
+	check := sgio.ScsiBackgroundScan
+	if command == ATA {
+		check = sgio.AtaBackgroundActivity
+	}
+	activity, err := check(device)
+	if err != nil {
+		return "", deviceError(err, fmt.Errorf("cannot check background activity of %s disk %s: %s", command, device, err))
+	}
+	return activity, nil
+}
+
+/*
+ * With --background-check the drive is asked before each spin down, and the
+ * spin down waits while a media scan or a self-test runs, or it starts over
+ * at the next spinup. Drives that cannot tell are spun down as usual. Empty
+ * when the spin down need not wait.
+ */
+func (m *Monitor) backgroundActivityOf(disk, command string) string {
+	if !m.config.Defaults.BackgroundCheck {
+		return ""
+	}
+	var activity string
+	err := m.runWithWatchdog(disk, func() error {
+		var err error
+		activity, err = backgroundActivity(fmt.Sprintf("/dev/%s", disk), command)
+		return err
+	})
+	if err != nil {
+		if m.debug(disk) {
+			m.printf("disk=%s %s\n", disk, err)
+		}
+		return ""
+	}
+	return activity
+}
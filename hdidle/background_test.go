@@ -0,0 +1,71 @@
+// hd-idle - spin down idle hard disks
+// Copyright (C) 2018  Andoni del Olmo
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package hdidle
+
+import (
+	"github.com/adelolmo/hd-idle/diskstats"
+	"io/ioutil"
+	"testing"
+	"time"
+)
+
+func TestSpindownWaitsForBackgroundScan(t *testing.T) {
+	scanning := true
+	var asked []string
+	backgroundActivity = func(device, command string) (string, error) {
+		asked = append(asked, device)
+		if scanning {
+			return "background medium scan", nil
+		}
+		return "", nil
+	}
+	defer func() { backgroundActivity = BackgroundActivity }()
+
+	simulation, err := ParseSimulation("dry-run")
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := NewConfig()
+	config.Defaults.Simulation = simulation
+	config.Defaults.Idle = time.Minute
+	config.Defaults.BackgroundCheck = true
+	config.SkewTime = 24 * time.Hour
+	m := New(config)
+	m.SetOutput(ioutil.Discard)
+
+	start := time.Now()
+	cycle := func(minutes int) bool {
+		m.now = start.Add(time.Duration(minutes) * time.Minute)
+		m.updateState(diskstats.DiskStats{Name: "sda"})
+		m.lastNow = m.now
+		return m.snapshots[0].SpunDown
+	}
+	cycle(0)
+	if len(asked) != 0 {
+		t.Fatalf("Expected the disk left alone before its idle time but it was asked %v", asked)
+	}
+	if cycle(2) {
+		t.Fatalf("Expected sda spinning during the scan")
+	}
+	scanning = false
+	if !cycle(3) {
+		t.Fatalf("Expected sda spun down after the scan")
+	}
+	if len(asked) != 2 || asked[0] != "/dev/sda" {
+		t.Fatalf("Expected sda asked twice but found %v", asked)
+	}
+}
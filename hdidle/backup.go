@@ -0,0 +1,69 @@
+// hd-idle - spin down idle hard disks
+// Copyright (C) 2018  Andoni del Olmo
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package hdidle
+
+import (
+	"fmt"
+	"time"
+)
+
+/* a backup disk from the moment it is plugged in until it is safe to remove */
+type backupRun struct {
+	attachedAt time.Time
+	writes     int
+}
+
+/* disks with --backup-window wait for their backup once plugged in */
+func (m *Monitor) startBackup(disk string, writes int) {
+	window := m.config.deviceConfig(disk).BackupWindow
+	if window == 0 {
+		return
+	}
+	m.backups[disk] = &backupRun{attachedAt: m.now, writes: writes}
+	m.printf("%s plugged in, waiting up to %v for the backup\n", m.displayName(disk), window)
+}
+
+/*
+ * The backup has not started yet and the window is still open: the disk stays
+ * up, however long it is idle. Once written to, the idle time marks the end
+ * of the backup.
+ */
+func (m *Monitor) waitingForBackup(disk string, writes int) bool {
+	run, found := m.backups[disk]
+	if !found || writes != run.writes {
+		return false
+	}
+	return m.now.Sub(run.attachedAt) < m.config.deviceConfig(disk).BackupWindow
+}
+
+/* after the spindown that ends the backup, tell the disk can be unplugged */
+func (m *Monitor) finishBackup(disk string, writes int) {
+	run, found := m.backups[disk]
+	if !found {
+		return
+	}
+	delete(m.backups, disk)
+	code, message := "BACKUP_DONE", "backup done, safe to remove"
+	if writes == run.writes {
+		code = "BACKUP_WINDOW_EXPIRED"
+		message = fmt.Sprintf("nothing written within %v, safe to remove", m.config.deviceConfig(disk).BackupWindow)
+	}
+	m.printf("%s %s\n", m.displayName(disk), message)
+	m.emitCode(EventSafeToRemove, disk, code, message)
+	m.logToFile(m.logFileOf(disk), fmt.Sprintf("date: %s, time: %s, disk: %s, %s",
+		m.now.Format("2006-01-02"), m.now.Format("15:04:05"), m.displayName(disk), message))
+}
@@ -0,0 +1,89 @@
+// hd-idle - spin down idle hard disks
+// Copyright (C) 2018  Andoni del Olmo
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package hdidle
+
+import (
+	"github.com/adelolmo/hd-idle/diskstats"
+	"io/ioutil"
+	"testing"
+	"time"
+)
+
+func TestBackupDiskSafeToRemove(t *testing.T) {
+	simulation, err := ParseSimulation("dry-run")
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := NewConfig()
+	config.Defaults.Simulation = simulation
+	config.SkewTime = 24 * time.Hour
+	config.Devices = []DeviceConf{{Name: "sdb", CommandType: SCSI, Idle: time.Minute, BackupWindow: time.Hour}}
+	m := New(config)
+	m.SetOutput(ioutil.Discard)
+	events, cancel := m.Subscribe("sdb")
+	defer cancel()
+
+	start := time.Now()
+	cycle := func(now time.Time, writes int) {
+		m.now = now
+		m.updateState(diskstats.DiskStats{Name: "sdb", Writes: writes})
+		m.lastNow = now
+	}
+	cycle(start, 5)
+	m.snapshots[0].LastIoAt = start
+
+	cycle(start.Add(30*time.Minute), 5)
+	if m.snapshots[0].SpunDown {
+		t.Fatal("Expected no spindown before the backup")
+	}
+	cycle(start.Add(40*time.Minute), 9)
+	cycle(start.Add(45*time.Minute), 9)
+	if !m.snapshots[0].SpunDown {
+		t.Fatal("Expected a spindown once the backup is done")
+	}
+	if event := <-events; event.Type != EventSpindown {
+		t.Fatalf("Expected %s but found %s", EventSpindown, event.Type)
+	}
+	if event := <-events; event.Type != EventSafeToRemove || event.Message != "backup done, safe to remove" {
+		t.Fatalf("Expected %s but found %+v", EventSafeToRemove, event)
+	}
+	if len(m.backups) != 0 {
+		t.Fatal("Expected the backup finished")
+	}
+}
+
+func TestBackupWindowExpires(t *testing.T) {
+	config := NewConfig()
+	config.Devices = []DeviceConf{{Name: "sdb", BackupWindow: time.Hour}}
+	m := New(config)
+	m.SetOutput(ioutil.Discard)
+
+	start := time.Now()
+	m.now = start
+	m.startBackup("sdb", 5)
+	if !m.waitingForBackup("sdb", 5) {
+		t.Fatal("Expected to wait for the backup")
+	}
+	m.now = start.Add(time.Hour)
+	if m.waitingForBackup("sdb", 5) {
+		t.Fatal("Expected no more waiting once the window is over")
+	}
+	m.startBackup("sdc", 5)
+	if _, found := m.backups["sdc"]; found {
+		t.Fatal("Expected no backup for a disk without --backup-window")
+	}
+}
@@ -0,0 +1,62 @@
+// hd-idle - spin down idle hard disks
+// Copyright (C) 2018  Andoni del Olmo
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package hdidle
+
+import (
+	"fmt"
+	"time"
+)
+
+/* consecutive command failures of a disk, and the end of its quarantine */
+type breaker struct {
+	failures  int
+	openUntil time.Time
+}
+
+/* a quarantined disk gets no commands and no probing until the cooldown ends */
+func (m *Monitor) quarantined(name string) bool {
+	b, found := m.breakers[name]
+	return found && m.now.Before(b.openUntil)
+}
+
+func (m *Monitor) recordResult(name string, err error) {
+	threshold := m.config.Defaults.BreakerThreshold
+	if threshold == 0 {
+		return
+	}
+	b, found := m.breakers[name]
+	if !found {
+		b = &breaker{}
+		m.breakers[name] = b
+	}
+	if err == nil {
+		b.failures = 0
+		return
+	}
+	b.failures++
+	if b.failures < threshold {
+		return
+	}
+	/* after the cooldown a single attempt decides whether to quarantine again */
+	b.failures = threshold - 1
+	b.openUntil = m.now.Add(m.config.Defaults.BreakerCooldown)
+	message := fmt.Sprintf("%d consecutive failures, no commands until %s", threshold, b.openUntil.Format(dateFormat))
+	m.printf("%s quarantined, %s\n", m.displayName(name), message)
+	m.emit(EventQuarantined, name, message)
+	m.logToFile(m.logFileOf(name), fmt.Sprintf("date: %s, time: %s, disk: %s, quarantined until %s",
+		m.now.Format("2006-01-02"), m.now.Format("15:04:05"), m.displayName(name), b.openUntil.Format(dateFormat)))
+}
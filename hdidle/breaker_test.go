@@ -0,0 +1,63 @@
+// hd-idle - spin down idle hard disks
+// Copyright (C) 2018  Andoni del Olmo
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package hdidle
+
+import (
+	"errors"
+	"io/ioutil"
+	"testing"
+	"time"
+)
+
+func TestBreakerQuarantinesFailingDisk(t *testing.T) {
+	config := NewConfig()
+	config.Defaults.WatchdogFactor = 0
+	config.Defaults.BreakerThreshold = 2
+	config.Defaults.BreakerCooldown = time.Hour
+	m := New(config)
+	m.SetOutput(ioutil.Discard)
+	fail := func() error { return errors.New("i/o error") }
+	succeed := func() error { return nil }
+
+	_ = m.deviceCommand("sda", fail)
+	_ = m.deviceCommand("sda", succeed)
+	_ = m.deviceCommand("sda", fail)
+	if m.quarantined("sda") {
+		t.Fatal("Expected sda not to be quarantined after a success in between")
+	}
+
+	_ = m.deviceCommand("sda", fail)
+	if !m.quarantined("sda") {
+		t.Fatal("Expected sda to be quarantined after 2 failures in a row")
+	}
+
+	/* after the cooldown a single failure quarantines again */
+	m.now = m.now.Add(time.Hour + time.Second)
+	if m.quarantined("sda") {
+		t.Fatal("Expected the quarantine of sda to end after the cooldown")
+	}
+	_ = m.deviceCommand("sda", fail)
+	if !m.quarantined("sda") {
+		t.Fatal("Expected sda to be quarantined again")
+	}
+
+	m.now = m.now.Add(time.Hour + time.Second)
+	_ = m.deviceCommand("sda", succeed)
+	if m.quarantined("sda") || m.breakers["sda"].failures != 0 {
+		t.Fatal("Expected sda to be healthy after a success")
+	}
+}
@@ -0,0 +1,383 @@
+// hd-idle - spin down idle hard disks
+// Copyright (C) 2018  Andoni del Olmo
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package hdidle
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+const (
+	SCSI = "scsi"
+	ATA  = "ata"
+
+	DefaultIdleTime           = 600 * time.Second
+	DefaultSshdIdleTime       = 1800 * time.Second
+	DefaultSmrIdleTime        = 1800 * time.Second
+	DefaultLogFallbackTimeout = time.Hour
+	DefaultWatchdogFactor     = 10
+	DefaultBreakerThreshold   = 3
+	DefaultBreakerCooldown    = time.Hour
+	DefaultWaitMountTimeout   = 10 * time.Minute
+	DefaultWakeStormWindow    = time.Minute
+	DefaultStateDir           = "/var/lib/hd-idle"
+	DefaultProbeWindow        = 2 * time.Minute
+	DefaultManualHold         = 30 * time.Minute
+	DailyReportOff            = time.Duration(-1) // no daily report
+
+	SymlinkResolveOnce       = 0
+	SymlinkResolveRetry      = 1
+	SymlinkResolveContinuous = 2 // every cycle, following a link to another disk
+	SymlinkResolveRequired   = 3 // once, failing when the disk is not plugged in
+
+	// SkewDisabled as skew time of a disk never takes a long cycle for a
+	// suspend, e.g. for an enclosure sleeping on its own.
+	SkewDisabled time.Duration = -1
+)
+
+// The config tag of a field names the key of the configuration file setting
+// it, ConfigSchema is generated from them.
+type DefaultConf struct {
+	Idle               time.Duration            `config:"idle"`
+	BatteryIdle        time.Duration            `config:"battery_idle"`        // instead of Idle while on battery, 0 for the same
+	ProfileIdles       map[string]time.Duration `config:"profile.<name>.idle"` // instead of Idle while the profile of the name is active
+	Profile            string                   `config:"profile"`             // active at start, none if empty
+	CommandType        string                   `config:"command_type"`
+	Debug              bool                     `config:"debug"`
+	LogFile            string                   `config:"log_file"`
+	LogBuffer          bool
+	LogFallback        string
+	LogFallbackTimeout time.Duration
+	LogFormat          string        `config:"log_format"` // how events are written to the standard output and the log file
+	TraceFile          string        // where to record which disks had I/O in each cycle
+	AuditOpens         string        // where to record every device open
+	WatchConfig        bool          // reload the configuration files when they change
+	SelfMetrics        bool          // measure the resource usage of hd-idle after every cycle
+	DailyReport        time.Duration // time of day the daily report is written, since midnight, DailyReportOff for none
+	StackedIo          bool          // count the I/O of loop and device mapper devices as I/O of the disks underneath
+	Exclude            []string      // disks never managed, as given with -x
+	OptIn              bool          `config:"opt_in"` // manage only the disks named with -a, Idle only applies to them
+	ManageRoot         bool          // manage the disks of the root filesystem too
+	ManageSwap         bool          // manage the disks holding active swap too
+	ManualHold         time.Duration // a disk spun up by hand isn't spun down before, 0 for no hold
+	Exports            bool          // defer spin downs while iSCSI or NBD clients are connected
+	SshdIdle           time.Duration // of hybrid drives not named with -a
+	SmrIdle            time.Duration // of shingled (SMR) drives not named with -a
+	SmrGcWait          time.Duration // since the last write of an SMR drive before spinning it down
+	PowerDrop          float64       // watts a spin down must save on the power meter of the disk
+	SymlinkPolicy      int           `config:"symlink_policy"`
+	ReadOnly           bool
+	UsbPowerOff        bool `config:"usb_power_off"`
+	SataLpm            string
+	WaitMounts         []string
+	WaitMountTimeout   time.Duration
+	HbaRuntimePm       bool
+	UsbHubSpacing      time.Duration // between commands to disks on the same usb hub
+	SmartInterval      time.Duration
+	StandbyReadAhead   int // KiB, negative to leave the read-ahead alone
+	StateDir           string
+	CheckpointInterval time.Duration // between saves of the statistics, 0 keeps them in memory only
+	Unsupported        string        // what to do with disks rejecting the spindown command
+	Replacement        string        // what to do with disks taking the slot of a configured disk
+	Simulation         *Simulation
+	WakeStormDisks     int
+	WakeStormWindow    time.Duration
+	Advisor            bool
+	WatchdogFactor     int
+	BreakerThreshold   int
+	BreakerCooldown    time.Duration
+	AwakeWindows       []AwakeWindow
+	InhibitSuspend     bool
+	CrashDir           string // where crash reports are written, none if empty
+	BackgroundCheck    bool   // defer spin downs while the drive scans its media or runs a self-test
+	QuirksFile         string
+	ApiTokens          string // file of the tokens of the API clients
+	LearnQuirks        bool
+	ProbeWindow        time.Duration // reads of a disk that just appeared are taken for udev probing it
+	Listen             []string
+	Control            bool     // let --listen clients change the sinks and the log file
+	ControlListen      []string // addresses whose clients can always make changes
+	ReadAllow          []string // users and @groups that may read from a unix socket, empty for everyone
+	ControlAllow       []string // users and @groups that may make changes through a unix socket, empty for root
+	Webhooks           []string
+	Push               string
+	PushInterval       time.Duration
+}
+
+type DeviceConf struct {
+	Name          string
+	GivenName     string
+	Idle          time.Duration            `config:"idle"`
+	BatteryIdle   time.Duration            `config:"battery_idle"`        // instead of Idle while on battery, 0 for the same
+	ProfileIdles  map[string]time.Duration `config:"profile.<name>.idle"` // instead of Idle while the profile of the name is active
+	CommandType   string                   `config:"command_type"`
+	UsbPowerOff   bool                     `config:"usb_power_off"`
+	SataLpm       string
+	WaitMounts    []string
+	AwakeWindows  []AwakeWindow
+	Alias         string `config:"alias"`
+	Class         string
+	WakeWith      []string          // disks whose spin up wakes this one too
+	BackupWindow  time.Duration     // how long a backup disk waits for its backup once plugged in
+	Passthrough   string            // what to do while a virtual machine has the disk, leave or shutoff
+	ManageSsd     bool              // manage the disk even if it is not rotational
+	PowerMeter    string            // the meter of the plug or UPS outlet the disk's enclosure draws power from
+	Namespace     string            `config:"namespace"`      // the group of disks API tokens can be limited to, e.g. media
+	SkewTime      time.Duration     `config:"skew"`           // overrides Config.SkewTime when not 0, SkewDisabled for never
+	LogFile       string            `config:"log_file"`       // overrides Defaults.LogFile for the records of the disk
+	SymlinkPolicy int               `config:"symlink_policy"` // how a disk named by a symlink is resolved, as -s
+	Debug         bool              `config:"debug"`          // print the debug output of the disk even without Defaults.Debug
+	Tags          map[string]string `config:"tag.<key>"`      // of the user, e.g. location=rack2, attached to the events and status of the disk
+}
+
+// ClassConf holds the settings shared by a class of disks, e.g. archive.
+type ClassConf struct {
+	Name         string
+	Idle         time.Duration
+	BatteryIdle  time.Duration
+	ProfileIdles map[string]time.Duration
+	CommandType  string
+	UsbPowerOff  bool
+	SataLpm      string
+	WaitMounts   []string
+	AwakeWindows []AwakeWindow
+}
+
+type Config struct {
+	Devices  []DeviceConf
+	Classes  []ClassConf
+	Defaults DefaultConf
+	SkewTime time.Duration
+	Files    []string // the configuration files read, the included ones too
+}
+
+// NewConfig returns a configuration with the default settings and no devices.
+func NewConfig() *Config {
+	return &Config{
+		Devices: []DeviceConf{},
+		Defaults: DefaultConf{
+			Idle:               DefaultIdleTime,
+			SshdIdle:           DefaultSshdIdleTime,
+			SmrIdle:            DefaultSmrIdleTime,
+			PowerDrop:          DefaultPowerDrop,
+			CommandType:        SCSI,
+			Debug:              false,
+			SymlinkPolicy:      SymlinkResolveOnce,
+			LogFallbackTimeout: DefaultLogFallbackTimeout,
+			LogFormat:          LogFormatText,
+			WatchdogFactor:     DefaultWatchdogFactor,
+			BreakerThreshold:   DefaultBreakerThreshold,
+			BreakerCooldown:    DefaultBreakerCooldown,
+			WaitMountTimeout:   DefaultWaitMountTimeout,
+			WakeStormWindow:    DefaultWakeStormWindow,
+			StandbyReadAhead:   -1,
+			StateDir:           DefaultStateDir,
+			ProbeWindow:        DefaultProbeWindow,
+			ManualHold:         DefaultManualHold,
+			Unsupported:        UnsupportedGiveUp,
+			Replacement:        ReplacementOff,
+		},
+	}
+}
+
+/* the skew time of a disk, SkewDisabled if a long cycle is never taken for a suspend */
+func (c *Config) skewTime(diskName string) time.Duration {
+	if skew := c.deviceConfig(diskName).SkewTime; skew != 0 {
+		return skew
+	}
+	return c.SkewTime
+}
+
+/*
+ * The settings of a disk: those of the device named after it, or else of the
+ * most specific pattern matching it, or else the defaults.
+ */
+func (c *Config) deviceConfig(diskName string) *DeviceConf {
+	var match *DeviceConf
+	rank := -1
+	for _, device := range c.Devices {
+		if device.Name == diskName {
+			return &device
+		}
+		if r := device.patternRank(diskName); r > rank {
+			device := device
+			match, rank = &device, r
+		}
+	}
+	if match != nil {
+		return match
+	}
+	return &DeviceConf{
+		Name:         diskName,
+		CommandType:  c.Defaults.CommandType,
+		Idle:         c.Defaults.Idle,
+		BatteryIdle:  c.Defaults.BatteryIdle,
+		ProfileIdles: c.Defaults.ProfileIdles,
+		UsbPowerOff:  c.Defaults.UsbPowerOff,
+		SataLpm:      c.Defaults.SataLpm,
+		WaitMounts:   c.Defaults.WaitMounts,
+		AwakeWindows: c.Defaults.AwakeWindows,
+	}
+}
+
+/* whether any disk has a battery idle time */
+func (c *Config) batteryIdles() bool {
+	if c.Defaults.BatteryIdle != 0 {
+		return true
+	}
+	for _, device := range c.Devices {
+		if device.BatteryIdle != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+/* the names of the profiles giving any disk an idle time, sorted */
+func (c *Config) profiles() []string {
+	names := map[string]bool{}
+	for name := range c.Defaults.ProfileIdles {
+		names[name] = true
+	}
+	for _, class := range c.Classes {
+		for name := range class.ProfileIdles {
+			names[name] = true
+		}
+	}
+	for _, device := range c.Devices {
+		for name := range device.ProfileIdles {
+			names[name] = true
+		}
+	}
+	var sorted []string
+	for name := range names {
+		sorted = append(sorted, name)
+	}
+	sort.Strings(sorted)
+	return sorted
+}
+
+/* whether a -a names the disk, by its name or a pattern */
+func (c *Config) named(diskName string) bool {
+	for _, device := range c.Devices {
+		if device.Name == diskName || device.patternRank(diskName) >= 0 {
+			return true
+		}
+	}
+	return false
+}
+
+/* the log file given with -l and those of the disks having their own, each once */
+func (c *Config) logFiles() []string {
+	all := []string{c.Defaults.LogFile}
+	for _, device := range c.Devices {
+		all = append(all, device.LogFile)
+	}
+	var files []string
+	seen := map[string]bool{}
+	for _, file := range all {
+		if len(file) > 0 && !seen[file] {
+			files = append(files, file)
+			seen[file] = true
+		}
+	}
+	return files
+}
+
+/* the log files given with -l, --disk-log and --log-fallback */
+func (c *Config) configuredLogFiles() []string {
+	files := c.logFiles()
+	if len(c.Defaults.LogFallback) > 0 {
+		files = append(files, c.Defaults.LogFallback)
+	}
+	return files
+}
+
+// WritablePaths lists the files hd-idle writes to with this configuration.
+func (c *Config) WritablePaths() []string {
+	var paths []string
+	for _, path := range append(c.logFiles(), c.Defaults.LogFallback, c.Defaults.TraceFile, c.Defaults.AuditOpens, c.Defaults.CrashDir) {
+		if len(path) > 0 {
+			paths = append(paths, path)
+		}
+	}
+	if c.Defaults.StandbyReadAhead >= 0 {
+		paths = append(paths, filepath.Join(c.Defaults.StateDir, readAheadStateFile))
+	}
+	if c.Defaults.Replacement != ReplacementOff {
+		paths = append(paths, filepath.Join(c.Defaults.StateDir, slotsStateFile))
+	}
+	if c.Defaults.LearnQuirks {
+		paths = append(paths, filepath.Join(c.Defaults.StateDir, learnedStateFile))
+	}
+	if c.Defaults.CheckpointInterval > 0 {
+		paths = append(paths, filepath.Join(c.Defaults.StateDir, statisticsStateFile),
+			filepath.Join(c.Defaults.StateDir, epochsStateFile))
+	}
+	return paths
+}
+
+// Class returns the class with the given name, nil if there is none.
+func (c *Config) Class(name string) *ClassConf {
+	for i := range c.Classes {
+		if c.Classes[i].Name == name {
+			return &c.Classes[i]
+		}
+	}
+	return nil
+}
+
+func (c *Config) String() string {
+	var devices string
+	for _, device := range c.Devices {
+		devices += "{" + device.String() + "}"
+	}
+	var classes string
+	for _, class := range c.Classes {
+		classes += "{" + class.String() + "}"
+	}
+	return fmt.Sprintf("symlinkPolicy=%d, defaultIdle=%v, batteryIdle=%v, profileIdles=%s, profile=%s, skew=%v, defaultCommand=%s, debug=%t, logFile=%s, logBuffer=%t, "+
+		"logFallback=%s, logFallbackTimeout=%v, logFormat=%s, trace=%s, auditOpens=%s, watchConfig=%t, selfMetrics=%t, dailyReport=%s, stackedIo=%t, exclude=%v, optIn=%t, manageRoot=%t, manageSwap=%t, manualHold=%v, exports=%t, sshdIdle=%v, smrIdle=%v, smrGcWait=%v, powerDrop=%.1f, readOnly=%t, usbPowerOff=%t, sataLpm=%s, waitMounts=%v, waitMountTimeout=%v, hbaRuntimePm=%t, usbHubSpacing=%v, smartInterval=%v, standbyReadAhead=%d, stateDir=%s, checkpointInterval=%v, unsupported=%s, replacement=%s, wakeStormDisks=%d, wakeStormWindow=%v, advisor=%t, watchdog=%d, breakerThreshold=%d, breakerCooldown=%v, awake=%v, inhibitSuspend=%t, backgroundCheck=%t, crashDir=%s, "+
+		"quirksFile=%s, learnQuirks=%t, probeWindow=%v, apiTokens=%s, listen=%v, control=%t, controlListen=%v, readAllow=%v, controlAllow=%v, webhooks=%v, push=%s, pushInterval=%v, classes=%s, devices=%s",
+		c.Defaults.SymlinkPolicy, FormatDuration(c.Defaults.Idle), FormatDuration(c.Defaults.BatteryIdle), formatProfileIdles(c.Defaults.ProfileIdles), c.Defaults.Profile, FormatDuration(c.SkewTime), c.Defaults.CommandType, c.Defaults.Debug,
+		c.Defaults.LogFile, c.Defaults.LogBuffer, c.Defaults.LogFallback, FormatDuration(c.Defaults.LogFallbackTimeout), c.Defaults.LogFormat,
+		c.Defaults.TraceFile, c.Defaults.AuditOpens, c.Defaults.WatchConfig, c.Defaults.SelfMetrics, FormatDailyReport(c.Defaults.DailyReport), c.Defaults.StackedIo, c.Defaults.Exclude, c.Defaults.OptIn, c.Defaults.ManageRoot, c.Defaults.ManageSwap, FormatDuration(c.Defaults.ManualHold), c.Defaults.Exports, FormatDuration(c.Defaults.SshdIdle),
+		FormatDuration(c.Defaults.SmrIdle), FormatDuration(c.Defaults.SmrGcWait), c.Defaults.PowerDrop,
+		c.Defaults.ReadOnly, c.Defaults.UsbPowerOff, c.Defaults.SataLpm, c.Defaults.WaitMounts,
+		FormatDuration(c.Defaults.WaitMountTimeout), c.Defaults.HbaRuntimePm, FormatDuration(c.Defaults.UsbHubSpacing), FormatDuration(c.Defaults.SmartInterval), c.Defaults.StandbyReadAhead, c.Defaults.StateDir, FormatDuration(c.Defaults.CheckpointInterval), c.Defaults.Unsupported, c.Defaults.Replacement,
+		c.Defaults.WakeStormDisks, FormatDuration(c.Defaults.WakeStormWindow), c.Defaults.Advisor, c.Defaults.WatchdogFactor,
+		c.Defaults.BreakerThreshold, FormatDuration(c.Defaults.BreakerCooldown), c.Defaults.AwakeWindows, c.Defaults.InhibitSuspend, c.Defaults.BackgroundCheck, c.Defaults.CrashDir,
+		c.Defaults.QuirksFile, c.Defaults.LearnQuirks, FormatDuration(c.Defaults.ProbeWindow), c.Defaults.ApiTokens, c.Defaults.Listen, c.Defaults.Control, c.Defaults.ControlListen, c.Defaults.ReadAllow, c.Defaults.ControlAllow, c.Defaults.Webhooks, c.Defaults.Push,
+		FormatDuration(c.Defaults.PushInterval), classes, devices)
+}
+
+func (dc *DeviceConf) String() string {
+	skew := FormatDuration(dc.SkewTime)
+	if dc.SkewTime == SkewDisabled {
+		skew = "off"
+	}
+	return fmt.Sprintf("name=%s, givenName=%s, alias=%s, class=%s, idle=%v, batteryIdle=%v, profileIdles=%s, commandType=%s, usbPowerOff=%t, sataLpm=%s, waitMounts=%v, awake=%v, wakeWith=%v, backupWindow=%v, passthrough=%s, manageSsd=%t, powerMeter=%s, namespace=%s, tags=%s, skew=%v, logFile=%s, symlinkPolicy=%d, debug=%t",
+		dc.Name, dc.GivenName, dc.Alias, dc.Class, FormatDuration(dc.Idle), FormatDuration(dc.BatteryIdle), formatProfileIdles(dc.ProfileIdles), dc.CommandType, dc.UsbPowerOff, dc.SataLpm,
+		dc.WaitMounts, dc.AwakeWindows, dc.WakeWith, FormatDuration(dc.BackupWindow), dc.Passthrough, dc.ManageSsd, dc.PowerMeter, dc.Namespace, formatTags(dc.Tags), skew, dc.LogFile, dc.SymlinkPolicy, dc.Debug)
+}
+
+func (cc *ClassConf) String() string {
+	return fmt.Sprintf("name=%s, idle=%v, batteryIdle=%v, profileIdles=%s, commandType=%s, usbPowerOff=%t, sataLpm=%s, waitMounts=%v, awake=%v",
+		cc.Name, FormatDuration(cc.Idle), FormatDuration(cc.BatteryIdle), formatProfileIdles(cc.ProfileIdles), cc.CommandType, cc.UsbPowerOff, cc.SataLpm, cc.WaitMounts, cc.AwakeWindows)
+}
@@ -0,0 +1,47 @@
+// hd-idle - spin down idle hard disks
+// Copyright (C) 2018  Andoni del Olmo
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package hdidle
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestClass(t *testing.T) {
+	config := NewConfig()
+	config.Defaults.ProfileIdles = map[string]time.Duration{"night": 5 * time.Minute}
+	config.Classes = []ClassConf{
+		{Name: "archive", Idle: time.Hour, CommandType: ATA, ProfileIdles: map[string]time.Duration{"weekend": time.Minute}},
+		{Name: "parity", Idle: 2 * time.Hour, CommandType: SCSI},
+	}
+	config.Devices = []DeviceConf{{Name: "sdb", GivenName: "sdb", Class: "archive", Idle: time.Hour, CommandType: ATA}}
+
+	if class := config.Class("parity"); class == nil || class.Idle != 2*time.Hour {
+		t.Fatalf("Expected the class parity but found %v", class)
+	}
+	if class := config.Class("backup"); class != nil {
+		t.Fatalf("Expected no class backup but found %v", class)
+	}
+	if profiles := config.profiles(); !reflect.DeepEqual(profiles, []string{"night", "weekend"}) {
+		t.Fatalf("Expected the profiles of the defaults and the classes but found %v", profiles)
+	}
+	if s := config.String(); !strings.Contains(s, "{name=archive, idle=1h, ") || !strings.Contains(s, "class=archive") {
+		t.Fatalf("Expected the classes and the class of sdb but found %s", s)
+	}
+}
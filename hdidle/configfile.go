@@ -0,0 +1,461 @@
+// hd-idle - spin down idle hard disks
+// Copyright (C) 2018  Andoni del Olmo
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package hdidle
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/adelolmo/hd-idle/io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/*
+A configuration file is written in a subset of TOML: the defaults first,
+then a section per disk. Comments start with #.
+
+	idle = 600
+	battery_idle = 120
+	profile.night.idle = 300
+	command_type = "scsi"
+	log_file = "/var/log/hd-idle.log"
+	symlink_policy = 1
+	opt_in = false
+
+	[disk."/dev/disk/by-id/ata-WDC_WD40EFRX-68N32N0_WD-WCC7K1234567"]
+	idle = 1800
+	command_type = "ata"
+	alias = "parity"
+	namespace = "media"
+	tag.location = "rack2"
+	profile.night.idle = 600
+	log_file = "/var/log/hd-idle/parity.log"
+	skew = "off"
+
+	[disk.sdc]
+	idle = 0
+
+The idle times of a profile, e.g. night, replace the others while the profile
+is active, see Monitor.SetProfile. The key profile of the defaults names the
+profile active at start.
+
+A line include = "hosts/*.conf" reads the files matching the path or glob,
+relative to the including file and in the order of their names, as if they
+were written there. An included file starts with the defaults, the including
+file then goes on with the section it was in.
+*/
+
+/* the settings of a disk section, nil when not set */
+type diskSection struct {
+	name          string
+	file          string // where the disk first appears
+	idle          *time.Duration
+	batteryIdle   *time.Duration
+	profileIdles  map[string]time.Duration
+	commandType   *string
+	alias         string
+	namespace     string
+	logFile       string // instead of the log file of the defaults
+	tags          map[string]string
+	usbPowerOff   *bool
+	symlinkPolicy *int
+	debug         *bool
+	skewTime      *time.Duration
+}
+
+// LoadConfigFile reads a configuration file. Disks inherit the defaults of
+// the file for the settings their section leaves out.
+func LoadConfigFile(path string) (*Config, error) {
+	return LoadConfigFiles([]string{path})
+}
+
+// ConfigDirFiles lists the drop-in files of a directory, e.g. /etc/hd-idle.d,
+// in the order they are read: the files ending in .conf, sorted by name.
+func ConfigDirFiles(dir string) ([]string, error) {
+	return filepath.Glob(filepath.Join(dir, "*.conf"))
+}
+
+// LoadConfigFiles reads configuration files in order, e.g. the main file
+// and then the drop-in files. A default set again overrides the earlier
+// value, and so does a setting of a disk whose section appears again under
+// the same name. Two sections naming the same disk differently are an error.
+func LoadConfigFiles(paths []string) (*Config, error) {
+	config := NewConfig()
+	var disks []*diskSection
+	for _, path := range paths {
+		var err error
+		if disks, err = readConfigFile(path, config, disks, nil); err != nil {
+			return nil, err
+		}
+	}
+
+	sections := map[string]*diskSection{}
+	for _, disk := range disks {
+		name, _ := io.RealPath(disk.name)
+		if other, found := sections[name]; found && len(name) > 0 {
+			return nil, fmt.Errorf("%s in %s and %s in %s are the same disk %s",
+				other.name, other.file, disk.name, disk.file, name)
+		}
+		sections[name] = disk
+		device := DeviceConf{
+			Name:          name,
+			GivenName:     disk.name,
+			Alias:         disk.alias,
+			Namespace:     disk.namespace,
+			LogFile:       disk.logFile,
+			Tags:          disk.tags,
+			Idle:          config.Defaults.Idle,
+			BatteryIdle:   config.Defaults.BatteryIdle,
+			ProfileIdles:  config.Defaults.ProfileIdles,
+			CommandType:   config.Defaults.CommandType,
+			UsbPowerOff:   config.Defaults.UsbPowerOff,
+			SataLpm:       config.Defaults.SataLpm,
+			WaitMounts:    config.Defaults.WaitMounts,
+			AwakeWindows:  config.Defaults.AwakeWindows,
+			SymlinkPolicy: config.Defaults.SymlinkPolicy,
+		}
+		if disk.idle != nil {
+			device.Idle = *disk.idle
+		}
+		if disk.batteryIdle != nil {
+			device.BatteryIdle = *disk.batteryIdle
+		}
+		for name, idle := range disk.profileIdles {
+			device.ProfileIdles = withProfileIdle(device.ProfileIdles, name, idle)
+		}
+		if disk.commandType != nil {
+			device.CommandType = *disk.commandType
+		}
+		if disk.usbPowerOff != nil {
+			device.UsbPowerOff = *disk.usbPowerOff
+		}
+		if disk.symlinkPolicy != nil {
+			device.SymlinkPolicy = *disk.symlinkPolicy
+		}
+		if disk.debug != nil {
+			device.Debug = *disk.debug
+		}
+		if disk.skewTime != nil {
+			device.SkewTime = *disk.skewTime
+		}
+		config.Devices = append(config.Devices, device)
+	}
+	return config, nil
+}
+
+/* including lists the files that include this one, to refuse a cycle */
+func readConfigFile(path string, config *Config, disks []*diskSection, including []string) ([]*diskSection, error) {
+	for _, parent := range including {
+		if filepath.Clean(parent) == filepath.Clean(path) {
+			return nil, fmt.Errorf("%s includes itself through %s", path, strings.Join(including, ", "))
+		}
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	config.Files = append(config.Files, path)
+
+	var disk *diskSection
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if len(text) == 0 || strings.HasPrefix(text, "#") {
+			continue
+		}
+		if strings.HasPrefix(text, "[") {
+			name, err := diskSectionName(text)
+			if err != nil {
+				return nil, fmt.Errorf("%s line %d: %s", path, line, err)
+			}
+			disk = nil
+			for _, known := range disks {
+				if known.name == name {
+					disk = known
+				}
+			}
+			if disk == nil {
+				disk = &diskSection{name: name, file: path}
+				disks = append(disks, disk)
+			}
+			continue
+		}
+		if key, value, err := configLine(text); err == nil && key == "include" {
+			files, err := includedFiles(path, value)
+			if err != nil {
+				return nil, fmt.Errorf("%s line %d: %s", path, line, err)
+			}
+			for _, file := range files {
+				if disks, err = readConfigFile(file, config, disks, append(including, path)); err != nil {
+					return nil, err
+				}
+			}
+			continue
+		}
+		if err := setConfigKey(config, disk, text); err != nil {
+			return nil, fmt.Errorf("%s line %d: %s", path, line, err)
+		}
+	}
+	return disks, scanner.Err()
+}
+
+/* the files of an include, a glob may match none */
+func includedFiles(path, value string) ([]string, error) {
+	if !filepath.IsAbs(value) {
+		value = filepath.Join(filepath.Dir(path), value)
+	}
+	if !strings.ContainsAny(value, "*?[") {
+		return []string{value}, nil
+	}
+	files, err := filepath.Glob(value)
+	if err != nil {
+		return nil, fmt.Errorf("include: wrong pattern %s", value)
+	}
+	return files, nil
+}
+
+/* [disk.sdb] or [disk."/dev/disk/by-id/..."] */
+func diskSectionName(text string) (string, error) {
+	if !strings.HasSuffix(text, "]") || !strings.HasPrefix(text, "[disk.") {
+		return "", fmt.Errorf("unknown section %s, must be [disk.<name>]", text)
+	}
+	name := strings.TrimSpace(text[len("[disk.") : len(text)-1])
+	if strings.HasPrefix(name, `"`) {
+		unquoted, err := strconv.Unquote(name)
+		if err != nil {
+			return "", fmt.Errorf("wrong disk name %s", name)
+		}
+		name = unquoted
+	}
+	if len(name) == 0 {
+		return "", fmt.Errorf("missing disk name")
+	}
+	if IsDevicePattern(name) {
+		if err := ValidDevicePattern(name); err != nil {
+			return "", fmt.Errorf("wrong disk pattern %s: %s", name, err)
+		}
+	}
+	return name, nil
+}
+
+func setConfigKey(config *Config, disk *diskSection, text string) error {
+	key, value, err := configLine(text)
+	if err != nil {
+		return err
+	}
+
+	if strings.HasPrefix(key, "profile.") && strings.HasSuffix(key, ".idle") {
+		return setProfileIdle(config, disk, strings.TrimSuffix(strings.TrimPrefix(key, "profile."), ".idle"), value)
+	}
+	if strings.HasPrefix(key, "tag.") {
+		return setTag(disk, strings.TrimPrefix(key, "tag."), value)
+	}
+	switch key {
+	case "idle":
+		idle, err := ParseDuration(value)
+		if err != nil {
+			return fmt.Errorf("idle must be a number of seconds or a duration like 10m")
+		}
+		if disk != nil {
+			disk.idle = &idle
+		} else {
+			config.Defaults.Idle = idle
+		}
+	case "battery_idle":
+		idle, err := ParseDuration(value)
+		if err != nil {
+			return fmt.Errorf("battery_idle must be a number of seconds or a duration like 2m")
+		}
+		if disk != nil {
+			disk.batteryIdle = &idle
+		} else {
+			config.Defaults.BatteryIdle = idle
+		}
+	case "command_type":
+		if value != SCSI && value != ATA {
+			return fmt.Errorf("command_type must be one of: scsi, ata")
+		}
+		if disk != nil {
+			disk.commandType = &value
+		} else {
+			config.Defaults.CommandType = value
+		}
+	case "usb_power_off":
+		on, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("usb_power_off must be true or false")
+		}
+		if disk != nil {
+			disk.usbPowerOff = &on
+		} else {
+			config.Defaults.UsbPowerOff = on
+		}
+	case "alias":
+		if disk == nil {
+			return fmt.Errorf("alias only applies to a disk section")
+		}
+		disk.alias = value
+	case "namespace":
+		if disk == nil {
+			return fmt.Errorf("namespace only applies to a disk section")
+		}
+		if !ValidNamespace(value) {
+			return fmt.Errorf("namespace must be letters, digits, dots, dashes and underscores")
+		}
+		disk.namespace = value
+	case "symlink_policy":
+		policy, err := strconv.Atoi(value)
+		if err != nil || policy < SymlinkResolveOnce || policy > SymlinkResolveRequired {
+			return fmt.Errorf("symlink_policy must be 0, 1, 2 or 3")
+		}
+		if disk != nil {
+			disk.symlinkPolicy = &policy
+		} else {
+			config.Defaults.SymlinkPolicy = policy
+		}
+	case "debug":
+		if disk == nil {
+			return setConfigDefault(config, key, value)
+		}
+		debug, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("debug must be true or false")
+		}
+		disk.debug = &debug
+	case "log_file":
+		if disk == nil {
+			return setConfigDefault(config, key, value)
+		}
+		disk.logFile = value
+	case "skew":
+		if disk == nil {
+			return fmt.Errorf("skew only applies to a disk section")
+		}
+		skew, err := ParseSkew(value)
+		if err != nil {
+			return fmt.Errorf("skew must be a number of seconds, a duration like 10m, or off")
+		}
+		disk.skewTime = &skew
+	case "log_format", "profile", "opt_in":
+		if disk != nil {
+			return fmt.Errorf("%s only applies to the defaults", key)
+		}
+		return setConfigDefault(config, key, value)
+	default:
+		return fmt.Errorf("unknown key %s", key)
+	}
+	return nil
+}
+
+func setConfigDefault(config *Config, key, value string) error {
+	switch key {
+	case "log_file":
+		config.Defaults.LogFile = value
+	case "log_format":
+		if value != LogFormatText && value != LogFormatKeyValue {
+			return fmt.Errorf("log_format must be one of: text, key-value")
+		}
+		config.Defaults.LogFormat = value
+	case "debug":
+		debug, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("debug must be true or false")
+		}
+		config.Defaults.Debug = debug
+	case "opt_in":
+		optIn, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("opt_in must be true or false")
+		}
+		config.Defaults.OptIn = optIn
+	case "profile":
+		if !ValidProfileName(value) {
+			return fmt.Errorf("profile must be letters, digits, dashes and underscores, and not %s", ProfileDefault)
+		}
+		config.Defaults.Profile = value
+	}
+	return nil
+}
+
+/* tag.location = "rack2" */
+func setTag(disk *diskSection, key, value string) error {
+	if disk == nil {
+		return fmt.Errorf("tag.%s only applies to a disk section", key)
+	}
+	if _, _, err := ParseTag(key + "=" + value); err != nil {
+		return fmt.Errorf("tag.%s: %s", key, err)
+	}
+	disk.tags = withTag(disk.tags, key, value)
+	return nil
+}
+
+/* profile.night.idle = 300 */
+func setProfileIdle(config *Config, disk *diskSection, name, value string) error {
+	if !ValidProfileName(name) {
+		return fmt.Errorf("profile must be letters, digits, dashes and underscores, and not %s", ProfileDefault)
+	}
+	idle, err := ParseDuration(value)
+	if err != nil {
+		return fmt.Errorf("profile.%s.idle must be a number of seconds or a duration like 5m", name)
+	}
+	if disk != nil {
+		disk.profileIdles = withProfileIdle(disk.profileIdles, name, idle)
+	} else {
+		config.Defaults.ProfileIdles = withProfileIdle(config.Defaults.ProfileIdles, name, idle)
+	}
+	return nil
+}
+
+/* key = value */
+func configLine(text string) (string, string, error) {
+	i := strings.Index(text, "=")
+	if i < 0 {
+		return "", "", fmt.Errorf("expected key = value")
+	}
+	key := strings.TrimSpace(text[:i])
+	value, err := configValue(strings.TrimSpace(text[i+1:]))
+	if err != nil {
+		return "", "", fmt.Errorf("%s: %s", key, err)
+	}
+	return key, value, nil
+}
+
+/* a quoted string, a number or a boolean, followed by an optional comment */
+func configValue(text string) (string, error) {
+	if strings.HasPrefix(text, `"`) {
+		end := strings.Index(text[1:], `"`)
+		if end < 0 {
+			return "", fmt.Errorf("unterminated string")
+		}
+		rest := strings.TrimSpace(text[end+2:])
+		if len(rest) > 0 && !strings.HasPrefix(rest, "#") {
+			return "", fmt.Errorf("unexpected %s", rest)
+		}
+		return strconv.Unquote(text[:end+2])
+	}
+	if i := strings.Index(text, "#"); i >= 0 {
+		text = strings.TrimSpace(text[:i])
+	}
+	if len(text) == 0 {
+		return "", fmt.Errorf("missing value")
+	}
+	return text, nil
+}
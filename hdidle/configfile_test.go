@@ -0,0 +1,220 @@
+// hd-idle - spin down idle hard disks
+// Copyright (C) 2018  Andoni del Olmo
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package hdidle
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeConfigFile(t *testing.T, dir, content string) string {
+	path := filepath.Join(dir, "hd-idle.conf")
+	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadConfigFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := writeConfigFile(t, dir, `# managed by ansible
+idle = 900
+battery_idle = "2m"
+profile.night.idle = 300
+profile = "night"
+command_type = "ata"
+log_file = "/var/log/hd-idle.log" # on the sd card
+symlink_policy = 1
+
+[disk."/dev/sdb"]
+idle = 1800
+battery_idle = 600
+profile.night.idle = "20m"
+alias = "parity"
+symlink_policy = 2
+log_file = "/var/log/hd-idle/parity.log"
+skew = "off"
+
+[disk.sdc]
+command_type = "scsi"
+usb_power_off = true
+namespace = "backups"
+debug = true
+tag.location = "rack2"
+tag.owner = "alice"
+skew = "5m"
+`)
+	config, err := LoadConfigFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.Defaults.Idle != 900*time.Second || config.Defaults.CommandType != ATA || config.Defaults.Debug ||
+		config.Defaults.LogFile != "/var/log/hd-idle.log" || config.Defaults.SymlinkPolicy != SymlinkResolveRetry ||
+		config.Defaults.Profile != "night" {
+		t.Fatalf("Unexpected defaults %s", config)
+	}
+	if len(config.Devices) != 2 {
+		t.Fatalf("Expected 2 disks but found %d", len(config.Devices))
+	}
+	sdb, sdc := config.Devices[0], config.Devices[1]
+	if sdb.Name != "sdb" || sdb.GivenName != "/dev/sdb" || sdb.Idle != 1800*time.Second || sdb.BatteryIdle != 600*time.Second || sdb.ProfileIdles["night"] != 20*time.Minute || sdb.CommandType != ATA || sdb.Alias != "parity" || sdb.SymlinkPolicy != SymlinkResolveContinuous || sdb.Debug ||
+		sdb.LogFile != "/var/log/hd-idle/parity.log" || sdb.SkewTime != SkewDisabled {
+		t.Fatalf("Unexpected disk %s", sdb.String())
+	}
+	if sdc.Name != "sdc" || sdc.Idle != 900*time.Second || sdc.BatteryIdle != 2*time.Minute || sdc.ProfileIdles["night"] != 5*time.Minute || sdc.CommandType != SCSI || !sdc.UsbPowerOff || sdc.Namespace != "backups" || sdc.SymlinkPolicy != SymlinkResolveRetry || !sdc.Debug ||
+		sdc.Tags["location"] != "rack2" || sdc.Tags["owner"] != "alice" || len(sdb.Tags) != 0 || len(sdc.LogFile) != 0 || sdc.SkewTime != 5*time.Minute {
+		t.Fatalf("Unexpected disk %s", sdc.String())
+	}
+}
+
+func TestLoadConfigFileErrors(t *testing.T) {
+	dir, err := ioutil.TempDir("", "config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for content, expected := range map[string]string{
+		"idle = ten":                        "line 1: idle",
+		"spin = 3":                          "line 1: unknown key spin",
+		"[disk.sdb]\nlog_format = \"text\"": "line 2: log_format only applies to the defaults",
+		"[device.sdb]":                      "line 1: unknown section",
+		"alias = \"parity":                  "line 1: alias: unterminated string",
+		"[disk.sdb]\nnamespace = \"a b\"":   "line 2: namespace must be",
+		"profile.default.idle = 300":        "line 1: profile must be",
+		"[disk.sdb]\nprofile = \"night\"":   "line 2: profile only applies to the defaults",
+		"symlink_policy = 4":                "line 1: symlink_policy must be 0, 1, 2 or 3",
+		"tag.location = \"rack2\"":          "line 1: tag.location only applies to a disk section",
+		"skew = 60":                         "line 1: skew only applies to a disk section",
+		"[disk.sdb]\nskew = \"never\"":      "line 2: skew must be",
+		"[disk.sdb]\ntag.Rack = \"2\"":      "line 2: tag.Rack: the key must be",
+	} {
+		_, err := LoadConfigFile(writeConfigFile(t, dir, content))
+		if err == nil || !strings.Contains(err.Error(), expected) {
+			t.Errorf("Expected %s for %q but found %v", expected, content, err)
+		}
+	}
+}
+
+func TestLoadConfigDropIns(t *testing.T) {
+	dir, err := ioutil.TempDir("", "config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	mainFile := writeConfigFile(t, dir, "idle = 600\n[disk.sdb]\nidle = 1200\nalias = \"parity\"\n")
+	dropIns := filepath.Join(dir, "hd-idle.d")
+	mustMkdir(t, dropIns)
+	for name, content := range map[string]string{
+		"20-sdb.conf":   "[disk.sdb]\nidle = 1800\n",
+		"10-sdc.conf":   "idle = 900\n[disk.sdc]\ncommand_type = \"ata\"\n",
+		"README":        "not read",
+		"30-other.conf": "# nothing yet\n",
+	} {
+		if err := ioutil.WriteFile(filepath.Join(dropIns, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	files, err := ConfigDirFiles(dropIns)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 3 || filepath.Base(files[0]) != "10-sdc.conf" {
+		t.Fatalf("Expected the .conf files sorted but found %v", files)
+	}
+	config, err := LoadConfigFiles(append([]string{mainFile}, files...))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.Defaults.Idle != 900*time.Second || len(config.Devices) != 2 {
+		t.Fatalf("Unexpected configuration %s", config)
+	}
+	sdb, sdc := config.Devices[0], config.Devices[1]
+	if sdb.Idle != 1800*time.Second || sdb.Alias != "parity" {
+		t.Fatalf("Expected sdb merged from both files but found %s", sdb.String())
+	}
+	if sdc.Idle != 900*time.Second || sdc.CommandType != ATA {
+		t.Fatalf("Unexpected disk %s", sdc.String())
+	}
+
+	conflict := filepath.Join(dropIns, "40-conflict.conf")
+	if err := ioutil.WriteFile(conflict, []byte("[disk.\"/dev/sdb\"]\nidle = 60\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadConfigFiles([]string{mainFile, conflict}); err == nil || !strings.Contains(err.Error(), "same disk sdb") {
+		t.Fatalf("Expected a conflict for sdb but found %v", err)
+	}
+}
+
+func TestLoadConfigInclude(t *testing.T) {
+	dir, err := ioutil.TempDir("", "config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	hosts := filepath.Join(dir, "hosts")
+	mustMkdir(t, hosts)
+	for name, content := range map[string]string{
+		"20-nas.conf":  "[disk.sdc]\nidle = 1800\n",
+		"10-base.conf": "idle = 900\n",
+		"shared.inc":   "command_type = \"ata\"\n",
+	} {
+		if err := ioutil.WriteFile(filepath.Join(hosts, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	path := writeConfigFile(t, dir, `include = "hosts/shared.inc"
+[disk.sdb]
+include = "hosts/*.conf" # per host
+alias = "parity"
+`)
+	config, err := LoadConfigFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.Defaults.Idle != 900*time.Second || config.Defaults.CommandType != ATA || len(config.Devices) != 2 {
+		t.Fatalf("Unexpected configuration %s", config)
+	}
+	sdb, sdc := config.Devices[0], config.Devices[1]
+	if sdb.Alias != "parity" || sdb.Idle != 900*time.Second {
+		t.Fatalf("Expected sdb to go on after the include but found %s", sdb.String())
+	}
+	if sdc.Idle != 1800*time.Second || len(sdc.Alias) != 0 {
+		t.Fatalf("Unexpected disk %s", sdc.String())
+	}
+
+	if err := ioutil.WriteFile(filepath.Join(hosts, "30-loop.conf"), []byte("include = \"../hd-idle.conf\"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadConfigFile(path); err == nil || !strings.Contains(err.Error(), "includes itself") {
+		t.Fatalf("Expected an include cycle but found %v", err)
+	}
+	writeConfigFile(t, dir, "include = \"missing.conf\"\n")
+	if _, err := LoadConfigFile(path); err == nil || !strings.Contains(err.Error(), "missing.conf") {
+		t.Fatalf("Expected a missing include but found %v", err)
+	}
+}
@@ -0,0 +1,139 @@
+// hd-idle - spin down idle hard disks
+// Copyright (C) 2018  Andoni del Olmo
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package hdidle
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"time"
+)
+
+/*
+The JSON Schema (draft-07) of a configuration file once read as TOML, for
+editors and validation pipelines. The keys come from the config tags of
+DefaultConf and DeviceConf, a tag.<key> or profile.<name>.idle key becomes a
+table, and configKeyRules adds what the Go types don't tell.
+*/
+
+const durationPattern = `^([0-9]+|([0-9]+(\.[0-9]*)?(ns|us|µs|ms|s|m|h))+)$`
+
+/* the values a key accepts beyond its type, as setConfigKey checks them */
+var configKeyRules = map[string]map[string]interface{}{
+	"command_type":   {"enum": []string{SCSI, ATA}},
+	"log_format":     {"enum": []string{LogFormatText, LogFormatKeyValue}},
+	"symlink_policy": {"minimum": SymlinkResolveOnce, "maximum": SymlinkResolveRequired},
+	"namespace":      {"pattern": namespaceName.String()},
+	"profile":        {"pattern": profileName.String(), "not": map[string]interface{}{"const": ProfileDefault}},
+	"skew":           {"oneOf": append(durationSchemas(), jsonSchema{"const": "off"})},
+}
+
+type jsonSchema map[string]interface{}
+
+// ConfigSchema returns the JSON Schema of a configuration file.
+func ConfigSchema() ([]byte, error) {
+	defaults := configProperties(reflect.TypeOf(DefaultConf{}))
+	defaults["include"] = jsonSchema{
+		"description": "files to read in place, a path or glob relative to the including file",
+		"oneOf": []jsonSchema{
+			{"type": "string"},
+			{"type": "array", "items": jsonSchema{"type": "string"}},
+		},
+	}
+	defaults["disk"] = jsonSchema{
+		"description": "the sections of the disks, by their name as given with -a",
+		"type":        "object",
+		"additionalProperties": jsonSchema{
+			"type":                 "object",
+			"properties":           configProperties(reflect.TypeOf(DeviceConf{})),
+			"additionalProperties": false,
+		},
+	}
+	return json.MarshalIndent(jsonSchema{
+		"$schema":              "http://json-schema.org/draft-07/schema#",
+		"$id":                  "hd-idle/config/1",
+		"title":                "hd-idle configuration file",
+		"type":                 "object",
+		"properties":           defaults,
+		"additionalProperties": false,
+	}, "", "  ")
+}
+
+/* the keys of the config tags of the struct */
+func configProperties(t reflect.Type) jsonSchema {
+	properties := jsonSchema{}
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		key := field.Tag.Get("config")
+		if len(key) == 0 {
+			continue
+		}
+		var schema jsonSchema
+		switch {
+		case strings.HasPrefix(key, "tag."):
+			key = "tag"
+			schema = jsonSchema{
+				"type":                 "object",
+				"propertyNames":        jsonSchema{"pattern": tagKey.String()},
+				"additionalProperties": valueSchema(field.Type.Elem()),
+			}
+		case strings.HasPrefix(key, "profile."):
+			key = "profile"
+			schema = jsonSchema{
+				"type":          "object",
+				"propertyNames": configKeyRules["profile"],
+				"additionalProperties": jsonSchema{
+					"type":                 "object",
+					"properties":           jsonSchema{"idle": valueSchema(field.Type.Elem())},
+					"additionalProperties": false,
+				},
+			}
+		default:
+			schema = valueSchema(field.Type)
+			for rule, value := range configKeyRules[key] {
+				schema[rule] = value
+			}
+		}
+		if other, found := properties[key]; found {
+			schema = jsonSchema{"anyOf": []interface{}{other, schema}}
+		}
+		properties[key] = schema
+	}
+	return properties
+}
+
+/* a number of seconds or a duration for a time.Duration */
+func valueSchema(t reflect.Type) jsonSchema {
+	if t == reflect.TypeOf(time.Duration(0)) {
+		return jsonSchema{"oneOf": durationSchemas()}
+	}
+	switch t.Kind() {
+	case reflect.Bool:
+		return jsonSchema{"type": "boolean"}
+	case reflect.Int:
+		return jsonSchema{"type": "integer"}
+	default:
+		return jsonSchema{"type": "string"}
+	}
+}
+
+func durationSchemas() []jsonSchema {
+	return []jsonSchema{
+		{"type": "integer", "minimum": 0, "description": "seconds"},
+		{"type": "string", "pattern": durationPattern, "description": "seconds or a duration like 10m"},
+	}
+}
@@ -0,0 +1,120 @@
+// hd-idle - spin down idle hard disks
+// Copyright (C) 2018  Andoni del Olmo
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package hdidle
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestConfigSchemaKeys(t *testing.T) {
+	defaults, disk := configSchemaProperties(t)
+
+	expected := []string{"battery_idle", "command_type", "debug", "disk", "idle", "include", "log_file",
+		"log_format", "opt_in", "profile", "symlink_policy", "usb_power_off"}
+	if keys := sortedKeys(defaults); !reflect.DeepEqual(keys, expected) {
+		t.Fatalf("Expected keys %v but found %v", expected, keys)
+	}
+	expected = []string{"alias", "battery_idle", "command_type", "debug", "idle", "log_file", "namespace",
+		"profile", "skew", "symlink_policy", "tag", "usb_power_off"}
+	if keys := sortedKeys(disk); !reflect.DeepEqual(keys, expected) {
+		t.Fatalf("Expected disk keys %v but found %v", expected, keys)
+	}
+}
+
+func TestConfigSchemaKeysAreRead(t *testing.T) {
+	defaults, disk := configSchemaProperties(t)
+	delete(defaults, "include")
+	delete(defaults, "disk")
+
+	for key, schema := range defaults {
+		for _, line := range sampleLines(key, schema.(map[string]interface{})) {
+			if err := setConfigKey(NewConfig(), nil, line); err != nil {
+				t.Fatalf("Expected %s to be read but found: %s", line, err)
+			}
+		}
+	}
+	for key, schema := range disk {
+		for _, line := range sampleLines(key, schema.(map[string]interface{})) {
+			if err := setConfigKey(NewConfig(), &diskSection{name: "sdb"}, line); err != nil {
+				t.Fatalf("Expected %s in a disk section to be read but found: %s", line, err)
+			}
+		}
+	}
+}
+
+func configSchemaProperties(t *testing.T) (map[string]interface{}, map[string]interface{}) {
+	b, err := ConfigSchema()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var schema struct {
+		Properties map[string]interface{} `json:"properties"`
+	}
+	if err := json.Unmarshal(b, &schema); err != nil {
+		t.Fatalf("Cannot parse the schema: %s", err)
+	}
+	disk := schema.Properties["disk"].(map[string]interface{})["additionalProperties"].(map[string]interface{})
+	return schema.Properties, disk["properties"].(map[string]interface{})
+}
+
+func sortedKeys(m map[string]interface{}) []string {
+	var keys []string
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+/* a line of the configuration file per alternative of the schema of the key */
+func sampleLines(key string, schema map[string]interface{}) []string {
+	var lines []string
+	for _, alternatives := range []string{"anyOf", "oneOf"} {
+		if list, found := schema[alternatives]; found {
+			for _, alternative := range list.([]interface{}) {
+				lines = append(lines, sampleLines(key, alternative.(map[string]interface{}))...)
+			}
+			return lines
+		}
+	}
+	switch {
+	case schema["type"] == "object" && key == "tag":
+		return []string{`tag.location = "rack2"`}
+	case schema["type"] == "object" && key == "profile":
+		return []string{"profile.night.idle = 300", `profile.night.idle = "5m"`}
+	case schema["const"] != nil:
+		return []string{fmt.Sprintf("%s = %q", key, schema["const"])}
+	case schema["type"] == "boolean":
+		return []string{key + " = true"}
+	case schema["type"] == "integer":
+		if minimum, found := schema["minimum"]; found {
+			return []string{fmt.Sprintf("%s = %v", key, minimum)}
+		}
+		return []string{key + " = 1"}
+	case schema["enum"] != nil:
+		return []string{fmt.Sprintf("%s = %q", key, schema["enum"].([]interface{})[0])}
+	case schema["pattern"] == durationPattern:
+		return []string{key + ` = "10m"`}
+	case schema["pattern"] != nil:
+		return []string{key + ` = "night"`}
+	}
+	return []string{key + ` = "/var/log/hd-idle.log"`}
+}
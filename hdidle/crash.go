@@ -0,0 +1,104 @@
+// hd-idle - spin down idle hard disks
+// Copyright (C) 2018  Andoni del Olmo
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package hdidle
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"runtime/debug"
+	"strconv"
+	"time"
+)
+
+// CrashReport tells where hd-idle panicked. The subsystem that panicked
+// gives up what it was doing, the others keep running.
+type CrashReport struct {
+	Time       time.Time `json:"time"`
+	Subsystem  string    `json:"subsystem"`      // cycle, command, watchdog, vetoer or the name of a sink
+	Disk       string    `json:"disk,omitempty"` // the disk being handled, if any
+	ConfigHash string    `json:"config_hash"`    // tells apart reports of the same configuration
+	Panic      string    `json:"panic"`
+	Stack      string    `json:"stack"`
+}
+
+/* a panic turned into an error, to be reported by whoever holds the monitor */
+type panicError struct {
+	value interface{}
+	stack []byte
+}
+
+func (e *panicError) Error() string {
+	return fmt.Sprintf("panic: %v", e.value)
+}
+
+/* call f, turning a panic into a *panicError */
+func recovered(f func() error) (err error) {
+	defer func() {
+		if value := recover(); value != nil {
+			err = &panicError{value: value, stack: debug.Stack()}
+		}
+	}()
+	return f()
+}
+
+/*
+ * Report a panic: a key=value line and the stack on the standard output, a
+ * line in the log file and, with --crash-dir, a JSON file with the whole
+ * report to attach to a bug report. The monitor must be locked.
+ */
+func (m *Monitor) reportCrash(subsystem, disk string, p *panicError) {
+	report := CrashReport{
+		Time:       time.Now(),
+		Subsystem:  subsystem,
+		Disk:       disk,
+		ConfigHash: fmt.Sprintf("%x", sha256.Sum256([]byte(m.config.String())))[:12],
+		Panic:      fmt.Sprint(p.value),
+		Stack:      string(p.stack),
+	}
+	m.printf("CRITICAL crash subsystem=%s disk=%s config=%s panic=%s\n%s",
+		quoteValue(subsystem), quoteValue(disk), report.ConfigHash, strconv.Quote(report.Panic), report.Stack)
+	m.logToFile(m.config.Defaults.LogFile, fmt.Sprintf("date: %s, time: %s, crash in %s: %s",
+		report.Time.Format("2006-01-02"), report.Time.Format("15:04:05"), subsystem, report.Panic))
+
+	dir := m.config.Defaults.CrashDir
+	if len(dir) == 0 {
+		return
+	}
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		m.printf("Cannot write crash report: %s\n", err)
+		return
+	}
+	file := filepath.Join(dir, fmt.Sprintf("crash-%s.json", report.Time.Format("20060102-150405.000000000")))
+	if err := ioutil.WriteFile(file, data, 0644); err != nil {
+		m.printf("Cannot write crash report: %s\n", err)
+		return
+	}
+	m.printf("crash report written to %s\n", file)
+}
+
+/* report an error that was a panic, true if it was */
+func (m *Monitor) reportPanic(subsystem, disk string, err error) bool {
+	p, ok := err.(*panicError)
+	if ok {
+		m.reportCrash(subsystem, disk, p)
+	}
+	return ok
+}
@@ -0,0 +1,108 @@
+// hd-idle - spin down idle hard disks
+// Copyright (C) 2018  Andoni del Olmo
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package hdidle
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/adelolmo/hd-idle/diskstats"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type panickingVetoer struct{}
+
+func (panickingVetoer) Veto(ctx context.Context, disk, action string) error {
+	var bookings map[string]bool
+	bookings[disk] = true // nil map
+	return nil
+}
+
+type panickingSink struct{}
+
+func (panickingSink) Deliver(event Event) error {
+	panic("sink broken")
+}
+
+func TestCrashReport(t *testing.T) {
+	dir, err := ioutil.TempDir("", "crash")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	simulation, err := ParseSimulation("dry-run")
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := NewConfig()
+	config.Defaults.Simulation = simulation
+	config.Defaults.Idle = time.Minute
+	config.Defaults.CrashDir = dir
+	config.SkewTime = 24 * time.Hour
+	m := New(config)
+	m.SetOutput(ioutil.Discard)
+	m.AddVetoer("bookings", panickingVetoer{})
+	m.AddSink("broken", panickingSink{}, 1)
+	defer m.Stop()
+
+	/* the panicking vetoer vetoes the spindown, the monitor goes on */
+	start := time.Now()
+	for _, minutes := range []int{0, 2} {
+		m.now = start.Add(time.Duration(minutes) * time.Minute)
+		m.updateState(diskstats.DiskStats{Name: "sda"})
+		m.lastNow = m.now
+	}
+	if m.snapshots[0].SpunDown {
+		t.Fatalf("Expected the spindown vetoed by the panicking vetoer")
+	}
+
+	/* the deferred spindown event reaches the panicking sink */
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if stats := m.SinkStats(); len(stats) == 1 && stats[0].Failed > 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected the sink panic counted as a failure but found %+v", m.SinkStats())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	files, _ := filepath.Glob(filepath.Join(dir, "crash-*.json"))
+	subsystems := map[string]string{}
+	for _, file := range files {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var report CrashReport
+		if err := json.Unmarshal(data, &report); err != nil {
+			t.Fatal(err)
+		}
+		if len(report.Stack) == 0 || len(report.ConfigHash) != 12 {
+			t.Fatalf("Expected a stack and a config hash but found %+v", report)
+		}
+		subsystems[report.Subsystem] = report.Disk
+	}
+	if disk, found := subsystems["vetoer bookings"]; !found || disk != "sda" || len(subsystems) < 2 {
+		t.Fatalf("Expected crash reports of the vetoer on sda and of the sink but found %v", subsystems)
+	}
+}
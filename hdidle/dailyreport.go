@@ -0,0 +1,224 @@
+// hd-idle - spin down idle hard disks
+// Copyright (C) 2018  Andoni del Olmo
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package hdidle
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+/*
+ * Once a day every disk gets a compact report in the output and its log
+ * file: how long it was spun down, how often it spun down and up, the idle
+ * gaps that ended just short of its idle time and what deferred its spin
+ * downs, with a hint where something can be tuned. Users who never look at
+ * the statistics get the feedback where they already look.
+ */
+
+const (
+	nearMissShare = 0.8 // of the idle time, an idle gap ending later nearly spun the disk down
+	nearMissHint  = 3   // near misses a day worth a hint
+	spinupsHint   = 24  // spin ups a day worth a hint
+)
+
+/* what the daily report tells about a disk besides its statistics */
+type dayNotes struct {
+	nearMisses int
+	longestGap time.Duration  // the longest idle gap that ended before the idle time
+	deferrals  map[string]int // cycles a due spin down was deferred, by code
+}
+
+/* what to do about the most frequent reason for deferring the spin downs */
+var deferralHints = map[string]string{
+	"PAUSED":              "spin downs were paused, check nothing leaves a pause behind",
+	"QUARANTINED":         "the disk failed its commands and was quarantined, check its cabling and enclosure",
+	"BACKUP":              "the disk waited for its backup, check the backup runs in its --backup-window",
+	"SMR_GC":              "the disk waited for its garbage collection after writes, fewer small writes shorten it",
+	"USB_HUB_BUSY":        "a disk on the same usb hub did not answer, check that disk",
+	"EXPORT_SESSION":      "an iSCSI or NBD client kept a session open, disconnect it when not in use",
+	"DISCARD":             "discards kept it busy, schedule fstrim inside an --awake window",
+	"IN_FLIGHT":           "requests stayed in flight without completing, check the disk and its cabling",
+	"DEVICE_MAPPER":       "an LVM snapshot merge or thin pool change kept it busy",
+	"BACKGROUND_ACTIVITY": "it ran self tests or other background work, schedule them inside an --awake window",
+	"VETO":                "a program embedding hd-idle vetoed the spin downs",
+}
+
+// ParseDailyReport parses the time of day of the daily report, hh:mm, or
+// off for none.
+func ParseDailyReport(s string) (time.Duration, error) {
+	if s == "off" {
+		return DailyReportOff, nil
+	}
+	return parseTimeOfDay(s)
+}
+
+// FormatDailyReport formats the time of day of the daily report like
+// ParseDailyReport takes it.
+func FormatDailyReport(at time.Duration) string {
+	if at == DailyReportOff {
+		return "off"
+	}
+	return fmt.Sprintf("%02d:%02d", int(at.Hours()), int(at.Minutes())%60)
+}
+
+func (m *Monitor) notesOf(name string) *dayNotes {
+	notes, found := m.dayNotes[name]
+	if !found {
+		notes = &dayNotes{deferrals: map[string]int{}}
+		m.dayNotes[name] = notes
+	}
+	return notes
+}
+
+/* an idle gap of a spinning disk ended with some I/O */
+func (m *Monitor) noteIdleGap(name string, idleTime, gap time.Duration) {
+	if m.config.Defaults.DailyReport == DailyReportOff || idleTime == 0 || gap >= idleTime {
+		return
+	}
+	notes := m.notesOf(name)
+	if gap > notes.longestGap {
+		notes.longestGap = gap
+	}
+	if float64(gap) >= nearMissShare*float64(idleTime) {
+		notes.nearMisses++
+	}
+}
+
+/* a due spin down was deferred for a cycle */
+func (m *Monitor) noteDeferral(name, code string) {
+	if m.config.Defaults.DailyReport == DailyReportOff || len(name) == 0 {
+		return
+	}
+	m.notesOf(name).deferrals[code]++
+}
+
+/* the next time of day of the report after t */
+func (m *Monitor) nextReportAt(t time.Time) time.Time {
+	midnight := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+	next := midnight.Add(m.config.Defaults.DailyReport)
+	if !next.After(t) {
+		next = midnight.AddDate(0, 0, 1).Add(m.config.Defaults.DailyReport)
+	}
+	return next
+}
+
+/* the report of the day once its time of day passed, the first one covers the time since start */
+func (m *Monitor) dailyReport() {
+	if m.config.Defaults.DailyReport == DailyReportOff {
+		return
+	}
+	if m.reportAt.IsZero() {
+		m.startReportPeriod()
+		return
+	}
+	if m.now.Before(m.nextReportAt(m.reportAt)) {
+		return
+	}
+	period := m.now.Sub(m.reportAt)
+	for _, ds := range m.snapshots {
+		if ds.IdleTime == 0 {
+			continue
+		}
+		summary, hints := m.reportOf(ds.Name, ds.IdleTime, period)
+		m.printf("daily report %s: %s\n", m.displayName(ds.Name), summary)
+		for _, hint := range hints {
+			m.printf("daily report %s hint: %s\n", m.displayName(ds.Name), hint)
+		}
+		m.emit(EventDailyReport, ds.Name, strings.Join(append([]string{summary}, hints...), "; "))
+		for _, line := range append([]string{summary}, hints...) {
+			m.logToFile(m.logFileOf(ds.Name), fmt.Sprintf("date: %s, time: %s, disk: %s, daily report: %s",
+				m.now.Format("2006-01-02"), m.now.Format("15:04:05"), m.displayName(ds.Name), line))
+		}
+	}
+	m.startReportPeriod()
+}
+
+func (m *Monitor) startReportPeriod() {
+	m.reportAt = m.now
+	m.reportStatistics = map[string]DiskStatistics{}
+	for key, s := range m.statistics {
+		m.reportStatistics[key] = *s
+	}
+	m.dayNotes = map[string]*dayNotes{}
+}
+
+/* the summary of the disk over the period and the hints about it */
+func (m *Monitor) reportOf(name string, idleTime, period time.Duration) (string, []string) {
+	start := m.reportStatistics[m.identityKey(name)]
+	now := *m.statisticsOf(name)
+	spindowns := now.Spindowns - start.Spindowns
+	spinups := now.Spinups - start.Spinups
+	spunDown := now.SpunDownTime - start.SpunDownTime
+	notes := m.notesOf(name)
+
+	share := 0.0
+	if period > 0 {
+		share = float64(spunDown) / float64(period)
+	}
+	summary := fmt.Sprintf("spun down %.0f%% of %s, spin downs %d, spin ups %d, near misses %d, longest idle gap %s of %s",
+		share*100, FormatDuration(period.Round(time.Minute)), spindowns, spinups, notes.nearMisses,
+		FormatDuration(notes.longestGap.Round(time.Second)), FormatDuration(idleTime))
+	var codes []string
+	deferred := 0
+	for code, cycles := range notes.deferrals {
+		codes = append(codes, code)
+		deferred += cycles
+	}
+	sort.Slice(codes, func(i, j int) bool {
+		if notes.deferrals[codes[i]] != notes.deferrals[codes[j]] {
+			return notes.deferrals[codes[i]] > notes.deferrals[codes[j]]
+		}
+		return codes[i] < codes[j]
+	})
+	if deferred > 0 {
+		var counts []string
+		for _, code := range codes {
+			counts = append(counts, fmt.Sprintf("%s %d", code, notes.deferrals[code]))
+		}
+		summary += fmt.Sprintf(", deferred cycles %d (%s)", deferred, strings.Join(counts, ", "))
+	}
+
+	var hints []string
+	days := period.Hours() / 24
+	switch {
+	case notes.nearMisses >= nearMissHint:
+		shorter := notes.longestGap.Truncate(time.Minute)
+		if shorter == notes.longestGap || shorter == 0 {
+			shorter = notes.longestGap.Truncate(time.Second)
+		}
+		hints = append(hints, fmt.Sprintf("%d idle gaps ended just short of the idle time, -i %s would have spun it down",
+			notes.nearMisses, FormatDuration(shorter)))
+	case spindowns == 0 && deferred == 0 && period >= idleTime:
+		hints = append(hints, fmt.Sprintf("never idle for %s, something keeps using it, --disk-debug shows its I/O every cycle",
+			FormatDuration(idleTime)))
+	}
+	if days > 0 && float64(spinups) > spinupsHint*days {
+		hint := fmt.Sprintf("%d spin ups wear the disk, a longer idle time saves start/stop cycles", spinups)
+		if !m.config.Defaults.Advisor {
+			hint += ", --advisor tells the systemd timers waking it"
+		}
+		hints = append(hints, hint)
+	}
+	if deferred > 0 {
+		if hint, found := deferralHints[codes[0]]; found {
+			hints = append(hints, hint)
+		}
+	}
+	return summary, hints
+}
@@ -0,0 +1,88 @@
+// hd-idle - spin down idle hard disks
+// Copyright (C) 2018  Andoni del Olmo
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package hdidle
+
+import (
+	"bytes"
+	"github.com/adelolmo/hd-idle/diskstats"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDailyReport(t *testing.T) {
+	simulation, err := ParseSimulation("dry-run")
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := NewConfig()
+	config.Defaults.Simulation = simulation
+	config.Defaults.DailyReport = 6 * time.Hour
+	config.SkewTime = 24 * time.Hour
+	config.Devices = []DeviceConf{{Name: "sdb", Idle: 10 * time.Minute, CommandType: SCSI}}
+	m := New(config)
+	var out bytes.Buffer
+	m.SetOutput(&out)
+
+	start := time.Date(2026, 10, 14, 12, 0, 0, 0, time.Local)
+	m.now = start
+	m.updateState(diskstats.DiskStats{Name: "sdb"})
+	m.snapshots[0].LastIoAt = start
+	m.dailyReport()
+	/* busy again just short of the idle time, three times */
+	for i := 1; i <= 3; i++ {
+		m.now = m.now.Add(9*time.Minute + 30*time.Second)
+		m.updateState(diskstats.DiskStats{Name: "sdb", Reads: i})
+	}
+	m.emitCode(EventSpindownDeferred, "sdb", "DISCARD", "discard in progress")
+
+	m.now = time.Date(2026, 10, 15, 5, 59, 0, 0, time.Local)
+	m.dailyReport()
+	if strings.Contains(out.String(), "daily report") {
+		t.Fatalf("Expected no report before 06:00 but found %s", out.String())
+	}
+	m.now = m.now.Add(2 * time.Minute)
+	m.dailyReport()
+	for _, expected := range []string{
+		"daily report sdb: spun down 0% of 18h1m, spin downs 0, spin ups 0, near misses 3, longest idle gap 9m30s of 10m, deferred cycles 1 (DISCARD 1)",
+		"daily report sdb hint: 3 idle gaps ended just short of the idle time, -i 9m would have spun it down",
+		"daily report sdb hint: discards kept it busy",
+	} {
+		if !strings.Contains(out.String(), expected) {
+			t.Errorf("Expected %q in\n%s", expected, out.String())
+		}
+	}
+
+	/* the next report starts afresh */
+	out.Reset()
+	m.now = m.now.Add(24 * time.Hour)
+	m.dailyReport()
+	if !strings.Contains(out.String(), "near misses 0") || strings.Contains(out.String(), "deferred") {
+		t.Fatalf("Expected an empty day but found %s", out.String())
+	}
+}
+
+func TestParseDailyReport(t *testing.T) {
+	for s, expected := range map[string]time.Duration{"off": DailyReportOff, "00:00": 0, "06:30": 6*time.Hour + 30*time.Minute} {
+		if at, err := ParseDailyReport(s); err != nil || at != expected || FormatDailyReport(at) != s {
+			t.Errorf("Expected %s to be %v but found %v: %v", s, expected, at, err)
+		}
+	}
+	if _, err := ParseDailyReport("6am"); err == nil {
+		t.Error("Expected 6am refused")
+	}
+}
@@ -0,0 +1,106 @@
+// hd-idle - spin down idle hard disks
+// Copyright (C) 2018  Andoni del Olmo
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package hdidle
+
+import (
+	"fmt"
+	"github.com/adelolmo/hd-idle/io"
+	"github.com/adelolmo/hd-idle/sysfs"
+	"strconv"
+	"strings"
+)
+
+/* replaced in tests */
+var deviceMapperStatus = io.DeviceMapperStatus
+
+type deviceMapperTargets []io.DeviceMapperTarget
+
+/*
+ * An LVM snapshot merging into its origin, or a thin pool committing its
+ * metadata, stalls for a long time when the disk underneath has to spin up
+ * again in the middle of it. Before spinning down a disk holding device
+ * mapper devices, dmsetup is asked for their status, and the spin down waits
+ * while a merge runs or while the metadata of a thin pool changed since the
+ * previous look. Empty when the spin down need not wait.
+ */
+func (m *Monitor) deviceMapperActivity(disk string) string {
+	devices, err := sysfs.DevicesOn(disk)
+	if err != nil {
+		return ""
+	}
+	names := map[string]bool{}
+	for _, device := range devices {
+		if name, err := sysfs.DeviceMapperName(device); err == nil {
+			names[name] = true
+		}
+	}
+	if len(names) == 0 {
+		return ""
+	}
+	if !m.dmTargetsAt.Equal(m.now) {
+		m.dmTargets, err = deviceMapperStatus()
+		m.dmTargetsAt = m.now
+		if err != nil && m.debug(disk) {
+			m.printf("cannot read the device mapper status: %s\n", err)
+		}
+	}
+
+	activity := ""
+	for _, target := range m.dmTargets {
+		if !names[target.Device] {
+			continue
+		}
+		switch target.Target {
+		case "snapshot-merge":
+			if merging(target.Status) {
+				activity = fmt.Sprintf("snapshot merge into %s in progress", target.Device)
+			}
+		case "thin-pool":
+			if len(target.Status) < 2 {
+				continue
+			}
+			/* the transaction id and the used metadata blocks */
+			metadata := target.Status[0] + " " + target.Status[1]
+			if m.thinPools[target.Device] != metadata {
+				activity = fmt.Sprintf("metadata of thin pool %s changing", target.Device)
+			}
+			m.thinPools[target.Device] = metadata
+		}
+	}
+	return activity
+}
+
+/* <allocated sectors>/<total sectors> <metadata sectors>, merged once only the metadata is left */
+func merging(status []string) bool {
+	if len(status) < 2 {
+		return false
+	}
+	allocated := strings.Split(status[0], "/")
+	if len(allocated) != 2 {
+		/* Invalid or Merge failed */
+		return false
+	}
+	sectors, err := strconv.ParseUint(allocated[0], 10, 64)
+	if err != nil {
+		return false
+	}
+	metadata, err := strconv.ParseUint(status[1], 10, 64)
+	if err != nil {
+		return false
+	}
+	return sectors > metadata
+}
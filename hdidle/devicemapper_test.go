@@ -0,0 +1,102 @@
+// hd-idle - spin down idle hard disks
+// Copyright (C) 2018  Andoni del Olmo
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package hdidle
+
+import (
+	"github.com/adelolmo/hd-idle/io"
+	"github.com/adelolmo/hd-idle/sysfs"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDeviceMapperBusy(t *testing.T) {
+	dir, err := ioutil.TempDir("", "devicemapper")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	write := func(path, content string) {
+		mustMkdir(t, filepath.Dir(filepath.Join(dir, path)))
+		if err := ioutil.WriteFile(filepath.Join(dir, path), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	link := func(target, name string) {
+		mustMkdir(t, filepath.Dir(filepath.Join(dir, name)))
+		if err := os.Symlink(filepath.Join(dir, target), filepath.Join(dir, name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	/* the volume dm-0 is on sdb, sdc holds no device mapper device */
+	write("devices/virtual/block/dm-0/dm/name", "vg0-data\n")
+	mustMkdir(t, filepath.Join(dir, "devices/pci0000:00/host1/block/sdb/sdb1/holders"))
+	write("devices/pci0000:00/host1/block/sdb/sdb1/partition", "1")
+	link("devices/virtual/block/dm-0", "devices/pci0000:00/host1/block/sdb/sdb1/holders/dm-0")
+	mustMkdir(t, filepath.Join(dir, "devices/pci0000:00/host2/block/sdc"))
+	link("devices/pci0000:00/host1/block/sdb", "block/sdb")
+	link("devices/pci0000:00/host2/block/sdc", "block/sdc")
+	link("devices/virtual/block/dm-0", "block/dm-0")
+	root := sysfs.Root
+	sysfs.Root = dir
+	defer func() { sysfs.Root = root }()
+
+	status := ""
+	calls := 0
+	defer func(f func() ([]io.DeviceMapperTarget, error)) { deviceMapperStatus = f }(deviceMapperStatus)
+	deviceMapperStatus = func() ([]io.DeviceMapperTarget, error) {
+		calls++
+		return io.ReadDeviceMapperStatus(strings.NewReader(status))
+	}
+
+	m := New(NewConfig())
+	m.SetOutput(ioutil.Discard)
+	start := time.Now()
+	look := func(now time.Time, disk string) bool {
+		m.now = now
+		return len(m.deviceMapperActivity(disk)) > 0
+	}
+
+	status = "vg0-data: 0 2097152 snapshot-merge 1024/4194304 16\n"
+	if !look(start, "sdb") {
+		t.Fatal("Expected sdb busy while the snapshot merges")
+	}
+	if look(start, "sdc") || calls != 1 {
+		t.Fatalf("Expected sdc left alone without asking dmsetup, asked %d times", calls)
+	}
+	status = "vg0-data: 0 2097152 snapshot-merge 16/4194304 16\n"
+	if look(start.Add(time.Minute), "sdb") {
+		t.Fatal("Expected sdb idle once the merge is over")
+	}
+
+	/* the first look at a thin pool defers, then only a change of its metadata does */
+	status = "vg0-data: 0 8388608 thin-pool 3 160/4608 512/65536 - rw no_discard_passdown queue_if_no_space - 1024\n"
+	if !look(start.Add(2*time.Minute), "sdb") {
+		t.Fatal("Expected sdb busy at the first look at the thin pool")
+	}
+	if look(start.Add(3*time.Minute), "sdb") {
+		t.Fatal("Expected sdb idle while the thin pool metadata stays put")
+	}
+	status = "vg0-data: 0 8388608 thin-pool 4 168/4608 520/65536 - rw no_discard_passdown queue_if_no_space - 1024\n"
+	if !look(start.Add(4*time.Minute), "sdb") {
+		t.Fatal("Expected sdb busy while the thin pool metadata changes")
+	}
+}
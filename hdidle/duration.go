@@ -0,0 +1,60 @@
+// hd-idle - spin down idle hard disks
+// Copyright (C) 2018  Andoni del Olmo
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package hdidle
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ParseDuration reads a time given on the command line or in a
+// configuration file: a number of seconds (e.g. 600) or a duration with
+// units (e.g. 10m or 1h30m).
+func ParseDuration(s string) (time.Duration, error) {
+	if seconds, err := strconv.Atoi(s); err == nil {
+		if seconds < 0 {
+			return 0, fmt.Errorf("negative time %s", s)
+		}
+		return time.Duration(seconds) * time.Second, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, fmt.Errorf("expected seconds or a duration like 10m or 1h30m but found %s", s)
+	}
+	if d < 0 {
+		return 0, fmt.Errorf("negative time %s", s)
+	}
+	return d, nil
+}
+
+// FormatDuration prints a duration the way it can be given, without the
+// zero units time.Duration prints, e.g. 10m instead of 10m0s.
+func FormatDuration(d time.Duration) string {
+	if d == 0 {
+		return "0"
+	}
+	s := d.String()
+	if strings.HasSuffix(s, "m0s") {
+		s = strings.TrimSuffix(s, "0s")
+	}
+	if strings.HasSuffix(s, "h0m") {
+		s = strings.TrimSuffix(s, "0m")
+	}
+	return s
+}
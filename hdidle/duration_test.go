@@ -0,0 +1,61 @@
+// hd-idle - spin down idle hard disks
+// Copyright (C) 2018  Andoni del Olmo
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package hdidle
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseDuration(t *testing.T) {
+	for s, expected := range map[string]time.Duration{
+		"600":   10 * time.Minute,
+		"0":     0,
+		"10m":   10 * time.Minute,
+		"1h30m": 90 * time.Minute,
+		"90s":   90 * time.Second,
+	} {
+		d, err := ParseDuration(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if d != expected {
+			t.Errorf("Expected %v for %s but found %v", expected, s, d)
+		}
+	}
+	for _, s := range []string{"-1", "-5m", "10 minutes", ""} {
+		if _, err := ParseDuration(s); err == nil {
+			t.Errorf("Expected %q to be refused", s)
+		}
+	}
+}
+
+func TestFormatDuration(t *testing.T) {
+	for d, expected := range map[time.Duration]string{
+		0:                          "0",
+		90 * time.Second:           "1m30s",
+		10 * time.Minute:           "10m",
+		time.Hour:                  "1h",
+		90 * time.Minute:           "1h30m",
+		time.Hour + 30*time.Second: "1h0m30s",
+		1500 * time.Millisecond:    "1.5s",
+	} {
+		if s := FormatDuration(d); s != expected {
+			t.Errorf("Expected %s for %v but found %s", expected, d, s)
+		}
+	}
+}
@@ -0,0 +1,109 @@
+// hd-idle - spin down idle hard disks
+// Copyright (C) 2018  Andoni del Olmo
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package hdidle
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// EffectiveDisk is the configuration a disk ends up with once the defaults,
+// the configuration files, the patterns and the kind of disk are applied.
+type EffectiveDisk struct {
+	Disk        string // kernel name, empty for a disk named with -a that is not plugged in
+	GivenName   string // the -a or pattern the settings come from, empty for the defaults
+	Media       string
+	Excluded    bool
+	Idle        time.Duration // 0 for never
+	CommandType string
+	LogFile     string
+}
+
+// EffectiveConfig resolves the configuration of the given disks, e.g. those
+// of /proc/diskstats, and of the disks named with -a that are not among them,
+// sorted by name. Telling the kind of the disks may send them IDENTIFY.
+func (m *Monitor) EffectiveConfig(disks []string) []EffectiveDisk {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var effective []EffectiveDisk
+	present := map[string]bool{}
+	for _, disk := range disks {
+		present[disk] = true
+		device := m.config.deviceConfig(disk)
+		m.media[disk] = m.mediaOf(disk)
+		idle := m.idleTime(disk, device)
+		excluded := m.excluded(disk)
+		if excluded {
+			idle = 0
+		}
+		effective = append(effective, EffectiveDisk{
+			Disk:        disk,
+			GivenName:   device.GivenName,
+			Media:       m.media[disk],
+			Excluded:    excluded,
+			Idle:        idle,
+			CommandType: device.CommandType,
+			LogFile:     m.logFileOf(disk),
+		})
+	}
+	for _, device := range m.config.Devices {
+		if IsDevicePattern(device.GivenName) || (len(device.Name) > 0 && present[device.Name]) {
+			continue
+		}
+		logFile := device.LogFile
+		if len(logFile) == 0 {
+			logFile = m.config.Defaults.LogFile
+		}
+		effective = append(effective, EffectiveDisk{
+			GivenName:   device.GivenName,
+			Idle:        device.Idle,
+			CommandType: device.CommandType,
+			LogFile:     logFile,
+		})
+	}
+	sort.SliceStable(effective, func(i, j int) bool {
+		if effective[i].Disk != effective[j].Disk {
+			return effective[i].Disk < effective[j].Disk
+		}
+		return effective[i].GivenName < effective[j].GivenName
+	})
+	return effective
+}
+
+/* disk=sdb name=/dev/disk/by-id/ata-... media=hdd excluded=false idle=30m command=ata log=/var/log/hd-idle.log */
+func (d EffectiveDisk) String() string {
+	return strings.Join([]string{
+		"disk=" + quoteValue(d.Disk),
+		"name=" + quoteValue(d.GivenName),
+		"media=" + quoteValue(d.Media),
+		"excluded=" + strconv.FormatBool(d.Excluded),
+		"idle=" + FormatDuration(d.Idle),
+		"command=" + d.CommandType,
+		"log=" + quoteValue(d.LogFile),
+	}, " ")
+}
+
+/* values that are empty or have blanks or quotes are quoted */
+func quoteValue(value string) string {
+	if len(value) == 0 || strings.ContainsAny(value, " \t\"") {
+		return strconv.Quote(value)
+	}
+	return value
+}
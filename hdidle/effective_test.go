@@ -0,0 +1,51 @@
+// hd-idle - spin down idle hard disks
+// Copyright (C) 2018  Andoni del Olmo
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package hdidle
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestEffectiveConfig(t *testing.T) {
+	config := NewConfig()
+	config.Defaults.Idle = 10 * time.Minute
+	config.Defaults.LogFile = "/var/log/hd-idle.log"
+	config.Defaults.Exclude = []string{"sda"}
+	config.Devices = []DeviceConf{
+		{Name: "sdc", GivenName: "/dev/disk/by-id/ata-WDC_WD40EFRX", Idle: time.Hour, CommandType: ATA, LogFile: "/var/log/parity.log"},
+		{Name: "sd[d-f]", GivenName: "sd[d-f]", Idle: 0, CommandType: SCSI},
+		{GivenName: "serial:WD-WCC4E1234567", Idle: 5 * time.Minute, CommandType: SCSI},
+	}
+	m := New(config)
+
+	expected := []string{
+		`disk="" name=serial:WD-WCC4E1234567 media="" excluded=false idle=5m command=scsi log=/var/log/hd-idle.log`,
+		`disk=sda name="" media=hdd excluded=true idle=0 command=scsi log=/var/log/hd-idle.log`,
+		`disk=sdb name="" media=hdd excluded=false idle=10m command=scsi log=/var/log/hd-idle.log`,
+		`disk=sdc name=/dev/disk/by-id/ata-WDC_WD40EFRX media=hdd excluded=false idle=1h command=ata log=/var/log/parity.log`,
+		`disk=sde name=sd[d-f] media=hdd excluded=false idle=0 command=scsi log=/var/log/hd-idle.log`,
+	}
+	var lines []string
+	for _, disk := range m.EffectiveConfig([]string{"sdc", "sda", "sde", "sdb"}) {
+		lines = append(lines, disk.String())
+	}
+	if !reflect.DeepEqual(lines, expected) {
+		t.Fatalf("Expected\n%v\nbut found\n%v", expected, lines)
+	}
+}
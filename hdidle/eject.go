@@ -0,0 +1,128 @@
+// hd-idle - spin down idle hard disks
+// Copyright (C) 2018  Andoni del Olmo
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package hdidle
+
+import (
+	"fmt"
+	"github.com/adelolmo/hd-idle/sysfs"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// EjectOptions tells what Eject does besides spinning the disk down.
+type EjectOptions struct {
+	CommandType string
+	UsbPowerOff bool // power off the usb port of the disk
+	Notify      bool // send a desktop notification with notify-send
+	Locate      bool // turn on the locate led of the enclosure slot
+}
+
+/* the disk operations of an eject, replaced in tests */
+type ejector struct {
+	mounts   func() []string
+	flush    func() error
+	spindown func() error
+	standby  func() (bool, error)
+	powerOff func() error
+	locate   func() error
+	notify   func(message string) error
+}
+
+// Eject prepares a disk to be unplugged: it flushes its write cache, spins
+// it down and checks it stopped, then signals the disk can go as the options
+// tell, writing the progress to out. Mounted disks are refused.
+func Eject(disk string, options EjectOptions, out io.Writer) error {
+	device := fmt.Sprintf("/dev/%s", disk)
+	e := ejector{
+		mounts:   func() []string { return diskMountPoints(disk) },
+		flush:    func() error { return flushDisk(device) },
+		spindown: func() error { return SpindownDisk(device, options.CommandType) },
+		standby:  func() (bool, error) { return DiskStandby(device, options.CommandType) },
+		powerOff: func() error {
+			port, err := sysfs.UsbPort(disk)
+			if err != nil {
+				return err
+			}
+			return sysfs.SetUsbPortPower(port, false)
+		},
+		locate: func() error {
+			slot, err := sysfs.EnclosureSlot(disk)
+			if err != nil {
+				return err
+			}
+			return sysfs.SetEnclosureLocate(slot, true)
+		},
+		notify: func(message string) error {
+			return exec.Command("notify-send", "--icon=drive-harddisk", "hd-idle", message).Run()
+		},
+	}
+	return e.eject(disk, options, out)
+}
+
+func (e ejector) eject(disk string, options EjectOptions, out io.Writer) error {
+	if mounts := e.mounts(); len(mounts) > 0 {
+		return &classifiedError{class: FailureBusy,
+			err: fmt.Errorf("%s is mounted on %s, unmount it first", disk, strings.Join(mounts, ", "))}
+	}
+	if err := e.flush(); err != nil {
+		return deviceError(err, fmt.Errorf("cannot flush %s: %s", disk, err))
+	}
+	fmt.Fprintf(out, "%s flushed\n", disk)
+	if err := e.spindown(); err != nil {
+		return err
+	}
+	if standby, err := e.standby(); err != nil {
+		fmt.Fprintf(out, "cannot check %s stopped: %s\n", disk, err)
+	} else if !standby {
+		return &classifiedError{class: FailureStillSpinning, err: fmt.Errorf("%s is still spinning after the spindown", disk)}
+	}
+	fmt.Fprintf(out, "%s spun down\n", disk)
+
+	/* the disk is safe to unplug now, the rest only tells so */
+	if options.UsbPowerOff {
+		if err := e.powerOff(); err != nil {
+			fmt.Fprintf(out, "cannot power off usb port of %s: %s\n", disk, err)
+		} else {
+			fmt.Fprintf(out, "%s usb port powered off\n", disk)
+		}
+	}
+	if options.Locate {
+		if err := e.locate(); err != nil {
+			fmt.Fprintf(out, "cannot turn on locate led of %s: %s\n", disk, err)
+		}
+	}
+	message := fmt.Sprintf("%s can be unplugged", disk)
+	fmt.Fprintln(out, message)
+	if options.Notify {
+		if err := e.notify(message); err != nil {
+			fmt.Fprintf(out, "cannot send notification: %s\n", err)
+		}
+	}
+	return nil
+}
+
+/* fsync on a block device writes its dirty pages and flushes its write cache */
+func flushDisk(device string) error {
+	f, err := os.Open(device)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return f.Sync()
+}
@@ -0,0 +1,76 @@
+// hd-idle - spin down idle hard disks
+// Copyright (C) 2018  Andoni del Olmo
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package hdidle
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+/* a disk that stops when told, recording the steps */
+func fakeEjector(steps *[]string) ejector {
+	standby := false
+	step := func(name string) func() error {
+		return func() error { *steps = append(*steps, name); return nil }
+	}
+	return ejector{
+		mounts:   func() []string { return nil },
+		flush:    step("flush"),
+		spindown: func() error { *steps = append(*steps, "spindown"); standby = true; return nil },
+		standby:  func() (bool, error) { return standby, nil },
+		powerOff: func() error { return errors.New("no per-port power switching") },
+		locate:   step("locate"),
+		notify:   func(message string) error { *steps = append(*steps, message); return nil },
+	}
+}
+
+func TestEject(t *testing.T) {
+	var steps []string
+	var out bytes.Buffer
+	err := fakeEjector(&steps).eject("sdb", EjectOptions{UsbPowerOff: true, Notify: true, Locate: true}, &out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"flush", "spindown", "locate", "sdb can be unplugged"}
+	if strings.Join(steps, "|") != strings.Join(expected, "|") {
+		t.Fatalf("Expected %v but found %v", expected, steps)
+	}
+	if !strings.Contains(out.String(), "cannot power off usb port of sdb") {
+		t.Fatalf("Expected the usb port failure reported but found\n%s", out.String())
+	}
+}
+
+func TestEjectRefused(t *testing.T) {
+	var steps []string
+	e := fakeEjector(&steps)
+	e.mounts = func() []string { return []string{"/media/backup"} }
+	if err := e.eject("sdb", EjectOptions{Notify: true}, ioutil.Discard); err == nil || len(steps) != 0 || FailureClass(err) != FailureBusy {
+		t.Fatalf("Expected a mounted disk refused but found %v after %v", err, steps)
+	}
+
+	e = fakeEjector(&steps)
+	e.spindown = func() error { return nil }
+	if err := e.eject("sdb", EjectOptions{Notify: true}, ioutil.Discard); err == nil || FailureClass(err) != FailureStillSpinning {
+		t.Fatalf("Expected an error for a disk still spinning but found %v", err)
+	}
+	if len(steps) != 1 {
+		t.Fatalf("Expected no notification but found %v", steps)
+	}
+}
@@ -0,0 +1,186 @@
+// hd-idle - spin down idle hard disks
+// Copyright (C) 2018  Andoni del Olmo
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package hdidle
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"time"
+)
+
+const (
+	epochsStateFile = "epochs.json"
+	// FirstEpoch names the period before the first annotation.
+	FirstEpoch = "0"
+)
+
+// Epoch is a period of the history started by an annotation, e.g. "changed
+// idle from 10m to 20m", so changes in the behavior of the disks can be
+// attributed to it.
+type Epoch struct {
+	Name  string    `json:"name"`
+	Note  string    `json:"note"`
+	Disk  string    `json:"disk,omitempty"` // the disk the note is about, empty for all
+	Start time.Time `json:"start"`
+	// Disks are the statistics when the epoch started, by serial number.
+	Disks map[string]EpochDisk `json:"disks"`
+}
+
+type EpochDisk struct {
+	Name       string         `json:"name"`
+	Statistics DiskStatistics `json:"statistics"`
+}
+
+// EpochReport tells what the disks did during an epoch.
+type EpochReport struct {
+	Name  string
+	Note  string
+	Disk  string
+	Start time.Time
+	End   time.Time // now for the current epoch
+	Disks []EpochDiskReport
+}
+
+type EpochDiskReport struct {
+	Name              string
+	SpindownAttempts  int
+	Spindowns         int
+	SpindownsVerified int
+	Spinups           int
+	SpunDownTime      time.Duration
+}
+
+// Annotate ends the current epoch and starts a new one with the given note.
+// The name defaults to the number of the epoch, the disk is optional.
+func (m *Monitor) Annotate(name, note, disk string) (Epoch, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.loadEpochs()
+	if len(name) == 0 {
+		name = strconv.Itoa(len(m.epochs) + 1)
+	}
+	if name == FirstEpoch {
+		return Epoch{}, fmt.Errorf("epoch %s is the one before the first annotation", FirstEpoch)
+	}
+	for _, e := range m.epochs {
+		if e.Name == name {
+			return Epoch{}, fmt.Errorf("epoch %s already exists", name)
+		}
+	}
+
+	epoch := Epoch{Name: name, Note: note, Disk: disk, Start: time.Now(), Disks: m.epochDisks()}
+	m.epochs = append(m.epochs, epoch)
+	if m.config.Defaults.CheckpointInterval > 0 {
+		if err := m.writeState(epochsStateFile, m.epochs); err != nil {
+			m.printf("Cannot save the epochs: %s\n", err)
+		}
+	}
+	text := fmt.Sprintf("epoch %s: %s", name, note)
+	if len(disk) > 0 {
+		text = fmt.Sprintf("epoch %s: %s: %s", name, disk, note)
+	}
+	m.println(text)
+	m.logToFile(m.config.Defaults.LogFile, fmt.Sprintf("date: %s, time: %s, %s",
+		epoch.Start.Format("2006-01-02"), epoch.Start.Format("15:04:05"), text))
+	return epoch, nil
+}
+
+// Epochs reports every epoch, starting with FirstEpoch.
+func (m *Monitor) Epochs() []EpochReport {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.loadEpochs()
+
+	first := Epoch{Name: FirstEpoch, Start: time.Now(), Disks: map[string]EpochDisk{}}
+	for _, s := range m.statistics {
+		if s.Since.Before(first.Start) {
+			first.Start = s.Since
+		}
+	}
+	if len(m.epochs) > 0 && m.epochs[0].Start.Before(first.Start) {
+		first.Start = m.epochs[0].Start
+	}
+	epochs := append([]Epoch{first}, m.epochs...)
+	current := Epoch{Start: time.Now(), Disks: m.epochDisks()}
+
+	var reports []EpochReport
+	for i, e := range epochs {
+		end := current
+		if i+1 < len(epochs) {
+			end = epochs[i+1]
+		}
+		reports = append(reports, EpochReport{
+			Name:  e.Name,
+			Note:  e.Note,
+			Disk:  e.Disk,
+			Start: e.Start,
+			End:   end.Start,
+			Disks: epochDiff(e.Disks, end.Disks),
+		})
+	}
+	return reports
+}
+
+/* the statistics now, by serial number, with the names of the disks */
+func (m *Monitor) epochDisks() map[string]EpochDisk {
+	names := map[string]string{}
+	for _, ds := range m.snapshots {
+		names[m.identityKey(ds.Name)] = ds.Name
+	}
+	disks := map[string]EpochDisk{}
+	for key, s := range m.statistics {
+		name, found := names[key]
+		if !found {
+			name = key
+		}
+		disks[key] = EpochDisk{Name: name, Statistics: *s}
+	}
+	return disks
+}
+
+func epochDiff(start, end map[string]EpochDisk) []EpochDiskReport {
+	var reports []EpochDiskReport
+	for key, e := range end {
+		s := start[key].Statistics
+		reports = append(reports, EpochDiskReport{
+			Name:              e.Name,
+			SpindownAttempts:  e.Statistics.SpindownAttempts - s.SpindownAttempts,
+			Spindowns:         e.Statistics.Spindowns - s.Spindowns,
+			SpindownsVerified: e.Statistics.SpindownsVerified - s.SpindownsVerified,
+			Spinups:           e.Statistics.Spinups - s.Spinups,
+			SpunDownTime:      e.Statistics.SpunDownTime - s.SpunDownTime,
+		})
+	}
+	sort.Slice(reports, func(i, j int) bool { return reports[i].Name < reports[j].Name })
+	return reports
+}
+
+/* the epochs of earlier runs, read once */
+func (m *Monitor) loadEpochs() {
+	if m.epochsLoaded {
+		return
+	}
+	m.epochsLoaded = true
+	if m.config.Defaults.CheckpointInterval == 0 {
+		return
+	}
+	if err := m.readState(epochsStateFile, &m.epochs); err != nil && !os.IsNotExist(err) {
+		m.printf("Ignoring state file %s: %s\n", epochsStateFile, err)
+	}
+}
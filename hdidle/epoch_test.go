@@ -0,0 +1,66 @@
+// hd-idle - spin down idle hard disks
+// Copyright (C) 2018  Andoni del Olmo
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package hdidle
+
+import (
+	"github.com/adelolmo/hd-idle/diskstats"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestEpochs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "state")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	config := NewConfig()
+	config.Defaults.StateDir = dir
+	config.Defaults.CheckpointInterval = time.Minute
+	m := New(config)
+	m.SetOutput(ioutil.Discard)
+	m.identityKeys["sda"] = "WD-1234"
+	m.snapshots = []diskstats.DiskStats{{Name: "sda"}}
+
+	m.countSpindown("sda")
+	m.countSpinup("sda")
+	if _, err := m.Annotate("", "changed idle from 10m to 20m", "sda"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.Annotate("1", "again", ""); err == nil {
+		t.Fatal("Expected an error for a duplicate epoch")
+	}
+	m.countSpindown("sda")
+
+	restarted := New(config)
+	restarted.SetOutput(ioutil.Discard)
+	restarted.statistics = m.statistics
+	restarted.identityKeys["sda"] = "WD-1234"
+	restarted.snapshots = m.snapshots
+	epochs := restarted.Epochs()
+	if len(epochs) != 2 || epochs[0].Name != FirstEpoch || epochs[1].Name != "1" || epochs[1].Disk != "sda" {
+		t.Fatalf("Unexpected epochs %+v", epochs)
+	}
+	if d := epochs[0].Disks[0]; d.Name != "sda" || d.Spindowns != 1 || d.Spinups != 1 {
+		t.Fatalf("Unexpected first epoch %+v", d)
+	}
+	if d := epochs[1].Disks[0]; d.Spindowns != 1 || d.Spinups != 0 {
+		t.Fatalf("Unexpected second epoch %+v", d)
+	}
+}
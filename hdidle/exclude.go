@@ -0,0 +1,158 @@
+// hd-idle - spin down idle hard disks
+// Copyright (C) 2018  Andoni del Olmo
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package hdidle
+
+import (
+	"github.com/adelolmo/hd-idle/io"
+	"github.com/adelolmo/hd-idle/sysfs"
+	"strings"
+)
+
+/* replaced in tests */
+var (
+	rootDisks = RootDisks
+	swapDisks = SwapDisks
+)
+
+/*
+ * Disks given with -x are never managed: no spindown, spinup or any other
+ * command, whatever the idle time. They are named like with -a, so a system
+ * disk can be excluded by a name that survives reboots.
+ */
+func (m *Monitor) excluded(disk string) bool {
+	if !m.config.Defaults.ManageRoot && m.rootDisk(disk) {
+		return true
+	}
+	if !m.config.Defaults.ManageSwap && m.swapDisks[disk] {
+		return true
+	}
+	for _, name := range m.config.Defaults.Exclude {
+		if IsDevicePattern(name) {
+			pattern := DeviceConf{Name: name}
+			if pattern.patternRank(disk) >= 0 {
+				return true
+			}
+			continue
+		}
+		if realPath, err := io.RealPath(name); err == nil && realPath == disk {
+			return true
+		}
+	}
+	return false
+}
+
+// RootDisks returns the whole disks holding the root filesystem, e.g. [sda]
+// or both disks of a mirror, walking partitions and device mapper volumes
+// down. Filesystems without a block device of their own, e.g. btrfs, are
+// found by their mount source.
+func RootDisks() ([]string, error) {
+	disks, err := sysfs.DisksForPath("/")
+	if err == nil {
+		return disks, nil
+	}
+	source, sourceErr := io.MountSource("/")
+	if sourceErr != nil || !strings.HasPrefix(source, "/dev/") {
+		return nil, err
+	}
+	return sysfs.DisksForNode(source)
+}
+
+/*
+ * Spinning the system disk down stalls the machine at every log write, so
+ * the disks of the root filesystem are left alone like those given with -x,
+ * unless --manage-root is given. They are found once.
+ */
+func (m *Monitor) rootDisk(disk string) bool {
+	if m.rootDisks == nil {
+		m.rootDisks = []string{}
+		disks, err := rootDisks()
+		if err != nil && m.config.Defaults.Debug {
+			m.printf("cannot find the disks of the root filesystem: %s\n", err)
+		}
+		for _, name := range disks {
+			m.rootDisks = append(m.rootDisks, name)
+			m.printf("disk=%s holds the root filesystem, not managed without --manage-root\n", name)
+		}
+	}
+	for _, name := range m.rootDisks {
+		if name == disk {
+			return true
+		}
+	}
+	return false
+}
+
+// SwapDisks returns the whole disks holding the active swap areas, the
+// partitions and those holding swap files.
+func SwapDisks() ([]string, error) {
+	swaps, err := io.Swaps()
+	if err != nil {
+		return nil, err
+	}
+	var disks []string
+	seen := map[string]bool{}
+	for _, swap := range swaps {
+		var found []string
+		if swap.Partition {
+			found, err = sysfs.DisksForNode(swap.File)
+		} else {
+			found, err = sysfs.DisksForPath(swap.File)
+		}
+		if err != nil {
+			return nil, err
+		}
+		for _, disk := range found {
+			if !seen[disk] {
+				seen[disk] = true
+				disks = append(disks, disk)
+			}
+		}
+	}
+	return disks, nil
+}
+
+/*
+ * A disk holding active swap is spun up again by the first page swapped in,
+ * so it is left alone like those given with -x, unless --manage-swap is
+ * given. Swap comes and goes with swapon and swapoff, so it is looked up
+ * every cycle.
+ */
+func (m *Monitor) updateSwap() {
+	if m.config.Defaults.ManageSwap {
+		return
+	}
+	disks, err := swapDisks()
+	if err != nil {
+		if m.config.Defaults.Debug {
+			m.printf("cannot find the disks of the swap areas: %s\n", err)
+		}
+		return
+	}
+	current := map[string]bool{}
+	for _, name := range disks {
+		current[name] = true
+		if !m.swapDisks[name] {
+			m.printf("disk=%s holds active swap, not managed without --manage-swap\n", name)
+		}
+	}
+	for name := range m.swapDisks {
+		if !current[name] {
+			m.printf("disk=%s holds no swap anymore\n", name)
+		}
+	}
+	m.swapDisks = current
+}
@@ -0,0 +1,112 @@
+// hd-idle - spin down idle hard disks
+// Copyright (C) 2018  Andoni del Olmo
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package hdidle
+
+import (
+	"github.com/adelolmo/hd-idle/diskstats"
+	"io/ioutil"
+	"testing"
+	"time"
+)
+
+func TestExcludedDisks(t *testing.T) {
+	simulation, err := ParseSimulation("dry-run")
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := NewConfig()
+	config.Defaults.Simulation = simulation
+	config.Defaults.Idle = time.Minute
+	config.Defaults.Exclude = []string{"sda", "sd[x-z]"}
+	config.SkewTime = 24 * time.Hour
+	m := New(config)
+	m.SetOutput(ioutil.Discard)
+
+	start := time.Now()
+	for _, now := range []time.Time{start, start.Add(10 * time.Minute)} {
+		m.now = now
+		for _, disk := range []string{"sda", "sdb", "sdy"} {
+			m.updateState(diskstats.DiskStats{Name: disk})
+		}
+		m.lastNow = now
+	}
+	if len(m.snapshots) != 1 || m.snapshots[0].Name != "sdb" || !m.snapshots[0].SpunDown {
+		t.Fatalf("Expected only sdb managed and spun down but found %+v", m.snapshots)
+	}
+
+	/* a reload excluding a managed disk */
+	config.Defaults.Exclude = append(config.Defaults.Exclude, "sdb")
+	m.updateState(diskstats.DiskStats{Name: "sdb"})
+	if len(m.snapshots) != 0 {
+		t.Fatalf("Expected sdb forgotten but found %+v", m.snapshots)
+	}
+}
+
+func TestRootDiskExcluded(t *testing.T) {
+	rootDisks = func() ([]string, error) { return []string{"sda"}, nil }
+	defer func() { rootDisks = func() ([]string, error) { return nil, nil } }()
+
+	for _, manageRoot := range []bool{false, true} {
+		config := NewConfig()
+		config.Defaults.ManageRoot = manageRoot
+		m := New(config)
+		m.SetOutput(ioutil.Discard)
+		for _, disk := range []string{"sda", "sdb"} {
+			m.updateState(diskstats.DiskStats{Name: disk})
+		}
+		if managed := m.previousDiskStatsIndex("sda") >= 0; managed != manageRoot {
+			t.Fatalf("Expected the root disk sda managed only with --manage-root, manageRoot=%t", manageRoot)
+		}
+		if m.previousDiskStatsIndex("sdb") < 0 {
+			t.Fatal("Expected sdb managed")
+		}
+	}
+}
+
+func TestSwapDiskExcluded(t *testing.T) {
+	swaps := []string{"sdb"}
+	swapDisks = func() ([]string, error) { return swaps, nil }
+	defer func() { swapDisks = func() ([]string, error) { return nil, nil } }()
+
+	for _, manageSwap := range []bool{false, true} {
+		config := NewConfig()
+		config.Defaults.ManageSwap = manageSwap
+		m := New(config)
+		m.SetOutput(ioutil.Discard)
+		m.updateSwap()
+		for _, disk := range []string{"sda", "sdb"} {
+			m.updateState(diskstats.DiskStats{Name: disk})
+		}
+		if managed := m.previousDiskStatsIndex("sdb") >= 0; managed != manageSwap {
+			t.Fatalf("Expected the swap disk sdb managed only with --manage-swap, manageSwap=%t", manageSwap)
+		}
+		if m.previousDiskStatsIndex("sda") < 0 {
+			t.Fatal("Expected sda managed")
+		}
+	}
+
+	/* after swapoff */
+	m := New(NewConfig())
+	m.SetOutput(ioutil.Discard)
+	m.updateSwap()
+	swaps = nil
+	m.updateSwap()
+	m.updateState(diskstats.DiskStats{Name: "sdb"})
+	if m.previousDiskStatsIndex("sdb") < 0 {
+		t.Fatal("Expected sdb managed once it holds no swap")
+	}
+}
@@ -0,0 +1,197 @@
+// hd-idle - spin down idle hard disks
+// Copyright (C) 2018  Andoni del Olmo
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package hdidle
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/adelolmo/hd-idle/sysfs"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+var configfsRoot = "/sys/kernel/config"
+
+/*
+ * A disk exported as an iSCSI LUN or over NBD is read and written by the
+ * clients without any filesystem, mount or process of the host showing it.
+ * Its spin down waits until no client is connected.
+ */
+func (m *Monitor) exportedTo(name string) string {
+	if !m.config.Defaults.Exports {
+		return ""
+	}
+	if !m.exportsAt.Equal(m.now) {
+		m.exports = exportClients()
+		m.exportsAt = m.now
+	}
+	devices, err := sysfs.DevicesOn(name)
+	if err != nil {
+		devices = []string{name}
+	}
+	var clients []string
+	for _, device := range devices {
+		for _, client := range m.exports[device] {
+			clients = appendUnique(clients, client)
+		}
+	}
+	sort.Strings(clients)
+	return strings.Join(clients, ", ")
+}
+
+/* the connected clients of the exported block devices, by block device */
+func exportClients() map[string][]string {
+	clients := map[string][]string{}
+	iscsiClients(clients)
+	nbdClients(clients)
+	return clients
+}
+
+/* the initiators logged in to the LIO targets, e.g. sdb: [iqn.1993-08.org.debian:01:abc] */
+func iscsiClients(clients map[string][]string) {
+	tpgs, _ := filepath.Glob(filepath.Join(configfsRoot, "target", "iscsi", "*", "tpgt_*"))
+	for _, tpg := range tpgs {
+		initiators := iscsiSessions(tpg)
+		if len(initiators) == 0 {
+			continue
+		}
+		luns, _ := filepath.Glob(filepath.Join(tpg, "lun", "lun_*", "*"))
+		for _, lun := range luns {
+			backstore, err := filepath.EvalSymlinks(lun)
+			if err != nil || backstore == lun {
+				continue
+			}
+			path, err := ioutil.ReadFile(filepath.Join(backstore, "udev_path"))
+			if err != nil || len(strings.TrimSpace(string(path))) == 0 {
+				continue
+			}
+			device := blockDevice(strings.TrimSpace(string(path)))
+			for _, initiator := range initiators {
+				clients[device] = appendUnique(clients[device], initiator)
+			}
+		}
+	}
+}
+
+/* the initiators with a session on the target portal group */
+func iscsiSessions(tpg string) []string {
+	var initiators []string
+	if b, err := ioutil.ReadFile(filepath.Join(tpg, "dynamic_sessions")); err == nil {
+		for _, line := range strings.Split(string(b), "\n") {
+			if line = strings.TrimSpace(line); len(line) > 0 {
+				initiators = appendUnique(initiators, line)
+			}
+		}
+	}
+	acls, _ := ioutil.ReadDir(filepath.Join(tpg, "acls"))
+	for _, acl := range acls {
+		info, err := ioutil.ReadFile(filepath.Join(tpg, "acls", acl.Name(), "info"))
+		if err != nil || strings.Contains(string(info), "No active iSCSI Session") {
+			continue
+		}
+		initiators = appendUnique(initiators, acl.Name())
+	}
+	return initiators
+}
+
+/* the nbd-server and qemu-nbd processes serving a block device to a connected client */
+func nbdClients(clients map[string][]string) {
+	established := establishedSockets()
+	dirs, err := ioutil.ReadDir(procRoot)
+	if err != nil {
+		return
+	}
+	for _, dir := range dirs {
+		if _, err := strconv.Atoi(dir.Name()); err != nil {
+			continue
+		}
+		comm, err := ioutil.ReadFile(filepath.Join(procRoot, dir.Name(), "comm"))
+		if err != nil {
+			continue
+		}
+		name := strings.TrimSpace(string(comm))
+		if name != "nbd-server" && name != "qemu-nbd" {
+			continue
+		}
+		devices, sockets := openFiles(dir.Name())
+		connected := false
+		for _, socket := range sockets {
+			connected = connected || established[socket]
+		}
+		if !connected {
+			continue
+		}
+		for _, device := range devices {
+			clients[device] = appendUnique(clients[device], fmt.Sprintf("nbd client of %s (pid %s)", name, dir.Name()))
+		}
+	}
+}
+
+/* the block devices and the socket inodes a process has open */
+func openFiles(pid string) (devices []string, sockets []string) {
+	fdDir := filepath.Join(procRoot, pid, "fd")
+	fds, err := ioutil.ReadDir(fdDir)
+	if err != nil {
+		return nil, nil
+	}
+	for _, fd := range fds {
+		target, err := os.Readlink(filepath.Join(fdDir, fd.Name()))
+		if err != nil {
+			continue
+		}
+		switch {
+		case strings.HasPrefix(target, "/dev/"):
+			devices = appendUnique(devices, filepath.Base(target))
+		case strings.HasPrefix(target, "socket:[") && strings.HasSuffix(target, "]"):
+			sockets = append(sockets, target[len("socket:["):len(target)-1])
+		}
+	}
+	return devices, sockets
+}
+
+/* the inodes of the connected tcp sockets */
+func establishedSockets() map[string]bool {
+	established := map[string]bool{}
+	for _, table := range []string{"tcp", "tcp6"} {
+		f, err := os.Open(filepath.Join(procRoot, "net", table))
+		if err != nil {
+			continue
+		}
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			fields := strings.Fields(scanner.Text())
+			/* sl local_address rem_address st ... inode */
+			if len(fields) > 9 && fields[3] == "01" {
+				established[fields[9]] = true
+			}
+		}
+		f.Close()
+	}
+	return established
+}
+
+/* the kernel name of a device node, following /dev/disk/by-* links */
+func blockDevice(path string) string {
+	if resolved, err := filepath.EvalSymlinks(path); err == nil {
+		path = resolved
+	}
+	return filepath.Base(path)
+}
@@ -0,0 +1,98 @@
+// hd-idle - spin down idle hard disks
+// Copyright (C) 2018  Andoni del Olmo
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package hdidle
+
+import (
+	"github.com/adelolmo/hd-idle/sysfs"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestExportClients(t *testing.T) {
+	dir, err := ioutil.TempDir("", "exports")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	write := func(path, content string) {
+		mustMkdir(t, filepath.Dir(filepath.Join(dir, path)))
+		if err := ioutil.WriteFile(filepath.Join(dir, path), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	link := func(target, name string) {
+		mustMkdir(t, filepath.Dir(filepath.Join(dir, name)))
+		if err := os.Symlink(target, filepath.Join(dir, name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	/* sdb is a LUN with a logged in initiator, sdc a LUN nobody is logged in to */
+	write("config/target/core/iblock_0/vault/udev_path", "/dev/sdb\n")
+	write("config/target/core/iblock_1/spare/udev_path", "/dev/sdc\n")
+	tpg := "config/target/iscsi/iqn.2003-01.org.linux-iscsi.nas:vault/tpgt_1/"
+	write(tpg+"dynamic_sessions", "iqn.1993-08.org.debian:01:abc\n")
+	link(filepath.Join(dir, "config/target/core/iblock_0/vault"), tpg+"lun/lun_0/vault")
+	tpg = "config/target/iscsi/iqn.2003-01.org.linux-iscsi.nas:spare/tpgt_1/"
+	write(tpg+"dynamic_sessions", "")
+	write(tpg+"acls/iqn.1993-08.org.debian:01:def/info", "No active iSCSI Session for Initiator Endpoint: iqn.1993-08.org.debian:01:def\n")
+	link(filepath.Join(dir, "config/target/core/iblock_1/spare"), tpg+"lun/lun_0/spare")
+
+	/* qemu-nbd serves sdd to a connected client, nbd-server serves sde to nobody */
+	write("proc/2000/comm", "qemu-nbd\n")
+	link("/dev/sdd", "proc/2000/fd/5")
+	link("socket:[31337]", "proc/2000/fd/6")
+	write("proc/3000/comm", "nbd-server\n")
+	link("/dev/sde", "proc/3000/fd/5")
+	link("socket:[4242]", "proc/3000/fd/6")
+	write("proc/net/tcp", "  sl  local_address rem_address   st tx_queue rx_queue tr tm->when retrnsmt   uid  timeout inode\n"+
+		"   0: 0100007F:2A1B 0100007F:C350 01 00000000:00000000 00:00000000 00000000     0        0 31337 1 0 20 4 30 10 -1\n"+
+		"   1: 00000000:2A1B 00000000:0000 0A 00000000:00000000 00:00000000 00000000     0        0 4242 1 0 100 0 0 10 0\n")
+
+	configfsRoot = filepath.Join(dir, "config")
+	procRoot = filepath.Join(dir, "proc")
+	root := sysfs.Root
+	sysfs.Root = dir
+	defer func() {
+		configfsRoot = "/sys/kernel/config"
+		procRoot = "/proc"
+		sysfs.Root = root
+	}()
+
+	expected := map[string][]string{
+		"sdb": {"iqn.1993-08.org.debian:01:abc"},
+		"sdd": {"nbd client of qemu-nbd (pid 2000)"},
+	}
+	if clients := exportClients(); !reflect.DeepEqual(clients, expected) {
+		t.Fatalf("Expected %v but found %v", expected, clients)
+	}
+
+	config := NewConfig()
+	config.Defaults.Exports = true
+	m := New(config)
+	m.now = time.Now()
+	if clients := m.exportedTo("sdb"); clients != "iqn.1993-08.org.debian:01:abc" {
+		t.Fatalf("Expected sdb exported to the initiator but found %q", clients)
+	}
+	if clients := m.exportedTo("sdc"); clients != "" {
+		t.Fatalf("Expected sdc without clients but found %q", clients)
+	}
+}
@@ -0,0 +1,118 @@
+// hd-idle - spin down idle hard disks
+// Copyright (C) 2018  Andoni del Olmo
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package hdidle
+
+import (
+	"fmt"
+	"github.com/adelolmo/hd-idle/sgio"
+	"os"
+	"syscall"
+)
+
+// What went wrong in a one-shot command, e.g. hd-idle spindown. Each class
+// has its own exit code.
+const (
+	FailureUsage         = "usage"          // wrong options
+	FailureNoDisk        = "no_disk"        // the disk doesn't exist
+	FailurePermission    = "permission"     // the disk cannot be opened, e.g. not running as root
+	FailureUnsupported   = "unsupported"    // the disk rejects the command
+	FailureBusy          = "busy"           // the disk is in use, e.g. mounted
+	FailureCommand       = "command"        // the command failed
+	FailureStillSpinning = "still_spinning" // the disk is still spinning after the spindown
+)
+
+/* an error that knows what went wrong */
+type classifiedError struct {
+	class string
+	cause error // as returned by the device, nil if none
+	err   error
+}
+
+func (e *classifiedError) Error() string {
+	return e.err.Error()
+}
+
+// FailureClass tells what went wrong from an error of SpindownDisk,
+// SpinupDisk, DiskStandby, IdentifyDisk or Eject.
+func FailureClass(err error) string {
+	switch e := err.(type) {
+	case *classifiedError:
+		return e.class
+	case *unsupportedError:
+		return FailureUnsupported
+	}
+	return FailureCommand
+}
+
+/* keep what went wrong talking to the device, reported as err */
+func deviceError(cause, err error) error {
+	class := FailureCommand
+	switch {
+	case cause == sgio.ErrCommandNotSupported:
+		class = FailureUnsupported
+	case os.IsNotExist(cause):
+		class = FailureNoDisk
+	case os.IsPermission(cause):
+		class = FailurePermission
+	}
+	return &classifiedError{class: class, cause: cause, err: err}
+}
+
+/* names of the errno values disks fail with, the rest go by number */
+var errnoNames = map[syscall.Errno]string{
+	syscall.EIO:       "EIO",
+	syscall.EACCES:    "EACCES",
+	syscall.EPERM:     "EPERM",
+	syscall.ENOENT:    "ENOENT",
+	syscall.ENODEV:    "ENODEV",
+	syscall.ENXIO:     "ENXIO",
+	syscall.EBUSY:     "EBUSY",
+	syscall.EINVAL:    "EINVAL",
+	syscall.ENOTTY:    "ENOTTY",
+	syscall.EAGAIN:    "EAGAIN",
+	syscall.ETIMEDOUT: "ETIMEDOUT",
+	syscall.EROFS:     "EROFS",
+}
+
+/*
+ * The machine-stable code of a failed disk command: the errno name, ENOTSUP
+ * for commands the disk rejects and EIO for any other error of the device,
+ * e.g. sense data.
+ */
+func errorCode(err error) string {
+	switch e := err.(type) {
+	case *unsupportedError:
+		return "ENOTSUP"
+	case *classifiedError:
+		if e.cause != nil {
+			return errorCode(e.cause)
+		}
+	case *os.PathError:
+		return errorCode(e.Err)
+	case *os.SyscallError:
+		return errorCode(e.Err)
+	case syscall.Errno:
+		if name, found := errnoNames[e]; found {
+			return name
+		}
+		return fmt.Sprintf("ERRNO_%d", int(e))
+	}
+	if err == sgio.ErrCommandNotSupported {
+		return "ENOTSUP"
+	}
+	return "EIO"
+}
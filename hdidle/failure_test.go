@@ -0,0 +1,63 @@
+// hd-idle - spin down idle hard disks
+// Copyright (C) 2018  Andoni del Olmo
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package hdidle
+
+import (
+	"errors"
+	"github.com/adelolmo/hd-idle/sgio"
+	"os"
+	"syscall"
+	"testing"
+)
+
+func TestFailureClass(t *testing.T) {
+	tests := []struct {
+		err  error
+		want string
+	}{
+		{spindownError(SCSI, "/dev/sdz", &os.PathError{Op: "open", Path: "/dev/sdz", Err: os.ErrNotExist}), FailureNoDisk},
+		{spindownError(SCSI, "/dev/sda", &os.PathError{Op: "open", Path: "/dev/sda", Err: os.ErrPermission}), FailurePermission},
+		{spindownError(ATA, "/dev/sda", sgio.ErrCommandNotSupported), FailureUnsupported},
+		{deviceError(sgio.ErrCommandNotSupported, errors.New("cannot spinup")), FailureUnsupported},
+		{spindownError(SCSI, "/dev/sda", errors.New("timeout")), FailureCommand},
+		{errors.New("anything else"), FailureCommand},
+	}
+	for _, tt := range tests {
+		if got := FailureClass(tt.err); got != tt.want {
+			t.Errorf("Expected %s for %q but found %s", tt.want, tt.err, got)
+		}
+	}
+}
+
+func TestErrorCode(t *testing.T) {
+	tests := []struct {
+		err  error
+		want string
+	}{
+		{spindownError(SCSI, "/dev/sda", &os.PathError{Op: "open", Path: "/dev/sda", Err: syscall.EACCES}), "EACCES"},
+		{spindownError(ATA, "/dev/sda", syscall.EIO), "EIO"},
+		{spindownError(ATA, "/dev/sda", syscall.Errno(200)), "ERRNO_200"},
+		{spindownError(ATA, "/dev/sda", sgio.ErrCommandNotSupported), "ENOTSUP"},
+		{spindownError(SCSI, "/dev/sda", errors.New("sense key 0x2")), "EIO"},
+		{errSimulated, "EIO"},
+	}
+	for _, tt := range tests {
+		if got := errorCode(tt.err); got != tt.want {
+			t.Errorf("Expected %s for %q but found %s", tt.want, tt.err, got)
+		}
+	}
+}
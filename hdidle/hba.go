@@ -0,0 +1,111 @@
+// hd-idle - spin down idle hard disks
+// Copyright (C) 2018  Andoni del Olmo
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package hdidle
+
+import (
+	"github.com/adelolmo/hd-idle/sysfs"
+)
+
+const runtimePmAuto = "auto"
+
+/* the pci controller of the disk, empty for usb disks or unknown topology */
+func (m *Monitor) hbaOf(name string) string {
+	if address, found := m.hbas[name]; found {
+		return address
+	}
+	address, err := sysfs.PciController(name)
+	if err != nil && m.debug(name) {
+		m.println(err.Error())
+	}
+	m.hbas[name] = address
+	return address
+}
+
+/*
+ * Let the controllers whose disks are all spun down suspend, and keep the
+ * others powered. The kernel resumes a suspended controller by itself when
+ * a disk is accessed.
+ */
+func (m *Monitor) updateHbaPower() {
+	if !m.config.Defaults.HbaRuntimePm {
+		return
+	}
+	allDown := map[string]bool{}
+	for _, ds := range m.snapshots {
+		address := m.hbaOf(ds.Name)
+		if len(address) == 0 {
+			continue
+		}
+		down, found := allDown[address]
+		allDown[address] = ds.SpunDown && (down || !found)
+	}
+	for address, down := range allDown {
+		if down {
+			m.suspendHba(address)
+		} else {
+			m.resumeHba(address)
+		}
+	}
+}
+
+func (m *Monitor) suspendHba(address string) {
+	if _, found := m.hbaControls[address]; found {
+		return
+	}
+	original, err := sysfs.RuntimePm(address)
+	if err != nil {
+		m.println(err.Error())
+		return
+	}
+	if original == runtimePmAuto {
+		return
+	}
+	if err := sysfs.SetRuntimePm(address, runtimePmAuto); err != nil {
+		m.println(err.Error())
+		return
+	}
+	m.hbaControls[address] = original
+	m.printf("controller %s runtime suspend enabled, all its disks are spun down\n", address)
+}
+
+func (m *Monitor) resumeHba(address string) {
+	original, found := m.hbaControls[address]
+	if !found {
+		return
+	}
+	delete(m.hbaControls, address)
+	if err := sysfs.SetRuntimePm(address, original); err != nil {
+		m.println(err.Error())
+		return
+	}
+	m.printf("controller %s runtime suspend disabled\n", address)
+}
+
+/* power the controller of a disk before hd-idle wakes it up */
+func (m *Monitor) resumeHbaOf(name string) {
+	if address, found := m.hbas[name]; found && len(address) > 0 {
+		m.resumeHba(address)
+	}
+}
+
+func (m *Monitor) restoreAllHbaPower() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for address := range m.hbaControls {
+		m.resumeHba(address)
+	}
+}
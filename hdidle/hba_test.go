@@ -0,0 +1,88 @@
+// hd-idle - spin down idle hard disks
+// Copyright (C) 2018  Andoni del Olmo
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package hdidle
+
+import (
+	"github.com/adelolmo/hd-idle/diskstats"
+	"github.com/adelolmo/hd-idle/sysfs"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestUpdateHbaPower(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sysfs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	root := sysfs.Root
+	sysfs.Root = dir
+	defer func() { sysfs.Root = root }()
+
+	controller := filepath.Join(dir, "devices/pci0000:00/0000:03:00.0")
+	for _, disk := range []string{"sda", "sdb"} {
+		block := filepath.Join(controller, "host0/target0:0:0/0:0:0:0/block", disk)
+		mustMkdir(t, block)
+		mustMkdir(t, filepath.Join(dir, "block"))
+		if err := os.Symlink(block, filepath.Join(dir, "block", disk)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	mustMkdir(t, filepath.Join(controller, "power"))
+	mustMkdir(t, filepath.Join(dir, "bus/pci/devices"))
+	if err := os.Symlink(controller, filepath.Join(dir, "bus/pci/devices/0000:03:00.0")); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(controller, "power/control"), []byte("on\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	config := NewConfig()
+	config.Defaults.HbaRuntimePm = true
+	m := New(config)
+	m.SetOutput(ioutil.Discard)
+	m.snapshots = []diskstats.DiskStats{{Name: "sda", SpunDown: true}, {Name: "sdb", SpunDown: false}}
+
+	m.updateHbaPower()
+	assertControl(t, "on")
+
+	m.snapshots[1].SpunDown = true
+	m.updateHbaPower()
+	assertControl(t, "auto")
+
+	m.snapshots[0].SpunDown = false
+	m.updateHbaPower()
+	assertControl(t, "on")
+}
+
+func assertControl(t *testing.T, expected string) {
+	control, err := sysfs.RuntimePm("0000:03:00.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if control != expected {
+		t.Fatalf("Expected runtime pm %s but found %s", expected, control)
+	}
+}
+
+func mustMkdir(t *testing.T, dir string) {
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,609 @@
+// hd-idle - spin down idle hard disks
+// Copyright (C) 2018  Andoni del Olmo
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package hdidle
+
+import (
+	"fmt"
+	"github.com/adelolmo/hd-idle/diskstats"
+	"github.com/adelolmo/hd-idle/io"
+	"github.com/adelolmo/hd-idle/sgio"
+	"github.com/adelolmo/hd-idle/sysfs"
+	"math"
+	"os"
+	"runtime/debug"
+	"sync/atomic"
+	"time"
+)
+
+const dateFormat = "2006-01-02T15:04:05"
+
+// ObserveDiskActivity runs a single monitoring cycle: it compares the disk
+// statistics with the previous cycle and spins down the idle disks. Run
+// calls it periodically, embedders with their own scheduler may call it
+// directly instead.
+func (m *Monitor) ObserveDiskActivity() error {
+	start, startCpu := time.Now(), time.Duration(0)
+	if m.config.Defaults.SelfMetrics {
+		startCpu = cpuTime()
+	}
+	actualSnapshot, err := diskstats.Snapshot()
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	/* a panic ends the cycle, the next one starts afresh */
+	defer func() {
+		if value := recover(); value != nil {
+			m.reportCrash("cycle", m.currentDisk, &panicError{value: value, stack: debug.Stack()})
+			m.currentDisk = ""
+			m.lastNow = m.now
+			atomic.StoreInt64(&m.cycleDoneAt, time.Now().UnixNano())
+		}
+	}()
+	defer m.startVetoCycle()()
+	m.now = time.Now()
+	forgetUdevProperties()
+	m.resolveSymlinks()
+	m.reloadQuirks(m.config.Defaults.QuirksFile)
+	m.removeUnpluggedDisks(actualSnapshot)
+	m.updatePause()
+	m.updatePowerSource()
+	m.updateProfile()
+	m.updateSwap()
+	if m.config.Defaults.StackedIo {
+		m.chargeStackedIo(actualSnapshot)
+	}
+	for _, stats := range actualSnapshot {
+		m.currentDisk = stats.Name
+		m.updateState(stats)
+	}
+	m.currentDisk = ""
+	m.updateHbaPower()
+	m.accumulateSpunDownTime()
+	m.checkpointStatistics()
+	m.dailyReport()
+	m.detectWakeStorm()
+	m.verifyPowerDrops()
+	m.flushLogBuffers()
+	m.auditCycle()
+	if m.config.Defaults.SelfMetrics {
+		m.measureSelf(start, startCpu)
+	}
+	m.lastNow = m.now
+	atomic.StoreInt64(&m.cycleDoneAt, time.Now().UnixNano())
+	return nil
+}
+
+func (m *Monitor) updateState(tmp diskstats.DiskStats) {
+	config := m.config
+	now := m.now
+	dsi := m.previousDiskStatsIndex(tmp.Name)
+	if m.excluded(tmp.Name) {
+		if dsi >= 0 {
+			/* excluded by a reload, forget it */
+			m.snapshots = append(m.snapshots[:dsi], m.snapshots[dsi+1:]...)
+		}
+		return
+	}
+	if dsi < 0 {
+		m.checkReplacement(tmp.Name)
+		m.snapshots = append(m.snapshots, m.initDevice(tmp))
+		m.startBackup(tmp.Name, tmp.Writes)
+		if m.debug(tmp.Name) {
+			m.logIdentity(tmp.Name)
+			m.logWear(tmp.Name, m.snapshots[len(m.snapshots)-1].CommandType)
+		}
+		return
+	}
+
+	if m.stuck[tmp.Name] {
+		/* a command to this disk still hangs, leave it alone */
+		if m.debug(tmp.Name) {
+			m.printf("disk=%s skipped, waiting for it to answer\n", tmp.Name)
+		}
+		return
+	}
+
+	if m.passedThrough(tmp.Name) {
+		/* the guest owns the disk, the idle time starts once the virtual machine lets go */
+		m.snapshots[dsi].TakeIo(tmp)
+		m.snapshots[dsi].LastIoAt = now
+		m.snapshots[dsi].SpunDown = false
+		return
+	}
+
+	if m.waitingForMounts(tmp.Name) {
+		/* the idle time starts once the filesystems are mounted */
+		m.snapshots[dsi].TakeIo(tmp)
+		m.snapshots[dsi].LastIoAt = now
+		return
+	}
+
+	if m.slept(tmp.Name) {
+		/* we slept too long, assume a suspend event and disks may be spun up */
+		/* reset spin status and timers */
+		m.snapshots[dsi].SpinUpAt = now
+		m.snapshots[dsi].LastIoAt = now
+		m.snapshots[dsi].SpunDown = false
+		m.restoreLinkPower(tmp.Name)
+		m.restoreReadAhead(tmp.Name)
+		m.logSpinupAfterSleep(m.snapshots[dsi].Name)
+	}
+
+	m.noteManualSpinup(dsi)
+	if m.manuallyHeld(tmp.Name) {
+		/* spun up by hand to be worked on, the idle time starts once the hold is over */
+		m.snapshots[dsi].TakeIo(tmp)
+		m.snapshots[dsi].LastIoAt = now
+		return
+	}
+
+	quarantined := m.quarantined(tmp.Name)
+	awake := inAwakeWindow(config.deviceConfig(tmp.Name).AwakeWindows, now)
+	if awake && m.snapshots[dsi].SpunDown && !quarantined && !m.vetoed(tmp.Name, ActionSpinup) {
+		/* keep the disk spinning during its awake window */
+		m.printf("%s spinup for awake window\n", m.displayName(tmp.Name))
+		m.resumeHbaOf(tmp.Name)
+		m.restoreLinkPower(tmp.Name)
+		m.restoreReadAhead(tmp.Name)
+		device := fmt.Sprintf("/dev/%s", tmp.Name)
+		command := m.snapshots[dsi].CommandType
+		start := time.Now()
+		q := m.quirksFor(tmp.Name)
+		if err := m.deviceCommand(tmp.Name, func() error { return spinupWithQuirk(device, command, q) }); err != nil {
+			m.println(err.Error())
+		} else {
+			/* the start command returns once the disk is ready */
+			m.recordWakeLatency(tmp.Name, time.Since(start), false)
+			m.countSpinup(tmp.Name)
+			m.logSpinup(m.snapshots[dsi])
+			m.emitCode(EventSpinup, tmp.Name, "AWAKE_WINDOW", "awake window")
+			m.snapshots[dsi].SpinUpAt = now
+			m.snapshots[dsi].LastIoAt = now
+			m.snapshots[dsi].SpunDown = false
+		}
+	}
+
+	if previous := m.snapshots[dsi]; tmp.IoSince(previous).Read() && !tmp.IoSince(previous).Written() &&
+		!previous.SpunDown && m.probing(tmp.Name) {
+		/* udev and blkid reading a disk that just appeared, not a use of it */
+		if m.debug(tmp.Name) {
+			m.printf("disk=%s reads ignored, probing\n", tmp.Name)
+		}
+		m.snapshots[dsi].TakeReads(tmp)
+	}
+
+	ds := m.snapshots[dsi]
+	delta := tmp.IoSince(ds)
+	/* discards don't count as reads or writes, but stopping the disk in the middle of one times out */
+	discarding := tmp.Discards != ds.Discards
+	m.snapshots[dsi].Discards = tmp.Discards
+	m.snapshots[dsi].IoTicks = tmp.IoTicks
+	if delta.Idle() {
+		if !ds.SpunDown && !awake {
+			/* no activity on this disk and still running */
+			idleDuration := now.Sub(ds.LastIoAt)
+			if ds.IdleTime != 0 && idleDuration > ds.IdleTime {
+				if _, deferred := m.deferralReason(ds, quarantined, discarding, tmp.InFlight); !deferred {
+					m.printf("%s spindown\n", m.aliased(ds.Name, "/dev/"+ds.Name))
+					watts, metered := m.powerBefore(ds.Name)
+					m.countSpindownAttempt(ds.Name)
+					err := m.inhibitedSpindown(ds.Name, ds.CommandType)
+					if err != nil {
+						m.println(err.Error())
+						m.emitCode(EventSpindownFailed, ds.Name, errorCode(err), err.Error())
+						if unsupported, ok := err.(*unsupportedError); ok {
+							m.markUnsupported(ds.Name, unsupported.command, ds.IdleTime)
+						}
+					} else {
+						m.countSpindown(ds.Name)
+						m.emit(EventSpindown, ds.Name, "")
+						if metered {
+							m.expectPowerDrop(ds.Name, watts)
+						} else {
+							m.verifyStandby(ds.Name, ds.CommandType)
+						}
+						if policy := config.deviceConfig(ds.Name).SataLpm; len(policy) > 0 {
+							m.lowerLinkPower(ds.Name, policy)
+						}
+						if config.Defaults.StandbyReadAhead >= 0 {
+							m.lowerReadAhead(ds.Name)
+						}
+						if config.deviceConfig(ds.Name).UsbPowerOff {
+							m.powerOffUsbPort(ds.Name)
+						}
+						m.finishBackup(ds.Name, ds.Writes)
+					}
+					m.snapshots[dsi].SpinDownAt = now
+					m.snapshots[dsi].SpunDown = true
+				}
+			}
+		}
+
+	} else {
+		/* disk had some activity */
+		if !ds.SpunDown {
+			m.noteIdleGap(ds.Name, ds.IdleTime, now.Sub(ds.LastIoAt))
+		}
+		if ds.SpunDown {
+			/* disk was spun down, thus it has just spun up */
+			m.printf("%s spinup\n", m.displayName(ds.Name))
+			m.restoreLinkPower(ds.Name)
+			m.restoreReadAhead(ds.Name)
+			m.countSpinup(ds.Name)
+			m.logSpinup(ds)
+			m.emit(EventSpinup, ds.Name, "")
+			m.recordWake(ds.Name)
+			m.adviseOnWake(ds.Name)
+			m.wakeFollowers(ds.Name)
+			m.snapshots[dsi].SpinUpAt = now
+			/* the first I/O waited for the disk to spin up, all within the busy time */
+			busy := time.Duration(tmp.IoTicks-ds.IoTicks) * time.Millisecond
+			if busy > 0 {
+				m.recordWakeLatency(ds.Name, busy, true)
+			}
+		}
+		m.recordTrace(ds.Name)
+		if delta.Written() {
+			m.recordWrite(ds.Name)
+		}
+		m.snapshots[dsi].TakeIo(tmp)
+		m.snapshots[dsi].LastIoAt = now
+		m.snapshots[dsi].SpunDown = false
+		/* the disk is surely awake, so reading the temperature cannot wake it */
+		if !quarantined {
+			m.readTemperature(ds.Name)
+			m.collectSmart(ds.Name, ds.CommandType)
+		}
+	}
+
+	if m.debug(ds.Name) {
+		ds = m.snapshots[dsi]
+		idleDuration := now.Sub(ds.LastIoAt)
+		m.printf("disk=%s alias=%s command=%s spunDown=%t "+
+			"reads=%d writes=%d %s idleTime=%v idleDuration=%v "+
+			"spindown=%s spinup=%s lastIO=%s temperature=%s\n",
+			ds.Name, config.deviceConfig(ds.Name).Alias, ds.CommandType, ds.SpunDown,
+			ds.Reads, ds.Writes, delta, ds.IdleTime.Seconds(), math.RoundToEven(idleDuration.Seconds()),
+			ds.SpinDownAt.Format(dateFormat), ds.SpinUpAt.Format(dateFormat), ds.LastIoAt.Format(dateFormat),
+			m.temperature(ds.Name))
+	}
+}
+
+/*
+ * Why a due spin down waits for a later cycle, if it does. The quiet reasons
+ * are only told in debug mode, the others are printed and sent as a
+ * spindown_deferred event. All but a disk not supporting spin down are
+ * counted for the daily report.
+ */
+func (m *Monitor) deferralReason(ds diskstats.DiskStats, quarantined, discarding bool, inFlight int) (string, bool) {
+	code, message, quiet := m.deferral(ds, quarantined, discarding, inFlight)
+	switch {
+	case len(code) == 0:
+		return "", false
+	case quiet:
+		if code != "UNSUPPORTED" {
+			m.noteDeferral(ds.Name, code)
+		}
+		if m.debug(ds.Name) {
+			m.printf("disk=%s spindown skipped, %s\n", ds.Name, message)
+		}
+	default:
+		m.printf("%s spindown deferred, %s\n", m.displayName(ds.Name), message)
+		m.emitCode(EventSpindownDeferred, ds.Name, code, message)
+	}
+	return code, true
+}
+
+/* the first reason found, the ones asking the disk or a vetoer last */
+func (m *Monitor) deferral(ds diskstats.DiskStats, quarantined, discarding bool, inFlight int) (string, string, bool) {
+	if quarantined {
+		return "QUARANTINED", "quarantined", true
+	}
+	if m.paused() || m.namespacePaused(ds.Name) {
+		return "PAUSED", "paused", true
+	}
+	if _, unsupported := m.unsupported[ds.Name]; unsupported {
+		return "UNSUPPORTED", "not supported", true
+	}
+	if sibling := m.busyUsbSibling(ds.Name); len(sibling) > 0 {
+		return "USB_HUB_BUSY", "usb hub busy with " + m.displayName(sibling), false
+	}
+	if m.waitingForBackup(ds.Name, ds.Writes) {
+		return "BACKUP", "waiting for the backup", true
+	}
+	if clients := m.exportedTo(ds.Name); len(clients) > 0 {
+		return "EXPORT_SESSION", "exported to " + clients, false
+	}
+	if m.waitingForGc(ds.Name) {
+		return "SMR_GC", "waiting for smr garbage collection", true
+	}
+	if discarding {
+		return "DISCARD", "discard in progress", false
+	}
+	if inFlight > 0 {
+		/* requests sent and not completed yet, a spin down would wait for them or abort them */
+		return "IN_FLIGHT", "io in flight", false
+	}
+	if activity := m.deviceMapperActivity(ds.Name); len(activity) > 0 {
+		return "DEVICE_MAPPER", activity, false
+	}
+	if activity := m.backgroundActivityOf(ds.Name, ds.CommandType); len(activity) > 0 {
+		return "BACKGROUND_ACTIVITY", activity + " in progress", false
+	}
+	if veto := m.veto(ds.Name, ActionSpindown); len(veto) > 0 {
+		return "VETO", veto, false
+	}
+	return "", "", false
+}
+
+/* forget disks gone from /proc/diskstats, they start afresh when plugged again */
+func (m *Monitor) removeUnpluggedDisks(actualSnapshot []diskstats.DiskStats) {
+	var present []diskstats.DiskStats
+	for _, ds := range m.snapshots {
+		found := false
+		for _, stats := range actualSnapshot {
+			if stats.Name == ds.Name {
+				found = true
+				break
+			}
+		}
+		if found {
+			present = append(present, ds)
+			continue
+		}
+		m.forgetIdentity(ds.Name)
+		m.unplugDevice(ds.Name)
+		delete(m.backups, ds.Name)
+		delete(m.hbas, ds.Name)
+		delete(m.usbHubs, ds.Name)
+		delete(m.mountsReady, ds.Name)
+		delete(m.mountWaitSince, ds.Name)
+		delete(m.smart, ds.Name)
+		delete(m.lastWriteAt, ds.Name)
+		delete(m.wakeLatencies, ds.Name)
+		delete(m.unsupported, ds.Name)
+		delete(m.inherited, ds.Name)
+		delete(m.temperatureInputs, ds.Name)
+		delete(m.temperatures, ds.Name)
+		if _, found := m.readAheads[ds.Name]; found {
+			/* a disk plugged in again starts with the default read-ahead */
+			delete(m.readAheads, ds.Name)
+			if err := m.saveReadAheads(); err != nil {
+				m.println(err.Error())
+			}
+		}
+	}
+	m.snapshots = present
+}
+
+func (m *Monitor) previousDiskStatsIndex(diskName string) int {
+	for i, stats := range m.snapshots {
+		if stats.Name == diskName {
+			return i
+		}
+	}
+	return -1
+}
+
+func (m *Monitor) initDevice(stats diskstats.DiskStats) diskstats.DiskStats {
+	deviceConf := m.config.deviceConfig(stats.Name)
+	m.classifyMedia(stats.Name)
+	m.appearedAt[stats.Name] = m.now
+	ds := diskstats.DiskStats{
+		Name:        stats.Name,
+		LastIoAt:    time.Now(),
+		SpinUpAt:    time.Now(),
+		SpunDown:    false,
+		Discards:    stats.Discards,
+		IoTicks:     stats.IoTicks,
+		IdleTime:    m.idleTime(stats.Name, deviceConf),
+		CommandType: deviceConf.CommandType,
+	}
+	ds.TakeIo(stats)
+	return ds
+}
+
+/* the persistent names of the disks, empty if udev is not around */
+func diskLinks() map[string][]string {
+	links, err := io.DiskLinks()
+	if err != nil {
+		return map[string][]string{}
+	}
+	return links
+}
+
+/* the disk for all user facing output, e.g. parity (sda) when given an alias */
+func (m *Monitor) displayName(diskName string) string {
+	return m.aliased(diskName, diskName)
+}
+
+func (m *Monitor) aliased(diskName, shown string) string {
+	alias := m.config.deviceConfig(diskName).Alias
+	if len(alias) > 0 {
+		return fmt.Sprintf("%s (%s)", alias, shown)
+	}
+	return shown
+}
+
+/* with -d for every disk, with --disk-debug for the disks given it */
+func (m *Monitor) debug(diskName string) bool {
+	return m.config.Defaults.Debug || m.config.deviceConfig(diskName).Debug
+}
+
+// SpindownDisk sends the stop command of the given type to the device.
+func SpindownDisk(device, command string) error {
+	switch command {
+	case SCSI:
+		return stopScsiDevice(device, sgio.StopScsiDevice)
+	case ATA:
+		if err := sgio.StopAtaDevice(device); err != nil {
+			return spindownError(ATA, device, err)
+		}
+		return nil
+	}
+	return nil
+}
+
+/*
+ * The kernel locks the medium of removable devices while they are open, e.g.
+ * mounted, and some refuse to stop while it is locked. Unlock it for the stop
+ * and lock it again.
+ */
+func stopScsiDevice(device string, stop func(string) error) error {
+	err := stop(device)
+	if err == sgio.ErrMediumRemovalPrevented {
+		if err = sgio.AllowMediumRemoval(device); err == nil {
+			err = stop(device)
+			if lockErr := sgio.PreventMediumRemoval(device); err == nil {
+				err = lockErr
+			}
+		}
+	}
+	if err != nil {
+		return spindownError(SCSI, device, err)
+	}
+	return nil
+}
+
+// SpinupDisk sends the start command of the given type to the device.
+func SpinupDisk(device, command string) error {
+	switch command {
+	case SCSI:
+		if err := sgio.StartScsiDevice(device); err != nil {
+			return deviceError(err, fmt.Errorf("cannot spinup scsi disk %s:\n%s\n", device, err.Error()))
+		}
+		return nil
+	case ATA:
+		if err := sgio.StartAtaDevice(device); err != nil {
+			return deviceError(err, fmt.Errorf("cannot spinup ata disk %s:\n%s\n", device, err.Error()))
+		}
+		return nil
+	}
+	return nil
+}
+
+// DiskStandby tells whether the device is spun down, asking it with the
+// power check of the given command type.
+func DiskStandby(device, command string) (bool, error) {
+	check := sgio.ScsiStopped
+	if command == ATA {
+		check = sgio.AtaStandby
+	}
+	standby, err := check(device)
+	if err != nil {
+		return false, deviceError(err, fmt.Errorf("cannot check power state of %s disk %s: %s", command, device, err))
+	}
+	return standby, nil
+}
+
+// IdentifyDisk reads the IDENTIFY DEVICE data of an ata device.
+func IdentifyDisk(device string) (*sgio.AtaIdentity, error) {
+	id, err := sgio.IdentifyAtaDevice(device)
+	if err != nil {
+		return nil, deviceError(err, fmt.Errorf("cannot identify disk %s: %s", device, err))
+	}
+	return id, nil
+}
+
+func (m *Monitor) powerOffUsbPort(name string) {
+	port, err := sysfs.UsbPort(name)
+	if err == nil {
+		err = sysfs.SetUsbPortPower(port, false)
+	}
+	if err != nil {
+		m.printf("Cannot power off usb port of %s: %s\n", m.displayName(name), err)
+		return
+	}
+	m.printf("%s usb port %s powered off\n", m.displayName(name), port)
+	m.emit(EventUsbPowerOff, name, port)
+	m.logToFile(m.logFileOf(name), fmt.Sprintf("date: %s, time: %s, disk: %s, usb port %s powered off",
+		m.now.Format("2006-01-02"), m.now.Format("15:04:05"), m.displayName(name), port))
+}
+
+// PowerOnUsbPort restores the power of a usb port, e.g. 1-1.2 as logged when
+// it was powered off after its disk spun down.
+func (m *Monitor) PowerOnUsbPort(port string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.config.Defaults.ReadOnly {
+		return fmt.Errorf("read-only mode does not allow powering on usb port %s", port)
+	}
+	if err := sysfs.SetUsbPortPower(port, true); err != nil {
+		return err
+	}
+	m.printf("usb port %s powered on\n", port)
+	return nil
+}
+
+func (m *Monitor) logSpinup(ds diskstats.DiskStats) {
+	now := time.Now()
+	text := fmt.Sprintf("date: %s, time: %s, disk: %s, running: %d, stopped: %d",
+		now.Format("2006-01-02"), now.Format("15:04:05"), m.displayName(ds.Name),
+		int(ds.SpinDownAt.Sub(ds.SpinUpAt).Seconds()), int(now.Sub(ds.SpinDownAt).Seconds()))
+	m.logToFile(m.logFileOf(ds.Name), text)
+}
+
+func (m *Monitor) logSpinupAfterSleep(name string) {
+	m.emit(EventSleepReset, name, "assuming disk spun up after long sleep")
+	text := fmt.Sprintf("date: %s, time: %s, disk: %s, assuming disk spun up after long sleep",
+		m.now.Format("2006-01-02"), m.now.Format("15:04:05"), m.displayName(name))
+	m.logToFile(m.logFileOf(name), text)
+}
+
+/* the log file of a disk, the one given with -l unless the disk has its own */
+func (m *Monitor) logFileOf(disk string) string {
+	if file := m.config.deviceConfig(disk).LogFile; len(file) > 0 {
+		return file
+	}
+	return m.config.Defaults.LogFile
+}
+
+func (m *Monitor) logToFile(file, text string) {
+	if len(file) == 0 {
+		return
+	}
+	if file != m.config.Defaults.TraceFile && m.config.Defaults.LogFormat == LogFormatKeyValue {
+		return // the events are logged as key=value lines instead
+	}
+
+	m.sinkFor(file).write(text)
+}
+
+func (m *Monitor) writeToFile(file, text string) {
+	if err := appendToFile(file, text); err != nil {
+		m.println(err.Error())
+	}
+}
+
+func appendToFile(file, text string) error {
+	cacheFile, err := os.OpenFile(file, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return fmt.Errorf("cannot open file %s. Error: %s", file, err)
+	}
+	if _, err = cacheFile.WriteString(text + "\n"); err != nil {
+		cacheFile.Close()
+		return fmt.Errorf("cannot write into file %s. Error: %s", file, err)
+	}
+	if err = cacheFile.Close(); err != nil {
+		return fmt.Errorf("cannot close file %s. Error: %s", file, err)
+	}
+	return nil
+}
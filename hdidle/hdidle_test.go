@@ -0,0 +1,176 @@
+// hd-idle - spin down idle hard disks
+// Copyright (C) 2018  Andoni del Olmo
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package hdidle
+
+import (
+	"bytes"
+	"github.com/adelolmo/hd-idle/diskstats"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMergedWritesAreActivity(t *testing.T) {
+	simulation, err := ParseSimulation("dry-run")
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := NewConfig()
+	config.Defaults.Simulation = simulation
+	config.Defaults.Idle = 10 * time.Minute
+	config.SkewTime = 24 * time.Hour
+	m := New(config)
+	m.SetOutput(ioutil.Discard)
+
+	start := time.Now()
+	stats := diskstats.DiskStats{Name: "sdb", Reads: 800, Writes: 1600, WritesCompleted: 20}
+	cycle := func(now time.Time) {
+		m.now = now
+		m.updateState(stats)
+		m.lastNow = now
+	}
+	cycle(start)
+
+	/* the sectors stay put while requests are merged into queued ones */
+	stats.WritesMerged = 3
+	cycle(start.Add(5 * time.Minute))
+	if !m.snapshots[0].LastIoAt.Equal(start.Add(5 * time.Minute)) {
+		t.Fatal("Expected the merged writes counted as activity")
+	}
+	if m.snapshots[0].WritesMerged != 3 {
+		t.Fatalf("Expected the merge counter taken but found %d", m.snapshots[0].WritesMerged)
+	}
+
+	cycle(start.Add(10 * time.Minute))
+	if !m.snapshots[0].LastIoAt.Equal(start.Add(5 * time.Minute)) {
+		t.Fatal("Expected no activity without a change of the counters")
+	}
+}
+
+func TestDebugOfOneDisk(t *testing.T) {
+	simulation, err := ParseSimulation("dry-run")
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := NewConfig()
+	config.Defaults.Simulation = simulation
+	config.SkewTime = 24 * time.Hour
+	config.Devices = []DeviceConf{{Name: "sdc", GivenName: "sdc", Idle: time.Hour, CommandType: SCSI, Debug: true}}
+	m := New(config)
+	var out bytes.Buffer
+	m.SetOutput(&out)
+
+	m.now = time.Now()
+	for _, name := range []string{"sdb", "sdc"} {
+		m.updateState(diskstats.DiskStats{Name: name})
+		m.updateState(diskstats.DiskStats{Name: name, Writes: 8})
+	}
+	if strings.Contains(out.String(), "disk=sdb") {
+		t.Fatalf("Expected no debug output of sdb but found %q", out.String())
+	}
+	if !strings.Contains(out.String(), "disk=sdc alias= command=scsi") {
+		t.Fatalf("Expected the debug output of sdc but found %q", out.String())
+	}
+
+	config.Defaults.Debug = true
+	out.Reset()
+	m.updateState(diskstats.DiskStats{Name: "sdb", Writes: 16})
+	if !strings.Contains(out.String(), "disk=sdb") {
+		t.Fatalf("Expected the debug output of every disk with -d but found %q", out.String())
+	}
+}
+
+func TestAliasShownBeforeKernelName(t *testing.T) {
+	simulation, err := ParseSimulation("dry-run")
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := NewConfig()
+	config.Defaults.Simulation = simulation
+	config.SkewTime = 24 * time.Hour
+	config.Devices = []DeviceConf{{Name: "sdb", GivenName: "sdb", Alias: "parity", Idle: time.Minute, CommandType: SCSI}}
+	m := New(config)
+	var out bytes.Buffer
+	m.SetOutput(&out)
+
+	start := time.Now()
+	m.snapshots = []diskstats.DiskStats{
+		{Name: "sdb", CommandType: SCSI, IdleTime: time.Minute, LastIoAt: start.Add(-time.Hour)},
+		{Name: "sdc", CommandType: SCSI, IdleTime: time.Minute, LastIoAt: start.Add(-time.Hour)},
+	}
+	m.now = start
+	m.updateState(diskstats.DiskStats{Name: "sdb"})
+	m.updateState(diskstats.DiskStats{Name: "sdc"})
+	if !strings.Contains(out.String(), "parity (/dev/sdb) spindown\n") || !strings.Contains(out.String(), "\n/dev/sdc spindown\n") {
+		t.Fatalf("Expected parity (/dev/sdb) and /dev/sdc spun down but found %q", out.String())
+	}
+	if name := m.displayName("sdb"); name != "parity (sdb)" {
+		t.Fatalf("Expected parity (sdb) but found %s", name)
+	}
+	if name := m.displayName("sdc"); name != "sdc" {
+		t.Fatalf("Expected sdc but found %s", name)
+	}
+}
+
+func TestDeferralReasonToldOnce(t *testing.T) {
+	config := NewConfig()
+	config.Defaults.DailyReport = time.Hour
+	m := New(config)
+	var out bytes.Buffer
+	m.SetOutput(&out)
+	m.AddVetoer("bookings", bookings{"sdb": true})
+	events, cancel := m.Subscribe("sdb")
+	defer cancel()
+	m.now = time.Now()
+	ds := diskstats.DiskStats{Name: "sdb", CommandType: SCSI}
+
+	var tests = []struct {
+		quarantined bool
+		discarding  bool
+		inFlight    int
+		code        string
+		told        string
+	}{
+		{true, true, 1, "QUARANTINED", ""},
+		{false, true, 1, "DISCARD", "sdb spindown deferred, discard in progress\n"},
+		{false, false, 2, "IN_FLIGHT", "sdb spindown deferred, io in flight\n"},
+		{false, false, 0, "VETO", "sdb spindown deferred, vetoed by bookings: booked for a render job\n"},
+	}
+	for _, test := range tests {
+		out.Reset()
+		code, deferred := m.deferralReason(ds, test.quarantined, test.discarding, test.inFlight)
+		if !deferred || code != test.code || out.String() != test.told {
+			t.Fatalf("Expected %s told %q but found %s, %t told %q", test.code, test.told, code, deferred, out.String())
+		}
+		if len(test.told) == 0 {
+			continue
+		}
+		if event := <-events; event.Type != EventSpindownDeferred || event.Code != test.code || len(events) > 0 {
+			t.Fatalf("Expected a single %s event but found %+v and %d more", test.code, event, len(events))
+		}
+	}
+	if deferrals := m.notesOf("sdb").deferrals; deferrals["QUARANTINED"] != 1 || deferrals["DISCARD"] != 1 ||
+		deferrals["IN_FLIGHT"] != 1 || deferrals["VETO"] != 1 {
+		t.Fatalf("Expected every deferral counted once but found %v", deferrals)
+	}
+
+	m.vetoers = nil
+	if code, deferred := m.deferralReason(ds, false, false, 0); deferred {
+		t.Fatalf("Expected no deferral but found %s", code)
+	}
+}
@@ -0,0 +1,78 @@
+// hd-idle - spin down idle hard disks
+// Copyright (C) 2018  Andoni del Olmo
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package hdidle
+
+import (
+	"fmt"
+	"github.com/adelolmo/hd-idle/sgio"
+	"github.com/adelolmo/hd-idle/sysfs"
+)
+
+/* replaced in tests */
+var ataIdentify = sgio.IdentifyAtaDevice
+
+type identifyResult struct {
+	identity *sgio.AtaIdentity
+	err      error
+}
+
+/*
+ * IDENTIFY results are kept by serial number (or disk name when the serial
+ * is unknown), so capability checks ask each drive only once. Failures are
+ * cached as well. Entries are dropped when the disk is unplugged.
+ */
+func (m *Monitor) identify(disk string) (*sgio.AtaIdentity, error) {
+	key := m.identityKey(disk)
+	result, found := m.identities[key]
+	if !found {
+		id, err := ataIdentify(fmt.Sprintf("/dev/%s", disk))
+		result = identifyResult{identity: id, err: err}
+		m.identities[key] = result
+	}
+	return result.identity, result.err
+}
+
+/* the serial number of the disk, or its name when the serial is unknown */
+func (m *Monitor) identityKey(disk string) string {
+	key, found := m.identityKeys[disk]
+	if !found {
+		serial, err := sysfs.Serial(disk)
+		key = serial
+		if err != nil {
+			key = "disk:" + disk
+		}
+		m.identityKeys[disk] = key
+	}
+	return key
+}
+
+func (m *Monitor) forgetIdentity(disk string) {
+	if key, found := m.identityKeys[disk]; found {
+		delete(m.identities, key)
+		delete(m.identityKeys, disk)
+	}
+}
+
+func (m *Monitor) logIdentity(disk string) {
+	id, err := m.identify(disk)
+	if err != nil {
+		m.printf("disk=%s identify=n/a\n", disk)
+		return
+	}
+	m.printf("disk=%s model=%s serial=%s apm=%t apmEnabled=%t epc=%t epcEnabled=%t standbyTimer=%t\n",
+		disk, id.Model, id.Serial, id.Apm, id.ApmEnabled, id.Epc, id.EpcEnabled, id.StandbyTimer)
+}
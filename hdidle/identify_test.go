@@ -0,0 +1,79 @@
+// hd-idle - spin down idle hard disks
+// Copyright (C) 2018  Andoni del Olmo
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package hdidle
+
+import (
+	"github.com/adelolmo/hd-idle/diskstats"
+	"github.com/adelolmo/hd-idle/sgio"
+	"github.com/adelolmo/hd-idle/sysfs"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestIdentifiedOncePerPlugIn(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sysfs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer func(root string, identify func(string) (*sgio.AtaIdentity, error)) {
+		sysfs.Root, ataIdentify = root, identify
+	}(sysfs.Root, ataIdentify)
+	sysfs.Root = dir
+	plug := func(serial string) {
+		mustMkdir(t, filepath.Join(dir, "block", "sda", "device"))
+		if err := ioutil.WriteFile(filepath.Join(dir, "block", "sda", "device", "serial"), []byte(serial+"\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var queried []string
+	ataIdentify = func(device string) (*sgio.AtaIdentity, error) {
+		serial, _ := sysfs.Serial("sda")
+		queried = append(queried, device)
+		return &sgio.AtaIdentity{Serial: serial}, nil
+	}
+
+	m := New(NewConfig())
+	m.SetOutput(ioutil.Discard)
+	m.snapshots = []diskstats.DiskStats{{Name: "sda"}}
+	plug("WD-WCC4E1111111")
+	for i := 0; i < 3; i++ {
+		if id, err := m.identify("sda"); err != nil || id.Serial != "WD-WCC4E1111111" {
+			t.Fatalf("Expected WD-WCC4E1111111 identified but found %v, %v", id, err)
+		}
+	}
+	if len(queried) != 1 || queried[0] != "/dev/sda" {
+		t.Fatalf("Expected /dev/sda queried once but found %v", queried)
+	}
+
+	/* replaced by another disk taking the same name */
+	m.removeUnpluggedDisks(nil)
+	if len(m.identities) != 0 || len(m.identityKeys) != 0 {
+		t.Fatalf("Expected the identity of the unplugged disk forgotten but found %v", m.identities)
+	}
+	plug("WD-WCC4E2222222")
+	m.snapshots = []diskstats.DiskStats{{Name: "sda"}}
+	if id, err := m.identify("sda"); err != nil || id.Serial != "WD-WCC4E2222222" {
+		t.Fatalf("Expected the replacement WD-WCC4E2222222 identified but found %v, %v", id, err)
+	}
+	if len(queried) != 2 {
+		t.Fatalf("Expected the replacement queried again but found %v", queried)
+	}
+}
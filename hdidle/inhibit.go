@@ -0,0 +1,85 @@
+// hd-idle - spin down idle hard disks
+// Copyright (C) 2018  Andoni del Olmo
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package hdidle
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"os/exec"
+)
+
+/*
+ * A suspendInhibitor holds a systemd-logind "sleep" inhibitor lock, so the
+ * system cannot suspend while a disk is being spun down. The lock belongs
+ * to a systemd-inhibit child process that lives until its stdin is closed.
+ */
+type suspendInhibitor struct {
+	cmd   *exec.Cmd
+	stdin *os.File
+}
+
+/* replaced in tests, returns the function releasing the lock */
+var inhibitSuspend = systemdInhibitSuspend
+
+func systemdInhibitSuspend(why string) (func(), error) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	cmd := exec.Command("systemd-inhibit", "--what=sleep", "--who=hd-idle", "--why="+why, "--mode=block",
+		"sh", "-c", "echo locked; exec cat >/dev/null")
+	cmd.Stdin = r
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		w.Close()
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		w.Close()
+		return nil, fmt.Errorf("cannot inhibit suspend: %s", err)
+	}
+
+	/* the command only runs once the lock is taken */
+	if _, err := bufio.NewReader(stdout).ReadString('\n'); err != nil {
+		w.Close()
+		cmd.Wait()
+		return nil, fmt.Errorf("cannot inhibit suspend: systemd-inhibit exited")
+	}
+	inhibitor := &suspendInhibitor{cmd: cmd, stdin: w}
+	return inhibitor.release, nil
+}
+
+func (i *suspendInhibitor) release() {
+	i.stdin.Close()
+	i.cmd.Wait()
+}
+
+/* the spin down, with suspend inhibited while it runs when asked for */
+func (m *Monitor) inhibitedSpindown(disk, command string) error {
+	if m.config.Defaults.InhibitSuspend {
+		release, err := inhibitSuspend("spinning down " + disk)
+		if err != nil {
+			m.println(err.Error())
+		} else {
+			defer release()
+		}
+	}
+	return m.spindownLearning(disk, command)
+}
@@ -0,0 +1,88 @@
+// hd-idle - spin down idle hard disks
+// Copyright (C) 2018  Andoni del Olmo
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package hdidle
+
+import (
+	"errors"
+	"github.com/adelolmo/hd-idle/diskstats"
+	"io/ioutil"
+	"testing"
+	"time"
+)
+
+/* a monitor spinning sdb down on its next update, counting the inhibitors taken and released */
+func inhibitingMonitor(t *testing.T, spec string, inhibitErr error) (*Monitor, *int, *int) {
+	simulation, err := ParseSimulation(spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := NewConfig()
+	config.Defaults.Simulation = simulation
+	config.Defaults.InhibitSuspend = true
+	config.SkewTime = 24 * time.Hour
+	m := New(config)
+	m.SetOutput(ioutil.Discard)
+	m.now = time.Now()
+	m.snapshots = []diskstats.DiskStats{
+		{Name: "sdb", CommandType: SCSI, IdleTime: time.Minute, LastIoAt: m.now.Add(-time.Hour)},
+	}
+
+	taken, released := new(int), new(int)
+	inhibitSuspend = func(why string) (func(), error) {
+		if inhibitErr != nil {
+			return nil, inhibitErr
+		}
+		if why != "spinning down sdb" {
+			t.Fatalf("Expected the spin down of sdb told but found %s", why)
+		}
+		if *taken != *released {
+			t.Fatal("Expected the inhibitor of the earlier spin down released")
+		}
+		*taken++
+		if spec == "dry-run,latency=1ms" {
+			/* stopped while the spin down runs */
+			m.Stop()
+		}
+		return func() { *released++ }, nil
+	}
+	return m, taken, released
+}
+
+func TestSuspendInhibitedWhileSpinningDown(t *testing.T) {
+	defer func(f func(string) (func(), error)) { inhibitSuspend = f }(inhibitSuspend)
+
+	for _, spec := range []string{"dry-run", "failures=100", "dry-run,latency=1ms"} {
+		m, taken, released := inhibitingMonitor(t, spec, nil)
+		m.updateState(diskstats.DiskStats{Name: "sdb"})
+		if *taken != 1 || *released != 1 {
+			t.Fatalf("Expected the inhibitor taken and released once with %s but found %d taken, %d released", spec, *taken, *released)
+		}
+	}
+
+	m, taken, _ := inhibitingMonitor(t, "dry-run", errors.New("cannot inhibit suspend: systemd-inhibit exited"))
+	m.updateState(diskstats.DiskStats{Name: "sdb"})
+	if *taken != 0 || !m.snapshots[0].SpunDown {
+		t.Fatalf("Expected the spin down without inhibitor but found %d taken, spun down %t", *taken, m.snapshots[0].SpunDown)
+	}
+
+	m, taken, _ = inhibitingMonitor(t, "dry-run", nil)
+	m.config.Defaults.InhibitSuspend = false
+	m.updateState(diskstats.DiskStats{Name: "sdb"})
+	if *taken != 0 {
+		t.Fatalf("Expected no inhibitor without --inhibit-suspend but found %d taken", *taken)
+	}
+}
@@ -0,0 +1,71 @@
+// hd-idle - spin down idle hard disks
+// Copyright (C) 2018  Andoni del Olmo
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+//go:build integration
+// +build integration
+
+package hdidle
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+/*
+ * Run against a disk of the kernel's scsi_debug module, loaded beforehand or
+ * by the integration tests of the sgio package, see there.
+ */
+func scsiDebugDisk(t *testing.T) string {
+	models, _ := filepath.Glob("/sys/block/sd*/device/model")
+	for _, model := range models {
+		content, err := ioutil.ReadFile(model)
+		if err == nil && strings.TrimSpace(string(content)) == "scsi_debug" {
+			return "/dev/" + filepath.Base(filepath.Dir(filepath.Dir(model)))
+		}
+	}
+	t.Skip("no scsi_debug disk, load it with modprobe scsi_debug")
+	return ""
+}
+
+func TestScsiDebugSpindownVerified(t *testing.T) {
+	device := scsiDebugDisk(t)
+	defer SpinupDisk(device, SCSI)
+
+	if err := SpindownDisk(device, SCSI); err != nil {
+		t.Fatal(err)
+	}
+	if standby, err := DiskStandby(device, SCSI); err != nil || !standby {
+		t.Fatalf("Expected %s in standby after the spin down but found %t, %v", device, standby, err)
+	}
+	if err := SpinupDisk(device, SCSI); err != nil {
+		t.Fatal(err)
+	}
+	if standby, err := DiskStandby(device, SCSI); err != nil || standby {
+		t.Fatalf("Expected %s spinning after the spin up but found %t, %v", device, standby, err)
+	}
+}
+
+func TestScsiDebugAtaUnsupported(t *testing.T) {
+	device := scsiDebugDisk(t)
+
+	/* what --unsupported acts on */
+	err := SpindownDisk(device, ATA)
+	if FailureClass(err) != FailureUnsupported {
+		t.Fatalf("Expected the ATA spin down of %s to be unsupported but found %v", device, err)
+	}
+}
@@ -0,0 +1,57 @@
+// hd-idle - spin down idle hard disks
+// Copyright (C) 2018  Andoni del Olmo
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package hdidle
+
+import (
+	"time"
+)
+
+// WakeLatency tells how long a disk takes from spin up to its first
+// completed I/O.
+type WakeLatency struct {
+	Last time.Duration
+	// LastEstimated is set when the last wake was caused by an I/O, not by
+	// hd-idle: the latency is then the time the disk was busy in the cycle
+	// it woke up, an upper bound.
+	LastEstimated bool
+	Max           time.Duration
+	Total         time.Duration
+	Wakes         int
+}
+
+// Average returns the mean latency of all the wakes.
+func (w WakeLatency) Average() time.Duration {
+	if w.Wakes == 0 {
+		return 0
+	}
+	return w.Total / time.Duration(w.Wakes)
+}
+
+func (m *Monitor) recordWakeLatency(name string, latency time.Duration, estimated bool) {
+	w := m.wakeLatencies[name]
+	w.Last = latency
+	w.LastEstimated = estimated
+	if latency > w.Max {
+		w.Max = latency
+	}
+	w.Total += latency
+	w.Wakes++
+	m.wakeLatencies[name] = w
+	if m.debug(name) {
+		m.printf("disk=%s wakeLatency=%v estimated=%t\n", name, latency.Seconds(), estimated)
+	}
+}
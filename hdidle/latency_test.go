@@ -0,0 +1,45 @@
+// hd-idle - spin down idle hard disks
+// Copyright (C) 2018  Andoni del Olmo
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package hdidle
+
+import (
+	"github.com/adelolmo/hd-idle/diskstats"
+	"io/ioutil"
+	"testing"
+	"time"
+)
+
+func TestWakeLatencyOfPassiveWake(t *testing.T) {
+	config := NewConfig()
+	config.SkewTime = time.Hour
+	m := New(config)
+	m.SetOutput(ioutil.Discard)
+	m.snapshots = []diskstats.DiskStats{{Name: "sda", SpunDown: true, Reads: 1, IoTicks: 1000}}
+
+	m.updateState(diskstats.DiskStats{Name: "sda", Reads: 2, IoTicks: 8000})
+	m.snapshots[0].SpunDown = true
+	m.updateState(diskstats.DiskStats{Name: "sda", Reads: 3, IoTicks: 11000})
+
+	latency := m.wakeLatencies["sda"]
+	expected := WakeLatency{Last: 3 * time.Second, LastEstimated: true, Max: 7 * time.Second, Total: 10 * time.Second, Wakes: 2}
+	if latency != expected {
+		t.Fatalf("Expected %+v but found %+v", expected, latency)
+	}
+	if latency.Average() != 5*time.Second {
+		t.Fatalf("Expected an average of 5s but found %v", latency.Average())
+	}
+}
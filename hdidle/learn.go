@@ -0,0 +1,200 @@
+// hd-idle - spin down idle hard disks
+// Copyright (C) 2018  Andoni del Olmo
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package hdidle
+
+import (
+	"fmt"
+	"github.com/adelolmo/hd-idle/quirks"
+	"github.com/adelolmo/hd-idle/sgio"
+	"github.com/adelolmo/hd-idle/sysfs"
+	"os"
+	"time"
+)
+
+const learnedStateFile = "bridges.json"
+
+/* seconds to wait for a disk to report standby after a stop, in turn */
+var learnDelays = []int{1, 2, 4, 8}
+
+/*
+ * The key of the profile learned for a disk behind a USB bridge: the
+ * vendor:product of the bridge and the serial number of the disk, so the
+ * profile follows the enclosure to any port and kernel name. Empty when the
+ * disk is not on usb or has no serial number.
+ */
+func bridgeKey(disk string) string {
+	usbID, err := sysfs.UsbID(disk)
+	if err != nil {
+		return ""
+	}
+	serial, err := sysfs.Serial(disk)
+	if err != nil {
+		return ""
+	}
+	return usbID + "/" + serial
+}
+
+/* the profiles learned so far, by bridge key, loaded on first use */
+func (m *Monitor) learnedProfiles() map[string]quirks.Quirk {
+	if m.learned == nil {
+		m.learned = map[string]quirks.Quirk{}
+		if err := m.readState(learnedStateFile, &m.learned); err != nil && !os.IsNotExist(err) {
+			m.printf("Ignoring state file %s: %s\n", learnedStateFile, err)
+		}
+	}
+	return m.learned
+}
+
+/* keep what worked for the disk, if it is not known already */
+func (m *Monitor) learn(disk, key string, q quirks.Quirk) {
+	profiles := m.learnedProfiles()
+	if known, found := profiles[key]; found && known == q {
+		return
+	}
+	profiles[key] = q
+	m.printf("%s learned bridge profile: command_type=%s, pass_through=%d, stop_delay=%d\n",
+		m.displayName(disk), q.CommandType, q.PassThrough, q.StopDelay)
+	if err := m.writeState(learnedStateFile, profiles); err != nil {
+		m.println(err.Error())
+	}
+}
+
+/*
+ * Spin down a disk behind a USB bridge trying what bridges often need when
+ * the quirks file says nothing about it, and keep what worked for the next
+ * time the enclosure is attached.
+ */
+func (m *Monitor) spindownLearning(disk, command string) error {
+	q := m.quirksFor(disk)
+	key := ""
+	if _, configured := m.configuredQuirks(disk); m.config.Defaults.LearnQuirks && !configured {
+		key = bridgeKey(disk)
+	}
+	if len(key) == 0 {
+		return m.deviceCommand(disk, func() error { return spindownWithQuirk(disk, command, q) })
+	}
+	var learned quirks.Quirk
+	probed := false // not in dry runs
+	err := m.deviceCommand(disk, func() error {
+		var err error
+		learned, err = newLearner().probe(disk, command, q)
+		probed = true
+		return err
+	})
+	if len(q.CommandType) == 0 {
+		q.CommandType = command
+	}
+	if err == nil && probed && learned != q {
+		m.learn(disk, key, learned)
+	}
+	return err
+}
+
+/* the disk operations of learning, replaced in tests */
+type learner struct {
+	spindown func(disk, command string, q quirks.Quirk) error
+	standby  func(disk, command string) (bool, error)
+	sleep    func(time.Duration)
+}
+
+func newLearner() learner {
+	return learner{
+		spindown: spindownWithQuirk,
+		standby: func(disk, command string) (bool, error) {
+			device := fmt.Sprintf("/dev/%s", disk)
+			if command == ATA {
+				return sgio.AtaStandby(device)
+			}
+			return sgio.ScsiStopped(device)
+		},
+		sleep: time.Sleep,
+	}
+}
+
+/* the adjustments to try in turn: the known ones first, then what bridges often need */
+func candidates(command string, q quirks.Quirk) []quirks.Quirk {
+	if len(q.CommandType) == 0 {
+		q.CommandType = command
+	}
+	other := SCSI
+	if q.CommandType == SCSI {
+		other = ATA
+	}
+	list := []quirks.Quirk{q}
+	if q.CommandType == ATA && q.PassThrough != 12 {
+		list = append(list, quirks.Quirk{CommandType: ATA, PassThrough: 12})
+	}
+	if other == ATA {
+		list = append(list, quirks.Quirk{CommandType: ATA}, quirks.Quirk{CommandType: ATA, PassThrough: 12})
+	} else {
+		list = append(list, quirks.Quirk{CommandType: SCSI})
+	}
+	return list
+}
+
+/*
+ * Spin the disk down with the first adjustments it accepts and reaches
+ * standby with, and return them. A disk still spinning after a stop gets the
+ * learn delays to settle, the time it took becomes its stop delay. Disks that
+ * cannot be checked are taken at their word. No monitor state here, it runs
+ * like spindownWithQuirk.
+ */
+func (l learner) probe(disk, command string, q quirks.Quirk) (quirks.Quirk, error) {
+	var first error
+	for _, c := range candidates(command, q) {
+		err := l.spindown(disk, command, c)
+		if err != nil {
+			if first == nil {
+				first = err
+			}
+			if _, unsupported := err.(*unsupportedError); unsupported {
+				continue
+			}
+			return q, err
+		}
+		if delay, settled := l.settle(disk, c); settled {
+			if delay > c.StopDelay {
+				c.StopDelay = delay
+			}
+			return c, nil
+		}
+		if first == nil {
+			first = &classifiedError{class: FailureStillSpinning,
+				err: fmt.Errorf("%s is still spinning after the spindown", disk)}
+		}
+	}
+	return q, first
+}
+
+/* seconds the disk took to report standby, false if it never did */
+func (l learner) settle(disk string, q quirks.Quirk) (int, bool) {
+	waited := 0
+	for i := -1; i < len(learnDelays); i++ {
+		if i >= 0 {
+			l.sleep(time.Duration(learnDelays[i]) * time.Second)
+			waited += learnDelays[i]
+		}
+		standby, err := l.standby(disk, q.CommandType)
+		if err != nil {
+			return 0, true
+		}
+		if standby {
+			return waited, true
+		}
+	}
+	return 0, false
+}
@@ -0,0 +1,164 @@
+// hd-idle - spin down idle hard disks
+// Copyright (C) 2018  Andoni del Olmo
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package hdidle
+
+import (
+	"github.com/adelolmo/hd-idle/quirks"
+	"github.com/adelolmo/hd-idle/sysfs"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestLearnPassThrough(t *testing.T) {
+	var tried []quirks.Quirk
+	l := learner{
+		spindown: func(disk, command string, q quirks.Quirk) error {
+			tried = append(tried, q)
+			if q.CommandType == ATA && q.PassThrough != 12 {
+				return &unsupportedError{command: ATA, device: "/dev/" + disk}
+			}
+			return nil
+		},
+		standby: func(disk, command string) (bool, error) { return true, nil },
+		sleep:   func(time.Duration) {},
+	}
+
+	learned, err := l.probe("sdb", ATA, quirks.Quirk{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := (quirks.Quirk{CommandType: ATA, PassThrough: 12}); learned != expected {
+		t.Fatalf("Expected %+v but was %+v", expected, learned)
+	}
+	if len(tried) != 2 {
+		t.Fatalf("Expected 2 attempts but were %d", len(tried))
+	}
+}
+
+func TestLearnStopDelay(t *testing.T) {
+	checks := 0
+	var slept time.Duration
+	l := learner{
+		spindown: func(disk, command string, q quirks.Quirk) error { return nil },
+		standby: func(disk, command string) (bool, error) {
+			checks++
+			return checks == 3, nil
+		},
+		sleep: func(d time.Duration) { slept += d },
+	}
+
+	learned, err := l.probe("sdb", SCSI, quirks.Quirk{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := (quirks.Quirk{CommandType: SCSI, StopDelay: 3}); learned != expected {
+		t.Fatalf("Expected %+v but was %+v", expected, learned)
+	}
+	if slept != 3*time.Second {
+		t.Fatalf("Expected to wait 3s but waited %v", slept)
+	}
+}
+
+func TestLearnIgnoredStop(t *testing.T) {
+	var tried []quirks.Quirk
+	l := learner{
+		spindown: func(disk, command string, q quirks.Quirk) error {
+			tried = append(tried, q)
+			return nil
+		},
+		standby: func(disk, command string) (bool, error) { return false, nil },
+		sleep:   func(time.Duration) {},
+	}
+
+	learned, err := l.probe("sdb", ATA, quirks.Quirk{})
+	if err == nil || FailureClass(err) != FailureStillSpinning {
+		t.Fatalf("Expected the disk still spinning but was %v", err)
+	}
+	if learned != (quirks.Quirk{}) {
+		t.Fatalf("Expected nothing learned but was %+v", learned)
+	}
+	expected := []quirks.Quirk{{CommandType: ATA}, {CommandType: ATA, PassThrough: 12}, {CommandType: SCSI}}
+	if len(tried) != len(expected) {
+		t.Fatalf("Expected %+v tried but were %+v", expected, tried)
+	}
+	for i := range expected {
+		if tried[i] != expected[i] {
+			t.Fatalf("Expected %+v tried but were %+v", expected, tried)
+		}
+	}
+}
+
+func TestLearnedProfileAcrossRestarts(t *testing.T) {
+	dir, err := ioutil.TempDir("", "learn")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	root := sysfs.Root
+	sysfs.Root = filepath.Join(dir, "sys")
+	defer func() { sysfs.Root = root }()
+
+	/* the enclosure came back as sdc */
+	usb := filepath.Join(dir, "sys/devices/pci0000:00/0000:00:14.0/usb2/2-1")
+	block := filepath.Join(usb, "2-1:1.0/host0/target0:0:0/0:0:0:0/block/sdc")
+	mustMkdir(t, filepath.Join(block, "device"))
+	mustMkdir(t, filepath.Join(dir, "sys/block"))
+	for file, content := range map[string]string{
+		filepath.Join(usb, "idVendor"):              "152d\n",
+		filepath.Join(usb, "idProduct"):             "0578\n",
+		filepath.Join(block, "device", "serial"):    "WD-WCC4E1234567\n",
+		filepath.Join(dir, "state", "bridges.json"): `{"152d:0578/WD-WCC4E1234567":{"command_type":"ata","pass_through":12,"stop_delay":2}}`,
+	} {
+		mustMkdir(t, filepath.Dir(file))
+		if err := ioutil.WriteFile(file, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Symlink(block, filepath.Join(dir, "sys/block/sdc")); err != nil {
+		t.Fatal(err)
+	}
+
+	config := NewConfig()
+	config.Defaults.StateDir = filepath.Join(dir, "state")
+	config.Defaults.LearnQuirks = true
+	m := New(config)
+	m.SetOutput(ioutil.Discard)
+
+	if key := bridgeKey("sdc"); key != "152d:0578/WD-WCC4E1234567" {
+		t.Fatalf("Unexpected bridge key %q", key)
+	}
+	expected := quirks.Quirk{CommandType: ATA, PassThrough: 12, StopDelay: 2}
+	if q := m.quirksFor("sdc"); q != expected {
+		t.Fatalf("Expected %+v but was %+v", expected, q)
+	}
+
+	m.learn("sdc", "152d:0578/WD-WCC4E1234567", quirks.Quirk{CommandType: SCSI})
+	m = New(config)
+	m.SetOutput(ioutil.Discard)
+	if q := m.quirksFor("sdc"); q != (quirks.Quirk{CommandType: SCSI}) {
+		t.Fatalf("Expected the profile learned before the restart but was %+v", q)
+	}
+
+	/* the quirks file wins */
+	m.quirks = []quirks.Quirk{{UsbID: "152d:*", StopDelay: 5}}
+	if q := m.quirksFor("sdc"); q != (quirks.Quirk{StopDelay: 5}) {
+		t.Fatalf("Expected the quirks file entry but was %+v", q)
+	}
+}
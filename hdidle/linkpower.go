@@ -0,0 +1,78 @@
+// hd-idle - spin down idle hard disks
+// Copyright (C) 2018  Andoni del Olmo
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package hdidle
+
+import (
+	"github.com/adelolmo/hd-idle/sysfs"
+)
+
+/* the link power policy of a disk's scsi host before hd-idle lowered it */
+type linkPolicy struct {
+	host     string
+	original string
+}
+
+func (m *Monitor) lowerLinkPower(name, policy string) {
+	if _, found := m.linkPolicies[name]; found {
+		return
+	}
+	host, err := sysfs.ScsiHost(name)
+	if err != nil {
+		m.printf("Cannot lower link power of %s: %s\n", m.displayName(name), err)
+		return
+	}
+	original, err := sysfs.LinkPowerPolicy(host)
+	if err != nil {
+		m.println(err.Error())
+		return
+	}
+	if original == policy {
+		return
+	}
+	if err := sysfs.SetLinkPowerPolicy(host, policy); err != nil {
+		m.println(err.Error())
+		return
+	}
+	m.linkPolicies[name] = linkPolicy{host: host, original: original}
+	if m.debug(name) {
+		m.printf("%s link power policy of %s set to %s\n", m.displayName(name), host, policy)
+	}
+}
+
+func (m *Monitor) restoreLinkPower(name string) {
+	policy, found := m.linkPolicies[name]
+	if !found {
+		return
+	}
+	delete(m.linkPolicies, name)
+	if err := sysfs.SetLinkPowerPolicy(policy.host, policy.original); err != nil {
+		m.println(err.Error())
+		return
+	}
+	if m.debug(name) {
+		m.printf("%s link power policy of %s restored to %s\n", m.displayName(name), policy.host, policy.original)
+	}
+}
+
+/* leave the links as found when hd-idle stops */
+func (m *Monitor) restoreAllLinkPower() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for name := range m.linkPolicies {
+		m.restoreLinkPower(name)
+	}
+}
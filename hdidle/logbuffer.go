@@ -0,0 +1,204 @@
+// hd-idle - spin down idle hard disks
+// Copyright (C) 2018  Andoni del Olmo
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package hdidle
+
+import (
+	"fmt"
+	"github.com/adelolmo/hd-idle/diskstats"
+	"github.com/adelolmo/hd-idle/sysfs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+/*
+ * A logSink queues the entries of a log file while the disk holding it is
+ * spun down, so that logging never wakes a disk up. Entries are written on
+ * the disk's next natural wake or, after a timeout, to a fallback file.
+ * Without a fallback file the disk may sleep for days, so only the last
+ * logBufferSize entries are kept and the dropped ones are counted.
+ */
+type logSink struct {
+	monitor *Monitor
+	file    string
+	disks   []string
+	pending []string
+	dropped int
+	since   time.Time
+}
+
+const logBufferSize = 1000
+
+/*
+ * Warn when a log file lives on a disk hd-idle spins down. Writing the
+ * "spun down" record there would wake the disk right away.
+ */
+func (m *Monitor) warnLogOnMonitoredDisk() {
+	files := m.config.logFiles()
+	if len(files) == 0 {
+		return
+	}
+
+	snapshot, err := diskstats.Snapshot()
+	if err != nil {
+		return
+	}
+	for _, file := range files {
+		m.warnLogOn(file, snapshot)
+	}
+}
+
+func (m *Monitor) warnLogOn(file string, snapshot []diskstats.DiskStats) {
+	for _, stats := range snapshot {
+		for _, disk := range m.sinkFor(file).disks {
+			if stats.Name != disk || m.config.deviceConfig(disk).Idle == 0 {
+				continue
+			}
+			if m.config.Defaults.LogBuffer {
+				m.printf("log file %s resides on monitored disk %s. "+
+					"Entries are kept in memory while the disk is spun down\n", file, disk)
+				continue
+			}
+			m.printf("warning: log file %s resides on monitored disk %s. "+
+				"Writing to it may spin the disk up. Use --log-buffer to write only while the disk is awake\n",
+				file, disk)
+		}
+	}
+}
+
+// SetLogFile logs to another file from the next entry on, an empty name
+// stops logging to a file. Entries buffered for the previous file are still
+// written there once its disk wakes up. Only the log files of the
+// configuration are accepted, hd-idle runs as root and must not be made to
+// write anywhere else.
+func (m *Monitor) SetLogFile(file string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if len(file) > 0 {
+		if err := m.allowedLogFile(file); err != nil {
+			return err
+		}
+	}
+	m.config.Defaults.LogFile = file
+	m.warnLogOnMonitoredDisk()
+	return nil
+}
+
+/* an absolute path, not a symlink, among the log files of the configuration */
+func (m *Monitor) allowedLogFile(file string) error {
+	if m.config.Defaults.ReadOnly {
+		return fmt.Errorf("read-only mode does not allow writing to %s", file)
+	}
+	if !filepath.IsAbs(file) || filepath.Clean(file) != file {
+		return fmt.Errorf("log file %s must be an absolute path", file)
+	}
+	allowed := false
+	for _, configured := range m.logFilesAllowed {
+		allowed = allowed || configured == file
+	}
+	if !allowed {
+		return fmt.Errorf("%s is not a log file of the configuration, one of: %s", file, strings.Join(m.logFilesAllowed, ", "))
+	}
+	if info, err := os.Lstat(file); err == nil && info.Mode()&os.ModeSymlink != 0 {
+		return fmt.Errorf("log file %s is a symlink", file)
+	}
+	return nil
+}
+
+// LogFile returns the file the monitor logs to, empty if none.
+func (m *Monitor) LogFile() string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.config.Defaults.LogFile
+}
+
+func (m *Monitor) sinkFor(file string) *logSink {
+	sink, found := m.logSinks[file]
+	if found {
+		return sink
+	}
+	disks, err := sysfs.DisksForPath(file)
+	if err != nil {
+		m.printf("Cannot find disk for log file %s: %s\n", file, err)
+	}
+	sink = &logSink{monitor: m, file: file, disks: disks}
+	m.logSinks[file] = sink
+	return sink
+}
+
+func (s *logSink) write(text string) {
+	if s.monitor.config.Defaults.LogBuffer && s.diskSpunDown() {
+		if len(s.pending) == 0 {
+			s.since = time.Now()
+		}
+		if len(s.pending) == logBufferSize {
+			if s.dropped == 0 {
+				s.monitor.printf("log buffer of %s full, dropping its oldest entries until the disk wakes up\n", s.file)
+			}
+			s.pending = append(s.pending[:0], s.pending[1:]...)
+			s.dropped++
+		}
+		s.pending = append(s.pending, text)
+		return
+	}
+	s.flush(s.file)
+	s.monitor.writeToFile(s.file, text)
+}
+
+func (s *logSink) flush(file string) {
+	if s.dropped > 0 {
+		now := time.Now()
+		s.monitor.writeToFile(file, fmt.Sprintf("date: %s, time: %s, %d older entries dropped while the disk was spun down",
+			now.Format("2006-01-02"), now.Format("15:04:05"), s.dropped))
+		s.dropped = 0
+	}
+	for _, text := range s.pending {
+		s.monitor.writeToFile(file, text)
+	}
+	s.pending = nil
+}
+
+func (s *logSink) diskSpunDown() bool {
+	for _, disk := range s.disks {
+		dsi := s.monitor.previousDiskStatsIndex(disk)
+		if dsi >= 0 && s.monitor.snapshots[dsi].SpunDown {
+			return true
+		}
+	}
+	return false
+}
+
+/*
+ * Write pending entries of the log files whose disks are awake again.
+ * Entries waiting longer than the fallback timeout go to the fallback file.
+ */
+func (m *Monitor) flushLogBuffers() {
+	fallback := m.config.Defaults.LogFallback
+	for _, sink := range m.logSinks {
+		if len(sink.pending) == 0 {
+			continue
+		}
+		if !sink.diskSpunDown() {
+			sink.flush(sink.file)
+			continue
+		}
+		if len(fallback) > 0 && time.Since(sink.since) > m.config.Defaults.LogFallbackTimeout {
+			sink.flush(fallback)
+		}
+	}
+}
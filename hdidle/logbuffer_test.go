@@ -0,0 +1,124 @@
+// hd-idle - spin down idle hard disks
+// Copyright (C) 2018  Andoni del Olmo
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package hdidle
+
+import (
+	"fmt"
+	"github.com/adelolmo/hd-idle/diskstats"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+/* a monitor buffering the log file of a spun down sdb, in a temporary directory */
+func bufferingMonitor(t *testing.T) (*Monitor, *logSink, string) {
+	dir, err := ioutil.TempDir("", "logbuffer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := NewConfig()
+	config.Defaults.LogBuffer = true
+	m := New(config)
+	m.SetOutput(ioutil.Discard)
+	m.snapshots = []diskstats.DiskStats{{Name: "sdb", SpunDown: true}}
+	file := filepath.Join(dir, "hd-idle.log")
+	sink := &logSink{monitor: m, file: file, disks: []string{"sdb"}}
+	m.logSinks[file] = sink
+	return m, sink, dir
+}
+
+func logLines(t *testing.T, file string) []string {
+	content, err := ioutil.ReadFile(file)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strings.Split(strings.TrimSuffix(string(content), "\n"), "\n")
+}
+
+func TestLogBufferedWhileSpunDown(t *testing.T) {
+	m, sink, dir := bufferingMonitor(t)
+	defer os.RemoveAll(dir)
+
+	sink.write("first")
+	sink.write("second")
+	m.flushLogBuffers()
+	if lines := logLines(t, sink.file); lines != nil {
+		t.Fatalf("Expected nothing written while sdb is spun down but found %v", lines)
+	}
+
+	m.snapshots[0].SpunDown = false
+	m.flushLogBuffers()
+	if lines := logLines(t, sink.file); len(lines) != 2 || lines[0] != "first" || lines[1] != "second" {
+		t.Fatalf("Expected the buffered entries once sdb woke up but found %v", lines)
+	}
+	sink.write("third")
+	if lines := logLines(t, sink.file); len(lines) != 3 || lines[2] != "third" || len(sink.pending) != 0 {
+		t.Fatalf("Expected an entry written right away while sdb is awake but found %v", lines)
+	}
+}
+
+func TestLogBufferFallback(t *testing.T) {
+	m, sink, dir := bufferingMonitor(t)
+	defer os.RemoveAll(dir)
+	fallback := filepath.Join(dir, "fallback.log")
+	m.config.Defaults.LogFallback = fallback
+
+	sink.write("first")
+	m.flushLogBuffers()
+	if lines := logLines(t, fallback); lines != nil {
+		t.Fatalf("Expected nothing in the fallback file before the timeout but found %v", lines)
+	}
+
+	sink.since = time.Now().Add(-m.config.Defaults.LogFallbackTimeout - time.Minute)
+	m.flushLogBuffers()
+	if lines := logLines(t, fallback); len(lines) != 1 || lines[0] != "first" {
+		t.Fatalf("Expected the entry in the fallback file but found %v", lines)
+	}
+	if lines := logLines(t, sink.file); lines != nil || len(sink.pending) != 0 {
+		t.Fatalf("Expected nothing left for the log file but found %v", lines)
+	}
+}
+
+func TestLogBufferDropsOldestEntries(t *testing.T) {
+	m, sink, dir := bufferingMonitor(t)
+	defer os.RemoveAll(dir)
+
+	for i := 0; i < logBufferSize+5; i++ {
+		sink.write(fmt.Sprintf("entry %d", i))
+	}
+	if len(sink.pending) != logBufferSize || sink.pending[0] != "entry 5" || sink.dropped != 5 {
+		t.Fatalf("Expected the last %d entries kept but found %d from %s, %d dropped",
+			logBufferSize, len(sink.pending), sink.pending[0], sink.dropped)
+	}
+
+	m.snapshots[0].SpunDown = false
+	m.flushLogBuffers()
+	lines := logLines(t, sink.file)
+	if len(lines) != logBufferSize+1 || !strings.HasSuffix(lines[0], ", 5 older entries dropped while the disk was spun down") ||
+		lines[1] != "entry 5" || lines[logBufferSize] != fmt.Sprintf("entry %d", logBufferSize+4) {
+		t.Fatalf("Expected the dropped entries counted before the kept ones but found %d lines from %v", len(lines), lines[:2])
+	}
+	if sink.dropped != 0 {
+		t.Fatalf("Expected the count of dropped entries reset but found %d", sink.dropped)
+	}
+}
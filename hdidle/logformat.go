@@ -0,0 +1,66 @@
+// hd-idle - spin down idle hard disks
+// Copyright (C) 2018  Andoni del Olmo
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package hdidle
+
+import (
+	"strconv"
+	"strings"
+	"time"
+)
+
+// How events are written to the standard output and the log file.
+const (
+	LogFormatText     = "text"      // sentences, e.g. "sda spindown"
+	LogFormatKeyValue = "key-value" // a key=value line per event, e.g. event=SPINDOWN disk=sda
+)
+
+/*
+ * With --log-format key-value every event is a line of key=value pairs, on
+ * the standard output next to the sentences and in the log file instead of
+ * them. Only the message is meant for humans, the rest stays the same
+ * between versions.
+ */
+func (m *Monitor) logEvent(event Event) {
+	if m.config.Defaults.LogFormat != LogFormatKeyValue {
+		return
+	}
+	line := event.keyValue()
+	m.println(line)
+	file := m.config.Defaults.LogFile
+	if len(event.Disk) > 0 {
+		file = m.logFileOf(event.Disk)
+	}
+	if len(file) > 0 {
+		m.sinkFor(file).write(line)
+	}
+}
+
+/* time=2006-01-02T15:04:05Z event=SPINDOWN_FAILED disk=sda code=EIO message="..." tag_location=rack2 */
+func (e Event) keyValue() string {
+	fields := []string{"time=" + e.Time.Format(time.RFC3339), "event=" + strings.ToUpper(string(e.Type))}
+	if len(e.Disk) > 0 {
+		fields = append(fields, "disk="+e.Disk)
+	}
+	if len(e.Code) > 0 {
+		fields = append(fields, "code="+e.Code)
+	}
+	if len(e.Message) > 0 {
+		fields = append(fields, "message="+strconv.Quote(e.Message))
+	}
+	fields = append(fields, tagFields(e.Tags)...)
+	return strings.Join(fields, " ")
+}
@@ -0,0 +1,111 @@
+// hd-idle - spin down idle hard disks
+// Copyright (C) 2018  Andoni del Olmo
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package hdidle
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEventKeyValue(t *testing.T) {
+	event := Event{Type: EventSpindownFailed, Disk: "sda", Time: time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC),
+		Code: "EIO", Message: `cannot spindown "sda"`}
+	expected := `time=2020-01-02T03:04:05Z event=SPINDOWN_FAILED disk=sda code=EIO message="cannot spindown \"sda\""`
+	if line := event.keyValue(); line != expected {
+		t.Fatalf("Expected %s but found %s", expected, line)
+	}
+
+	event.Tags = map[string]string{"owner": "alice", "location": "rack 2"}
+	if line := event.keyValue(); line != expected+` tag_location="rack 2" tag_owner=alice` {
+		t.Fatalf("Expected the tags after the message but found %s", line)
+	}
+
+	event = Event{Type: EventPaused, Time: event.Time}
+	if line := event.keyValue(); line != "time=2020-01-02T03:04:05Z event=PAUSED" {
+		t.Fatalf("Expected empty fields left out but found %s", line)
+	}
+}
+
+func TestKeyValueLogFormat(t *testing.T) {
+	dir, err := ioutil.TempDir("", "hd-idle-log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	config := NewConfig()
+	config.Defaults.LogFile = filepath.Join(dir, "hd-idle.log")
+	config.Defaults.LogFormat = LogFormatKeyValue
+	m := New(config)
+	var out bytes.Buffer
+	m.SetOutput(&out)
+	m.now = time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	m.logToFile(config.Defaults.LogFile, "date: 2020-01-02, time: 03:04:05, disk: sda, running: 10, stopped: 20")
+	m.emitCode(EventSpindownDeferred, "sda", "DISCARD", "discard in progress")
+
+	expected := `time=2020-01-02T03:04:05Z event=SPINDOWN_DEFERRED disk=sda code=DISCARD message="discard in progress"`
+	b, err := ioutil.ReadFile(config.Defaults.LogFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.TrimSpace(string(b)) != expected {
+		t.Fatalf("Expected only the event in the log file but found %q", b)
+	}
+	if strings.TrimSpace(out.String()) != expected {
+		t.Fatalf("Expected the event on the standard output but found %q", out.String())
+	}
+}
+
+func TestDiskLogFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "hd-idle-log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	config := NewConfig()
+	config.Defaults.LogFile = filepath.Join(dir, "media.log")
+	config.Devices = []DeviceConf{{Name: "sdc", GivenName: "sdc", LogFile: filepath.Join(dir, "backup.log")}}
+	m := New(config)
+	m.SetOutput(ioutil.Discard)
+	m.now = time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	m.logSpinupAfterSleep("sdb")
+	m.logSpinupAfterSleep("sdc")
+
+	for file, disk := range map[string]string{"media.log": "sdb", "backup.log": "sdc"} {
+		b, err := ioutil.ReadFile(filepath.Join(dir, file))
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected := "date: 2020-01-02, time: 03:04:05, disk: " + disk + ", assuming disk spun up after long sleep"
+		if strings.TrimSpace(string(b)) != expected {
+			t.Fatalf("Expected only the record of %s in %s but found %q", disk, file, b)
+		}
+	}
+
+	paths := config.WritablePaths()
+	if len(paths) != 2 || paths[1] != filepath.Join(dir, "backup.log") {
+		t.Fatalf("Expected the log file of sdc writable but found %v", paths)
+	}
+}
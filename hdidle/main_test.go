@@ -0,0 +1,44 @@
+// hd-idle - spin down idle hard disks
+// Copyright (C) 2018  Andoni del Olmo
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package hdidle
+
+import (
+	"fmt"
+	"github.com/adelolmo/hd-idle/io"
+	"github.com/adelolmo/hd-idle/sgio"
+	"os"
+	"testing"
+)
+
+func TestMain(m *testing.M) {
+	/* the disks of the tests are made up, don't let the disks of the machine running them show through */
+	rotational = func(string) (bool, error) { return true, nil }
+	removable = func(string) bool { return false }
+	zoned = func(string) string { return "none" }
+	batteryPowered = func() bool { return false }
+	rootDisks = func() ([]string, error) { return nil, nil }
+	swapDisks = func() ([]string, error) { return nil, nil }
+	deviceMapperStatus = func() ([]io.DeviceMapperTarget, error) { return nil, nil }
+	diskStandby = func(device, command string) (bool, error) {
+		return false, fmt.Errorf("cannot check power state of %s in tests", device)
+	}
+	ataIdentify = func(device string) (*sgio.AtaIdentity, error) {
+		return nil, fmt.Errorf("cannot identify %s in tests", device)
+	}
+	io.UdevQueue = "/nonexistent/udev/queue"
+	os.Exit(m.Run())
+}
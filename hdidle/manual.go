@@ -0,0 +1,94 @@
+// hd-idle - spin down idle hard disks
+// Copyright (C) 2018  Andoni del Olmo
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package hdidle
+
+import (
+	"fmt"
+	"github.com/adelolmo/hd-idle/io"
+	"time"
+)
+
+/*
+ * A disk spun up by hand, through SpinUp, is being worked on: it isn't spun
+ * down for --manual-hold, and its idle time only starts once the hold is
+ * over, so a user pausing to read isn't raced by the next cycle.
+ */
+
+// SpinUp starts the disk, given by any name RealPath takes, and holds it for
+// the manual hold time. The disk must be monitored.
+func (m *Monitor) SpinUp(disk string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	name, err := io.RealPath(disk)
+	if err != nil {
+		return err
+	}
+	dsi := m.previousDiskStatsIndex(name)
+	if dsi < 0 {
+		return fmt.Errorf("disk %s is not monitored", disk)
+	}
+	m.resumeHbaOf(name)
+	m.restoreLinkPower(name)
+	m.restoreReadAhead(name)
+	device := fmt.Sprintf("/dev/%s", name)
+	command := m.snapshots[dsi].CommandType
+	q := m.quirksFor(name)
+	if err := m.deviceCommand(name, func() error { return spinupWithQuirk(device, command, q) }); err != nil {
+		return err
+	}
+
+	m.manualSpinups[name] = true
+	if hold := m.config.Defaults.ManualHold; hold > 0 {
+		m.manualHolds[name] = time.Now().Add(hold)
+		m.printf("%s spun up by hand, held for %s\n", m.displayName(name), FormatDuration(hold))
+	} else {
+		m.printf("%s spun up by hand\n", m.displayName(name))
+	}
+	return nil
+}
+
+/* count a spin up by hand in the cycle after it, and start the idle time over */
+func (m *Monitor) noteManualSpinup(dsi int) {
+	name := m.snapshots[dsi].Name
+	if !m.manualSpinups[name] {
+		return
+	}
+	delete(m.manualSpinups, name)
+	if m.snapshots[dsi].SpunDown {
+		m.countSpinup(name)
+		m.logSpinup(m.snapshots[dsi])
+		m.emitCode(EventSpinup, name, "MANUAL", "spun up by hand")
+		m.snapshots[dsi].SpinUpAt = m.now
+		m.snapshots[dsi].SpunDown = false
+	}
+	m.snapshots[dsi].LastIoAt = m.now
+}
+
+/* whether the disk is still held after a spin up by hand */
+func (m *Monitor) manuallyHeld(name string) bool {
+	until, found := m.manualHolds[name]
+	if !found {
+		return false
+	}
+	if m.now.Before(until) {
+		return true
+	}
+	delete(m.manualHolds, name)
+	m.printf("%s manual hold over\n", m.displayName(name))
+	return false
+}
@@ -0,0 +1,72 @@
+// hd-idle - spin down idle hard disks
+// Copyright (C) 2018  Andoni del Olmo
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package hdidle
+
+import (
+	"github.com/adelolmo/hd-idle/diskstats"
+	"io/ioutil"
+	"testing"
+	"time"
+)
+
+func TestManualSpinupHoldsDisk(t *testing.T) {
+	simulation, err := ParseSimulation("dry-run")
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := NewConfig()
+	config.Defaults.Simulation = simulation
+	config.Defaults.Idle = 10 * time.Minute
+	config.Defaults.ManualHold = 30 * time.Minute
+	config.SkewTime = 24 * time.Hour
+	m := New(config)
+	m.SetOutput(ioutil.Discard)
+
+	start := time.Now()
+	cycle := func(minutes int) *diskstats.DiskStats {
+		m.now = start.Add(time.Duration(minutes) * time.Minute)
+		m.updateState(diskstats.DiskStats{Name: "sdb"})
+		m.lastNow = m.now
+		return &m.snapshots[0]
+	}
+	if err := m.SpinUp("sdb"); err == nil {
+		t.Fatal("Expected an error for a disk not monitored yet")
+	}
+	cycle(0)
+	if ds := cycle(11); !ds.SpunDown {
+		t.Fatal("Expected sdb spun down after its idle time")
+	}
+
+	if err := m.SpinUp("sdb"); err != nil {
+		t.Fatal(err)
+	}
+	ds := cycle(12)
+	if spinups := m.statisticsOf("sdb").Spinups; ds.SpunDown || spinups != 1 {
+		t.Fatalf("Expected the spin up by hand counted but found spunDown=%t spinups=%d", ds.SpunDown, spinups)
+	}
+	/* idle past the idle time, but still held */
+	if ds = cycle(29); ds.SpunDown {
+		t.Fatal("Expected sdb held after the spin up by hand")
+	}
+	/* the idle time starts when the hold is over */
+	if ds = cycle(35); ds.SpunDown {
+		t.Fatal("Expected the idle time to start over after the hold")
+	}
+	if ds = cycle(41); !ds.SpunDown {
+		t.Fatal("Expected sdb spun down once idle after the hold")
+	}
+}
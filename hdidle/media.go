@@ -0,0 +1,134 @@
+// hd-idle - spin down idle hard disks
+// Copyright (C) 2018  Andoni del Olmo
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package hdidle
+
+import (
+	"github.com/adelolmo/hd-idle/sgio"
+	"github.com/adelolmo/hd-idle/sysfs"
+	"strings"
+	"time"
+)
+
+// The kinds of media of a disk, as shown in the status.
+const (
+	MediaHdd   = "hdd"
+	MediaSsd   = "ssd"
+	MediaSshd  = "sshd"  // hybrid drive, platters behind a flash cache
+	MediaSmr   = "smr"   // shingled magnetic recording drive, cleaning up its media cache after writes
+	MediaFlash = "flash" // removable flash media, e.g. a USB stick or an SD card
+)
+
+/* replaced in tests */
+var (
+	rotational = sysfs.Rotational
+	removable  = sysfs.Removable
+	zoned      = sysfs.Zoned
+)
+
+/* hybrid drives that don't advertise the hybrid information feature, by model prefix */
+var sshdModels = []string{
+	"ST500LM000", "ST1000LM014", "ST500LX025", "ST1000LX015", "ST2000LX001",
+	"ST1000DX001", "ST1000DX002", "ST2000DX001", "ST2000DX002", "ST4000DX001", "ST4000DX002",
+	"WD10J31X", "WD5000J31X",
+	"MQ01ABDH", "MQ02ABD",
+}
+
+/*
+ * SSDs and NVMe drives have nothing to spin down, and some of them take a
+ * standby command badly. They are left alone unless named with --manage-ssd.
+ * Hybrid drives (SSHDs) serve most reads from their flash cache, so spinning
+ * them down saves less, and disks not named with -a get --sshd-idle instead
+ * of the default idle time. Shingled (SMR) drives get --smr-idle the same
+ * way, see smr.go. USB sticks and memory cards have nothing to spin
+ * down either, and are only managed when named with -a. A disk whose kind
+ * cannot be read is taken for a spinning one.
+ */
+func (m *Monitor) classifyMedia(name string) {
+	media := m.mediaOf(name)
+	m.media[name] = media
+
+	device := m.config.deviceConfig(name)
+	switch {
+	case media == MediaFlash && device.Idle != 0 && !m.config.named(name):
+		m.printf("%s is removable flash media, not managed\n", m.displayName(name))
+	case media == MediaSsd && device.Idle != 0 && !device.ManageSsd:
+		m.printf("%s is not rotational, not managed\n", m.displayName(name))
+	case media == MediaSshd && m.idleTime(name, device) != device.Idle:
+		m.printf("%s is a hybrid drive, idle time %v\n", m.displayName(name), m.idleTime(name, device))
+	case media == MediaSmr && m.idleTime(name, device) != device.Idle:
+		m.printf("%s is a shingled (SMR) drive, idle time %v\n", m.displayName(name), m.idleTime(name, device))
+	}
+}
+
+func (m *Monitor) mediaOf(name string) string {
+	if removable(name) {
+		return MediaFlash
+	}
+	if spinning, err := rotational(name); err == nil && !spinning {
+		return MediaSsd
+	}
+	if model := zoned(name); model == "host-aware" || model == "host-managed" {
+		return MediaSmr
+	}
+	if id, err := m.identify(name); err == nil {
+		switch {
+		case id.Hybrid:
+			return MediaSshd
+		case id.RotationRate == 1:
+			/* e.g. behind a usb bridge that reports every disk as rotational */
+			return MediaSsd
+		case id.Zoned == sgio.ZonedHostAware || id.Zoned == sgio.ZonedDriveManaged, smrModel(id.Model):
+			return MediaSmr
+		}
+	}
+	model := strings.ToUpper(sysfs.Model(name))
+	for _, prefix := range sshdModels {
+		if strings.HasPrefix(model, prefix) {
+			return MediaSshd
+		}
+	}
+	if smrModel(model) {
+		return MediaSmr
+	}
+	return MediaHdd
+}
+
+/* the idle time of the disk, as configured or as its kind of media asks for */
+func (m *Monitor) idleTime(name string, device *DeviceConf) time.Duration {
+	if m.config.Defaults.OptIn && !m.config.named(name) {
+		return 0
+	}
+	switch m.media[name] {
+	case MediaFlash:
+		if !m.config.named(name) {
+			return 0
+		}
+	case MediaSsd:
+		if !device.ManageSsd {
+			return 0
+		}
+	case MediaSshd:
+		if device.Idle != 0 && !m.config.named(name) {
+			return m.config.Defaults.SshdIdle
+		}
+	case MediaSmr:
+		if device.Idle != 0 && !m.config.named(name) {
+			return m.config.Defaults.SmrIdle
+		}
+	}
+	return m.sourceIdle(device)
+}
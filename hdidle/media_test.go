@@ -0,0 +1,220 @@
+// hd-idle - spin down idle hard disks
+// Copyright (C) 2018  Andoni del Olmo
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package hdidle
+
+import (
+	"fmt"
+	"github.com/adelolmo/hd-idle/diskstats"
+	"github.com/adelolmo/hd-idle/sgio"
+	"io/ioutil"
+	"testing"
+	"time"
+)
+
+func TestSolidStateNotManaged(t *testing.T) {
+	rotational = func(name string) (bool, error) { return name == "sda", nil }
+	defer func() { rotational = func(string) (bool, error) { return true, nil } }()
+
+	simulation, err := ParseSimulation("dry-run")
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := NewConfig()
+	config.Defaults.Simulation = simulation
+	config.Defaults.Idle = time.Minute
+	config.SkewTime = 24 * time.Hour
+	config.Devices = []DeviceConf{{Name: "nvme1n1", Idle: time.Minute, CommandType: SCSI, ManageSsd: true}}
+	m := New(config)
+	m.SetOutput(ioutil.Discard)
+
+	start := time.Now()
+	for _, now := range []time.Time{start, start.Add(10 * time.Minute)} {
+		m.now = now
+		for _, disk := range []string{"sda", "sdb", "nvme1n1"} {
+			m.updateState(diskstats.DiskStats{Name: disk})
+		}
+		m.lastNow = now
+	}
+	spunDown := map[string]bool{}
+	for _, ds := range m.snapshots {
+		spunDown[ds.Name] = ds.SpunDown
+	}
+	if !spunDown["sda"] || spunDown["sdb"] || !spunDown["nvme1n1"] {
+		t.Fatalf("Expected sda and nvme1n1 spun down but found %v", spunDown)
+	}
+
+	/* a reload keeps the ssd out */
+	if err := m.Reload(config); err != nil {
+		t.Fatal(err)
+	}
+	for _, ds := range m.snapshots {
+		if ds.Name == "sdb" && ds.IdleTime != 0 {
+			t.Fatalf("Expected sdb without idle time after a reload but found %v", ds.IdleTime)
+		}
+	}
+}
+
+func TestHybridDriveIdleTime(t *testing.T) {
+	ataIdentify = func(device string) (*sgio.AtaIdentity, error) {
+		return &sgio.AtaIdentity{Hybrid: device == "/dev/sdc" || device == "/dev/sdd"}, nil
+	}
+	defer func() {
+		ataIdentify = func(device string) (*sgio.AtaIdentity, error) {
+			return nil, fmt.Errorf("cannot identify %s in tests", device)
+		}
+	}()
+
+	config := NewConfig()
+	config.Defaults.Idle = time.Minute
+	config.Devices = []DeviceConf{{Name: "sdd", Idle: 2 * time.Minute, CommandType: SCSI}}
+	m := New(config)
+	m.SetOutput(ioutil.Discard)
+	for _, disk := range []string{"sdb", "sdc", "sdd"} {
+		m.snapshots = append(m.snapshots, m.initDevice(diskstats.DiskStats{Name: disk}))
+	}
+
+	/* sdd is named with -a and keeps its idle time */
+	expected := map[string]struct {
+		media string
+		idle  time.Duration
+	}{
+		"sdb": {MediaHdd, time.Minute},
+		"sdc": {MediaSshd, DefaultSshdIdleTime},
+		"sdd": {MediaSshd, 2 * time.Minute},
+	}
+	for _, status := range m.Status() {
+		e := expected[status.Name]
+		if status.Media != e.media || status.IdleTime != e.idle {
+			t.Fatalf("Expected %s an %s idle for %v but found %s idle for %v",
+				status.Name, e.media, e.idle, status.Media, status.IdleTime)
+		}
+	}
+}
+
+func TestRemovableFlashNotManaged(t *testing.T) {
+	removable = func(name string) bool { return name == "sdf" || name == "mmcblk0" }
+	defer func() { removable = func(string) bool { return false } }()
+
+	config := NewConfig()
+	config.Defaults.Idle = time.Minute
+	config.Devices = []DeviceConf{{Name: "mmcblk0", Idle: 2 * time.Minute, CommandType: SCSI}}
+	m := New(config)
+	m.SetOutput(ioutil.Discard)
+	for _, disk := range []string{"sdf", "mmcblk0"} {
+		m.snapshots = append(m.snapshots, m.initDevice(diskstats.DiskStats{Name: disk}))
+	}
+
+	/* mmcblk0 is named with -a */
+	expected := map[string]time.Duration{"sdf": 0, "mmcblk0": 2 * time.Minute}
+	for _, status := range m.Status() {
+		if status.Media != MediaFlash || status.IdleTime != expected[status.Name] {
+			t.Fatalf("Expected %s flash idle for %v but found %s idle for %v",
+				status.Name, expected[status.Name], status.Media, status.IdleTime)
+		}
+	}
+}
+
+func TestOptInManagesNamedDisksOnly(t *testing.T) {
+	config := NewConfig()
+	config.Defaults.Idle = time.Minute
+	config.Defaults.OptIn = true
+	config.Devices = []DeviceConf{
+		{Name: "sdb", Idle: 2 * time.Minute, CommandType: SCSI},
+		{Name: "usb-*", GivenName: "usb-*", Idle: 3 * time.Minute, CommandType: SCSI},
+	}
+	m := New(config)
+	m.SetOutput(ioutil.Discard)
+	for _, disk := range []string{"sda", "sdb", "usb-WD_Elements"} {
+		m.snapshots = append(m.snapshots, m.initDevice(diskstats.DiskStats{Name: disk}))
+	}
+
+	/* sda shows up in /proc/diskstats but isn't named */
+	expected := map[string]time.Duration{"sda": 0, "sdb": 2 * time.Minute, "usb-WD_Elements": 3 * time.Minute}
+	statuses := m.Status()
+	if len(statuses) != len(expected) {
+		t.Fatalf("Expected %d disks but found %d", len(expected), len(statuses))
+	}
+	for _, status := range statuses {
+		if status.IdleTime != expected[status.Name] {
+			t.Fatalf("Expected %s idle for %v but found %v", status.Name, expected[status.Name], status.IdleTime)
+		}
+	}
+}
+
+func TestSmrDriveWaitsForGarbageCollection(t *testing.T) {
+	zoned = func(name string) string {
+		if name == "sdd" {
+			return "host-aware"
+		}
+		return "none"
+	}
+	ataIdentify = func(device string) (*sgio.AtaIdentity, error) {
+		if device == "/dev/sdc" {
+			return &sgio.AtaIdentity{Zoned: sgio.ZonedDriveManaged}, nil
+		}
+		return &sgio.AtaIdentity{}, nil
+	}
+	defer func() {
+		zoned = func(string) string { return "none" }
+		ataIdentify = func(device string) (*sgio.AtaIdentity, error) {
+			return nil, fmt.Errorf("cannot identify %s in tests", device)
+		}
+	}()
+
+	simulation, err := ParseSimulation("dry-run")
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := NewConfig()
+	config.Defaults.Simulation = simulation
+	config.Defaults.Idle = time.Minute
+	config.Defaults.SmrIdle = 2 * time.Minute
+	config.Defaults.SmrGcWait = 20 * time.Minute
+	config.SkewTime = 24 * time.Hour
+	m := New(config)
+	m.SetOutput(ioutil.Discard)
+
+	start := time.Now()
+	cycle := func(minutes, writes int) map[string]bool {
+		m.now = start.Add(time.Duration(minutes) * time.Minute)
+		for _, disk := range []string{"sdb", "sdc", "sdd"} {
+			m.updateState(diskstats.DiskStats{Name: disk, Writes: writes})
+		}
+		m.lastNow = m.now
+		spunDown := map[string]bool{}
+		for _, ds := range m.snapshots {
+			spunDown[ds.Name] = ds.SpunDown
+		}
+		return spunDown
+	}
+	cycle(0, 0)
+	for _, status := range m.Status() {
+		expected := map[string]string{"sdb": MediaHdd, "sdc": MediaSmr, "sdd": MediaSmr}[status.Name]
+		if status.Media != expected || (expected == MediaSmr && status.IdleTime != 2*time.Minute) {
+			t.Fatalf("Expected %s an %s but found %s idle for %v", status.Name, expected, status.Media, status.IdleTime)
+		}
+	}
+
+	/* written at minute 1, the smr drives stay up until minute 21 */
+	cycle(1, 10)
+	if spunDown := cycle(10, 10); !spunDown["sdb"] || spunDown["sdc"] || spunDown["sdd"] {
+		t.Fatalf("Expected only sdb spun down but found %v", spunDown)
+	}
+	if spunDown := cycle(22, 10); !spunDown["sdc"] || !spunDown["sdd"] {
+		t.Fatalf("Expected the smr drives spun down after the wait but found %v", spunDown)
+	}
+}
@@ -0,0 +1,497 @@
+// hd-idle - spin down idle hard disks
+// Copyright (C) 2018  Andoni del Olmo
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+// Package hdidle spins down idle disks. It is the engine of the hd-idle
+// command and can be embedded in other programs:
+//
+//	config := hdidle.NewConfig()
+//	monitor := hdidle.New(config)
+//	go monitor.Run()
+//	...
+//	monitor.Stop()
+package hdidle
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/adelolmo/hd-idle/diskstats"
+	"github.com/adelolmo/hd-idle/quirks"
+	"io"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const subscriberBufferSize = 16
+
+type EventType string
+
+const (
+	EventSpindown            EventType = "spindown"
+	EventSpindownFailed      EventType = "spindown_failed"
+	EventSpindownDeferred    EventType = "spindown_deferred"
+	EventSpinup              EventType = "spinup"
+	EventSleepReset          EventType = "sleep_reset"
+	EventUsbPowerOff         EventType = "usb_power_off"
+	EventDeviceStuck         EventType = "device_stuck"
+	EventDeviceRecovered     EventType = "device_recovered"
+	EventLoopStuck           EventType = "loop_stuck"
+	EventQuarantined         EventType = "quarantined"
+	EventWakeStorm           EventType = "wake_storm"
+	EventSpindownUnsupported EventType = "spindown_unsupported"
+	EventDiskReplaced        EventType = "disk_replaced"
+	EventPaused              EventType = "paused"
+	EventResumed             EventType = "resumed"
+	EventDiskPlugged         EventType = "disk_plugged"
+	EventSafeToRemove        EventType = "safe_to_remove"
+	EventSpindownUnverified  EventType = "spindown_unverified"
+	EventProfileSwitched     EventType = "profile_switched"
+	EventDailyReport         EventType = "daily_report"
+)
+
+// Event tells about something that happened to a disk.
+type Event struct {
+	Type    EventType
+	Disk    string
+	Time    time.Time
+	Code    string            // why, machine-stable, e.g. EIO for a failed spindown, empty if the type says it all
+	Message string            // why, for humans, may change between versions
+	Tags    map[string]string // of the disk, see DeviceConf.Tags
+}
+
+// DeviceStatus is the state of a disk as seen by the monitor.
+type DeviceStatus struct {
+	Name        string
+	Alias       string
+	CommandType string
+	IdleTime    time.Duration
+	SpunDown    bool
+	SpinDownAt  time.Time
+	SpinUpAt    time.Time
+	LastIoAt    time.Time
+	Temperature *float64 // degrees Celsius, nil if unknown
+	Failures    int      // consecutive command failures
+	// QuarantinedUntil is set while the disk gets no commands after failing
+	// repeatedly.
+	QuarantinedUntil time.Time
+	Smart            *SmartStatus // nil until collected
+	WakeLatency      *WakeLatency // nil until the disk woke up once
+	Statistics       *DiskStatistics
+	// Unsupported tells why hd-idle gave up spinning the disk down.
+	Unsupported string
+	// Inherits is the persistent name of the replaced disk whose
+	// configuration the disk inherited.
+	Inherits string
+	// Media is the kind of disk: hdd, ssd, sshd, smr or flash.
+	Media string
+	// Namespace is the group of disks the disk belongs to, empty for none.
+	Namespace string
+	// Tags are the tags of the user on the disk, see DeviceConf.Tags.
+	Tags map[string]string
+	// Links are the persistent names of the disk and its partitions, e.g.
+	// /dev/disk/by-uuid/0b4e-1f2a.
+	Links []string
+}
+
+type subscriber struct {
+	disk string
+	ch   chan Event
+}
+
+// Monitor observes the disk activity and spins down idle disks.
+type Monitor struct {
+	config *Config
+	out    io.Writer
+
+	mu                   sync.Mutex
+	snapshots            []diskstats.DiskStats
+	now                  time.Time
+	lastNow              time.Time
+	logSinks             map[string]*logSink
+	logFilesAllowed      []string // SetLogFile may switch to, see Config.configuredLogFiles
+	temperatureInputs    map[string]string
+	temperatures         map[string]float64
+	identities           map[string]identifyResult
+	identityKeys         map[string]string
+	quirks               []quirks.Quirk
+	quirksModTime        time.Time
+	learned              map[string]quirks.Quirk // bridge profiles by bridge key, loaded on first use
+	linkPolicies         map[string]linkPolicy
+	readAheads           map[string]readAhead
+	unsupported          map[string]string
+	runtimePms           map[string]runtimePm
+	slots                map[string]string // slot by persistent name, loaded on first use
+	inherited            map[string]string
+	pendingSince         map[string]time.Time // by given name
+	backups              map[string]*backupRun
+	stacked              map[string]stackedIo // last counters by loop or device mapper device
+	stackedIo            map[string]stackedIo // charged to the disks underneath, by disk
+	hbas                 map[string]string
+	hbaControls          map[string]string
+	usbHubs              map[string]string
+	usbHubCommandAt      map[string]time.Time
+	stuck                map[string]bool
+	breakers             map[string]*breaker
+	mountsReady          map[string]bool
+	mountWaitSince       map[string]time.Time
+	mountPoints          map[string]bool
+	mountPointsAt        time.Time
+	appearedAt           map[string]time.Time // disks still in their probe window
+	vmDisks              map[string]bool      // passed through to a running virtual machine
+	media                map[string]string    // hdd, ssd, sshd, smr or flash
+	lastWriteAt          map[string]time.Time // of SMR drives, their garbage collection follows the writes
+	exports              map[string][]string  // connected iSCSI and NBD clients by block device
+	exportsAt            time.Time
+	dmTargets            deviceMapperTargets // as dmsetup status told at dmTargetsAt
+	dmTargetsAt          time.Time
+	thinPools            map[string]string     // transaction id and used metadata blocks by pool
+	powerChecks          map[string]powerCheck // spin downs waiting to be seen on the power meter
+	vmOpeners            map[string]int        // qemu pid by block device
+	vmOpenersAt          time.Time
+	smart                map[string]SmartStatus
+	wakeLatencies        map[string]WakeLatency
+	statistics           map[string]*DiskStatistics // by identity key
+	checkpointAt         time.Time
+	reportAt             time.Time                 // start of the period of the next daily report
+	reportStatistics     map[string]DiskStatistics // at reportAt, by identity key
+	dayNotes             map[string]*dayNotes
+	epochs               []Epoch
+	epochsLoaded         bool
+	recentWakes          []wake
+	lastStormAt          time.Time
+	advice               map[string]*Advice
+	pausedUntil          time.Time
+	namespacePausedUntil map[string]time.Time
+	pauseAnnounced       bool
+	onBattery            bool
+	manualSpinups        map[string]bool      // spun up by SpinUp since the last cycle
+	manualHolds          map[string]time.Time // end of the hold after a spin up by hand
+	rootDisks            []string             // of the root filesystem, nil until found
+	swapDisks            map[string]bool      // holding active swap
+	audit                *openAudit           // of the device opens, nil without --audit-opens
+	self                 SelfMetrics          // measured after the last cycle with --self-metrics
+	profile              string               // active, none if empty
+	profileSwitched      bool
+	currentDisk          string // being handled by the cycle, for crash reports
+	vetoers              []namedVetoer
+	vetoDeadline         context.Context // shared by the vetoers asked during a cycle
+	interval             time.Duration
+	started              bool
+
+	cycleDoneAt int64 // unix nanoseconds, read by the watchdog without holding mu
+
+	/* what the watchdog needs, it cannot wait for mu held by a stuck cycle */
+	watchdogMu   sync.Mutex
+	watchdogView watchdogView
+
+	subscribersMu     sync.Mutex
+	subscribers       []*subscriber
+	subscribersClosed bool // Run returned, new subscriptions are closed right away
+	sinks             []*sinkQueue
+
+	stopOnce sync.Once
+	stop     chan struct{}
+	done     chan struct{}
+}
+
+// New creates a monitor for the given configuration. The monitor owns the
+// configuration from now on.
+func New(config *Config) *Monitor {
+	return &Monitor{
+		config:               config,
+		profile:              config.Defaults.Profile,
+		out:                  os.Stdout,
+		now:                  time.Now(),
+		lastNow:              time.Now(),
+		logSinks:             map[string]*logSink{},
+		logFilesAllowed:      config.configuredLogFiles(),
+		temperatureInputs:    map[string]string{},
+		temperatures:         map[string]float64{},
+		identities:           map[string]identifyResult{},
+		identityKeys:         map[string]string{},
+		linkPolicies:         map[string]linkPolicy{},
+		readAheads:           map[string]readAhead{},
+		unsupported:          map[string]string{},
+		runtimePms:           map[string]runtimePm{},
+		inherited:            map[string]string{},
+		pendingSince:         map[string]time.Time{},
+		backups:              map[string]*backupRun{},
+		stacked:              map[string]stackedIo{},
+		stackedIo:            map[string]stackedIo{},
+		hbas:                 map[string]string{},
+		hbaControls:          map[string]string{},
+		usbHubs:              map[string]string{},
+		usbHubCommandAt:      map[string]time.Time{},
+		stuck:                map[string]bool{},
+		breakers:             map[string]*breaker{},
+		mountsReady:          map[string]bool{},
+		mountWaitSince:       map[string]time.Time{},
+		vmDisks:              map[string]bool{},
+		appearedAt:           map[string]time.Time{},
+		media:                map[string]string{},
+		lastWriteAt:          map[string]time.Time{},
+		powerChecks:          map[string]powerCheck{},
+		smart:                map[string]SmartStatus{},
+		wakeLatencies:        map[string]WakeLatency{},
+		statistics:           map[string]*DiskStatistics{},
+		dayNotes:             map[string]*dayNotes{},
+		advice:               map[string]*Advice{},
+		namespacePausedUntil: map[string]time.Time{},
+		manualSpinups:        map[string]bool{},
+		manualHolds:          map[string]time.Time{},
+		thinPools:            map[string]string{},
+		stop:                 make(chan struct{}),
+		done:                 make(chan struct{}),
+	}
+}
+
+// SetOutput sets where human readable messages are written, stdout by default.
+func (m *Monitor) SetOutput(w io.Writer) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.out = w
+	m.updateWatchdogView()
+}
+
+// Run observes the disk activity until Stop is called. A monitor can only
+// run once.
+func (m *Monitor) Run() error {
+	m.mu.Lock()
+	if m.started {
+		m.mu.Unlock()
+		return errors.New("monitor already started")
+	}
+	m.started = true
+	m.mu.Unlock()
+	defer close(m.done)
+	defer m.closeSubscribers()
+	defer m.restoreAllLinkPower()
+	defer m.restoreAllHbaPower()
+	defer m.restoreAllReadAheads()
+	defer m.restoreAllDiskRuntimePm()
+	defer m.saveStatistics()
+
+	if len(m.config.Defaults.QuirksFile) > 0 {
+		if err := m.loadQuirks(m.config.Defaults.QuirksFile); err != nil {
+			return fmt.Errorf("cannot load quirks file %s: %s", m.config.Defaults.QuirksFile, err)
+		}
+	}
+	defer m.startOpenAudit()()
+	m.warnLogOnMonitoredDisk()
+	m.restoreSavedReadAheads()
+	m.loadStatistics()
+
+	interval := PollInterval(m.config.Devices)
+	if m.config.SkewTime == 0 {
+		m.config.SkewTime = interval * 3
+	}
+	m.mu.Lock()
+	m.interval = interval
+	m.updateWatchdogView()
+	m.mu.Unlock()
+	atomic.StoreInt64(&m.cycleDoneAt, time.Now().UnixNano())
+	if m.config.Defaults.WatchdogFactor > 0 {
+		go func() {
+			err := recovered(func() error { m.watchdog(); return nil })
+			if p, ok := err.(*panicError); ok {
+				m.mu.Lock()
+				m.reportCrash("watchdog", "", p)
+				m.mu.Unlock()
+			}
+		}()
+	}
+	timer := time.NewTimer(interval)
+	defer timer.Stop()
+	for {
+		if err := m.ObserveDiskActivity(); err != nil {
+			return err
+		}
+		select {
+		case <-m.stop:
+			return nil
+		case <-timer.C:
+			/* a reload may change the interval */
+			m.mu.Lock()
+			interval = m.interval
+			m.mu.Unlock()
+			timer.Reset(interval)
+		}
+	}
+}
+
+// Stopping returns a channel closed once Stop is called, for the goroutines
+// serving the monitor to end with it.
+func (m *Monitor) Stopping() <-chan struct{} {
+	return m.stop
+}
+
+// Stop ends Run and waits for it to return. Subscriptions are closed.
+func (m *Monitor) Stop() {
+	m.stopOnce.Do(func() { close(m.stop) })
+	m.mu.Lock()
+	started := m.started
+	m.mu.Unlock()
+	if started {
+		<-m.done
+	}
+}
+
+// Status returns the state of all disks seen so far.
+func (m *Monitor) Status() []DeviceStatus {
+	links := diskLinks()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var status []DeviceStatus
+	for _, ds := range m.snapshots {
+		s := DeviceStatus{
+			Name:        ds.Name,
+			Alias:       m.config.deviceConfig(ds.Name).Alias,
+			CommandType: ds.CommandType,
+			IdleTime:    ds.IdleTime,
+			SpunDown:    ds.SpunDown,
+			SpinDownAt:  ds.SpinDownAt,
+			SpinUpAt:    ds.SpinUpAt,
+			LastIoAt:    ds.LastIoAt,
+			Links:       links[ds.Name],
+			Unsupported: m.unsupported[ds.Name],
+			Inherits:    m.inherited[ds.Name],
+			Media:       m.media[ds.Name],
+			Namespace:   m.config.deviceConfig(ds.Name).Namespace,
+			Tags:        m.tagsOf(ds.Name),
+		}
+		if celsius, found := m.temperatures[ds.Name]; found {
+			s.Temperature = &celsius
+		}
+		if latency, found := m.wakeLatencies[ds.Name]; found {
+			s.WakeLatency = &latency
+		}
+		if statistics, found := m.statistics[m.identityKey(ds.Name)]; found {
+			copied := *statistics
+			s.Statistics = &copied
+		}
+		if smart, found := m.smart[ds.Name]; found {
+			s.Smart = &smart
+		}
+		if b, found := m.breakers[ds.Name]; found {
+			s.Failures = b.failures
+			if m.quarantined(ds.Name) {
+				s.QuarantinedUntil = b.openUntil
+			}
+		}
+		status = append(status, s)
+	}
+	return status
+}
+
+// Subscribe returns the events of the given disk, or of all disks if disk
+// is empty, and a function to cancel the subscription. Events are dropped
+// when the channel is full, so a slow reader never blocks the monitor. Once
+// Run has returned, the channel is closed right away.
+func (m *Monitor) Subscribe(disk string) (<-chan Event, func()) {
+	s := &subscriber{disk: disk, ch: make(chan Event, subscriberBufferSize)}
+	m.subscribersMu.Lock()
+	if m.subscribersClosed {
+		close(s.ch)
+	} else {
+		m.subscribers = append(m.subscribers, s)
+	}
+	m.subscribersMu.Unlock()
+
+	return s.ch, func() {
+		m.subscribersMu.Lock()
+		defer m.subscribersMu.Unlock()
+		for i, sub := range m.subscribers {
+			if sub == s {
+				m.subscribers = append(m.subscribers[:i], m.subscribers[i+1:]...)
+				close(s.ch)
+				return
+			}
+		}
+	}
+}
+
+func (m *Monitor) emit(eventType EventType, disk, message string) {
+	m.emitCode(eventType, disk, "", message)
+}
+
+func (m *Monitor) emitCode(eventType EventType, disk, code, message string) {
+	event := Event{Type: eventType, Disk: disk, Time: m.now, Code: code, Message: message, Tags: m.tagsOf(disk)}
+	if eventType == EventSpindownDeferred {
+		m.noteDeferral(disk, code)
+	}
+	m.logEvent(event)
+	m.publish(event)
+}
+
+/* to the subscribers and the sinks, safe without holding mu */
+func (m *Monitor) publish(event Event) {
+	m.subscribersMu.Lock()
+	defer m.subscribersMu.Unlock()
+	for _, s := range m.subscribers {
+		if len(s.disk) > 0 && s.disk != event.Disk {
+			continue
+		}
+		select {
+		case s.ch <- event:
+		default:
+		}
+	}
+	for _, q := range m.sinks {
+		q.push(event)
+	}
+}
+
+func (m *Monitor) closeSubscribers() {
+	m.subscribersMu.Lock()
+	defer m.subscribersMu.Unlock()
+	for _, s := range m.subscribers {
+		close(s.ch)
+	}
+	m.subscribers = nil
+	m.subscribersClosed = true
+}
+
+func (m *Monitor) printf(format string, a ...interface{}) {
+	fmt.Fprintf(m.out, format, a...)
+}
+
+func (m *Monitor) println(a ...interface{}) {
+	fmt.Fprintln(m.out, a...)
+}
+
+// PollInterval returns how often the disk activity is observed for the
+// given devices.
+func PollInterval(deviceConfs []DeviceConf) time.Duration {
+	if len(deviceConfs) == 0 {
+		return DefaultIdleTime / 10
+	}
+
+	interval := DefaultIdleTime
+	for _, dev := range deviceConfs {
+		if dev.Idle < interval {
+			interval = dev.Idle
+		}
+	}
+
+	sleepTime := interval / 10
+	if sleepTime == 0 {
+		return time.Second
+	}
+	return sleepTime
+}
@@ -0,0 +1,99 @@
+// hd-idle - spin down idle hard disks
+// Copyright (C) 2018  Andoni del Olmo
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package hdidle
+
+import (
+	"github.com/adelolmo/hd-idle/io"
+	"github.com/adelolmo/hd-idle/sysfs"
+	"path/filepath"
+)
+
+/*
+ * A disk with --wait-mount is not managed until its filesystems are
+ * mounted, so hd-idle doesn't spin it down while the boot process is about
+ * to fsck and mount it.
+ */
+func (m *Monitor) waitingForMounts(name string) bool {
+	if m.mountsReady[name] {
+		return false
+	}
+	wanted := m.config.deviceConfig(name).WaitMounts
+	if len(wanted) == 0 {
+		m.mountsReady[name] = true
+		return false
+	}
+
+	since, found := m.mountWaitSince[name]
+	if !found {
+		since = m.now
+		m.mountWaitSince[name] = since
+	}
+	missing := m.missingMounts(wanted)
+	switch {
+	case len(missing) == 0:
+		m.printf("%s filesystems mounted, managing the disk\n", m.displayName(name))
+	case m.now.Sub(since) > m.config.Defaults.WaitMountTimeout:
+		m.printf("%s still missing mounts %v after %v, managing the disk anyway\n",
+			m.displayName(name), missing, m.config.Defaults.WaitMountTimeout)
+	default:
+		if m.debug(name) {
+			m.printf("disk=%s waiting for mounts %v\n", name, missing)
+		}
+		return true
+	}
+	m.mountsReady[name] = true
+	delete(m.mountWaitSince, name)
+	return false
+}
+
+func (m *Monitor) missingMounts(wanted []string) []string {
+	if !m.mountPointsAt.Equal(m.now) {
+		mountPoints, err := io.MountPoints()
+		if err != nil {
+			m.println(err.Error())
+		}
+		m.mountPoints = map[string]bool{}
+		for _, mountPoint := range mountPoints {
+			m.mountPoints[mountPoint] = true
+		}
+		m.mountPointsAt = m.now
+	}
+
+	var missing []string
+	for _, mountPoint := range wanted {
+		if !m.mountPoints[filepath.Clean(mountPoint)] {
+			missing = append(missing, mountPoint)
+		}
+	}
+	return missing
+}
+
+/* the mount points of the filesystems on the disk */
+func diskMountPoints(disk string) []string {
+	mountPoints, err := io.MountPoints()
+	if err != nil {
+		return nil
+	}
+	var onDisk []string
+	for _, mountPoint := range mountPoints {
+		disks, err := sysfs.DisksForPath(mountPoint)
+		if err == nil && contains(disks, disk) {
+			onDisk = append(onDisk, mountPoint)
+		}
+	}
+	return onDisk
+}
@@ -0,0 +1,73 @@
+// hd-idle - spin down idle hard disks
+// Copyright (C) 2018  Andoni del Olmo
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package hdidle
+
+import (
+	"io/ioutil"
+	"testing"
+	"time"
+)
+
+func TestWaitingForMounts(t *testing.T) {
+	config := NewConfig()
+	config.Devices = []DeviceConf{{Name: "sda", WaitMounts: []string{"/mnt/data/", "/mnt/backup"}}}
+	m := New(config)
+	m.SetOutput(ioutil.Discard)
+	setMountPoints(m, "/", "/mnt/data")
+
+	if !m.waitingForMounts("sda") {
+		t.Fatal("Expected sda to wait for /mnt/backup")
+	}
+	if m.waitingForMounts("sdb") {
+		t.Fatal("Expected sdb without mounts to be managed")
+	}
+
+	m.now = m.now.Add(time.Minute)
+	setMountPoints(m, "/", "/mnt/data", "/mnt/backup")
+	if m.waitingForMounts("sda") {
+		t.Fatal("Expected sda to be managed once mounted")
+	}
+	setMountPoints(m, "/")
+	if m.waitingForMounts("sda") {
+		t.Fatal("Expected sda to stay managed after an unmount")
+	}
+}
+
+func TestWaitingForMountsTimeout(t *testing.T) {
+	config := NewConfig()
+	config.Defaults.WaitMounts = []string{"/mnt/data"}
+	m := New(config)
+	m.SetOutput(ioutil.Discard)
+	setMountPoints(m, "/")
+
+	if !m.waitingForMounts("sda") {
+		t.Fatal("Expected sda to wait for /mnt/data")
+	}
+	m.now = m.now.Add(DefaultWaitMountTimeout + time.Second)
+	setMountPoints(m, "/")
+	if m.waitingForMounts("sda") {
+		t.Fatal("Expected sda to be managed after the timeout")
+	}
+}
+
+func setMountPoints(m *Monitor, mountPoints ...string) {
+	m.mountPoints = map[string]bool{}
+	for _, mountPoint := range mountPoints {
+		m.mountPoints[mountPoint] = true
+	}
+	m.mountPointsAt = m.now
+}
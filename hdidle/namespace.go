@@ -0,0 +1,85 @@
+// hd-idle - spin down idle hard disks
+// Copyright (C) 2018  Andoni del Olmo
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package hdidle
+
+import (
+	"regexp"
+	"time"
+)
+
+/*
+ * A namespace groups the disks of one service or user of a shared host,
+ * e.g. media or backups, so API clients can be limited to them.
+ */
+
+var namespaceName = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]*$`)
+
+// ValidNamespace tells whether the name can be given to a namespace.
+func ValidNamespace(name string) bool {
+	return namespaceName.MatchString(name)
+}
+
+// PauseNamespace stops spinning the disks of a namespace down for the given
+// time, like Pause does for all disks. It returns the end of the pause.
+func (m *Monitor) PauseNamespace(namespace string, d time.Duration) time.Time {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	until := time.Now().Add(d)
+	m.namespacePausedUntil[namespace] = until
+	m.printf("spindowns of namespace %s paused until %s\n", namespace, until.Format(dateFormat))
+	return until
+}
+
+// ResumeNamespace ends the pause of a namespace from the next monitoring
+// cycle on. A pause of all disks goes on.
+func (m *Monitor) ResumeNamespace(namespace string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, found := m.namespacePausedUntil[namespace]; found {
+		delete(m.namespacePausedUntil, namespace)
+		m.printf("spindowns of namespace %s resumed\n", namespace)
+	}
+}
+
+// NamespacePausedUntil returns the end of the pause of the disks of a
+// namespace, either their own or of all disks, the zero time if their
+// spindowns aren't paused.
+func (m *Monitor) NamespacePausedUntil(namespace string) time.Time {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	until := m.pausedUntil
+	if own := m.namespacePausedUntil[namespace]; own.After(until) {
+		until = own
+	}
+	if !time.Now().Before(until) {
+		return time.Time{}
+	}
+	return until
+}
+
+func (m *Monitor) namespacePaused(disk string) bool {
+	namespace := m.config.deviceConfig(disk).Namespace
+	if len(namespace) == 0 {
+		return false
+	}
+	until, found := m.namespacePausedUntil[namespace]
+	if found && !m.now.Before(until) {
+		delete(m.namespacePausedUntil, namespace)
+		return false
+	}
+	return found
+}
@@ -0,0 +1,100 @@
+// hd-idle - spin down idle hard disks
+// Copyright (C) 2018  Andoni del Olmo
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package hdidle
+
+import (
+	"github.com/adelolmo/hd-idle/sysfs"
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const (
+	// PassthroughLeave never manages a disk again once a virtual machine opened it.
+	PassthroughLeave = "leave"
+	// PassthroughShutoff manages a disk only while no virtual machine has it open.
+	PassthroughShutoff = "shutoff"
+)
+
+/*
+ * A disk passed through to a virtual machine belongs to the guest, which
+ * spins it down itself. Spinning it down from the host under a running
+ * guest ends in I/O errors inside the virtual machine.
+ */
+func (m *Monitor) passedThrough(name string) bool {
+	policy := m.config.deviceConfig(name).Passthrough
+	if len(policy) == 0 {
+		return false
+	}
+	if policy == PassthroughLeave && m.vmDisks[name] {
+		return true
+	}
+
+	pid := m.vmOpener(name)
+	switch {
+	case pid > 0 && !m.vmDisks[name]:
+		m.printf("%s passed through to a virtual machine (pid %d), leaving it alone\n", m.displayName(name), pid)
+		m.vmDisks[name] = true
+	case pid == 0 && m.vmDisks[name]:
+		m.printf("%s virtual machine shut off, managing the disk\n", m.displayName(name))
+		delete(m.vmDisks, name)
+	}
+	return m.vmDisks[name]
+}
+
+/* the pid of a virtual machine having the disk, a partition or a volume on it open, 0 if none */
+func (m *Monitor) vmOpener(name string) int {
+	if !m.vmOpenersAt.Equal(m.now) {
+		m.vmOpeners = vmOpeners()
+		m.vmOpenersAt = m.now
+	}
+	devices, err := sysfs.DevicesOn(name)
+	if err != nil {
+		devices = []string{name}
+	}
+	for _, device := range devices {
+		if pid := m.vmOpeners[device]; pid > 0 {
+			return pid
+		}
+	}
+	return 0
+}
+
+/* block devices open by qemu processes, e.g. sdb opened by pid 1234 */
+func vmOpeners() map[string]int {
+	openers := map[string]int{}
+	dirs, err := ioutil.ReadDir(procRoot)
+	if err != nil {
+		return openers
+	}
+	for _, dir := range dirs {
+		pid, err := strconv.Atoi(dir.Name())
+		if err != nil {
+			continue
+		}
+		comm, err := ioutil.ReadFile(filepath.Join(procRoot, dir.Name(), "comm"))
+		if err != nil || !strings.HasPrefix(string(comm), "qemu") {
+			continue
+		}
+		devices, _ := openFiles(dir.Name())
+		for _, device := range devices {
+			openers[device] = pid
+		}
+	}
+	return openers
+}
@@ -0,0 +1,93 @@
+// hd-idle - spin down idle hard disks
+// Copyright (C) 2018  Andoni del Olmo
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package hdidle
+
+import (
+	"github.com/adelolmo/hd-idle/diskstats"
+	"github.com/adelolmo/hd-idle/sysfs"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestPassthrough(t *testing.T) {
+	for _, test := range []struct {
+		policy  string
+		managed bool // after the virtual machine shut off
+	}{
+		{PassthroughShutoff, true},
+		{PassthroughLeave, false},
+	} {
+		dir, err := ioutil.TempDir("", "proc")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+		mustMkdir(t, filepath.Join(dir, "4321", "fd"))
+		if err := ioutil.WriteFile(filepath.Join(dir, "4321", "comm"), []byte("qemu-system-x86\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		fd := filepath.Join(dir, "4321", "fd", "17")
+		if err := os.Symlink("/dev/sdb", fd); err != nil {
+			t.Fatal(err)
+		}
+		procRoot = dir
+		defer func() { procRoot = "/proc" }()
+		root := sysfs.Root
+		sysfs.Root = dir
+		defer func() { sysfs.Root = root }()
+
+		simulation, err := ParseSimulation("dry-run")
+		if err != nil {
+			t.Fatal(err)
+		}
+		config := NewConfig()
+		config.Defaults.Simulation = simulation
+		config.SkewTime = 24 * time.Hour
+		config.Devices = []DeviceConf{{Name: "sdb", Idle: time.Minute, CommandType: SCSI, Passthrough: test.policy}}
+		m := New(config)
+		m.SetOutput(ioutil.Discard)
+
+		start := time.Now()
+		cycle := func(offset time.Duration) {
+			m.now = start.Add(offset)
+			m.updateState(diskstats.DiskStats{Name: "sdb"})
+			m.lastNow = m.now
+		}
+		cycle(0)
+		cycle(5 * time.Minute)
+		cycle(10 * time.Minute)
+		if m.snapshots[0].SpunDown {
+			t.Fatalf("%s: Expected sdb left alone while the virtual machine runs", test.policy)
+		}
+
+		/* the virtual machine shuts off */
+		if err := os.Remove(fd); err != nil {
+			t.Fatal(err)
+		}
+		cycle(10*time.Minute + 30*time.Second)
+		if m.snapshots[0].SpunDown {
+			t.Fatalf("%s: Expected the idle time to start when the virtual machine shut off", test.policy)
+		}
+		cycle(15 * time.Minute)
+		if m.snapshots[0].SpunDown != test.managed {
+			t.Fatalf("%s: Expected spun down %t but found %t", test.policy, test.managed, m.snapshots[0].SpunDown)
+		}
+	}
+}
@@ -0,0 +1,220 @@
+// hd-idle - spin down idle hard disks
+// Copyright (C) 2018  Andoni del Olmo
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package hdidle
+
+import (
+	"fmt"
+	"github.com/adelolmo/hd-idle/io"
+	"path"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// RegexPrefix makes a disk name given with -a a regular expression matching
+// kernel names, e.g. re:^sd[c-j]$. Names with *, ? or [ are glob patterns,
+// e.g. sd[c-j].
+const RegexPrefix = "re:"
+
+// UdevPrefix makes a disk name given with -a a list of udev properties the
+// disks must have, e.g. udev:ID_MODEL=WDC_WD80EFAX*,ID_BUS=ata. The values
+// are glob patterns.
+const UdevPrefix = "udev:"
+
+/* replaced in tests */
+var udevProperties = io.UdevProperties
+
+/*
+ * The udev properties of the disks, read once per cycle: deviceConfig ranks
+ * the udev patterns every time the configuration of a disk is looked up.
+ */
+var udevCache = struct {
+	sync.Mutex
+	disks map[string]udevEntry
+}{disks: map[string]udevEntry{}}
+
+type udevEntry struct {
+	properties map[string]string
+	err        error
+}
+
+func cachedUdevProperties(disk string) (map[string]string, error) {
+	udevCache.Lock()
+	defer udevCache.Unlock()
+	entry, found := udevCache.disks[disk]
+	if !found {
+		entry.properties, entry.err = udevProperties(disk)
+		udevCache.disks[disk] = entry
+	}
+	return entry.properties, entry.err
+}
+
+/* read the udev properties again, e.g. of a disk that was plugged in */
+func forgetUdevProperties() {
+	udevCache.Lock()
+	defer udevCache.Unlock()
+	udevCache.disks = map[string]udevEntry{}
+}
+
+/*
+ * The regular expressions and udev selectors of the patterns, compiled when
+ * the pattern is checked and looked up on every match.
+ */
+var compiledPatterns = struct {
+	sync.Mutex
+	regexps   map[string]*regexp.Regexp
+	selectors map[string]map[string]string
+}{regexps: map[string]*regexp.Regexp{}, selectors: map[string]map[string]string{}}
+
+/* the regular expression of re:..., compiled once */
+func patternRegexp(name string) (*regexp.Regexp, error) {
+	compiledPatterns.Lock()
+	defer compiledPatterns.Unlock()
+	if re, found := compiledPatterns.regexps[name]; found {
+		return re, nil
+	}
+	re, err := regexp.Compile(strings.TrimPrefix(name, RegexPrefix))
+	if err != nil {
+		return nil, err
+	}
+	compiledPatterns.regexps[name] = re
+	return re, nil
+}
+
+/* the properties of udev:..., parsed once */
+func udevSelector(name string) (map[string]string, error) {
+	compiledPatterns.Lock()
+	defer compiledPatterns.Unlock()
+	if selector, found := compiledPatterns.selectors[name]; found {
+		return selector, nil
+	}
+	selector, err := parseUdevSelector(name)
+	if err != nil {
+		return nil, err
+	}
+	compiledPatterns.selectors[name] = selector
+	return selector, nil
+}
+
+// IsDevicePattern tells whether a disk name given with -a is a glob, regular
+// expression or udev properties for several disks.
+func IsDevicePattern(name string) bool {
+	return strings.HasPrefix(name, RegexPrefix) || strings.HasPrefix(name, UdevPrefix) || strings.ContainsAny(name, "*?[")
+}
+
+// ValidDevicePattern returns the error of a malformed glob or regular
+// expression.
+func ValidDevicePattern(name string) error {
+	if strings.HasPrefix(name, RegexPrefix) {
+		_, err := patternRegexp(name)
+		return err
+	}
+	if strings.HasPrefix(name, UdevPrefix) {
+		_, err := udevSelector(name)
+		return err
+	}
+	if _, err := path.Match(name, ""); err != nil {
+		return fmt.Errorf("malformed glob pattern %s", name)
+	}
+	return nil
+}
+
+/*
+ * How specific a pattern matching the disk is, -1 if it doesn't match. Globs
+ * beat regular expressions, which are hard to compare, and among globs the
+ * one with more literal characters wins, e.g. sdc* over sd*. Udev properties
+ * beat both, as they follow the drive whatever its kernel name: the more
+ * properties, then the more literal characters in their values, the better.
+ */
+func (dc *DeviceConf) patternRank(disk string) int {
+	if strings.HasPrefix(dc.Name, UdevPrefix) {
+		return udevRank(dc.Name, disk)
+	}
+	if strings.HasPrefix(dc.Name, RegexPrefix) {
+		re, err := patternRegexp(dc.Name)
+		if err != nil || !re.MatchString(disk) {
+			return -1
+		}
+		return 0
+	}
+	if !strings.ContainsAny(dc.Name, "*?[") {
+		return -1
+	}
+	if matched, err := path.Match(dc.Name, disk); err != nil || !matched {
+		return -1
+	}
+	return 1 + globLiterals(dc.Name)
+}
+
+/* characters of a glob matching only themselves */
+func globLiterals(pattern string) int {
+	literals := 0
+	for i := 0; i < len(pattern); i++ {
+		switch pattern[i] {
+		case '*', '?':
+		case '[':
+			for i < len(pattern) && pattern[i] != ']' {
+				i++
+			}
+		case '\\':
+			i++
+			literals++
+		default:
+			literals++
+		}
+	}
+	return literals
+}
+
+/* the properties of udev:KEY=VALUE,KEY=VALUE */
+func parseUdevSelector(name string) (map[string]string, error) {
+	selector := map[string]string{}
+	for _, property := range strings.Split(strings.TrimPrefix(name, UdevPrefix), ",") {
+		i := strings.Index(property, "=")
+		if i < 1 {
+			return nil, fmt.Errorf("expected KEY=VALUE but found %q", property)
+		}
+		if _, err := path.Match(property[i+1:], ""); err != nil {
+			return nil, fmt.Errorf("malformed glob pattern %s", property[i+1:])
+		}
+		selector[property[:i]] = property[i+1:]
+	}
+	return selector, nil
+}
+
+func udevRank(name, disk string) int {
+	selector, err := udevSelector(name)
+	if err != nil {
+		return -1
+	}
+	properties, err := cachedUdevProperties(disk)
+	if err != nil {
+		return -1
+	}
+	literals := 0
+	for key, pattern := range selector {
+		value, found := properties[key]
+		if !found {
+			return -1
+		}
+		if matched, err := path.Match(pattern, value); err != nil || !matched {
+			return -1
+		}
+		literals += globLiterals(pattern)
+	}
+	return 1000*len(selector) + literals
+}
@@ -0,0 +1,153 @@
+// hd-idle - spin down idle hard disks
+// Copyright (C) 2018  Andoni del Olmo
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package hdidle
+
+import (
+	"fmt"
+	"github.com/adelolmo/hd-idle/io"
+	"testing"
+	"time"
+)
+
+func TestDeviceConfigPatterns(t *testing.T) {
+	config := NewConfig()
+	config.Devices = []DeviceConf{
+		{Name: "re:^sd[a-z]$", Idle: 1 * time.Minute},
+		{Name: "sd[c-j]", Idle: 2 * time.Minute},
+		{Name: "sdc*", Idle: 3 * time.Minute},
+		{Name: "sdd", Idle: 4 * time.Minute},
+	}
+
+	tests := []struct {
+		disk string
+		want time.Duration
+	}{
+		{"sdd", 4 * time.Minute},     // the name beats any pattern
+		{"sdc", 3 * time.Minute},     // more literal characters
+		{"sde", 2 * time.Minute},     // globs beat regular expressions
+		{"sdk", 1 * time.Minute},     // only the regular expression matches
+		{"nvme0n1", DefaultIdleTime}, // nothing matches
+	}
+	for _, tt := range tests {
+		if idle := config.deviceConfig(tt.disk).Idle; idle != tt.want {
+			t.Errorf("Expected idle %v for %s but found %v", tt.want, tt.disk, idle)
+		}
+	}
+}
+
+func TestDeviceConfigUdevProperties(t *testing.T) {
+	udevProperties = func(disk string) (map[string]string, error) {
+		switch disk {
+		case "sdb":
+			return map[string]string{"ID_BUS": "ata", "ID_MODEL": "WDC_WD80EFAX-68KNBN0"}, nil
+		case "sdc":
+			return map[string]string{"ID_BUS": "usb", "ID_MODEL": "WDC_WD80EFAX-68KNBN0"}, nil
+		case "sdd":
+			return map[string]string{"ID_BUS": "ata", "ID_MODEL": "ST8000VN004-2M2101"}, nil
+		}
+		return nil, fmt.Errorf("no udev data for %s", disk)
+	}
+	forgetUdevProperties()
+	defer func() {
+		udevProperties = io.UdevProperties
+		forgetUdevProperties()
+	}()
+
+	config := NewConfig()
+	config.Devices = []DeviceConf{
+		{Name: "sd*", Idle: 1 * time.Minute},
+		{Name: "udev:ID_MODEL=WDC_WD80EFAX*", Idle: 30 * time.Minute},
+		{Name: "udev:ID_MODEL=WDC_WD80EFAX*,ID_BUS=usb", Idle: 5 * time.Minute},
+	}
+	tests := []struct {
+		disk string
+		want time.Duration
+	}{
+		{"sdb", 30 * time.Minute},    // udev properties beat kernel name patterns
+		{"sdc", 5 * time.Minute},     // more properties
+		{"sdd", 1 * time.Minute},     // the model doesn't match
+		{"nvme0n1", DefaultIdleTime}, // no udev data
+	}
+	for _, tt := range tests {
+		if idle := config.deviceConfig(tt.disk).Idle; idle != tt.want {
+			t.Errorf("Expected idle %v for %s but found %v", tt.want, tt.disk, idle)
+		}
+	}
+}
+
+func TestValidDevicePattern(t *testing.T) {
+	for _, name := range []string{"sd[c-j]", "sd*", "re:^sd[c-j]$", "udev:ID_MODEL=WDC_WD80EFAX*,ID_BUS=ata"} {
+		if !IsDevicePattern(name) || ValidDevicePattern(name) != nil {
+			t.Errorf("Expected %s to be a valid pattern", name)
+		}
+	}
+	for _, name := range []string{"sd[c-", "re:sd(", "udev:ID_MODEL", "udev:ID_MODEL=WD[8"} {
+		if ValidDevicePattern(name) == nil {
+			t.Errorf("Expected %s to be refused", name)
+		}
+	}
+	if IsDevicePattern("/dev/disk/by-id/wwn-0x5000c500a1b2c3d4") {
+		t.Error("Expected a symlink not to be a pattern")
+	}
+}
+
+func TestUdevPropertiesReadOncePerCycle(t *testing.T) {
+	reads := 0
+	udevProperties = func(disk string) (map[string]string, error) {
+		reads++
+		return map[string]string{"ID_BUS": "ata"}, nil
+	}
+	forgetUdevProperties()
+	defer func() {
+		udevProperties = io.UdevProperties
+		forgetUdevProperties()
+	}()
+
+	config := NewConfig()
+	config.Devices = []DeviceConf{{Name: "udev:ID_BUS=ata", Idle: 30 * time.Minute}}
+	for i := 0; i < 10; i++ {
+		if idle := config.deviceConfig("sdb").Idle; idle != 30*time.Minute {
+			t.Fatalf("Expected idle 30m but found %v", idle)
+		}
+	}
+	if reads != 1 {
+		t.Fatalf("Expected the udev properties read once but found %d reads", reads)
+	}
+	forgetUdevProperties()
+	config.deviceConfig("sdb")
+	if reads != 2 {
+		t.Fatalf("Expected the udev properties read again in the next cycle but found %d reads", reads)
+	}
+}
+
+func TestPatternsCompiledOnce(t *testing.T) {
+	if err := ValidDevicePattern("re:^sd[b-c]$"); err != nil {
+		t.Fatal(err)
+	}
+	first, _ := patternRegexp("re:^sd[b-c]$")
+	config := NewConfig()
+	config.Devices = []DeviceConf{{Name: "re:^sd[b-c]$", Idle: 30 * time.Minute}}
+	if idle := config.deviceConfig("sdb").Idle; idle != 30*time.Minute {
+		t.Fatalf("Expected idle 30m but found %v", idle)
+	}
+	if again, _ := patternRegexp("re:^sd[b-c]$"); again != first {
+		t.Fatal("Expected the regular expression compiled once")
+	}
+	if _, err := patternRegexp("re:sd("); err == nil {
+		t.Fatal("Expected a malformed regular expression to be refused")
+	}
+}
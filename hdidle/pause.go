@@ -0,0 +1,84 @@
+// hd-idle - spin down idle hard disks
+// Copyright (C) 2018  Andoni del Olmo
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package hdidle
+
+import (
+	"fmt"
+	"time"
+)
+
+// Pause stops spinning disks down for the given time, e.g. during
+// maintenance. Spindowns resume on their own once it is over, or earlier with
+// Resume. Pausing again replaces the end of the pause. It returns the end of
+// the pause.
+func (m *Monitor) Pause(d time.Duration) time.Time {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.pausedUntil = time.Now().Add(d)
+	m.pauseAnnounced = false
+	return m.pausedUntil
+}
+
+// Resume ends a pause from the next monitoring cycle on.
+func (m *Monitor) Resume() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if !m.pausedUntil.IsZero() {
+		m.pausedUntil = time.Now()
+	}
+}
+
+// PausedUntil returns the end of the current pause, the zero time if
+// spindowns aren't paused.
+func (m *Monitor) PausedUntil() time.Time {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if !time.Now().Before(m.pausedUntil) {
+		return time.Time{}
+	}
+	return m.pausedUntil
+}
+
+func (m *Monitor) paused() bool {
+	return m.now.Before(m.pausedUntil)
+}
+
+/* tell when a pause starts and ends, once per monitoring cycle at most */
+func (m *Monitor) updatePause() {
+	if m.pausedUntil.IsZero() {
+		return
+	}
+	if !m.paused() {
+		if m.pauseAnnounced {
+			m.announcePause(EventResumed, "spindowns resumed")
+		}
+		m.pausedUntil = time.Time{}
+		m.pauseAnnounced = false
+		return
+	}
+	if !m.pauseAnnounced {
+		m.announcePause(EventPaused, "spindowns paused until "+m.pausedUntil.Format(dateFormat))
+		m.pauseAnnounced = true
+	}
+}
+
+func (m *Monitor) announcePause(eventType EventType, message string) {
+	m.println(message)
+	m.emit(eventType, "", message)
+	m.logToFile(m.config.Defaults.LogFile, fmt.Sprintf("date: %s, time: %s, %s",
+		m.now.Format("2006-01-02"), m.now.Format("15:04:05"), message))
+}
@@ -0,0 +1,116 @@
+// hd-idle - spin down idle hard disks
+// Copyright (C) 2018  Andoni del Olmo
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package hdidle
+
+import (
+	"github.com/adelolmo/hd-idle/diskstats"
+	"io/ioutil"
+	"testing"
+	"time"
+)
+
+func TestPauseExpires(t *testing.T) {
+	simulation, err := ParseSimulation("dry-run")
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := NewConfig()
+	config.Defaults.Simulation = simulation
+	config.SkewTime = 24 * time.Hour
+	m := New(config)
+	m.SetOutput(ioutil.Discard)
+	events, cancel := m.Subscribe("")
+	defer cancel()
+
+	start := time.Now()
+	m.snapshots = []diskstats.DiskStats{{Name: "sda", CommandType: SCSI, IdleTime: time.Minute, LastIoAt: start.Add(-time.Hour)}}
+	until := m.Pause(2 * time.Hour)
+	if !m.PausedUntil().Equal(until) {
+		t.Fatalf("Expected paused until %s but found %s", until, m.PausedUntil())
+	}
+
+	cycle := func(now time.Time) {
+		m.now = now
+		m.updatePause()
+		m.updateState(diskstats.DiskStats{Name: "sda"})
+		m.lastNow = now
+	}
+	cycle(start.Add(time.Minute))
+	if event := <-events; event.Type != EventPaused {
+		t.Fatalf("Expected %s but found %s", EventPaused, event.Type)
+	}
+	if m.snapshots[0].SpunDown {
+		t.Fatal("Expected no spindown while paused")
+	}
+
+	cycle(until.Add(time.Minute))
+	if event := <-events; event.Type != EventResumed {
+		t.Fatalf("Expected %s but found %s", EventResumed, event.Type)
+	}
+	if !m.snapshots[0].SpunDown {
+		t.Fatal("Expected a spindown once the pause expired")
+	}
+	if !m.PausedUntil().IsZero() {
+		t.Fatal("Expected no pause left")
+	}
+}
+
+func TestPauseNamespace(t *testing.T) {
+	simulation, err := ParseSimulation("dry-run")
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := NewConfig()
+	config.Defaults.Simulation = simulation
+	config.SkewTime = 24 * time.Hour
+	config.Devices = []DeviceConf{
+		{Name: "sda", GivenName: "sda", Idle: time.Minute, CommandType: SCSI, Namespace: "media"},
+		{Name: "sdb", GivenName: "sdb", Idle: time.Minute, CommandType: SCSI, Namespace: "backups"},
+	}
+	m := New(config)
+	m.SetOutput(ioutil.Discard)
+
+	start := time.Now()
+	m.snapshots = []diskstats.DiskStats{
+		{Name: "sda", CommandType: SCSI, IdleTime: time.Minute, LastIoAt: start.Add(-time.Hour)},
+		{Name: "sdb", CommandType: SCSI, IdleTime: time.Minute, LastIoAt: start.Add(-time.Hour)},
+	}
+	until := m.PauseNamespace("media", 2*time.Hour)
+	if !m.NamespacePausedUntil("media").Equal(until) || !m.PausedUntil().IsZero() {
+		t.Fatal("Expected only the media namespace paused")
+	}
+	if !m.NamespacePausedUntil("backups").IsZero() {
+		t.Fatal("Expected the backups namespace not paused")
+	}
+
+	cycle := func(now time.Time) {
+		m.now = now
+		m.updateState(diskstats.DiskStats{Name: "sda"})
+		m.updateState(diskstats.DiskStats{Name: "sdb"})
+		m.lastNow = now
+	}
+	cycle(start.Add(time.Minute))
+	if m.snapshots[0].SpunDown || !m.snapshots[1].SpunDown {
+		t.Fatal("Expected only the disk of the paused namespace left spinning")
+	}
+
+	m.ResumeNamespace("media")
+	cycle(start.Add(2 * time.Minute))
+	if !m.snapshots[0].SpunDown {
+		t.Fatal("Expected a spindown once the namespace resumed")
+	}
+}
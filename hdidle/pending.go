@@ -0,0 +1,153 @@
+// hd-idle - spin down idle hard disks
+// Copyright (C) 2018  Andoni del Olmo
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package hdidle
+
+import (
+	"fmt"
+	"github.com/adelolmo/hd-idle/io"
+	"sort"
+	"strings"
+	"time"
+)
+
+// PendingDevice is a configured disk that is not plugged in, e.g. a backup
+// disk in a dock or away offsite.
+type PendingDevice struct {
+	Name      string // as given with -a
+	Alias     string
+	Namespace string
+	Tags      map[string]string
+	Since     time.Time
+}
+
+/*
+ * Resolve the configured disks that are not plugged in. They are pending
+ * without complaints until their name resolves, so disks that come and go
+ * are no error. With -s 2 the resolved ones are resolved again, see
+ * followSymlink.
+ */
+func (m *Monitor) resolveSymlinks() {
+	config := m.config
+	for i := range config.Devices {
+		if config.Devices[i].SymlinkPolicy == SymlinkResolveContinuous {
+			m.followSymlink(i)
+		}
+		device := config.Devices[i]
+		if len(device.Name) > 0 {
+			continue
+		}
+		realPath, err := io.RealPath(device.GivenName)
+		if err != nil {
+			if _, found := m.pendingSince[device.GivenName]; !found {
+				m.pendingSince[device.GivenName] = m.now
+				m.printf("Disk %s is not plugged in, waiting for it\n", device.GivenName)
+			}
+			continue
+		}
+		config.Devices[i].Name = realPath
+		delete(m.pendingSince, device.GivenName)
+		message := fmt.Sprintf("symlink %s resolved to %s", device.GivenName, realPath)
+		m.logToFile(m.logFileOf(realPath), message)
+		m.emit(EventDiskPlugged, realPath, message)
+	}
+}
+
+/*
+ * With -s 2 a symlink is resolved every cycle, since a disk unplugged and
+ * plugged in again between two cycles may come back as another sdX, while
+ * another disk takes its old name. The configuration then follows the link,
+ * and both disks start over with the configuration they now get. A link gone
+ * makes the disk pending.
+ */
+func (m *Monitor) followSymlink(i int) {
+	device := m.config.Devices[i]
+	if len(device.Name) == 0 || device.GivenName == device.Name || IsDevicePattern(device.GivenName) {
+		return
+	}
+	realPath, err := io.RealPath(device.GivenName)
+	if err != nil {
+		m.config.Devices[i].Name = ""
+		m.forgetSnapshot(device.Name)
+		return
+	}
+	if realPath == device.Name {
+		return
+	}
+	m.config.Devices[i].Name = realPath
+	m.forgetSnapshot(device.Name)
+	m.forgetSnapshot(realPath)
+	message := fmt.Sprintf("symlink %s now resolves to %s instead of %s", device.GivenName, realPath, device.Name)
+	m.logToFile(m.logFileOf(realPath), message)
+	m.emit(EventDiskPlugged, realPath, message)
+}
+
+/* the disk is initialised again in its next cycle */
+func (m *Monitor) forgetSnapshot(disk string) {
+	if dsi := m.previousDiskStatsIndex(disk); dsi >= 0 {
+		m.snapshots = append(m.snapshots[:dsi], m.snapshots[dsi+1:]...)
+	}
+}
+
+/*
+ * With -s 1 or 2, a disk configured by a symlink is pending again once
+ * unplugged, so it gets its configuration under whatever name it comes back
+ * with. Disks configured by serial number or WWN always are, the drive is
+ * what they name, and so are those configured by a by-path or by-partlabel
+ * link, another disk may take the port or carry the partition.
+ */
+func (m *Monitor) unplugDevice(disk string) {
+	for i := range m.config.Devices {
+		device := m.config.Devices[i]
+		once := device.SymlinkPolicy == SymlinkResolveOnce || device.SymlinkPolicy == SymlinkResolveRequired
+		if once && !io.DriveName(device.GivenName) && !io.HotplugName(device.GivenName) {
+			continue
+		}
+		if device.Name == disk && device.GivenName != disk && len(device.GivenName) > 0 {
+			m.config.Devices[i].Name = ""
+		}
+	}
+}
+
+// MissingDisks returns the error naming the disks with -s 3 that are not
+// plugged in, nil if all of them are.
+func (c *Config) MissingDisks() error {
+	var missing []string
+	for _, device := range c.Devices {
+		if device.SymlinkPolicy == SymlinkResolveRequired && len(device.Name) == 0 && !IsDevicePattern(device.GivenName) {
+			missing = append(missing, device.GivenName)
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("disk %s is not plugged in, required by -s %d", strings.Join(missing, ", "), SymlinkResolveRequired)
+	}
+	return nil
+}
+
+// Pending returns the configured disks that are not plugged in, by name.
+func (m *Monitor) Pending() []PendingDevice {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var pending []PendingDevice
+	for _, device := range m.config.Devices {
+		if since, found := m.pendingSince[device.GivenName]; found && len(device.Name) == 0 {
+			pending = append(pending, PendingDevice{Name: device.GivenName, Alias: device.Alias, Namespace: device.Namespace, Tags: device.Tags, Since: since})
+		}
+	}
+	sort.Slice(pending, func(i, j int) bool { return pending[i].Name < pending[j].Name })
+	return pending
+}
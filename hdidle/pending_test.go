@@ -0,0 +1,183 @@
+// hd-idle - spin down idle hard disks
+// Copyright (C) 2018  Andoni del Olmo
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package hdidle
+
+import (
+	"github.com/adelolmo/hd-idle/diskstats"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestPendingUntilPlugged(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pending")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	byLabel := filepath.Join(dir, "by-label")
+	mustMkdir(t, byLabel)
+	link := filepath.Join(byLabel, "offsite")
+
+	config := NewConfig()
+	config.Devices = []DeviceConf{{GivenName: link, Alias: "offsite", SymlinkPolicy: SymlinkResolveRetry}}
+	m := New(config)
+	m.SetOutput(ioutil.Discard)
+	events, cancel := m.Subscribe("")
+	defer cancel()
+
+	m.now = time.Now()
+	m.resolveSymlinks()
+	pending := m.Pending()
+	if len(pending) != 1 || pending[0].Name != link || pending[0].Alias != "offsite" || !pending[0].Since.Equal(m.now) {
+		t.Fatalf("Expected the disk pending but found %+v", pending)
+	}
+	since := m.now
+	m.now = since.Add(time.Minute)
+	m.resolveSymlinks()
+	if pending := m.Pending(); len(pending) != 1 || !pending[0].Since.Equal(since) {
+		t.Fatalf("Expected the disk pending since the first cycle but found %+v", pending)
+	}
+
+	if err := os.Symlink("../../sdc", link); err != nil {
+		t.Fatal(err)
+	}
+	m.resolveSymlinks()
+	if config.Devices[0].Name != "sdc" || len(m.Pending()) != 0 {
+		t.Fatalf("Expected the disk resolved to sdc but found %+v", config.Devices[0])
+	}
+	if event := <-events; event.Type != EventDiskPlugged || event.Disk != "sdc" {
+		t.Fatalf("Expected disk_plugged for sdc but found %+v", event)
+	}
+
+	m.unplugDevice("sdc")
+	if len(config.Devices[0].Name) != 0 {
+		t.Fatalf("Expected the disk pending again once unplugged but found %+v", config.Devices[0])
+	}
+}
+
+func TestUnplugDriveName(t *testing.T) {
+	config := NewConfig()
+	config.Devices = []DeviceConf{
+		{Name: "sdc", GivenName: "wwn-0x5000c500a1b2c3d4"},
+		{Name: "sdd", GivenName: "/dev/disk/by-label/offsite"},
+		{Name: "sde", GivenName: "/dev/disk/by-path/pci-0000:00:1f.2-ata-2"},
+		{Name: "sdf", GivenName: "/dev/disk/by-partlabel/backup"},
+	}
+	m := New(config)
+	m.SetOutput(ioutil.Discard)
+
+	for _, disk := range []string{"sdc", "sdd", "sde", "sdf"} {
+		m.unplugDevice(disk)
+	}
+	if len(config.Devices[0].Name) != 0 {
+		t.Fatalf("Expected the drive pending again once unplugged but found %+v", config.Devices[0])
+	}
+	if config.Devices[1].Name != "sdd" {
+		t.Fatalf("Expected the symlink kept without -s 1 but found %+v", config.Devices[1])
+	}
+	if len(config.Devices[2].Name) != 0 || len(config.Devices[3].Name) != 0 {
+		t.Fatalf("Expected the port and the partition label pending again once unplugged but found %+v", config.Devices[2:])
+	}
+}
+
+func TestFollowSymlink(t *testing.T) {
+	dir, err := ioutil.TempDir("", "follow")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	byLabel := filepath.Join(dir, "by-label")
+	mustMkdir(t, byLabel)
+	link := filepath.Join(byLabel, "archive")
+	if err := os.Symlink("../../sdc", link); err != nil {
+		t.Fatal(err)
+	}
+
+	config := NewConfig()
+	config.Defaults.Idle = 10 * time.Minute
+	config.Devices = []DeviceConf{{Name: "sdc", GivenName: link, Idle: time.Hour, SymlinkPolicy: SymlinkResolveContinuous}}
+	m := New(config)
+	m.SetOutput(ioutil.Discard)
+	events, cancel := m.Subscribe("")
+	defer cancel()
+
+	m.now = time.Now()
+	cycle := func() {
+		m.resolveSymlinks()
+		for _, disk := range []string{"sdc", "sdd"} {
+			m.updateState(diskstats.DiskStats{Name: disk})
+		}
+	}
+	idleTimes := func() map[string]time.Duration {
+		idle := map[string]time.Duration{}
+		for _, ds := range m.snapshots {
+			idle[ds.Name] = ds.IdleTime
+		}
+		return idle
+	}
+	cycle()
+	if idle := idleTimes(); idle["sdc"] != time.Hour || idle["sdd"] != 10*time.Minute {
+		t.Fatalf("Expected the hour for sdc but found %v", idle)
+	}
+
+	/* plugged in again as sdd, while another disk took sdc */
+	if err := os.Remove(link); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("../../sdd", link); err != nil {
+		t.Fatal(err)
+	}
+	cycle()
+	if config.Devices[0].Name != "sdd" {
+		t.Fatalf("Expected the configuration to follow the link to sdd but found %+v", config.Devices[0])
+	}
+	if idle := idleTimes(); idle["sdd"] != time.Hour || idle["sdc"] != 10*time.Minute {
+		t.Fatalf("Expected the hour for sdd now but found %v", idle)
+	}
+	if event := <-events; event.Type != EventDiskPlugged || event.Disk != "sdd" {
+		t.Fatalf("Expected disk_plugged for sdd but found %+v", event)
+	}
+
+	if err := os.Remove(link); err != nil {
+		t.Fatal(err)
+	}
+	cycle()
+	if pending := m.Pending(); len(pending) != 1 || len(config.Devices[0].Name) != 0 {
+		t.Fatalf("Expected the disk pending once its link is gone but found %+v", pending)
+	}
+}
+
+func TestMissingRequiredDisks(t *testing.T) {
+	config := NewConfig()
+	config.Devices = []DeviceConf{
+		{Name: "sdb", GivenName: "/dev/disk/by-id/ata-present", SymlinkPolicy: SymlinkResolveRequired},
+		{GivenName: "/dev/disk/by-id/ata-retried", SymlinkPolicy: SymlinkResolveRetry},
+		{GivenName: "sd[x-z]", SymlinkPolicy: SymlinkResolveRequired},
+	}
+	if err := config.MissingDisks(); err != nil {
+		t.Fatalf("Expected no missing disk but found %s", err)
+	}
+
+	config.Devices = append(config.Devices, DeviceConf{GivenName: "/dev/disk/by-id/ata-missing", SymlinkPolicy: SymlinkResolveRequired})
+	err := config.MissingDisks()
+	if err == nil || err.Error() != "disk /dev/disk/by-id/ata-missing is not plugged in, required by -s 3" {
+		t.Fatalf("Expected the missing disk but found %v", err)
+	}
+}
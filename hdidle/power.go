@@ -0,0 +1,62 @@
+// hd-idle - spin down idle hard disks
+// Copyright (C) 2018  Andoni del Olmo
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package hdidle
+
+import (
+	"fmt"
+	"github.com/adelolmo/hd-idle/sysfs"
+	"time"
+)
+
+/* replaced in tests */
+var batteryPowered = sysfs.OnBattery
+
+/*
+ * Disks with a --battery-idle get it instead of their idle time while the
+ * machine runs on battery, e.g. a laptop or a portable NAS spinning its disks
+ * down early until it is plugged in again. The power source is read every
+ * cycle and the idle times switch as it changes.
+ */
+func (m *Monitor) updatePowerSource() {
+	if !m.config.batteryIdles() {
+		m.onBattery = false
+		return
+	}
+	onBattery := batteryPowered()
+	if onBattery == m.onBattery {
+		return
+	}
+	m.onBattery = onBattery
+	message := "on mains power, idle times restored"
+	if onBattery {
+		message = "on battery, battery idle times in effect"
+	}
+	m.println(message)
+	m.logToFile(m.config.Defaults.LogFile, fmt.Sprintf("date: %s, time: %s, %s",
+		m.now.Format("2006-01-02"), m.now.Format("15:04:05"), message))
+	for i := range m.snapshots {
+		m.snapshots[i].IdleTime = m.idleTime(m.snapshots[i].Name, m.config.deviceConfig(m.snapshots[i].Name))
+	}
+}
+
+/* the idle time of the disk on the current power source, the battery idle time wins over a profile */
+func (m *Monitor) sourceIdle(device *DeviceConf) time.Duration {
+	if m.onBattery && device.BatteryIdle != 0 {
+		return device.BatteryIdle
+	}
+	return m.profileIdle(device)
+}
@@ -0,0 +1,121 @@
+// hd-idle - spin down idle hard disks
+// Copyright (C) 2018  Andoni del Olmo
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package hdidle
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// The kinds of power meters a disk enclosure can be plugged into.
+const (
+	PowerMeterTasmota = "tasmota:" // e.g. tasmota:http://10.0.0.5
+	PowerMeterShelly  = "shelly:"  // e.g. shelly:http://10.0.0.6
+	PowerMeterNut     = "nut:"     // e.g. nut:ups@10.0.0.7/outlet.1.realpower
+)
+
+const (
+	DefaultPowerDrop  = 2.0 // watts
+	powerMeterTimeout = 5 * time.Second
+	powerSettleTime   = 20 * time.Second // for the platters to stop
+	nutPort           = "3493"
+)
+
+// ValidPowerMeter returns the error of a malformed power meter.
+func ValidPowerMeter(meter string) error {
+	switch {
+	case strings.HasPrefix(meter, PowerMeterTasmota), strings.HasPrefix(meter, PowerMeterShelly):
+		return nil
+	case strings.HasPrefix(meter, PowerMeterNut):
+		if !strings.Contains(meter, "@") {
+			return fmt.Errorf("expected nut:<ups>@<host>[:port][/<variable>]")
+		}
+		return nil
+	}
+	return fmt.Errorf("must start with one of: %s, %s, %s", PowerMeterTasmota, PowerMeterShelly, PowerMeterNut)
+}
+
+/* replaced in tests */
+var powerReading = readPowerMeter
+
+type powerCheck struct {
+	meter  string
+	before float64 // watts
+	at     time.Time
+}
+
+/* the power drawn through the meter of the disk's enclosure, if it has one */
+func (m *Monitor) powerBefore(name string) (float64, bool) {
+	meter := m.config.deviceConfig(name).PowerMeter
+	if len(meter) == 0 {
+		return 0, false
+	}
+	watts, err := powerReading(meter)
+	if err != nil {
+		m.println(err.Error())
+		return 0, false
+	}
+	return watts, true
+}
+
+func (m *Monitor) expectPowerDrop(name string, before float64) {
+	m.powerChecks[name] = powerCheck{meter: m.config.deviceConfig(name).PowerMeter, before: before, at: m.now}
+}
+
+/*
+ * Some USB bridges and enclosures acknowledge the stop command without
+ * passing it on, so the disk keeps spinning while hd-idle reports it spun
+ * down. Once the platters had time to stop, the power drawn through the
+ * meter of the enclosure must have gone down by --power-drop watts.
+ */
+func (m *Monitor) verifyPowerDrops() {
+	var names []string
+	for name := range m.powerChecks {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		check := m.powerChecks[name]
+		if m.now.Sub(check.at) < powerSettleTime {
+			continue
+		}
+		delete(m.powerChecks, name)
+		if dsi := m.previousDiskStatsIndex(name); dsi < 0 || !m.snapshots[dsi].SpunDown {
+			/* woke up or unplugged in the meantime */
+			continue
+		}
+		after, err := powerReading(check.meter)
+		if err != nil {
+			m.println(err.Error())
+			continue
+		}
+		if check.before-after >= m.config.Defaults.PowerDrop {
+			m.countSpindownVerified(name)
+			if m.debug(name) {
+				m.printf("disk=%s spindown verified, power %.1f W -> %.1f W\n", name, check.before, after)
+			}
+			continue
+		}
+		message := fmt.Sprintf("power draw went from %.1f W to %.1f W, the disk may still be spinning", check.before, after)
+		m.printf("%s spindown unverified, %s\n", m.displayName(name), message)
+		m.emitCode(EventSpindownUnverified, name, "POWER_UNCHANGED", message)
+		m.logToFile(m.logFileOf(name), fmt.Sprintf("date: %s, time: %s, disk: %s, spindown unverified, %s",
+			m.now.Format("2006-01-02"), m.now.Format("15:04:05"), m.displayName(name), message))
+	}
+}
@@ -0,0 +1,132 @@
+// hd-idle - spin down idle hard disks
+// Copyright (C) 2018  Andoni del Olmo
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+//go:build !minimal
+// +build !minimal
+
+package hdidle
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/* the power drawn through the meter in watts */
+func readPowerMeter(meter string) (float64, error) {
+	switch {
+	case strings.HasPrefix(meter, PowerMeterTasmota):
+		var status struct {
+			StatusSNS struct {
+				ENERGY struct {
+					Power *float64
+				}
+			}
+		}
+		url := strings.TrimSuffix(strings.TrimPrefix(meter, PowerMeterTasmota), "/") + "/cm?cmnd=Status%208"
+		if err := getJson(url, &status); err != nil {
+			return 0, err
+		}
+		if status.StatusSNS.ENERGY.Power == nil {
+			return 0, fmt.Errorf("no power reading from %s", meter)
+		}
+		return *status.StatusSNS.ENERGY.Power, nil
+	case strings.HasPrefix(meter, PowerMeterShelly):
+		var status struct {
+			Meters []struct {
+				Power float64 `json:"power"`
+			} `json:"meters"`
+		}
+		url := strings.TrimSuffix(strings.TrimPrefix(meter, PowerMeterShelly), "/") + "/status"
+		if err := getJson(url, &status); err != nil {
+			return 0, err
+		}
+		if len(status.Meters) == 0 {
+			return 0, fmt.Errorf("no power reading from %s", meter)
+		}
+		return status.Meters[0].Power, nil
+	case strings.HasPrefix(meter, PowerMeterNut):
+		return readNut(strings.TrimPrefix(meter, PowerMeterNut))
+	}
+	return 0, fmt.Errorf("unknown power meter %s", meter)
+}
+
+func getJson(url string, v interface{}) error {
+	client := &http.Client{Timeout: powerMeterTimeout}
+	resp, err := client.Get(url)
+	if err != nil {
+		return fmt.Errorf("cannot read power meter: %s", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("cannot read power meter %s: %s", url, resp.Status)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return fmt.Errorf("cannot read power meter %s: %s", url, err)
+	}
+	return nil
+}
+
+/* a variable of a UPS from the NUT server, ups.realpower unless another one is given */
+func readNut(meter string) (float64, error) {
+	variable := "ups.realpower"
+	if i := strings.Index(meter, "/"); i >= 0 {
+		meter, variable = meter[:i], meter[i+1:]
+	}
+	at := strings.Index(meter, "@")
+	ups, host := meter[:at], meter[at+1:]
+	if _, _, err := net.SplitHostPort(host); err != nil {
+		host = net.JoinHostPort(host, nutPort)
+	}
+
+	conn, err := net.DialTimeout("tcp", host, powerMeterTimeout)
+	if err != nil {
+		return 0, fmt.Errorf("cannot read power meter: %s", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(powerMeterTimeout))
+	if _, err := fmt.Fprintf(conn, "GET VAR %s %s\n", ups, variable); err != nil {
+		return 0, fmt.Errorf("cannot read power meter: %s", err)
+	}
+	line, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil {
+		return 0, fmt.Errorf("cannot read power meter: %s", err)
+	}
+	return parseNutVar(line)
+}
+
+/* VAR ups ups.realpower "45" */
+func parseNutVar(line string) (float64, error) {
+	line = strings.TrimSpace(line)
+	if !strings.HasPrefix(line, "VAR ") {
+		return 0, fmt.Errorf("cannot read power meter: %s", line)
+	}
+	first := strings.Index(line, "\"")
+	last := strings.LastIndex(line, "\"")
+	if first < 0 || last <= first {
+		return 0, fmt.Errorf("cannot read power meter: %s", line)
+	}
+	watts, err := strconv.ParseFloat(line[first+1:last], 64)
+	if err != nil {
+		return 0, fmt.Errorf("cannot read power meter: %s", line)
+	}
+	return watts, nil
+}
@@ -0,0 +1,58 @@
+// hd-idle - spin down idle hard disks
+// Copyright (C) 2018  Andoni del Olmo
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+//go:build !minimal
+// +build !minimal
+
+package hdidle
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestReadPowerMeter(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/cm":
+			fmt.Fprint(w, `{"StatusSNS":{"Time":"2024-01-01T00:00:00","ENERGY":{"Total":1.2,"Power":21.5}}}`)
+		case "/status":
+			fmt.Fprint(w, `{"meters":[{"power":9.8,"is_valid":true}]}`)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	for meter, expected := range map[string]float64{PowerMeterTasmota + server.URL: 21.5, PowerMeterShelly + server.URL: 9.8} {
+		watts, err := readPowerMeter(meter)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if watts != expected {
+			t.Fatalf("Expected %.1f W from %s but found %.1f W", expected, meter, watts)
+		}
+	}
+
+	if watts, err := parseNutVar(`VAR ups outlet.1.realpower "45.5"` + "\n"); err != nil || watts != 45.5 {
+		t.Fatalf("Expected 45.5 W but found %.1f, %v", watts, err)
+	}
+	if _, err := parseNutVar("ERR VAR-NOT-SUPPORTED\n"); err == nil {
+		t.Fatal("Expected an error for an unknown variable")
+	}
+}
@@ -0,0 +1,79 @@
+// hd-idle - spin down idle hard disks
+// Copyright (C) 2018  Andoni del Olmo
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package hdidle
+
+import (
+	"github.com/adelolmo/hd-idle/diskstats"
+	"io/ioutil"
+	"testing"
+	"time"
+)
+
+func TestSpindownUnverified(t *testing.T) {
+	/* the bridge of sdb acknowledges the stop command, the disk of sdc stops */
+	readings := map[string][]float64{"tasmota:http://plug-b": {20, 19.5}, "tasmota:http://plug-c": {20, 12}}
+	powerReading = func(meter string) (float64, error) {
+		watts := readings[meter][0]
+		readings[meter] = readings[meter][1:]
+		return watts, nil
+	}
+	defer func() { powerReading = readPowerMeter }()
+
+	simulation, err := ParseSimulation("dry-run")
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := NewConfig()
+	config.Defaults.Simulation = simulation
+	config.SkewTime = 24 * time.Hour
+	config.Devices = []DeviceConf{
+		{Name: "sdb", Idle: time.Minute, CommandType: SCSI, PowerMeter: "tasmota:http://plug-b"},
+		{Name: "sdc", Idle: time.Minute, CommandType: SCSI, PowerMeter: "tasmota:http://plug-c"},
+	}
+	m := New(config)
+	m.SetOutput(ioutil.Discard)
+	events, cancel := m.Subscribe("")
+	defer cancel()
+
+	start := time.Now()
+	for _, offset := range []time.Duration{0, 2 * time.Minute, 3 * time.Minute} {
+		m.now = start.Add(offset)
+		m.updateState(diskstats.DiskStats{Name: "sdb"})
+		m.updateState(diskstats.DiskStats{Name: "sdc"})
+		m.verifyPowerDrops()
+		m.lastNow = m.now
+	}
+
+	var unverified []string
+	for len(events) > 0 {
+		if event := <-events; event.Type == EventSpindownUnverified {
+			unverified = append(unverified, event.Disk)
+			if event.Code != "POWER_UNCHANGED" {
+				t.Fatalf("Expected the POWER_UNCHANGED code but found %s", event.Code)
+			}
+		}
+	}
+	if len(unverified) != 1 || unverified[0] != "sdb" {
+		t.Fatalf("Expected the spindown of sdb unverified but found %v", unverified)
+	}
+	if s := m.statisticsOf("sdb"); s.SpindownAttempts != 1 || s.Spindowns != 1 || s.SpindownsVerified != 0 {
+		t.Fatalf("Expected 1 unverified spindown of sdb but found %+v", s)
+	}
+	if s := m.statisticsOf("sdc"); s.SpindownAttempts != 1 || s.Spindowns != 1 || s.SpindownsVerified != 1 {
+		t.Fatalf("Expected 1 verified spindown of sdc but found %+v", s)
+	}
+}
@@ -0,0 +1,45 @@
+// hd-idle - spin down idle hard disks
+// Copyright (C) 2018  Andoni del Olmo
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package hdidle
+
+import (
+	"github.com/adelolmo/hd-idle/io"
+)
+
+/* how many probe windows udev may keep a disk busy for at most */
+const probeWindowLimit = 3
+
+/*
+ * Right after boot or a hotplug udev and blkid read every new disk for its
+ * partitions and filesystems. These reads are discovery, not a use of the
+ * disk, so the idle time starts when the disk appeared. The window is
+ * extended while udev still has events queued, as on a slow boot with many
+ * disks, within a limit.
+ */
+func (m *Monitor) probing(disk string) bool {
+	window := m.config.Defaults.ProbeWindow
+	appeared, found := m.appearedAt[disk]
+	if window == 0 || !found {
+		return false
+	}
+	since := m.now.Sub(appeared)
+	if since < window || since < probeWindowLimit*window && io.UdevBusy() {
+		return true
+	}
+	delete(m.appearedAt, disk)
+	return false
+}
@@ -0,0 +1,83 @@
+// hd-idle - spin down idle hard disks
+// Copyright (C) 2018  Andoni del Olmo
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package hdidle
+
+import (
+	"github.com/adelolmo/hd-idle/diskstats"
+	"github.com/adelolmo/hd-idle/io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestProbeWindow(t *testing.T) {
+	dir, err := ioutil.TempDir("", "udev")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	queue := io.UdevQueue
+	io.UdevQueue = filepath.Join(dir, "queue")
+	defer func() { io.UdevQueue = queue }()
+
+	simulation, err := ParseSimulation("dry-run")
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := NewConfig()
+	config.Defaults.Simulation = simulation
+	config.Defaults.Idle = 10 * time.Minute
+	config.SkewTime = 24 * time.Hour
+	m := New(config)
+	m.SetOutput(ioutil.Discard)
+
+	start := time.Now()
+	reads := 0
+	cycle := func(now time.Time, readMore bool) {
+		if readMore {
+			reads += 100
+		}
+		m.now = now
+		m.updateState(diskstats.DiskStats{Name: "sdb", Reads: reads})
+		m.lastNow = now
+	}
+	cycle(start, false)
+	appeared := m.snapshots[0].LastIoAt
+
+	/* blkid reading the new disk */
+	cycle(start.Add(30*time.Second), true)
+	if !m.snapshots[0].LastIoAt.Equal(appeared) {
+		t.Fatal("Expected the reads of the probe window ignored")
+	}
+
+	/* udev is still busy after the window */
+	if err := ioutil.WriteFile(io.UdevQueue, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	cycle(start.Add(3*time.Minute), true)
+	if !m.snapshots[0].LastIoAt.Equal(appeared) {
+		t.Fatal("Expected the reads ignored while udev is busy")
+	}
+
+	os.Remove(io.UdevQueue)
+	cycle(start.Add(4*time.Minute), true)
+	if !m.snapshots[0].LastIoAt.Equal(start.Add(4 * time.Minute)) {
+		t.Fatal("Expected the reads after the probe window counted")
+	}
+}
@@ -0,0 +1,139 @@
+// hd-idle - spin down idle hard disks
+// Copyright (C) 2018  Andoni del Olmo
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package hdidle
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ProfileDefault as profile switches back to the configured idle times.
+const ProfileDefault = "default"
+
+var profileName = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// ValidProfileName tells whether the name can be given to a profile.
+func ValidProfileName(name string) bool {
+	return profileName.MatchString(name) && name != ProfileDefault
+}
+
+/*
+ * A profile, e.g. night or backup, gives disks other idle times while it is
+ * active. Disks the profile gives no idle time keep theirs. It is switched at
+ * runtime through SetProfile and takes effect in the next monitoring cycle,
+ * where the switch is told like a pause.
+ */
+
+// SetProfile makes the named profile the active one, or none with
+// ProfileDefault. The profile must give some disk an idle time.
+func (m *Monitor) SetProfile(name string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if name == ProfileDefault {
+		name = ""
+	}
+	if len(name) > 0 && !m.config.HasProfile(name) {
+		return fmt.Errorf("unknown profile %s, must be one of: %s",
+			name, strings.Join(append(m.config.profiles(), ProfileDefault), ", "))
+	}
+	if name != m.profile {
+		m.profile = name
+		m.profileSwitched = true
+	}
+	return nil
+}
+
+// Profile returns the name of the active profile, ProfileDefault if none is.
+func (m *Monitor) Profile() string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if len(m.profile) == 0 {
+		return ProfileDefault
+	}
+	return m.profile
+}
+
+// Profiles returns the names of the profiles of the configuration, sorted.
+func (m *Monitor) Profiles() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.config.profiles()
+}
+
+/* tell about a switch of the profile and give the disks their new idle times */
+func (m *Monitor) updateProfile() {
+	if !m.profileSwitched {
+		return
+	}
+	m.profileSwitched = false
+	message := "profile " + m.profile + " active"
+	if len(m.profile) == 0 {
+		message = "profile " + ProfileDefault + " active, configured idle times restored"
+	}
+	m.println(message)
+	m.emit(EventProfileSwitched, "", message)
+	m.logToFile(m.config.Defaults.LogFile, fmt.Sprintf("date: %s, time: %s, %s",
+		m.now.Format("2006-01-02"), m.now.Format("15:04:05"), message))
+	for i := range m.snapshots {
+		m.snapshots[i].IdleTime = m.idleTime(m.snapshots[i].Name, m.config.deviceConfig(m.snapshots[i].Name))
+	}
+}
+
+/* the idle time of the disk in the active profile */
+func (m *Monitor) profileIdle(device *DeviceConf) time.Duration {
+	if idle, found := device.ProfileIdles[m.profile]; found && len(m.profile) > 0 {
+		return idle
+	}
+	return device.Idle
+}
+
+// HasProfile tells whether a profile of the name gives any disk an idle time.
+func (c *Config) HasProfile(name string) bool {
+	for _, profile := range c.profiles() {
+		if profile == name {
+			return true
+		}
+	}
+	return false
+}
+
+/* [night=5m day=1h], sorted by profile */
+func formatProfileIdles(idles map[string]time.Duration) string {
+	var names []string
+	for name := range idles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var formatted []string
+	for _, name := range names {
+		formatted = append(formatted, name+"="+FormatDuration(idles[name]))
+	}
+	return "[" + strings.Join(formatted, " ") + "]"
+}
+
+/* set in a copy, the map may be shared with the defaults or a class */
+func withProfileIdle(idles map[string]time.Duration, name string, idle time.Duration) map[string]time.Duration {
+	copied := map[string]time.Duration{}
+	for profile, profileIdle := range idles {
+		copied[profile] = profileIdle
+	}
+	copied[name] = idle
+	return copied
+}
@@ -0,0 +1,91 @@
+// hd-idle - spin down idle hard disks
+// Copyright (C) 2018  Andoni del Olmo
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package hdidle
+
+import (
+	"github.com/adelolmo/hd-idle/diskstats"
+	"io/ioutil"
+	"testing"
+	"time"
+)
+
+func TestSwitchProfile(t *testing.T) {
+	simulation, err := ParseSimulation("dry-run")
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := NewConfig()
+	config.Defaults.Simulation = simulation
+	config.Defaults.Idle = 30 * time.Minute
+	config.Defaults.ProfileIdles = map[string]time.Duration{"night": 2 * time.Minute}
+	config.Devices = []DeviceConf{{Name: "sdc", Idle: time.Hour, CommandType: SCSI}}
+	config.SkewTime = 24 * time.Hour
+	m := New(config)
+	m.SetOutput(ioutil.Discard)
+
+	start := time.Now()
+	cycle := func(minutes int) map[string]bool {
+		m.now = start.Add(time.Duration(minutes) * time.Minute)
+		m.updateProfile()
+		for _, disk := range []string{"sdb", "sdc"} {
+			m.updateState(diskstats.DiskStats{Name: disk})
+		}
+		m.lastNow = m.now
+		spunDown := map[string]bool{}
+		for _, ds := range m.snapshots {
+			spunDown[ds.Name] = ds.SpunDown
+		}
+		return spunDown
+	}
+	cycle(0)
+	if spunDown := cycle(5); spunDown["sdb"] || spunDown["sdc"] {
+		t.Fatalf("Expected the disks spinning without a profile but found %v", spunDown)
+	}
+
+	if err := m.SetProfile("weekend"); err == nil {
+		t.Fatal("Expected an error for an unknown profile")
+	}
+	if err := m.SetProfile("night"); err != nil {
+		t.Fatal(err)
+	}
+	/* sdc has no idle time in the profile and keeps its hour */
+	if spunDown := cycle(6); !spunDown["sdb"] || spunDown["sdc"] {
+		t.Fatalf("Expected only sdb spun down at night but found %v", spunDown)
+	}
+
+	reloaded := NewConfig()
+	reloaded.Defaults.Simulation = simulation
+	reloaded.Defaults.Idle = 30 * time.Minute
+	reloaded.Defaults.ProfileIdles = map[string]time.Duration{"night": 3 * time.Minute}
+	reloaded.Devices = []DeviceConf{{Name: "sdc", Idle: time.Hour, CommandType: SCSI}}
+	if err := m.Reload(reloaded); err != nil {
+		t.Fatal(err)
+	}
+	if m.Profile() != "night" {
+		t.Fatalf("Expected profile night to stay active after a reload but found %s", m.Profile())
+	}
+
+	if err := m.SetProfile(ProfileDefault); err != nil {
+		t.Fatal(err)
+	}
+	cycle(7)
+	for _, ds := range m.snapshots {
+		if ds.Name == "sdb" && ds.IdleTime != 30*time.Minute {
+			t.Fatalf("Expected sdb idle for 30m without a profile but found %v", ds.IdleTime)
+		}
+	}
+}
@@ -0,0 +1,129 @@
+// hd-idle - spin down idle hard disks
+// Copyright (C) 2018  Andoni del Olmo
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package hdidle
+
+import (
+	"fmt"
+	"github.com/adelolmo/hd-idle/quirks"
+	"github.com/adelolmo/hd-idle/sgio"
+	"github.com/adelolmo/hd-idle/sysfs"
+	"os"
+	"time"
+)
+
+func (m *Monitor) loadQuirks(file string) error {
+	info, err := os.Stat(file)
+	if err != nil {
+		return err
+	}
+	list, err := quirks.Load(file)
+	if err != nil {
+		return err
+	}
+	m.quirks = list
+	m.quirksModTime = info.ModTime()
+	return nil
+}
+
+/* reload the quirks file when it changes, keeping the previous quirks if it is wrong */
+func (m *Monitor) reloadQuirks(file string) {
+	if len(file) == 0 {
+		return
+	}
+	info, err := os.Stat(file)
+	if err != nil || info.ModTime().Equal(m.quirksModTime) {
+		return
+	}
+	if err := m.loadQuirks(file); err != nil {
+		m.quirksModTime = info.ModTime()
+		m.printf("Cannot reload quirks file %s: %s\n", file, err)
+		return
+	}
+	m.printf("quirks file %s reloaded\n", file)
+}
+
+/* the quirks file wins over the profile learned for the bridge */
+func (m *Monitor) quirksFor(disk string) quirks.Quirk {
+	if q, found := m.configuredQuirks(disk); found {
+		return q
+	}
+	if m.config.Defaults.LearnQuirks {
+		if key := bridgeKey(disk); len(key) > 0 {
+			return m.learnedProfiles()[key]
+		}
+	}
+	return quirks.Quirk{}
+}
+
+/* the adjustments of the quirks file for the disk, false if no entry matches */
+func (m *Monitor) configuredQuirks(disk string) (quirks.Quirk, bool) {
+	if len(m.quirks) == 0 {
+		return quirks.Quirk{}, false
+	}
+	serial, _ := sysfs.Serial(disk)
+	usbID, _ := sysfs.UsbID(disk)
+	device := quirks.Device{
+		UsbID:  usbID,
+		Vendor: sysfs.Vendor(disk),
+		Model:  sysfs.Model(disk),
+		Serial: serial,
+	}
+	for _, q := range m.quirks {
+		if q.Matches(device) {
+			return quirks.Find(m.quirks, device), true
+		}
+	}
+	return quirks.Quirk{}, false
+}
+
+/* no monitor state here, it may keep running after the watchdog gave up on it */
+func spindownWithQuirk(disk, command string, q quirks.Quirk) error {
+	if len(q.CommandType) > 0 {
+		command = q.CommandType
+	}
+
+	device := fmt.Sprintf("/dev/%s", disk)
+	var err error
+	if command == ATA && q.PassThrough == 12 {
+		if err = sgio.StopAtaDevice12(device); err != nil {
+			err = spindownError(ATA, device, err)
+		}
+	} else if command == SCSI && q.Eject {
+		err = stopScsiDevice(device, sgio.EjectScsiDevice)
+	} else {
+		err = SpindownDisk(device, command)
+	}
+
+	if err == nil && q.StopDelay > 0 {
+		time.Sleep(q.StopDelayDuration())
+	}
+	return err
+}
+
+/* a medium ejected on spin down has to be loaded again */
+func spinupWithQuirk(device, command string, q quirks.Quirk) error {
+	if len(q.CommandType) > 0 {
+		command = q.CommandType
+	}
+	if command == SCSI && q.Eject {
+		if err := sgio.LoadScsiDevice(device); err != nil {
+			return fmt.Errorf("cannot spinup scsi disk %s:\n%s\n", device, err.Error())
+		}
+		return nil
+	}
+	return SpinupDisk(device, command)
+}
@@ -0,0 +1,146 @@
+// hd-idle - spin down idle hard disks
+// Copyright (C) 2018  Andoni del Olmo
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package hdidle
+
+import (
+	"github.com/adelolmo/hd-idle/sysfs"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+const readAheadStateFile = "read-ahead.json"
+
+var bootIdFile = "/proc/sys/kernel/random/boot_id"
+
+/* the read-ahead of a disk before hd-idle lowered it */
+type readAhead struct {
+	Original int `json:"original"`
+	Lowered  int `json:"lowered"`
+}
+
+/*
+ * The original values are saved in the state directory, so they can be
+ * restored after hd-idle was killed while disks were spun down. sysfs starts
+ * afresh on every boot, so the state of another boot is discarded.
+ */
+type readAheadState struct {
+	BootId string               `json:"boot_id"`
+	Disks  map[string]readAhead `json:"disks"`
+}
+
+func (m *Monitor) lowerReadAhead(name string) {
+	if _, found := m.readAheads[name]; found {
+		return
+	}
+	kb := m.config.Defaults.StandbyReadAhead
+	original, err := sysfs.ReadAhead(name)
+	if err != nil {
+		m.println(err.Error())
+		return
+	}
+	if original <= kb {
+		return
+	}
+	m.readAheads[name] = readAhead{Original: original, Lowered: kb}
+	if err := m.saveReadAheads(); err != nil {
+		m.printf("Cannot save read-ahead of %s, leaving it as is: %s\n", m.displayName(name), err)
+		delete(m.readAheads, name)
+		return
+	}
+	if err := sysfs.SetReadAhead(name, kb); err != nil {
+		m.println(err.Error())
+		delete(m.readAheads, name)
+		_ = m.saveReadAheads()
+		return
+	}
+	if m.debug(name) {
+		m.printf("%s read-ahead set to %dKiB\n", m.displayName(name), kb)
+	}
+}
+
+func (m *Monitor) restoreReadAhead(name string) {
+	ra, found := m.readAheads[name]
+	if !found {
+		return
+	}
+	delete(m.readAheads, name)
+	m.setReadAhead(name, ra)
+	if err := m.saveReadAheads(); err != nil {
+		m.println(err.Error())
+	}
+}
+
+/* leave the read-ahead as found when hd-idle stops */
+func (m *Monitor) restoreAllReadAheads() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for name := range m.readAheads {
+		m.restoreReadAhead(name)
+	}
+}
+
+/* restore the read-ahead lowered by an earlier run that didn't stop cleanly */
+func (m *Monitor) restoreSavedReadAheads() {
+	if m.config.Defaults.StandbyReadAhead < 0 {
+		return
+	}
+	var state readAheadState
+	if err := m.readState(readAheadStateFile, &state); err != nil {
+		if !os.IsNotExist(err) {
+			m.printf("Ignoring state file %s: %s\n", readAheadStateFile, err)
+		}
+		return
+	}
+	if state.BootId == bootId() {
+		for name, ra := range state.Disks {
+			m.setReadAhead(name, ra)
+		}
+	}
+	if err := m.removeState(readAheadStateFile); err != nil {
+		m.println(err.Error())
+	}
+}
+
+/* restore the original value unless someone else changed it meanwhile */
+func (m *Monitor) setReadAhead(name string, ra readAhead) {
+	if current, err := sysfs.ReadAhead(name); err != nil || current != ra.Lowered {
+		return
+	}
+	if err := sysfs.SetReadAhead(name, ra.Original); err != nil {
+		m.println(err.Error())
+		return
+	}
+	if m.debug(name) {
+		m.printf("%s read-ahead restored to %dKiB\n", m.displayName(name), ra.Original)
+	}
+}
+
+func (m *Monitor) saveReadAheads() error {
+	if len(m.readAheads) == 0 {
+		return m.removeState(readAheadStateFile)
+	}
+	return m.writeState(readAheadStateFile, readAheadState{BootId: bootId(), Disks: m.readAheads})
+}
+
+func bootId() string {
+	data, err := ioutil.ReadFile(bootIdFile)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(data))
+}
@@ -0,0 +1,124 @@
+// hd-idle - spin down idle hard disks
+// Copyright (C) 2018  Andoni del Olmo
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package hdidle
+
+import (
+	"github.com/adelolmo/hd-idle/sysfs"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func fakeReadAhead(t *testing.T, kb string) string {
+	dir, err := ioutil.TempDir("", "sysfs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	mustMkdir(t, filepath.Join(dir, "sys/block/sda/queue"))
+	if err := ioutil.WriteFile(filepath.Join(dir, "sys/block/sda/queue/read_ahead_kb"), []byte(kb), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "boot_id"), []byte("b1\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	sysfs.Root = filepath.Join(dir, "sys")
+	bootIdFile = filepath.Join(dir, "boot_id")
+	return dir
+}
+
+func restoreFakes(root, bootIdFileName string) {
+	sysfs.Root = root
+	bootIdFile = bootIdFileName
+}
+
+func TestLowerAndRestoreReadAhead(t *testing.T) {
+	defer restoreFakes(sysfs.Root, bootIdFile)
+	dir := fakeReadAhead(t, "128\n")
+	defer os.RemoveAll(dir)
+
+	config := NewConfig()
+	config.Defaults.StandbyReadAhead = 0
+	config.Defaults.StateDir = filepath.Join(dir, "state")
+	m := New(config)
+	m.SetOutput(ioutil.Discard)
+
+	m.lowerReadAhead("sda")
+	assertReadAhead(t, 0)
+	if _, err := os.Stat(filepath.Join(dir, "state", readAheadStateFile)); err != nil {
+		t.Fatalf("Expected the original read-ahead to be saved: %s", err)
+	}
+
+	m.restoreReadAhead("sda")
+	assertReadAhead(t, 128)
+	if _, err := os.Stat(filepath.Join(dir, "state", readAheadStateFile)); !os.IsNotExist(err) {
+		t.Fatalf("Expected no state file but found %v", err)
+	}
+}
+
+func TestRestoreSavedReadAheads(t *testing.T) {
+	defer restoreFakes(sysfs.Root, bootIdFile)
+	dir := fakeReadAhead(t, "0\n")
+	defer os.RemoveAll(dir)
+	mustMkdir(t, filepath.Join(dir, "state"))
+	state := `{"boot_id":"b1","disks":{"sda":{"original":256,"lowered":0}}}`
+	if err := ioutil.WriteFile(filepath.Join(dir, "state", readAheadStateFile), []byte(state), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	config := NewConfig()
+	config.Defaults.StandbyReadAhead = 0
+	config.Defaults.StateDir = filepath.Join(dir, "state")
+	m := New(config)
+	m.SetOutput(ioutil.Discard)
+	m.restoreSavedReadAheads()
+
+	assertReadAhead(t, 256)
+	if _, err := os.Stat(filepath.Join(dir, "state", readAheadStateFile)); !os.IsNotExist(err) {
+		t.Fatalf("Expected the state file to be removed but found %v", err)
+	}
+}
+
+func TestIgnoreReadAheadsOfAnotherBoot(t *testing.T) {
+	defer restoreFakes(sysfs.Root, bootIdFile)
+	dir := fakeReadAhead(t, "0\n")
+	defer os.RemoveAll(dir)
+	mustMkdir(t, filepath.Join(dir, "state"))
+	state := `{"boot_id":"b0","disks":{"sda":{"original":256,"lowered":0}}}`
+	if err := ioutil.WriteFile(filepath.Join(dir, "state", readAheadStateFile), []byte(state), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	config := NewConfig()
+	config.Defaults.StandbyReadAhead = 0
+	config.Defaults.StateDir = filepath.Join(dir, "state")
+	m := New(config)
+	m.SetOutput(ioutil.Discard)
+	m.restoreSavedReadAheads()
+
+	assertReadAhead(t, 0)
+}
+
+func assertReadAhead(t *testing.T, expected int) {
+	kb, err := sysfs.ReadAhead("sda")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if kb != expected {
+		t.Fatalf("Expected a read-ahead of %d but found %d", expected, kb)
+	}
+}
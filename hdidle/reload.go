@@ -0,0 +1,113 @@
+// hd-idle - spin down idle hard disks
+// Copyright (C) 2018  Andoni del Olmo
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package hdidle
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// Reload applies a new configuration to the running monitor. The idle timers
+// and spin state of the disks are kept. The
+// settings used once at start (listeners, sinks, state directory, watchdog,
+// simulation and read-only mode) keep their values until a restart.
+func (m *Monitor) Reload(config *Config) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	old := *m.config
+	var kept []string
+	keep := func(name string, current, reloaded interface{}) {
+		if !reflect.DeepEqual(current, reloaded) {
+			kept = append(kept, name)
+		}
+	}
+	keep("--listen", old.Defaults.Listen, config.Defaults.Listen)
+	keep("--listen-control", old.Defaults.ControlListen, config.Defaults.ControlListen)
+	keep("--read-allow", old.Defaults.ReadAllow, config.Defaults.ReadAllow)
+	keep("--control-allow", old.Defaults.ControlAllow, config.Defaults.ControlAllow)
+	keep("--api-tokens", old.Defaults.ApiTokens, config.Defaults.ApiTokens)
+	keep("--control", old.Defaults.Control, config.Defaults.Control)
+	keep("--webhook", old.Defaults.Webhooks, config.Defaults.Webhooks)
+	keep("--push", old.Defaults.Push, config.Defaults.Push)
+	keep("--state-dir", old.Defaults.StateDir, config.Defaults.StateDir)
+	keep("--watchdog", old.Defaults.WatchdogFactor, config.Defaults.WatchdogFactor)
+	keep("--simulate", old.Defaults.Simulation, config.Defaults.Simulation)
+	keep("--read-only", old.Defaults.ReadOnly, config.Defaults.ReadOnly)
+	keep("--audit-opens", old.Defaults.AuditOpens, config.Defaults.AuditOpens)
+	keep("--watch-config", old.Defaults.WatchConfig, config.Defaults.WatchConfig)
+	config.Defaults.Listen = old.Defaults.Listen
+	config.Defaults.ControlListen = old.Defaults.ControlListen
+	config.Defaults.ReadAllow = old.Defaults.ReadAllow
+	config.Defaults.ControlAllow = old.Defaults.ControlAllow
+	config.Defaults.ApiTokens = old.Defaults.ApiTokens
+	config.Defaults.Control = old.Defaults.Control
+	config.Defaults.Webhooks = old.Defaults.Webhooks
+	config.Defaults.Push = old.Defaults.Push
+	config.Defaults.PushInterval = old.Defaults.PushInterval
+	config.Defaults.StateDir = old.Defaults.StateDir
+	config.Defaults.WatchdogFactor = old.Defaults.WatchdogFactor
+	config.Defaults.Simulation = old.Defaults.Simulation
+	config.Defaults.ReadOnly = old.Defaults.ReadOnly
+	config.Defaults.AuditOpens = old.Defaults.AuditOpens
+	config.Defaults.WatchConfig = old.Defaults.WatchConfig
+	if config.Defaults.ReadOnly {
+		if paths := config.WritablePaths(); len(paths) > 0 {
+			return fmt.Errorf("read-only mode does not allow writing to: %s", strings.Join(paths, ", "))
+		}
+	}
+
+	/* disks that took the place of a configured disk keep its configuration */
+	for disk, givenName := range m.inherited {
+		for i := range config.Devices {
+			if config.Devices[i].GivenName == givenName && len(config.Devices[i].Name) == 0 {
+				config.Devices[i].Name = disk
+			}
+		}
+	}
+	if m.interval > 0 {
+		m.interval = PollInterval(config.Devices)
+		if config.SkewTime == 0 {
+			config.SkewTime = m.interval * 3
+		}
+	}
+	*m.config = *config
+	m.logFilesAllowed = config.configuredLogFiles()
+	m.updateWatchdogView()
+	/* a switched profile stays active unless it is gone or the configuration starts another */
+	if config.Defaults.Profile != old.Defaults.Profile || (len(m.profile) > 0 && !config.HasProfile(m.profile)) {
+		if m.profile != config.Defaults.Profile {
+			m.profile = config.Defaults.Profile
+			m.profileSwitched = true
+		}
+	}
+	for i := range m.snapshots {
+		device := config.deviceConfig(m.snapshots[i].Name)
+		m.snapshots[i].IdleTime = m.idleTime(m.snapshots[i].Name, device)
+		m.snapshots[i].CommandType = device.CommandType
+	}
+	if !reflect.DeepEqual(config.logFiles(), old.logFiles()) {
+		m.warnLogOnMonitoredDisk()
+	}
+
+	m.printf("configuration reloaded: %s\n", m.config)
+	if len(kept) > 0 {
+		m.printf("%s changed, takes effect after a restart\n", strings.Join(kept, ", "))
+	}
+	return nil
+}
@@ -0,0 +1,69 @@
+// hd-idle - spin down idle hard disks
+// Copyright (C) 2018  Andoni del Olmo
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package hdidle
+
+import (
+	"github.com/adelolmo/hd-idle/diskstats"
+	"io/ioutil"
+	"testing"
+	"time"
+)
+
+func TestReloadKeepsTimers(t *testing.T) {
+	config := NewConfig()
+	config.Defaults.Listen = []string{"127.0.0.1:7000"}
+	m := New(config)
+	m.SetOutput(ioutil.Discard)
+	lastIo := time.Now().Add(-5 * time.Minute)
+	m.snapshots = []diskstats.DiskStats{
+		{Name: "sda", CommandType: SCSI, IdleTime: DefaultIdleTime, LastIoAt: lastIo},
+		{Name: "sdb", CommandType: SCSI, IdleTime: DefaultIdleTime, LastIoAt: lastIo, SpunDown: true},
+	}
+
+	reloaded := NewConfig()
+	reloaded.Defaults.Listen = []string{"0.0.0.0:7000"}
+	reloaded.Devices = []DeviceConf{{Name: "sda", Idle: 30 * time.Minute, CommandType: ATA}}
+	if err := m.Reload(reloaded); err != nil {
+		t.Fatal(err)
+	}
+	sda, sdb := m.snapshots[0], m.snapshots[1]
+	if sda.IdleTime != 30*time.Minute || sda.CommandType != ATA || !sda.LastIoAt.Equal(lastIo) {
+		t.Fatalf("Expected sda with the new idle time and its timer but found %+v", sda)
+	}
+	if !sdb.SpunDown || sdb.IdleTime != DefaultIdleTime {
+		t.Fatalf("Expected sdb spun down with the default idle time but found %+v", sdb)
+	}
+	if len(config.Devices) != 1 || config.Defaults.Listen[0] != "127.0.0.1:7000" {
+		t.Fatalf("Expected the new disks and the listener of the start but found %s", config)
+	}
+}
+
+func TestReloadRefusesWritesInReadOnlyMode(t *testing.T) {
+	config := NewConfig()
+	config.Defaults.ReadOnly = true
+	m := New(config)
+	m.SetOutput(ioutil.Discard)
+
+	reloaded := NewConfig()
+	reloaded.Defaults.LogFile = "/var/log/hd-idle.log"
+	if err := m.Reload(reloaded); err == nil {
+		t.Fatal("Expected an error for a log file in read-only mode")
+	}
+	if len(config.Defaults.LogFile) != 0 {
+		t.Fatal("Expected the running configuration kept")
+	}
+}
@@ -0,0 +1,119 @@
+// hd-idle - spin down idle hard disks
+// Copyright (C) 2018  Andoni del Olmo
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package hdidle
+
+import (
+	"fmt"
+	"github.com/adelolmo/hd-idle/io"
+	"github.com/adelolmo/hd-idle/sysfs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// What to do when a disk appears in the slot of a configured disk that is
+// gone.
+const (
+	ReplacementOff     = "off"
+	ReplacementReport  = "report"  // tell how to configure the new disk
+	ReplacementInherit = "inherit" // apply the configuration of the old disk
+)
+
+const slotsStateFile = "slots.json"
+
+/*
+ * The slot of a disk: the enclosure slot when an SES enclosure reports one,
+ * otherwise the by-path name of the port. Empty if neither is known.
+ */
+func diskSlot(disk string) string {
+	if slot, err := sysfs.EnclosureSlot(disk); err == nil {
+		return "enclosure " + slot
+	}
+	for _, link := range diskLinks()[disk] {
+		if filepath.Base(filepath.Dir(link)) == "by-path" && !strings.Contains(filepath.Base(link), "-part") {
+			return link
+		}
+	}
+	return ""
+}
+
+/* only disks configured by a persistent name stop matching when replaced */
+func persistentName(name string) bool {
+	return strings.HasPrefix(name, "/") && strings.Contains(name, "by-") || io.DriveName(name)
+}
+
+/*
+ * Remember the slots of the disks configured by persistent name, and find the
+ * configured disk a new disk replaces: one that sat in the same slot and whose
+ * persistent name is gone.
+ */
+func (m *Monitor) checkReplacement(disk string) {
+	policy := m.config.Defaults.Replacement
+	if policy == ReplacementOff || len(policy) == 0 {
+		return
+	}
+	if m.slots == nil {
+		m.slots = map[string]string{}
+		if err := m.readState(slotsStateFile, &m.slots); err != nil && !os.IsNotExist(err) {
+			m.printf("Ignoring state file %s: %s\n", slotsStateFile, err)
+		}
+	}
+	slot := diskSlot(disk)
+	if len(slot) == 0 {
+		return
+	}
+
+	devices := m.config.Devices
+	for i := range devices {
+		if devices[i].Name != disk {
+			continue
+		}
+		if persistentName(devices[i].GivenName) && m.slots[devices[i].GivenName] != slot {
+			m.slots[devices[i].GivenName] = slot
+			if err := m.writeState(slotsStateFile, m.slots); err != nil {
+				m.println(err.Error())
+			}
+		}
+		return
+	}
+
+	for i := range devices {
+		givenName := devices[i].GivenName
+		if !persistentName(givenName) || m.slots[givenName] != slot {
+			continue
+		}
+		if _, err := io.RealPath(givenName); err == nil {
+			continue // the old disk is still around
+		}
+		if policy == ReplacementReport {
+			message := fmt.Sprintf("%s took the place of %s in %s, configure it with -a or use --replacement inherit",
+				disk, givenName, slot)
+			m.println(message)
+			m.emit(EventDiskReplaced, disk, message)
+			return
+		}
+		devices[i].Name = disk
+		delete(m.pendingSince, givenName)
+		m.inherited[disk] = givenName
+		message := fmt.Sprintf("%s took the place of %s in %s, inheriting its configuration", disk, givenName, slot)
+		m.println(message)
+		m.emit(EventDiskReplaced, disk, message)
+		m.logToFile(m.logFileOf(disk), fmt.Sprintf("date: %s, time: %s, %s",
+			m.now.Format("2006-01-02"), m.now.Format("15:04:05"), message))
+		return
+	}
+}
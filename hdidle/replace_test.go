@@ -0,0 +1,107 @@
+// hd-idle - spin down idle hard disks
+// Copyright (C) 2018  Andoni del Olmo
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package hdidle
+
+import (
+	"github.com/adelolmo/hd-idle/diskstats"
+	"github.com/adelolmo/hd-idle/io"
+	"github.com/adelolmo/hd-idle/sysfs"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestInheritReplacedDisk(t *testing.T) {
+	dir, err := ioutil.TempDir("", "replace")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	root, devDiskDir := sysfs.Root, io.DevDiskDir
+	defer func() { sysfs.Root, io.DevDiskDir = root, devDiskDir }()
+	sysfs.Root = filepath.Join(dir, "sys")
+	io.DevDiskDir = filepath.Join(dir, "disk")
+
+	mustMkdir(t, filepath.Join(dir, "disk/by-path"))
+	mustMkdir(t, filepath.Join(dir, "disk/by-id"))
+	slot := filepath.Join(dir, "disk/by-path/pci-0000:00:1f.2-ata-3")
+	if err := os.Symlink("../../sdc", slot); err != nil {
+		t.Fatal(err)
+	}
+	oldDisk := filepath.Join(dir, "disk/by-id/ata-WDC_WD40EFRX_OLD")
+
+	config := NewConfig()
+	config.Defaults.Replacement = ReplacementInherit
+	config.Defaults.StateDir = filepath.Join(dir, "state")
+	config.Devices = []DeviceConf{{GivenName: oldDisk, Idle: time.Hour, CommandType: ATA}}
+	m := New(config)
+	m.SetOutput(ioutil.Discard)
+	if err := m.writeState(slotsStateFile, map[string]string{oldDisk: slot}); err != nil {
+		t.Fatal(err)
+	}
+	events, cancel := m.Subscribe("sdc")
+	defer cancel()
+
+	m.updateState(diskstats.DiskStats{Name: "sdc"})
+
+	if event := <-events; event.Type != EventDiskReplaced {
+		t.Fatalf("Expected %s but found %s", EventDiskReplaced, event.Type)
+	}
+	if ds := m.snapshots[0]; ds.IdleTime != time.Hour || ds.CommandType != ATA {
+		t.Fatalf("Expected the configuration of the old disk but found %+v", ds)
+	}
+	if status := m.Status(); status[0].Inherits != oldDisk {
+		t.Fatalf("Expected the disk to inherit from %s but found %q", oldDisk, status[0].Inherits)
+	}
+}
+
+func TestRememberSlotOfConfiguredDisk(t *testing.T) {
+	dir, err := ioutil.TempDir("", "replace")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	root, devDiskDir := sysfs.Root, io.DevDiskDir
+	defer func() { sysfs.Root, io.DevDiskDir = root, devDiskDir }()
+	sysfs.Root = filepath.Join(dir, "sys")
+	io.DevDiskDir = filepath.Join(dir, "disk")
+
+	mustMkdir(t, filepath.Join(dir, "disk/by-path"))
+	slot := filepath.Join(dir, "disk/by-path/pci-0000:00:1f.2-ata-3")
+	if err := os.Symlink("../../sdc", slot); err != nil {
+		t.Fatal(err)
+	}
+
+	config := NewConfig()
+	config.Defaults.Replacement = ReplacementReport
+	config.Defaults.StateDir = filepath.Join(dir, "state")
+	config.Devices = []DeviceConf{{Name: "sdc", GivenName: "/dev/disk/by-id/ata-WDC_WD40EFRX_NEW", Idle: time.Hour}}
+	m := New(config)
+	m.SetOutput(ioutil.Discard)
+
+	m.updateState(diskstats.DiskStats{Name: "sdc"})
+
+	var slots map[string]string
+	if err := m.readState(slotsStateFile, &slots); err != nil {
+		t.Fatal(err)
+	}
+	if slots["/dev/disk/by-id/ata-WDC_WD40EFRX_NEW"] != slot {
+		t.Fatalf("Expected slot %s but found %v", slot, slots)
+	}
+}
@@ -0,0 +1,105 @@
+// hd-idle - spin down idle hard disks
+// Copyright (C) 2018  Andoni del Olmo
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package hdidle
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+/* replaced in tests */
+var procSelfStatm = "/proc/self/statm"
+
+// SelfMetrics is the resource usage of hd-idle itself, measured after every
+// cycle with --self-metrics, to weigh the cost of its features against the
+// power the spin downs save.
+type SelfMetrics struct {
+	MeasuredAt    time.Time
+	CycleDuration time.Duration // wall time of the last cycle
+	CycleCpu      time.Duration // user and system time spent by the process during the last cycle
+	Cpu           time.Duration // user and system time since the start
+	Goroutines    int
+	RssBytes      uint64 // 0 if unknown
+	HeapBytes     uint64 // allocated heap objects
+	GcRuns        uint32
+	GcPause       time.Duration // total stop the world time of the collections
+}
+
+// SelfMetrics returns the resource usage measured after the last cycle,
+// false without --self-metrics or before the first cycle.
+func (m *Monitor) SelfMetrics() (SelfMetrics, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.self, !m.self.MeasuredAt.IsZero()
+}
+
+func (m *Monitor) measureSelf(start time.Time, startCpu time.Duration) {
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+	cpu := cpuTime()
+	m.self = SelfMetrics{
+		MeasuredAt:    time.Now(),
+		CycleDuration: time.Since(start),
+		CycleCpu:      cpu - startCpu,
+		Cpu:           cpu,
+		Goroutines:    runtime.NumGoroutine(),
+		RssBytes:      residentSetSize(),
+		HeapBytes:     mem.HeapAlloc,
+		GcRuns:        mem.NumGC,
+		GcPause:       time.Duration(mem.PauseTotalNs),
+	}
+	if m.config.Defaults.Debug {
+		m.printf("self %s\n", m.self)
+	}
+}
+
+/* user and system time of the process, 0 if unknown */
+func cpuTime() time.Duration {
+	var usage syscall.Rusage
+	if err := syscall.Getrusage(syscall.RUSAGE_SELF, &usage); err != nil {
+		return 0
+	}
+	return time.Duration(usage.Utime.Nano() + usage.Stime.Nano())
+}
+
+/* the second field of statm is the resident set size in pages */
+func residentSetSize() uint64 {
+	content, err := ioutil.ReadFile(procSelfStatm)
+	if err != nil {
+		return 0
+	}
+	fields := strings.Fields(string(content))
+	if len(fields) < 2 {
+		return 0
+	}
+	pages, err := strconv.ParseUint(fields[1], 10, 64)
+	if err != nil {
+		return 0
+	}
+	return pages * uint64(os.Getpagesize())
+}
+
+func (s SelfMetrics) String() string {
+	return fmt.Sprintf("cycle=%v cycleCpu=%v cpu=%v goroutines=%d rss=%d heap=%d gcRuns=%d gcPause=%v",
+		s.CycleDuration, s.CycleCpu, s.Cpu, s.Goroutines, s.RssBytes, s.HeapBytes, s.GcRuns, s.GcPause)
+}
@@ -0,0 +1,66 @@
+// hd-idle - spin down idle hard disks
+// Copyright (C) 2018  Andoni del Olmo
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package hdidle
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestMeasureSelf(t *testing.T) {
+	dir, err := ioutil.TempDir("", "self")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer func(statm string) { procSelfStatm = statm }(procSelfStatm)
+	procSelfStatm = filepath.Join(dir, "statm")
+	if err := ioutil.WriteFile(procSelfStatm, []byte("4521 1200 800 300 0 2100 0\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	config := NewConfig()
+	config.Defaults.SelfMetrics = true
+	m := New(config)
+	m.SetOutput(ioutil.Discard)
+	if _, measured := m.SelfMetrics(); measured {
+		t.Fatal("Expected nothing measured before the first cycle")
+	}
+
+	m.measureSelf(time.Now().Add(-2*time.Second), 0)
+	self, measured := m.SelfMetrics()
+	if !measured {
+		t.Fatal("Expected the metrics measured after a cycle")
+	}
+	if self.CycleDuration < 2*time.Second {
+		t.Fatalf("Expected the cycle to last 2s at least but found %v", self.CycleDuration)
+	}
+	if self.RssBytes != 1200*uint64(os.Getpagesize()) {
+		t.Fatalf("Expected the resident set of statm but found %d", self.RssBytes)
+	}
+	if self.Goroutines == 0 || self.HeapBytes == 0 {
+		t.Fatalf("Expected the runtime statistics but found %v", self)
+	}
+
+	os.Remove(procSelfStatm)
+	if rss := residentSetSize(); rss != 0 {
+		t.Fatalf("Expected an unknown resident set but found %d", rss)
+	}
+}
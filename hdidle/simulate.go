@@ -0,0 +1,97 @@
+// hd-idle - spin down idle hard disks
+// Copyright (C) 2018  Andoni del Olmo
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package hdidle
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var errSimulated = errors.New("simulated failure")
+
+// Simulation injects latency and failures into the commands sent to the
+// disks, to exercise the watchdog and the circuit breaker during
+// development.
+type Simulation struct {
+	Latency  time.Duration // added to every command
+	Jitter   time.Duration // random extra latency up to this
+	Failures int           // percentage of commands failing
+	DryRun   bool          // don't send the commands to the disks
+
+	mu   sync.Mutex
+	rand *rand.Rand
+}
+
+// ParseSimulation parses a comma separated list of latency=<duration>,
+// jitter=<duration>, failures=<percent> and dry-run.
+func ParseSimulation(spec string) (*Simulation, error) {
+	s := &Simulation{rand: rand.New(rand.NewSource(time.Now().UnixNano()))}
+	for _, option := range strings.Split(spec, ",") {
+		kv := strings.SplitN(strings.TrimSpace(option), "=", 2)
+		var err error
+		switch {
+		case kv[0] == "dry-run" && len(kv) == 1:
+			s.DryRun = true
+		case kv[0] == "latency" && len(kv) == 2:
+			s.Latency, err = time.ParseDuration(kv[1])
+		case kv[0] == "jitter" && len(kv) == 2:
+			s.Jitter, err = time.ParseDuration(kv[1])
+		case kv[0] == "failures" && len(kv) == 2:
+			s.Failures, err = strconv.Atoi(kv[1])
+			if err == nil && (s.Failures < 0 || s.Failures > 100) {
+				err = errors.New("must be a percentage")
+			}
+		default:
+			return nil, fmt.Errorf("unknown simulation option %s", option)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("wrong simulation option %s: %s", option, err)
+		}
+	}
+	return s, nil
+}
+
+/* may run on the goroutine of the watchdog, hence the lock around rand */
+func (s *Simulation) wrap(command func() error) func() error {
+	return func() error {
+		s.mu.Lock()
+		delay := s.Latency
+		if s.Jitter > 0 {
+			delay += time.Duration(s.rand.Int63n(int64(s.Jitter)))
+		}
+		fail := s.rand.Intn(100) < s.Failures
+		s.mu.Unlock()
+
+		time.Sleep(delay)
+		if fail {
+			return errSimulated
+		}
+		if s.DryRun {
+			return nil
+		}
+		return command()
+	}
+}
+
+func (s *Simulation) String() string {
+	return fmt.Sprintf("latency=%v jitter=%v failures=%d%% dryRun=%t", s.Latency, s.Jitter, s.Failures, s.DryRun)
+}
@@ -0,0 +1,63 @@
+// hd-idle - spin down idle hard disks
+// Copyright (C) 2018  Andoni del Olmo
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package hdidle
+
+import (
+	"io/ioutil"
+	"testing"
+	"time"
+)
+
+func TestParseSimulation(t *testing.T) {
+	s, err := ParseSimulation("latency=2s, jitter=500ms,failures=30,dry-run")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Latency != 2*time.Second || s.Jitter != 500*time.Millisecond || s.Failures != 30 || !s.DryRun {
+		t.Fatalf("Unexpected simulation %s", s)
+	}
+	for _, spec := range []string{"failures=101", "latency=fast", "hang", "dry-run=1"} {
+		if _, err := ParseSimulation(spec); err == nil {
+			t.Errorf("Expected an error for %s", spec)
+		}
+	}
+}
+
+func TestSimulatedFailuresOpenBreaker(t *testing.T) {
+	simulation, err := ParseSimulation("failures=100")
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := NewConfig()
+	config.Defaults.Simulation = simulation
+	config.Defaults.BreakerThreshold = 2
+	m := New(config)
+	m.SetOutput(ioutil.Discard)
+
+	sent := 0
+	for i := 0; i < 2; i++ {
+		if err := m.deviceCommand("sda", func() error { sent++; return nil }); err != errSimulated {
+			t.Fatalf("Expected a simulated failure but found %v", err)
+		}
+	}
+	if sent != 0 {
+		t.Fatalf("Expected no command sent but found %d", sent)
+	}
+	if !m.quarantined("sda") {
+		t.Fatal("Expected the disk to be quarantined")
+	}
+}
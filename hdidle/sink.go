@@ -0,0 +1,168 @@
+// hd-idle - spin down idle hard disks
+// Copyright (C) 2018  Andoni del Olmo
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package hdidle
+
+import (
+	"sync"
+	"time"
+)
+
+const DefaultSinkQueueSize = 100
+
+// Sink receives the events of a monitor, e.g. to forward them to a webhook.
+// Deliver may be slow or fail: it runs in its own goroutine, never in the
+// observation loop.
+type Sink interface {
+	Deliver(event Event) error
+}
+
+// SinkStats tells how the delivery of events to a sink is going.
+type SinkStats struct {
+	Name        string
+	Queued      int
+	Delivered   uint64
+	Failed      uint64
+	Dropped     uint64 // oldest events discarded because the queue was full
+	LastError   string
+	LastErrorAt time.Time
+}
+
+type sinkQueue struct {
+	name    string
+	sink    Sink
+	crashed func(p *panicError)
+	size    int
+	wake    chan struct{}
+	quit    chan struct{}
+
+	mu     sync.Mutex
+	events []Event
+	stats  SinkStats
+}
+
+// AddSink delivers the events to the sink through a queue of the given size.
+// When the sink falls behind the oldest queued events are dropped.
+func (m *Monitor) AddSink(name string, sink Sink, queueSize int) {
+	if queueSize < 1 {
+		queueSize = DefaultSinkQueueSize
+	}
+	q := &sinkQueue{
+		name:  name,
+		sink:  sink,
+		size:  queueSize,
+		wake:  make(chan struct{}, 1),
+		quit:  make(chan struct{}),
+		stats: SinkStats{Name: name},
+		crashed: func(p *panicError) {
+			m.mu.Lock()
+			defer m.mu.Unlock()
+			m.reportCrash("sink "+name, "", p)
+		},
+	}
+	m.subscribersMu.Lock()
+	m.sinks = append(m.sinks, q)
+	m.subscribersMu.Unlock()
+	go q.run(m.stop)
+}
+
+// RemoveSink stops delivering events to the sink added with the given name.
+// Events still queued for it are discarded. The other sinks keep their queues
+// and statistics.
+func (m *Monitor) RemoveSink(name string) bool {
+	m.subscribersMu.Lock()
+	defer m.subscribersMu.Unlock()
+	for i, q := range m.sinks {
+		if q.name == name {
+			m.sinks = append(m.sinks[:i:i], m.sinks[i+1:]...)
+			close(q.quit)
+			return true
+		}
+	}
+	return false
+}
+
+// SinkStats returns the delivery statistics of every sink.
+func (m *Monitor) SinkStats() []SinkStats {
+	m.subscribersMu.Lock()
+	defer m.subscribersMu.Unlock()
+	var stats []SinkStats
+	for _, q := range m.sinks {
+		q.mu.Lock()
+		s := q.stats
+		s.Queued = len(q.events)
+		q.mu.Unlock()
+		stats = append(stats, s)
+	}
+	return stats
+}
+
+func (q *sinkQueue) push(event Event) {
+	q.mu.Lock()
+	if len(q.events) == q.size {
+		q.events = q.events[1:]
+		q.stats.Dropped++
+	}
+	q.events = append(q.events, event)
+	q.mu.Unlock()
+
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (q *sinkQueue) pop() (Event, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if len(q.events) == 0 {
+		return Event{}, false
+	}
+	event := q.events[0]
+	q.events = q.events[1:]
+	return event, true
+}
+
+func (q *sinkQueue) run(stop <-chan struct{}) {
+	for {
+		select {
+		case <-stop:
+			return
+		case <-q.quit:
+			return
+		case <-q.wake:
+		}
+		for {
+			event, found := q.pop()
+			if !found {
+				break
+			}
+			err := recovered(func() error { return q.sink.Deliver(event) })
+			if p, ok := err.(*panicError); ok {
+				q.crashed(p)
+			}
+			q.mu.Lock()
+			if err != nil {
+				q.stats.Failed++
+				q.stats.LastError = err.Error()
+				q.stats.LastErrorAt = time.Now()
+			} else {
+				q.stats.Delivered++
+			}
+			q.mu.Unlock()
+		}
+	}
+}
@@ -0,0 +1,137 @@
+// hd-idle - spin down idle hard disks
+// Copyright (C) 2018  Andoni del Olmo
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package hdidle
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+type blockingSink struct {
+	release   chan struct{}
+	delivered chan Event
+	err       error
+}
+
+func (s *blockingSink) Deliver(event Event) error {
+	<-s.release
+	s.delivered <- event
+	return s.err
+}
+
+func TestSinkDropsOldestEvents(t *testing.T) {
+	m := New(NewConfig())
+	defer m.Stop()
+	sink := &blockingSink{release: make(chan struct{}), delivered: make(chan Event, 10)}
+	m.AddSink("slow", sink, 2)
+
+	m.emit(EventSpindown, "sda", "")
+	// wait for the first event to be taken by the blocked sink
+	waitFor(t, func() bool { return m.SinkStats()[0].Queued == 0 })
+	m.emit(EventSpindown, "sdb", "")
+	m.emit(EventSpindown, "sdc", "")
+	m.emit(EventSpindown, "sdd", "")
+
+	stats := m.SinkStats()[0]
+	if stats.Queued != 2 || stats.Dropped != 1 {
+		t.Fatalf("Expected 2 queued and 1 dropped but found %+v", stats)
+	}
+
+	close(sink.release)
+	for _, disk := range []string{"sda", "sdc", "sdd"} {
+		if event := <-sink.delivered; event.Disk != disk {
+			t.Fatalf("Expected event of %s but found %s", disk, event.Disk)
+		}
+	}
+	waitFor(t, func() bool { return m.SinkStats()[0].Delivered == 3 })
+}
+
+func TestSinkCountsFailures(t *testing.T) {
+	m := New(NewConfig())
+	defer m.Stop()
+	sink := &blockingSink{release: make(chan struct{}), delivered: make(chan Event, 10), err: errors.New("offline")}
+	close(sink.release)
+	m.AddSink("down", sink, 0)
+
+	m.emit(EventSpinup, "sda", "")
+	waitFor(t, func() bool { return m.SinkStats()[0].Failed == 1 })
+	if stats := m.SinkStats()[0]; stats.LastError != "offline" {
+		t.Fatalf("Expected last error offline but found %s", stats.LastError)
+	}
+}
+
+func TestRemoveSinkKeepsOthers(t *testing.T) {
+	m := New(NewConfig())
+	defer m.Stop()
+	kept := &blockingSink{release: make(chan struct{}), delivered: make(chan Event, 10)}
+	close(kept.release)
+	m.AddSink("kept", kept, 0)
+	removed := &blockingSink{release: make(chan struct{}), delivered: make(chan Event, 10)}
+	close(removed.release)
+	m.AddSink("removed", removed, 0)
+
+	m.emit(EventSpindown, "sda", "")
+	<-kept.delivered
+	<-removed.delivered
+	if !m.RemoveSink("removed") {
+		t.Fatal("Expected sink removed to be found")
+	}
+	if m.RemoveSink("removed") {
+		t.Fatal("Expected sink removed to be gone")
+	}
+
+	m.emit(EventSpinup, "sda", "")
+	<-kept.delivered
+	stats := m.SinkStats()
+	if len(stats) != 1 || stats[0].Name != "kept" {
+		t.Fatalf("Expected only sink kept but found %+v", stats)
+	}
+	waitFor(t, func() bool { return m.SinkStats()[0].Delivered == 2 })
+}
+
+func waitFor(t *testing.T, condition func() bool) {
+	deadline := time.Now().Add(5 * time.Second)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatal("Timeout waiting for condition")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestSubscriptionsClosedOnceRunReturned(t *testing.T) {
+	m := New(NewConfig())
+	before, cancel := m.Subscribe("")
+	defer cancel()
+	m.closeSubscribers()
+	if _, open := <-before; open {
+		t.Fatal("Expected the subscription closed once Run returned")
+	}
+
+	after, cancel := m.Subscribe("sda")
+	defer cancel()
+	select {
+	case _, open := <-after:
+		if open {
+			t.Fatal("Expected no event after Run returned")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected a subscription after Run returned to be closed right away")
+	}
+	m.emit(EventSpindown, "sda", "")
+}
@@ -0,0 +1,45 @@
+// hd-idle - spin down idle hard disks
+// Copyright (C) 2018  Andoni del Olmo
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package hdidle
+
+import "time"
+
+/*
+ * A cycle much longer than the poll interval means the system was suspended,
+ * and the disks spun up on resume. Enclosures sleeping on their own, or
+ * disks that keep spinning through a suspend, get a skew time of their own.
+ */
+func (m *Monitor) slept(name string) bool {
+	skew := m.config.skewTime(name)
+	return skew != SkewDisabled && m.now.Sub(m.lastNow) > skew
+}
+
+// ParseSkew reads the skew time of a disk: a time, or off or 0 for
+// SkewDisabled.
+func ParseSkew(s string) (time.Duration, error) {
+	if s == "off" {
+		return SkewDisabled, nil
+	}
+	skew, err := ParseDuration(s)
+	if err != nil {
+		return 0, err
+	}
+	if skew == 0 {
+		return SkewDisabled, nil
+	}
+	return skew, nil
+}
@@ -0,0 +1,84 @@
+// hd-idle - spin down idle hard disks
+// Copyright (C) 2018  Andoni del Olmo
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package hdidle
+
+import (
+	"github.com/adelolmo/hd-idle/diskstats"
+	"io/ioutil"
+	"testing"
+	"time"
+)
+
+func TestSkewPerDisk(t *testing.T) {
+	simulation, err := ParseSimulation("dry-run")
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := NewConfig()
+	config.Defaults.Simulation = simulation
+	config.SkewTime = time.Minute
+	config.Devices = []DeviceConf{
+		{Name: "sdb", GivenName: "sdb", Idle: time.Hour, CommandType: SCSI, SkewTime: SkewDisabled},
+		{Name: "sdc", GivenName: "sdc", Idle: time.Hour, CommandType: SCSI, SkewTime: 3 * time.Hour},
+	}
+	m := New(config)
+	m.SetOutput(ioutil.Discard)
+
+	start := time.Now()
+	for _, name := range []string{"sda", "sdb", "sdc"} {
+		m.snapshots = append(m.snapshots, diskstats.DiskStats{Name: name, CommandType: SCSI, IdleTime: time.Hour,
+			SpunDown: true, SpinDownAt: start, LastIoAt: start})
+	}
+
+	/* two hours without a cycle */
+	m.lastNow = start
+	m.now = start.Add(2 * time.Hour)
+	for _, name := range []string{"sda", "sdb", "sdc"} {
+		m.updateState(diskstats.DiskStats{Name: name})
+	}
+	if m.snapshots[0].SpunDown {
+		t.Fatal("Expected sda taken for spun up after the global skew time")
+	}
+	if !m.snapshots[1].SpunDown {
+		t.Fatal("Expected sdb left spun down with the skew disabled")
+	}
+	if !m.snapshots[2].SpunDown {
+		t.Fatal("Expected sdc left spun down within its own skew time")
+	}
+}
+
+func TestParseSkew(t *testing.T) {
+	for s, expected := range map[string]time.Duration{
+		"off": SkewDisabled,
+		"0":   SkewDisabled,
+		"300": 5 * time.Minute,
+		"1h":  time.Hour,
+	} {
+		skew, err := ParseSkew(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if skew != expected {
+			t.Errorf("Expected %v for %s but found %v", expected, s, skew)
+		}
+	}
+	for _, s := range []string{"-1", "never", ""} {
+		if _, err := ParseSkew(s); err == nil {
+			t.Errorf("Expected %q to be refused", s)
+		}
+	}
+}
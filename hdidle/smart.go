@@ -0,0 +1,80 @@
+// hd-idle - spin down idle hard disks
+// Copyright (C) 2018  Andoni del Olmo
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package hdidle
+
+import (
+	"fmt"
+	"github.com/adelolmo/hd-idle/sgio"
+	"time"
+)
+
+// SmartStatus is the last SMART data collected from a disk.
+type SmartStatus struct {
+	CollectedAt time.Time
+	Error       string // why the last collection failed, if it did
+	Attributes  []sgio.SmartAttribute
+}
+
+/*
+ * Collect SMART data at most once per interval, and only while the disk is
+ * surely awake: talking to a spun down drive would wake it up.
+ */
+func (m *Monitor) collectSmart(name, command string) {
+	interval := m.config.Defaults.SmartInterval
+	if interval == 0 || command != ATA {
+		return
+	}
+	previous, found := m.smart[name]
+	if found && m.now.Sub(previous.CollectedAt) < interval {
+		return
+	}
+
+	device := fmt.Sprintf("/dev/%s", name)
+	var attributes []sgio.SmartAttribute
+	err := m.runWithWatchdog(name, func() error {
+		var err error
+		attributes, err = sgio.ReadAtaSmart(device)
+		return err
+	})
+	status := SmartStatus{CollectedAt: m.now}
+	if err != nil {
+		/* keep the last good attributes, the error tells they are old */
+		status.Error = err.Error()
+		status.Attributes = previous.Attributes
+		m.printf("Cannot read smart data of %s: %s\n", m.displayName(name), err)
+	} else {
+		status.Attributes = attributes
+	}
+	m.smart[name] = status
+
+	if err == nil && m.debug(name) {
+		m.printf("disk=%s smart collected, %d attributes%s\n", name, len(attributes), smartWear(attributes))
+	}
+}
+
+func smartWear(attributes []sgio.SmartAttribute) string {
+	var wear string
+	for _, attribute := range attributes {
+		switch attribute.ID {
+		case 4:
+			wear += fmt.Sprintf(" startStopCycles=%d", attribute.Raw)
+		case 193:
+			wear += fmt.Sprintf(" loadUnloadCycles=%d", attribute.Raw)
+		}
+	}
+	return wear
+}
@@ -0,0 +1,63 @@
+// hd-idle - spin down idle hard disks
+// Copyright (C) 2018  Andoni del Olmo
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package hdidle
+
+import (
+	"strings"
+)
+
+/*
+ * Drive-managed SMR drives that don't tell in their IDENTIFY data, by model
+ * prefix as in sysfs or IDENTIFY.
+ */
+var smrModels = []string{
+	"WDC WD20EFAX", "WDC WD30EFAX", "WDC WD40EFAX", "WDC WD60EFAX",
+	"WDC WD10SPZX", "WDC WD20SPZX", "WDC WD20EZAZ", "WDC WD60EZAZ",
+	"ST2000DM008", "ST3000DM007", "ST4000DM004", "ST6000DM003", "ST8000DM004",
+	"ST1000LM048", "ST2000LM015", "ST3000LM024", "ST4000LM024", "ST5000LM000",
+	"ST5000AS0011", "ST6000AS0002", "ST8000AS0002",
+	"TOSHIBA DT02ABA", "TOSHIBA MQ04ABD", "TOSHIBA MQ04ABF",
+}
+
+func smrModel(model string) bool {
+	model = strings.ToUpper(model)
+	for _, prefix := range smrModels {
+		if strings.HasPrefix(model, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+/*
+ * Shingled drives rewrite whole bands out of their media cache once the
+ * writes stop. Spun down in the middle of it, they pick it up again at the
+ * next spinup, or some of them spin up on their own to finish it. With
+ * --smr-gc-wait they are left running for that long after their last write,
+ * however long they have been idle.
+ */
+func (m *Monitor) recordWrite(disk string) {
+	if m.media[disk] == MediaSmr {
+		m.lastWriteAt[disk] = m.now
+	}
+}
+
+func (m *Monitor) waitingForGc(disk string) bool {
+	wait := m.config.Defaults.SmrGcWait
+	writtenAt, found := m.lastWriteAt[disk]
+	return wait > 0 && found && m.now.Sub(writtenAt) < wait
+}
@@ -0,0 +1,169 @@
+// hd-idle - spin down idle hard disks
+// Copyright (C) 2018  Andoni del Olmo
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package hdidle
+
+import (
+	"fmt"
+	"github.com/adelolmo/hd-idle/sgio"
+	"io"
+	"time"
+)
+
+// SoakCycle is the outcome of spinning a disk down and up once.
+type SoakCycle struct {
+	Spindown time.Duration
+	Spinup   time.Duration
+	// Verified tells the disk reported standby after the spindown and not
+	// after the spinup. False when either check failed or was not possible.
+	Verified bool
+	Errors   []string
+}
+
+// SoakReport collects the cycles of a soak test.
+type SoakReport struct {
+	Disk        string
+	CommandType string
+	Cycles      []SoakCycle
+}
+
+/* the disk operations of a soak test, replaced in tests */
+type soaker struct {
+	spindown func() error
+	spinup   func() error
+	standby  func() (bool, error)
+	sleep    func(time.Duration, <-chan struct{}) bool
+}
+
+// Soak spins the disk down, checks it reports standby, spins it up and
+// checks it is awake, cycles times with settle time in between, writing the
+// progress to out. It returns early when stop is closed.
+func Soak(disk, command string, cycles int, settle time.Duration, stop <-chan struct{}, out io.Writer) SoakReport {
+	device := fmt.Sprintf("/dev/%s", disk)
+	standby := func() (bool, error) { return sgio.ScsiStopped(device) }
+	if command == ATA {
+		standby = func() (bool, error) { return sgio.AtaStandby(device) }
+	}
+	s := soaker{
+		spindown: func() error { return SpindownDisk(device, command) },
+		spinup:   func() error { return SpinupDisk(device, command) },
+		standby:  standby,
+		sleep:    sleepUnlessStopped,
+	}
+	return s.soak(disk, command, cycles, settle, stop, out)
+}
+
+func (s soaker) soak(disk, command string, cycles int, settle time.Duration, stop <-chan struct{}, out io.Writer) SoakReport {
+	report := SoakReport{Disk: disk, CommandType: command}
+	for i := 1; i <= cycles; i++ {
+		var c SoakCycle
+		c.Verified = true
+
+		start := time.Now()
+		if err := s.spindown(); err != nil {
+			c.Errors = append(c.Errors, fmt.Sprintf("spindown: %s", err))
+			c.Verified = false
+		}
+		c.Spindown = time.Since(start)
+		if !s.sleep(settle, stop) {
+			break
+		}
+		if standby, err := s.standby(); err != nil {
+			c.Errors = append(c.Errors, fmt.Sprintf("standby check: %s", err))
+			c.Verified = false
+		} else if !standby {
+			c.Errors = append(c.Errors, "not in standby after spindown")
+			c.Verified = false
+		}
+
+		start = time.Now()
+		if err := s.spinup(); err != nil {
+			c.Errors = append(c.Errors, fmt.Sprintf("spinup: %s", err))
+			c.Verified = false
+		}
+		c.Spinup = time.Since(start)
+		if standby, err := s.standby(); err != nil {
+			c.Errors = append(c.Errors, fmt.Sprintf("awake check: %s", err))
+			c.Verified = false
+		} else if standby {
+			c.Errors = append(c.Errors, "still in standby after spinup")
+			c.Verified = false
+		}
+
+		report.Cycles = append(report.Cycles, c)
+		fmt.Fprintf(out, "cycle %d/%d spindown=%v spinup=%v verified=%t\n", i, cycles,
+			c.Spindown.Round(time.Millisecond), c.Spinup.Round(time.Millisecond), c.Verified)
+		for _, e := range c.Errors {
+			fmt.Fprintf(out, "  %s\n", e)
+		}
+		if i < cycles && !s.sleep(settle, stop) {
+			break
+		}
+	}
+	return report
+}
+
+func sleepUnlessStopped(d time.Duration, stop <-chan struct{}) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-stop:
+		return false
+	case <-timer.C:
+		return true
+	}
+}
+
+// Failed returns the number of cycles that were not verified.
+func (r SoakReport) Failed() int {
+	failed := 0
+	for _, c := range r.Cycles {
+		if !c.Verified {
+			failed++
+		}
+	}
+	return failed
+}
+
+// Write prints the summary of the soak test.
+func (r SoakReport) Write(out io.Writer) {
+	fmt.Fprintf(out, "disk=%s commandType=%s cycles=%d failed=%d\n", r.Disk, r.CommandType, len(r.Cycles), r.Failed())
+	if len(r.Cycles) == 0 {
+		return
+	}
+	var spindowns, spinups []time.Duration
+	for _, c := range r.Cycles {
+		spindowns = append(spindowns, c.Spindown)
+		spinups = append(spinups, c.Spinup)
+	}
+	fmt.Fprintf(out, "spindown %s\n", durationStats(spindowns))
+	fmt.Fprintf(out, "spinup   %s\n", durationStats(spinups))
+}
+
+func durationStats(durations []time.Duration) string {
+	min, max, total := durations[0], durations[0], time.Duration(0)
+	for _, d := range durations {
+		if d < min {
+			min = d
+		}
+		if d > max {
+			max = d
+		}
+		total += d
+	}
+	avg := total / time.Duration(len(durations))
+	return fmt.Sprintf("min=%v avg=%v max=%v", min.Round(time.Millisecond), avg.Round(time.Millisecond), max.Round(time.Millisecond))
+}
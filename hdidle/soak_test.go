@@ -0,0 +1,75 @@
+// hd-idle - spin down idle hard disks
+// Copyright (C) 2018  Andoni del Olmo
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package hdidle
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"time"
+)
+
+/* a disk whose standby state follows the commands, failing the spindowns listed */
+func fakeSoaker(failSpindowns map[int]bool) soaker {
+	standby, spindowns := false, 0
+	return soaker{
+		spindown: func() error {
+			spindowns++
+			if failSpindowns[spindowns] {
+				return errors.New("bridge timeout")
+			}
+			standby = true
+			return nil
+		},
+		spinup:  func() error { standby = false; return nil },
+		standby: func() (bool, error) { return standby, nil },
+		sleep:   func(time.Duration, <-chan struct{}) bool { return true },
+	}
+}
+
+func TestSoak(t *testing.T) {
+	report := fakeSoaker(map[int]bool{2: true}).soak("sda", ATA, 3, time.Second, nil, ioutil.Discard)
+
+	if len(report.Cycles) != 3 {
+		t.Fatalf("Expected 3 cycles but found %d", len(report.Cycles))
+	}
+	if report.Failed() != 1 {
+		t.Fatalf("Expected 1 failed cycle but found %d", report.Failed())
+	}
+	expected := []string{"spindown: bridge timeout", "not in standby after spindown"}
+	if errs := report.Cycles[1].Errors; strings.Join(errs, "|") != strings.Join(expected, "|") {
+		t.Fatalf("Expected %v but found %v", expected, errs)
+	}
+
+	var out bytes.Buffer
+	report.Write(&out)
+	if !strings.HasPrefix(out.String(), "disk=sda commandType=ata cycles=3 failed=1\n") {
+		t.Fatalf("Unexpected summary %s", out.String())
+	}
+}
+
+func TestSoakStops(t *testing.T) {
+	s := fakeSoaker(nil)
+	s.sleep = func(time.Duration, <-chan struct{}) bool { return false }
+
+	report := s.soak("sda", ATA, 3, time.Second, nil, ioutil.Discard)
+	if len(report.Cycles) != 0 {
+		t.Fatalf("Expected no completed cycle but found %d", len(report.Cycles))
+	}
+}
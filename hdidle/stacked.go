@@ -0,0 +1,90 @@
+// hd-idle - spin down idle hard disks
+// Copyright (C) 2018  Andoni del Olmo
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package hdidle
+
+import (
+	"github.com/adelolmo/hd-idle/diskstats"
+	"github.com/adelolmo/hd-idle/sysfs"
+)
+
+type stackedIo struct {
+	reads  int
+	writes int
+}
+
+/* replaced in tests */
+var (
+	stackedSnapshot = diskstats.StackedSnapshot
+	disksBehind     = sysfs.DisksBehind
+)
+
+/*
+ * A virtual machine using a disk image through a loop device, or an
+ * encrypted volume, may be busy while the page cache keeps its I/O away from
+ * the disk underneath. Count the I/O of loop and device mapper devices as I/O
+ * of the disks holding their data, so those disks are not spun down under a
+ * running guest.
+ */
+func (m *Monitor) chargeStackedIo(snapshot []diskstats.DiskStats) {
+	stacked, err := stackedSnapshot()
+	if err != nil {
+		return
+	}
+	seen := map[string]bool{}
+	for _, device := range stacked {
+		seen[device.Name] = true
+		last, found := m.stacked[device.Name]
+		m.stacked[device.Name] = stackedIo{reads: device.Reads, writes: device.Writes}
+		if !found {
+			continue
+		}
+		/* counters going back belong to a device set up again */
+		reads, writes := device.Reads-last.reads, device.Writes-last.writes
+		if reads <= 0 && writes <= 0 {
+			continue
+		}
+		/* the backing file of a loop device may change, look it up on every use */
+		disks, err := disksBehind(device.Name)
+		if err != nil {
+			continue
+		}
+		for _, disk := range disks {
+			charged := m.stackedIo[disk]
+			if reads > 0 {
+				charged.reads += reads
+			}
+			if writes > 0 {
+				charged.writes += writes
+			}
+			m.stackedIo[disk] = charged
+			if m.debug(disk) {
+				m.printf("disk=%s charged reads=%d writes=%d of %s\n", disk, reads, writes, device.Name)
+			}
+		}
+	}
+	for name := range m.stacked {
+		if !seen[name] {
+			delete(m.stacked, name)
+		}
+	}
+
+	for i := range snapshot {
+		charged := m.stackedIo[snapshot[i].Name]
+		snapshot[i].Reads += charged.reads
+		snapshot[i].Writes += charged.writes
+	}
+}
@@ -0,0 +1,60 @@
+// hd-idle - spin down idle hard disks
+// Copyright (C) 2018  Andoni del Olmo
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package hdidle
+
+import (
+	"github.com/adelolmo/hd-idle/diskstats"
+	"github.com/adelolmo/hd-idle/sysfs"
+	"io/ioutil"
+	"testing"
+)
+
+func TestChargeStackedIo(t *testing.T) {
+	loop := diskstats.DiskStats{Name: "loop0", Reads: 100, Writes: 50}
+	stackedSnapshot = func() ([]diskstats.DiskStats, error) { return []diskstats.DiskStats{loop}, nil }
+	disksBehind = func(name string) ([]string, error) { return []string{"sdb"}, nil }
+	defer func() {
+		stackedSnapshot = diskstats.StackedSnapshot
+		disksBehind = sysfs.DisksBehind
+	}()
+
+	config := NewConfig()
+	config.Defaults.StackedIo = true
+	m := New(config)
+	m.SetOutput(ioutil.Discard)
+
+	snapshot := []diskstats.DiskStats{{Name: "sda", Reads: 10, Writes: 10}, {Name: "sdb", Reads: 10, Writes: 10}}
+	m.chargeStackedIo(snapshot)
+	if snapshot[1].Reads != 10 || snapshot[1].Writes != 10 {
+		t.Fatalf("Expected nothing charged on the first sight of the loop device but found %+v", snapshot[1])
+	}
+
+	loop.Reads, loop.Writes = 130, 50
+	snapshot = []diskstats.DiskStats{{Name: "sda", Reads: 10, Writes: 10}, {Name: "sdb", Reads: 10, Writes: 10}}
+	m.chargeStackedIo(snapshot)
+	if snapshot[0].Reads != 10 || snapshot[1].Reads != 40 || snapshot[1].Writes != 10 {
+		t.Fatalf("Expected the loop reads charged to sdb only but found %+v", snapshot)
+	}
+
+	/* the device set up again starts over, what was charged stays */
+	loop.Reads, loop.Writes = 0, 0
+	snapshot = []diskstats.DiskStats{{Name: "sdb", Reads: 10, Writes: 10}}
+	m.chargeStackedIo(snapshot)
+	if snapshot[0].Reads != 40 || snapshot[0].Writes != 10 {
+		t.Fatalf("Expected no activity from the reset counters but found %+v", snapshot[0])
+	}
+}
@@ -0,0 +1,111 @@
+// hd-idle - spin down idle hard disks
+// Copyright (C) 2018  Andoni del Olmo
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package hdidle
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+/* JSON files in the state directory, kept across restarts */
+
+const backupSuffix = ".bak"
+
+func (m *Monitor) readState(name string, v interface{}) error {
+	data, err := ioutil.ReadFile(filepath.Join(m.config.Defaults.StateDir, name))
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+/*
+ * Replaced atomically, so a crash never leaves half a file behind. The data
+ * is synced before the rename, otherwise a power loss may leave an empty file
+ * under the new name, and the directory after it, otherwise the rename itself
+ * may be lost.
+ */
+func (m *Monitor) writeState(name string, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(m.config.Defaults.StateDir, 0755); err != nil {
+		return err
+	}
+	file := filepath.Join(m.config.Defaults.StateDir, name)
+	tmp := file + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, file); err != nil {
+		return err
+	}
+	return syncDir(m.config.Defaults.StateDir)
+}
+
+/* keep the current file with the backup suffix, nothing to do if there is none */
+func (m *Monitor) backupState(name string) error {
+	err := m.renameState(name, name+backupSuffix)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+func (m *Monitor) renameState(name, newName string) error {
+	dir := m.config.Defaults.StateDir
+	if err := os.Rename(filepath.Join(dir, name), filepath.Join(dir, newName)); err != nil {
+		return err
+	}
+	return syncDir(dir)
+}
+
+/* the entries of the directory, e.g. a file renamed into it, reach the disk */
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	if err := d.Sync(); err != nil {
+		d.Close()
+		return err
+	}
+	return d.Close()
+}
+
+func (m *Monitor) removeState(name string) error {
+	err := os.Remove(filepath.Join(m.config.Defaults.StateDir, name))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
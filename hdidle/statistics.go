@@ -0,0 +1,209 @@
+// hd-idle - spin down idle hard disks
+// Copyright (C) 2018  Andoni del Olmo
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package hdidle
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+)
+
+const statisticsStateFile = "statistics.json"
+
+/* replaced in tests */
+var diskStandby = DiskStandby
+
+// DiskStatistics are the long-term counters of a disk. With a checkpoint
+// interval they are kept across restarts, by serial number. Spindowns are
+// the stop commands the disk accepted, out of SpindownAttempts. Of those,
+// SpindownsVerified were confirmed by the disk reporting standby or by the
+// power meter, so enclosures that acknowledge a stop without passing it on
+// stand out.
+type DiskStatistics struct {
+	Since             time.Time     `json:"since"`
+	SpindownAttempts  int           `json:"spindown_attempts"`
+	Spindowns         int           `json:"spindowns"`
+	SpindownsVerified int           `json:"spindowns_verified"`
+	Spinups           int           `json:"spinups"`
+	SpunDownTime      time.Duration `json:"spun_down_time"`
+}
+
+/*
+ * The checksum covers the disks, so a file torn by a power loss or damaged
+ * on disk is told apart from a valid one.
+ */
+type statisticsState struct {
+	Checksum string          `json:"checksum"`
+	Disks    json.RawMessage `json:"disks"`
+}
+
+var errStatisticsChecksum = errors.New("checksum mismatch")
+
+func (m *Monitor) statisticsOf(name string) *DiskStatistics {
+	key := m.identityKey(name)
+	s, found := m.statistics[key]
+	if !found {
+		s = &DiskStatistics{Since: m.now}
+		m.statistics[key] = s
+	}
+	return s
+}
+
+func (m *Monitor) countSpindownAttempt(name string) {
+	m.statisticsOf(name).SpindownAttempts++
+}
+
+func (m *Monitor) countSpindown(name string) {
+	m.statisticsOf(name).Spindowns++
+}
+
+func (m *Monitor) countSpindownVerified(name string) {
+	m.statisticsOf(name).SpindownsVerified++
+}
+
+/*
+ * Ask the disk whether the accepted stop command brought it to standby.
+ * Disks behind a power meter are verified by the drop of their power draw
+ * instead, disks that cannot tell are not counted.
+ */
+func (m *Monitor) verifyStandby(name, command string) {
+	if sim := m.config.Defaults.Simulation; sim != nil && sim.DryRun {
+		return
+	}
+	if q := m.quirksFor(name); len(q.CommandType) > 0 {
+		command = q.CommandType
+	}
+	var standby bool
+	err := m.runWithWatchdog(name, func() error {
+		var err error
+		standby, err = diskStandby(fmt.Sprintf("/dev/%s", name), command)
+		return err
+	})
+	switch {
+	case err != nil:
+		if m.debug(name) {
+			m.printf("disk=%s standby not verified: %s\n", name, err)
+		}
+	case standby:
+		m.countSpindownVerified(name)
+	default:
+		message := "the disk does not report standby, it may still be spinning"
+		m.printf("%s spindown unverified, %s\n", m.displayName(name), message)
+		m.emitCode(EventSpindownUnverified, name, "NOT_IN_STANDBY", message)
+		m.logToFile(m.logFileOf(name), fmt.Sprintf("date: %s, time: %s, disk: %s, spindown unverified, %s",
+			m.now.Format("2006-01-02"), m.now.Format("15:04:05"), m.displayName(name), message))
+	}
+}
+
+func (m *Monitor) countSpinup(name string) {
+	m.statisticsOf(name).Spinups++
+}
+
+/* add the time since the last cycle to the disks still spun down, unless the system slept */
+func (m *Monitor) accumulateSpunDownTime() {
+	elapsed := m.now.Sub(m.lastNow)
+	for _, ds := range m.snapshots {
+		if skew := m.config.skewTime(ds.Name); skew > 0 && elapsed > skew {
+			continue
+		}
+		if ds.SpunDown {
+			m.statisticsOf(ds.Name).SpunDownTime += elapsed
+		}
+	}
+}
+
+/*
+ * Save the statistics every checkpoint interval. The previous checkpoint is
+ * kept as a backup, loaded when the latest one turns out to be damaged.
+ */
+func (m *Monitor) checkpointStatistics() {
+	interval := m.config.Defaults.CheckpointInterval
+	if interval == 0 || m.now.Sub(m.checkpointAt) < interval {
+		return
+	}
+	m.writeStatistics()
+}
+
+/* the last checkpoint when hd-idle stops */
+func (m *Monitor) saveStatistics() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.config.Defaults.CheckpointInterval == 0 {
+		return
+	}
+	m.writeStatistics()
+}
+
+func (m *Monitor) writeStatistics() {
+	m.checkpointAt = m.now
+	disks := map[string]DiskStatistics{}
+	for key, s := range m.statistics {
+		disks[key] = *s
+	}
+	data, err := json.Marshal(disks)
+	if err == nil {
+		err = m.backupState(statisticsStateFile)
+	}
+	if err == nil {
+		err = m.writeState(statisticsStateFile, statisticsState{Checksum: checksum(data), Disks: data})
+	}
+	if err != nil {
+		m.printf("Cannot save the statistics: %s\n", err)
+	}
+}
+
+/* the latest valid checkpoint, damaged files are set aside with a .corrupt suffix */
+func (m *Monitor) loadStatistics() {
+	if m.config.Defaults.CheckpointInterval == 0 {
+		return
+	}
+	for _, name := range []string{statisticsStateFile, statisticsStateFile + backupSuffix} {
+		var state statisticsState
+		disks := map[string]DiskStatistics{}
+		err := m.readState(name, &state)
+		if err == nil && checksum(state.Disks) != state.Checksum {
+			err = errStatisticsChecksum
+		}
+		if err == nil {
+			err = json.Unmarshal(state.Disks, &disks)
+		}
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			m.printf("Statistics file %s is damaged, setting it aside: %s\n", name, err)
+			if err := m.renameState(name, name+".corrupt"); err != nil {
+				m.println(err.Error())
+			}
+			continue
+		}
+		for key, s := range disks {
+			s := s
+			m.statistics[key] = &s
+		}
+		return
+	}
+}
+
+func checksum(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,126 @@
+// hd-idle - spin down idle hard disks
+// Copyright (C) 2018  Andoni del Olmo
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package hdidle
+
+import (
+	"github.com/adelolmo/hd-idle/diskstats"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestStatisticsSurviveRestart(t *testing.T) {
+	dir, err := ioutil.TempDir("", "state")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	config := NewConfig()
+	config.Defaults.StateDir = dir
+	config.Defaults.CheckpointInterval = time.Minute
+
+	m := New(config)
+	m.SetOutput(ioutil.Discard)
+	m.identityKeys["sda"] = "WD-1234"
+	m.snapshots = []diskstats.DiskStats{{Name: "sda", SpunDown: true}}
+	m.countSpindown("sda")
+	m.now = m.lastNow.Add(30 * time.Second)
+	m.accumulateSpunDownTime()
+	m.checkpointStatistics()
+	m.countSpinup("sda")
+	m.saveStatistics()
+
+	restarted := New(config)
+	restarted.SetOutput(ioutil.Discard)
+	restarted.loadStatistics()
+	s := restarted.statistics["WD-1234"]
+	if s == nil || s.Spindowns != 1 || s.Spinups != 1 || s.SpunDownTime != 30*time.Second {
+		t.Fatalf("Unexpected statistics %+v", s)
+	}
+}
+
+func TestDamagedStatisticsFallBackToBackup(t *testing.T) {
+	dir, err := ioutil.TempDir("", "state")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	config := NewConfig()
+	config.Defaults.StateDir = dir
+	config.Defaults.CheckpointInterval = time.Minute
+
+	m := New(config)
+	m.SetOutput(ioutil.Discard)
+	m.identityKeys["sda"] = "WD-1234"
+	m.countSpindown("sda")
+	m.saveStatistics()
+	m.countSpindown("sda")
+	m.saveStatistics()
+
+	file := filepath.Join(dir, statisticsStateFile)
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data[len(data)-10] = '7'
+	if err := ioutil.WriteFile(file, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	restarted := New(config)
+	restarted.SetOutput(ioutil.Discard)
+	restarted.loadStatistics()
+	if s := restarted.statistics["WD-1234"]; s == nil || s.Spindowns != 1 {
+		t.Fatalf("Expected the backup with 1 spindown but found %+v", s)
+	}
+	if _, err := os.Stat(file + ".corrupt"); err != nil {
+		t.Fatalf("Expected the damaged file set aside: %s", err)
+	}
+}
+
+func TestSpindownsVerifiedByStandby(t *testing.T) {
+	standby := map[string]bool{"/dev/sdb": true, "/dev/sdc": false}
+	defer func(f func(string, string) (bool, error)) { diskStandby = f }(diskStandby)
+	diskStandby = func(device, command string) (bool, error) {
+		if command != ATA {
+			t.Errorf("Expected the ata power check but found %s", command)
+		}
+		return standby[device], nil
+	}
+
+	m := New(NewConfig())
+	m.SetOutput(ioutil.Discard)
+	events, cancel := m.Subscribe("")
+	defer cancel()
+	for _, name := range []string{"sdb", "sdc"} {
+		m.countSpindownAttempt(name)
+		m.countSpindown(name)
+		m.verifyStandby(name, ATA)
+	}
+
+	if s := m.statisticsOf("sdb"); s.SpindownAttempts != 1 || s.Spindowns != 1 || s.SpindownsVerified != 1 {
+		t.Fatalf("Expected 1 verified spindown of sdb but found %+v", s)
+	}
+	if s := m.statisticsOf("sdc"); s.SpindownAttempts != 1 || s.Spindowns != 1 || s.SpindownsVerified != 0 {
+		t.Fatalf("Expected 1 unverified spindown of sdc but found %+v", s)
+	}
+	if event := <-events; event.Type != EventSpindownUnverified || event.Disk != "sdc" || event.Code != "NOT_IN_STANDBY" {
+		t.Fatalf("Expected sdc unverified but found %+v", event)
+	}
+}
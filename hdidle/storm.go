@@ -0,0 +1,134 @@
+// hd-idle - spin down idle hard disks
+// Copyright (C) 2018  Andoni del Olmo
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package hdidle
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var procRoot = "/proc"
+
+/* programs known for walking whole directory trees */
+var scanners = map[string]bool{
+	"updatedb":        true,
+	"updatedb.mlocat": true, // comm is truncated to 15 characters
+	"plocate-build":   true,
+	"mandb":           true,
+	"find":            true,
+	"du":              true,
+	"rsync":           true,
+	"borg":            true,
+	"restic":          true,
+	"duplicity":       true,
+	"rdiff-backup":    true,
+	"tracker-miner-f": true,
+	"baloo_file":      true,
+	"minidlnad":       true,
+	"btrfs":           true,
+	"smartctl":        true,
+}
+
+type wake struct {
+	disk string
+	at   time.Time
+}
+
+func (m *Monitor) recordWake(name string) {
+	if m.config.Defaults.WakeStormDisks == 0 {
+		return
+	}
+	m.recentWakes = append(m.recentWakes, wake{disk: name, at: m.now})
+}
+
+/*
+ * Many disks waking up within a short window are rarely a coincidence but
+ * a tree walk, e.g. updatedb or a backup. Report them once as a storm with
+ * the suspects, instead of leaving the user to correlate the spin ups.
+ */
+func (m *Monitor) detectWakeStorm() {
+	if m.config.Defaults.WakeStormDisks == 0 {
+		return
+	}
+	window := m.config.Defaults.WakeStormWindow
+	var recent []wake
+	for _, w := range m.recentWakes {
+		if m.now.Sub(w.at) <= window {
+			recent = append(recent, w)
+		}
+	}
+	m.recentWakes = recent
+
+	var disks []string
+	for _, w := range recent {
+		if w.at.After(m.lastStormAt) {
+			disks = appendUnique(disks, m.displayName(w.disk))
+		}
+	}
+	if len(disks) < m.config.Defaults.WakeStormDisks {
+		return
+	}
+	m.lastStormAt = m.now
+
+	suspects := suspectedScanners()
+	message := fmt.Sprintf("%d disks woke up within %v: %s", len(disks), window, strings.Join(disks, ", "))
+	if len(suspects) > 0 {
+		message += "; suspects: " + strings.Join(suspects, ", ")
+	}
+	m.printf("wake storm, %s\n", message)
+	m.emit(EventWakeStorm, "", message)
+	m.logToFile(m.config.Defaults.LogFile, fmt.Sprintf("date: %s, time: %s, wake storm, %s",
+		m.now.Format("2006-01-02"), m.now.Format("15:04:05"), message))
+}
+
+/* running programs known for scanning directory trees, e.g. updatedb (pid 1234) */
+func suspectedScanners() []string {
+	dirs, err := ioutil.ReadDir(procRoot)
+	if err != nil {
+		return nil
+	}
+	var suspects []string
+	for _, dir := range dirs {
+		if _, err := strconv.Atoi(dir.Name()); err != nil {
+			continue
+		}
+		comm, err := ioutil.ReadFile(filepath.Join(procRoot, dir.Name(), "comm"))
+		if err != nil {
+			continue
+		}
+		name := strings.TrimSpace(string(comm))
+		if scanners[name] {
+			suspects = append(suspects, fmt.Sprintf("%s (pid %s)", name, dir.Name()))
+		}
+	}
+	sort.Strings(suspects)
+	return suspects
+}
+
+func appendUnique(list []string, s string) []string {
+	for _, e := range list {
+		if e == s {
+			return list
+		}
+	}
+	return append(list, s)
+}
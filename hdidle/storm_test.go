@@ -0,0 +1,95 @@
+// hd-idle - spin down idle hard disks
+// Copyright (C) 2018  Andoni del Olmo
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package hdidle
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestWakeStorm(t *testing.T) {
+	dir, err := ioutil.TempDir("", "proc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	mustMkdir(t, filepath.Join(dir, "1234"))
+	if err := ioutil.WriteFile(filepath.Join(dir, "1234", "comm"), []byte("updatedb\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	procRoot = dir
+	defer func() { procRoot = "/proc" }()
+
+	config := NewConfig()
+	config.Defaults.WakeStormDisks = 2
+	m := New(config)
+	m.SetOutput(ioutil.Discard)
+	events, cancel := m.Subscribe("")
+	defer cancel()
+
+	start := time.Now()
+	m.now = start
+	m.recordWake("sda")
+	m.detectWakeStorm()
+	m.now = start.Add(30 * time.Second)
+	m.recordWake("sdb")
+	m.detectWakeStorm()
+
+	event := <-events
+	if event.Type != EventWakeStorm {
+		t.Fatalf("Expected a wake storm but found %s", event.Type)
+	}
+	expected := "2 disks woke up within 1m0s: sda, sdb; suspects: updatedb (pid 1234)"
+	if event.Message != expected {
+		t.Fatalf("Expected %q but found %q", expected, event.Message)
+	}
+
+	m.now = start.Add(40 * time.Second)
+	m.recordWake("sdc")
+	m.detectWakeStorm()
+	select {
+	case event := <-events:
+		t.Fatalf("Expected the storm to be reported once but found %+v", event)
+	default:
+	}
+}
+
+func TestNoWakeStormOutsideWindow(t *testing.T) {
+	config := NewConfig()
+	config.Defaults.WakeStormDisks = 2
+	m := New(config)
+	m.SetOutput(ioutil.Discard)
+	events, cancel := m.Subscribe("")
+	defer cancel()
+
+	start := time.Now()
+	m.now = start
+	m.recordWake("sda")
+	m.detectWakeStorm()
+	m.now = start.Add(2 * time.Minute)
+	m.recordWake("sdb")
+	m.detectWakeStorm()
+
+	select {
+	case event := <-events:
+		t.Fatalf("Expected no wake storm but found %+v", event)
+	default:
+	}
+}
@@ -0,0 +1,101 @@
+// hd-idle - spin down idle hard disks
+// Copyright (C) 2018  Andoni del Olmo
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package hdidle
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+/*
+ * Tags are key/value pairs of the user on a disk, e.g. location=rack2,
+ * owner=alice or tier=cold. hd-idle only attaches them to the events and the
+ * status of the disk, for filtering and reporting downstream.
+ */
+
+var (
+	tagKey   = regexp.MustCompile(`^[a-z][a-z0-9_]*$`)
+	tagValue = regexp.MustCompile(`^[A-Za-z0-9._:/@+-]+$`)
+)
+
+// ParseTag splits a tag given as key=value. The key is lowercase letters,
+// digits and underscores, starting with a letter, so it can become a
+// metric label.
+func ParseTag(tag string) (string, string, error) {
+	i := strings.Index(tag, "=")
+	if i < 0 {
+		return "", "", fmt.Errorf("must be <key>=<value>, e.g. location=rack2")
+	}
+	key, value := tag[:i], tag[i+1:]
+	if !tagKey.MatchString(key) {
+		return "", "", fmt.Errorf("the key must be lowercase letters, digits and underscores, starting with a letter")
+	}
+	if len(value) == 0 || strings.ContainsAny(value, "\n\r") {
+		return "", "", fmt.Errorf("the value must not be empty or span lines")
+	}
+	return key, value, nil
+}
+
+func (m *Monitor) tagsOf(disk string) map[string]string {
+	if len(disk) == 0 {
+		return nil
+	}
+	return m.config.deviceConfig(disk).Tags
+}
+
+/* set in a copy, the map may be shared with a disk of the configuration file */
+func withTag(tags map[string]string, key, value string) map[string]string {
+	copied := map[string]string{}
+	for k, v := range tags {
+		copied[k] = v
+	}
+	copied[key] = value
+	return copied
+}
+
+func tagKeys(tags map[string]string) []string {
+	var keys []string
+	for key := range tags {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func formatTags(tags map[string]string) string {
+	var formatted []string
+	for _, key := range tagKeys(tags) {
+		formatted = append(formatted, key+"="+tags[key])
+	}
+	return "[" + strings.Join(formatted, " ") + "]"
+}
+
+/* tag_location=rack2, quoted unless it is a single word */
+func tagFields(tags map[string]string) []string {
+	var fields []string
+	for _, key := range tagKeys(tags) {
+		value := tags[key]
+		if !tagValue.MatchString(value) {
+			value = strconv.Quote(value)
+		}
+		fields = append(fields, "tag_"+key+"="+value)
+	}
+	return fields
+}
@@ -0,0 +1,57 @@
+// hd-idle - spin down idle hard disks
+// Copyright (C) 2018  Andoni del Olmo
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package hdidle
+
+import (
+	"github.com/adelolmo/hd-idle/diskstats"
+	"io/ioutil"
+	"testing"
+)
+
+func TestTagsOnEventsAndStatus(t *testing.T) {
+	config := NewConfig()
+	config.Devices = []DeviceConf{{Name: "sdb", GivenName: "sdb", Tags: map[string]string{"location": "rack2"}}}
+	m := New(config)
+	m.SetOutput(ioutil.Discard)
+	m.snapshots = []diskstats.DiskStats{{Name: "sdb"}, {Name: "sdc"}}
+	events, cancel := m.Subscribe("")
+	defer cancel()
+
+	m.emit(EventSpinup, "sdb", "")
+	m.emit(EventSpinup, "sdc", "")
+	if event := <-events; event.Tags["location"] != "rack2" {
+		t.Fatalf("Expected the tags of sdb on its event but found %+v", event)
+	}
+	if event := <-events; len(event.Tags) != 0 {
+		t.Fatalf("Expected no tags on the event of sdc but found %+v", event)
+	}
+	status := m.Status()
+	if status[0].Tags["location"] != "rack2" || len(status[1].Tags) != 0 {
+		t.Fatalf("Expected the tags of sdb in its status but found %+v", status)
+	}
+}
+
+func TestParseTag(t *testing.T) {
+	if key, value, err := ParseTag("owner=alice=bob"); err != nil || key != "owner" || value != "alice=bob" {
+		t.Fatalf("Unexpected tag %s=%s: %v", key, value, err)
+	}
+	for _, tag := range []string{"owner", "=alice", "Owner=alice", "1st=alice", "owner="} {
+		if _, _, err := ParseTag(tag); err == nil {
+			t.Errorf("Expected %s refused", tag)
+		}
+	}
+}
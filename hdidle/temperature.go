@@ -0,0 +1,54 @@
+// hd-idle - spin down idle hard disks
+// Copyright (C) 2018  Andoni del Olmo
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package hdidle
+
+import (
+	"fmt"
+	"github.com/adelolmo/hd-idle/sysfs"
+)
+
+/*
+ * Temperature of the disk from the kernel drivetemp module. Reading it makes
+ * drivetemp talk to the drive, so it is only read while the disk is surely
+ * awake. Otherwise the last known value is used. A disk without hwmon input
+ * is looked up again on the next read, since drivetemp may be loaded later.
+ */
+func (m *Monitor) readTemperature(disk string) {
+	input, found := m.temperatureInputs[disk]
+	if !found {
+		var err error
+		if input, err = sysfs.TemperatureInput(disk); err != nil {
+			return
+		}
+		m.temperatureInputs[disk] = input
+	}
+	celsius, err := sysfs.ReadTemperature(input)
+	if err != nil {
+		/* drivetemp was unloaded or the hwmon device renumbered */
+		delete(m.temperatureInputs, disk)
+		return
+	}
+	m.temperatures[disk] = celsius
+}
+
+func (m *Monitor) temperature(disk string) string {
+	celsius, found := m.temperatures[disk]
+	if !found {
+		return "n/a"
+	}
+	return fmt.Sprintf("%.1fC", celsius)
+}
@@ -0,0 +1,64 @@
+// hd-idle - spin down idle hard disks
+// Copyright (C) 2018  Andoni del Olmo
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package hdidle
+
+import (
+	"github.com/adelolmo/hd-idle/diskstats"
+	"github.com/adelolmo/hd-idle/sysfs"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestTemperatureOfUnpluggedDiskForgotten(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sysfs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer func(root string) { sysfs.Root = root }(sysfs.Root)
+	sysfs.Root = dir
+
+	m := New(NewConfig())
+	m.SetOutput(ioutil.Discard)
+	m.snapshots = []diskstats.DiskStats{{Name: "sda"}}
+
+	m.readTemperature("sda")
+	if temperature := m.temperature("sda"); temperature != "n/a" {
+		t.Fatalf("Expected n/a without drivetemp but found %s", temperature)
+	}
+
+	/* drivetemp loaded after the first read */
+	hwmon := filepath.Join(dir, "block", "sda", "device", "hwmon", "hwmon0")
+	mustMkdir(t, hwmon)
+	if err := ioutil.WriteFile(filepath.Join(hwmon, "temp1_input"), []byte("41000\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	m.readTemperature("sda")
+	if temperature := m.temperature("sda"); temperature != "41.0C" {
+		t.Fatalf("Expected 41.0C but found %s", temperature)
+	}
+
+	m.removeUnpluggedDisks(nil)
+	if _, found := m.temperatureInputs["sda"]; found {
+		t.Fatalf("Expected the hwmon input of the unplugged disk to be forgotten")
+	}
+	if temperature := m.temperature("sda"); temperature != "n/a" {
+		t.Fatalf("Expected n/a for the unplugged disk but found %s", temperature)
+	}
+}
@@ -0,0 +1,175 @@
+// hd-idle - spin down idle hard disks
+// Copyright (C) 2018  Andoni del Olmo
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package hdidle
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Start-stop cycles a desktop disk is typically rated for, used to tell how
+// fast a configuration wears a disk out.
+const RatedStartStopCycles = 50000
+
+// TraceEntry tells a disk had I/O in the monitoring cycle at the given time.
+// Traces are recorded with --trace, one "<RFC 3339 time> <disk>" per line.
+type TraceEntry struct {
+	Time time.Time
+	Disk string
+}
+
+// ReadTrace parses a trace, sorted by time.
+func ReadTrace(r io.Reader) ([]TraceEntry, error) {
+	var entries []TraceEntry
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("line %d: expected <time> <disk>", line)
+		}
+		t, err := time.Parse(time.RFC3339, fields[0])
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %s", line, err)
+		}
+		entries = append(entries, TraceEntry{Time: t, Disk: fields[1]})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	sort.SliceStable(entries, func(i, j int) bool { return entries[i].Time.Before(entries[j].Time) })
+	return entries, nil
+}
+
+func (m *Monitor) recordTrace(name string) {
+	if len(m.config.Defaults.TraceFile) == 0 {
+		return
+	}
+	m.logToFile(m.config.Defaults.TraceFile, m.now.Format(time.RFC3339)+" "+name)
+}
+
+// TraceResult is what a configuration would have done to a disk during a
+// trace.
+type TraceResult struct {
+	Disk         string
+	Idle         time.Duration
+	SpunDownTime time.Duration
+	Spindowns    int
+	Spinups      int
+}
+
+// CyclesPerYear extrapolates the spindowns to a year of the given length of
+// trace.
+func (r TraceResult) CyclesPerYear(length time.Duration) float64 {
+	if length <= 0 {
+		return 0
+	}
+	return float64(r.Spindowns) * float64(365*24*time.Hour) / float64(length)
+}
+
+/*
+ * Replay the trace against the idle times of the configuration: a disk is
+ * spun down in the first cycle its idle time is exceeded and spun up by its
+ * next I/O, as the monitor does. Disks start awake and the trace ends with
+ * its last entry.
+ */
+func SimulateTrace(trace []TraceEntry, config *Config) []TraceResult {
+	if len(trace) == 0 {
+		return nil
+	}
+	start, end := trace[0].Time, trace[len(trace)-1].Time
+	interval := PollInterval(config.Devices)
+	accesses := map[string][]time.Time{}
+	var disks []string
+	for _, e := range trace {
+		if _, found := accesses[e.Disk]; !found {
+			disks = append(disks, e.Disk)
+		}
+		accesses[e.Disk] = append(accesses[e.Disk], e.Time)
+	}
+	sort.Strings(disks)
+
+	var results []TraceResult
+	for _, disk := range disks {
+		r := TraceResult{Disk: disk, Idle: config.deviceConfig(disk).Idle}
+		last := start
+		for _, next := range accesses[disk] {
+			if spindown, ok := spindownTime(start, last, r.Idle, interval); ok && spindown.Before(next) {
+				r.Spindowns++
+				r.Spinups++
+				r.SpunDownTime += next.Sub(spindown)
+			}
+			last = next
+		}
+		if spindown, ok := spindownTime(start, last, r.Idle, interval); ok && spindown.Before(end) {
+			r.Spindowns++
+			r.SpunDownTime += end.Sub(spindown)
+		}
+		results = append(results, r)
+	}
+	return results
+}
+
+/* the first cycle after the idle time is exceeded, cycles start with the trace */
+func spindownTime(start, lastIo time.Time, idle, interval time.Duration) (time.Time, bool) {
+	if idle == 0 {
+		return time.Time{}, false
+	}
+	due := lastIo.Add(idle)
+	cycles := due.Sub(start)/interval + 1
+	return start.Add(cycles * interval), true
+}
+
+// WriteTraceComparison writes what configurations a and b would have done
+// during a trace of the given length, side by side.
+func WriteTraceComparison(out io.Writer, length time.Duration, a, b []TraceResult) {
+	fmt.Fprintf(out, "trace of %.1f days\n", length.Hours()/24)
+	fmt.Fprintf(out, "%-10s %-16s %-22s %-16s %-16s %s\n",
+		"disk", "idle", "spun down hours", "spindowns", "spinups", "cycles/year")
+	var totalA, totalB TraceResult
+	for i := range a {
+		ra, rb := a[i], b[i]
+		fmt.Fprintf(out, "%-10s %-16s %-22s %-16s %-16s %s\n", ra.Disk,
+			fmt.Sprintf("%v -> %v", ra.Idle, rb.Idle),
+			fmt.Sprintf("%.1f -> %.1f", ra.SpunDownTime.Hours(), rb.SpunDownTime.Hours()),
+			fmt.Sprintf("%d -> %d", ra.Spindowns, rb.Spindowns),
+			fmt.Sprintf("%d -> %d", ra.Spinups, rb.Spinups),
+			fmt.Sprintf("%.0f -> %.0f", ra.CyclesPerYear(length), rb.CyclesPerYear(length)))
+		totalA.SpunDownTime += ra.SpunDownTime
+		totalB.SpunDownTime += rb.SpunDownTime
+		totalA.Spindowns += ra.Spindowns
+		totalB.Spindowns += rb.Spindowns
+	}
+	fmt.Fprintf(out, "total spun down hours %.1f -> %.1f, spindowns %d -> %d\n",
+		totalA.SpunDownTime.Hours(), totalB.SpunDownTime.Hours(), totalA.Spindowns, totalB.Spindowns)
+	for i := range a {
+		ra, rb := a[i], b[i]
+		if rb.Spindowns == 0 || rb.Spindowns <= ra.Spindowns {
+			continue
+		}
+		if years := float64(RatedStartStopCycles) / rb.CyclesPerYear(length); years < 5 {
+			fmt.Fprintf(out, "warning: with b %s reaches %d start-stop cycles in %.1f years\n",
+				rb.Disk, RatedStartStopCycles, years)
+		}
+	}
+}
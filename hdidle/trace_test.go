@@ -0,0 +1,76 @@
+// hd-idle - spin down idle hard disks
+// Copyright (C) 2018  Andoni del Olmo
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package hdidle
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+const trace = `2024-03-01T01:00:00Z sda
+2024-03-01T00:00:00Z sda
+# sdb only wakes at the end
+2024-03-01T00:05:00Z sda
+
+2024-03-01T02:00:00Z sdb
+`
+
+func TestReadTrace(t *testing.T) {
+	entries, err := ReadTrace(strings.NewReader(trace))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 4 || entries[0].Disk != "sda" || entries[0].Time.Hour() != 0 || entries[3].Disk != "sdb" {
+		t.Fatalf("Unexpected entries %v", entries)
+	}
+	if _, err := ReadTrace(strings.NewReader("yesterday sda\n")); err == nil {
+		t.Fatal("Expected an error for a wrong time")
+	}
+}
+
+func TestSimulateTrace(t *testing.T) {
+	entries, err := ReadTrace(strings.NewReader(trace))
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := SimulateTrace(entries, NewConfig())
+	if len(a) != 2 {
+		t.Fatalf("Expected 2 disks but found %v", a)
+	}
+	/* idle from 00:05 and from 01:00, spun down in the cycle after 10 minutes */
+	if a[0].Spindowns != 2 || a[0].Spinups != 1 || a[0].SpunDownTime != 93*time.Minute {
+		t.Fatalf("Unexpected result for sda %+v", a[0])
+	}
+	if a[1].Spindowns != 1 || a[1].Spinups != 1 || a[1].SpunDownTime != 109*time.Minute {
+		t.Fatalf("Unexpected result for sdb %+v", a[1])
+	}
+
+	config := NewConfig()
+	config.Devices = []DeviceConf{{Name: "sda", Idle: 0}}
+	b := SimulateTrace(entries, config)
+	if b[0].Spindowns != 0 || b[0].SpunDownTime != 0 {
+		t.Fatalf("Expected sda to stay awake but found %+v", b[0])
+	}
+
+	var out bytes.Buffer
+	WriteTraceComparison(&out, 2*time.Hour, a, b)
+	if !strings.Contains(out.String(), "1.6 -> 0.0") {
+		t.Fatalf("Expected the spun down hours side by side but found\n%s", out.String())
+	}
+}
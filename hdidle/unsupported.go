@@ -0,0 +1,101 @@
+// hd-idle - spin down idle hard disks
+// Copyright (C) 2018  Andoni del Olmo
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package hdidle
+
+import (
+	"fmt"
+	"github.com/adelolmo/hd-idle/sgio"
+	"github.com/adelolmo/hd-idle/sysfs"
+	"time"
+)
+
+// What to do with disks rejecting the spindown command.
+const (
+	UnsupportedGiveUp    = "give-up"    // stop sending the command
+	UnsupportedRetry     = "retry"      // keep trying every time the disk is idle
+	UnsupportedRuntimePm = "runtime-pm" // give up and let the kernel suspend the device
+)
+
+/* the disk, or its bridge, rejected the spindown command as unknown */
+type unsupportedError struct {
+	command string
+	device  string
+}
+
+func (e *unsupportedError) Error() string {
+	return fmt.Sprintf("cannot spindown %s disk %s:\n%s\n", e.command, e.device, sgio.ErrCommandNotSupported)
+}
+
+func spindownError(command, device string, err error) error {
+	if err == sgio.ErrCommandNotSupported {
+		return &unsupportedError{command: command, device: device}
+	}
+	return deviceError(err, fmt.Errorf("cannot spindown %s disk %s:\n%s\n", command, device, err.Error()))
+}
+
+/* the runtime power management of a disk before hd-idle enabled it */
+type runtimePm struct {
+	control string
+	delayMs int
+}
+
+/*
+ * Retrying a command the disk doesn't know only fills the log, so remember
+ * the disk can't be spun down and tell it once.
+ */
+func (m *Monitor) markUnsupported(name, command string, idle time.Duration) {
+	policy := m.config.Defaults.Unsupported
+	if policy == UnsupportedRetry {
+		return
+	}
+	reason := fmt.Sprintf("the disk rejects the %s spindown command", command)
+	m.unsupported[name] = reason
+	m.printf("%s %s, not trying again\n", m.displayName(name), reason)
+	m.emitCode(EventSpindownUnsupported, name, "ENOTSUP", reason)
+	if policy == UnsupportedRuntimePm {
+		m.enableRuntimePm(name, idle)
+	}
+}
+
+func (m *Monitor) enableRuntimePm(name string, idle time.Duration) {
+	if _, found := m.runtimePms[name]; found {
+		return
+	}
+	control, delayMs, err := sysfs.DiskRuntimePm(name)
+	if err != nil {
+		m.println(err.Error())
+		return
+	}
+	if err := sysfs.SetDiskRuntimePm(name, "auto", int(idle/time.Millisecond)); err != nil {
+		m.println(err.Error())
+		return
+	}
+	m.runtimePms[name] = runtimePm{control: control, delayMs: delayMs}
+	m.printf("%s runtime power management enabled instead, autosuspend after %v\n", m.displayName(name), idle)
+}
+
+/* leave the disks as found when hd-idle stops */
+func (m *Monitor) restoreAllDiskRuntimePm() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for name, pm := range m.runtimePms {
+		if err := sysfs.SetDiskRuntimePm(name, pm.control, pm.delayMs); err != nil {
+			m.println(err.Error())
+		}
+		delete(m.runtimePms, name)
+	}
+}
@@ -0,0 +1,91 @@
+// hd-idle - spin down idle hard disks
+// Copyright (C) 2018  Andoni del Olmo
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package hdidle
+
+import (
+	"errors"
+	"github.com/adelolmo/hd-idle/sgio"
+	"github.com/adelolmo/hd-idle/sysfs"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSpindownError(t *testing.T) {
+	err := spindownError(ATA, "/dev/sda", sgio.ErrCommandNotSupported)
+	if unsupported, ok := err.(*unsupportedError); !ok || unsupported.command != ATA {
+		t.Fatalf("Expected an unsupported ata command but found %v", err)
+	}
+	if _, ok := spindownError(SCSI, "/dev/sda", errors.New("timeout")).(*unsupportedError); ok {
+		t.Fatal("Expected an ordinary error")
+	}
+}
+
+func TestMarkUnsupportedWithRuntimePm(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sysfs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	root := sysfs.Root
+	sysfs.Root = dir
+	defer func() { sysfs.Root = root }()
+	power := filepath.Join(dir, "block/sda/device/power")
+	mustMkdir(t, power)
+	for name, content := range map[string]string{"control": "on\n", "autosuspend_delay_ms": "-1\n"} {
+		if err := ioutil.WriteFile(filepath.Join(power, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	config := NewConfig()
+	config.Defaults.Unsupported = UnsupportedRuntimePm
+	m := New(config)
+	m.SetOutput(ioutil.Discard)
+	events, cancel := m.Subscribe("sda")
+	defer cancel()
+
+	m.markUnsupported("sda", SCSI, 10*time.Minute)
+	if event := <-events; event.Type != EventSpindownUnsupported {
+		t.Fatalf("Expected %s but found %s", EventSpindownUnsupported, event.Type)
+	}
+	if m.unsupported["sda"] != "the disk rejects the scsi spindown command" {
+		t.Fatalf("Unexpected reason %q", m.unsupported["sda"])
+	}
+	if control, delay, _ := sysfs.DiskRuntimePm("sda"); control != "auto" || delay != 600000 {
+		t.Fatalf("Expected auto/600000 but found %s/%d", control, delay)
+	}
+
+	m.restoreAllDiskRuntimePm()
+	if control, delay, _ := sysfs.DiskRuntimePm("sda"); control != "on" || delay != -1 {
+		t.Fatalf("Expected on/-1 but found %s/%d", control, delay)
+	}
+}
+
+func TestRetryUnsupported(t *testing.T) {
+	config := NewConfig()
+	config.Defaults.Unsupported = UnsupportedRetry
+	m := New(config)
+	m.SetOutput(ioutil.Discard)
+
+	m.markUnsupported("sda", ATA, 10*time.Minute)
+	if _, found := m.unsupported["sda"]; found {
+		t.Fatal("Expected the disk to be retried")
+	}
+}
@@ -0,0 +1,85 @@
+// hd-idle - spin down idle hard disks
+// Copyright (C) 2018  Andoni del Olmo
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package hdidle
+
+import (
+	"github.com/adelolmo/hd-idle/sysfs"
+	"sort"
+	"time"
+)
+
+/* the usb hub of the disk, empty if it isn't attached to usb */
+func (m *Monitor) usbHubOf(name string) string {
+	hub, found := m.usbHubs[name]
+	if !found {
+		hub, _ = sysfs.UsbHub(name)
+		m.usbHubs[name] = hub
+	}
+	return hub
+}
+
+/*
+ * A disk on the same usb hub whose command still hangs, empty if there is
+ * none. Cheap hubs drop commands while another one is in flight.
+ */
+func (m *Monitor) busyUsbSibling(name string) string {
+	if m.config.Defaults.UsbHubSpacing == 0 {
+		return ""
+	}
+	hub := m.usbHubOf(name)
+	if len(hub) == 0 {
+		return ""
+	}
+	var busy []string
+	for disk := range m.stuck {
+		if disk != name && m.usbHubOf(disk) == hub {
+			busy = append(busy, disk)
+		}
+	}
+	if len(busy) == 0 {
+		return ""
+	}
+	sort.Strings(busy)
+	return busy[0]
+}
+
+/* wait until the last command to a disk on the same usb hub is far enough behind */
+func (m *Monitor) waitForUsbHub(name string) {
+	spacing := m.config.Defaults.UsbHubSpacing
+	if spacing == 0 {
+		return
+	}
+	hub := m.usbHubOf(name)
+	if len(hub) == 0 {
+		return
+	}
+	if wait := spacing - time.Since(m.usbHubCommandAt[hub]); wait > 0 {
+		if m.debug(name) {
+			m.printf("disk=%s waiting %v for usb hub %s\n", name, wait, hub)
+		}
+		time.Sleep(wait)
+	}
+}
+
+func (m *Monitor) usbHubCommandDone(name string) {
+	if m.config.Defaults.UsbHubSpacing == 0 {
+		return
+	}
+	if hub := m.usbHubOf(name); len(hub) > 0 {
+		m.usbHubCommandAt[hub] = time.Now()
+	}
+}
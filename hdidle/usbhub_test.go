@@ -0,0 +1,88 @@
+// hd-idle - spin down idle hard disks
+// Copyright (C) 2018  Andoni del Olmo
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package hdidle
+
+import (
+	"github.com/adelolmo/hd-idle/diskstats"
+	"github.com/adelolmo/hd-idle/sysfs"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestUsbHubSpacing(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sysfs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	root := sysfs.Root
+	sysfs.Root = dir
+	defer func() { sysfs.Root = root }()
+
+	mustMkdir(t, filepath.Join(dir, "block"))
+	for disk, port := range map[string]string{"sda": "2-1.1", "sdb": "2-1.2", "sdc": "2-2"} {
+		usb := filepath.Join(dir, "devices/pci0000:00/0000:00:14.0/usb2/2-1", port)
+		if port == "2-2" {
+			usb = filepath.Join(dir, "devices/pci0000:00/0000:00:14.0/usb2", port)
+		}
+		block := filepath.Join(usb, port+":1.0/host0/target0:0:0/0:0:0:0/block", disk)
+		mustMkdir(t, block)
+		if err := ioutil.WriteFile(filepath.Join(usb, "idVendor"), []byte("152d\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Symlink(block, filepath.Join(dir, "block", disk)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	config := NewConfig()
+	config.Defaults.UsbHubSpacing = 50 * time.Millisecond
+	config.Devices = []DeviceConf{{Name: "sdb", GivenName: "sdb", Alias: "parity"}}
+	m := New(config)
+	m.SetOutput(ioutil.Discard)
+
+	m.stuck["sdb"] = true
+	if sibling := m.busyUsbSibling("sda"); sibling != "sdb" {
+		t.Fatalf("Expected sdb busy on the hub of sda but found %q", sibling)
+	}
+	if _, message, _ := m.deferral(diskstats.DiskStats{Name: "sda"}, false, false, 0); message != "usb hub busy with parity (sdb)" {
+		t.Fatalf("Expected the alias of the busy disk but found %q", message)
+	}
+	if sibling := m.busyUsbSibling("sdc"); sibling != "" {
+		t.Fatalf("Expected no busy disk on the hub of sdc but found %s", sibling)
+	}
+
+	if err := m.deviceCommand("sda", func() error { return nil }); err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	if err := m.deviceCommand("sdc", func() error { return nil }); err != nil {
+		t.Fatal(err)
+	}
+	if time.Since(start) >= config.Defaults.UsbHubSpacing {
+		t.Fatal("Expected no wait for a disk on another hub")
+	}
+	if err := m.deviceCommand("sdb", func() error { return nil }); err != nil {
+		t.Fatal(err)
+	}
+	if time.Since(start) < config.Defaults.UsbHubSpacing {
+		t.Fatal("Expected a wait for a disk on the same hub")
+	}
+}
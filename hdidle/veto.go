@@ -0,0 +1,136 @@
+// hd-idle - spin down idle hard disks
+// Copyright (C) 2018  Andoni del Olmo
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package hdidle
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// The actions of the monitor a Vetoer is asked about.
+const (
+	ActionSpindown = "spindown"
+	ActionSpinup   = "spinup" // for an awake window or with --wake-with, not the spin ups caused by I/O
+)
+
+// VetoTimeout is how long the vetoers have to answer, all of them together
+// in a cycle of the monitor. No answer in time is taken for a veto.
+const VetoTimeout = 5 * time.Second
+
+/* replaced in tests */
+var vetoTimeout = VetoTimeout
+
+// Vetoer lets a program embedding the monitor keep it from acting on a disk,
+// e.g. after asking a booking system whether a render job still needs it.
+// Veto is called with the kernel name of the disk, e.g. sdb, and the action
+// about to be taken, and returns an error telling why to veto it, nil to let
+// it go ahead. The context is done once the answer is too late: a vetoer
+// running a program should start it with exec.CommandContext, one asking a
+// server should send the request with the context. It is called from the
+// observation loop: it must not call the monitor. A vetoed spindown is asked
+// about again in the next cycle.
+type Vetoer interface {
+	Veto(ctx context.Context, disk, action string) error
+}
+
+type namedVetoer struct {
+	name   string
+	vetoer Vetoer
+}
+
+// AddVetoer asks the vetoer before every spindown and spinup of the monitor,
+// after the vetoers added before it.
+func (m *Monitor) AddVetoer(name string, vetoer Vetoer) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.vetoers = append(m.vetoers, namedVetoer{name: name, vetoer: vetoer})
+}
+
+// RemoveVetoer stops asking the vetoer added with the given name.
+func (m *Monitor) RemoveVetoer(name string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i, v := range m.vetoers {
+		if v.name == name {
+			m.vetoers = append(m.vetoers[:i], m.vetoers[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+/* whether a vetoer keeps the spinup from being taken, told */
+func (m *Monitor) vetoed(disk, action string) bool {
+	veto := m.veto(disk, action)
+	if len(veto) == 0 {
+		return false
+	}
+	m.printf("%s %s %s\n", m.displayName(disk), action, veto)
+	return true
+}
+
+/*
+ * The vetoers of a cycle answer before a shared deadline, so a slow one holds
+ * the cycle up for VetoTimeout at most however many disks it is asked about.
+ */
+func (m *Monitor) startVetoCycle() context.CancelFunc {
+	ctx, cancel := context.WithTimeout(context.Background(), vetoTimeout)
+	m.vetoDeadline = ctx
+	return func() {
+		cancel()
+		m.vetoDeadline = nil
+	}
+}
+
+/* the vetoer keeping the action from being taken and why, empty if none */
+func (m *Monitor) veto(disk, action string) string {
+	if len(m.vetoers) == 0 {
+		return ""
+	}
+	ctx := m.vetoDeadline
+	if ctx == nil {
+		/* asked outside a cycle */
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(context.Background(), vetoTimeout)
+		defer cancel()
+	}
+	for _, v := range m.vetoers {
+		err := askVetoer(ctx, v.vetoer, disk, action)
+		if err == nil {
+			continue
+		}
+		m.reportPanic("vetoer "+v.name, disk, err)
+		return fmt.Sprintf("vetoed by %s: %s", v.name, err)
+	}
+	return ""
+}
+
+/* the vetoer is told to give up through the context once the deadline passed */
+func askVetoer(ctx context.Context, vetoer Vetoer, disk, action string) error {
+	if ctx.Err() != nil {
+		return fmt.Errorf("no answer within %v", vetoTimeout)
+	}
+	answer := make(chan error, 1)
+	go func() { answer <- recovered(func() error { return vetoer.Veto(ctx, disk, action) }) }()
+	select {
+	case err := <-answer:
+		return err
+	case <-ctx.Done():
+		return fmt.Errorf("no answer within %v", vetoTimeout)
+	}
+}
@@ -0,0 +1,149 @@
+// hd-idle - spin down idle hard disks
+// Copyright (C) 2018  Andoni del Olmo
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package hdidle
+
+import (
+	"context"
+	"errors"
+	"github.com/adelolmo/hd-idle/diskstats"
+	"io/ioutil"
+	"testing"
+	"time"
+)
+
+type bookings map[string]bool
+
+func (b bookings) Veto(ctx context.Context, disk, action string) error {
+	if action == ActionSpindown && b[disk] {
+		return errors.New("booked for a render job")
+	}
+	return nil
+}
+
+func TestVetoedSpindown(t *testing.T) {
+	simulation, err := ParseSimulation("dry-run")
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := NewConfig()
+	config.Defaults.Simulation = simulation
+	config.Defaults.Idle = time.Minute
+	config.SkewTime = 24 * time.Hour
+	m := New(config)
+	m.SetOutput(ioutil.Discard)
+	booked := bookings{"sdb": true}
+	m.AddVetoer("bookings", booked)
+	events, unsubscribe := m.Subscribe("sdb")
+	defer unsubscribe()
+
+	start := time.Now()
+	cycle := func(minutes int) map[string]bool {
+		m.now = start.Add(time.Duration(minutes) * time.Minute)
+		for _, disk := range []string{"sda", "sdb"} {
+			m.updateState(diskstats.DiskStats{Name: disk})
+		}
+		m.lastNow = m.now
+		spunDown := map[string]bool{}
+		for _, ds := range m.snapshots {
+			spunDown[ds.Name] = ds.SpunDown
+		}
+		return spunDown
+	}
+	cycle(0)
+	if spunDown := cycle(2); !spunDown["sda"] || spunDown["sdb"] {
+		t.Fatalf("Expected only sda spun down but found %v", spunDown)
+	}
+	select {
+	case event := <-events:
+		if event.Type != EventSpindownDeferred || event.Code != "VETO" {
+			t.Fatalf("Expected a vetoed spindown but found %+v", event)
+		}
+	default:
+		t.Fatalf("Expected an event for the vetoed spindown")
+	}
+
+	booked["sdb"] = false
+	if spunDown := cycle(3); !spunDown["sdb"] {
+		t.Fatalf("Expected sdb spun down once no longer booked")
+	}
+
+	if !m.RemoveVetoer("bookings") || m.RemoveVetoer("bookings") {
+		t.Fatalf("Expected the vetoer removed once")
+	}
+}
+
+/* answers only once told to give up, like a program started with exec.CommandContext */
+type hungVetoer struct {
+	asked    chan string
+	returned chan string
+}
+
+func (v hungVetoer) Veto(ctx context.Context, disk, action string) error {
+	v.asked <- disk
+	<-ctx.Done()
+	v.returned <- disk
+	return ctx.Err()
+}
+
+func TestVetoersShareTheDeadlineOfTheCycle(t *testing.T) {
+	defer func(timeout time.Duration) { vetoTimeout = timeout }(vetoTimeout)
+	vetoTimeout = 100 * time.Millisecond
+	simulation, err := ParseSimulation("dry-run")
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := NewConfig()
+	config.Defaults.Simulation = simulation
+	config.Defaults.Idle = time.Minute
+	config.SkewTime = 24 * time.Hour
+	m := New(config)
+	m.SetOutput(ioutil.Discard)
+	disks := []string{"sda", "sdb", "sdc", "sdd"}
+	hung := hungVetoer{asked: make(chan string, len(disks)), returned: make(chan string, len(disks))}
+	m.AddVetoer("hung", hung)
+
+	start := time.Now()
+	m.now = start
+	for _, disk := range disks {
+		m.updateState(diskstats.DiskStats{Name: disk})
+	}
+	m.lastNow = m.now
+	m.now = start.Add(2 * time.Minute)
+	begin := time.Now()
+	endCycle := m.startVetoCycle()
+	for _, disk := range disks {
+		m.updateState(diskstats.DiskStats{Name: disk})
+	}
+	endCycle()
+	if elapsed := time.Since(begin); elapsed > 3*vetoTimeout {
+		t.Fatalf("Expected the vetoers to take %v at most for the cycle but took %v", vetoTimeout, elapsed)
+	}
+	for _, ds := range m.snapshots {
+		if ds.SpunDown {
+			t.Fatalf("Expected the spindown of %s vetoed without an answer", ds.Name)
+		}
+	}
+
+	if asked := len(hung.asked); asked != 1 {
+		t.Fatalf("Expected the vetoer asked once before the deadline but was asked %d times", asked)
+	}
+	select {
+	case <-hung.returned:
+	case <-time.After(time.Second):
+		t.Fatal("Expected the vetoer told to give up at the deadline")
+	}
+}
@@ -0,0 +1,67 @@
+// hd-idle - spin down idle hard disks
+// Copyright (C) 2018  Andoni del Olmo
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package hdidle
+
+import (
+	"fmt"
+	"time"
+)
+
+/*
+ * Spin up the disks configured to wake with the given one, e.g. the other
+ * members of a pool or the backup disk of a share, so their spin up overlaps
+ * with the leader's instead of adding to the wait of the next access.
+ * Followers don't wake their own followers.
+ */
+func (m *Monitor) wakeFollowers(leader string) {
+	for i := range m.snapshots {
+		ds := m.snapshots[i]
+		if !ds.SpunDown || !contains(m.config.deviceConfig(ds.Name).WakeWith, leader) {
+			continue
+		}
+		if m.quarantined(ds.Name) || m.stuck[ds.Name] || m.vetoed(ds.Name, ActionSpinup) {
+			continue
+		}
+		m.printf("%s spinup with %s\n", m.displayName(ds.Name), m.displayName(leader))
+		m.resumeHbaOf(ds.Name)
+		m.restoreLinkPower(ds.Name)
+		m.restoreReadAhead(ds.Name)
+		device := fmt.Sprintf("/dev/%s", ds.Name)
+		q := m.quirksFor(ds.Name)
+		start := time.Now()
+		if err := m.deviceCommand(ds.Name, func() error { return spinupWithQuirk(device, ds.CommandType, q) }); err != nil {
+			m.println(err.Error())
+			continue
+		}
+		m.recordWakeLatency(ds.Name, time.Since(start), false)
+		m.countSpinup(ds.Name)
+		m.logSpinup(ds)
+		m.emitCode(EventSpinup, ds.Name, "WAKE_WITH", "woken with "+leader)
+		m.snapshots[i].SpinUpAt = m.now
+		m.snapshots[i].LastIoAt = m.now
+		m.snapshots[i].SpunDown = false
+	}
+}
+
+func contains(names []string, name string) bool {
+	for _, n := range names {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
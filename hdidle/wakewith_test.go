@@ -0,0 +1,59 @@
+// hd-idle - spin down idle hard disks
+// Copyright (C) 2018  Andoni del Olmo
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package hdidle
+
+import (
+	"github.com/adelolmo/hd-idle/diskstats"
+	"io/ioutil"
+	"testing"
+	"time"
+)
+
+func TestWakeFollowers(t *testing.T) {
+	simulation, err := ParseSimulation("dry-run")
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := NewConfig()
+	config.Defaults.Simulation = simulation
+	config.Devices = []DeviceConf{
+		{Name: "sdb", CommandType: SCSI, WakeWith: []string{"sda"}},
+		{Name: "sdc", CommandType: SCSI, WakeWith: []string{"sdb"}},
+	}
+	m := New(config)
+	m.SetOutput(ioutil.Discard)
+	events, cancel := m.Subscribe("sdb")
+	defer cancel()
+
+	m.now = time.Now()
+	m.snapshots = []diskstats.DiskStats{
+		{Name: "sda", SpunDown: true},
+		{Name: "sdb", SpunDown: true},
+		{Name: "sdc", SpunDown: true},
+	}
+	m.wakeFollowers("sda")
+
+	if event := <-events; event.Type != EventSpinup || event.Message != "woken with sda" {
+		t.Fatalf("Expected a spinup with sda but found %+v", event)
+	}
+	if m.snapshots[1].SpunDown || !m.snapshots[1].LastIoAt.Equal(m.now) {
+		t.Fatalf("Expected sdb awake with a fresh idle timer but found %+v", m.snapshots[1])
+	}
+	if !m.snapshots[0].SpunDown || !m.snapshots[2].SpunDown {
+		t.Fatal("Expected only the followers of sda to wake up")
+	}
+}
@@ -0,0 +1,153 @@
+// hd-idle - spin down idle hard disks
+// Copyright (C) 2018  Andoni del Olmo
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package hdidle
+
+import (
+	"fmt"
+	"io"
+	"sync/atomic"
+	"time"
+)
+
+var minCommandTimeout = 30 * time.Second
+
+/* how long a device command may block before the disk is skipped */
+func (m *Monitor) commandTimeout() time.Duration {
+	timeout := time.Duration(m.config.Defaults.WatchdogFactor) * m.interval
+	if timeout < minCommandTimeout {
+		return minCommandTimeout
+	}
+	return timeout
+}
+
+/* run a command to the disk, counting its failures for the circuit breaker */
+func (m *Monitor) deviceCommand(name string, command func() error) error {
+	if m.config.Defaults.Simulation != nil {
+		command = m.config.Defaults.Simulation.wrap(command)
+	}
+	m.waitForUsbHub(name)
+	err := m.runWithWatchdog(name, command)
+	m.usbHubCommandDone(name)
+	m.recordResult(name, err)
+	return err
+}
+
+/*
+ * Run a command that may block forever on a dead device. When it takes too
+ * long the disk is skipped, so the other disks keep being managed, until
+ * the command returns.
+ */
+func (m *Monitor) runWithWatchdog(name string, command func() error) error {
+	if m.config.Defaults.WatchdogFactor == 0 {
+		err := recovered(command)
+		m.reportPanic("command", name, err)
+		return err
+	}
+	result := make(chan error, 1)
+	go func() { result <- recovered(command) }()
+
+	timeout := m.commandTimeout()
+	select {
+	case err := <-result:
+		m.reportPanic("command", name, err)
+		return err
+	case <-time.After(timeout):
+	}
+
+	m.stuck[name] = true
+	message := fmt.Sprintf("no answer after %v, skipping the disk until it answers", timeout)
+	m.printf("CRITICAL %s %s\n", m.displayName(name), message)
+	m.emit(EventDeviceStuck, name, message)
+	go func() {
+		err := <-result
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		delete(m.stuck, name)
+		m.printf("%s answers again\n", m.displayName(name))
+		if err != nil && !m.reportPanic("command", name, err) {
+			m.println(err.Error())
+		}
+		m.emit(EventDeviceRecovered, name, "")
+	}()
+	return fmt.Errorf("%s: %s", name, message)
+}
+
+/* the configuration of the watchdog, copied from the monitor while holding mu */
+type watchdogView struct {
+	interval  time.Duration
+	factor    int
+	logFormat string
+	out       io.Writer
+}
+
+func (m *Monitor) updateWatchdogView() {
+	m.watchdogMu.Lock()
+	defer m.watchdogMu.Unlock()
+	m.watchdogView = watchdogView{
+		interval:  m.interval,
+		factor:    m.config.Defaults.WatchdogFactor,
+		logFormat: m.config.Defaults.LogFormat,
+		out:       m.out,
+	}
+}
+
+func (m *Monitor) currentWatchdogView() watchdogView {
+	m.watchdogMu.Lock()
+	defer m.watchdogMu.Unlock()
+	return m.watchdogView
+}
+
+/*
+ * Report cycles that don't complete, e.g. blocked reading /proc/diskstats.
+ * The stuck cycle may hold mu, so the watchdog never takes it: it works from
+ * its view of the configuration and only publishes the event, the log files
+ * are written by the cycle.
+ */
+func (m *Monitor) watchdog() {
+	view := m.currentWatchdogView()
+	ticker := time.NewTicker(view.interval)
+	defer ticker.Stop()
+	reported := false
+	for {
+		select {
+		case <-m.stop:
+			return
+		case <-ticker.C:
+		}
+		view = m.currentWatchdogView()
+		limit := time.Duration(view.factor) * view.interval
+		if limit < minCommandTimeout {
+			limit = minCommandTimeout
+		}
+		stalled := time.Since(time.Unix(0, atomic.LoadInt64(&m.cycleDoneAt)))
+		if stalled <= limit {
+			reported = false
+			continue
+		}
+		if !reported {
+			message := fmt.Sprintf("observation loop stuck for %v", stalled.Round(time.Second))
+			event := Event{Type: EventLoopStuck, Time: time.Now(), Message: message}
+			if view.logFormat == LogFormatKeyValue {
+				fmt.Fprintln(view.out, event.keyValue())
+			} else {
+				fmt.Fprintf(view.out, "CRITICAL %s\n", message)
+			}
+			m.publish(event)
+			reported = true
+		}
+	}
+}
@@ -0,0 +1,107 @@
+// hd-idle - spin down idle hard disks
+// Copyright (C) 2018  Andoni del Olmo
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package hdidle
+
+import (
+	"io/ioutil"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestDeviceCommandSkipsStuckDisk(t *testing.T) {
+	timeout := minCommandTimeout
+	minCommandTimeout = 10 * time.Millisecond
+	defer func() { minCommandTimeout = timeout }()
+
+	m := New(NewConfig())
+	m.SetOutput(ioutil.Discard)
+	events, cancel := m.Subscribe("sda")
+	defer cancel()
+
+	block := make(chan struct{})
+	m.mu.Lock()
+	err := m.deviceCommand("sda", func() error {
+		<-block
+		return nil
+	})
+	stuck := m.stuck["sda"]
+	m.mu.Unlock()
+	if err == nil || !stuck {
+		t.Fatalf("Expected sda to be stuck, err=%v", err)
+	}
+	if event := <-events; event.Type != EventDeviceStuck {
+		t.Fatalf("Expected %s but found %s", EventDeviceStuck, event.Type)
+	}
+
+	close(block)
+	if event := <-events; event.Type != EventDeviceRecovered {
+		t.Fatalf("Expected %s but found %s", EventDeviceRecovered, event.Type)
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.stuck["sda"] {
+		t.Fatal("Expected sda not to be stuck anymore")
+	}
+}
+
+func TestDeviceCommandWithoutWatchdog(t *testing.T) {
+	config := NewConfig()
+	config.Defaults.WatchdogFactor = 0
+	m := New(config)
+	called := false
+	if err := m.deviceCommand("sda", func() error { called = true; return nil }); err != nil || !called {
+		t.Fatalf("Expected the command to run, err=%v", err)
+	}
+}
+
+func TestWatchdogReportsStuckLoopHoldingLock(t *testing.T) {
+	timeout := minCommandTimeout
+	minCommandTimeout = 20 * time.Millisecond
+	defer func() { minCommandTimeout = timeout }()
+
+	config := NewConfig()
+	config.Defaults.WatchdogFactor = 1
+	config.Defaults.LogFormat = LogFormatKeyValue
+	config.Defaults.LogFile = "/tmp/hd-idle-watchdog-test.log"
+	m := New(config)
+	m.SetOutput(ioutil.Discard)
+	m.mu.Lock()
+	m.interval = 5 * time.Millisecond
+	m.updateWatchdogView()
+	m.mu.Unlock()
+	atomic.StoreInt64(&m.cycleDoneAt, time.Now().Add(-time.Second).UnixNano())
+	events, cancel := m.Subscribe("")
+	defer cancel()
+
+	/* a cycle stuck with the lock held */
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	go m.watchdog()
+	defer close(m.stop)
+	select {
+	case event := <-events:
+		if event.Type != EventLoopStuck {
+			t.Fatalf("Expected %s but found %s", EventLoopStuck, event.Type)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected the stuck loop to be reported while the cycle holds the lock")
+	}
+	if len(m.logSinks) > 0 {
+		t.Fatalf("Expected the watchdog not to write the log files but found %d sinks", len(m.logSinks))
+	}
+}
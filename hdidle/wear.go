@@ -0,0 +1,56 @@
+// hd-idle - spin down idle hard disks
+// Copyright (C) 2018  Andoni del Olmo
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package hdidle
+
+import (
+	"fmt"
+	"github.com/adelolmo/hd-idle/sgio"
+)
+
+/*
+ * Wear counters of the disk regardless of the transport. SCSI disks report
+ * them in the Start-Stop Cycle Counter log page, ATA disks in the SMART
+ * attributes 4 and 193, without the counts they are rated for.
+ */
+func startStopCycles(disk, command string) (*sgio.StartStopCycles, error) {
+	device := fmt.Sprintf("/dev/%s", disk)
+	switch command {
+	case SCSI:
+		return sgio.ScsiStartStopCycles(device)
+	case ATA:
+		return sgio.AtaStartStopCycles(device)
+	}
+	return nil, fmt.Errorf("no start-stop cycle counters for %s disks", command)
+}
+
+func (m *Monitor) logWear(disk, command string) {
+	cycles, err := startStopCycles(disk, command)
+	if err != nil {
+		m.printf("disk=%s startStopCycles=n/a\n", disk)
+		return
+	}
+	m.printf("disk=%s startStopCycles=%s loadUnloadCycles=%s\n", disk,
+		cycleCount(cycles.StartStop, cycles.SpecifiedStartStop), cycleCount(cycles.LoadUnload, cycles.SpecifiedLoadUnload))
+}
+
+/* the count and the one the disk is rated for, if known */
+func cycleCount(count, specified uint32) string {
+	if specified == 0 {
+		return fmt.Sprint(count)
+	}
+	return fmt.Sprintf("%d/%d", count, specified)
+}
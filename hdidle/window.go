@@ -0,0 +1,111 @@
+// hd-idle - spin down idle hard disks
+// Copyright (C) 2018  Andoni del Olmo
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package hdidle
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// AwakeWindow is a daily or weekly time window during which disks are
+// woken up and kept spinning, e.g. for scrubs or backups.
+type AwakeWindow struct {
+	AnyDay  bool
+	Weekday time.Weekday
+	From    time.Duration // since midnight
+	To      time.Duration // since midnight, before From if the window spans midnight
+}
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday, "mon": time.Monday, "tue": time.Tuesday, "wed": time.Wednesday,
+	"thu": time.Thursday, "fri": time.Friday, "sat": time.Saturday,
+}
+
+// ParseAwakeWindow parses windows like "10:00-11:00" (daily) or
+// "Sat 10:00-11:00". Days are given abbreviated or in full.
+func ParseAwakeWindow(s string) (AwakeWindow, error) {
+	window := AwakeWindow{AnyDay: true}
+	fields := strings.Fields(s)
+	if len(fields) == 2 {
+		day, found := time.Weekday(0), false
+		if name := strings.ToLower(fields[0]); len(name) >= 3 {
+			day, found = weekdays[name[:3]]
+			found = found && (len(name) == 3 || name == strings.ToLower(day.String()))
+		}
+		if !found {
+			return window, fmt.Errorf("wrong day %s", fields[0])
+		}
+		window.AnyDay = false
+		window.Weekday = day
+		fields = fields[1:]
+	}
+	if len(fields) != 1 {
+		return window, fmt.Errorf("wrong window %s", s)
+	}
+
+	times := strings.Split(fields[0], "-")
+	if len(times) != 2 {
+		return window, fmt.Errorf("wrong window %s", s)
+	}
+	var err error
+	if window.From, err = parseTimeOfDay(times[0]); err != nil {
+		return window, err
+	}
+	if window.To, err = parseTimeOfDay(times[1]); err != nil {
+		return window, err
+	}
+	return window, nil
+}
+
+func parseTimeOfDay(s string) (time.Duration, error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, fmt.Errorf("wrong time %s. Must be hh:mm", s)
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+func (w AwakeWindow) contains(t time.Time) bool {
+	sinceMidnight := time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute +
+		time.Duration(t.Second())*time.Second
+	today := w.AnyDay || t.Weekday() == w.Weekday
+	if w.From <= w.To {
+		return today && sinceMidnight >= w.From && sinceMidnight < w.To
+	}
+	/* the window spans midnight */
+	yesterday := w.AnyDay || t.Weekday() == (w.Weekday+1)%7
+	return (today && sinceMidnight >= w.From) || (yesterday && sinceMidnight < w.To)
+}
+
+func (w AwakeWindow) String() string {
+	day := ""
+	if !w.AnyDay {
+		day = w.Weekday.String()[:3] + " "
+	}
+	return fmt.Sprintf("%s%02d:%02d-%02d:%02d", day,
+		int(w.From.Hours()), int(w.From.Minutes())%60, int(w.To.Hours()), int(w.To.Minutes())%60)
+}
+
+func inAwakeWindow(windows []AwakeWindow, t time.Time) bool {
+	for _, w := range windows {
+		if w.contains(t) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,91 @@
+// hd-idle - spin down idle hard disks
+// Copyright (C) 2018  Andoni del Olmo
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package hdidle
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseAwakeWindow(t *testing.T) {
+	var tests = []struct {
+		window   string
+		expected string
+	}{
+		{"02:00-04:00", "02:00-04:00"},
+		{"Sat 23:00-01:00", "Sat 23:00-01:00"},
+		{"saturday 10:00-11:00", "Sat 10:00-11:00"},
+		{"  Sun   0:05-6:30 ", "Sun 00:05-06:30"},
+	}
+	for _, test := range tests {
+		window, err := ParseAwakeWindow(test.window)
+		if err != nil {
+			t.Fatalf("%q: unexpected error: %s", test.window, err)
+		}
+		if window.String() != test.expected {
+			t.Fatalf("%q: expected %s but found %s", test.window, test.expected, window)
+		}
+	}
+}
+
+func TestParseMalformedAwakeWindow(t *testing.T) {
+	for _, window := range []string{"", "Sat", "10:00", "10:00-", "-11:00", "10:00-11:00-12:00", "10-11",
+		"25:00-26:00", "10:60-11:00", "Sa 10:00-11:00", "Sunny 10:00-11:00", "Xyz 10:00-11:00",
+		"Sat 10:00 11:00", "Sat Sun 10:00-11:00"} {
+		if _, err := ParseAwakeWindow(window); err == nil {
+			t.Fatalf("Expected an error for %q", window)
+		}
+	}
+}
+
+func TestAwakeWindowContains(t *testing.T) {
+	/* 2026-10-10 is a Saturday */
+	at := func(day, hour, minute int) time.Time {
+		return time.Date(2026, time.October, day, hour, minute, 0, 0, time.Local)
+	}
+	overnight, _ := ParseAwakeWindow("Sat 23:00-01:00")
+	daily, _ := ParseAwakeWindow("22:30-02:00")
+	morning, _ := ParseAwakeWindow("Mon 08:00-09:00")
+	var tests = []struct {
+		window   AwakeWindow
+		time     time.Time
+		expected bool
+	}{
+		{overnight, at(10, 23, 30), true},
+		{overnight, at(11, 0, 30), true},
+		{overnight, at(12, 0, 30), false},
+		{overnight, at(10, 0, 30), false},
+		{overnight, at(10, 22, 59), false},
+		{overnight, at(11, 1, 0), false},
+		{overnight, at(17, 23, 0), true},
+		{daily, at(14, 23, 0), true},
+		{daily, at(15, 1, 59), true},
+		{daily, at(15, 2, 0), false},
+		{daily, at(15, 12, 0), false},
+		{morning, at(12, 8, 0), true},
+		{morning, at(12, 9, 0), false},
+		{morning, at(13, 8, 30), false},
+	}
+	for _, test := range tests {
+		if found := test.window.contains(test.time); found != test.expected {
+			t.Fatalf("%s at %s: expected %t but found %t", test.window, test.time.Format("Mon 15:04"), test.expected, found)
+		}
+	}
+	if !inAwakeWindow([]AwakeWindow{morning, overnight}, at(11, 0, 30)) || inAwakeWindow(nil, at(11, 0, 30)) {
+		t.Fatal("Expected a time in any of the windows to be inside")
+	}
+}
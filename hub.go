@@ -0,0 +1,58 @@
+// hd-idle - spin down idle hard disks
+// Copyright (C) 2018  Andoni del Olmo
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+//go:build !minimal
+// +build !minimal
+
+package main
+
+import (
+	"fmt"
+	"github.com/adelolmo/hd-idle/api"
+	"net/http"
+	"os"
+)
+
+/*
+hd-idle hub --listen <address> [--host <url>]...
+serves the merged status of several hd-idle instances, queried at the given
+urls or pushing to the hub with --push.
+*/
+func hub(args []string) {
+	var listen string
+	var hosts []string
+	for index, arg := range args {
+		switch arg {
+		case "--listen":
+			listen = args[index+1]
+		case "--host":
+			hosts = append(hosts, args[index+1])
+		case "-h":
+			fmt.Println("usage: hd-idle hub --listen <address> [--host <url>]...")
+			os.Exit(0)
+		}
+	}
+	if len(listen) == 0 {
+		fmt.Println("Missing --listen. usage: hd-idle hub --listen <address> [--host <url>]...")
+		os.Exit(1)
+	}
+
+	fmt.Printf("hub listen=%s, hosts=%v\n", listen, hosts)
+	if err := http.ListenAndServe(listen, api.NewHubHandler(api.NewHub(hosts))); err != nil {
+		fmt.Println(err.Error())
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,279 @@
+// hd-idle - spin down idle hard disks
+// Copyright (C) 2018  Andoni del Olmo
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"github.com/adelolmo/hd-idle/hdidle"
+	"github.com/adelolmo/hd-idle/io"
+	"github.com/adelolmo/hd-idle/sysfs"
+	stdio "io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+const (
+	initUsage      = "usage: hd-idle init [-o <file>]"
+	initConfigFile = "/etc/hd-idle.conf"
+	initIdle       = 10 * time.Minute
+)
+
+/* the block devices worth asking about, no partitions, loop or device mapper devices */
+var initDiskRegex = regexp.MustCompile("^(sd[a-z]+|hd[a-z]+|vd[a-z]+|xvd[a-z]+|nvme[0-9]+n[0-9]+|mmcblk[0-9]+)$")
+
+type initDisk struct {
+	disk       string
+	name       string
+	model      string
+	serial     string
+	rotational bool
+	transport  string
+	idle       time.Duration
+	command    string
+}
+
+/*
+hd-idle init [-o <file>]
+lists the disks with their model, serial number, whether they spin and how
+they are attached, asks which ones to manage and with what idle time, writes
+a configuration file and prints the systemd override starting hd-idle with
+it. Empty answers take the default in brackets.
+*/
+func initConfig(args []string, input stdio.Reader, out stdio.Writer) error {
+	file := ""
+	for index := 0; index < len(args); index++ {
+		switch args[index] {
+		case "-o":
+			if index+1 == len(args) {
+				return fmt.Errorf("Missing file for -o. %s", initUsage)
+			}
+			index++
+			file = args[index]
+		case "-h":
+			return errHelp
+		default:
+			return fmt.Errorf("Unknown argument %s. %s", args[index], initUsage)
+		}
+	}
+
+	disks, err := initDisks()
+	if err != nil {
+		return fmt.Errorf("Cannot list the disks: %s", err)
+	}
+	if len(disks) == 0 {
+		return errors.New("No disks found.")
+	}
+
+	in := bufio.NewReader(input)
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "\tdisk\tmodel\tserial\trotational\ttransport")
+	var spinning []string
+	for i, d := range disks {
+		fmt.Fprintf(w, "%d)\t%s\t%s\t%s\t%t\t%s\n", i+1, d.disk, d.model, d.serial, d.rotational, d.transport)
+		if d.rotational {
+			spinning = append(spinning, d.disk)
+		}
+	}
+	w.Flush()
+	fmt.Fprintln(out)
+
+	var chosen []*initDisk
+	for len(chosen) == 0 {
+		answer, ended := ask(in, out, "Disks to manage, by number or name", strings.Join(spinning, " "))
+		chosen = chooseDisks(out, disks, answer)
+		if len(chosen) == 0 && ended {
+			return errors.New("No disk chosen before the end of the input.")
+		}
+		if len(chosen) == 0 {
+			fmt.Fprintln(out, "Choose at least one disk.")
+		}
+	}
+
+	for _, d := range chosen {
+		if d.idle, err = askDuration(in, out, fmt.Sprintf("Idle time of %s", d.disk), initIdle); err != nil {
+			return err
+		}
+		for {
+			var ended bool
+			d.command, ended = ask(in, out, fmt.Sprintf("Command type of %s (scsi, ata)", d.disk), hdidle.SCSI)
+			if d.command == hdidle.SCSI || d.command == hdidle.ATA {
+				break
+			}
+			if ended {
+				return fmt.Errorf("Wrong command type %s. Must be one of: scsi, ata", d.command)
+			}
+			fmt.Fprintln(out, "Must be one of: scsi, ata")
+		}
+	}
+	idle, err := askDuration(in, out, "Idle time of the other disks, 0 for never", 0)
+	if err != nil {
+		return err
+	}
+
+	if len(file) == 0 {
+		file, _ = ask(in, out, "Configuration file", initConfigFile)
+	}
+	if _, err := os.Stat(file); err == nil {
+		if answer, _ := ask(in, out, fmt.Sprintf("%s exists, replace it? (y, n)", file), "n"); answer != "y" {
+			return errors.New("Nothing written.")
+		}
+	}
+	if err := ioutil.WriteFile(file, []byte(initConfigText(idle, chosen)), 0644); err != nil {
+		return fmt.Errorf("Cannot write %s: %s", file, err)
+	}
+	fmt.Fprintf(out, "\nWrote %s.\n\n", file)
+	fmt.Fprint(out, initOverrideText(file, chosen))
+	return nil
+}
+
+/* the disks of sysfs, with the persistent name the configuration names them by */
+func initDisks() ([]*initDisk, error) {
+	entries, err := ioutil.ReadDir(filepath.Join(sysfs.Root, "block"))
+	if err != nil {
+		return nil, err
+	}
+	links, _ := io.DiskLinks()
+	var disks []*initDisk
+	for _, entry := range entries {
+		name := entry.Name()
+		if !initDiskRegex.MatchString(name) {
+			continue
+		}
+		rotational, _ := sysfs.Rotational(name)
+		serial, _ := sysfs.Serial(name)
+		disks = append(disks, &initDisk{
+			disk:       name,
+			name:       persistentName(name, links[name]),
+			model:      strings.TrimSpace(sysfs.Vendor(name) + " " + sysfs.Model(name)),
+			serial:     serial,
+			rotational: rotational,
+			transport:  sysfs.Transport(name),
+		})
+	}
+	sort.Slice(disks, func(i, j int) bool { return disks[i].disk < disks[j].disk })
+	return disks, nil
+}
+
+/* the /dev/disk/by-id link of the disk itself, one with the model and serial number over its WWN */
+func persistentName(disk string, links []string) string {
+	name := disk
+	for _, link := range links {
+		base := filepath.Base(link)
+		if !strings.Contains(link, "/by-id/") || strings.Contains(base, "-part") {
+			continue
+		}
+		if !strings.HasPrefix(base, io.WwnPrefix) {
+			return link
+		}
+		if name == disk {
+			name = link
+		}
+	}
+	return name
+}
+
+/* the disks of the answer, by their number in the list or their name */
+func chooseDisks(out stdio.Writer, disks []*initDisk, answer string) []*initDisk {
+	var chosen []*initDisk
+	for _, field := range strings.FieldsFunc(answer, func(r rune) bool { return r == ' ' || r == ',' }) {
+		found := false
+		for i, d := range disks {
+			if field == strconv.Itoa(i+1) || field == d.disk || field == "/dev/"+d.disk {
+				chosen = append(chosen, d)
+				found = true
+				break
+			}
+		}
+		if !found {
+			fmt.Fprintf(out, "No disk %s.\n", field)
+			return nil
+		}
+	}
+	return chosen
+}
+
+/*
+ * The answer to the question, the default for an empty answer or the end of
+ * the input. Ended tells the input has ended, asking again is pointless.
+ */
+func ask(in *bufio.Reader, out stdio.Writer, question, def string) (answer string, ended bool) {
+	fmt.Fprintf(out, "%s [%s]: ", question, def)
+	line, err := in.ReadString('\n')
+	if err != nil && len(line) == 0 {
+		fmt.Fprintln(out)
+		return def, true
+	}
+	if answer := strings.TrimSpace(line); len(answer) > 0 {
+		return answer, err != nil
+	}
+	return def, err != nil
+}
+
+/* the duration answered, an error for a wrong one at the end of the input */
+func askDuration(in *bufio.Reader, out stdio.Writer, question string, def time.Duration) (time.Duration, error) {
+	for {
+		answer, ended := ask(in, out, question, hdidle.FormatDuration(def))
+		d, err := hdidle.ParseDuration(answer)
+		if err == nil {
+			return d, nil
+		}
+		if ended {
+			return 0, err
+		}
+		fmt.Fprintln(out, err)
+	}
+}
+
+func initConfigText(idle time.Duration, disks []*initDisk) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "# written by hd-idle init\n")
+	fmt.Fprintf(&b, "idle = %d\n", int(idle.Seconds()))
+	for _, d := range disks {
+		fmt.Fprintf(&b, "\n# %s\n", strings.TrimSpace(fmt.Sprintf("%s, %s %s", d.disk, d.model, d.serial)))
+		fmt.Fprintf(&b, "[disk.%s]\n", strconv.Quote(d.name))
+		fmt.Fprintf(&b, "idle = %d\n", int(d.idle.Seconds()))
+		fmt.Fprintf(&b, "command_type = %s\n", strconv.Quote(d.command))
+	}
+	return b.String()
+}
+
+/* the drop-in starting hd-idle with the file, solid state disks need --manage-ssd on the command line */
+func initOverrideText(file string, disks []*initDisk) string {
+	options := "--config " + file
+	for _, d := range disks {
+		if !d.rotational {
+			options += " -a " + d.name + " --manage-ssd"
+		}
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "To start hd-idle with it, add a systemd override with systemctl edit hd-idle:\n\n")
+	fmt.Fprintf(&b, "[Service]\n")
+	fmt.Fprintf(&b, "ExecStartPre=/usr/sbin/hd-idle check-config %s\n", options)
+	fmt.Fprintf(&b, "ExecStart=\n")
+	fmt.Fprintf(&b, "ExecStart=/usr/sbin/hd-idle %s\n\n", options)
+	fmt.Fprintf(&b, "It replaces HD_IDLE_OPTS of /etc/default/hd-idle. Then: systemctl restart hd-idle\n")
+	return b.String()
+}
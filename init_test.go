@@ -0,0 +1,149 @@
+// hd-idle - spin down idle hard disks
+// Copyright (C) 2018  Andoni del Olmo
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"bytes"
+	"github.com/adelolmo/hd-idle/hdidle"
+	"github.com/adelolmo/hd-idle/io"
+	"github.com/adelolmo/hd-idle/sysfs"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+/* sda, a spinning disk, and sdb, a solid state one, with their by-id links */
+func fakeInitDisks(t *testing.T) (string, func()) {
+	dir, err := ioutil.TempDir("", "hd-idle")
+	if err != nil {
+		t.Fatal(err)
+	}
+	root, devDiskDir := sysfs.Root, io.DevDiskDir
+	sysfs.Root = filepath.Join(dir, "sys")
+	io.DevDiskDir = filepath.Join(dir, "dev", "disk")
+
+	write := func(path, content string) {
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for disk, attributes := range map[string][]string{
+		"sda": {"1", "WDC WD40EFRX", "WD-WCC4E1234567", "ata-WDC_WD40EFRX_WD-WCC4E1234567"},
+		"sdb": {"0", "Samsung SSD 870", "S6PNNS0T123456", "ata-Samsung_SSD_870_S6PNNS0T123456"},
+	} {
+		block := filepath.Join(sysfs.Root, "block", disk)
+		write(filepath.Join(block, "queue", "rotational"), attributes[0]+"\n")
+		write(filepath.Join(block, "device", "model"), attributes[1]+"\n")
+		write(filepath.Join(block, "device", "serial"), attributes[2]+"\n")
+		if err := os.MkdirAll(filepath.Join(io.DevDiskDir, "by-id"), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Symlink("../../"+disk, filepath.Join(io.DevDiskDir, "by-id", attributes[3])); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir, func() {
+		sysfs.Root, io.DevDiskDir = root, devDiskDir
+		os.RemoveAll(dir)
+	}
+}
+
+func TestInitConfigByNumber(t *testing.T) {
+	dir, reset := fakeInitDisks(t)
+	defer reset()
+	file := filepath.Join(dir, "hd-idle.conf")
+
+	var out bytes.Buffer
+	if err := initConfig([]string{"-o", file}, strings.NewReader("1 2\n5m\n\n20m\nata\n0\n"), &out); err != nil {
+		t.Fatal(err)
+	}
+	config, err := hdidle.LoadConfigFile(file)
+	if err != nil {
+		t.Fatalf("Expected the written configuration to load but found: %s", err)
+	}
+	if config.Defaults.Idle != 0 || len(config.Devices) != 2 {
+		t.Fatalf("Expected no default idle time and 2 disks but found %s and %d disks", config.Defaults.Idle, len(config.Devices))
+	}
+	expected := []struct {
+		name    string
+		idle    time.Duration
+		command string
+	}{
+		{filepath.Join(io.DevDiskDir, "by-id", "ata-WDC_WD40EFRX_WD-WCC4E1234567"), 5 * time.Minute, hdidle.SCSI},
+		{filepath.Join(io.DevDiskDir, "by-id", "ata-Samsung_SSD_870_S6PNNS0T123456"), 20 * time.Minute, hdidle.ATA},
+	}
+	for i, device := range config.Devices {
+		if device.GivenName != expected[i].name || device.Idle != expected[i].idle || device.CommandType != expected[i].command {
+			t.Fatalf("Expected %v but found %s, %s, %s", expected[i], device.GivenName, device.Idle, device.CommandType)
+		}
+	}
+
+	override := "-a " + expected[1].name + " --manage-ssd"
+	if !strings.Contains(out.String(), "ExecStart=/usr/sbin/hd-idle --config "+file+" "+override+"\n") {
+		t.Fatalf("Expected the solid state disk managed by the override but found %q", out.String())
+	}
+	if strings.Contains(out.String(), "WD-WCC4E1234567 --manage-ssd") {
+		t.Fatalf("Expected no --manage-ssd for the spinning disk but found %q", out.String())
+	}
+}
+
+func TestInitConfigByName(t *testing.T) {
+	dir, reset := fakeInitDisks(t)
+	defer reset()
+	file := filepath.Join(dir, "hd-idle.conf")
+
+	var out bytes.Buffer
+	if err := initConfig([]string{"-o", file}, strings.NewReader("sdz\n/dev/sdb\n\n\n"), &out); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out.String(), "No disk sdz.\nChoose at least one disk.\n") {
+		t.Fatalf("Expected sdz refused but found %q", out.String())
+	}
+	config, err := hdidle.LoadConfigFile(file)
+	if err != nil {
+		t.Fatalf("Expected the written configuration to load but found: %s", err)
+	}
+	if len(config.Devices) != 1 || config.Devices[0].Name != "sdb" ||
+		config.Devices[0].Idle != 10*time.Minute {
+		t.Fatalf("Expected sdb with the default idle time but found %v", config.Devices)
+	}
+}
+
+func TestInitConfigEndOfInput(t *testing.T) {
+	dir, reset := fakeInitDisks(t)
+	defer reset()
+	file := filepath.Join(dir, "hd-idle.conf")
+
+	err := initConfig([]string{"-o", file}, strings.NewReader("sdz"), ioutil.Discard)
+	if err == nil || err.Error() != "No disk chosen before the end of the input." {
+		t.Fatalf("Expected no disk chosen but found %v", err)
+	}
+	if _, err := os.Stat(file); !os.IsNotExist(err) {
+		t.Fatalf("Expected nothing written but found %v", err)
+	}
+
+	err = initConfig([]string{"-o", file}, strings.NewReader("1\nsoon"), ioutil.Discard)
+	if err == nil || !strings.Contains(err.Error(), "soon") {
+		t.Fatalf("Expected the wrong idle time at the end of the input but found %v", err)
+	}
+}
@@ -0,0 +1,66 @@
+// hd-idle - spin down idle hard disks
+// Copyright (C) 2018  Andoni del Olmo
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package io
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"os/exec"
+	"strings"
+)
+
+// DeviceMapperTarget is a segment of a device mapper device with the status
+// of its target, a line of dmsetup status.
+type DeviceMapperTarget struct {
+	Device string   // e.g. vg0-data
+	Target string   // e.g. snapshot-merge or thin-pool
+	Status []string // the fields specific to the target
+}
+
+// DeviceMapperStatus returns the status of the targets of every device
+// mapper device, as dmsetup status shows it.
+func DeviceMapperStatus() ([]DeviceMapperTarget, error) {
+	out, err := exec.Command("dmsetup", "status").Output()
+	if err != nil {
+		return nil, err
+	}
+	return ReadDeviceMapperStatus(bytes.NewReader(out))
+}
+
+func ReadDeviceMapperStatus(r io.Reader) ([]DeviceMapperTarget, error) {
+	var targets []DeviceMapperTarget
+	device := ""
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		/* vg0-data: 0 2097152 snapshot-merge 16/4194304 16 */
+		line := scanner.Text()
+		if i := strings.Index(line, ": "); i > 0 {
+			device, line = line[:i], line[i+2:]
+		}
+		cols := strings.Fields(line)
+		if len(device) == 0 || len(cols) < 3 {
+			/* e.g. No devices found */
+			continue
+		}
+		targets = append(targets, DeviceMapperTarget{Device: device, Target: cols[2], Status: cols[3:]})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return targets, nil
+}
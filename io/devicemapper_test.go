@@ -0,0 +1,50 @@
+// hd-idle - spin down idle hard disks
+// Copyright (C) 2018  Andoni del Olmo
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package io
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestReadDeviceMapperStatus(t *testing.T) {
+	s := `vg0-data: 0 2097152 snapshot-merge 1024/4194304 16
+vg0-pool-tpool: 0 8388608 thin-pool 3 160/4608 512/65536 - rw no_discard_passdown queue_if_no_space - 1024
+vg0-root: 0 1048576 linear
+0 1048576 linear
+`
+	targets, err := ReadDeviceMapperStatus(strings.NewReader(s))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []DeviceMapperTarget{
+		{Device: "vg0-data", Target: "snapshot-merge", Status: []string{"1024/4194304", "16"}},
+		{Device: "vg0-pool-tpool", Target: "thin-pool", Status: []string{"3", "160/4608", "512/65536", "-", "rw",
+			"no_discard_passdown", "queue_if_no_space", "-", "1024"}},
+		{Device: "vg0-root", Target: "linear", Status: []string{}},
+		{Device: "vg0-root", Target: "linear", Status: []string{}},
+	}
+	if !reflect.DeepEqual(targets, expected) {
+		t.Fatalf("Expected %v but found %v", expected, targets)
+	}
+
+	targets, err = ReadDeviceMapperStatus(strings.NewReader("No devices found\n"))
+	if err != nil || len(targets) != 0 {
+		t.Fatalf("Expected no targets but found %v, %v", targets, err)
+	}
+}
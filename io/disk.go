@@ -18,13 +18,30 @@ package io
 
 import (
 	"fmt"
+	"github.com/adelolmo/hd-idle/sysfs"
 	"os"
 	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
 )
 
+// SerialPrefix names a disk by its serial number, e.g. serial:WD-WCC4E1234567.
+const SerialPrefix = "serial:"
+
+// WwnPrefix names a disk by its World Wide Name, as in /dev/disk/by-id, e.g.
+// wwn-0x5000c500a1b2c3d4.
+const WwnPrefix = "wwn-"
+
+// RealPath returns the kernel name (e.g. sdb) of the disk given by kernel
+// name, device path, /dev/disk/by-* link, serial number or WWN.
 func RealPath(path string) (string, error) {
+	if strings.HasPrefix(path, SerialPrefix) {
+		return sysfs.DiskWithSerial(strings.TrimPrefix(path, SerialPrefix))
+	}
+	if strings.HasPrefix(path, WwnPrefix) {
+		return sysfs.DiskWithWwn(strings.TrimPrefix(path, WwnPrefix))
+	}
 	if path[0] != '/' {
 		return path, nil
 	}
@@ -33,18 +50,64 @@ func RealPath(path string) (string, error) {
 	}
 	s, err := os.Readlink(path)
 	if err == nil {
-		device := filepath.Base(s)
-		/* remove partition numbers, if any */
-		for {
-			i := device[len(device)-1:]
-			_, err := strconv.Atoi(i)
-			if err != nil {
-				break
-			}
-			device = device[:len(device)-1]
-		}
-		return device, nil
+		return diskOf(filepath.Base(s)), nil
 	}
 
 	return "", fmt.Errorf("cannot find device for %s", path)
 }
+
+// HotplugName tells whether the name is a /dev/disk/by-path link, naming the
+// port a disk is plugged into, or a /dev/disk/by-partlabel link, naming a
+// partition whatever disk it is on. Either may resolve to another disk once
+// the disk is plugged in again.
+func HotplugName(path string) bool {
+	return strings.Contains(path, "/by-path/") || strings.Contains(path, "/by-partlabel/")
+}
+
+// DriveName tells whether the name is the drive's own, its serial number or
+// WWN, rather than a name that can pass to another drive, e.g. sdb.
+func DriveName(path string) bool {
+	return strings.HasPrefix(path, SerialPrefix) || strings.HasPrefix(path, WwnPrefix)
+}
+
+// DevDiskDir holds the persistent device names created by udev. It is a
+// variable so tests can point it somewhere else.
+var DevDiskDir = "/dev/disk"
+
+// DiskLinks returns the persistent names (e.g. /dev/disk/by-uuid/...) of every
+// disk, including the names of its partitions, sorted.
+func DiskLinks() (map[string][]string, error) {
+	links, err := filepath.Glob(filepath.Join(DevDiskDir, "by-*", "*"))
+	if err != nil {
+		return nil, err
+	}
+	disks := map[string][]string{}
+	for _, link := range links {
+		target, err := os.Readlink(link)
+		if err != nil {
+			continue
+		}
+		disk := diskOf(filepath.Base(target))
+		disks[disk] = append(disks[disk], link)
+	}
+	for _, l := range disks {
+		sort.Strings(l)
+	}
+	return disks, nil
+}
+
+/* the disk a partition is on, the partition number removed without sysfs */
+func diskOf(device string) string {
+	if disk, err := sysfs.WholeDisk(device); err == nil {
+		return disk
+	}
+	for len(device) > 0 {
+		i := device[len(device)-1:]
+		_, err := strconv.Atoi(i)
+		if err != nil {
+			break
+		}
+		device = device[:len(device)-1]
+	}
+	return device
+}
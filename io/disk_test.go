@@ -18,7 +18,11 @@ package io
 
 import (
 	"fmt"
+	"github.com/adelolmo/hd-idle/sysfs"
+	"io/ioutil"
 	"os"
+	"path/filepath"
+	"reflect"
 	"testing"
 )
 
@@ -101,3 +105,89 @@ func TestRealPath(t *testing.T) {
 		})
 	}
 }
+
+func TestDiskLinks(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dev")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for _, d := range []string{"by-id", "by-uuid"} {
+		if err := os.MkdirAll(filepath.Join(dir, d), os.ModePerm); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for link, target := range map[string]string{
+		"by-id/ata-WDC_WD40EFRX":       "../../sdb",
+		"by-id/ata-WDC_WD40EFRX-part1": "../../sdb1",
+		"by-uuid/0b4e-1f2a":            "../../sdb1",
+		"by-uuid/9c3d-77e0":            "../../sda2",
+	} {
+		if err := os.Symlink(target, filepath.Join(dir, link)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	DevDiskDir = dir
+	defer func() { DevDiskDir = "/dev/disk" }()
+
+	links, err := DiskLinks()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string][]string{
+		"sda": {filepath.Join(dir, "by-uuid/9c3d-77e0")},
+		"sdb": {
+			filepath.Join(dir, "by-id/ata-WDC_WD40EFRX"),
+			filepath.Join(dir, "by-id/ata-WDC_WD40EFRX-part1"),
+			filepath.Join(dir, "by-uuid/0b4e-1f2a"),
+		},
+	}
+	if !reflect.DeepEqual(links, expected) {
+		t.Fatalf("Expected %v but found %v", expected, links)
+	}
+}
+
+func TestRealPathHotplugNames(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dev")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for _, d := range []string{"disk/by-path", "disk/by-partlabel", "sys/class/block",
+		"sys/devices/pci0000:00/nvme/nvme0/nvme0n1/nvme0n1p2", "sys/devices/pci0000:00/host1/block/sdb"} {
+		if err := os.MkdirAll(filepath.Join(dir, d), os.ModePerm); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "sys/devices/pci0000:00/nvme/nvme0/nvme0n1/nvme0n1p2/partition"), []byte("2\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	for link, target := range map[string]string{
+		"disk/by-path/pci-0000:00:1f.2-ata-2": "../../sdb",
+		"disk/by-partlabel/backup":            "../../nvme0n1p2",
+		"sys/class/block/sdb":                 filepath.Join(dir, "sys/devices/pci0000:00/host1/block/sdb"),
+		"sys/class/block/nvme0n1p2":           filepath.Join(dir, "sys/devices/pci0000:00/nvme/nvme0/nvme0n1/nvme0n1p2"),
+	} {
+		if err := os.Symlink(target, filepath.Join(dir, link)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	root := sysfs.Root
+	sysfs.Root = filepath.Join(dir, "sys")
+	defer func() { sysfs.Root = root }()
+
+	for path, expected := range map[string]string{
+		filepath.Join(dir, "disk/by-path/pci-0000:00:1f.2-ata-2"): "sdb",
+		filepath.Join(dir, "disk/by-partlabel/backup"):            "nvme0n1",
+	} {
+		if disk, err := RealPath(path); err != nil || disk != expected {
+			t.Errorf("Expected %s for %s but found %s, %v", expected, path, disk, err)
+		}
+		if !HotplugName(path) {
+			t.Errorf("Expected %s resolved again on hotplug", path)
+		}
+	}
+	if HotplugName("/dev/disk/by-id/ata-WDC_WD40EFRX") || HotplugName("sdb") {
+		t.Error("Expected by-id links and kernel names not resolved again on hotplug")
+	}
+}
@@ -0,0 +1,114 @@
+// hd-idle - spin down idle hard disks
+// Copyright (C) 2018  Andoni del Olmo
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package io
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+const (
+	mountPointCol = 4 // field 5 - mount point, see proc(5)
+	sourceCol     = 2 // after the separator: filesystem type, mount source
+)
+
+// MountPoints returns the mount points of the system.
+func MountPoints() ([]string, error) {
+	f, err := os.Open("/proc/self/mountinfo")
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return ReadMountPoints(f)
+}
+
+func ReadMountPoints(r io.Reader) ([]string, error) {
+	var mountPoints []string
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		cols := strings.Fields(scanner.Text())
+		if len(cols) <= mountPointCol {
+			continue
+		}
+		mountPoints = append(mountPoints, unescapeMountPoint(cols[mountPointCol]))
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return mountPoints, nil
+}
+
+// MountSource returns the source of the filesystem mounted last at the mount
+// point, e.g. /dev/sda2.
+func MountSource(mountPoint string) (string, error) {
+	f, err := os.Open("/proc/self/mountinfo")
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	return ReadMountSource(f, mountPoint)
+}
+
+func ReadMountSource(r io.Reader, mountPoint string) (string, error) {
+	var source string
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		cols := strings.Fields(scanner.Text())
+		if len(cols) <= mountPointCol || unescapeMountPoint(cols[mountPointCol]) != mountPoint {
+			continue
+		}
+		/* the optional fields end with a separator */
+		for i := mountPointCol + 1; i < len(cols); i++ {
+			if cols[i] == "-" && i+sourceCol < len(cols) {
+				source = unescapeMountPoint(cols[i+sourceCol])
+				break
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return "", err
+	}
+	if len(source) == 0 {
+		return "", fmt.Errorf("nothing mounted at %s", mountPoint)
+	}
+	return source, nil
+}
+
+/* spaces, tabs, newlines and backslashes are escaped as octal, e.g. \040 */
+func unescapeMountPoint(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+4 <= len(s) {
+			if c, err := strconv.ParseUint(s[i+1:i+4], 8, 8); err == nil {
+				b.WriteByte(byte(c))
+				i += 3
+				continue
+			}
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
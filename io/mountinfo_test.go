@@ -0,0 +1,54 @@
+// hd-idle - spin down idle hard disks
+// Copyright (C) 2018  Andoni del Olmo
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package io
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestReadMountPoints(t *testing.T) {
+	s := `22 1 179:2 / / rw,noatime shared:1 - ext4 /dev/root rw
+36 22 8:1 / /mnt/data rw,relatime shared:20 - ext4 /dev/sda1 rw
+37 22 8:17 / /mnt/my\040disk rw,relatime shared:21 - ext4 /dev/sdb1 rw`
+
+	got, err := ReadMountPoints(strings.NewReader(s))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"/", "/mnt/data", "/mnt/my disk"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ReadMountPoints() = %v, want %v", got, want)
+	}
+}
+
+func TestReadMountSource(t *testing.T) {
+	s := `22 1 0:21 / / rw,noatime shared:1 - btrfs /dev/nvme0n1p2 rw,subvol=/@
+36 22 8:1 / /mnt/data rw,relatime - ext4 /dev/sda1 rw
+37 36 8:17 / /mnt/data rw,relatime shared:21 master:3 - ext4 /dev/sdb1 rw`
+
+	for mountPoint, want := range map[string]string{"/": "/dev/nvme0n1p2", "/mnt/data": "/dev/sdb1"} {
+		got, err := ReadMountSource(strings.NewReader(s), mountPoint)
+		if err != nil || got != want {
+			t.Errorf("ReadMountSource(%s) = %s, %v, want %s", mountPoint, got, err, want)
+		}
+	}
+	if _, err := ReadMountSource(strings.NewReader(s), "/srv"); err == nil {
+		t.Error("Expected an error for a mount point with nothing mounted")
+	}
+}
@@ -0,0 +1,59 @@
+// hd-idle - spin down idle hard disks
+// Copyright (C) 2018  Andoni del Olmo
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package io
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"strings"
+)
+
+// Swap is an active swap area of /proc/swaps.
+type Swap struct {
+	File      string // e.g. /dev/sda3 or /swapfile
+	Partition bool   // a block device rather than a file
+}
+
+// Swaps returns the active swap areas of the system.
+func Swaps() ([]Swap, error) {
+	f, err := os.Open("/proc/swaps")
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return ReadSwaps(f)
+}
+
+func ReadSwaps(r io.Reader) ([]Swap, error) {
+	var swaps []Swap
+	scanner := bufio.NewScanner(r)
+	/* the first line names the columns */
+	scanner.Scan()
+	for scanner.Scan() {
+		cols := strings.Fields(scanner.Text())
+		if len(cols) < 2 {
+			continue
+		}
+		swaps = append(swaps, Swap{File: unescapeMountPoint(cols[0]), Partition: cols[1] == "partition"})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return swaps, nil
+}
@@ -0,0 +1,43 @@
+// hd-idle - spin down idle hard disks
+// Copyright (C) 2018  Andoni del Olmo
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package io
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestReadSwaps(t *testing.T) {
+	s := `Filename				Type		Size		Used		Priority
+/dev/sda3                               partition	8388604		1024		-2
+/srv/my\040swap                         file		2097148		0		-3
+/dev/zram0                              partition	4020220		0		100`
+
+	got, err := ReadSwaps(strings.NewReader(s))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Swap{{File: "/dev/sda3", Partition: true}, {File: "/srv/my swap"}, {File: "/dev/zram0", Partition: true}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ReadSwaps() = %v, want %v", got, want)
+	}
+
+	if got, err = ReadSwaps(strings.NewReader("Filename\tType\tSize\tUsed\tPriority\n")); err != nil || len(got) != 0 {
+		t.Errorf("ReadSwaps() without swap = %v, %v, want none", got, err)
+	}
+}
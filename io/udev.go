@@ -0,0 +1,70 @@
+// hd-idle - spin down idle hard disks
+// Copyright (C) 2018  Andoni del Olmo
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package io
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/adelolmo/hd-idle/sysfs"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// UdevDataDir is where udev keeps the properties of the devices. It is a
+// variable so tests can point it to a fake tree.
+var UdevDataDir = "/run/udev/data"
+
+// UdevQueue exists while udev has events to process, e.g. the disks found at
+// boot or just plugged in. It is a variable so tests can point it elsewhere.
+var UdevQueue = "/run/udev/queue"
+
+// UdevBusy tells whether udev is still processing events, what udevadm
+// settle waits for.
+func UdevBusy() bool {
+	_, err := os.Stat(UdevQueue)
+	return err == nil
+}
+
+// UdevProperties returns the udev properties of the disk, e.g. ID_MODEL,
+// ID_BUS or ID_PATH, as udevadm info shows them.
+func UdevProperties(disk string) (map[string]string, error) {
+	dev, err := ioutil.ReadFile(filepath.Join(sysfs.Root, "block", disk, "dev"))
+	if err != nil {
+		return nil, fmt.Errorf("no block device %s", disk)
+	}
+	f, err := os.Open(filepath.Join(UdevDataDir, "b"+strings.TrimSpace(string(dev))))
+	if err != nil {
+		return nil, fmt.Errorf("no udev data for %s: %s", disk, err)
+	}
+	defer f.Close()
+
+	properties := map[string]string{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		/* E:ID_MODEL=WDC_WD80EFAX-68KNBN0 */
+		line := scanner.Text()
+		if !strings.HasPrefix(line, "E:") {
+			continue
+		}
+		if i := strings.Index(line, "="); i > 2 {
+			properties[line[2:i]] = line[i+1:]
+		}
+	}
+	return properties, scanner.Err()
+}
@@ -0,0 +1,66 @@
+// hd-idle - spin down idle hard disks
+// Copyright (C) 2018  Andoni del Olmo
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package io
+
+import (
+	"github.com/adelolmo/hd-idle/sysfs"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestUdevProperties(t *testing.T) {
+	dir, err := ioutil.TempDir("", "udev")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := os.MkdirAll(filepath.Join(dir, "block", "sdb"), os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Join(dir, "data"), os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "block", "sdb", "dev"), []byte("8:16\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	data := "S:disk/by-id/ata-WDC_WD80EFAX-68KNBN0_VAG1234\nI:3456789\nE:ID_BUS=ata\nE:ID_MODEL=WDC_WD80EFAX-68KNBN0\n" +
+		"E:ID_PATH=pci-0000:00:1f.2-ata-2\nG:systemd\n"
+	if err := ioutil.WriteFile(filepath.Join(dir, "data", "b8:16"), []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	root := sysfs.Root
+	sysfs.Root = dir
+	UdevDataDir = filepath.Join(dir, "data")
+	defer func() {
+		sysfs.Root = root
+		UdevDataDir = "/run/udev/data"
+	}()
+
+	properties, err := UdevProperties("sdb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(properties) != 3 || properties["ID_BUS"] != "ata" || properties["ID_MODEL"] != "WDC_WD80EFAX-68KNBN0" ||
+		properties["ID_PATH"] != "pci-0000:00:1f.2-ata-2" {
+		t.Fatalf("Expected the three properties of sdb but found %v", properties)
+	}
+	if _, err := UdevProperties("sdz"); err == nil {
+		t.Fatal("Expected an error for an unknown disk")
+	}
+}
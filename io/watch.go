@@ -0,0 +1,91 @@
+// hd-idle - spin down idle hard disks
+// Copyright (C) 2018  Andoni del Olmo
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package io
+
+import (
+	"bytes"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"unsafe"
+)
+
+const watchMask = syscall.IN_CLOSE_WRITE | syscall.IN_CREATE | syscall.IN_DELETE | syscall.IN_MOVED_TO | syscall.IN_MOVED_FROM
+
+// Watcher tells which files of the watched directories change: written,
+// created, deleted or renamed. Directories rather than files are watched,
+// since editors and configuration management replace a file by renaming
+// another one over it.
+type Watcher struct {
+	Changes <-chan string // the paths that changed
+
+	fd   int
+	mu   sync.Mutex
+	dirs map[int32]string
+}
+
+// NewWatcher starts an inotify watcher without directories, see Add.
+func NewWatcher() (*Watcher, error) {
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC)
+	if err != nil {
+		return nil, err
+	}
+	changes := make(chan string, 16)
+	w := &Watcher{Changes: changes, fd: fd, dirs: map[int32]string{}}
+	go w.read(changes)
+	return w, nil
+}
+
+// Add watches a directory too. Adding it again is fine.
+func (w *Watcher) Add(dir string) error {
+	wd, err := syscall.InotifyAddWatch(w.fd, dir, watchMask)
+	if err != nil {
+		return err
+	}
+	w.mu.Lock()
+	w.dirs[int32(wd)] = dir
+	w.mu.Unlock()
+	return nil
+}
+
+func (w *Watcher) read(changes chan<- string) {
+	buf := make([]byte, 64*(syscall.SizeofInotifyEvent+syscall.NAME_MAX+1))
+	for {
+		n, err := syscall.Read(w.fd, buf)
+		if err != nil || n <= 0 {
+			if err == syscall.EINTR {
+				continue
+			}
+			close(changes)
+			return
+		}
+		for offset := 0; offset+syscall.SizeofInotifyEvent <= n; {
+			event := (*syscall.InotifyEvent)(unsafe.Pointer(&buf[offset]))
+			name := buf[offset+syscall.SizeofInotifyEvent : offset+syscall.SizeofInotifyEvent+int(event.Len)]
+			offset += syscall.SizeofInotifyEvent + int(event.Len)
+
+			w.mu.Lock()
+			dir, found := w.dirs[event.Wd]
+			w.mu.Unlock()
+			if !found || len(name) == 0 {
+				continue
+			}
+			/* the name is padded with zeros */
+			changes <- filepath.Join(dir, string(bytes.TrimRight(name, "\x00")))
+		}
+	}
+}
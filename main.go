@@ -17,18 +17,27 @@
 package main
 
 import (
+	"errors"
 	"fmt"
+	"github.com/adelolmo/hd-idle/hdidle"
 	"github.com/adelolmo/hd-idle/io"
+	"github.com/adelolmo/hd-idle/quirks"
 	"os"
+	"os/signal"
 	"strconv"
+	"strings"
+	"syscall"
 	"time"
 )
 
-const (
-	defaultIdleTime     = 600 * time.Second
-	symlinkResolveOnce  = 0
-	symlinkResolveRetry = 1
-)
+const usage = "usage: hd-idle [--config <file>] [--config-dir <dir>] [--watch-config] [--compat] [-t <disk>] [-s <symlink_policy>] [-a <name>] [-x <name>] [--opt-in] [--manage-root] [--manage-swap] [--manual-hold <time>] [--alias <alias>] [--namespace <name>] [--tag <key>=<value>] [--disk-log <logfile>] [--disk-debug] [--wake-with <disk>] [--backup-window <time>] [--passthrough <policy>] [--manage-ssd] [--power-meter <meter>] [--class <class>] " +
+	"[--define-class <class>] [-i <idle_time>] [--battery-idle <time>] [--profile-idle <profile>=<time>] [--profile <profile>] " +
+	"[-c <command_type>] [--usb-power-off] [--sata-lpm <policy>] [--wait-mount <path>] [--wait-mount-timeout <time>] [--skew <time>] [--probe-window <time>] [--hba-runtime-pm] [--usb-hub-spacing <time>] [--smart-interval <time>] [--standby-read-ahead <kb>] [--state-dir <dir>] [--checkpoint-interval <time>] [--unsupported <policy>] [--replacement <policy>] [--wake-storm <disks>] [--wake-storm-window <time>] [--advisor] [--watchdog <factor>] [--breaker-threshold <failures>] [--breaker-cooldown <time>] [--awake <window>] [--usb-power-on <port>] [--quirks <file>] [--learn-quirks] [-l <logfile>] [--log-format <format>] [--log-buffer] " +
+	"[--log-fallback <logfile>] [--log-fallback-timeout <time>] [--trace <file>] [--audit-opens <file>] [--self-metrics] [--daily-report <hh:mm|off>] [--stacked-io] [--exports] [--sshd-idle <time>] [--smr-idle <time>] [--smr-gc-wait <time>] [--power-drop <watts>] [--inhibit-suspend] [--background-check] [--crash-dir <dir>] [--listen <address>] [--control] [--listen-control <address>] [--read-allow <user|@group>] [--control-allow <user|@group>] [--api-tokens <file>] [--webhook <url>] [--push <url>] [--push-interval <time>] [--read-only] [-d] [-h]\n" +
+	"options override the HD_IDLE_* environment variables, which override the configuration files"
+
+/* returned by parseArgs for h, to print the usage */
+var errHelp = errors.New("help")
 
 func main() {
 
@@ -37,136 +46,880 @@ func main() {
 		os.Exit(0)
 	}
 
-	singleDiskMode := false
+	if len(os.Args) > 1 && os.Args[1] == "hub" {
+		hub(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "soak" {
+		soak(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "pause" {
+		pause(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "resume" {
+		resume(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "profile" {
+		profile(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "annotate" {
+		annotate(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "report" {
+		report(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "eject" {
+		eject(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && (os.Args[1] == "spindown" || os.Args[1] == "spinup" || os.Args[1] == "check" || os.Args[1] == "identify") {
+		oneShot(os.Args[1], os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "simulate" {
+		simulate(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "schema" {
+		printSchema(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "init" {
+		err := initConfig(os.Args[2:], os.Stdin, os.Stdout)
+		if err == errHelp {
+			fmt.Println(initUsage)
+			return
+		}
+		if err != nil {
+			fmt.Println(err.Error())
+			os.Exit(1)
+		}
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "check-config" {
+		checkConfig(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "print-config" {
+		printConfig(os.Args[2:])
+		return
+	}
+
+	args := append(envArgsOrExit(), commandLineOrExit(os.Args[1:])...)
+	for index, arg := range args {
+		if arg == "--usb-power-on" {
+			if err := usbPowerOn(args, index); err != nil {
+				fmt.Println(err.Error())
+				os.Exit(1)
+			}
+			os.Exit(0)
+		}
+	}
+	config, disk := parseArgsOrExit(args)
+	if config.Defaults.ReadOnly {
+		if paths := config.WritablePaths(); len(paths) > 0 {
+			fmt.Printf("Read-only mode does not allow writing to: %s\n", strings.Join(paths, ", "))
+			os.Exit(1)
+		}
+	}
+
+	if err := config.MissingDisks(); err != nil && len(disk) == 0 {
+		fmt.Println(err.Error())
+		os.Exit(1)
+	}
+
+	if len(disk) > 0 {
+		fmt.Printf("%s spindown\n", disk)
+		if err := hdidle.SpindownDisk(disk, config.Defaults.CommandType); err != nil {
+			fmt.Println(err.Error())
+			os.Exit(exitCodes[hdidle.FailureClass(err)])
+		}
+		os.Exit(0)
+	}
+	fmt.Println(config.String())
+	if config.Defaults.Simulation != nil {
+		fmt.Printf("WARNING simulating disk commands: %s\n", config.Defaults.Simulation)
+	}
+
+	monitor := hdidle.New(config)
+	startNetwork(monitor, config)
+
+	/* stop cleanly so the disks' links get their power policy back */
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		<-signals
+		monitor.Stop()
+	}()
+
+	hangups := make(chan os.Signal, 1)
+	signal.Notify(hangups, syscall.SIGHUP)
+	go func() {
+		for range hangups {
+			if err := reload(monitor, args); err != nil {
+				fmt.Printf("Cannot reload the configuration, keeping the running one: %s\n", err)
+			}
+		}
+	}()
+	if config.Defaults.WatchConfig {
+		go watchConfig(monitor, args)
+	}
+
+	if err := monitor.Run(); err != nil {
+		fmt.Println(err.Error())
+		os.Exit(1)
+	}
+}
+
+/*
+ * --usb-power-on powers the port on and ends, through the monitor of the
+ * other options so --read-only refuses it as it does for the control API.
+ */
+func usbPowerOn(args []string, index int) error {
+	if index+1 == len(args) {
+		return errors.New("Missing port for --usb-power-on. Must be a usb port, e.g. 1-1.2")
+	}
+	others := append(append([]string{}, args[:index]...), args[index+2:]...)
+	config, _, err := parseArgs(others)
+	if err == errHelp {
+		return errors.New(usage)
+	}
+	if err != nil {
+		return err
+	}
+	monitor := hdidle.New(config)
+	return monitor.PowerOnUsbPort(args[index+1])
+}
+
+/* parseArgs for the commands, wrong options end them */
+func parseArgsOrExit(args []string) (*hdidle.Config, string) {
+	config, disk, err := parseArgs(args)
+	if err == errHelp {
+		fmt.Println(usage)
+		os.Exit(0)
+	}
+	if err != nil {
+		fmt.Println(err.Error())
+		os.Exit(1)
+	}
+	return config, disk
+}
+
+/*
+ * The configuration given by the options, read again on SIGHUP. Wrong options
+ * and files that cannot be read are returned as an error, so a reload keeps
+ * the running configuration. The disk is the one given with -t, if any.
+ */
+func parseArgs(args []string) (*hdidle.Config, string, error) {
 	var disk string
-	defaultConf := DefaultConf{
-		Idle:          defaultIdleTime,
-		CommandType:   SCSI,
-		Debug:         false,
-		SymlinkPolicy: 0,
+	var config = hdidle.NewConfig()
+	var deviceConf *hdidle.DeviceConf
+	var classConf *hdidle.ClassConf
+
+	/* the flags override the configuration files, wherever they are given */
+	files, err := configFiles(args)
+	if err != nil {
+		return nil, "", fmt.Errorf("Cannot read configuration directory: %s", err)
 	}
-	var config = &Config{
-		Devices:  []DeviceConf{},
-		Defaults: defaultConf,
+	if len(files) > 0 {
+		loaded, err := hdidle.LoadConfigFiles(files)
+		if err != nil {
+			return nil, "", fmt.Errorf("Cannot load configuration file: %s", err)
+		}
+		config = loaded
 	}
-	var deviceConf *DeviceConf
 
-	for index, arg := range os.Args[1:] {
+	for index, arg := range args {
 		switch arg {
 		case "-t":
-			if len(os.Args) < 3 {
-				fmt.Println("Missing disk argument. Must be a device (e.g. sda)")
-				os.Exit(1)
+			if len(args) < 2 {
+				return nil, "", errors.New("Missing disk argument. Must be a device (e.g. sda)")
 			}
-			singleDiskMode = true
-			disk = os.Args[index+2]
+			disk = args[index+1]
 
 		case "-s":
-			s := os.Args[index+2]
-			switch s {
-			case "0":
-				config.Defaults.SymlinkPolicy = symlinkResolveOnce
-			case "1":
-				config.Defaults.SymlinkPolicy = symlinkResolveRetry
-			default:
-				fmt.Printf("Wrong symlink_policy -s %s. Must be 0 or 1\n", s)
-				os.Exit(1)
+			s := args[index+1]
+			policy, err := strconv.Atoi(s)
+			if err != nil || policy < hdidle.SymlinkResolveOnce || policy > hdidle.SymlinkResolveRequired {
+				return nil, "", fmt.Errorf("Wrong symlink_policy -s %s. Must be 0, 1, 2 or 3", s)
+			}
+			if deviceConf != nil {
+				deviceConf.SymlinkPolicy = policy
+			} else {
+				config.Defaults.SymlinkPolicy = policy
+			}
+
+		case "--define-class":
+			if deviceConf != nil {
+				config.Devices = append(config.Devices, *deviceConf)
+				deviceConf = nil
+			}
+			if classConf != nil {
+				config.Classes = append(config.Classes, *classConf)
+			}
+			classConf = &hdidle.ClassConf{
+				Name:         args[index+1],
+				Idle:         config.Defaults.Idle,
+				BatteryIdle:  config.Defaults.BatteryIdle,
+				ProfileIdles: config.Defaults.ProfileIdles,
+				CommandType:  config.Defaults.CommandType,
+				UsbPowerOff:  config.Defaults.UsbPowerOff,
+				SataLpm:      config.Defaults.SataLpm,
+				WaitMounts:   config.Defaults.WaitMounts,
+				AwakeWindows: config.Defaults.AwakeWindows,
 			}
 
 		case "-a":
 			if deviceConf != nil {
 				config.Devices = append(config.Devices, *deviceConf)
 			}
+			if classConf != nil {
+				config.Classes = append(config.Classes, *classConf)
+				classConf = nil
+			}
 
-			name := os.Args[index+2]
-			deviceRealPath, err := io.RealPath(name)
+			name := args[index+1]
+			if hdidle.IsDevicePattern(name) {
+				if err := hdidle.ValidDevicePattern(name); err != nil {
+					return nil, "", fmt.Errorf("Wrong pattern -a %s: %s", name, err)
+				}
+			}
+			/* a disk that is not plugged in stays pending until it is */
+			deviceRealPath, _ := io.RealPath(name)
+			deviceConf = &hdidle.DeviceConf{
+				Name:          deviceRealPath,
+				GivenName:     name,
+				Idle:          config.Defaults.Idle,
+				BatteryIdle:   config.Defaults.BatteryIdle,
+				ProfileIdles:  config.Defaults.ProfileIdles,
+				CommandType:   config.Defaults.CommandType,
+				UsbPowerOff:   config.Defaults.UsbPowerOff,
+				SataLpm:       config.Defaults.SataLpm,
+				WaitMounts:    config.Defaults.WaitMounts,
+				AwakeWindows:  config.Defaults.AwakeWindows,
+				SymlinkPolicy: config.Defaults.SymlinkPolicy,
+			}
+			/* options for a disk of the configuration file change its section */
+			for i := range config.Devices {
+				if config.Devices[i].GivenName == name {
+					*deviceConf = config.Devices[i]
+					config.Devices = append(config.Devices[:i], config.Devices[i+1:]...)
+					break
+				}
+			}
+
+		case "-x":
+			name := args[index+1]
+			if hdidle.IsDevicePattern(name) {
+				if err := hdidle.ValidDevicePattern(name); err != nil {
+					return nil, "", fmt.Errorf("Wrong pattern -x %s: %s", name, err)
+				}
+			}
+			config.Defaults.Exclude = append(config.Defaults.Exclude, name)
+
+		case "--alias":
+			if deviceConf == nil {
+				return nil, "", errors.New("Missing disk for --alias. Must follow -a <name>")
+			}
+			deviceConf.Alias = args[index+1]
+
+		case "--wake-with":
+			if deviceConf == nil {
+				return nil, "", errors.New("Missing disk for --wake-with. Must follow -a <name>")
+			}
+			name := args[index+1]
+			leader, err := io.RealPath(name)
 			if err != nil {
-				deviceRealPath = ""
-				fmt.Printf("Unable to resolve symlink: %s\n", name)
+				return nil, "", fmt.Errorf("Unable to resolve symlink: %s", name)
 			}
-			deviceConf = &DeviceConf{
-				Name:        deviceRealPath,
-				GivenName:   name,
-				Idle:        config.Defaults.Idle,
-				CommandType: config.Defaults.CommandType,
+			deviceConf.WakeWith = append(deviceConf.WakeWith, leader)
+
+		case "--backup-window":
+			if deviceConf == nil {
+				return nil, "", errors.New("Missing disk for --backup-window. Must follow -a <name>")
 			}
+			s := args[index+1]
+			window, err := hdidle.ParseDuration(s)
+			if err != nil || window == 0 {
+				return nil, "", fmt.Errorf("Wrong backup_window --backup-window %s. Must be a positive time, e.g. 600 or 10m", s)
+			}
+			deviceConf.BackupWindow = window
 
-		case "-i":
-			s := os.Args[index+2]
-			idle, err := strconv.Atoi(s)
+		case "--namespace":
+			if deviceConf == nil {
+				return nil, "", errors.New("Missing disk for --namespace. Must follow -a <name>")
+			}
+			namespace := args[index+1]
+			if !hdidle.ValidNamespace(namespace) {
+				return nil, "", fmt.Errorf("Wrong name --namespace %s. Must be letters, digits, dots, dashes and underscores", namespace)
+			}
+			deviceConf.Namespace = namespace
+
+		case "--tag":
+			if deviceConf == nil {
+				return nil, "", errors.New("Missing disk for --tag. Must follow -a <name>")
+			}
+			key, value, err := hdidle.ParseTag(args[index+1])
 			if err != nil {
-				fmt.Printf("Wrong idle_time -i %d. Must be a number", idle)
-				os.Exit(1)
+				return nil, "", fmt.Errorf("Wrong tag --tag %s: %s", args[index+1], err)
+			}
+			deviceConf.Tags = withTag(deviceConf.Tags, key, value)
+
+		case "--disk-log":
+			if deviceConf == nil {
+				return nil, "", errors.New("Missing disk for --disk-log. Must follow -a <name>")
 			}
+			deviceConf.LogFile = args[index+1]
+
+		case "--disk-debug":
 			if deviceConf == nil {
-				config.Defaults.Idle = time.Duration(idle) * time.Second
-				break
+				return nil, "", errors.New("Missing disk for --disk-debug. Must follow -a <name>")
 			}
-			deviceConf.Idle = time.Duration(idle) * time.Second
+			deviceConf.Debug = true
+
+		case "--passthrough":
+			if deviceConf == nil {
+				return nil, "", errors.New("Missing disk for --passthrough. Must follow -a <name>")
+			}
+			policy := args[index+1]
+			if policy != hdidle.PassthroughLeave && policy != hdidle.PassthroughShutoff {
+				return nil, "", fmt.Errorf("Wrong passthrough --passthrough %s. Must be one of: leave, shutoff", policy)
+			}
+			deviceConf.Passthrough = policy
+
+		case "--power-meter":
+			if deviceConf == nil {
+				return nil, "", errors.New("Missing disk for --power-meter. Must follow -a <name>")
+			}
+			meter := args[index+1]
+			if err := hdidle.ValidPowerMeter(meter); err != nil {
+				return nil, "", fmt.Errorf("Wrong power_meter --power-meter %s: %s", meter, err)
+			}
+			deviceConf.PowerMeter = meter
+
+		case "--manage-ssd":
+			if deviceConf == nil {
+				return nil, "", errors.New("Missing disk for --manage-ssd. Must follow -a <name>")
+			}
+			deviceConf.ManageSsd = true
+
+		case "--class":
+			if deviceConf == nil {
+				return nil, "", errors.New("Missing disk for --class. Must follow -a <name>")
+			}
+			class := config.Class(args[index+1])
+			if class == nil {
+				return nil, "", fmt.Errorf("Unknown class --class %s. Must be defined before with --define-class", args[index+1])
+			}
+			deviceConf.Class = class.Name
+			deviceConf.Idle = class.Idle
+			deviceConf.BatteryIdle = class.BatteryIdle
+			deviceConf.ProfileIdles = class.ProfileIdles
+			deviceConf.CommandType = class.CommandType
+			deviceConf.UsbPowerOff = class.UsbPowerOff
+			deviceConf.SataLpm = class.SataLpm
+			deviceConf.WaitMounts = class.WaitMounts
+			deviceConf.AwakeWindows = class.AwakeWindows
+
+		case "-i":
+			s := args[index+1]
+			idle, err := hdidle.ParseDuration(s)
+			if err != nil {
+				return nil, "", fmt.Errorf("Wrong idle_time -i %s. Must be a time, e.g. 600 or 10m", s)
+			}
+			switch {
+			case deviceConf != nil:
+				deviceConf.Idle = idle
+			case classConf != nil:
+				classConf.Idle = idle
+			default:
+				config.Defaults.Idle = idle
+			}
+
+		case "--battery-idle":
+			s := args[index+1]
+			idle, err := hdidle.ParseDuration(s)
+			if err != nil {
+				return nil, "", fmt.Errorf("Wrong battery_idle --battery-idle %s. Must be a time, e.g. 120 or 2m", s)
+			}
+			switch {
+			case deviceConf != nil:
+				deviceConf.BatteryIdle = idle
+			case classConf != nil:
+				classConf.BatteryIdle = idle
+			default:
+				config.Defaults.BatteryIdle = idle
+			}
+
+		case "--profile-idle":
+			s := args[index+1]
+			i := strings.Index(s, "=")
+			if i < 0 || !hdidle.ValidProfileName(s[:i]) {
+				return nil, "", fmt.Errorf("Wrong profile --profile-idle %s. Must be <profile>=<time>, the profile letters, digits, dashes and underscores, e.g. night=5m", s)
+			}
+			idle, err := hdidle.ParseDuration(s[i+1:])
+			if err != nil {
+				return nil, "", fmt.Errorf("Wrong idle_time --profile-idle %s. Must be a time, e.g. night=300 or night=5m", s)
+			}
+			switch {
+			case deviceConf != nil:
+				deviceConf.ProfileIdles = withProfileIdle(deviceConf.ProfileIdles, s[:i], idle)
+			case classConf != nil:
+				classConf.ProfileIdles = withProfileIdle(classConf.ProfileIdles, s[:i], idle)
+			default:
+				config.Defaults.ProfileIdles = withProfileIdle(config.Defaults.ProfileIdles, s[:i], idle)
+			}
+
+		case "--profile":
+			config.Defaults.Profile = args[index+1]
 
 		case "-c":
-			command := os.Args[index+2]
+			command := args[index+1]
 			switch command {
-			case SCSI, ATA:
-				if deviceConf == nil {
+			case hdidle.SCSI, hdidle.ATA:
+				switch {
+				case deviceConf != nil:
+					deviceConf.CommandType = command
+				case classConf != nil:
+					classConf.CommandType = command
+				default:
 					config.Defaults.CommandType = command
-					break
 				}
-				deviceConf.CommandType = command
 			default:
-				fmt.Printf("Wrong command_type -c %s. Must be one of: scsi, ata", command)
-				os.Exit(1)
+				return nil, "", fmt.Errorf("Wrong command_type -c %s. Must be one of: scsi, ata", command)
+			}
+
+		case "--usb-power-off":
+			switch {
+			case deviceConf != nil:
+				deviceConf.UsbPowerOff = true
+			case classConf != nil:
+				classConf.UsbPowerOff = true
+			default:
+				config.Defaults.UsbPowerOff = true
+			}
+
+		case "--sata-lpm":
+			policy := args[index+1]
+			switch policy {
+			case "min_power", "med_power_with_dipm", "medium_power":
+			default:
+				return nil, "", fmt.Errorf("Wrong sata_lpm --sata-lpm %s. Must be one of: min_power, med_power_with_dipm, medium_power", policy)
+			}
+			switch {
+			case deviceConf != nil:
+				deviceConf.SataLpm = policy
+			case classConf != nil:
+				classConf.SataLpm = policy
+			default:
+				config.Defaults.SataLpm = policy
 			}
 
+		case "--wait-mount":
+			mountPoint := args[index+1]
+			switch {
+			case deviceConf != nil:
+				deviceConf.WaitMounts = withWaitMount(deviceConf.WaitMounts, mountPoint)
+			case classConf != nil:
+				classConf.WaitMounts = withWaitMount(classConf.WaitMounts, mountPoint)
+			default:
+				config.Defaults.WaitMounts = withWaitMount(config.Defaults.WaitMounts, mountPoint)
+			}
+
+		case "--wait-mount-timeout":
+			s := args[index+1]
+			timeout, err := hdidle.ParseDuration(s)
+			if err != nil {
+				return nil, "", fmt.Errorf("Wrong wait_mount_timeout --wait-mount-timeout %s. Must be a time, e.g. 600 or 10m", s)
+			}
+			config.Defaults.WaitMountTimeout = timeout
+
+		case "--skew":
+			s := args[index+1]
+			if deviceConf != nil {
+				/* off or 0 never takes a long cycle for a suspend of the disk */
+				skew, err := hdidle.ParseSkew(s)
+				if err != nil {
+					return nil, "", fmt.Errorf("Wrong skew_time --skew %s. Must be a time, e.g. 600 or 10m, or off", s)
+				}
+				deviceConf.SkewTime = skew
+				break
+			}
+			skew, err := hdidle.ParseDuration(s)
+			if err != nil || skew == 0 {
+				return nil, "", fmt.Errorf("Wrong skew_time --skew %s. Must be a positive time, e.g. 600 or 10m", s)
+			}
+			config.SkewTime = skew
+
+		case "--probe-window":
+			s := args[index+1]
+			window, err := hdidle.ParseDuration(s)
+			if err != nil {
+				return nil, "", fmt.Errorf("Wrong probe_window --probe-window %s. Must be a time, e.g. 120 or 2m", s)
+			}
+			config.Defaults.ProbeWindow = window
+
+		case "--hba-runtime-pm":
+			config.Defaults.HbaRuntimePm = true
+
+		case "--usb-hub-spacing":
+			s := args[index+1]
+			spacing, err := hdidle.ParseDuration(s)
+			if err != nil {
+				return nil, "", fmt.Errorf("Wrong usb_hub_spacing --usb-hub-spacing %s. Must be a time, e.g. 600 or 10m", s)
+			}
+			config.Defaults.UsbHubSpacing = spacing
+
+		case "--smart-interval":
+			s := args[index+1]
+			interval, err := hdidle.ParseDuration(s)
+			if err != nil {
+				return nil, "", fmt.Errorf("Wrong smart_interval --smart-interval %s. Must be a time, e.g. 600 or 10m", s)
+			}
+			config.Defaults.SmartInterval = interval
+
+		case "--standby-read-ahead":
+			s := args[index+1]
+			kb, err := strconv.Atoi(s)
+			if err != nil || kb < 0 {
+				return nil, "", fmt.Errorf("Wrong read-ahead --standby-read-ahead %s. Must be a number of KiB", s)
+			}
+			config.Defaults.StandbyReadAhead = kb
+
+		case "--unsupported":
+			policy := args[index+1]
+			switch policy {
+			case hdidle.UnsupportedGiveUp, hdidle.UnsupportedRetry, hdidle.UnsupportedRuntimePm:
+			default:
+				return nil, "", fmt.Errorf("Wrong policy --unsupported %s. Must be one of: give-up, retry, runtime-pm", policy)
+			}
+			config.Defaults.Unsupported = policy
+
+		case "--replacement":
+			policy := args[index+1]
+			switch policy {
+			case hdidle.ReplacementOff, hdidle.ReplacementReport, hdidle.ReplacementInherit:
+			default:
+				return nil, "", fmt.Errorf("Wrong policy --replacement %s. Must be one of: off, report, inherit", policy)
+			}
+			config.Defaults.Replacement = policy
+
+		case "--simulate":
+			simulation, err := hdidle.ParseSimulation(args[index+1])
+			if err != nil {
+				return nil, "", fmt.Errorf("Wrong --simulate: %s", err)
+			}
+			config.Defaults.Simulation = simulation
+
+		case "--state-dir":
+			config.Defaults.StateDir = args[index+1]
+
+		case "--checkpoint-interval":
+			s := args[index+1]
+			interval, err := hdidle.ParseDuration(s)
+			if err != nil {
+				return nil, "", fmt.Errorf("Wrong checkpoint_interval --checkpoint-interval %s. Must be a time, e.g. 600 or 10m", s)
+			}
+			config.Defaults.CheckpointInterval = interval
+
+		case "--wake-storm":
+			s := args[index+1]
+			disks, err := strconv.Atoi(s)
+			if err != nil || disks < 0 {
+				return nil, "", fmt.Errorf("Wrong wake storm disks --wake-storm %s. Must be a number", s)
+			}
+			config.Defaults.WakeStormDisks = disks
+
+		case "--wake-storm-window":
+			s := args[index+1]
+			window, err := hdidle.ParseDuration(s)
+			if err != nil || window == 0 {
+				return nil, "", fmt.Errorf("Wrong wake storm window --wake-storm-window %s. Must be a positive time, e.g. 600 or 10m", s)
+			}
+			config.Defaults.WakeStormWindow = window
+
+		case "--advisor":
+			config.Defaults.Advisor = true
+
+		case "--watchdog":
+			s := args[index+1]
+			factor, err := strconv.Atoi(s)
+			if err != nil || factor < 0 {
+				return nil, "", fmt.Errorf("Wrong watchdog factor --watchdog %s. Must be a number, 0 to disable", s)
+			}
+			config.Defaults.WatchdogFactor = factor
+
+		case "--breaker-threshold":
+			s := args[index+1]
+			threshold, err := strconv.Atoi(s)
+			if err != nil || threshold < 0 {
+				return nil, "", fmt.Errorf("Wrong breaker threshold --breaker-threshold %s. Must be a number, 0 to disable", s)
+			}
+			config.Defaults.BreakerThreshold = threshold
+
+		case "--breaker-cooldown":
+			s := args[index+1]
+			cooldown, err := hdidle.ParseDuration(s)
+			if err != nil || cooldown == 0 {
+				return nil, "", fmt.Errorf("Wrong breaker cooldown --breaker-cooldown %s. Must be a positive time, e.g. 600 or 10m", s)
+			}
+			config.Defaults.BreakerCooldown = cooldown
+
+		case "--awake":
+			window, err := hdidle.ParseAwakeWindow(args[index+1])
+			if err != nil {
+				return nil, "", fmt.Errorf("Wrong awake window --awake %s: %s", args[index+1], err)
+			}
+			switch {
+			case deviceConf != nil:
+				deviceConf.AwakeWindows = withAwakeWindow(deviceConf.AwakeWindows, window)
+			case classConf != nil:
+				classConf.AwakeWindows = withAwakeWindow(classConf.AwakeWindows, window)
+			default:
+				config.Defaults.AwakeWindows = withAwakeWindow(config.Defaults.AwakeWindows, window)
+			}
+
+		case "--quirks":
+			config.Defaults.QuirksFile = args[index+1]
+			if _, err := quirks.Load(config.Defaults.QuirksFile); err != nil {
+				return nil, "", fmt.Errorf("Cannot load quirks file %s: %s", config.Defaults.QuirksFile, err)
+			}
+
+		case "--learn-quirks":
+			config.Defaults.LearnQuirks = true
+
 		case "-l":
-			config.Defaults.LogFile = os.Args[index+2]
+			config.Defaults.LogFile = args[index+1]
+
+		case "--log-buffer":
+			config.Defaults.LogBuffer = true
+
+		case "--log-fallback":
+			config.Defaults.LogBuffer = true
+			config.Defaults.LogFallback = args[index+1]
+
+		case "--trace":
+			config.Defaults.TraceFile = args[index+1]
+
+		case "--audit-opens":
+			config.Defaults.AuditOpens = args[index+1]
+
+		case "--watch-config":
+			config.Defaults.WatchConfig = true
+
+		case "--self-metrics":
+			config.Defaults.SelfMetrics = true
+
+		case "--daily-report":
+			s := args[index+1]
+			at, err := hdidle.ParseDailyReport(s)
+			if err != nil {
+				return nil, "", fmt.Errorf("Wrong daily_report --daily-report %s. Must be a time of day hh:mm, e.g. 06:00, or off", s)
+			}
+			config.Defaults.DailyReport = at
+
+		case "--opt-in":
+			config.Defaults.OptIn = true
+
+		case "--manage-root":
+			config.Defaults.ManageRoot = true
+
+		case "--manage-swap":
+			config.Defaults.ManageSwap = true
+
+		case "--manual-hold":
+			s := args[index+1]
+			hold, err := hdidle.ParseDuration(s)
+			if err != nil {
+				return nil, "", fmt.Errorf("Wrong manual_hold --manual-hold %s. Must be a time, e.g. 1800 or 30m", s)
+			}
+			config.Defaults.ManualHold = hold
+
+		case "--stacked-io":
+			config.Defaults.StackedIo = true
+
+		case "--exports":
+			config.Defaults.Exports = true
+
+		case "--power-drop":
+			s := args[index+1]
+			watts, err := strconv.ParseFloat(s, 64)
+			if err != nil || watts <= 0 {
+				return nil, "", fmt.Errorf("Wrong power_drop --power-drop %s. Must be a positive number of watts", s)
+			}
+			config.Defaults.PowerDrop = watts
+
+		case "--sshd-idle":
+			s := args[index+1]
+			idle, err := hdidle.ParseDuration(s)
+			if err != nil {
+				return nil, "", fmt.Errorf("Wrong sshd_idle --sshd-idle %s. Must be a time, e.g. 600 or 10m", s)
+			}
+			config.Defaults.SshdIdle = idle
+
+		case "--smr-idle":
+			s := args[index+1]
+			idle, err := hdidle.ParseDuration(s)
+			if err != nil {
+				return nil, "", fmt.Errorf("Wrong smr_idle --smr-idle %s. Must be a time, e.g. 600 or 10m", s)
+			}
+			config.Defaults.SmrIdle = idle
+
+		case "--smr-gc-wait":
+			s := args[index+1]
+			wait, err := hdidle.ParseDuration(s)
+			if err != nil {
+				return nil, "", fmt.Errorf("Wrong smr_gc_wait --smr-gc-wait %s. Must be a time, e.g. 900 or 15m", s)
+			}
+			config.Defaults.SmrGcWait = wait
+
+		case "--log-format":
+			s := args[index+1]
+			if s != hdidle.LogFormatText && s != hdidle.LogFormatKeyValue {
+				return nil, "", fmt.Errorf("Wrong log_format --log-format %s. Must be one of: text, key-value", s)
+			}
+			config.Defaults.LogFormat = s
+
+		case "--log-fallback-timeout":
+			s := args[index+1]
+			timeout, err := hdidle.ParseDuration(s)
+			if err != nil {
+				return nil, "", fmt.Errorf("Wrong log_fallback_timeout --log-fallback-timeout %s. Must be a time, e.g. 600 or 10m", s)
+			}
+			config.Defaults.LogFallbackTimeout = timeout
+
+		case "--listen":
+			config.Defaults.Listen = append(config.Defaults.Listen, args[index+1])
+
+		case "--listen-control":
+			config.Defaults.ControlListen = append(config.Defaults.ControlListen, args[index+1])
+
+		case "--control":
+			config.Defaults.Control = true
+
+		case "--read-allow":
+			config.Defaults.ReadAllow = append(config.Defaults.ReadAllow, args[index+1])
+
+		case "--control-allow":
+			config.Defaults.ControlAllow = append(config.Defaults.ControlAllow, args[index+1])
+
+		case "--api-tokens":
+			config.Defaults.ApiTokens = args[index+1]
+			if err := loadApiTokens(config.Defaults.ApiTokens); err != nil {
+				return nil, "", fmt.Errorf("Cannot load API tokens %s: %s", config.Defaults.ApiTokens, err)
+			}
+
+		case "--webhook":
+			config.Defaults.Webhooks = append(config.Defaults.Webhooks, args[index+1])
+
+		case "--push":
+			config.Defaults.Push = args[index+1]
+
+		case "--push-interval":
+			s := args[index+1]
+			interval, err := hdidle.ParseDuration(s)
+			if err != nil || interval == 0 {
+				return nil, "", fmt.Errorf("Wrong push_interval --push-interval %s. Must be a positive time, e.g. 600 or 10m", s)
+			}
+			config.Defaults.PushInterval = interval
 
 		case "-d":
 			config.Defaults.Debug = true
 
-		case "h":
-			fmt.Println("usage: hd-idle [-t <disk>] [-s <symlink_policy>] [-a <name>] [-i <idle_time>] " +
-				"[-c <command_type>] [-l <logfile>] [-d] [-h]")
-			os.Exit(0)
-		}
-	}
+		case "--inhibit-suspend":
+			config.Defaults.InhibitSuspend = true
 
-	if singleDiskMode {
-		if err := spindownDisk(disk, config.Defaults.CommandType); err != nil {
-			fmt.Println(err.Error())
-			os.Exit(1)
+		case "--background-check":
+			config.Defaults.BackgroundCheck = true
+
+		case "--crash-dir":
+			config.Defaults.CrashDir = args[index+1]
+
+		case "--read-only":
+			config.Defaults.ReadOnly = true
+
+		case "h":
+			return nil, "", errHelp
 		}
-		os.Exit(0)
 	}
 
 	if deviceConf != nil {
 		config.Devices = append(config.Devices, *deviceConf)
 	}
-	fmt.Println(config.String())
+	if classConf != nil {
+		config.Classes = append(config.Classes, *classConf)
+	}
+	if len(config.Defaults.Profile) > 0 && !config.HasProfile(config.Defaults.Profile) {
+		return nil, "", fmt.Errorf("Unknown profile --profile %s. Must be given idle times with --profile-idle", config.Defaults.Profile)
+	}
+
+	return config, disk, nil
+}
 
-	interval := poolInterval(config.Devices)
-	config.SkewTime = interval * 3
-	for {
-		ObserveDiskActivity(config)
-		time.Sleep(interval)
+/* the --config file first, then the files of the --config-dir directories */
+func configFiles(args []string) ([]string, error) {
+	var files, dropIns []string
+	for index, arg := range args {
+		switch arg {
+		case "--config":
+			files = append(files, args[index+1])
+		case "--config-dir":
+			dir, err := hdidle.ConfigDirFiles(args[index+1])
+			if err != nil {
+				return nil, err
+			}
+			dropIns = append(dropIns, dir...)
+		}
 	}
+	return append(files, dropIns...), nil
 }
 
-func poolInterval(deviceConfs []DeviceConf) time.Duration {
-	if len(deviceConfs) == 0 {
-		return defaultIdleTime / 10
+/*
+ * Read the options and the configuration file again. The files and disks they
+ * name are checked first, so a mistake doesn't end the running hd-idle.
+ */
+func reload(monitor *hdidle.Monitor, args []string) error {
+	config, _, err := parseArgs(args)
+	if err != nil {
+		return err
 	}
+	if err := config.MissingDisks(); err != nil {
+		return err
+	}
+	return monitor.Reload(config)
+}
+
+/* append to a copy, the slice may be shared with the defaults or a class */
+func withAwakeWindow(windows []hdidle.AwakeWindow, window hdidle.AwakeWindow) []hdidle.AwakeWindow {
+	return append(append([]hdidle.AwakeWindow{}, windows...), window)
+}
 
-	interval := defaultIdleTime
-	for _, dev := range deviceConfs {
-		if dev.Idle < interval {
-			interval = dev.Idle
+func withWaitMount(mountPoints []string, mountPoint string) []string {
+	return append(append([]string{}, mountPoints...), mountPoint)
+}
+
+func withTag(tags map[string]string, key, value string) map[string]string {
+	copied := map[string]string{key: value}
+	for name, tag := range tags {
+		if name != key {
+			copied[name] = tag
 		}
 	}
+	return copied
+}
 
-	sleepTime := interval / 10
-	if sleepTime == 0 {
-		return time.Second
+func withProfileIdle(idles map[string]time.Duration, profile string, idle time.Duration) map[string]time.Duration {
+	copied := map[string]time.Duration{profile: idle}
+	for name, profileIdle := range idles {
+		if name != profile {
+			copied[name] = profileIdle
+		}
 	}
-	return sleepTime
+	return copied
 }
@@ -0,0 +1,176 @@
+// hd-idle - spin down idle hard disks
+// Copyright (C) 2018  Andoni del Olmo
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"github.com/adelolmo/hd-idle/hdidle"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+/* a configuration file in a temporary directory, removed by the returned function */
+func configFile(t *testing.T, name, content string) (string, func()) {
+	dir, err := ioutil.TempDir("", "hd-idle")
+	if err != nil {
+		t.Fatal(err)
+	}
+	file := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(file, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return file, func() { os.RemoveAll(dir) }
+}
+
+/* a monitor started with the options, quiet */
+func startedMonitor(t *testing.T, args []string) *hdidle.Monitor {
+	config, _, err := parseArgs(args)
+	if err != nil {
+		t.Fatalf("Expected the options to be read but found: %s", err)
+	}
+	monitor := hdidle.New(config)
+	monitor.SetOutput(ioutil.Discard)
+	return monitor
+}
+
+func TestReloadKeepsConfigurationOfUnknownProfile(t *testing.T) {
+	file, remove := configFile(t, "hd-idle.conf",
+		"log_file = \"/tmp/before.log\"\nprofile = \"night\"\nprofile.night.idle = 300\n")
+	defer remove()
+	args := []string{"--config", file}
+	monitor := startedMonitor(t, args)
+
+	if err := ioutil.WriteFile(file, []byte("log_file = \"/tmp/after.log\"\nprofile = \"night\"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	err := reload(monitor, args)
+	if err == nil || !strings.Contains(err.Error(), "Unknown profile") {
+		t.Fatalf("Expected the reload to refuse the unknown profile but found %v", err)
+	}
+	if monitor.LogFile() != "/tmp/before.log" || monitor.Profile() != "night" {
+		t.Fatalf("Expected the running configuration kept but found log file %s, profile %s",
+			monitor.LogFile(), monitor.Profile())
+	}
+
+	if err := ioutil.WriteFile(file, []byte("log_file = \"/tmp/after.log\"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := reload(monitor, args); err != nil {
+		t.Fatalf("Expected the fixed file to be reloaded but found: %s", err)
+	}
+	if monitor.LogFile() != "/tmp/after.log" {
+		t.Fatalf("Expected the reloaded log file but found %s", monitor.LogFile())
+	}
+}
+
+func TestReloadKeepsConfigurationOfWrongFile(t *testing.T) {
+	file, remove := configFile(t, "hd-idle.conf", "log_file = \"/tmp/before.log\"\n")
+	defer remove()
+	args := []string{"--config", file}
+	monitor := startedMonitor(t, args)
+
+	if err := ioutil.WriteFile(file, []byte("log_file = \"/tmp/after.log\"\nidle = soon\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := reload(monitor, args); err == nil {
+		t.Fatal("Expected the reload to refuse the file")
+	}
+	if monitor.LogFile() != "/tmp/before.log" {
+		t.Fatalf("Expected the running configuration kept but found log file %s", monitor.LogFile())
+	}
+}
+
+func TestParseArgsReturnsErrors(t *testing.T) {
+	for _, args := range [][]string{
+		{"-i", "soon"},
+		{"--alias", "parity"},
+		{"-c", "sata"},
+		{"--profile", "night"},
+		{"--config", "/nonexistent/hd-idle.conf"},
+	} {
+		if _, _, err := parseArgs(args); err == nil || err == errHelp {
+			t.Fatalf("Expected an error for %v but found %v", args, err)
+		}
+	}
+	if _, _, err := parseArgs([]string{"h"}); err != errHelp {
+		t.Fatalf("Expected the usage to be asked for but found %v", err)
+	}
+}
+
+func TestClasses(t *testing.T) {
+	config, _, err := parseArgs([]string{"-i", "600", "--profile-idle", "night=300",
+		"--define-class", "archive", "-i", "3600", "-c", "ata", "--usb-power-off", "--profile-idle", "night=7200",
+		"-a", "sdb", "--class", "archive",
+		"-a", "sdc", "--class", "archive", "-i", "120", "--profile-idle", "night=60",
+		"-a", "sdd"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	class := config.Class("archive")
+	if class == nil || class.Idle != time.Hour || class.CommandType != hdidle.ATA || !class.UsbPowerOff ||
+		class.ProfileIdles["night"] != 2*time.Hour {
+		t.Fatalf("Expected the class archive but found %v", config.Classes)
+	}
+	expected := map[string]struct {
+		class       string
+		idle        time.Duration
+		night       time.Duration
+		command     string
+		usbPowerOff bool
+	}{
+		"sdb": {"archive", time.Hour, 2 * time.Hour, hdidle.ATA, true},
+		"sdc": {"archive", 2 * time.Minute, time.Minute, hdidle.ATA, true},
+		"sdd": {"", 10 * time.Minute, 5 * time.Minute, hdidle.SCSI, false},
+	}
+	if len(config.Devices) != len(expected) {
+		t.Fatalf("Expected %d disks but found %d", len(expected), len(config.Devices))
+	}
+	for _, device := range config.Devices {
+		e := expected[device.GivenName]
+		if device.Class != e.class || device.Idle != e.idle || device.ProfileIdles["night"] != e.night ||
+			device.CommandType != e.command || device.UsbPowerOff != e.usbPowerOff {
+			t.Fatalf("Expected %s with %v but found %s", device.GivenName, e, device.String())
+		}
+	}
+	if class.ProfileIdles["night"] != 2*time.Hour {
+		t.Fatalf("Expected the override of sdc to leave the class alone but found %v", class.ProfileIdles)
+	}
+
+	for _, args := range [][]string{
+		{"-a", "sdb", "--class", "archive"},
+		{"--define-class", "archive", "--class", "archive"},
+	} {
+		if _, _, err := parseArgs(args); err == nil {
+			t.Fatalf("Expected an error for %v", args)
+		}
+	}
+}
+
+func TestUsbPowerOnReadOnly(t *testing.T) {
+	args := []string{"--read-only", "--usb-power-on", "1-1.2", "-d"}
+	err := usbPowerOn(args, 1)
+	if err == nil || !strings.Contains(err.Error(), "read-only mode does not allow powering on usb port 1-1.2") {
+		t.Fatalf("Expected read-only mode to refuse --usb-power-on but found %v", err)
+	}
+	if err := usbPowerOn([]string{"--usb-power-on"}, 0); err == nil || !strings.Contains(err.Error(), "Missing port") {
+		t.Fatalf("Expected the missing port told but found %v", err)
+	}
+}
@@ -0,0 +1,117 @@
+// hd-idle - spin down idle hard disks
+// Copyright (C) 2018  Andoni del Olmo
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+//go:build minimal
+// +build minimal
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/adelolmo/hd-idle/hdidle"
+	"os"
+)
+
+/*
+ * The minimal build, go build -tags minimal, leaves out everything that talks
+ * over the network: the API and its listeners, the webhooks, pushing to a hub,
+ * the power meters and the commands talking to a running hd-idle. It is meant
+ * for routers and small boards, where a smaller binary that opens no sockets
+ * is worth more than the NAS features.
+ */
+
+var errMinimalBuild = errors.New("not part of the minimal build")
+
+/* the JSON object of a failed one-shot command, as api.CommandError */
+type commandError struct {
+	SchemaVersion int    `json:"schema_version"`
+	Command       string `json:"command"`
+	Disk          string `json:"disk,omitempty"`
+	Failure       string `json:"failure"`
+	ExitCode      int    `json:"exit_code"`
+	Message       string `json:"message"`
+}
+
+/* exit if the configuration asks for the network, there is nothing else to start */
+func startNetwork(monitor *hdidle.Monitor, config *hdidle.Config) {
+	if options := unavailableOptions(config); len(options) > 0 {
+		for _, option := range options {
+			fmt.Printf("%s: not part of the minimal build\n", option)
+		}
+		os.Exit(1)
+	}
+}
+
+func loadApiTokens(file string) error {
+	return errMinimalBuild
+}
+
+func remoteSpinup(url, disk string) error {
+	return errMinimalBuild
+}
+
+/* the options the build cannot honour */
+func unavailableOptions(config *hdidle.Config) []string {
+	var options []string
+	for _, address := range config.Defaults.Listen {
+		options = append(options, "--listen "+address)
+	}
+	for _, address := range config.Defaults.ControlListen {
+		options = append(options, "--listen-control "+address)
+	}
+	for _, url := range config.Defaults.Webhooks {
+		options = append(options, "--webhook "+url)
+	}
+	if len(config.Defaults.Push) > 0 {
+		options = append(options, "--push "+config.Defaults.Push)
+	}
+	for _, device := range config.Devices {
+		if len(device.PowerMeter) > 0 {
+			options = append(options, "--power-meter "+device.PowerMeter)
+		}
+	}
+	return options
+}
+
+func hub(args []string) {
+	unavailable("hub")
+}
+
+func pause(args []string) {
+	unavailable("pause")
+}
+
+func resume(args []string) {
+	unavailable("resume")
+}
+
+func profile(args []string) {
+	unavailable("profile")
+}
+
+func annotate(args []string) {
+	unavailable("annotate")
+}
+
+func report(args []string) {
+	unavailable("report")
+}
+
+func unavailable(command string) {
+	fmt.Printf("hd-idle %s: %s\n", command, errMinimalBuild)
+	os.Exit(1)
+}
@@ -0,0 +1,116 @@
+// hd-idle - spin down idle hard disks
+// Copyright (C) 2018  Andoni del Olmo
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+//go:build !minimal
+// +build !minimal
+
+package main
+
+import (
+	"fmt"
+	"github.com/adelolmo/hd-idle/api"
+	"github.com/adelolmo/hd-idle/hdidle"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+)
+
+/* deliver the events to the webhooks and the hub, and serve the API on the listeners */
+func startNetwork(monitor *hdidle.Monitor, config *hdidle.Config) {
+	for _, url := range config.Defaults.Webhooks {
+		monitor.AddSink("webhook "+url, api.NewWebhook(url), hdidle.DefaultSinkQueueSize)
+	}
+	if len(config.Defaults.Push) > 0 {
+		host, err := os.Hostname()
+		if err != nil {
+			fmt.Printf("Cannot get the host name for --push: %s\n", err)
+			os.Exit(1)
+		}
+		pusher := api.NewPusher(config.Defaults.Push, host, config.Defaults.PushInterval)
+		monitor.AddSink("push "+config.Defaults.Push, pusher, hdidle.DefaultSinkQueueSize)
+		go pusher.Run(monitor, monitor.Stopping())
+	}
+	handler := api.NewHandler(monitor)
+	if config.Defaults.Control {
+		handler = api.NewControlHandler(monitor)
+	}
+	/* with tokens, every client of a tcp address needs one */
+	var tokens []api.Token
+	if len(config.Defaults.ApiTokens) > 0 {
+		var err error
+		if tokens, err = api.LoadTokens(config.Defaults.ApiTokens); err != nil {
+			fmt.Printf("Cannot load API tokens %s: %s\n", config.Defaults.ApiTokens, err)
+			os.Exit(1)
+		}
+	}
+	withTokens := func(handler http.Handler, control bool) http.Handler {
+		if len(tokens) == 0 {
+			return handler
+		}
+		return api.NewTokenHandler(monitor, tokens, control, handler)
+	}
+	peers := api.PeerPolicy{Read: config.Defaults.ReadAllow, Control: config.Defaults.ControlAllow}
+	for _, address := range config.Defaults.Listen {
+		if strings.HasPrefix(address, "unix:") {
+			serve(address, withTokens(api.NewPeerHandler(monitor, peers), true))
+			continue
+		}
+		serve(address, withTokens(handler, config.Defaults.Control))
+	}
+	for _, address := range config.Defaults.ControlListen {
+		if strings.HasPrefix(address, "unix:") {
+			serve(address, withTokens(api.NewPeerHandler(monitor, peers), true))
+			continue
+		}
+		serve(address, withTokens(api.NewControlHandler(monitor), true))
+	}
+}
+
+/* serve the API on every listener of the address, exit if it cannot be opened */
+func serve(address string, handler http.Handler) {
+	listeners, err := api.Listen(address)
+	if err != nil {
+		fmt.Printf("Cannot listen on %s: %s\n", address, err)
+		os.Exit(1)
+	}
+	for _, listener := range listeners {
+		go func(listener net.Listener) {
+			if err := http.Serve(listener, handler); err != nil {
+				fmt.Printf("API server on %s stopped: %s\n", listener.Addr(), err)
+			}
+		}(listener)
+	}
+}
+
+func loadApiTokens(file string) error {
+	_, err := api.LoadTokens(file)
+	return err
+}
+
+/* spin a disk up through the control API of a running hd-idle */
+func remoteSpinup(url, disk string) error {
+	_, err := api.SpinUp(url, disk)
+	return err
+}
+
+/* the JSON object of a failed one-shot command */
+type commandError = api.CommandError
+
+/* the options the build cannot honour, none in the full build */
+func unavailableOptions(config *hdidle.Config) []string {
+	return nil
+}
@@ -0,0 +1,50 @@
+// hd-idle - spin down idle hard disks
+// Copyright (C) 2018  Andoni del Olmo
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+//go:build !minimal
+// +build !minimal
+
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func TestReloadKeepsConfigurationOfBrokenTokens(t *testing.T) {
+	file, remove := configFile(t, "hd-idle.conf", "log_file = \"/tmp/before.log\"\n")
+	defer remove()
+	tokens := filepath.Join(filepath.Dir(file), "tokens")
+	if err := ioutil.WriteFile(tokens, []byte("media control 5c0f6d1e9a8b4c27b3e1f0a9d8c7b6a5\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	args := []string{"--config", file, "--api-tokens", tokens}
+	monitor := startedMonitor(t, args)
+
+	if err := ioutil.WriteFile(file, []byte("log_file = \"/tmp/after.log\"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(tokens, []byte("media control short\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := reload(monitor, args); err == nil {
+		t.Fatal("Expected the reload to refuse the broken tokens file")
+	}
+	if monitor.LogFile() != "/tmp/before.log" {
+		t.Fatalf("Expected the running configuration kept but found log file %s", monitor.LogFile())
+	}
+}
@@ -0,0 +1,153 @@
+// hd-idle - spin down idle hard disks
+// Copyright (C) 2018  Andoni del Olmo
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/adelolmo/hd-idle/hdidle"
+	"github.com/adelolmo/hd-idle/io"
+	"github.com/adelolmo/hd-idle/sgio"
+	"os"
+	"strings"
+)
+
+const oneShotUsage = "usage: hd-idle spindown|spinup|check|identify [-c <command_type>] [--json] <disk>\n" +
+	"       hd-idle spinup --url <url> [--json] <disk>"
+
+/* the exit code of each failure class, documented in the README */
+var exitCodes = map[string]int{
+	hdidle.FailureUsage:         1,
+	hdidle.FailureNoDisk:        2,
+	hdidle.FailurePermission:    3,
+	hdidle.FailureUnsupported:   4,
+	hdidle.FailureBusy:          5,
+	hdidle.FailureCommand:       6,
+	hdidle.FailureStillSpinning: 7,
+}
+
+/*
+hd-idle spindown|spinup|check|identify [-c <command_type>] [--json] <disk>
+sends a single command to a disk and exits: spindown and spinup the stop and
+start commands, check prints whether the disk is spun down and identify the
+IDENTIFY DEVICE data of an ata disk. Failures exit with the code of their
+class, and with --json also write an api.CommandError to stderr.
+
+hd-idle spinup --url <url> <disk> asks the hd-idle instance at url to spin
+the disk up instead, so that it holds the disk for its --manual-hold.
+*/
+func oneShot(command string, args []string) {
+	var disk string
+	var jsonErrors bool
+	var url string
+	commandType := hdidle.SCSI
+	for index := 0; index < len(args); index++ {
+		switch arg := args[index]; arg {
+		case "-c":
+			if index+1 == len(args) {
+				fail(command, "", jsonErrors, hdidle.FailureUsage, errors.New(oneShotUsage))
+			}
+			index++
+			commandType = args[index]
+			if commandType != hdidle.SCSI && commandType != hdidle.ATA {
+				fail(command, "", jsonErrors, hdidle.FailureUsage,
+					fmt.Errorf("Wrong command_type -c %s. Must be one of: scsi, ata", commandType))
+			}
+		case "--url":
+			if index+1 == len(args) || command != "spinup" {
+				fail(command, "", jsonErrors, hdidle.FailureUsage, errors.New(oneShotUsage))
+			}
+			index++
+			url = args[index]
+		case "--json":
+			jsonErrors = true
+		case "-h":
+			fmt.Println(oneShotUsage)
+			os.Exit(0)
+		default:
+			disk = arg
+		}
+	}
+	if len(disk) == 0 {
+		fail(command, "", jsonErrors, hdidle.FailureUsage, errors.New("Missing disk. "+oneShotUsage))
+	}
+	if len(url) > 0 {
+		/* the running instance knows the aliases too */
+		if err := remoteSpinup(url, disk); err != nil {
+			fail(command, disk, jsonErrors, hdidle.FailureClass(err), err)
+		}
+		fmt.Printf("%s spun up by %s\n", disk, url)
+		return
+	}
+	name, err := io.RealPath(disk)
+	if err != nil {
+		fail(command, disk, jsonErrors, hdidle.FailureNoDisk, err)
+	}
+	device := fmt.Sprintf("/dev/%s", name)
+
+	switch command {
+	case "spindown":
+		err = hdidle.SpindownDisk(device, commandType)
+		if err == nil {
+			fmt.Printf("%s spun down\n", name)
+		}
+	case "spinup":
+		err = hdidle.SpinupDisk(device, commandType)
+		if err == nil {
+			fmt.Printf("%s spun up\n", name)
+		}
+	case "check":
+		var standby bool
+		if standby, err = hdidle.DiskStandby(device, commandType); err == nil {
+			state := "active"
+			if standby {
+				state = "standby"
+			}
+			fmt.Printf("%s %s\n", name, state)
+		}
+	case "identify":
+		var id *sgio.AtaIdentity
+		if id, err = hdidle.IdentifyDisk(device); err == nil {
+			fmt.Printf("disk=%s model=%s serial=%s apm=%t apmEnabled=%t epc=%t epcEnabled=%t standbyTimer=%t\n",
+				name, id.Model, id.Serial, id.Apm, id.ApmEnabled, id.Epc, id.EpcEnabled, id.StandbyTimer)
+		}
+	}
+	if err != nil {
+		fail(command, name, jsonErrors, hdidle.FailureClass(err), err)
+	}
+}
+
+/*
+ * End a one-shot command with the exit code of its failure class. The message
+ * goes to stdout as ever, the JSON object to stderr so scripts can tell them
+ * apart.
+ */
+func fail(command, disk string, jsonErrors bool, failure string, err error) {
+	fmt.Println(err.Error())
+	if jsonErrors {
+		json.NewEncoder(os.Stderr).Encode(commandError{
+			SchemaVersion: 1,
+			Command:       command,
+			Disk:          disk,
+			Failure:       failure,
+			ExitCode:      exitCodes[failure],
+			Message:       strings.TrimSpace(err.Error()),
+		})
+	}
+	os.Exit(exitCodes[failure])
+}
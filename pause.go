@@ -0,0 +1,93 @@
+// hd-idle - spin down idle hard disks
+// Copyright (C) 2018  Andoni del Olmo
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+//go:build !minimal
+// +build !minimal
+
+package main
+
+import (
+	"fmt"
+	"github.com/adelolmo/hd-idle/api"
+	"os"
+	"time"
+)
+
+const (
+	defaultControlUrl = "http://127.0.0.1:7000"
+	pauseUsage        = "usage: hd-idle pause --for <duration> [--url <url>]"
+	resumeUsage       = "usage: hd-idle resume [--url <url>]"
+)
+
+/*
+hd-idle pause --for <duration> [--url <url>]
+pauses the spindowns of the running hd-idle, started with --listen and
+--control, until the duration (e.g. 2h) is over.
+*/
+func pause(args []string) {
+	url := defaultControlUrl
+	var d time.Duration
+	for index, arg := range args {
+		switch arg {
+		case "--for":
+			duration, err := time.ParseDuration(args[index+1])
+			if err != nil || duration <= 0 {
+				fmt.Printf("Wrong duration --for %s. Must be a positive duration, e.g. 2h or 90m\n", args[index+1])
+				os.Exit(1)
+			}
+			d = duration
+		case "--url":
+			url = args[index+1]
+		case "-h":
+			fmt.Println(pauseUsage)
+			os.Exit(0)
+		}
+	}
+	if d == 0 {
+		fmt.Println("Missing --for. " + pauseUsage)
+		os.Exit(1)
+	}
+
+	p, err := api.PauseSpindowns(url, d)
+	if err != nil {
+		fmt.Println(err.Error())
+		os.Exit(1)
+	}
+	fmt.Printf("spindowns paused until %s\n", p.PausedUntil.Local().Format("2006-01-02T15:04:05"))
+}
+
+/*
+hd-idle resume [--url <url>]
+ends the pause of the running hd-idle right away.
+*/
+func resume(args []string) {
+	url := defaultControlUrl
+	for index, arg := range args {
+		switch arg {
+		case "--url":
+			url = args[index+1]
+		case "-h":
+			fmt.Println(resumeUsage)
+			os.Exit(0)
+		}
+	}
+
+	if _, err := api.ResumeSpindowns(url); err != nil {
+		fmt.Println(err.Error())
+		os.Exit(1)
+	}
+	fmt.Println("spindowns resumed")
+}
@@ -0,0 +1,64 @@
+// hd-idle - spin down idle hard disks
+// Copyright (C) 2018  Andoni del Olmo
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+//go:build !minimal
+// +build !minimal
+
+package main
+
+import (
+	"fmt"
+	"github.com/adelolmo/hd-idle/api"
+	"os"
+	"strings"
+)
+
+const profileUsage = "usage: hd-idle profile [<profile>] [--url <url>]"
+
+/*
+hd-idle profile [<profile>] [--url <url>]
+switches the running hd-idle, started with --listen and --control, to the
+profile of idle times, or back to the configured ones with default. Without a
+profile it prints the active one and those it can switch to.
+*/
+func profile(args []string) {
+	url := defaultControlUrl
+	var name string
+	for index, arg := range args {
+		switch {
+		case arg == "--url":
+			url = args[index+1]
+		case arg == "-h":
+			fmt.Println(profileUsage)
+			os.Exit(0)
+		case index == 0 && !strings.HasPrefix(arg, "-"):
+			name = arg
+		}
+	}
+
+	var p api.Profile
+	var err error
+	if len(name) > 0 {
+		p, err = api.SwitchProfile(url, name)
+	} else {
+		p, err = api.FetchProfile(url)
+	}
+	if err != nil {
+		fmt.Println(err.Error())
+		os.Exit(1)
+	}
+	fmt.Printf("profile %s active, profiles: %s\n", p.Active, strings.Join(append(p.Profiles, "default"), ", "))
+}
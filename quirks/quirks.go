@@ -0,0 +1,141 @@
+// hd-idle - spin down idle hard disks
+// Copyright (C) 2018  Andoni del Olmo
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package quirks
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+/*
+A quirks file is a JSON list of entries. Each entry matches disks by one or
+more identifiers, shell patterns allowed, and lists the adjustments they need:
+
+	[
+	  {
+	    "usb_id": "152d:0578",
+	    "model": "WDC WD40*",
+	    "command_type": "ata",
+	    "pass_through": 12,
+	    "stop_delay": 5
+	  },
+	  {
+	    "vendor": "FUJITSU",
+	    "model": "MCR3230SS",
+	    "eject": true
+	  }
+	]
+*/
+
+// Quirk matches disks and holds the adjustments they need.
+type Quirk struct {
+	UsbID  string `json:"usb_id,omitempty"` // vendor:product of the USB bridge
+	Vendor string `json:"vendor,omitempty"`
+	Model  string `json:"model,omitempty"`
+	Serial string `json:"serial,omitempty"`
+
+	CommandType string `json:"command_type,omitempty"` // scsi or ata
+	PassThrough int    `json:"pass_through,omitempty"` // 12 or 16 bytes ATA PASS-THROUGH
+	StopDelay   int    `json:"stop_delay,omitempty"`   // seconds to wait after the stop command
+	Eject       bool   `json:"eject,omitempty"`        // stop scsi disks by unloading the medium
+}
+
+// Device holds the identifiers of a disk quirks are matched against.
+type Device struct {
+	UsbID  string
+	Vendor string
+	Model  string
+	Serial string
+}
+
+func Load(path string) ([]Quirk, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return Read(f)
+}
+
+func Read(r io.Reader) ([]Quirk, error) {
+	var quirks []Quirk
+	if err := json.NewDecoder(r).Decode(&quirks); err != nil {
+		return nil, fmt.Errorf("wrong quirks: %s", err)
+	}
+	for i, q := range quirks {
+		if len(q.UsbID)+len(q.Vendor)+len(q.Model)+len(q.Serial) == 0 {
+			return nil, fmt.Errorf("quirk %d matches no identifier", i)
+		}
+		switch q.CommandType {
+		case "", "scsi", "ata":
+		default:
+			return nil, fmt.Errorf("quirk %d: wrong command_type %s. Must be one of: scsi, ata", i, q.CommandType)
+		}
+		switch q.PassThrough {
+		case 0, 12, 16:
+		default:
+			return nil, fmt.Errorf("quirk %d: wrong pass_through %d. Must be 12 or 16", i, q.PassThrough)
+		}
+	}
+	return quirks, nil
+}
+
+// Matches tells whether all the identifiers of the quirk match the device.
+func (q Quirk) Matches(d Device) bool {
+	return match(q.UsbID, d.UsbID) && match(q.Vendor, d.Vendor) &&
+		match(q.Model, d.Model) && match(q.Serial, d.Serial)
+}
+
+func match(pattern, value string) bool {
+	if len(pattern) == 0 {
+		return true
+	}
+	matched, err := filepath.Match(pattern, value)
+	return err == nil && matched
+}
+
+// Find merges the adjustments of all quirks matching the device. Later
+// entries override earlier ones.
+func Find(quirks []Quirk, d Device) Quirk {
+	var found Quirk
+	for _, q := range quirks {
+		if !q.Matches(d) {
+			continue
+		}
+		if len(q.CommandType) > 0 {
+			found.CommandType = q.CommandType
+		}
+		if q.PassThrough > 0 {
+			found.PassThrough = q.PassThrough
+		}
+		if q.StopDelay > 0 {
+			found.StopDelay = q.StopDelay
+		}
+		if q.Eject {
+			found.Eject = true
+		}
+	}
+	return found
+}
+
+func (q Quirk) StopDelayDuration() time.Duration {
+	return time.Duration(q.StopDelay) * time.Second
+}
@@ -0,0 +1,87 @@
+// hd-idle - spin down idle hard disks
+// Copyright (C) 2018  Andoni del Olmo
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package quirks
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestFind(t *testing.T) {
+	s := `[
+		{"usb_id": "152d:0578", "command_type": "ata", "pass_through": 12},
+		{"usb_id": "152d:*", "model": "WDC WD40*", "stop_delay": 5},
+		{"serial": "ABC123", "command_type": "scsi"},
+		{"vendor": "FUJITSU", "eject": true}
+	]`
+	quirks, err := Read(strings.NewReader(s))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		device Device
+		want   Quirk
+	}{
+		{
+			name:   "bridge",
+			device: Device{UsbID: "152d:0578", Model: "ST4000DM004"},
+			want:   Quirk{CommandType: "ata", PassThrough: 12},
+		},
+		{
+			name:   "bridge and model",
+			device: Device{UsbID: "152d:0578", Model: "WDC WD40EFRX-68N32N0"},
+			want:   Quirk{CommandType: "ata", PassThrough: 12, StopDelay: 5},
+		},
+		{
+			name:   "later entries win",
+			device: Device{UsbID: "152d:0578", Serial: "ABC123"},
+			want:   Quirk{CommandType: "scsi", PassThrough: 12},
+		},
+		{
+			name:   "eject",
+			device: Device{Vendor: "FUJITSU", Model: "MCR3230SS"},
+			want:   Quirk{Eject: true},
+		},
+		{
+			name:   "no match",
+			device: Device{UsbID: "0bc2:2320", Model: "WDC WD40EFRX-68N32N0"},
+			want:   Quirk{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Find(quirks, tt.device); got != tt.want {
+				t.Errorf("Find() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReadWrongQuirks(t *testing.T) {
+	for _, s := range []string{
+		`{"usb_id": "152d:0578"}`,
+		`[{"command_type": "ata"}]`,
+		`[{"model": "*", "command_type": "sata"}]`,
+		`[{"model": "*", "pass_through": 10}]`,
+	} {
+		if _, err := Read(strings.NewReader(s)); err == nil {
+			t.Errorf("Expected error for %s", s)
+		}
+	}
+}
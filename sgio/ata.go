@@ -25,24 +25,56 @@ import (
 const (
 	sgAta16    = 0x85 // ATA PASS-THROUGH(16)
 	sgAta16Len = 16
+	sgAta12    = 0xa1 // ATA PASS-THROUGH(12), for bridges that don't know the 16 bytes variant
+	sgAta12Len = 12
 
 	sgAtaProtoNonData = 3 << 1
 	ataUsingLba       = 1 << 6
 
-	ataOpStandbyNow1 = 0xe0 // https://wiki.osdev.org/ATA/ATAPI_Power_Management
-	ataOpStandbyNow2 = 0x94 // Retired in ATA4. Did not coexist with ATAPI.
+	ataOpStandbyNow1   = 0xe0 // https://wiki.osdev.org/ATA/ATAPI_Power_Management
+	ataOpStandbyNow2   = 0x94 // Retired in ATA4. Did not coexist with ATAPI.
+	ataOpIdleImmediate = 0xe1
 )
 
 func StopAtaDevice(device string) error {
-	f, err := openDevice(device)
+	return stopAtaDevice(device, sendAtaCommand)
+}
+
+// StopAtaDevice12 spins down the disk using ATA PASS-THROUGH(12).
+func StopAtaDevice12(device string) error {
+	return stopAtaDevice(device, sendAta12Command)
+}
+
+func stopAtaDevice(device string, send func(*os.File, uint8) error) error {
+	f, err := openDevice(device, "ATA STANDBY IMMEDIATE")
 	if err != nil {
 		return err
 	}
 
-	if err = sendAtaCommand(f, ataOpStandbyNow1); err != nil {
+	if err = send(f, ataOpStandbyNow1); err != nil {
+		f.Close()
 		return err
 	}
-	if err = sendAtaCommand(f, ataOpStandbyNow2); err != nil {
+	/* retired in ATA4, drives that took the first command may reject it */
+	if err = send(f, ataOpStandbyNow2); err != nil && err != ErrCommandNotSupported {
+		f.Close()
+		return err
+	}
+
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("cannot close file %s. Error: %s", device, err)
+	}
+	return nil
+}
+
+func StartAtaDevice(device string) error {
+	f, err := openDevice(device, "ATA IDLE IMMEDIATE")
+	if err != nil {
+		return err
+	}
+
+	if err = sendAtaCommand(f, ataOpIdleImmediate); err != nil {
+		f.Close()
 		return err
 	}
 
@@ -58,27 +90,33 @@ func sendAtaCommand(f *os.File, command uint8) error {
 	cbd[1] = sgAtaProtoNonData
 	cbd[13] = ataUsingLba
 	cbd[14] = command
-	return sendSgio(f, cbd)
+	return sendSgio(f, cbd[:])
 }
 
-func sendSgio(f *os.File, inqCmdBlk [sgAta16Len]uint8) error {
+func sendAta12Command(f *os.File, command uint8) error {
+	var cbd [sgAta12Len]uint8
+	cbd[0] = sgAta12
+	cbd[1] = sgAtaProtoNonData
+	cbd[8] = ataUsingLba
+	cbd[9] = command
+	return sendSgio(f, cbd[:])
+}
+
+func sendSgio(f *os.File, inqCmdBlk []uint8) error {
 	senseBuf := make([]byte, sgio.SENSE_BUF_LEN)
 	ioHdr := &sgio.SgIoHdr{
-		InterfaceID:    'S',                //  0	4
-		DxferDirection: SgDxferNone,        //  4 	4
-		CmdLen:         sgAta16Len,         //  8	1
-		MxSbLen:        sgio.SENSE_BUF_LEN, //  9	1
-		Cmdp:           &inqCmdBlk[0],      // 24   8
-		Sbp:            &senseBuf[0],       // 32	8
-		Timeout:        0,                  // 40	4
+		InterfaceID:    'S',                   //  0	4
+		DxferDirection: SgDxferNone,           //  4 	4
+		CmdLen:         uint8(len(inqCmdBlk)), //  8	1
+		MxSbLen:        sgio.SENSE_BUF_LEN,    //  9	1
+		Cmdp:           &inqCmdBlk[0],         // 24   8
+		Sbp:            &senseBuf[0],          // 32	8
+		Timeout:        0,                     // 40	4
 	}
 
 	if err := sgio.SgioSyscall(f, ioHdr); err != nil {
 		return err
 	}
 
-	if err := sgio.CheckSense(ioHdr, &senseBuf); err != nil {
-		return err
-	}
-	return nil
+	return checkSense(ioHdr, senseBuf)
 }
@@ -26,13 +26,22 @@ import (
 
 const SgDxferNone = -1
 
-func openDevice(fname string) (*os.File, error) {
+// OpenHook, when set, is called before a device is opened with the command
+// about to be sent, e.g. START STOP UNIT. A device is only held open while
+// its command runs, so every command is seen.
+var OpenHook func(device, command string)
+
+func openDevice(fname, command string) (*os.File, error) {
+	if OpenHook != nil {
+		OpenHook(fname, command)
+	}
 	f, err := os.OpenFile(fname, os.O_RDONLY, 0)
 	if err != nil {
 		return nil, err
 	}
 	var version uint32
 	if (ioctl(f.Fd(), sgio.SG_GET_VERSION_NUM, uintptr(unsafe.Pointer(&version))) != nil) || (version < 30000) {
+		f.Close()
 		return nil, fmt.Errorf("device does not appear to be an sg device")
 	}
 	return f, nil
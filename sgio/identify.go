@@ -0,0 +1,134 @@
+// hd-idle - spin down idle hard disks
+// Copyright (C) 2018  Andoni del Olmo
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package sgio
+
+import (
+	"fmt"
+	"github.com/benmcclelland/sgio"
+	"os"
+	"strings"
+)
+
+const (
+	ataOpIdentify     = 0xec
+	ataIdentifyLen    = 512
+	sgAtaProtoPioIn   = 4 << 1
+	sgAtaTDir         = 1 << 3 // transfer from the device
+	sgAtaByteBlock    = 1 << 2 // transfer length in blocks
+	sgAtaTLenSecCount = 2      // transfer length in the sector count field
+)
+
+// AtaIdentity holds the capabilities hd-idle cares about from the
+// IDENTIFY DEVICE data.
+type AtaIdentity struct {
+	Model        string
+	Serial       string
+	Apm          bool // advanced power management supported
+	ApmEnabled   bool
+	Epc          bool // extended power conditions supported
+	EpcEnabled   bool
+	StandbyTimer bool // standby timer values as in the standard supported
+	Hybrid       bool // hybrid information supported, i.e. a hybrid drive (SSHD) with a flash cache
+	RotationRate int  // nominal rpm, 1 for non-rotating media, 0 if not reported
+	Zoned        int  // zoned capabilities: ZonedHostAware, ZonedDriveManaged or 0 if not reported
+}
+
+// The zoned capabilities of shingled (SMR) drives in IDENTIFY DEVICE.
+const (
+	ZonedHostAware    = 1
+	ZonedDriveManaged = 2
+)
+
+func IdentifyAtaDevice(device string) (*AtaIdentity, error) {
+	f, err := openDevice(device, "ATA IDENTIFY DEVICE")
+	if err != nil {
+		return nil, err
+	}
+
+	data := make([]byte, ataIdentifyLen)
+	var cbd [sgAta16Len]uint8
+	cbd[0] = sgAta16
+	cbd[1] = sgAtaProtoPioIn
+	cbd[2] = sgAtaTDir | sgAtaByteBlock | sgAtaTLenSecCount
+	cbd[6] = 1
+	cbd[14] = ataOpIdentify
+	if err := sendSgioDataIn(f, cbd[:], data); err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	if err := f.Close(); err != nil {
+		return nil, fmt.Errorf("cannot close file %s. Error: %s", device, err)
+	}
+	return ParseAtaIdentity(data), nil
+}
+
+// ParseAtaIdentity decodes the 256 little endian words of IDENTIFY DEVICE.
+func ParseAtaIdentity(data []byte) *AtaIdentity {
+	word := func(i int) uint16 {
+		return uint16(data[2*i]) | uint16(data[2*i+1])<<8
+	}
+	return &AtaIdentity{
+		Model:        ataString(data, 27, 47),
+		Serial:       ataString(data, 10, 20),
+		Apm:          word(83)&(1<<3) != 0,
+		ApmEnabled:   word(86)&(1<<3) != 0,
+		Epc:          word(119)&(1<<7) != 0,
+		EpcEnabled:   word(120)&(1<<7) != 0,
+		StandbyTimer: word(49)&(1<<13) != 0,
+		Hybrid:       word(76) != 0 && word(76) != 0xffff && word(78)&(1<<9) != 0,
+		RotationRate: rotationRate(word(217)),
+		Zoned:        int(word(69) & 3),
+	}
+}
+
+/* 1 for non-rotating media, 0401h to FFFEh the rpm, anything else is not reported */
+func rotationRate(w uint16) int {
+	if w == 1 || (w >= 0x401 && w != 0xffff) {
+		return int(w)
+	}
+	return 0
+}
+
+/* ATA strings hold two characters per word, high byte first */
+func ataString(data []byte, from, to int) string {
+	b := make([]byte, 0, 2*(to-from))
+	for i := from; i < to; i++ {
+		b = append(b, data[2*i+1], data[2*i])
+	}
+	return strings.Trim(string(b), " \x00")
+}
+
+func sendSgioDataIn(f *os.File, cbd []uint8, data []byte) error {
+	senseBuf := make([]byte, sgio.SENSE_BUF_LEN)
+	ioHdr := &sgio.SgIoHdr{
+		InterfaceID:    'S',
+		DxferDirection: sgio.SG_DXFER_FROM_DEV,
+		CmdLen:         uint8(len(cbd)),
+		MxSbLen:        sgio.SENSE_BUF_LEN,
+		DxferLen:       uint32(len(data)),
+		Dxferp:         &data[0],
+		Cmdp:           &cbd[0],
+		Sbp:            &senseBuf[0],
+		Timeout:        sgio.TIMEOUT_20_SECS,
+	}
+
+	if err := sgio.SgioSyscall(f, ioHdr); err != nil {
+		return err
+	}
+	return sgio.CheckSense(ioHdr, &senseBuf)
+}
@@ -0,0 +1,58 @@
+// hd-idle - spin down idle hard disks
+// Copyright (C) 2018  Andoni del Olmo
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package sgio
+
+import (
+	"testing"
+)
+
+func TestParseAtaIdentity(t *testing.T) {
+	data := make([]byte, ataIdentifyLen)
+	putString := func(word int, s string) {
+		for i := 0; i < len(s); i += 2 {
+			data[2*word+i+1] = s[i]
+			if i+1 < len(s) {
+				data[2*word+i] = s[i+1]
+			}
+		}
+	}
+	putString(10, "     WD-WCC4E1234567")
+	putString(27, "WDC WD40EFRX-68N32N0                    ")
+	data[2*83] = 1 << 3
+	data[2*119] = 1 << 7
+	data[2*49+1] = 1 << 5
+	data[2*76] = 1 << 3 // SATA 3.0
+	data[2*78+1] = 1 << 1
+	data[2*217], data[2*217+1] = 0x70, 0x17 // 6000 rpm
+	data[2*69] = 2                          // drive managed
+
+	id := ParseAtaIdentity(data)
+
+	expected := AtaIdentity{
+		Model:        "WDC WD40EFRX-68N32N0",
+		Serial:       "WD-WCC4E1234567",
+		Apm:          true,
+		Epc:          true,
+		StandbyTimer: true,
+		Hybrid:       true,
+		RotationRate: 6000,
+		Zoned:        ZonedDriveManaged,
+	}
+	if *id != expected {
+		t.Fatalf("Expected %+v but found %+v", expected, *id)
+	}
+}
@@ -0,0 +1,152 @@
+// hd-idle - spin down idle hard disks
+// Copyright (C) 2018  Andoni del Olmo
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+//go:build integration
+// +build integration
+
+package sgio
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+/*
+ * The integration tests send real SG_IO commands to a disk emulated by the
+ * kernel's scsi_debug module, loaded for the run if it isn't already:
+ *
+ *	sudo go test -tags integration ./sgio
+ *
+ * They are skipped without root or without the module, e.g. in a container.
+ */
+
+const scsiDebugSettle = 10 * time.Second
+
+/* the emulated disk, empty when there is none */
+var scsiDebugDevice string
+
+func TestMain(m *testing.M) {
+	device, loaded, err := setUpScsiDebug()
+	if err != nil {
+		fmt.Printf("skipping the scsi_debug tests: %s\n", err)
+	}
+	scsiDebugDevice = device
+	code := m.Run()
+	if loaded {
+		if out, err := exec.Command("modprobe", "-r", "scsi_debug").CombinedOutput(); err != nil {
+			fmt.Printf("cannot unload scsi_debug: %s %s\n", err, out)
+		}
+	}
+	os.Exit(code)
+}
+
+/* find the disk of scsi_debug, loading the module when it isn't */
+func setUpScsiDebug() (string, bool, error) {
+	if device := findScsiDebug(); len(device) > 0 {
+		return device, false, nil
+	}
+	if os.Geteuid() != 0 {
+		return "", false, fmt.Errorf("loading scsi_debug needs root")
+	}
+	if out, err := exec.Command("modprobe", "scsi_debug", "dev_size_mb=8").CombinedOutput(); err != nil {
+		return "", false, fmt.Errorf("cannot load scsi_debug: %s %s", err, strings.TrimSpace(string(out)))
+	}
+	for deadline := time.Now().Add(scsiDebugSettle); time.Now().Before(deadline); time.Sleep(100 * time.Millisecond) {
+		if device := findScsiDebug(); len(device) > 0 {
+			if _, err := os.Stat(device); err == nil {
+				return device, true, nil
+			}
+		}
+	}
+	return "", true, fmt.Errorf("no disk of scsi_debug after %s", scsiDebugSettle)
+}
+
+func findScsiDebug() string {
+	models, _ := filepath.Glob("/sys/block/sd*/device/model")
+	for _, model := range models {
+		content, err := ioutil.ReadFile(model)
+		if err == nil && strings.TrimSpace(string(content)) == "scsi_debug" {
+			return "/dev/" + filepath.Base(filepath.Dir(filepath.Dir(model)))
+		}
+	}
+	return ""
+}
+
+func requireScsiDebug(t *testing.T) string {
+	if len(scsiDebugDevice) == 0 {
+		t.Skip("no scsi_debug disk")
+	}
+	return scsiDebugDevice
+}
+
+func TestScsiDebugStopStart(t *testing.T) {
+	device := requireScsiDebug(t)
+	defer StartScsiDevice(device)
+
+	if stopped, err := ScsiStopped(device); err != nil || stopped {
+		t.Fatalf("Expected %s running but found stopped=%t, %v", device, stopped, err)
+	}
+	if err := StopScsiDevice(device); err != nil {
+		t.Fatal(err)
+	}
+	if stopped, err := ScsiStopped(device); err != nil || !stopped {
+		t.Fatalf("Expected %s stopped but found stopped=%t, %v", device, stopped, err)
+	}
+	/* TEST UNIT READY must not start it */
+	if stopped, err := ScsiStopped(device); err != nil || !stopped {
+		t.Fatalf("Expected %s still stopped but found stopped=%t, %v", device, stopped, err)
+	}
+	if err := StartScsiDevice(device); err != nil {
+		t.Fatal(err)
+	}
+	if stopped, err := ScsiStopped(device); err != nil || stopped {
+		t.Fatalf("Expected %s running again but found stopped=%t, %v", device, stopped, err)
+	}
+}
+
+func TestScsiDebugRejectsAtaPassThrough(t *testing.T) {
+	device := requireScsiDebug(t)
+
+	/* a SCSI disk without a SAT layer, like a bridge rejecting ATA commands */
+	if err := StopAtaDevice(device); err == nil {
+		t.Fatalf("Expected %s to reject the ATA standby command", device)
+	}
+	if identity, err := IdentifyAtaDevice(device); err == nil {
+		t.Fatalf("Expected %s to reject IDENTIFY DEVICE but found %+v", device, identity)
+	}
+	if stopped, err := ScsiStopped(device); err != nil || stopped {
+		t.Fatalf("Expected %s running after the rejected commands but found stopped=%t, %v", device, stopped, err)
+	}
+}
+
+func TestScsiDebugStartStopCycles(t *testing.T) {
+	device := requireScsiDebug(t)
+
+	/* older kernels emulate no start-stop cycle counter page */
+	cycles, err := ScsiStartStopCycles(device)
+	if err != nil {
+		t.Skipf("no start-stop cycle counter page: %s", err)
+	}
+	if cycles.SpecifiedStartStop > 0 && cycles.StartStop > cycles.SpecifiedStartStop {
+		t.Fatalf("Expected the start-stop cycles within the specified ones but found %+v", cycles)
+	}
+}
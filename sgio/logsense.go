@@ -0,0 +1,147 @@
+// hd-idle - spin down idle hard disks
+// Copyright (C) 2018  Andoni del Olmo
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package sgio
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+const (
+	logSense                 = 0x4d
+	logSenseLen              = 10
+	logPageCumulative        = 1 << 6
+	logPageStartStopCycles   = 0x0e
+	logPageBackgroundScan    = 0x15
+	logPageMaxLen            = 512
+	paramSpecifiedStartStop  = 0x0003
+	paramStartStopCycles     = 0x0004
+	paramSpecifiedLoadUnload = 0x0005
+	paramLoadUnloadCycles    = 0x0006
+	paramBackgroundScan      = 0x0000
+)
+
+// StartStopCycles holds the wear counters of a disk. Specified values are
+// the ones the manufacturer rates the disk for, zero if unknown.
+type StartStopCycles struct {
+	StartStop           uint32
+	SpecifiedStartStop  uint32
+	LoadUnload          uint32
+	SpecifiedLoadUnload uint32
+}
+
+// ScsiStartStopCycles reads the Start-Stop Cycle Counter log page.
+func ScsiStartStopCycles(device string) (*StartStopCycles, error) {
+	data, err := readLogPage(device, logPageStartStopCycles)
+	if err != nil {
+		return nil, err
+	}
+	return ParseStartStopCycles(data)
+}
+
+// ScsiBackgroundScan tells the background scan the drive runs on its own, a
+// background medium scan or pre-scan, empty if none.
+func ScsiBackgroundScan(device string) (string, error) {
+	data, err := readLogPage(device, logPageBackgroundScan)
+	if err != nil {
+		return "", err
+	}
+	return ParseBackgroundScan(data)
+}
+
+func readLogPage(device string, page uint8) ([]byte, error) {
+	f, err := openDevice(device, "LOG SENSE")
+	if err != nil {
+		return nil, err
+	}
+
+	data := make([]byte, logPageMaxLen)
+	cbd := []uint8{logSense, 0, logPageCumulative | page, 0, 0, 0, 0,
+		uint8(logPageMaxLen >> 8), uint8(logPageMaxLen & 0xff), 0}
+	if err := sendSgioDataIn(f, cbd, data); err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	if err := f.Close(); err != nil {
+		return nil, fmt.Errorf("cannot close file %s. Error: %s", device, err)
+	}
+	return data, nil
+}
+
+// ParseStartStopCycles decodes the parameters of log page 0x0e.
+func ParseStartStopCycles(data []byte) (*StartStopCycles, error) {
+	if len(data) < 4 || data[0]&0x3f != logPageStartStopCycles {
+		return nil, fmt.Errorf("not a start-stop cycle counter log page")
+	}
+	end := 4 + int(binary.BigEndian.Uint16(data[2:4]))
+	if end > len(data) {
+		end = len(data)
+	}
+
+	cycles := &StartStopCycles{}
+	for i := 4; i+4 <= end; {
+		code := binary.BigEndian.Uint16(data[i : i+2])
+		length := int(data[i+3])
+		value := data[i+4:]
+		i += 4 + length
+		if length != 4 || i > end {
+			continue
+		}
+		switch code {
+		case paramSpecifiedStartStop:
+			cycles.SpecifiedStartStop = binary.BigEndian.Uint32(value)
+		case paramStartStopCycles:
+			cycles.StartStop = binary.BigEndian.Uint32(value)
+		case paramSpecifiedLoadUnload:
+			cycles.SpecifiedLoadUnload = binary.BigEndian.Uint32(value)
+		case paramLoadUnloadCycles:
+			cycles.LoadUnload = binary.BigEndian.Uint32(value)
+		}
+	}
+	return cycles, nil
+}
+
+// ParseBackgroundScan decodes the status parameter of log page 0x15.
+func ParseBackgroundScan(data []byte) (string, error) {
+	if len(data) < 4 || data[0]&0x3f != logPageBackgroundScan {
+		return "", fmt.Errorf("not a background scan results log page")
+	}
+	end := 4 + int(binary.BigEndian.Uint16(data[2:4]))
+	if end > len(data) {
+		end = len(data)
+	}
+
+	for i := 4; i+4 <= end; {
+		code := binary.BigEndian.Uint16(data[i : i+2])
+		length := int(data[i+3])
+		value := data[i+4:]
+		i += 4 + length
+		if code != paramBackgroundScan || length < 6 || i > end {
+			continue
+		}
+		/* after the accumulated power on minutes and a reserved byte */
+		switch value[5] {
+		case 1:
+			return "background medium scan", nil
+		case 2:
+			return "background pre-scan", nil
+		}
+		return "", nil
+	}
+	return "", nil
+}
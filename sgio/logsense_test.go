@@ -0,0 +1,69 @@
+// hd-idle - spin down idle hard disks
+// Copyright (C) 2018  Andoni del Olmo
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package sgio
+
+import (
+	"testing"
+)
+
+func TestParseStartStopCycles(t *testing.T) {
+	data := []byte{
+		0x0e, 0x00, 0x00, 0x34,
+		0x00, 0x01, 0x03, 0x06, '2', '0', '1', '9', '1', '2', // date of manufacture
+		0x00, 0x02, 0x03, 0x06, '2', '0', '2', '0', '0', '1', // accounting date
+		0x00, 0x03, 0x03, 0x04, 0x00, 0x00, 0xc3, 0x50, // specified start-stop cycles: 50000
+		0x00, 0x04, 0x03, 0x04, 0x00, 0x00, 0x01, 0x2c, // accumulated start-stop cycles: 300
+		0x00, 0x05, 0x03, 0x04, 0x00, 0x09, 0x27, 0xc0, // specified load-unload cycles: 600000
+		0x00, 0x06, 0x03, 0x04, 0x00, 0x00, 0x04, 0xd2, // accumulated load-unload cycles: 1234
+	}
+
+	cycles, err := ParseStartStopCycles(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := StartStopCycles{StartStop: 300, SpecifiedStartStop: 50000, LoadUnload: 1234, SpecifiedLoadUnload: 600000}
+	if *cycles != expected {
+		t.Fatalf("Expected %+v but found %+v", expected, *cycles)
+	}
+
+	if _, err := ParseStartStopCycles([]byte{0x0d, 0, 0, 0}); err == nil {
+		t.Fatalf("Expected error for wrong log page")
+	}
+}
+
+func TestParseBackgroundScan(t *testing.T) {
+	page := func(status byte) []byte {
+		return []byte{
+			0x15, 0x00, 0x00, 0x10,
+			0x00, 0x00, 0x03, 0x0c, 0x00, 0x01, 0x86, 0xa0, 0x00, status, 0x00, 0x07, 0x80, 0x00, 0x00, 0x05,
+		}
+	}
+	for status, expected := range map[byte]string{0: "", 1: "background medium scan", 2: "background pre-scan", 8: ""} {
+		activity, err := ParseBackgroundScan(page(status))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if activity != expected {
+			t.Fatalf("Expected %q for status %d but found %q", expected, status, activity)
+		}
+	}
+
+	if _, err := ParseBackgroundScan([]byte{0x0e, 0, 0, 0}); err == nil {
+		t.Fatalf("Expected error for wrong log page")
+	}
+}
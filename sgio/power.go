@@ -0,0 +1,87 @@
+// hd-idle - spin down idle hard disks
+// Copyright (C) 2018  Andoni del Olmo
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package sgio
+
+import (
+	"fmt"
+	"github.com/benmcclelland/sgio"
+)
+
+const (
+	ataOpCheckPowerMode = 0xe5
+	sgAtaCheckCondition = 1 << 5 // return the ATA registers in the sense data
+	ataPowerModeStandby = 0x00
+
+	testUnitReady          = 0x00
+	senseNoSense           = 0x00
+	senseNotReady          = 0x02
+	ascNotReady            = 0x04
+	ascqStartUnitRequired  = 0x02
+	ascLowPowerConditionOn = 0x5e
+)
+
+// AtaStandby tells whether the drive is in standby with CHECK POWER MODE,
+// which doesn't wake it up.
+func AtaStandby(device string) (bool, error) {
+	var cbd [sgAta16Len]uint8
+	cbd[0] = sgAta16
+	cbd[1] = sgAtaProtoNonData
+	cbd[2] = sgAtaCheckCondition
+	cbd[13] = ataUsingLba
+	cbd[14] = ataOpCheckPowerMode
+	ioHdr, sense, err := sendScsiCommand(device, "ATA CHECK POWER MODE", cbd[:])
+	if err != nil {
+		return false, err
+	}
+	return parseAtaPowerMode(sense[:ioHdr.SbLenWr])
+}
+
+/* the sector count register holds the power mode */
+func parseAtaPowerMode(sense []byte) (bool, error) {
+	d := ataStatusReturn(sense)
+	if d == nil {
+		return false, fmt.Errorf("no ATA registers returned for CHECK POWER MODE")
+	}
+	if d[13]&ataStatusError != 0 {
+		return false, ErrCommandNotSupported
+	}
+	return d[5] == ataPowerModeStandby, nil
+}
+
+// ScsiStopped tells whether the device reports being stopped or in a low
+// power condition with TEST UNIT READY, which doesn't start it.
+func ScsiStopped(device string) (bool, error) {
+	ioHdr, sense, err := sendScsiCommand(device, "TEST UNIT READY", []uint8{testUnitReady, 0, 0, 0, 0, 0})
+	if err != nil {
+		return false, err
+	}
+	if (ioHdr.Info & sgio.SG_INFO_OK_MASK) == sgio.SG_INFO_OK {
+		return false, nil
+	}
+	return parseScsiStopped(ioHdr, sense)
+}
+
+func parseScsiStopped(ioHdr *sgio.SgIoHdr, sense []byte) (bool, error) {
+	key, asc, ascq := parseSense(sense[:ioHdr.SbLenWr])
+	switch {
+	case key == senseNotReady && asc == ascNotReady && ascq == ascqStartUnitRequired:
+		return true, nil
+	case (key == senseNoSense || key == senseNotReady) && asc == ascLowPowerConditionOn:
+		return true, nil
+	}
+	return false, checkSense(ioHdr, sense)
+}
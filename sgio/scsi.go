@@ -17,21 +17,94 @@
 package sgio
 
 import (
+	"errors"
 	"fmt"
 	"github.com/benmcclelland/sgio"
 )
 
 // https://en.wikipedia.org/wiki/SCSI_command
-const startStopUnit = 0x1b
+const (
+	startStopUnit             = 0x1b
+	preventAllowMediumRemoval = 0x1e
+	startBit                  = 1
+	loadEjectBit              = 1 << 1
+	preventBit                = 1
+
+	senseIllegalRequest          = 0x05
+	senseAbortedCommand          = 0x0b
+	ascInvalidOpcode             = 0x20
+	ascMediumRemovalPrevented    = 0x53
+	ascqMediumRemovalPrevented   = 0x02
+	senseResponseDescriptor      = 0x72
+	senseResponseDescriptorDefer = 0x73
+
+	ataStatusReturnDescriptor = 0x09
+	ataErrorAbort             = 1 << 2
+	ataStatusError            = 1
+)
+
+var scsiCommandNames = map[uint8]string{
+	startStopUnit:             "START STOP UNIT",
+	preventAllowMediumRemoval: "PREVENT ALLOW MEDIUM REMOVAL",
+}
+
+// ErrMediumRemovalPrevented is returned when a removable device refuses to
+// stop because the medium is locked, see PreventMediumRemoval.
+var ErrMediumRemovalPrevented = errors.New("medium removal prevented")
+
+// ErrCommandNotSupported is returned when the device, or the bridge in front
+// of it, rejects the command as invalid.
+var ErrCommandNotSupported = errors.New("command not supported by the device")
 
 func StopScsiDevice(device string) error {
-	f, err := openDevice(device)
+	return scsiCommand(device, startStopUnit, 0)
+}
+
+func StartScsiDevice(device string) error {
+	return scsiCommand(device, startStopUnit, startBit)
+}
+
+// EjectScsiDevice stops the device with the load/eject bit set. Removable
+// devices unload the medium, which some docks require to spin down and
+// magneto-optical drives push out.
+func EjectScsiDevice(device string) error {
+	return scsiCommand(device, startStopUnit, loadEjectBit)
+}
+
+// LoadScsiDevice starts the device and loads the medium unloaded by
+// EjectScsiDevice, if the device can.
+func LoadScsiDevice(device string) error {
+	return scsiCommand(device, startStopUnit, loadEjectBit|startBit)
+}
+
+// AllowMediumRemoval unlocks the medium of a removable device. The kernel
+// locks it while the device is open, e.g. mounted.
+func AllowMediumRemoval(device string) error {
+	return scsiCommand(device, preventAllowMediumRemoval, 0)
+}
+
+// PreventMediumRemoval locks the medium of a removable device again.
+func PreventMediumRemoval(device string) error {
+	return scsiCommand(device, preventAllowMediumRemoval, preventBit)
+}
+
+/* six bytes command whose only parameter is byte 4 */
+func scsiCommand(device string, opcode, param uint8) error {
+	ioHdr, sense, err := sendScsiCommand(device, scsiCommandNames[opcode], []uint8{opcode, 0, 0, 0, param, 0})
 	if err != nil {
 		return err
 	}
+	return checkSense(ioHdr, sense)
+}
+
+/* the command without data transfer, its status and sense buffer are left to the caller */
+func sendScsiCommand(device, command string, inqCmdBlk []uint8) (*sgio.SgIoHdr, []byte, error) {
+	f, err := openDevice(device, command)
+	if err != nil {
+		return nil, nil, err
+	}
 
 	senseBuf := make([]byte, sgio.SENSE_BUF_LEN)
-	inqCmdBlk := []uint8{startStopUnit, 0, 0, 0, 0, 0}
 	ioHdr := &sgio.SgIoHdr{
 		InterfaceID:    'S',
 		DxferDirection: SgDxferNone,
@@ -42,15 +115,67 @@ func StopScsiDevice(device string) error {
 	}
 
 	if err := sgio.SgioSyscall(f, ioHdr); err != nil {
-		return err
+		f.Close()
+		return nil, nil, err
 	}
 
-	if err := sgio.CheckSense(ioHdr, &senseBuf); err != nil {
-		return err
+	if err := f.Close(); err != nil {
+		return nil, nil, fmt.Errorf("cannot close file %s. Error: %s", device, err)
 	}
+	return ioHdr, senseBuf, nil
+}
 
-	if err := f.Close(); err != nil {
-		return fmt.Errorf("cannot close file %s. Error: %s", device, err)
+/* sense key, additional sense code and qualifier of fixed or descriptor format sense data */
+func parseSense(sense []byte) (key, asc, ascq uint8) {
+	if len(sense) < 4 {
+		return 0, 0, 0
+	}
+	switch sense[0] & 0x7f {
+	case senseResponseDescriptor, senseResponseDescriptorDefer:
+		return sense[1] & 0x0f, sense[2], sense[3]
+	}
+	if len(sense) < 14 {
+		return sense[2] & 0x0f, 0, 0
+	}
+	return sense[2] & 0x0f, sense[12], sense[13]
+}
+
+/*
+ * Tell apart the failures hd-idle reacts to: a locked medium and a command
+ * the device doesn't know, either rejected as an invalid opcode or aborted by
+ * the ATA drive behind a SAT bridge.
+ */
+func checkSense(ioHdr *sgio.SgIoHdr, senseBuf []byte) error {
+	if ioHdr.SbLenWr > 0 {
+		sense := senseBuf[:ioHdr.SbLenWr]
+		key, asc, ascq := parseSense(sense)
+		switch {
+		case key == senseIllegalRequest && asc == ascMediumRemovalPrevented && ascq == ascqMediumRemovalPrevented:
+			return ErrMediumRemovalPrevented
+		case key == senseIllegalRequest && asc == ascInvalidOpcode:
+			return ErrCommandNotSupported
+		case key == senseAbortedCommand && ataAborted(sense):
+			return ErrCommandNotSupported
+		}
+	}
+	return sgio.CheckSense(ioHdr, &senseBuf)
+}
+
+/* the ATA status return descriptor tells the drive aborted the command */
+func ataAborted(sense []byte) bool {
+	d := ataStatusReturn(sense)
+	return d != nil && d[3]&ataErrorAbort != 0 && d[13]&ataStatusError != 0
+}
+
+/* the ATA registers returned by a SAT device in descriptor format sense data, nil if absent */
+func ataStatusReturn(sense []byte) []byte {
+	if len(sense) < 8 || sense[0]&0x7f != senseResponseDescriptor {
+		return nil
+	}
+	for i := 8; i+1 < len(sense); i += int(sense[i+1]) + 2 {
+		if sense[i] == ataStatusReturnDescriptor && i+13 < len(sense) {
+			return sense[i : i+14]
+		}
 	}
 	return nil
 }
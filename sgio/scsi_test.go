@@ -0,0 +1,125 @@
+// hd-idle - spin down idle hard disks
+// Copyright (C) 2018  Andoni del Olmo
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package sgio
+
+import (
+	"github.com/benmcclelland/sgio"
+	"testing"
+)
+
+func TestParseSense(t *testing.T) {
+	tests := []struct {
+		name  string
+		sense []byte
+		key   uint8
+		asc   uint8
+		ascq  uint8
+	}{
+		{
+			name:  "fixed format",
+			sense: []byte{0x70, 0, 0x05, 0, 0, 0, 0, 0x0a, 0, 0, 0, 0, 0x53, 0x02, 0, 0, 0, 0},
+			key:   0x05, asc: 0x53, ascq: 0x02,
+		},
+		{
+			name:  "descriptor format",
+			sense: []byte{0x72, 0x05, 0x53, 0x02, 0, 0, 0, 0},
+			key:   0x05, asc: 0x53, ascq: 0x02,
+		},
+		{
+			name:  "short fixed format",
+			sense: []byte{0x70, 0, 0x02, 0, 0, 0},
+			key:   0x02,
+		},
+		{
+			name:  "truncated",
+			sense: []byte{0x70},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key, asc, ascq := parseSense(tt.sense)
+			if key != tt.key || asc != tt.asc || ascq != tt.ascq {
+				t.Errorf("parseSense() = %#x/%#x/%#x, want %#x/%#x/%#x", key, asc, ascq, tt.key, tt.asc, tt.ascq)
+			}
+		})
+	}
+}
+
+func TestCheckSense(t *testing.T) {
+	ataAbort := []byte{0x72, 0x0b, 0x00, 0x00, 0, 0, 0, 0x0e,
+		0x09, 0x0c, 0x00, 0x04, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0x51}
+	tests := []struct {
+		name  string
+		sense []byte
+		want  error
+	}{
+		{
+			name:  "invalid opcode",
+			sense: []byte{0x70, 0, 0x05, 0, 0, 0, 0, 0x0a, 0, 0, 0, 0, 0x20, 0x00, 0, 0, 0, 0},
+			want:  ErrCommandNotSupported,
+		},
+		{
+			name:  "ata abort",
+			sense: ataAbort,
+			want:  ErrCommandNotSupported,
+		},
+		{
+			name:  "medium removal prevented",
+			sense: []byte{0x72, 0x05, 0x53, 0x02, 0, 0, 0, 0},
+			want:  ErrMediumRemovalPrevented,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			senseBuf := make([]byte, sgio.SENSE_BUF_LEN)
+			copy(senseBuf, tt.sense)
+			ioHdr := &sgio.SgIoHdr{SbLenWr: uint8(len(tt.sense)), Info: sgio.SG_INFO_OK_MASK}
+			if err := checkSense(ioHdr, senseBuf); err != tt.want {
+				t.Errorf("checkSense() = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseAtaPowerMode(t *testing.T) {
+	standby := []byte{0x72, 0x01, 0x00, 0x1d, 0, 0, 0, 0x0e,
+		0x09, 0x0c, 0x00, 0x00, 0, 0x00, 0, 0, 0, 0, 0, 0, 0, 0x50}
+	if inStandby, err := parseAtaPowerMode(standby); err != nil || !inStandby {
+		t.Fatalf("Expected standby but found %t, %v", inStandby, err)
+	}
+	active := append([]byte(nil), standby...)
+	active[8+5] = 0xff
+	if inStandby, err := parseAtaPowerMode(active); err != nil || inStandby {
+		t.Fatalf("Expected active but found %t, %v", inStandby, err)
+	}
+	if _, err := parseAtaPowerMode([]byte{0x70, 0, 0x05}); err == nil {
+		t.Fatal("Expected an error without ATA registers")
+	}
+}
+
+func TestParseScsiStopped(t *testing.T) {
+	stopped := []byte{0x70, 0, 0x02, 0, 0, 0, 0, 0x0a, 0, 0, 0, 0, 0x04, 0x02, 0, 0, 0, 0}
+	ioHdr := &sgio.SgIoHdr{SbLenWr: uint8(len(stopped)), Info: sgio.SG_INFO_OK_MASK}
+	if isStopped, err := parseScsiStopped(ioHdr, stopped); err != nil || !isStopped {
+		t.Fatalf("Expected stopped but found %t, %v", isStopped, err)
+	}
+	mediumNotPresent := []byte{0x70, 0, 0x02, 0, 0, 0, 0, 0x0a, 0, 0, 0, 0, 0x3a, 0x00, 0, 0, 0, 0}
+	ioHdr = &sgio.SgIoHdr{SbLenWr: uint8(len(mediumNotPresent)), Info: sgio.SG_INFO_OK_MASK}
+	if _, err := parseScsiStopped(ioHdr, mediumNotPresent); err == nil {
+		t.Fatal("Expected an error for a missing medium")
+	}
+}
@@ -0,0 +1,177 @@
+// hd-idle - spin down idle hard disks
+// Copyright (C) 2018  Andoni del Olmo
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package sgio
+
+import (
+	"fmt"
+)
+
+const (
+	ataOpSmart          = 0xb0
+	smartReadData       = 0xd0
+	smartLbaMid         = 0x4f
+	smartLbaHigh        = 0xc2
+	smartDataLen        = 512
+	smartAttributes     = 30
+	smartAttributeLen   = 12
+	smartAttributesOff  = 2
+	smartOfflineStatus  = 362
+	smartSelfTestStatus = 363
+	smartStartStopCount = 4
+	smartLoadCycleCount = 193
+)
+
+var smartAttributeNames = map[uint8]string{
+	1:   "Raw_Read_Error_Rate",
+	4:   "Start_Stop_Count",
+	5:   "Reallocated_Sector_Ct",
+	9:   "Power_On_Hours",
+	10:  "Spin_Retry_Count",
+	12:  "Power_Cycle_Count",
+	190: "Airflow_Temperature_Cel",
+	192: "Power-Off_Retract_Count",
+	193: "Load_Cycle_Count",
+	194: "Temperature_Celsius",
+	197: "Current_Pending_Sector",
+	198: "Offline_Uncorrectable",
+	199: "UDMA_CRC_Error_Count",
+}
+
+// SmartAttribute is an entry of the SMART attribute table.
+type SmartAttribute struct {
+	ID      uint8
+	Name    string // as named by smartctl, empty if unknown
+	Current uint8
+	Worst   uint8
+	Raw     uint64
+}
+
+// ReadAtaSmart reads the SMART attributes with SMART READ DATA. Talking to
+// the drive may wake it up.
+func ReadAtaSmart(device string) ([]SmartAttribute, error) {
+	data, err := readAtaSmartData(device)
+	if err != nil {
+		return nil, err
+	}
+	return ParseSmartData(data), nil
+}
+
+// AtaStartStopCycles reads the start-stop and load cycle counts of the
+// SMART attributes 4 and 193. ATA drives don't report the counts they are
+// rated for, those are left zero.
+func AtaStartStopCycles(device string) (*StartStopCycles, error) {
+	attributes, err := ReadAtaSmart(device)
+	if err != nil {
+		return nil, err
+	}
+	return SmartStartStopCycles(attributes)
+}
+
+// SmartStartStopCycles takes the wear counters out of the SMART attributes.
+// Only the low 32 bits of the raw values count, some drives keep other
+// data in the upper ones.
+func SmartStartStopCycles(attributes []SmartAttribute) (*StartStopCycles, error) {
+	var cycles StartStopCycles
+	found := false
+	for _, attribute := range attributes {
+		switch attribute.ID {
+		case smartStartStopCount:
+			cycles.StartStop = uint32(attribute.Raw)
+			found = true
+		case smartLoadCycleCount:
+			cycles.LoadUnload = uint32(attribute.Raw)
+			found = true
+		}
+	}
+	if !found {
+		return nil, fmt.Errorf("no start-stop or load cycle count attribute")
+	}
+	return &cycles, nil
+}
+
+// AtaBackgroundActivity tells the maintenance the drive runs on its own, an
+// offline data collection or a self-test, empty if none.
+func AtaBackgroundActivity(device string) (string, error) {
+	data, err := readAtaSmartData(device)
+	if err != nil {
+		return "", err
+	}
+	return ParseSmartActivity(data), nil
+}
+
+func readAtaSmartData(device string) ([]byte, error) {
+	f, err := openDevice(device, "ATA SMART READ DATA")
+	if err != nil {
+		return nil, err
+	}
+
+	data := make([]byte, smartDataLen)
+	var cbd [sgAta16Len]uint8
+	cbd[0] = sgAta16
+	cbd[1] = sgAtaProtoPioIn
+	cbd[2] = sgAtaTDir | sgAtaByteBlock | sgAtaTLenSecCount
+	cbd[4] = smartReadData
+	cbd[6] = 1
+	cbd[10] = smartLbaMid
+	cbd[12] = smartLbaHigh
+	cbd[14] = ataOpSmart
+	if err := sendSgioDataIn(f, cbd[:], data); err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	if err := f.Close(); err != nil {
+		return nil, fmt.Errorf("cannot close file %s. Error: %s", device, err)
+	}
+	return data, nil
+}
+
+// ParseSmartData decodes the attribute table of the SMART READ DATA
+// response, skipping unused entries.
+func ParseSmartData(data []byte) []SmartAttribute {
+	var attributes []SmartAttribute
+	for i := 0; i < smartAttributes; i++ {
+		entry := data[smartAttributesOff+i*smartAttributeLen : smartAttributesOff+(i+1)*smartAttributeLen]
+		if entry[0] == 0 {
+			continue
+		}
+		var raw uint64
+		for b := 10; b >= 5; b-- {
+			raw = raw<<8 | uint64(entry[b])
+		}
+		attributes = append(attributes, SmartAttribute{
+			ID:      entry[0],
+			Name:    smartAttributeNames[entry[0]],
+			Current: entry[3],
+			Worst:   entry[4],
+			Raw:     raw,
+		})
+	}
+	return attributes
+}
+
+// ParseSmartActivity decodes the offline data collection and self-test
+// execution status of the SMART READ DATA response.
+func ParseSmartActivity(data []byte) string {
+	switch {
+	case data[smartSelfTestStatus]>>4 == 0xf:
+		return "self-test"
+	case data[smartOfflineStatus]&0x7f == 0x03:
+		return "offline data collection"
+	}
+	return ""
+}
@@ -0,0 +1,76 @@
+// hd-idle - spin down idle hard disks
+// Copyright (C) 2018  Andoni del Olmo
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package sgio
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseSmartData(t *testing.T) {
+	data := make([]byte, smartDataLen)
+	data[0], data[1] = 0x10, 0x00 // revision
+	copy(data[2:], []byte{
+		0x04, 0x32, 0x00, 0x64, 0x64, 0x2c, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, // start-stop count: 300
+		0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, // unused
+		0xc1, 0x32, 0x00, 0xc8, 0xc7, 0xd2, 0x04, 0x00, 0x00, 0x00, 0x00, 0x00, // load cycle count: 1234
+		0xf0, 0x00, 0x00, 0x64, 0x5a, 0x01, 0x02, 0x03, 0x04, 0x05, 0x06, 0x00, // unknown, 6 byte raw value
+	})
+
+	expected := []SmartAttribute{
+		{ID: 4, Name: "Start_Stop_Count", Current: 100, Worst: 100, Raw: 300},
+		{ID: 193, Name: "Load_Cycle_Count", Current: 200, Worst: 199, Raw: 1234},
+		{ID: 240, Current: 100, Worst: 90, Raw: 0x060504030201},
+	}
+	if attributes := ParseSmartData(data); !reflect.DeepEqual(attributes, expected) {
+		t.Fatalf("Expected %+v but found %+v", expected, attributes)
+	}
+}
+
+func TestSmartStartStopCycles(t *testing.T) {
+	attributes := []SmartAttribute{
+		{ID: 4, Raw: 300},
+		{ID: 9, Raw: 20000},
+		{ID: 193, Raw: 0x0001000004d2}, // load cycle count 1234, vendor data above
+	}
+	cycles, err := SmartStartStopCycles(attributes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := StartStopCycles{StartStop: 300, LoadUnload: 1234}
+	if *cycles != expected {
+		t.Fatalf("Expected %+v but found %+v", expected, *cycles)
+	}
+	if _, err := SmartStartStopCycles([]SmartAttribute{{ID: 9, Raw: 20000}}); err == nil {
+		t.Fatal("Expected an error without the cycle count attributes")
+	}
+}
+
+func TestParseSmartActivity(t *testing.T) {
+	data := make([]byte, smartDataLen)
+	if activity := ParseSmartActivity(data); activity != "" {
+		t.Fatalf("Expected no activity but found %q", activity)
+	}
+	data[smartOfflineStatus] = 0x83 // in progress, auto offline enabled
+	if activity := ParseSmartActivity(data); activity != "offline data collection" {
+		t.Fatalf("Expected offline data collection but found %q", activity)
+	}
+	data[smartSelfTestStatus] = 0xf9 // in progress, 90% remaining
+	if activity := ParseSmartActivity(data); activity != "self-test" {
+		t.Fatalf("Expected self-test but found %q", activity)
+	}
+}
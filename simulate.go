@@ -0,0 +1,116 @@
+// hd-idle - spin down idle hard disks
+// Copyright (C) 2018  Andoni del Olmo
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"fmt"
+	"github.com/adelolmo/hd-idle/hdidle"
+	"os"
+	"strings"
+)
+
+const simulateUsage = "usage: hd-idle simulate --trace <file> --a \"<options>\" --b \"<options>\""
+
+/*
+hd-idle simulate --trace <file> --a "<options>" --b "<options>"
+replays a trace recorded with --trace against two sets of -i and -a options
+and prints what each would have done side by side.
+*/
+func simulate(args []string) {
+	var traceFile, a, b string
+	for index, arg := range args {
+		switch arg {
+		case "--trace":
+			traceFile = args[index+1]
+		case "--a":
+			a = args[index+1]
+		case "--b":
+			b = args[index+1]
+		case "-h":
+			fmt.Println(simulateUsage)
+			os.Exit(0)
+		}
+	}
+	if len(traceFile) == 0 || len(a) == 0 || len(b) == 0 {
+		fmt.Println("Missing --trace, --a or --b. " + simulateUsage)
+		os.Exit(1)
+	}
+	configA, err := idleConfig(a)
+	if err != nil {
+		fmt.Printf("Wrong options --a \"%s\". %s\n", a, err)
+		os.Exit(1)
+	}
+	configB, err := idleConfig(b)
+	if err != nil {
+		fmt.Printf("Wrong options --b \"%s\". %s\n", b, err)
+		os.Exit(1)
+	}
+
+	file, err := os.Open(traceFile)
+	if err != nil {
+		fmt.Printf("Cannot open trace %s: %s\n", traceFile, err)
+		os.Exit(1)
+	}
+	trace, err := hdidle.ReadTrace(file)
+	file.Close()
+	if err != nil {
+		fmt.Printf("Cannot read trace %s: %s\n", traceFile, err)
+		os.Exit(1)
+	}
+	if len(trace) == 0 {
+		fmt.Printf("Trace %s is empty\n", traceFile)
+		os.Exit(1)
+	}
+	length := trace[len(trace)-1].Time.Sub(trace[0].Time)
+	hdidle.WriteTraceComparison(os.Stdout, length,
+		hdidle.SimulateTrace(trace, configA), hdidle.SimulateTrace(trace, configB))
+}
+
+/* the idle times of "-i <seconds> -a <disk> -i <seconds> ...", disks are named as in the trace */
+func idleConfig(options string) (*hdidle.Config, error) {
+	config := hdidle.NewConfig()
+	var device *hdidle.DeviceConf
+	args := strings.Fields(options)
+	for index := 0; index < len(args); index += 2 {
+		if index+1 == len(args) {
+			return nil, fmt.Errorf("Missing value for %s", args[index])
+		}
+		value := args[index+1]
+		switch args[index] {
+		case "-a":
+			config.Devices = append(config.Devices, hdidle.DeviceConf{
+				Name:      value,
+				GivenName: value,
+				Idle:      config.Defaults.Idle,
+			})
+			device = &config.Devices[len(config.Devices)-1]
+		case "-i":
+			idle, err := hdidle.ParseDuration(value)
+			if err != nil {
+				return nil, fmt.Errorf("Idle time -i %s must be a time, e.g. 600 or 10m", value)
+			}
+			if device == nil {
+				config.Defaults.Idle = idle
+			} else {
+				device.Idle = idle
+			}
+		default:
+			return nil, fmt.Errorf("Only -i and -a are simulated, found %s", args[index])
+		}
+	}
+	return config, nil
+}
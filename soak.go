@@ -0,0 +1,95 @@
+// hd-idle - spin down idle hard disks
+// Copyright (C) 2018  Andoni del Olmo
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"fmt"
+	"github.com/adelolmo/hd-idle/hdidle"
+	"github.com/adelolmo/hd-idle/io"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+const soakUsage = "usage: hd-idle soak --device <disk> --cycles <count> [-c <command_type>] [--settle <time>]"
+
+/*
+hd-idle soak --device <disk> --cycles <count> [-c <command_type>] [--settle <time>]
+cycles a disk through spindown, standby check, spinup and awake check, and
+reports the timings and errors. Exits with 2 when a cycle failed.
+*/
+func soak(args []string) {
+	var disk string
+	cycles := 0
+	command := hdidle.SCSI
+	settle := 10 * time.Second
+	for index, arg := range args {
+		switch arg {
+		case "--device":
+			name, err := io.RealPath(args[index+1])
+			if err != nil {
+				fmt.Println(err.Error())
+				os.Exit(1)
+			}
+			disk = name
+		case "--cycles":
+			count, err := strconv.Atoi(args[index+1])
+			if err != nil || count < 1 {
+				fmt.Printf("Wrong cycles --cycles %s. Must be a positive number\n", args[index+1])
+				os.Exit(1)
+			}
+			cycles = count
+		case "-c":
+			command = args[index+1]
+			if command != hdidle.SCSI && command != hdidle.ATA {
+				fmt.Printf("Wrong command_type -c %s. Must be one of: scsi, ata\n", command)
+				os.Exit(1)
+			}
+		case "--settle":
+			d, err := hdidle.ParseDuration(args[index+1])
+			if err != nil {
+				fmt.Printf("Wrong settle time --settle %s. Must be a time, e.g. 10 or 10s\n", args[index+1])
+				os.Exit(1)
+			}
+			settle = d
+		case "-h":
+			fmt.Println(soakUsage)
+			os.Exit(0)
+		}
+	}
+	if len(disk) == 0 || cycles == 0 {
+		fmt.Println("Missing --device or --cycles. " + soakUsage)
+		os.Exit(1)
+	}
+
+	stop := make(chan struct{})
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		<-signals
+		close(stop)
+	}()
+
+	fmt.Printf("soak disk=%s commandType=%s cycles=%d settle=%v\n", disk, command, cycles, settle)
+	report := hdidle.Soak(disk, command, cycles, settle, stop, os.Stdout)
+	report.Write(os.Stdout)
+	if report.Failed() > 0 {
+		os.Exit(2)
+	}
+}
@@ -0,0 +1,191 @@
+// hd-idle - spin down idle hard disks
+// Copyright (C) 2018  Andoni del Olmo
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package sysfs
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+)
+
+// Root is the mount point of sysfs. It is a variable so tests can point it
+// to a fake tree.
+var Root = "/sys"
+
+// DisksForPath returns the whole disks (e.g. sda) backing the filesystem
+// that holds path. The path does not need to exist, the closest existing
+// parent directory is used instead. Partitions and device mapper volumes
+// are walked down to the disks underneath them.
+func DisksForPath(path string) ([]string, error) {
+	var stat syscall.Stat_t
+	for {
+		err := syscall.Stat(path, &stat)
+		if err == nil {
+			break
+		}
+		parent := filepath.Dir(path)
+		if parent == path {
+			return nil, fmt.Errorf("cannot stat %s: %s", path, err)
+		}
+		path = parent
+	}
+	return DisksForDevice(major(uint64(stat.Dev)), minor(uint64(stat.Dev)))
+}
+
+// DisksForDevice returns the whole disks behind the block device with the
+// given major and minor numbers.
+func DisksForDevice(major, minor uint32) ([]string, error) {
+	link := filepath.Join(Root, "dev", "block", fmt.Sprintf("%d:%d", major, minor))
+	dir, err := filepath.EvalSymlinks(link)
+	if err != nil {
+		return nil, fmt.Errorf("no block device %d:%d", major, minor)
+	}
+	return disksForDir(dir), nil
+}
+
+// DisksForNode returns the whole disks behind the block device node, e.g.
+// /dev/sda2 or /dev/mapper/root.
+func DisksForNode(node string) ([]string, error) {
+	var stat syscall.Stat_t
+	if err := syscall.Stat(node, &stat); err != nil {
+		return nil, fmt.Errorf("cannot stat %s: %s", node, err)
+	}
+	if stat.Mode&syscall.S_IFMT != syscall.S_IFBLK {
+		return nil, fmt.Errorf("%s is not a block device", node)
+	}
+	return DisksForDevice(major(uint64(stat.Rdev)), minor(uint64(stat.Rdev)))
+}
+
+// DisksBehind returns the whole disks holding the data of a block device by
+// name, e.g. the disk a loop device's backing file lives on or the disks
+// under a device mapper device.
+func DisksBehind(name string) ([]string, error) {
+	dir, err := filepath.EvalSymlinks(filepath.Join(Root, "block", name))
+	if err != nil {
+		return nil, fmt.Errorf("no block device %s", name)
+	}
+	return disksForDir(dir), nil
+}
+
+// DevicesOn returns the block devices a process can open to reach the data
+// of a disk: the disk itself, its partitions and the device mapper, md or
+// loop devices holding them, e.g. [sdb sdb1 dm-0].
+func DevicesOn(disk string) ([]string, error) {
+	dir, err := filepath.EvalSymlinks(filepath.Join(Root, "block", disk))
+	if err != nil {
+		return nil, fmt.Errorf("no block device %s", disk)
+	}
+	return devicesOnDir(dir), nil
+}
+
+// WholeDisk returns the disk a partition is on, e.g. sdb for sdb1 or nvme0n1
+// for nvme0n1p1, or the device itself when it is no partition.
+func WholeDisk(name string) (string, error) {
+	dir, err := filepath.EvalSymlinks(filepath.Join(Root, "class", "block", name))
+	if err != nil {
+		return "", fmt.Errorf("no block device %s", name)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "partition")); err == nil {
+		return filepath.Base(filepath.Dir(dir)), nil
+	}
+	return name, nil
+}
+
+// DeviceMapperName returns the name of a device mapper device by its kernel
+// name, e.g. vg0-data for dm-0.
+func DeviceMapperName(name string) (string, error) {
+	b, err := ioutil.ReadFile(filepath.Join(Root, "block", name, "dm", "name"))
+	if err != nil {
+		return "", fmt.Errorf("no device mapper device %s", name)
+	}
+	return strings.TrimSpace(string(b)), nil
+}
+
+func devicesOnDir(dir string) []string {
+	devices := []string{filepath.Base(dir)}
+	entries, _ := ioutil.ReadDir(dir)
+	for _, entry := range entries {
+		if _, err := os.Stat(filepath.Join(dir, entry.Name(), "partition")); err == nil {
+			devices = appendUnique(devices, devicesOnDir(filepath.Join(dir, entry.Name()))...)
+		}
+	}
+	holders, _ := ioutil.ReadDir(filepath.Join(dir, "holders"))
+	for _, holder := range holders {
+		holderDir, err := filepath.EvalSymlinks(filepath.Join(dir, "holders", holder.Name()))
+		if err != nil {
+			continue
+		}
+		devices = appendUnique(devices, devicesOnDir(holderDir)...)
+	}
+	return devices
+}
+
+func disksForDir(dir string) []string {
+	if b, err := ioutil.ReadFile(filepath.Join(dir, "loop", "backing_file")); err == nil {
+		/* a disk image, e.g. of a virtual machine */
+		disks, err := DisksForPath(strings.TrimSpace(string(b)))
+		if err != nil {
+			return nil
+		}
+		return disks
+	}
+	slaves, err := ioutil.ReadDir(filepath.Join(dir, "slaves"))
+	if err == nil && len(slaves) > 0 {
+		var disks []string
+		for _, slave := range slaves {
+			slaveDir, err := filepath.EvalSymlinks(filepath.Join(dir, "slaves", slave.Name()))
+			if err != nil {
+				continue
+			}
+			disks = appendUnique(disks, disksForDir(slaveDir)...)
+		}
+		return disks
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, "partition")); err == nil {
+		return []string{filepath.Base(filepath.Dir(dir))}
+	}
+	return []string{filepath.Base(dir)}
+}
+
+func appendUnique(list []string, names ...string) []string {
+	for _, name := range names {
+		found := false
+		for _, n := range list {
+			if n == name {
+				found = true
+				break
+			}
+		}
+		if !found {
+			list = append(list, name)
+		}
+	}
+	return list
+}
+
+/* same encoding as glibc's gnu_dev_major and gnu_dev_minor */
+func major(dev uint64) uint32 {
+	return uint32(((dev >> 8) & 0xfff) | ((dev >> 32) & 0xfffff000))
+}
+
+func minor(dev uint64) uint32 {
+	return uint32((dev & 0xff) | ((dev >> 12) & 0xffffff00))
+}
@@ -0,0 +1,461 @@
+// hd-idle - spin down idle hard disks
+// Copyright (C) 2018  Andoni del Olmo
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package sysfs
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"syscall"
+	"testing"
+)
+
+func fakeSysfs(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "sysfs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	Root = dir
+
+	mkdir := func(path string) {
+		if err := os.MkdirAll(filepath.Join(dir, path), os.ModePerm); err != nil {
+			t.Fatal(err)
+		}
+	}
+	touch := func(path, content string) {
+		if err := ioutil.WriteFile(filepath.Join(dir, path), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	link := func(target, name string) {
+		if err := os.Symlink(filepath.Join(dir, target), filepath.Join(dir, name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	mkdir("dev/block")
+	mkdir("devices/pci0000:00/host0/block/sda/sda1")
+	touch("devices/pci0000:00/host0/block/sda/sda1/partition", "1")
+	mkdir("devices/pci0000:00/host1/block/sdb/sdb1")
+	touch("devices/pci0000:00/host1/block/sdb/sdb1/partition", "1")
+	mkdir("devices/pci0000:00/host2/block/sdc")
+	mkdir("devices/virtual/block/dm-0/slaves")
+	link("devices/pci0000:00/host0/block/sda/sda1", "devices/virtual/block/dm-0/slaves/sda1")
+	link("devices/pci0000:00/host1/block/sdb/sdb1", "devices/virtual/block/dm-0/slaves/sdb1")
+	mkdir("devices/virtual/block/dm-0/dm")
+	touch("devices/virtual/block/dm-0/dm/name", "vg0-data\n")
+	mkdir("devices/pci0000:00/host1/block/sdb/sdb1/holders")
+	link("devices/virtual/block/dm-0", "devices/pci0000:00/host1/block/sdb/sdb1/holders/dm-0")
+
+	link("devices/pci0000:00/host0/block/sda/sda1", "dev/block/8:1")
+	link("devices/pci0000:00/host2/block/sdc", "dev/block/8:32")
+	link("devices/virtual/block/dm-0", "dev/block/253:0")
+
+	mkdir("class/block")
+	link("devices/pci0000:00/host1/block/sdb", "class/block/sdb")
+	link("devices/pci0000:00/host1/block/sdb/sdb1", "class/block/sdb1")
+
+	mkdir("block")
+	link("devices/pci0000:00/host2/block/sdc", "block/sdc")
+	link("devices/pci0000:00/host1/block/sdb", "block/sdb")
+	link("devices/virtual/block/dm-0", "block/dm-0")
+	mkdir("devices/pci0000:00/host2/block/sdc/queue")
+	touch("devices/pci0000:00/host2/block/sdc/queue/read_ahead_kb", "128\n")
+	touch("devices/pci0000:00/host2/block/sdc/queue/rotational", "1\n")
+	mkdir("devices/pci0000:00/host1/block/sdb/queue")
+	touch("devices/pci0000:00/host1/block/sdb/queue/rotational", "0\n")
+	mkdir("devices/pci0000:00/host2/block/sdc/device/power")
+	touch("devices/pci0000:00/host2/block/sdc/device/power/control", "on\n")
+	touch("devices/pci0000:00/host2/block/sdc/device/power/autosuspend_delay_ms", "-1\n")
+	mkdir("devices/pci0000:00/host2/enclosure/0:0:8:0/Slot 03")
+	link("devices/pci0000:00/host2/enclosure/0:0:8:0/Slot 03", "devices/pci0000:00/host2/block/sdc/device/enclosure_device:Slot 03")
+	touch("devices/pci0000:00/host2/enclosure/0:0:8:0/Slot 03/locate", "0\n")
+	mkdir("class/enclosure")
+	link("devices/pci0000:00/host2/enclosure/0:0:8:0", "class/enclosure/0:0:8:0")
+	mkdir("class/scsi_host/host2")
+	touch("class/scsi_host/host2/link_power_management_policy", "max_performance\n")
+
+	mkdir("devices/pci0000:00/0000:00:1f.2/ata1/host3/target3:0:0/3:0:0:0/block/sdd")
+	mkdir("devices/pci0000:00/0000:00:1f.2/power")
+	touch("devices/pci0000:00/0000:00:1f.2/power/control", "on\n")
+	link("devices/pci0000:00/0000:00:1f.2/ata1/host3/target3:0:0/3:0:0:0/block/sdd", "block/sdd")
+	touch("devices/pci0000:00/host2/block/sdc/device/wwid", "naa.5000C500A1B2C3D4\n")
+	touch("devices/pci0000:00/host2/block/sdc/device/vpd_pg80", "\x00\x80\x00\x0fWD-WCC4E1234567")
+	mkdir("devices/pci0000:00/0000:00:1f.2/ata1/host3/target3:0:0/3:0:0:0/block/sdd/device")
+	touch("devices/pci0000:00/0000:00:1f.2/ata1/host3/target3:0:0/3:0:0:0/block/sdd/device/serial", "S4EVNX0M123456\n")
+	mkdir("bus/pci/devices")
+	link("devices/pci0000:00/0000:00:1f.2", "bus/pci/devices/0000:00:1f.2")
+	mkdir("devices/pci0000:00/0000:00:14.0/usb2/2-1/2-1:1.0/host4/target4:0:0/4:0:0:0/block/sde")
+	touch("devices/pci0000:00/0000:00:14.0/usb2/2-1/idVendor", "152d")
+	link("devices/pci0000:00/0000:00:14.0/usb2/2-1/2-1:1.0/host4/target4:0:0/4:0:0:0/block/sde", "block/sde")
+	touch("devices/pci0000:00/0000:00:14.0/usb2/2-1/2-1:1.0/host4/target4:0:0/4:0:0:0/block/sde/removable", "1\n")
+	touch("devices/pci0000:00/host2/block/sdc/removable", "0\n")
+	mkdir("bus/mmc")
+	mkdir("devices/platform/mmc0/mmc_host/mmc0/mmc0:0001/block/mmcblk0")
+	link("bus/mmc", "devices/platform/mmc0/mmc_host/mmc0/mmc0:0001/subsystem")
+	link("devices/platform/mmc0/mmc_host/mmc0/mmc0:0001", "devices/platform/mmc0/mmc_host/mmc0/mmc0:0001/block/mmcblk0/device")
+	link("devices/platform/mmc0/mmc_host/mmc0/mmc0:0001/block/mmcblk0", "block/mmcblk0")
+	return dir
+}
+
+func TestDisksForDevice(t *testing.T) {
+	dir := fakeSysfs(t)
+	defer os.RemoveAll(dir)
+
+	tests := []struct {
+		name  string
+		major uint32
+		minor uint32
+		want  []string
+	}{
+		{name: "partition", major: 8, minor: 1, want: []string{"sda"}},
+		{name: "whole disk", major: 8, minor: 32, want: []string{"sdc"}},
+		{name: "device mapper", major: 253, minor: 0, want: []string{"sda", "sdb"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := DisksForDevice(tt.major, tt.minor)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("DisksForDevice() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	if _, err := DisksForDevice(8, 99); err == nil {
+		t.Errorf("expected error for unknown device")
+	}
+}
+
+func TestUsbPortDisableFile(t *testing.T) {
+	Root = "/sys"
+	tests := []struct {
+		port string
+		want string
+	}{
+		{port: "1-1.2", want: "/sys/bus/usb/devices/1-1/1-1:1.0/1-1-port2/disable"},
+		{port: "2-1.4.1", want: "/sys/bus/usb/devices/2-1.4/2-1.4:1.0/2-1.4-port1/disable"},
+		{port: "1-3", want: "/sys/bus/usb/devices/usb1/1-0:1.0/usb1-port3/disable"},
+	}
+	for _, tt := range tests {
+		got, err := usbPortDisableFile(tt.port)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tt.want {
+			t.Errorf("usbPortDisableFile(%s) = %v, want %v", tt.port, got, tt.want)
+		}
+	}
+}
+
+func TestLinkPowerPolicy(t *testing.T) {
+	dir := fakeSysfs(t)
+	defer os.RemoveAll(dir)
+
+	host, err := ScsiHost("sdc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if host != "host2" {
+		t.Fatalf("Expected host2 but found %s", host)
+	}
+	if _, err := ScsiHost("dm-0"); err == nil {
+		t.Fatal("Expected an error for a disk without scsi host")
+	}
+
+	policy, err := LinkPowerPolicy(host)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if policy != "max_performance" {
+		t.Fatalf("Expected max_performance but found %s", policy)
+	}
+	if err := SetLinkPowerPolicy(host, "min_power"); err != nil {
+		t.Fatal(err)
+	}
+	if policy, _ = LinkPowerPolicy(host); policy != "min_power" {
+		t.Fatalf("Expected min_power but found %s", policy)
+	}
+}
+
+func TestRuntimePm(t *testing.T) {
+	dir := fakeSysfs(t)
+	defer os.RemoveAll(dir)
+
+	address, err := PciController("sdd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if address != "0000:00:1f.2" {
+		t.Fatalf("Expected 0000:00:1f.2 but found %s", address)
+	}
+	if _, err := PciController("sde"); err == nil {
+		t.Fatal("Expected an error for a usb disk")
+	}
+
+	if control, _ := RuntimePm(address); control != "on" {
+		t.Fatalf("Expected on but found %s", control)
+	}
+	if err := SetRuntimePm(address, "auto"); err != nil {
+		t.Fatal(err)
+	}
+	if control, _ := RuntimePm(address); control != "auto" {
+		t.Fatalf("Expected auto but found %s", control)
+	}
+}
+
+func TestUsbHub(t *testing.T) {
+	dir := fakeSysfs(t)
+	defer os.RemoveAll(dir)
+
+	if hub, err := UsbHub("sde"); err != nil || hub != "usb2" {
+		t.Fatalf("Expected usb2 but found %s, %v", hub, err)
+	}
+	if _, err := UsbHub("sdd"); err == nil {
+		t.Fatal("Expected an error for a sata disk")
+	}
+	if hub, _, _ := splitUsbPort("2-1.4.1"); hub != "2-1.4" {
+		t.Fatalf("Expected 2-1.4 but found %s", hub)
+	}
+	for _, port := range []string{"../../../etc/x-1", "1-1.2/../3", "usb1", ""} {
+		if _, _, err := splitUsbPort(port); err == nil {
+			t.Fatalf("Expected an error for usb port %q", port)
+		}
+	}
+}
+
+func TestReadAhead(t *testing.T) {
+	dir := fakeSysfs(t)
+	defer os.RemoveAll(dir)
+
+	kb, err := ReadAhead("sdc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if kb != 128 {
+		t.Fatalf("Expected 128 but found %d", kb)
+	}
+	if err := SetReadAhead("sdc", 0); err != nil {
+		t.Fatal(err)
+	}
+	if kb, _ = ReadAhead("sdc"); kb != 0 {
+		t.Fatalf("Expected 0 but found %d", kb)
+	}
+	if _, err := ReadAhead("sdz"); err == nil {
+		t.Fatal("Expected an error for an unknown disk")
+	}
+}
+
+func TestRotational(t *testing.T) {
+	dir := fakeSysfs(t)
+	defer os.RemoveAll(dir)
+
+	if rotational, err := Rotational("sdc"); err != nil || !rotational {
+		t.Fatalf("Expected sdc rotational but found %t, %v", rotational, err)
+	}
+	if rotational, err := Rotational("sdb"); err != nil || rotational {
+		t.Fatalf("Expected sdb non-rotational but found %t, %v", rotational, err)
+	}
+	if _, err := Rotational("sdz"); err == nil {
+		t.Fatal("Expected an error for an unknown disk")
+	}
+}
+
+func TestRemovable(t *testing.T) {
+	dir := fakeSysfs(t)
+	defer os.RemoveAll(dir)
+
+	for disk, expected := range map[string]bool{"sde": true, "mmcblk0": true, "sdc": false, "sdz": false} {
+		if removable := Removable(disk); removable != expected {
+			t.Fatalf("Expected %s removable %t but found %t", disk, expected, removable)
+		}
+	}
+}
+
+func TestTransport(t *testing.T) {
+	dir := fakeSysfs(t)
+	defer os.RemoveAll(dir)
+
+	for disk, expected := range map[string]string{"sdc": "scsi", "sdd": "sata", "sde": "usb", "mmcblk0": "mmc", "sdz": ""} {
+		if transport := Transport(disk); transport != expected {
+			t.Fatalf("Expected %s transport %q but found %q", disk, expected, transport)
+		}
+	}
+}
+
+func TestDiskRuntimePm(t *testing.T) {
+	dir := fakeSysfs(t)
+	defer os.RemoveAll(dir)
+
+	control, delay, err := DiskRuntimePm("sdc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if control != "on" || delay != -1 {
+		t.Fatalf("Expected on/-1 but found %s/%d", control, delay)
+	}
+	if err := SetDiskRuntimePm("sdc", "auto", 600000); err != nil {
+		t.Fatal(err)
+	}
+	if control, delay, _ = DiskRuntimePm("sdc"); control != "auto" || delay != 600000 {
+		t.Fatalf("Expected auto/600000 but found %s/%d", control, delay)
+	}
+}
+
+func TestEnclosureSlot(t *testing.T) {
+	dir := fakeSysfs(t)
+	defer os.RemoveAll(dir)
+
+	slot, err := EnclosureSlot("sdc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if slot != "0:0:8:0/Slot 03" {
+		t.Fatalf("Expected 0:0:8:0/Slot 03 but found %s", slot)
+	}
+	if _, err := EnclosureSlot("sdd"); err == nil {
+		t.Fatal("Expected an error for a disk without enclosure")
+	}
+
+	if err := SetEnclosureLocate(slot, true); err != nil {
+		t.Fatal(err)
+	}
+	locate, _ := ioutil.ReadFile(filepath.Join(dir, "class/enclosure", slot, "locate"))
+	if string(locate) != "1" {
+		t.Fatalf("Expected the locate led on but found %s", locate)
+	}
+}
+
+func TestDiskWithSerial(t *testing.T) {
+	dir := fakeSysfs(t)
+	defer os.RemoveAll(dir)
+
+	for serial, want := range map[string]string{"WD-WCC4E1234567": "sdc", "S4EVNX0M123456": "sdd"} {
+		disk, err := DiskWithSerial(serial)
+		if err != nil || disk != want {
+			t.Fatalf("Expected %s for serial %s but found %s, %v", want, serial, disk, err)
+		}
+	}
+	if _, err := DiskWithSerial("WD-WCC4E7654321"); err == nil {
+		t.Fatal("Expected an error for an unknown serial number")
+	}
+}
+
+func TestDiskWithWwn(t *testing.T) {
+	dir := fakeSysfs(t)
+	defer os.RemoveAll(dir)
+
+	disk, err := DiskWithWwn("0x5000c500a1b2c3d4")
+	if err != nil || disk != "sdc" {
+		t.Fatalf("Expected sdc but found %s, %v", disk, err)
+	}
+	if _, err := DiskWithWwn("0x5000c500ffffffff"); err == nil {
+		t.Fatal("Expected an error for an unknown wwn")
+	}
+}
+
+func TestDisksBehind(t *testing.T) {
+	dir := fakeSysfs(t)
+	defer os.RemoveAll(dir)
+
+	/* a disk image in the fake tree, said to live on sdc */
+	image := filepath.Join(dir, "vm.img")
+	if err := ioutil.WriteFile(image, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	var stat syscall.Stat_t
+	if err := syscall.Stat(image, &stat); err != nil {
+		t.Fatal(err)
+	}
+	dev := fmt.Sprintf("%d:%d", major(uint64(stat.Dev)), minor(uint64(stat.Dev)))
+	if err := os.Symlink(filepath.Join(dir, "devices/pci0000:00/host2/block/sdc"), filepath.Join(dir, "dev/block", dev)); err != nil {
+		t.Fatal(err)
+	}
+	loop := filepath.Join(dir, "devices/virtual/block/loop0")
+	if err := os.MkdirAll(filepath.Join(loop, "loop"), os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(loop, "loop", "backing_file"), []byte(image+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(loop, filepath.Join(dir, "block", "loop0")); err != nil {
+		t.Fatal(err)
+	}
+
+	if disks, err := DisksBehind("loop0"); err != nil || !reflect.DeepEqual(disks, []string{"sdc"}) {
+		t.Fatalf("Expected the image on sdc but found %v, %v", disks, err)
+	}
+	if disks, err := DisksBehind("dm-0"); err != nil || !reflect.DeepEqual(disks, []string{"sda", "sdb"}) {
+		t.Fatalf("Expected dm-0 on sda and sdb but found %v, %v", disks, err)
+	}
+	if _, err := DisksBehind("loop9"); err == nil {
+		t.Fatal("Expected an error for an unknown device")
+	}
+}
+
+func TestDevicesOn(t *testing.T) {
+	dir := fakeSysfs(t)
+	defer os.RemoveAll(dir)
+
+	devices, err := DevicesOn("sdb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(devices, []string{"sdb", "sdb1", "dm-0"}) {
+		t.Fatalf("Expected [sdb sdb1 dm-0] but found %v", devices)
+	}
+	devices, err = DevicesOn("sdc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(devices, []string{"sdc"}) {
+		t.Fatalf("Expected [sdc] but found %v", devices)
+	}
+	if _, err := DevicesOn("sdz"); err == nil {
+		t.Fatal("Expected an error for an unknown disk")
+	}
+}
+
+func TestDeviceMapperName(t *testing.T) {
+	dir := fakeSysfs(t)
+	defer os.RemoveAll(dir)
+
+	if name, err := DeviceMapperName("dm-0"); err != nil || name != "vg0-data" {
+		t.Fatalf("Expected vg0-data but found %s, %v", name, err)
+	}
+	if _, err := DeviceMapperName("sdb"); err == nil {
+		t.Fatal("Expected an error for a disk")
+	}
+}
+
+func TestWholeDisk(t *testing.T) {
+	dir := fakeSysfs(t)
+	defer os.RemoveAll(dir)
+
+	for name, expected := range map[string]string{"sdb1": "sdb", "sdb": "sdb"} {
+		if disk, err := WholeDisk(name); err != nil || disk != expected {
+			t.Errorf("Expected %s for %s but found %s, %v", expected, name, disk, err)
+		}
+	}
+	if _, err := WholeDisk("sdz1"); err == nil {
+		t.Error("Expected an error for an unknown partition")
+	}
+}
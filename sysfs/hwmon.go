@@ -0,0 +1,50 @@
+// hd-idle - spin down idle hard disks
+// Copyright (C) 2018  Andoni del Olmo
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package sysfs
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// TemperatureInput returns the hwmon temperature file the drivetemp kernel
+// module registers for the disk.
+func TemperatureInput(disk string) (string, error) {
+	matches, _ := filepath.Glob(filepath.Join(Root, "block", disk, "device", "hwmon", "hwmon*", "temp1_input"))
+	if len(matches) == 0 {
+		return "", fmt.Errorf("no hwmon temperature for %s. Is the drivetemp module loaded?", disk)
+	}
+	return matches[0], nil
+}
+
+// ReadTemperature reads a hwmon temperature file in degrees Celsius.
+// Beware that drivetemp queries the drive, so this must not be called while
+// the disk is spun down.
+func ReadTemperature(input string) (float64, error) {
+	b, err := ioutil.ReadFile(input)
+	if err != nil {
+		return 0, err
+	}
+	millidegrees, err := strconv.Atoi(strings.TrimSpace(string(b)))
+	if err != nil {
+		return 0, fmt.Errorf("wrong temperature in %s: %s", input, err)
+	}
+	return float64(millidegrees) / 1000, nil
+}
@@ -0,0 +1,67 @@
+// hd-idle - spin down idle hard disks
+// Copyright (C) 2018  Andoni del Olmo
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package sysfs
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestTemperature(t *testing.T) {
+	dir, err := ioutil.TempDir("", "hwmon")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer func(root string) { Root = root }(Root)
+	Root = dir
+
+	if _, err := TemperatureInput("sda"); err == nil {
+		t.Fatalf("Expected an error without drivetemp")
+	}
+
+	hwmon := filepath.Join(dir, "block", "sda", "device", "hwmon", "hwmon3")
+	if err := os.MkdirAll(hwmon, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(hwmon, "temp1_input"), []byte("37500\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	input, err := TemperatureInput("sda")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if input != filepath.Join(hwmon, "temp1_input") {
+		t.Fatalf("Expected %s but found %s", filepath.Join(hwmon, "temp1_input"), input)
+	}
+	celsius, err := ReadTemperature(input)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if celsius != 37.5 {
+		t.Fatalf("Expected 37.5 but found %v", celsius)
+	}
+
+	if err := ioutil.WriteFile(input, []byte("hot\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ReadTemperature(input); err == nil {
+		t.Fatalf("Expected an error for a wrong temperature")
+	}
+}
@@ -0,0 +1,169 @@
+// hd-idle - spin down idle hard disks
+// Copyright (C) 2018  Andoni del Olmo
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package sysfs
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+)
+
+// Serial returns the serial number of the disk from the unit serial number
+// VPD page the kernel read when the disk was attached, so the disk itself
+// is not queried. Devices without the page, e.g. NVMe disks, get it from
+// their serial attribute.
+func Serial(disk string) (string, error) {
+	b, err := ioutil.ReadFile(filepath.Join(Root, "block", disk, "device", "vpd_pg80"))
+	if err != nil || len(b) < 4 {
+		if serial := readAttribute(filepath.Join(Root, "block", disk, "device"), "serial"); len(serial) > 0 {
+			return serial, nil
+		}
+		return "", fmt.Errorf("no serial number for %s", disk)
+	}
+	length := int(b[3])
+	if 4+length > len(b) {
+		length = len(b) - 4
+	}
+	serial := strings.TrimSpace(string(b[4 : 4+length]))
+	if len(serial) == 0 {
+		return "", fmt.Errorf("no serial number for %s", disk)
+	}
+	return serial, nil
+}
+
+// DiskWithSerial returns the disk (e.g. sdb) with the given serial number,
+// whatever name the kernel gave it.
+func DiskWithSerial(serial string) (string, error) {
+	disks, err := ioutil.ReadDir(filepath.Join(Root, "block"))
+	if err != nil {
+		return "", err
+	}
+	for _, disk := range disks {
+		if found, err := Serial(disk.Name()); err == nil && found == serial {
+			return disk.Name(), nil
+		}
+	}
+	return "", fmt.Errorf("no disk with serial number %s", serial)
+}
+
+// Wwn returns the World Wide Name of the disk, e.g. 0x5000c500a1b2c3d4, from
+// the NAA designator the kernel read from its device identification VPD
+// page.
+func Wwn(disk string) (string, error) {
+	for _, dir := range []string{filepath.Join(Root, "block", disk, "device"), filepath.Join(Root, "block", disk)} {
+		wwid := readAttribute(dir, "wwid")
+		if strings.HasPrefix(wwid, "naa.") {
+			return "0x" + strings.ToLower(strings.TrimPrefix(wwid, "naa.")), nil
+		}
+	}
+	return "", fmt.Errorf("no wwn for %s", disk)
+}
+
+// DiskWithWwn returns the disk (e.g. sdb) with the given World Wide Name,
+// e.g. 0x5000c500a1b2c3d4.
+func DiskWithWwn(wwn string) (string, error) {
+	disks, err := ioutil.ReadDir(filepath.Join(Root, "block"))
+	if err != nil {
+		return "", err
+	}
+	for _, disk := range disks {
+		if found, err := Wwn(disk.Name()); err == nil && found == strings.ToLower(wwn) {
+			return disk.Name(), nil
+		}
+	}
+	return "", fmt.Errorf("no disk with wwn %s", wwn)
+}
+
+// Vendor returns the vendor the disk reports in its INQUIRY data.
+func Vendor(disk string) string {
+	return readAttribute(filepath.Join(Root, "block", disk, "device"), "vendor")
+}
+
+// Model returns the model the disk reports in its INQUIRY data.
+func Model(disk string) string {
+	return readAttribute(filepath.Join(Root, "block", disk, "device"), "model")
+}
+
+// Removable tells whether the disk is removable media, e.g. a USB stick, a
+// card in a card reader or an SD card on the mmc bus.
+func Removable(disk string) bool {
+	if readAttribute(filepath.Join(Root, "block", disk), "removable") == "1" {
+		return true
+	}
+	subsystem, err := filepath.EvalSymlinks(filepath.Join(Root, "block", disk, "device", "subsystem"))
+	return err == nil && filepath.Base(subsystem) == "mmc"
+}
+
+// Transport returns how the disk is attached, from the devices its sysfs
+// path goes through: usb, sata, nvme, mmc, virtio, or scsi for the rest
+// (SAS, Fibre Channel, iSCSI...).
+func Transport(disk string) string {
+	dir, err := filepath.EvalSymlinks(filepath.Join(Root, "block", disk))
+	if err != nil {
+		return ""
+	}
+	for _, transport := range []struct{ part, name string }{
+		{"/usb", "usb"},
+		{"/nvme", "nvme"},
+		{"/mmc_host/", "mmc"},
+		{"/virtio", "virtio"},
+		{"/ata", "sata"},
+	} {
+		if strings.Contains(dir, transport.part) {
+			return transport.name
+		}
+	}
+	return "scsi"
+}
+
+/* content of a sysfs attribute, empty if it cannot be read */
+func readAttribute(dir, name string) string {
+	b, err := ioutil.ReadFile(filepath.Join(dir, name))
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(b))
+}
+
+// EnclosureSlot returns the enclosure and slot the disk sits in, e.g.
+// 0:0:8:0/Slot 03, as reported by SES enclosures.
+func EnclosureSlot(disk string) (string, error) {
+	links, err := filepath.Glob(filepath.Join(Root, "block", disk, "device", "enclosure_device:*"))
+	if err != nil || len(links) == 0 {
+		return "", fmt.Errorf("disk %s is not in an enclosure", disk)
+	}
+	slot, err := filepath.EvalSymlinks(links[0])
+	if err != nil {
+		return "", fmt.Errorf("disk %s is not in an enclosure", disk)
+	}
+	return filepath.Join(filepath.Base(filepath.Dir(slot)), filepath.Base(slot)), nil
+}
+
+// SetEnclosureLocate turns the locate LED of an enclosure slot, as returned
+// by EnclosureSlot, on or off.
+func SetEnclosureLocate(slot string, on bool) error {
+	value := "0"
+	if on {
+		value = "1"
+	}
+	file := filepath.Join(Root, "class", "enclosure", slot, "locate")
+	if err := ioutil.WriteFile(file, []byte(value), 0644); err != nil {
+		return fmt.Errorf("cannot switch locate led of %s: %s", slot, err)
+	}
+	return nil
+}
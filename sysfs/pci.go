@@ -0,0 +1,68 @@
+// hd-idle - spin down idle hard disks
+// Copyright (C) 2018  Andoni del Olmo
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package sysfs
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+var pciAddressRegex = regexp.MustCompile(`^[0-9a-f]{4}:[0-9a-f]{2}:[0-9a-f]{2}\.[0-7]$`)
+
+// PciController returns the PCI address (e.g. 0000:00:1f.2) of the storage
+// controller the disk is attached to. Disks attached to USB have none, the
+// nearest PCI device is the USB host controller shared with other devices.
+func PciController(disk string) (string, error) {
+	dir, err := filepath.EvalSymlinks(filepath.Join(Root, "block", disk))
+	if err != nil {
+		return "", fmt.Errorf("cannot find disk %s in sysfs", disk)
+	}
+	for ; dir != Root && dir != "/"; dir = filepath.Dir(dir) {
+		if _, err := os.Stat(filepath.Join(dir, "idVendor")); err == nil {
+			return "", fmt.Errorf("disk %s is attached to usb", disk)
+		}
+		if name := filepath.Base(dir); pciAddressRegex.MatchString(name) {
+			return name, nil
+		}
+	}
+	return "", fmt.Errorf("disk %s is not attached to a pci controller", disk)
+}
+
+// RuntimePm returns the runtime power management control of the PCI
+// device: on (always powered) or auto (suspended when idle).
+func RuntimePm(address string) (string, error) {
+	data, err := ioutil.ReadFile(runtimePmFile(address))
+	if err != nil {
+		return "", fmt.Errorf("cannot read runtime pm of %s: %s", address, err)
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+func SetRuntimePm(address, control string) error {
+	if err := ioutil.WriteFile(runtimePmFile(address), []byte(control), 0644); err != nil {
+		return fmt.Errorf("cannot set runtime pm of %s to %s: %s", address, control, err)
+	}
+	return nil
+}
+
+func runtimePmFile(address string) string {
+	return filepath.Join(Root, "bus", "pci", "devices", address, "power", "control")
+}
@@ -0,0 +1,44 @@
+// hd-idle - spin down idle hard disks
+// Copyright (C) 2018  Andoni del Olmo
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package sysfs
+
+import (
+	"io/ioutil"
+	"path/filepath"
+)
+
+// OnBattery tells whether the machine runs on battery: it has a mains or USB
+// power supply and none of them is online, or else a battery discharging.
+// Machines without any power supply in sysfs run on mains.
+func OnBattery() bool {
+	supplies, _ := ioutil.ReadDir(filepath.Join(Root, "class", "power_supply"))
+	external, online, discharging := false, false, false
+	for _, supply := range supplies {
+		dir := filepath.Join(Root, "class", "power_supply", supply.Name())
+		switch readAttribute(dir, "type") {
+		case "Mains", "USB":
+			external = true
+			online = online || readAttribute(dir, "online") == "1"
+		case "Battery":
+			discharging = discharging || readAttribute(dir, "status") == "Discharging"
+		}
+	}
+	if external {
+		return !online
+	}
+	return discharging
+}
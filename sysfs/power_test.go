@@ -0,0 +1,62 @@
+// hd-idle - spin down idle hard disks
+// Copyright (C) 2018  Andoni del Olmo
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package sysfs
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestOnBattery(t *testing.T) {
+	dir, err := ioutil.TempDir("", "power")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer func(root string) { Root = root }(Root)
+	Root = dir
+
+	supply := func(name string, attributes map[string]string) {
+		supplyDir := filepath.Join(dir, "class", "power_supply", name)
+		if err := os.MkdirAll(supplyDir, 0755); err != nil {
+			t.Fatal(err)
+		}
+		for attribute, value := range attributes {
+			if err := ioutil.WriteFile(filepath.Join(supplyDir, attribute), []byte(value+"\n"), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	if OnBattery() {
+		t.Fatalf("Expected mains without power supplies")
+	}
+	supply("BAT0", map[string]string{"type": "Battery", "status": "Discharging"})
+	if !OnBattery() {
+		t.Fatalf("Expected battery with a discharging battery only")
+	}
+	supply("AC", map[string]string{"type": "Mains", "online": "1"})
+	if OnBattery() {
+		t.Fatalf("Expected mains with the adapter online")
+	}
+	supply("AC", map[string]string{"online": "0"})
+	if !OnBattery() {
+		t.Fatalf("Expected battery with the adapter offline")
+	}
+}
@@ -0,0 +1,71 @@
+// hd-idle - spin down idle hard disks
+// Copyright (C) 2018  Andoni del Olmo
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package sysfs
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// ReadAhead returns the read-ahead of the disk in KiB.
+func ReadAhead(disk string) (int, error) {
+	data, err := ioutil.ReadFile(readAheadFile(disk))
+	if err != nil {
+		return 0, fmt.Errorf("cannot read read-ahead of %s: %s", disk, err)
+	}
+	kb, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil {
+		return 0, fmt.Errorf("cannot read read-ahead of %s: %s", disk, err)
+	}
+	return kb, nil
+}
+
+// SetReadAhead sets the read-ahead of the disk in KiB.
+func SetReadAhead(disk string, kb int) error {
+	if err := ioutil.WriteFile(readAheadFile(disk), []byte(strconv.Itoa(kb)), 0644); err != nil {
+		return fmt.Errorf("cannot set read-ahead of %s to %d: %s", disk, kb, err)
+	}
+	return nil
+}
+
+func readAheadFile(disk string) string {
+	return filepath.Join(Root, "block", disk, "queue", "read_ahead_kb")
+}
+
+// Rotational tells whether the disk has spinning platters, false for SSDs
+// and NVMe drives.
+func Rotational(disk string) (bool, error) {
+	data, err := ioutil.ReadFile(filepath.Join(Root, "block", disk, "queue", "rotational"))
+	if err != nil {
+		return false, fmt.Errorf("cannot read rotational of %s: %s", disk, err)
+	}
+	return strings.TrimSpace(string(data)) != "0", nil
+}
+
+// Zoned returns the zoned model of the disk the kernel sees, host-aware or
+// host-managed for SMR drives exposing their zones, none for any other disk
+// including drive-managed SMR ones.
+func Zoned(disk string) string {
+	model := readAttribute(filepath.Join(Root, "block", disk, "queue"), "zoned")
+	if len(model) == 0 {
+		return "none"
+	}
+	return model
+}
@@ -0,0 +1,60 @@
+// hd-idle - spin down idle hard disks
+// Copyright (C) 2018  Andoni del Olmo
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package sysfs
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// DiskRuntimePm returns the runtime power management setting of the scsi
+// device of the disk (on or auto) and its autosuspend delay in milliseconds.
+func DiskRuntimePm(disk string) (string, int, error) {
+	control, err := ioutil.ReadFile(diskPowerFile(disk, "control"))
+	if err != nil {
+		return "", 0, fmt.Errorf("cannot read runtime power management of %s: %s", disk, err)
+	}
+	delay, err := ioutil.ReadFile(diskPowerFile(disk, "autosuspend_delay_ms"))
+	if err != nil {
+		return "", 0, fmt.Errorf("cannot read autosuspend delay of %s: %s", disk, err)
+	}
+	ms, err := strconv.Atoi(strings.TrimSpace(string(delay)))
+	if err != nil {
+		return "", 0, fmt.Errorf("cannot read autosuspend delay of %s: %s", disk, err)
+	}
+	return strings.TrimSpace(string(control)), ms, nil
+}
+
+// SetDiskRuntimePm sets the runtime power management of the scsi device of
+// the disk. With auto the kernel suspends the device after the autosuspend
+// delay without I/O.
+func SetDiskRuntimePm(disk, control string, delayMs int) error {
+	if err := ioutil.WriteFile(diskPowerFile(disk, "autosuspend_delay_ms"), []byte(strconv.Itoa(delayMs)), 0644); err != nil {
+		return fmt.Errorf("cannot set autosuspend delay of %s to %d: %s", disk, delayMs, err)
+	}
+	if err := ioutil.WriteFile(diskPowerFile(disk, "control"), []byte(control), 0644); err != nil {
+		return fmt.Errorf("cannot set runtime power management of %s to %s: %s", disk, control, err)
+	}
+	return nil
+}
+
+func diskPowerFile(disk, name string) string {
+	return filepath.Join(Root, "block", disk, "device", "power", name)
+}
@@ -0,0 +1,65 @@
+// hd-idle - spin down idle hard disks
+// Copyright (C) 2018  Andoni del Olmo
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package sysfs
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+var scsiHostRegex = regexp.MustCompile("^host[0-9]+$")
+
+// ScsiHost returns the SCSI host (e.g. host2) the disk is attached to. For
+// SATA disks on AHCI controllers it stands for the link of a single port.
+func ScsiHost(disk string) (string, error) {
+	dir, err := filepath.EvalSymlinks(filepath.Join(Root, "block", disk))
+	if err != nil {
+		return "", fmt.Errorf("cannot find disk %s in sysfs", disk)
+	}
+	for ; dir != Root && dir != "/"; dir = filepath.Dir(dir) {
+		if name := filepath.Base(dir); scsiHostRegex.MatchString(name) {
+			return name, nil
+		}
+	}
+	return "", fmt.Errorf("disk %s is not attached to a scsi host", disk)
+}
+
+// LinkPowerPolicy returns the SATA link power management policy of the
+// SCSI host, e.g. max_performance.
+func LinkPowerPolicy(host string) (string, error) {
+	data, err := ioutil.ReadFile(linkPowerPolicyFile(host))
+	if err != nil {
+		return "", fmt.Errorf("cannot read link power policy of %s: %s", host, err)
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+// SetLinkPowerPolicy sets the SATA link power management policy of the
+// SCSI host, e.g. min_power or med_power_with_dipm.
+func SetLinkPowerPolicy(host, policy string) error {
+	if err := ioutil.WriteFile(linkPowerPolicyFile(host), []byte(policy), 0644); err != nil {
+		return fmt.Errorf("cannot set link power policy of %s to %s: %s", host, policy, err)
+	}
+	return nil
+}
+
+func linkPowerPolicyFile(host string) string {
+	return filepath.Join(Root, "class", "scsi_host", host, "link_power_management_policy")
+}
@@ -0,0 +1,125 @@
+// hd-idle - spin down idle hard disks
+// Copyright (C) 2018  Andoni del Olmo
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package sysfs
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+/* bus-port, then the port on each hub down the chain, e.g. 1-1.2 */
+var usbPortPattern = regexp.MustCompile(`^[0-9]+-[0-9]+(\.[0-9]+)*$`)
+
+// UsbPort returns the USB device (e.g. 2-1.3) the disk is attached to.
+func UsbPort(disk string) (string, error) {
+	dir, err := usbDeviceDir(disk)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Base(dir), nil
+}
+
+// UsbID returns the vendor:product id of the USB bridge of the disk.
+func UsbID(disk string) (string, error) {
+	dir, err := usbDeviceDir(disk)
+	if err != nil {
+		return "", err
+	}
+	return readAttribute(dir, "idVendor") + ":" + readAttribute(dir, "idProduct"), nil
+}
+
+func usbDeviceDir(disk string) (string, error) {
+	dir, err := filepath.EvalSymlinks(filepath.Join(Root, "block", disk))
+	if err != nil {
+		return "", fmt.Errorf("cannot find disk %s in sysfs", disk)
+	}
+	for ; dir != Root && dir != "/"; dir = filepath.Dir(dir) {
+		name := filepath.Base(dir)
+		if strings.Contains(name, ":") {
+			continue
+		}
+		if _, err := os.Stat(filepath.Join(dir, "idVendor")); err == nil {
+			return dir, nil
+		}
+	}
+	return "", fmt.Errorf("disk %s is not attached to usb", disk)
+}
+
+// UsbHub returns the USB hub (e.g. 2-1, or usb2 for the root hub of bus 2)
+// the disk's USB device is plugged in. Disks on the same hub share its
+// bandwidth and its transaction translator.
+func UsbHub(disk string) (string, error) {
+	port, err := UsbPort(disk)
+	if err != nil {
+		return "", err
+	}
+	hub, _, err := splitUsbPort(port)
+	return hub, err
+}
+
+// SetUsbPortPower switches the power of the hub port the given USB device
+// is plugged in. It only has an effect on hubs with per-port power switching.
+func SetUsbPortPower(port string, on bool) error {
+	disable, err := usbPortDisableFile(port)
+	if err != nil {
+		return err
+	}
+	value := "1"
+	if on {
+		value = "0"
+	}
+	if err := ioutil.WriteFile(disable, []byte(value), 0644); err != nil {
+		return fmt.Errorf("cannot switch power of usb port %s: %s", port, err)
+	}
+	return nil
+}
+
+/*
+ * Port 1-1.2 is port 2 on hub 1-1 and port 1-1 is port 1 on the root hub of
+ * bus 1, usb1. The hub interface holds one directory per port:
+ *   /sys/bus/usb/devices/1-1/1-1:1.0/1-1-port2/disable
+ *   /sys/bus/usb/devices/usb1/1-0:1.0/usb1-port1/disable
+ */
+func usbPortDisableFile(port string) (string, error) {
+	hub, number, err := splitUsbPort(port)
+	if err != nil {
+		return "", err
+	}
+	iface := hub + ":1.0"
+	if strings.HasPrefix(hub, "usb") {
+		iface = strings.TrimPrefix(hub, "usb") + "-0:1.0"
+	}
+	return filepath.Join(Root, "bus", "usb", "devices", hub, iface, hub+"-port"+number, "disable"), nil
+}
+
+/* the hub and the number of the port on it */
+func splitUsbPort(port string) (string, string, error) {
+	if !usbPortPattern.MatchString(port) {
+		return "", "", fmt.Errorf("wrong usb port %s", port)
+	}
+	if i := strings.LastIndex(port, "."); i >= 0 {
+		return port[:i], port[i+1:], nil
+	}
+	if i := strings.Index(port, "-"); i >= 0 {
+		return "usb" + port[:i], port[i+1:], nil
+	}
+	return "", "", fmt.Errorf("wrong usb port %s", port)
+}
@@ -0,0 +1,126 @@
+// hd-idle - spin down idle hard disks
+// Copyright (C) 2018  Andoni del Olmo
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+// Package timers reads the systemd timers of the system and when they last
+// triggered, without talking to systemd.
+package timers
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// UnitDirs are searched for timer units, the first one defining a timer wins.
+// They are variables so tests can point them somewhere else.
+var UnitDirs = []string{
+	"/etc/systemd/system",
+	"/run/systemd/system",
+	"/usr/local/lib/systemd/system",
+	"/lib/systemd/system",
+	"/usr/lib/systemd/system",
+}
+
+// StampDir holds the stamp files of persistent timers, touched by systemd
+// every time the timer triggers.
+var StampDir = "/var/lib/systemd/timers"
+
+type Timer struct {
+	Name        string // e.g. mlocate.timer
+	Description string
+	Unit        string   // unit started by the timer
+	OnCalendar  []string // calendar schedules
+	// LastTrigger is zero when unknown, that is for timers that are not
+	// persistent or never ran.
+	LastTrigger time.Time
+}
+
+// Read returns the timers of the system sorted by name. Masked timers are
+// left out.
+func Read() ([]Timer, error) {
+	seen := map[string]bool{}
+	var timers []Timer
+	for _, dir := range UnitDirs {
+		files, err := filepath.Glob(filepath.Join(dir, "*.timer"))
+		if err != nil {
+			return nil, err
+		}
+		for _, file := range files {
+			name := filepath.Base(file)
+			if seen[name] {
+				continue
+			}
+			seen[name] = true
+			if target, err := os.Readlink(file); err == nil && target == "/dev/null" {
+				continue
+			}
+			timer, err := readTimer(file)
+			if err != nil {
+				continue
+			}
+			if stamp, err := os.Stat(filepath.Join(StampDir, "stamp-"+name)); err == nil {
+				timer.LastTrigger = stamp.ModTime()
+			}
+			timers = append(timers, timer)
+		}
+	}
+	sort.Slice(timers, func(i, j int) bool { return timers[i].Name < timers[j].Name })
+	return timers, nil
+}
+
+func readTimer(file string) (Timer, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return Timer{}, err
+	}
+	defer f.Close()
+
+	name := filepath.Base(file)
+	timer := Timer{Name: name, Unit: strings.TrimSuffix(name, ".timer") + ".service"}
+	var section string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) == 0 || line[0] == '#' || line[0] == ';' {
+			continue
+		}
+		if line[0] == '[' {
+			section = strings.Trim(line, "[]")
+			continue
+		}
+		kv := strings.SplitN(line, "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		key, value := strings.TrimSpace(kv[0]), strings.TrimSpace(kv[1])
+		switch section + "." + key {
+		case "Unit.Description":
+			timer.Description = value
+		case "Timer.Unit":
+			timer.Unit = value
+		case "Timer.OnCalendar":
+			if len(value) == 0 {
+				timer.OnCalendar = nil // an empty assignment resets the list
+				continue
+			}
+			timer.OnCalendar = append(timer.OnCalendar, value)
+		}
+	}
+	return timer, scanner.Err()
+}
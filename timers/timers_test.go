@@ -0,0 +1,79 @@
+// hd-idle - spin down idle hard disks
+// Copyright (C) 2018  Andoni del Olmo
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package timers
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestRead(t *testing.T) {
+	dir, err := ioutil.TempDir("", "timers")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	etc, lib, stamps := filepath.Join(dir, "etc"), filepath.Join(dir, "lib"), filepath.Join(dir, "stamps")
+	for _, d := range []string{etc, lib, stamps} {
+		if err := os.MkdirAll(d, os.ModePerm); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write := func(path, content string) {
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(filepath.Join(lib, "mlocate.timer"), `[Unit]
+Description=Updates mlocate database every day
+
+[Timer]
+OnCalendar=daily
+AccuracySec=24h
+Persistent=true
+`)
+	write(filepath.Join(lib, "btrfs-scrub@.timer"), "[Timer]\nOnCalendar=monthly\n")
+	write(filepath.Join(lib, "fstrim.timer"), "[Timer]\nOnCalendar=weekly\n")
+	write(filepath.Join(etc, "fstrim.timer"), "[Timer]\nOnCalendar=weekly\nOnCalendar=\nOnCalendar=Sun 03:00\nUnit=trim.service\n")
+	if err := os.Symlink("/dev/null", filepath.Join(etc, "btrfs-scrub@.timer")); err != nil {
+		t.Fatal(err)
+	}
+	write(filepath.Join(stamps, "stamp-mlocate.timer"), "")
+	triggeredAt := time.Date(2020, 1, 2, 3, 4, 5, 0, time.Local)
+	if err := os.Chtimes(filepath.Join(stamps, "stamp-mlocate.timer"), triggeredAt, triggeredAt); err != nil {
+		t.Fatal(err)
+	}
+	UnitDirs = []string{etc, lib}
+	StampDir = stamps
+
+	timers, err := Read()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []Timer{
+		{Name: "fstrim.timer", Unit: "trim.service", OnCalendar: []string{"Sun 03:00"}},
+		{Name: "mlocate.timer", Description: "Updates mlocate database every day", Unit: "mlocate.service",
+			OnCalendar: []string{"daily"}, LastTrigger: triggeredAt},
+	}
+	if !reflect.DeepEqual(timers, expected) {
+		t.Fatalf("Expected %+v but found %+v", expected, timers)
+	}
+}
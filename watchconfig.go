@@ -0,0 +1,110 @@
+// hd-idle - spin down idle hard disks
+// Copyright (C) 2018  Andoni del Olmo
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"fmt"
+	"github.com/adelolmo/hd-idle/hdidle"
+	"github.com/adelolmo/hd-idle/io"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+/* changes come in bursts, e.g. a write and a rename, so a reload waits for a quiet moment */
+const configSettleTime = 2 * time.Second
+
+/*
+ * With --watch-config the configuration files are reloaded like on SIGHUP
+ * whenever they change: the --config files, the files they include and the
+ * .conf files of the --config-dir directories and of the directories of
+ * included files, for the globs. A configuration that doesn't load is
+ * reported and the running one kept, until the next change.
+ */
+func watchConfig(monitor *hdidle.Monitor, args []string) {
+	watcher, err := io.NewWatcher()
+	if err != nil {
+		fmt.Printf("Cannot watch the configuration files: %s\n", err)
+		return
+	}
+	files, dirs := watchedConfig(watcher, args)
+	settle := time.NewTimer(configSettleTime)
+	settle.Stop()
+	for {
+		select {
+		case path, ok := <-watcher.Changes:
+			if !ok {
+				fmt.Println("Stopped watching the configuration files")
+				return
+			}
+			if files[path] || (dirs[filepath.Dir(path)] && strings.HasSuffix(path, ".conf")) {
+				settle.Reset(configSettleTime)
+			}
+		case <-settle.C:
+			fmt.Println("Configuration files changed, reloading")
+			if err := reload(monitor, args); err != nil {
+				fmt.Printf("Cannot reload the configuration, keeping the running one: %s\n", err)
+			}
+			/* the includes may have changed */
+			files, dirs = watchedConfig(watcher, args)
+		}
+	}
+}
+
+/* the files and the directories of drop-ins to watch, their directories added to the watcher */
+func watchedConfig(watcher *io.Watcher, args []string) (map[string]bool, map[string]bool) {
+	files := map[string]bool{}
+	dirs := map[string]bool{}
+	for index, arg := range args {
+		switch arg {
+		case "--config":
+			files[filepath.Clean(args[index+1])] = true
+		case "--config-dir":
+			dirs[filepath.Clean(args[index+1])] = true
+		}
+	}
+	/* until a broken configuration is fixed, only the files given are known */
+	paths, _ := configFiles(args)
+	for _, path := range loadedConfigFiles(paths) {
+		path = filepath.Clean(path)
+		if !files[path] && !dirs[filepath.Dir(path)] {
+			dirs[filepath.Dir(path)] = true // included, maybe by a glob
+		}
+		files[path] = true
+	}
+	watched := map[string]bool{}
+	for path := range files {
+		watched[filepath.Dir(path)] = true
+	}
+	for dir := range dirs {
+		watched[dir] = true
+	}
+	for dir := range watched {
+		if err := watcher.Add(dir); err != nil {
+			fmt.Printf("Cannot watch %s: %s\n", dir, err)
+		}
+	}
+	return files, dirs
+}
+
+func loadedConfigFiles(paths []string) []string {
+	config, err := hdidle.LoadConfigFiles(paths)
+	if err != nil {
+		return paths
+	}
+	return config.Files
+}